@@ -5,6 +5,7 @@ import (
 	"github.com/diegostock12/kubeml/ml/pkg/ps"
 	"github.com/diegostock12/kubeml/ml/pkg/scheduler"
 	"github.com/diegostock12/kubeml/ml/pkg/train"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
 	"os"
 
 	"github.com/docopt/docopt-go"
@@ -49,9 +50,10 @@ func runParameterServer(logger *zap.Logger, port int, schedulerUrl string, stand
 	logger.Fatal("Parameter Server exited")
 }
 
+// Run a standalone job, the process exits once the job finishes
 func runJob(logger *zap.Logger, port int, jobId string) {
 	job := train.NewBasicJob(logger, jobId)
-	job.Serve(port)
+	os.Exit(job.Run(port))
 }
 
 // Main function that will run when starting a new pod on Kubernetes.
@@ -95,6 +97,11 @@ Options:
 		log.Fatalf("Could not build zap logger: %v", err)
 	}
 
+	// all the components share the names used in mongo
+	if err := util.ValidateMongoNames(); err != nil {
+		logger.Fatal("Invalid MongoDB configuration", zap.Error(err))
+	}
+
 	// for now set the default urls
 	schedulerUrl := "http://scheduler.kubeml"
 	psUrl := "http://parameter-server.kubeml"
@@ -4,6 +4,8 @@ go 1.12
 
 require (
 	github.com/RedisAI/redisai-go v1.0.1
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/aws/aws-sdk-go v1.36.33
 	github.com/coreos/go-systemd v0.0.0-20190719114852-fd7a80b32e1f // indirect
 	github.com/docopt/docopt-go v0.0.0-20180111231733-ee0de3bc6815
 	github.com/fission/fission v1.8.1-0.20210208054438-6f9bad3d05f8
@@ -23,6 +25,7 @@ require (
 	github.com/prometheus/client_golang v1.0.0
 	github.com/spf13/cobra v1.1.1
 	github.com/stretchr/objx v0.2.0 // indirect
+	github.com/vmihailenco/msgpack/v4 v4.3.12
 	go.mongodb.org/mongo-driver v1.4.3
 	go.uber.org/multierr v1.5.0 // indirect
 	go.uber.org/zap v1.10.0
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"fmt"
+	"math"
+)
+
+// Scales of the accuracy reported by the validation functions. The history,
+// the goal, the quiet margin and the milestones of a job are kept in percent,
+// so the accuracies of the functions that report a fraction are converted
+const (
+	AccuracyScaleFraction = "fraction"
+	AccuracyScalePercent  = "percent"
+)
+
+// Capabilities reported by the functions that declare the scale of their
+// accuracy, so the job does not have to detect it from the first validation
+const (
+	CapabilityAccuracyFraction = "accuracy_fraction"
+	CapabilityAccuracyPercent  = "accuracy_percent"
+)
+
+// NoGoalAccuracy is the default goal accuracy, which is never
+// reached before the last epoch and means the same in both scales
+const NoGoalAccuracy = 100
+
+// ValidateAccuracyScale checks that the scale is known, empty is allowed
+func ValidateAccuracyScale(scale string) error {
+	switch scale {
+	case "", AccuracyScaleFraction, AccuracyScalePercent:
+		return nil
+	default:
+		return fmt.Errorf("accuracy scale should be %s or %s, got \"%s\"",
+			AccuracyScaleFraction, AccuracyScalePercent, scale)
+	}
+}
+
+// DeclaredAccuracyScale returns the scale of the accuracy declared
+// in the capabilities of the function, empty if it did not declare it
+func (h *JobHistory) DeclaredAccuracyScale() string {
+	for _, c := range h.Capabilities {
+		switch c {
+		case CapabilityAccuracyFraction:
+			return AccuracyScaleFraction
+		case CapabilityAccuracyPercent:
+			return AccuracyScalePercent
+		}
+	}
+	return ""
+}
+
+// DetectAccuracyScale returns the scale of an accuracy reported by a function
+// that did not declare it. Accuracies over 1 are percentages. Accuracies up to 1
+// are also those of a model in percent that is right less than 1% of the time,
+// which is common before it is trained, e.g. 0.8% on a hundred classes, so they
+// are only taken as fractions of a trained model, once the validation of its
+// first epoch finished. An accuracy of 0 is the same in both scales. If the
+// accuracy does not tell the scale false is returned
+func DetectAccuracyScale(accuracy float64, trained bool) (string, bool) {
+	switch {
+	case accuracy > 1:
+		return AccuracyScalePercent, true
+	case accuracy > 0 && trained:
+		return AccuracyScaleFraction, true
+	default:
+		return "", false
+	}
+}
+
+// AccuracyToPercent converts an accuracy in the scale to percent. Accuracies
+// of an unknown scale are returned as they are
+func AccuracyToPercent(accuracy float64, scale string) float64 {
+	if scale == AccuracyScaleFraction {
+		return accuracy * 100
+	}
+	return accuracy
+}
+
+// ConvertAccuracies converts the accuracies of a history saved before the scale was
+// recorded, which were kept as reported, to percent once the scale of the function is
+// known, so a continued job does not append percentages to the fractions of the run
+// it continues. The scale of the accuracies kept is detected from the highest one,
+// as an accuracy of a trained model if trained is set, see DetectAccuracyScale. If
+// it is not the scale of the function they are left as they are and an error is
+// returned, since the history would mix both. Histories with a scale are already
+// in percent and are not changed
+func (h *JobHistory) ConvertAccuracies(scale string, trained bool) error {
+	if len(h.AccuracyScale) > 0 {
+		return nil
+	}
+
+	series := [][]float64{h.Accuracy, h.CanaryAccuracy}
+	highest := 0.0
+	for _, values := range series {
+		for _, v := range values {
+			highest = math.Max(highest, v)
+		}
+	}
+	if kept, ok := DetectAccuracyScale(highest, trained); ok && kept != scale {
+		return fmt.Errorf("the accuracies of the history are in the %s scale, but the function reports them in the %s scale",
+			kept, scale)
+	}
+
+	for _, values := range series {
+		for i := range values {
+			values[i] = AccuracyToPercent(values[i], scale)
+		}
+	}
+	return nil
+}
+
+// ValidateGoalScale checks the scale of the goal accuracy, and if it is set, that
+// the goal and the quiet margin are in its range, so a goal of 95 is not taken
+// as a fraction or one of 0.95 as a percentage. NoGoalAccuracy is always valid
+func (o TrainOptions) ValidateGoalScale() error {
+	if err := ValidateAccuracyScale(o.GoalScale); err != nil {
+		return err
+	}
+	return o.checkGoalScale(o.GoalScale)
+}
+
+// NormalizeGoal converts the goal accuracy and the quiet margin to percent, once
+// the scale of the accuracy of the function is known. They are in the scale of the
+// function unless the options set their own. It fails if the goal can't be in the
+// scale, e.g. a goal of 95 for a function that reports the accuracy as a fraction
+func (o *TrainOptions) NormalizeGoal(functionScale string) error {
+	scale := o.GoalScale
+	if len(scale) == 0 {
+		scale = functionScale
+	}
+	if err := o.checkGoalScale(scale); err != nil {
+		return err
+	}
+
+	if o.GoalAccuracy != NoGoalAccuracy {
+		o.GoalAccuracy = AccuracyToPercent(o.GoalAccuracy, scale)
+	}
+	o.QuietMargin = AccuracyToPercent(o.QuietMargin, scale)
+	o.GoalScale = AccuracyScalePercent
+	return nil
+}
+
+// checkGoalScale checks that the goal accuracy and the quiet margin are in the range
+// of the scale. A goal of 0.95 in percent is too low to be meant as a percentage
+func (o TrainOptions) checkGoalScale(scale string) error {
+	goal := o.GoalAccuracy
+	switch {
+	case goal == NoGoalAccuracy:
+	case scale == AccuracyScaleFraction && goal > 1:
+		return fmt.Errorf("goal accuracy %v is not a fraction, the accuracy is in the %s scale (0-1), use %v",
+			goal, scale, goal/100)
+	case scale == AccuracyScalePercent && goal > 0 && goal <= 1:
+		return fmt.Errorf("goal accuracy %v is too low for a percentage, the accuracy is in the %s scale (0-100), use %v",
+			goal, scale, goal*100)
+	}
+
+	if scale == AccuracyScaleFraction && o.QuietMargin > 1 {
+		return fmt.Errorf("quiet margin %v is not a fraction, the accuracy is in the %s scale (0-1)", o.QuietMargin, scale)
+	}
+	return nil
+}
+
+// AccuracyUnit describes the scale the accuracies of the history are kept in
+func (h *JobHistory) AccuracyUnit() string {
+	if len(h.AccuracyScale) == 0 {
+		return "as reported by the function, whose scale was not recorded"
+	}
+	return fmt.Sprintf("in percent, reported by the function in the %s scale", h.AccuracyScale)
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectAccuracyScale(t *testing.T) {
+	tests := []struct {
+		accuracy float64
+		trained  bool
+		scale    string
+		ok       bool
+	}{
+		{0, true, "", false},
+		{0, false, "", false},
+		{0.001, true, AccuracyScaleFraction, true},
+		{0.87, true, AccuracyScaleFraction, true},
+		{1, true, AccuracyScaleFraction, true},
+		{1.001, true, AccuracyScalePercent, true},
+		{87, true, AccuracyScalePercent, true},
+		{100, true, AccuracyScalePercent, true},
+		// under 1% in percent before the model is trained
+		{0.008, false, "", false},
+		{0.87, false, "", false},
+		{1, false, "", false},
+		{1.001, false, AccuracyScalePercent, true},
+		{87, false, AccuracyScalePercent, true},
+	}
+
+	for _, tt := range tests {
+		scale, ok := DetectAccuracyScale(tt.accuracy, tt.trained)
+		if scale != tt.scale || ok != tt.ok {
+			t.Errorf("got (%q, %v) for %v trained %v, want (%q, %v)", scale, ok, tt.accuracy, tt.trained, tt.scale, tt.ok)
+		}
+	}
+}
+
+func TestAccuracyToPercent(t *testing.T) {
+	tests := []struct {
+		accuracy float64
+		scale    string
+		want     float64
+	}{
+		{0, AccuracyScaleFraction, 0},
+		{0.87, AccuracyScaleFraction, 87},
+		{1, AccuracyScaleFraction, 100},
+		{0, AccuracyScalePercent, 0},
+		{1, AccuracyScalePercent, 1},
+		{87, AccuracyScalePercent, 87},
+		{0.87, "", 0.87},
+	}
+
+	for _, tt := range tests {
+		if got := AccuracyToPercent(tt.accuracy, tt.scale); got != tt.want {
+			t.Errorf("got %v for %v in scale %q, want %v", got, tt.accuracy, tt.scale, tt.want)
+		}
+	}
+}
+
+func TestNormalizeGoal(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      TrainOptions
+		scale     string
+		goal      float64
+		margin    float64
+		wantError bool
+	}{
+		{"fraction goal", TrainOptions{GoalAccuracy: 0.95, QuietMargin: 0.01}, AccuracyScaleFraction, 95, 1, false},
+		{"fraction goal of 1", TrainOptions{GoalAccuracy: 1}, AccuracyScaleFraction, 100, 0, false},
+		{"fraction goal of 0", TrainOptions{GoalAccuracy: 0}, AccuracyScaleFraction, 0, 0, false},
+		{"percent goal for fractions", TrainOptions{GoalAccuracy: 95}, AccuracyScaleFraction, 0, 0, true},
+		{"percent margin for fractions", TrainOptions{GoalAccuracy: 0.95, QuietMargin: 2}, AccuracyScaleFraction, 0, 0, true},
+		{"percent goal", TrainOptions{GoalAccuracy: 95, QuietMargin: 1}, AccuracyScalePercent, 95, 1, false},
+		{"percent goal just over 1", TrainOptions{GoalAccuracy: 1.5}, AccuracyScalePercent, 1.5, 0, false},
+		{"percent goal of 0", TrainOptions{GoalAccuracy: 0}, AccuracyScalePercent, 0, 0, false},
+		{"fraction goal for percentages", TrainOptions{GoalAccuracy: 0.95}, AccuracyScalePercent, 0, 0, true},
+		{"goal of 1 for percentages", TrainOptions{GoalAccuracy: 1}, AccuracyScalePercent, 0, 0, true},
+		{"no goal for fractions", TrainOptions{GoalAccuracy: NoGoalAccuracy}, AccuracyScaleFraction, NoGoalAccuracy, 0, false},
+		{"no goal for percentages", TrainOptions{GoalAccuracy: NoGoalAccuracy}, AccuracyScalePercent, NoGoalAccuracy, 0, false},
+		{
+			name:  "goal in its own scale",
+			opts:  TrainOptions{GoalAccuracy: 0.95, GoalScale: AccuracyScaleFraction},
+			scale: AccuracyScalePercent,
+			goal:  95,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			err := opts.NormalizeGoal(tt.scale)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("got no error, want one for goal %v in scale %s", tt.opts.GoalAccuracy, tt.scale)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.GoalAccuracy != tt.goal || opts.QuietMargin != tt.margin || opts.GoalScale != AccuracyScalePercent {
+				t.Errorf("got goal %v, margin %v and scale %s, want %v, %v and %s",
+					opts.GoalAccuracy, opts.QuietMargin, opts.GoalScale, tt.goal, tt.margin, AccuracyScalePercent)
+			}
+		})
+	}
+}
+
+func TestConvertAccuracies(t *testing.T) {
+	tests := []struct {
+		name      string
+		history   JobHistory
+		scale     string
+		trained   bool
+		accuracy  []float64
+		canary    []float64
+		wantError bool
+	}{
+		{
+			name:     "fractions",
+			history:  JobHistory{Accuracy: []float64{0, 0.5, 1}, CanaryAccuracy: []float64{0.25}},
+			scale:    AccuracyScaleFraction,
+			trained:  true,
+			accuracy: []float64{0, 50, 100},
+			canary:   []float64{25},
+		},
+		{
+			name:     "percentages",
+			history:  JobHistory{Accuracy: []float64{0, 0.5, 87}},
+			scale:    AccuracyScalePercent,
+			trained:  true,
+			accuracy: []float64{0, 0.5, 87},
+		},
+		{
+			name:     "only zeros",
+			history:  JobHistory{Accuracy: []float64{0, 0}},
+			scale:    AccuracyScaleFraction,
+			trained:  true,
+			accuracy: []float64{0, 0},
+		},
+		{
+			name:     "already in percent",
+			history:  JobHistory{Accuracy: []float64{0.5, 87}, AccuracyScale: AccuracyScaleFraction},
+			scale:    AccuracyScaleFraction,
+			trained:  true,
+			accuracy: []float64{0.5, 87},
+		},
+		{
+			name:      "percentages for fractions",
+			history:   JobHistory{Accuracy: []float64{0.5, 87}},
+			scale:     AccuracyScaleFraction,
+			trained:   true,
+			accuracy:  []float64{0.5, 87},
+			wantError: true,
+		},
+		{
+			name:      "fractions for percentages",
+			history:   JobHistory{Accuracy: []float64{0.5, 1}, CanaryAccuracy: []float64{0.75}},
+			scale:     AccuracyScalePercent,
+			trained:   true,
+			accuracy:  []float64{0.5, 1},
+			canary:    []float64{0.75},
+			wantError: true,
+		},
+		{
+			name:     "under 1% before training for percentages",
+			history:  JobHistory{Accuracy: []float64{0.5, 1}},
+			scale:    AccuracyScalePercent,
+			accuracy: []float64{0.5, 1},
+		},
+		{
+			name:     "under 1% before training for fractions",
+			history:  JobHistory{Accuracy: []float64{0.5, 1}},
+			scale:    AccuracyScaleFraction,
+			accuracy: []float64{50, 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.history
+			err := h.ConvertAccuracies(tt.scale, tt.trained)
+			if (err != nil) != tt.wantError {
+				t.Errorf("got error %v, want error %v", err, tt.wantError)
+			}
+
+			// the accuracies are left as they are if they can't be converted
+			if !reflect.DeepEqual(h.Accuracy, tt.accuracy) {
+				t.Errorf("got accuracies %v, want %v", h.Accuracy, tt.accuracy)
+			}
+			if !reflect.DeepEqual(h.CanaryAccuracy, tt.canary) {
+				t.Errorf("got canary accuracies %v, want %v", h.CanaryAccuracy, tt.canary)
+			}
+		})
+	}
+}
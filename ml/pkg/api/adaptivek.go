@@ -0,0 +1,93 @@
+package api
+
+import (
+	"fmt"
+	"math"
+)
+
+// Defaults of the adaptive K, see NextK
+const (
+	// DefaultKTargetOverhead is the fraction of the compute time
+	// of an epoch the merges are kept under
+	DefaultKTargetOverhead = 0.1
+
+	// DefaultMaxAdaptiveK is the largest K the job adapts to
+	DefaultMaxAdaptiveK = 128
+
+	// MaxKChange is the most K is multiplied or divided by from an epoch to the next
+	MaxKChange = 2
+)
+
+// KTargetOverheadOrDefault returns the merge overhead the adaptive K aims for
+func (o TrainOptions) KTargetOverheadOrDefault() float64 {
+	if o.KTargetOverhead > 0 {
+		return o.KTargetOverhead
+	}
+	return DefaultKTargetOverhead
+}
+
+// MaxKOrDefault returns the largest K the adaptive K can reach
+func (o TrainOptions) MaxKOrDefault() int {
+	if o.MaxK > 0 {
+		return o.MaxK
+	}
+	return DefaultMaxAdaptiveK
+}
+
+// ValidateAdaptiveK checks that the target overhead is a fraction and the bounds
+// contain the K of the job. K must sync every few steps to be adapted, so it
+// can't be -1, and the layer groups are left out since their Ks are multiples
+func (o TrainOptions) ValidateAdaptiveK() error {
+	if !o.AdaptiveK {
+		if o.KTargetOverhead != 0 || o.MaxK != 0 {
+			return fmt.Errorf("the target overhead and the max K are only used with the adaptive K")
+		}
+		return nil
+	}
+
+	switch {
+	case o.KTargetOverhead < 0 || o.KTargetOverhead >= 1:
+		return fmt.Errorf("the target merge overhead should be between 0 and 1, got %v", o.KTargetOverhead)
+	case o.MaxK < 0:
+		return fmt.Errorf("max K should not be negative, got %d", o.MaxK)
+	case o.K < 1:
+		return fmt.Errorf("the adaptive K needs a positive K to start from, got %d", o.K)
+	case o.K > o.MaxKOrDefault():
+		return fmt.Errorf("K %d is over the max K %d", o.K, o.MaxKOrDefault())
+	case len(o.LayerSyncOverrides) > 0:
+		return fmt.Errorf("the adaptive K can't be used with layer sync overrides")
+	}
+	return nil
+}
+
+// MergeOverhead returns the time spent merging as a fraction of the time
+// spent computing in an epoch, the rest of its elapsed time, and 0 if the
+// times are not known
+func MergeOverhead(elapsed, merging float64) float64 {
+	compute := elapsed - merging
+	if merging <= 0 || compute <= 0 {
+		return 0
+	}
+	return merging / compute
+}
+
+// NextK returns the K of the next epoch after one that ran with K k and had the
+// given merge overhead. Since every merge costs about the same, the overhead is
+// inversely proportional to K. K grows when the overhead is over the target and
+// shrinks when it is under half of it, aiming in both cases at three quarters of
+// the target, so the noise of the measures does not make it oscillate. K changes
+// at most by MaxKChange times in an epoch and stays between 1 and max
+func NextK(k int, overhead, target float64, max int) int {
+	if overhead <= 0 || target <= 0 || (overhead <= target && overhead >= target/2) {
+		return k
+	}
+
+	next := float64(k) * overhead / (0.75 * target)
+	next = math.Max(float64(k)/MaxKChange, math.Min(float64(k)*MaxKChange, next))
+	if overhead > target {
+		next = math.Ceil(next)
+	} else {
+		next = math.Floor(next)
+	}
+	return int(math.Max(1, math.Min(float64(max), next)))
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Administrative audit log
+const (
+	// AdminActorHeader carries the user doing an administrative action,
+	// set by the clients until there is authentication
+	AdminActorHeader = "X-Kubeml-Actor"
+
+	// RequestIdHeader identifies a request in the audit log, it is
+	// generated by the controller if the client does not set it
+	RequestIdHeader = "X-Request-Id"
+
+	// UnknownActor is recorded for the requests without an actor
+	UnknownActor = "unknown"
+
+	// DefaultAdminAuditLimit and MaxAdminAuditLimit are the default
+	// and maximum number of entries of a page of the audit log
+	DefaultAdminAuditLimit = 50
+	MaxAdminAuditLimit     = 500
+)
+
+type (
+	// AdminAuditEntry records an administrative action. The entries form a chain like
+	// the commits of git, each one holding the hash of the previous one, so an entry
+	// that is changed or removed breaks the hashes of all the entries after it
+	AdminAuditEntry struct {
+		Seq       int64     `json:"seq" bson:"_id"`
+		Time      time.Time `json:"time"`
+		Actor     string    `json:"actor"`
+		Action    string    `json:"action"`
+		Target    string    `json:"target,omitempty"`
+		RequestId string    `json:"request_id"`
+		Status    int       `json:"status"`
+		// Before and After are the values changed by the
+		// action encoded as JSON, when the action has them
+		Before string `json:"before,omitempty"`
+		After  string `json:"after,omitempty"`
+
+		PrevHash string `json:"prev_hash"`
+		Hash     string `json:"hash"`
+	}
+
+	// AdminAuditPage is a page of the audit log, Next is the
+	// sequence number to ask for the next page, 0 if it is the last
+	AdminAuditPage struct {
+		Entries []AdminAuditEntry `json:"entries"`
+		Next    int64             `json:"next,omitempty"`
+	}
+)
+
+// ComputeHash returns the hash of the entry, which
+// covers all its fields and the hash of the previous entry
+func (e *AdminAuditEntry) ComputeHash() string {
+	fields := []string{
+		fmt.Sprint(e.Seq),
+		e.Time.UTC().Format(time.RFC3339Nano),
+		e.Actor,
+		e.Action,
+		e.Target,
+		e.RequestId,
+		fmt.Sprint(e.Status),
+		e.Before,
+		e.After,
+		e.PrevHash,
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyAuditChain checks the hashes of consecutive entries sorted by their
+// sequence number, returning an error describing the first broken link
+func VerifyAuditChain(entries []AdminAuditEntry) error {
+	for i := range entries {
+		e := &entries[i]
+		if e.ComputeHash() != e.Hash {
+			return fmt.Errorf("entry %d does not match its hash", e.Seq)
+		}
+		if i == 0 {
+			continue
+		}
+
+		prev := &entries[i-1]
+		if e.Seq == prev.Seq+1 && e.PrevHash != prev.Hash {
+			return fmt.Errorf("entry %d does not follow entry %d", e.Seq, prev.Seq)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Formats the model of a job can be exported to. TorchScript artifacts
+// can be loaded with torch.jit.load without the class of the network
+const (
+	ExportFormatONNX        = "onnx"
+	ExportFormatTorchScript = "torchscript"
+)
+
+// Capabilities of a function, reported in HeaderCapabilities by the init
+// function so operations it does not implement are refused with a clear error
+const (
+	HeaderCapabilities = "X-Kubeml-Capabilities"
+
+	// CapabilityTrain is reported by every function that reports its capabilities,
+	// so they are never empty unless the function does not report them
+	CapabilityTrain = "train"
+	// CapabilityExport is reported by the functions that can export their model
+	CapabilityExport = "export"
+	// CapabilityTorchScript is reported by the functions that can script their
+	// model, which unlike the ONNX export does not need a sample input
+	CapabilityTorchScript = "torchscript"
+	// CapabilityAllReduce is reported by the functions that can average
+	// the layers assigned to them with the allreduce sync topology
+	CapabilityAllReduce = "allreduce"
+)
+
+// HeaderChecksum is the sha256 of an artifact downloaded from the storage service
+const HeaderChecksum = "X-Kubeml-Checksum"
+
+// Stages of the export of a model, reported to the client as JSON lines
+const (
+	ExportStageChecking  = "checking"
+	ExportStageExporting = "exporting"
+	ExportStageDone      = "done"
+	ExportStageFailed    = "failed"
+)
+
+type (
+	// ModelArtifact is the model of a job exported by its function
+	// to a format such as ONNX and kept in the storage service
+	ModelArtifact struct {
+		JobId   string    `json:"job_id"`
+		Format  string    `json:"format"`
+		Size    int64     `json:"size"`
+		SHA256  string    `json:"sha256"`
+		Created time.Time `json:"created"`
+	}
+
+	// ExportProgress is one of the steps of the export of a model. The last
+	// one is either done with the artifact or failed with the error
+	ExportProgress struct {
+		Stage    string         `json:"stage"`
+		Message  string         `json:"message,omitempty"`
+		Artifact *ModelArtifact `json:"artifact,omitempty"`
+		Error    string         `json:"error,omitempty"`
+	}
+)
+
+// ValidateExportFormat checks that the model can be exported to the format
+func ValidateExportFormat(format string) error {
+	switch format {
+	case ExportFormatONNX, ExportFormatTorchScript:
+		return nil
+	default:
+		return fmt.Errorf("export format should be %s or %s, got \"%s\"",
+			ExportFormatONNX, ExportFormatTorchScript, format)
+	}
+}
+
+// ExportCapability returns the capability the function needs to export to the format
+func ExportCapability(format string) string {
+	if format == ExportFormatTorchScript {
+		return CapabilityTorchScript
+	}
+	return CapabilityExport
+}
+
+// ExportExtension returns the extension of the files of the format
+func ExportExtension(format string) string {
+	if format == ExportFormatTorchScript {
+		return "pt"
+	}
+	return format
+}
+
+// ParseCapabilities returns the capabilities in the comma separated header
+func ParseCapabilities(header string) []string {
+	var capabilities []string
+	for _, c := range strings.Split(header, ",") {
+		if c = strings.TrimSpace(c); len(c) > 0 {
+			capabilities = append(capabilities, c)
+		}
+	}
+	return capabilities
+}
+
+// HasCapability returns whether the function of the job reported the capability.
+// Jobs saved before the capabilities were reported have none, so they are
+// taken as capable and the function itself refuses what it does not implement
+func (h *JobHistory) HasCapability(capability string) bool {
+	if h.Capabilities == nil {
+		return true
+	}
+	for _, c := range h.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package api
+
+import "math"
+
+// Schemes used to assign the training data to the functions
+const (
+	// AuditSchemeContiguous splits the shards of the dataset in contiguous
+	// ranges, the first functions taking one extra shard if they do not divide
+	// evenly, and each function trains on its range in order
+	AuditSchemeContiguous = "contiguous"
+
+	// AuditSchemeShuffled assigns the shards as the contiguous scheme, but each
+	// function shuffles the datapoints of every interval between merges with a
+	// torch generator seeded with the seed of the function for the epoch
+	AuditSchemeShuffled = "contiguous-shuffled"
+)
+
+type (
+	// DataAudit records the data each function trained on in every epoch of a job.
+	// The order of the datapoints is deterministic given the scheme and the seeds,
+	// so the indices are not stored
+	DataAudit struct {
+		JobId     string            `bson:"_id" json:"id"`
+		Dataset   string            `json:"dataset"`
+		Shards    int64             `json:"shards"`
+		ShardSize int               `json:"shard_size"`
+		Scheme    string            `json:"scheme"`
+		Seed      int64             `json:"seed,omitempty"`
+		Epochs    []EpochAssignment `json:"epochs"`
+	}
+
+	// EpochAssignment is the data assigned to the functions in an epoch
+	EpochAssignment struct {
+		Epoch       int                  `json:"epoch"`
+		Parallelism int                  `json:"parallelism"`
+		K           int                  `json:"k"`
+		BatchSize   int                  `json:"batch_size"`
+		Functions   []FunctionAssignment `json:"functions"`
+	}
+
+	// FunctionAssignment is the range of shards [FirstShard, EndShard) a function
+	// trained on in an epoch, along with the seed it shuffled them with
+	FunctionAssignment struct {
+		FuncId     int   `json:"func_id"`
+		FirstShard int64 `json:"first_shard"`
+		EndShard   int64 `json:"end_shard"`
+		Seed       int64 `json:"seed,omitempty"`
+		// BatchSize is the batch of the function if the
+		// job balances the data by capacity
+		BatchSize int `json:"batch_size,omitempty"`
+	}
+)
+
+// SplitShards returns the shards assigned to each of the functions, mirroring
+// the split done by the functions: the shards are divided in contiguous ranges
+// and the first shards%parallelism functions take one more shard
+func SplitShards(shards int64, parallelism int) []FunctionAssignment {
+	if parallelism <= 0 {
+		return nil
+	}
+
+	n := int64(parallelism)
+	k, m := shards/n, shards%n
+	assignments := make([]FunctionAssignment, parallelism)
+	for i := int64(0); i < n; i++ {
+		assignments[i] = FunctionAssignment{
+			FuncId:     int(i),
+			FirstShard: i*k + min64(i, m),
+			EndShard:   (i+1)*k + min64(i+1, m),
+		}
+	}
+	return assignments
+}
+
+// SplitShardsByShares returns the shards assigned to each of the functions when the
+// job balances the data by capacity, mirroring the split done by the functions: the
+// shards are divided in contiguous ranges whose bounds are the cumulative shares
+// rounded to the nearest shard, halves to even
+func SplitShardsByShares(shards int64, shares []float64) []FunctionAssignment {
+	var total float64
+	for _, share := range shares {
+		total += share
+	}
+
+	var acc float64
+	var first int64
+	assignments := make([]FunctionAssignment, len(shares))
+	for i, share := range shares {
+		acc += share
+		end := int64(math.RoundToEven(float64(shards) * acc / total))
+		assignments[i] = FunctionAssignment{
+			FuncId:     i,
+			FirstShard: first,
+			EndShard:   end,
+		}
+		first = end
+	}
+	return assignments
+}
+
+// FunctionSeed returns the seed a function shuffles its data with in an epoch,
+// derived from the seed of the job so it is different for every epoch and function
+func FunctionSeed(seed int64, epoch, parallelism, funcId int) int64 {
+	if seed == 0 {
+		return 0
+	}
+	return seed + int64(epoch)*int64(parallelism) + int64(funcId)
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"fmt"
+	"math"
+)
+
+// MinFunctionBatchSize is the smallest batch a function trains with
+// when the batch of the functions is derived from the global batch
+const MinFunctionBatchSize = 8
+
+// FunctionBatchSize returns the batch each function trains with at the given parallelism.
+//
+// Without a global batch it is the batch size of the request. With one, it is the global
+// batch divided by the parallelism rounded to the nearest integer (halves round up), and
+// never under MinFunctionBatchSize. The effective global batch is then the batch of the
+// functions times the parallelism, which can differ slightly from the one requested
+func (r TrainRequest) FunctionBatchSize(parallelism int) int {
+	if r.Options.GlobalBatchSize <= 0 {
+		return r.BatchSize
+	}
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+
+	batch := (r.Options.GlobalBatchSize + parallelism/2) / parallelism
+	if batch < MinFunctionBatchSize {
+		batch = MinFunctionBatchSize
+	}
+	return batch
+}
+
+// CapacityShares returns the fraction of the data and of the global batch each of the
+// functions gets when the job balances them by capacity, proportional to the capacity
+// the function reported in the previous epoch. The functions without a capacity, like
+// the ones added when the parallelism grows, get the mean of the others, and the shares
+// are uniform if none of the functions reported one
+func CapacityShares(capacities map[int]float64, parallelism int) []float64 {
+	var sum float64
+	var known int
+	for id := 0; id < parallelism; id++ {
+		if c := capacities[id]; c > 0 {
+			sum += c
+			known++
+		}
+	}
+
+	shares := make([]float64, parallelism)
+	if known == 0 {
+		for id := range shares {
+			shares[id] = 1 / float64(parallelism)
+		}
+		return shares
+	}
+
+	mean := sum / float64(known)
+	total := sum + mean*float64(parallelism-known)
+	for id := range shares {
+		c := capacities[id]
+		if c <= 0 {
+			c = mean
+		}
+		shares[id] = c / total
+	}
+	return shares
+}
+
+// FunctionBatchSizes splits the effective global batch of the parallelism among the
+// functions by their shares, see CapacityShares. The sizes are the batch per device of
+// the functions that train on several devices. Every function gets a batch of at least 1
+func (r TrainRequest) FunctionBatchSizes(parallelism, devices int, shares []float64) []int {
+	global := r.FunctionBatchSize(parallelism*devices) * parallelism
+	sizes := make([]int, len(shares))
+	for id, share := range shares {
+		sizes[id] = int(math.Round(float64(global) * share))
+		if sizes[id] < 1 {
+			sizes[id] = 1
+		}
+	}
+	return sizes
+}
+
+// ScaledLearningRate applies the linear scaling rule, multiplying the learning rate
+// by the ratio between the effective global batch and the reference one the learning
+// rate was tuned for. The learning rate is not changed without a reference batch
+func ScaledLearningRate(lr float32, effective, reference int) float32 {
+	if reference <= 0 || effective <= 0 {
+		return lr
+	}
+	return lr * float32(effective) / float32(reference)
+}
+
+// ValidateGlobalBatch checks that the global batch is not negative and is
+// big enough for every function to get at least the minimum batch
+func (o TrainOptions) ValidateGlobalBatch() error {
+	if o.GlobalBatchSize < 0 {
+		return fmt.Errorf("global batch size should not be negative, got %v", o.GlobalBatchSize)
+	}
+	if o.GlobalBatchSize > 0 && o.GlobalBatchSize < MinFunctionBatchSize {
+		return fmt.Errorf("global batch size should be at least %v, got %v", MinFunctionBatchSize, o.GlobalBatchSize)
+	}
+	return nil
+}
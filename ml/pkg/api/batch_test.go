@@ -0,0 +1,93 @@
+package api
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestFunctionBatchSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		batch       int
+		global      int
+		parallelism int
+		want        int
+	}{
+		{"no global batch", 32, 0, 4, 32},
+		{"even split", 32, 128, 4, 32},
+		{"rounded down", 32, 100, 3, 33},
+		{"half rounded up", 32, 100, 8, 13},
+		{"rounded up", 32, 100, 6, 17},
+		{"minimum batch", 32, 64, 16, 8},
+		{"under the minimum after rounding", 32, 60, 8, 8},
+		{"no parallelism", 32, 100, 0, 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := TrainRequest{BatchSize: tt.batch, Options: TrainOptions{GlobalBatchSize: tt.global}}
+			if got := r.FunctionBatchSize(tt.parallelism); got != tt.want {
+				t.Errorf("got batch %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFunctionBatchSizes(t *testing.T) {
+	r := TrainRequest{BatchSize: 32, Options: TrainOptions{GlobalBatchSize: 100}}
+
+	// the global batch of 3 x 33 is split by the shares and each gets at least 1
+	got := r.FunctionBatchSizes(3, 1, []float64{0.5, 0.499, 0.001})
+	if want := []int{50, 49, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got batches %v, want %v", got, want)
+	}
+
+	// with 2 devices per function the split is over 4 devices, 25 each
+	got = r.FunctionBatchSizes(2, 2, []float64{0.75, 0.25})
+	if want := []int{38, 13}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got batches %v with devices, want %v", got, want)
+	}
+}
+
+func TestScaledLearningRate(t *testing.T) {
+	tests := []struct {
+		name      string
+		effective int
+		reference int
+		want      float32
+	}{
+		{"same batch", 128, 128, 0.1},
+		{"doubled", 256, 128, 0.2},
+		{"halved", 64, 128, 0.05},
+		{"rounded batch", 99, 100, 0.099},
+		{"no reference", 256, 0, 0.1},
+		{"no effective batch", 0, 128, 0.1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ScaledLearningRate(0.1, tt.effective, tt.reference); math.Abs(float64(got-tt.want)) > 1e-6 {
+				t.Errorf("got learning rate %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateGlobalBatch(t *testing.T) {
+	tests := []struct {
+		global    int
+		wantError bool
+	}{
+		{0, false},
+		{MinFunctionBatchSize, false},
+		{MinFunctionBatchSize - 1, true},
+		{-1, true},
+	}
+
+	for _, tt := range tests {
+		if err := (TrainOptions{GlobalBatchSize: tt.global}).ValidateGlobalBatch(); (err != nil) != tt.wantError {
+			t.Errorf("global batch %d: got error %v, want error %v", tt.global, err, tt.wantError)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Backends where the reference model of a job is checkpointed after every epoch
+const (
+	// CheckpointBackendRedis keeps the model only in redis,
+	// which is lost if the instance restarts
+	CheckpointBackendRedis = "redis"
+
+	// CheckpointBackendS3 and CheckpointBackendGCS also upload the
+	// model with a manifest to the configured bucket
+	CheckpointBackendS3  = "s3"
+	CheckpointBackendGCS = "gcs"
+)
+
+type (
+	// CheckpointManifest describes a checkpoint of a job saved in object storage.
+	// The tensors are saved as little endian blobs, one object per layer, under
+	// the key of each tensor relative to the bucket
+	CheckpointManifest struct {
+		JobId   string         `json:"job_id"`
+		Epoch   int            `json:"epoch"`
+		Model   string         `json:"model"`
+		Storage string         `json:"storage"`
+		Created time.Time      `json:"created"`
+		Size    int64          `json:"size"`
+		Tensors []ExportTensor `json:"tensors"`
+	}
+)
+
+// ValidateCheckpointBackend checks that the backend is known, empty uses redis
+func ValidateCheckpointBackend(backend string) error {
+	switch backend {
+	case "", CheckpointBackendRedis, CheckpointBackendS3, CheckpointBackendGCS:
+		return nil
+	default:
+		return fmt.Errorf("unknown checkpoint backend \"%s\", should be %s, %s or %s",
+			backend, CheckpointBackendRedis, CheckpointBackendS3, CheckpointBackendGCS)
+	}
+}
+
+// IsObjectStorage returns whether the backend saves the checkpoints in a bucket
+func IsObjectStorage(backend string) bool {
+	return backend == CheckpointBackendS3 || backend == CheckpointBackendGCS
+}
+
+// CheckpointPrefix is the prefix of all the objects of the checkpoints of a job
+func CheckpointPrefix(jobId string) string {
+	return "checkpoints/" + jobId + "/"
+}
+
+// CheckpointEpochPrefix is the prefix of the objects of the checkpoint of an epoch
+func CheckpointEpochPrefix(jobId string, epoch int) string {
+	return fmt.Sprintf("%sepoch-%04d/", CheckpointPrefix(jobId), epoch)
+}
+
+// CheckpointLatestKey is the key of the manifest of the last checkpoint of a
+// job, which is overwritten once all the tensors of a new checkpoint are saved
+func CheckpointLatestKey(jobId string) string {
+	return CheckpointPrefix(jobId) + "latest.json"
+}
+
+// CheckpointBestMetric returns the validation metric the best checkpoints are
+// ranked by, the accuracy unless the options set the validation loss
+func (o TrainOptions) CheckpointBestMetric() string {
+	if len(o.KeepBestBy) == 0 {
+		return MetricAccuracy
+	}
+	return o.KeepBestBy
+}
+
+// ValidateRetention checks that the retention of the checkpoints is not negative,
+// ranks them by a validation metric and is only set when checkpointing to a bucket
+func (o TrainOptions) ValidateRetention() error {
+	if o.KeepLast < 0 || o.KeepBest < 0 {
+		return fmt.Errorf("checkpoints to keep should not be negative")
+	}
+
+	switch o.KeepBestBy {
+	case "", MetricAccuracy, MetricValidationLoss:
+	default:
+		return fmt.Errorf("best checkpoints should be ranked by %s or %s, got \"%s\"",
+			MetricAccuracy, MetricValidationLoss, o.KeepBestBy)
+	}
+
+	if (o.KeepLast > 0 || o.KeepBest > 0) && !IsObjectStorage(o.CheckpointBackend) {
+		return fmt.Errorf("checkpoint retention needs a checkpoint backend of %s or %s",
+			CheckpointBackendS3, CheckpointBackendGCS)
+	}
+	return nil
+}
+
+// RetainedCheckpoints returns which of the saved checkpoints, given by their epoch in
+// increasing order, are kept: the last KeepLast ones and the best KeepBest ones by the
+// validation metric, so a checkpoint can count for both. The newest checkpoint is always
+// kept since it is the one the jobs resume from, and the checkpoints of epochs that were
+// not validated do not compete for the best. If no retention is set all are kept
+func (o TrainOptions) RetainedCheckpoints(epochs []int, h *JobHistory) map[int]bool {
+	keep := make(map[int]bool, len(epochs))
+	if o.KeepLast == 0 && o.KeepBest == 0 {
+		for _, e := range epochs {
+			keep[e] = true
+		}
+		return keep
+	}
+
+	if len(epochs) > 0 {
+		keep[epochs[len(epochs)-1]] = true
+	}
+	for i := len(epochs) - 1; i >= 0 && i >= len(epochs)-o.KeepLast; i-- {
+		keep[epochs[i]] = true
+	}
+
+	if o.KeepBest > 0 {
+		metric := o.CheckpointBestMetric()
+		values, validated := h.Series(metric)
+		value := make(map[int]float64, len(values))
+		for i, e := range validated {
+			value[e] = values[i]
+		}
+
+		var candidates []int
+		for _, e := range epochs {
+			if _, exists := value[e]; exists {
+				candidates = append(candidates, e)
+			}
+		}
+
+		// ties go to the most recent checkpoint
+		sort.SliceStable(candidates, func(i, j int) bool {
+			a, b := value[candidates[i]], value[candidates[j]]
+			if a == b {
+				return candidates[i] > candidates[j]
+			}
+			return o.Better(metric, a, b)
+		})
+		for i := 0; i < len(candidates) && i < o.KeepBest; i++ {
+			keep[candidates[i]] = true
+		}
+	}
+
+	return keep
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRetainedCheckpoints(t *testing.T) {
+	// every other epoch is validated, epochs 4 and 8 tie on the accuracy
+	h := &JobHistory{
+		ValidationEpochs: []int{2, 4, 6, 8},
+		Accuracy:         []float64{50, 80, 60, 80},
+		ValidationLoss:   []float64{1, 0.2, 0.5, 0.3},
+	}
+	all := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	tests := []struct {
+		name   string
+		opts   TrainOptions
+		epochs []int
+		want   []int
+	}{
+		{"no retention", TrainOptions{}, all, all},
+		{"no checkpoints", TrainOptions{KeepLast: 2, KeepBest: 2}, nil, nil},
+		{"keep last", TrainOptions{KeepLast: 3}, all, []int{8, 9, 10}},
+		{"keep last over the saved", TrainOptions{KeepLast: 20}, []int{3, 4}, []int{3, 4}},
+		// the newest checkpoint is kept even if it is not one of the best
+		{"keep best", TrainOptions{KeepBest: 2}, all, []int{4, 8, 10}},
+		{"tie goes to the most recent", TrainOptions{KeepBest: 1}, all, []int{8, 10}},
+		{"keep best by loss", TrainOptions{KeepBest: 1, KeepBestBy: MetricValidationLoss}, all, []int{4, 10}},
+		// epoch 8 is one of the last and one of the best, and is not counted twice
+		{"overlap", TrainOptions{KeepLast: 3, KeepBest: 2}, all, []int{4, 8, 9, 10}},
+		{"overlap of every checkpoint", TrainOptions{KeepLast: 2, KeepBest: 2}, []int{4, 8}, []int{4, 8}},
+		// the best checkpoints that were pruned before do not compete
+		{"pruned best", TrainOptions{KeepBest: 2}, []int{5, 6, 7, 8, 9, 10}, []int{6, 8, 10}},
+		{"no validated checkpoints", TrainOptions{KeepBest: 2}, []int{1, 3, 5}, []int{5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep := tt.opts.RetainedCheckpoints(tt.epochs, h)
+			var got []int
+			for _, e := range tt.epochs {
+				if keep[e] {
+					got = append(got, e)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) || len(keep) != len(tt.want) {
+				t.Errorf("got checkpoints %v kept, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      TrainOptions
+		wantError bool
+	}{
+		{"no retention", TrainOptions{}, false},
+		{"bucket", TrainOptions{KeepLast: 2, KeepBest: 1, KeepBestBy: MetricValidationLoss, CheckpointBackend: CheckpointBackendS3}, false},
+		{"negative", TrainOptions{KeepLast: -1, CheckpointBackend: CheckpointBackendGCS}, true},
+		{"unknown metric", TrainOptions{KeepBest: 1, KeepBestBy: MetricTrainLoss, CheckpointBackend: CheckpointBackendS3}, true},
+		{"redis", TrainOptions{KeepLast: 2, CheckpointBackend: CheckpointBackendRedis}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.ValidateRetention(); (err != nil) != tt.wantError {
+				t.Errorf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+}
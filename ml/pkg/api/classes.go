@@ -0,0 +1,97 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetricsPerClass makes the validation functions count the correct predictions
+// of every class, so the job keeps the accuracy of each class in the history
+const MetricsPerClass = "per_class"
+
+// Prefixes of the per-class counts in the results of the validation functions,
+// followed by the label of the class
+const (
+	ClassCorrectPrefix = "class_correct_"
+	ClassTotalPrefix   = "class_total_"
+)
+
+// WantsMetric returns true if the optional metric is enabled
+func (o TrainOptions) WantsMetric(metric string) bool {
+	for _, m := range o.Metrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateMetrics checks that the optional metrics are known
+func (o TrainOptions) ValidateMetrics() error {
+	for _, m := range o.Metrics {
+		if m != MetricsPerClass {
+			return fmt.Errorf("unknown metric \"%s\", expected %s", m, MetricsPerClass)
+		}
+	}
+	return nil
+}
+
+// SetClassAccuracy sets the accuracy of every class for the validation of the
+// given epoch, computed from the correct predictions and the datapoints of
+// each class. Classes seen for the first time are padded with zeros in the
+// previous validations
+func (h *JobHistory) SetClassAccuracy(epoch int, correct, total map[string]float64, decimals int) {
+	if h.ClassAccuracy == nil {
+		h.ClassAccuracy = make(map[string][]float64)
+	}
+
+	i := h.validationIndex(epoch)
+	for class, n := range total {
+		if n <= 0 {
+			continue
+		}
+		accuracy := RoundMetric(correct[class]/n*100, decimals)
+		h.ClassAccuracy[class] = setAt(h.ClassAccuracy[class], i, accuracy)
+	}
+	h.ClassSamples = total
+}
+
+// Classes returns the labels of the classes in the history. Numeric labels
+// come first in numeric order, followed by the rest sorted alphabetically,
+// so the classes are listed in the same order every time
+func (h *JobHistory) Classes() []string {
+	classes := make([]string, 0, len(h.ClassAccuracy))
+	for class := range h.ClassAccuracy {
+		classes = append(classes, class)
+	}
+
+	sort.Slice(classes, func(i, j int) bool {
+		a, errA := strconv.Atoi(classes[i])
+		b, errB := strconv.Atoi(classes[j])
+		switch {
+		case errA == nil && errB == nil && a != b:
+			return a < b
+		case errA == nil && errB != nil:
+			return true
+		case errA != nil && errB == nil:
+			return false
+		}
+		return classes[i] < classes[j]
+	})
+	return classes
+}
+
+// ClassCounts adds the per-class counts in the results of a
+// validation function to the correct and total counts
+func ClassCounts(results map[string]float64, correct, total map[string]float64) {
+	for key, value := range results {
+		switch {
+		case strings.HasPrefix(key, ClassCorrectPrefix):
+			correct[strings.TrimPrefix(key, ClassCorrectPrefix)] += value
+		case strings.HasPrefix(key, ClassTotalPrefix):
+			total[strings.TrimPrefix(key, ClassTotalPrefix)] += value
+		}
+	}
+}
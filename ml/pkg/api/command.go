@@ -0,0 +1,66 @@
+package api
+
+import (
+	"strings"
+)
+
+// RedactedValue replaces the values of the secret flags in the command of a job
+const RedactedValue = "REDACTED"
+
+// secretFlags are the flags of the train command whose values are not kept,
+// besides the ones named like a secret, see isSecretFlag. The notify url
+// often carries the token of the webhook it points to
+var secretFlags = map[string]bool{
+	"notify-url": true,
+}
+
+// isSecretFlag returns whether the value of the flag should be redacted
+func isSecretFlag(name string) bool {
+	if secretFlags[name] {
+		return true
+	}
+	for _, word := range []string{"secret", "token", "password", "credential"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactCommand returns a copy of the arguments of a command with the values
+// of the secret flags redacted, given either as --flag=value or --flag value
+func RedactCommand(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+	for i := 0; i < len(redacted); i++ {
+		arg := redacted[i]
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+		name := strings.TrimPrefix(arg, "--")
+		if eq := strings.Index(name, "="); eq >= 0 {
+			if isSecretFlag(name[:eq]) {
+				redacted[i] = "--" + name[:eq] + "=" + RedactedValue
+			}
+			continue
+		}
+		if isSecretFlag(name) && i+1 < len(redacted) {
+			i++
+			redacted[i] = RedactedValue
+		}
+	}
+	return redacted
+}
+
+// CommandLine returns the command that submitted the job as it would be typed,
+// quoting the arguments that hold spaces or quotes, empty if it was not recorded
+func (r TrainRequest) CommandLine() string {
+	quoted := make([]string, len(r.Command))
+	for i, arg := range r.Command {
+		if len(arg) == 0 || strings.ContainsAny(arg, " \t\"'$\\") {
+			arg = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,23 @@
+package api
+
+// ConcurrencyDivergence is the fraction of the parallelism granted to a job by which
+// the train invocations that ran at the same time must fall short of it for the
+// scheduler policy to scale from the measured concurrency instead. Fission might
+// queue some of the invocations, so they don't all run at once
+const ConcurrencyDivergence = 0.25
+
+// Diverged returns true if the concurrency measured in the last epoch is
+// lower than the parallelism by more than the ConcurrencyDivergence
+func (s JobState) Diverged() bool {
+	return s.MeasuredConcurrency > 0 &&
+		float64(s.MeasuredConcurrency) < float64(s.Parallelism)*(1-ConcurrencyDivergence)
+}
+
+// PolicyParallelism returns the parallelism the scheduler policy scales from, the
+// measured concurrency if it diverged from the parallelism granted and this otherwise
+func (s JobState) PolicyParallelism() int {
+	if s.Diverged() {
+		return s.MeasuredConcurrency
+	}
+	return s.Parallelism
+}
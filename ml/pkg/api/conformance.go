@@ -0,0 +1,61 @@
+package api
+
+// Settings of the invocations of the conformance suite, which runs every task of a
+// function on a tiny slice of its dataset. The function trains as one of many, so it
+// only gets a shard or two of the train and validation sets
+const (
+	DefaultConformanceParallelism = 1000
+	DefaultConformanceBatchSize   = 8
+	ConformanceJobPrefix          = "conformance-"
+)
+
+// Results of the checks of the conformance suite
+const (
+	ConformancePass = "pass"
+	ConformanceFail = "fail"
+	ConformanceSkip = "skip"
+)
+
+type (
+	// ConformanceCheck is the result of invoking one task of a function. Capability is
+	// the capability the task belongs to, and Declared whether the function reported
+	// it at init. Field is the field of the response that deviated from the schema
+	ConformanceCheck struct {
+		Task       string  `json:"task"`
+		Capability string  `json:"capability"`
+		Declared   bool    `json:"declared"`
+		Result     string  `json:"result"`
+		Field      string  `json:"field,omitempty"`
+		Message    string  `json:"message,omitempty"`
+		Elapsed    float64 `json:"elapsed"`
+	}
+
+	// ConformanceReport holds the checks run against a function, along with the
+	// schema version and the capabilities it reported in its init response
+	ConformanceReport struct {
+		Function      string             `json:"function"`
+		SchemaVersion string             `json:"schema_version,omitempty"`
+		Capabilities  []string           `json:"capabilities,omitempty"`
+		Checks        []ConformanceCheck `json:"checks"`
+	}
+)
+
+// Passed returns true if none of the checks failed
+func (r *ConformanceReport) Passed() bool {
+	for _, check := range r.Checks {
+		if check.Result == ConformanceFail {
+			return false
+		}
+	}
+	return true
+}
+
+// Declares returns whether the function reported the capability at init
+func (r *ConformanceReport) Declares(capability string) bool {
+	for _, c := range r.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
@@ -1,5 +1,7 @@
 package api
 
+import "time"
+
 // Addresses of services
 const (
 	FissionRouterUrl   = "http://router.fission"
@@ -15,6 +17,101 @@ const (
 
 const DefaultParallelism = 5
 
+// MongoDB
+const (
+	// DefaultMongoDatabase is the database of the histories and the rest of the
+	// collections of kubeml, which can be changed with MONGO_DATABASE
+	DefaultMongoDatabase = "kubeml"
+
+	// DefaultHistoryCollection is the collection of the histories
+	// of the jobs, which can be changed with MONGO_HISTORY_COLLECTION
+	DefaultHistoryCollection = "history"
+)
+
+// Serialization formats of the payloads exchanged with the functions
+const (
+	SerializationJSON    = "json"
+	SerializationMsgpack = "msgpack"
+)
+
+// Scratch volumes
+const (
+	// ScratchMountPath is the path where the scratch volume of
+	// a job is mounted in the function pods
+	ScratchMountPath = "/scratch"
+
+	// DefaultMaxScratchGB is the default cap on the size of the
+	// scratch volume that a job can request
+	DefaultMaxScratchGB = 100
+)
+
+// Iterations per epoch
+const (
+	// DatasetShardSize is the number of datapoints in each of the
+	// documents a dataset is split into when uploaded
+	DatasetShardSize = 64
+
+	// DefaultMaxIterationsPerEpoch is the default number of merges per epoch
+	// above which a train request is warned that its K is probably too small
+	DefaultMaxIterationsPerEpoch = 100
+
+	// Headers of the response to a train request with the planned
+	// iterations per epoch and the warning about K if any
+	HeaderIterationsPerEpoch = "X-Kubeml-Iterations-Per-Epoch"
+	HeaderWarning            = "X-Kubeml-Warning"
+)
+
+// Timeouts of the communication between components
+const (
+	// DefaultSchedulerTimeout is the default time in seconds a job waits
+	// for the scheduler to send the parallelism of the next epoch
+	DefaultSchedulerTimeout = 60
+
+	// RequestTimeout bounds the requests sent to the parameter
+	// server and the scheduler by the other components
+	RequestTimeout = 2 * time.Minute
+
+	// DefaultHistoryFlushInterval is the default time in seconds between
+	// the writes of the history of a job to the database while it trains
+	DefaultHistoryFlushInterval = 30
+)
+
+// Scheduler decisions
+const (
+	// DecisionValidFor is the number of epochs a decision that keeps
+	// the parallelism holds before the job asks the scheduler again
+	DecisionValidFor = 3
+
+	// DefaultThroughputTrigger is the default relative change of the epoch
+	// time after which the job asks the scheduler before the decision expires
+	DefaultThroughputTrigger = 0.2
+)
+
+// DefaultImprovementWindow is the default number of epochs over which
+// the train loss must improve when the job sets a minimum improvement
+const DefaultImprovementWindow = 5
+
+// Admission limits
+const (
+	// DefaultMaxEpochs is the default maximum number of epochs of a train request
+	DefaultMaxEpochs = 1000
+
+	// DefaultMaxFunctionEpochs is the default maximum of epochs
+	// times the parallelism of a train request
+	DefaultMaxFunctionEpochs = 20000
+)
+
+// Job bundles
+const (
+	// ExportManifestFile is the name of the manifest in a bundle,
+	// which is always the last file of the archive
+	ExportManifestFile = "manifest.json"
+
+	// DefaultExportMaxWeightsBytes is the default size above which the weights
+	// are not included in a bundle unless explicitly requested
+	DefaultExportMaxWeightsBytes = 256 << 20
+)
+
 // Debug
 const (
 	MongoUrlDebug            = "mongodb://192.168.99.101:30074"
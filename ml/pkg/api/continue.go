@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// ContinueClaimTimeout is the time a continue claims the history of the job. A
+// claim older than it is taken as a continue that never started training
+const ContinueClaimTimeout = 10 * time.Minute
+
+// ContinueRequest asks to train a finished job for more epochs
+// in place, keeping its id and appending to its history
+type ContinueRequest struct {
+	Epochs int `json:"epochs"`
+}
+
+// Epochs returns the number of epochs recorded in the history
+func (h *JobHistory) Epochs() int {
+	return len(h.TrainLoss)
+}
+
+// Continue returns the request that trains the job of the history for more epochs,
+// resuming from its last checkpoint. Only completed jobs whose last epoch was
+// checkpointed in object storage can be continued, since the model kept in redis
+// is deleted once the job finishes, and only if no other continue claimed the job
+func (h *History) Continue(epochs int) (*TrainRequest, error) {
+	if epochs <= 0 {
+		return nil, fmt.Errorf("epochs to continue should be positive, got %d", epochs)
+	}
+	if status := h.Status(); status != JobCompleted {
+		return nil, fmt.Errorf("job %s is %s, only completed jobs can be continued", h.Id, status)
+	}
+	if !h.Continuing.IsZero() && time.Since(h.Continuing) < ContinueClaimTimeout {
+		return nil, fmt.Errorf("job %s is already being continued", h.Id)
+	}
+
+	// the tensors of the job are deleted after it finishes, and the continued
+	// job uses the same keys, so wait until the cleanup is recorded. Older
+	// histories do not record the end of the job nor the cleanup
+	if !h.Finished.IsZero() && h.Cleanup == nil {
+		return nil, fmt.Errorf("the tensors of job %s are still being deleted, try again later", h.Id)
+	}
+
+	backend := h.Task.Options.CheckpointBackend
+	if !IsObjectStorage(backend) || h.Data.CheckpointEpoch == 0 {
+		return nil, fmt.Errorf("job %s has no checkpoint in object storage to continue from", h.Id)
+	}
+	trained := h.Data.Epochs()
+	if h.Data.CheckpointEpoch != trained {
+		return nil, fmt.Errorf("the last checkpoint of job %s is from epoch %d, but it trained %d epochs",
+			h.Id, h.Data.CheckpointEpoch, trained)
+	}
+
+	// the changes of the continued run are recorded on a copy
+	// of the effective options so the history is not modified
+	req := h.Task
+	if req.Effective != nil {
+		effective := *req.Effective
+		effective.Changes = append([]OptionChange(nil), effective.Changes...)
+		req.Effective = &effective
+	}
+	req.Epochs = trained + epochs
+	req.Options.ResumeFrom = h.Id
+	req.Options.ResumeBackend = backend
+	req.Options.ContinueFrom = h.Id
+
+	reason := fmt.Sprintf("continued for %d more epochs", epochs)
+	req.RecordChange("epochs", h.Task.Epochs, req.Epochs, reason)
+	req.RecordChange("options.resume_from", h.Task.Options.ResumeFrom, req.Options.ResumeFrom, reason)
+	req.RecordChange("options.resume_backend", h.Task.Options.ResumeBackend, req.Options.ResumeBackend, reason)
+	return &req, nil
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// continuableHistory returns the history of a completed job checkpointed after its last epoch
+func continuableHistory() *History {
+	h := &History{
+		Id:       "job",
+		Task:     TrainRequest{Epochs: 2, Options: TrainOptions{CheckpointBackend: CheckpointBackendS3}},
+		Finished: time.Now().Add(-time.Hour),
+		Cleanup:  &TensorCleanup{Keys: 10},
+	}
+	h.Data.TrainLoss = []float64{2, 1}
+	h.Data.CheckpointEpoch = 2
+	return h
+}
+
+func TestContinue(t *testing.T) {
+	req, err := continuableHistory().Continue(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Epochs != 5 || req.Options.ContinueFrom != "job" || req.Options.ResumeFrom != "job" {
+		t.Errorf("got epochs %d, continue from %q and resume from %q, want 5, job and job",
+			req.Epochs, req.Options.ContinueFrom, req.Options.ResumeFrom)
+	}
+}
+
+func TestContinueRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(h *History)
+		reason string
+	}{
+		{"running", func(h *History) { h.InProgress = true }, "is running"},
+		{"failed", func(h *History) { h.Error = "out of memory" }, "is failed"},
+		{"tensors being deleted", func(h *History) { h.Cleanup = nil }, "still being deleted"},
+		{"checkpoint in redis", func(h *History) { h.Task.Options.CheckpointBackend = CheckpointBackendRedis }, "no checkpoint"},
+		{"old checkpoint", func(h *History) { h.Data.CheckpointEpoch = 1 }, "from epoch 1"},
+		{"being continued", func(h *History) { h.Continuing = time.Now().Add(-time.Minute) }, "already being continued"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := continuableHistory()
+			tt.change(h)
+			_, err := h.Continue(3)
+			if err == nil || !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("got error %v, want it to contain %q", err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestContinueExpiredClaim(t *testing.T) {
+	// a continue that never started does not keep the job claimed
+	h := continuableHistory()
+	h.Continuing = time.Now().Add(-ContinueClaimTimeout - time.Minute)
+	if _, err := h.Continue(3); err != nil {
+		t.Errorf("got error %v with an expired claim, want nil", err)
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"fmt"
+	"math"
+)
+
+// ValidateMaxEpochCost checks that the cost cap of the epochs is not negative
+func (o TrainOptions) ValidateMaxEpochCost() error {
+	if o.MaxEpochCost < 0 {
+		return fmt.Errorf("max epoch cost should not be negative, got %v", o.MaxEpochCost)
+	}
+	return nil
+}
+
+// OverEpochCost returns whether the function seconds of an epoch exceed the cap of the job
+func (o TrainOptions) OverEpochCost(cost float64) bool {
+	return o.MaxEpochCost > 0 && cost > o.MaxEpochCost
+}
+
+// CostParallelism returns the parallelism of the epoch after one whose functions ran
+// for cost seconds with the given parallelism, lowered in proportion to how far the
+// epoch went over the cap. It is lowered by at least one function, down to one
+func (o TrainOptions) CostParallelism(parallelism int, cost float64) int {
+	if !o.OverEpochCost(cost) || parallelism <= 1 {
+		return parallelism
+	}
+
+	reduced := int(math.Floor(float64(parallelism) * o.MaxEpochCost / cost))
+	switch {
+	case reduced < 1:
+		return 1
+	case reduced >= parallelism:
+		return parallelism - 1
+	default:
+		return reduced
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import "time"
+
+// Deployment heartbeats
+const (
+	// DeploymentHeartbeatInterval is how often the components
+	// refresh their document in the deployments collection
+	DeploymentHeartbeatInterval = time.Minute
+
+	// DeploymentStaleAfter is the time without a heartbeat
+	// after which a component is reported as stale
+	DeploymentStaleAfter = 3 * DeploymentHeartbeatInterval
+)
+
+// DeploymentInfo describes a running component of kubeml, it is written by
+// the component on startup and its heartbeat refreshed periodically. Id is the
+// component and the host it runs on, so every replica has its own document
+type DeploymentInfo struct {
+	Id        string `json:"id" bson:"_id"`
+	Component string `json:"component"`
+	Host      string `json:"host"`
+
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	// ConfigHash identifies the environment the component runs
+	// with, replicas with different configurations differ in it
+	ConfigHash string `json:"config_hash"`
+	// RedisVersion and MongoVersion are the versions of the databases
+	// detected by the component, empty if they could not be reached
+	RedisVersion string `json:"redis_version,omitempty"`
+	MongoVersion string `json:"mongo_version,omitempty"`
+
+	Started   time.Time `json:"started"`
+	Heartbeat time.Time `json:"heartbeat"`
+	// Stale is set by the controller when listing the components
+	Stale bool `json:"stale" bson:"-"`
+}
+
+// IsStale returns whether the component missed its heartbeats
+func (d *DeploymentInfo) IsStale(now time.Time) bool {
+	return now.Sub(d.Heartbeat) > DeploymentStaleAfter
+}
@@ -0,0 +1,37 @@
+package api
+
+import "fmt"
+
+// MaxFunctionDevices is the most devices a function can train on
+const MaxFunctionDevices = 16
+
+// ValidateDevices checks that the devices per function are
+// not negative and under MaxFunctionDevices
+func (o TrainOptions) ValidateDevices() error {
+	if o.DevicesPerFunction < 0 {
+		return fmt.Errorf("devices per function should not be negative, got %d", o.DevicesPerFunction)
+	}
+	if o.DevicesPerFunction > MaxFunctionDevices {
+		return fmt.Errorf("devices per function should be at most %d, got %d", MaxFunctionDevices, o.DevicesPerFunction)
+	}
+	return nil
+}
+
+// FunctionDevices returns the devices each function is asked to train on, 1 unless set
+func (o TrainOptions) FunctionDevices() int {
+	if o.DevicesPerFunction <= 1 {
+		return 1
+	}
+	return o.DevicesPerFunction
+}
+
+// MultiDevice returns true if the functions of the job train on several devices
+func (o TrainOptions) MultiDevice() bool {
+	return o.FunctionDevices() > 1
+}
+
+// StepBatchSize returns the datapoints a function takes in every step, the batch
+// of the request on each of its devices, used to plan the merges of an epoch
+func (r TrainRequest) StepBatchSize() int {
+	return r.BatchSize * r.Options.FunctionDevices()
+}
@@ -0,0 +1,57 @@
+package api
+
+import "fmt"
+
+type (
+	// EffectiveOptions is the snapshot of the settings a job actually ran with, after
+	// the defaults and clamps applied by the controller when the job was admitted and
+	// by the job while it ran. Each of those is recorded as an OptionChange
+	EffectiveOptions struct {
+		Epochs    int            `json:"epochs"`
+		BatchSize int            `json:"batch_size"`
+		Options   TrainOptions   `json:"options"`
+		Changes   []OptionChange `json:"changes,omitempty"`
+	}
+
+	// OptionChange records a field of the request changed after it was sent,
+	// with the value sent by the user, the value used and the reason why
+	OptionChange struct {
+		Field     string `json:"field"`
+		Original  string `json:"original"`
+		Effective string `json:"effective"`
+		Reason    string `json:"reason"`
+	}
+)
+
+// RecordChange records that a field of the request was changed from original to
+// effective, doing nothing if the value is the same. Every place changing the
+// request once it is sent records the change, so the task and the history tell
+// what governed the run even when it differs from what the user asked for
+func (r *TrainRequest) RecordChange(field string, original, effective interface{}, reason string) {
+	o, e := fmt.Sprint(original), fmt.Sprint(effective)
+	if o == e {
+		return
+	}
+
+	if r.Effective == nil {
+		r.Effective = &EffectiveOptions{}
+	}
+	r.Effective.Changes = append(r.Effective.Changes, OptionChange{
+		Field:     field,
+		Original:  o,
+		Effective: e,
+		Reason:    reason,
+	})
+	r.SnapshotOptions()
+}
+
+// SnapshotOptions saves the current settings of the request as its effective
+// options, called once the request is admitted and after every change
+func (r *TrainRequest) SnapshotOptions() {
+	if r.Effective == nil {
+		r.Effective = &EffectiveOptions{}
+	}
+	r.Effective.Epochs = r.Epochs
+	r.Effective.BatchSize = r.BatchSize
+	r.Effective.Options = r.Options
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecordChange(t *testing.T) {
+	r := TrainRequest{Epochs: 5, BatchSize: 32}
+
+	// a value that did not change is not recorded
+	r.RecordChange("epochs", 5, r.Epochs, "unchanged")
+	if r.Effective != nil {
+		t.Fatalf("got effective options %+v for an unchanged value, want none", r.Effective)
+	}
+
+	r.BatchSize = 16
+	r.RecordChange("batch_size", 32, r.BatchSize, "split")
+	r.Options.ClassWeights = []float64{1, 2}
+	r.RecordChange("options.class_weights", []float64(nil), r.Options.ClassWeights, "resolved")
+
+	want := []OptionChange{
+		{Field: "batch_size", Original: "32", Effective: "16", Reason: "split"},
+		{Field: "options.class_weights", Original: "[]", Effective: "[1 2]", Reason: "resolved"},
+	}
+	if !reflect.DeepEqual(r.Effective.Changes, want) {
+		t.Errorf("got changes %+v, want %+v", r.Effective.Changes, want)
+	}
+
+	// the snapshot follows the latest change
+	if r.Effective.BatchSize != 16 || r.Effective.Epochs != 5 || !reflect.DeepEqual(r.Effective.Options.ClassWeights, []float64{1, 2}) {
+		t.Errorf("got snapshot %+v, want the current settings", r.Effective)
+	}
+}
+
+func TestApplySuggestionsRecordsChanges(t *testing.T) {
+	k := 4
+	r := TrainRequest{Options: TrainOptions{
+		DefaultParallelism: 2,
+		K:                  8,
+		UseSuggestions:     []string{SuggestParallelism, SuggestK},
+	}}
+	r.ApplySuggestions(&Suggestions{Parallelism: 6, ParallelismJob: "job", K: &k, Runs: 3})
+
+	if r.Effective == nil || len(r.Effective.Changes) != 2 {
+		t.Fatalf("got effective options %+v, want the two suggestions recorded", r.Effective)
+	}
+	for i, field := range []string{"options.default_parallelism", "options.k"} {
+		if r.Effective.Changes[i].Field != field {
+			t.Errorf("got change %+v, want %s", r.Effective.Changes[i], field)
+		}
+	}
+	if r.Effective.Options.DefaultParallelism != 6 || r.Effective.Options.K != 4 {
+		t.Errorf("got parallelism %d and K %d in the snapshot, want 6 and 4",
+			r.Effective.Options.DefaultParallelism, r.Effective.Options.K)
+	}
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// EfficiencySpeedTarget is the fraction of the speed of the fastest parallelism of a
+// job the recommended parallelism must reach, the cheapest one that does is recommended
+const EfficiencySpeedTarget = 0.95
+
+type (
+	// EfficiencyPoint is the mean time and cost of the epochs of a job trained with a
+	// parallelism. Speed is the fraction of the speed of the fastest parallelism and
+	// Cost the fraction of its cost. Epochs is the number of epochs averaged, 0 if the
+	// point is interpolated between the closest parallelisms the job trained with
+	EfficiencyPoint struct {
+		Parallelism int     `json:"parallelism"`
+		Epochs      int     `json:"epochs"`
+		EpochTime   float64 `json:"epoch_time"`
+		EpochCost   float64 `json:"epoch_cost"`
+		Speed       float64 `json:"speed"`
+		Cost        float64 `json:"cost"`
+	}
+
+	// EfficiencyCurve is the speed against the cost of every parallelism from the lowest
+	// to the highest a job trained with, computed once the job finishes. Recommended is
+	// the parallelism for future runs of the same function and dataset
+	EfficiencyCurve struct {
+		Points      []EfficiencyPoint `json:"points"`
+		Fastest     int               `json:"fastest"`
+		Recommended int               `json:"recommended"`
+		Summary     string            `json:"summary"`
+	}
+
+	// ParallelismRecommendation is the parallelism recommended by the last job that
+	// trained a function on a dataset, stored keyed by both. The controller attaches it
+	// to the next requests of the pair, and the scheduler starts them from it
+	ParallelismRecommendation struct {
+		Id          string    `bson:"_id" json:"id"`
+		Function    string    `json:"function"`
+		Dataset     string    `json:"dataset"`
+		Parallelism int       `json:"parallelism"`
+		Speed       float64   `json:"speed"`
+		Cost        float64   `json:"cost"`
+		JobId       string    `json:"job_id"`
+		Updated     time.Time `json:"updated"`
+	}
+)
+
+// RecommendationId returns the id the recommendation of the function and the dataset is stored with
+func RecommendationId(function, dataset string) string {
+	return function + "/" + dataset
+}
+
+// EfficiencyCurve computes the efficiency curve of the job from the time and the function
+// seconds of its epochs, averaged for each parallelism it trained with. The first epoch is
+// left out if there are more, since it pays the cold starts of the functions. The
+// parallelisms in between the ones measured are interpolated linearly. The recommended
+// parallelism is the cheapest one within EfficiencySpeedTarget of the speed of the
+// fastest. It returns nil if the history has no epochs
+func (h *JobHistory) EfficiencyCurve() *EfficiencyCurve {
+	n := len(h.EpochDuration)
+	if len(h.Parallelism) < n {
+		n = len(h.Parallelism)
+	}
+
+	measured := make(map[int]*EfficiencyPoint)
+	var previous float64
+	for i := 0; i < n; i++ {
+		elapsed := h.EpochDuration[i] - previous
+		previous = h.EpochDuration[i]
+		parallelism := int(h.Parallelism[i])
+		if (i == 0 && n > 1) || elapsed <= 0 || parallelism < 1 {
+			continue
+		}
+
+		// the function seconds are measured since they were recorded
+		cost := elapsed * float64(parallelism)
+		if i < len(h.EpochCost) && h.EpochCost[i] > 0 {
+			cost = h.EpochCost[i]
+		}
+
+		p, exists := measured[parallelism]
+		if !exists {
+			p = &EfficiencyPoint{Parallelism: parallelism}
+			measured[parallelism] = p
+		}
+		p.Epochs++
+		p.EpochTime += elapsed
+		p.EpochCost += cost
+	}
+	if len(measured) == 0 {
+		return nil
+	}
+
+	levels := make([]int, 0, len(measured))
+	for parallelism, p := range measured {
+		p.EpochTime /= float64(p.Epochs)
+		p.EpochCost /= float64(p.Epochs)
+		levels = append(levels, parallelism)
+	}
+	sort.Ints(levels)
+
+	curve := &EfficiencyCurve{}
+	for i, parallelism := range levels {
+		if i > 0 {
+			curve.Points = append(curve.Points, interpolatePoints(*measured[levels[i-1]], *measured[parallelism])...)
+		}
+		curve.Points = append(curve.Points, *measured[parallelism])
+	}
+
+	fastest := curve.Points[0]
+	for _, p := range curve.Points {
+		if p.Epochs > 0 && p.EpochTime < fastest.EpochTime {
+			fastest = p
+		}
+	}
+	recommended := fastest
+	for i := range curve.Points {
+		p := &curve.Points[i]
+		p.Speed = fastest.EpochTime / p.EpochTime
+		p.Cost = p.EpochCost / fastest.EpochCost
+		if p.Speed >= EfficiencySpeedTarget && p.EpochCost < recommended.EpochCost {
+			recommended = *p
+		}
+	}
+
+	curve.Fastest = fastest.Parallelism
+	curve.Recommended = recommended.Parallelism
+	if recommended.Parallelism == fastest.Parallelism {
+		curve.Summary = fmt.Sprintf("parallelism %d was the fastest, and no parallelism got %.0f%% of its speed for less",
+			fastest.Parallelism, EfficiencySpeedTarget*100)
+	} else {
+		curve.Summary = fmt.Sprintf("parallelism %d would have got %.0f%% of the speed of parallelism %d at %.0f%% of the cost",
+			recommended.Parallelism, recommended.Speed*100, fastest.Parallelism, recommended.Cost*100)
+	}
+	return curve
+}
+
+// interpolatePoints returns the points of the parallelisms between the two measured ones,
+// with the time and the cost of the epochs interpolated linearly between them
+func interpolatePoints(from, to EfficiencyPoint) []EfficiencyPoint {
+	var points []EfficiencyPoint
+	for parallelism := from.Parallelism + 1; parallelism < to.Parallelism; parallelism++ {
+		f := float64(parallelism-from.Parallelism) / float64(to.Parallelism-from.Parallelism)
+		points = append(points, EfficiencyPoint{
+			Parallelism: parallelism,
+			EpochTime:   from.EpochTime + f*(to.EpochTime-from.EpochTime),
+			EpochCost:   from.EpochCost + f*(to.EpochCost-from.EpochCost),
+		})
+	}
+	return points
+}
+
+// Recommendation returns the recommendation stored for future runs of the job
+func (c *EfficiencyCurve) Recommendation(jobId string, req TrainRequest) *ParallelismRecommendation {
+	for _, p := range c.Points {
+		if p.Parallelism != c.Recommended {
+			continue
+		}
+		return &ParallelismRecommendation{
+			Id:          RecommendationId(req.FunctionName, req.Dataset),
+			Function:    req.FunctionName,
+			Dataset:     req.Dataset,
+			Parallelism: p.Parallelism,
+			Speed:       p.Speed,
+			Cost:        p.Cost,
+			JobId:       jobId,
+			Updated:     time.Now(),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEfficiencyCurve(t *testing.T) {
+	// the first epoch pays the cold starts, then 10s epochs with
+	// 2 functions, 6s with 4 and 5s with 8, costing 20, 24 and 40
+	h := &JobHistory{
+		EpochDuration: []float64{100, 110, 120, 126, 131},
+		Parallelism:   []float64{2, 2, 2, 4, 8},
+	}
+	curve := h.EfficiencyCurve()
+	if curve == nil {
+		t.Fatal("got no curve")
+	}
+
+	want := []EfficiencyPoint{
+		{Parallelism: 2, Epochs: 2, EpochTime: 10, EpochCost: 20},
+		{Parallelism: 3, EpochTime: 8, EpochCost: 22},
+		{Parallelism: 4, Epochs: 1, EpochTime: 6, EpochCost: 24},
+		{Parallelism: 5, EpochTime: 5.75, EpochCost: 28},
+		{Parallelism: 6, EpochTime: 5.5, EpochCost: 32},
+		{Parallelism: 7, EpochTime: 5.25, EpochCost: 36},
+		{Parallelism: 8, Epochs: 1, EpochTime: 5, EpochCost: 40},
+	}
+	if len(curve.Points) != len(want) {
+		t.Fatalf("got points %+v, want %d", curve.Points, len(want))
+	}
+	for i, p := range curve.Points {
+		w := want[i]
+		if p.Parallelism != w.Parallelism || p.Epochs != w.Epochs ||
+			math.Abs(p.EpochTime-w.EpochTime) > 1e-9 || math.Abs(p.EpochCost-w.EpochCost) > 1e-9 {
+			t.Errorf("got point %+v, want %+v", p, w)
+		}
+		if speed := 5 / w.EpochTime; math.Abs(p.Speed-speed) > 1e-9 {
+			t.Errorf("got speed %v of parallelism %d, want %v", p.Speed, p.Parallelism, speed)
+		}
+	}
+
+	// 7 functions are interpolated to get over 95% of the speed of 8 for less
+	if curve.Fastest != 8 || curve.Recommended != 7 {
+		t.Errorf("got fastest %d and recommended %d, want 8 and 7", curve.Fastest, curve.Recommended)
+	}
+	if math.Abs(curve.Points[5].Cost-0.9) > 1e-9 {
+		t.Errorf("got cost %v of the recommended parallelism, want 0.9", curve.Points[5].Cost)
+	}
+
+	rec := curve.Recommendation("job", TrainRequest{FunctionName: "resnet", Dataset: "cifar"})
+	if rec == nil || rec.Id != "resnet/cifar" || rec.Parallelism != 7 || rec.JobId != "job" {
+		t.Errorf("got recommendation %+v, want parallelism 7 for resnet/cifar", rec)
+	}
+}
+
+func TestEfficiencyCurveEdges(t *testing.T) {
+	tests := []struct {
+		name        string
+		h           JobHistory
+		points      int
+		fastest     int
+		recommended int
+	}{
+		{"no epochs", JobHistory{}, 0, 0, 0},
+		// a single epoch is kept even if it paid the cold starts
+		{"one epoch", JobHistory{EpochDuration: []float64{10}, Parallelism: []float64{4}}, 1, 4, 4},
+		{"one parallelism", JobHistory{EpochDuration: []float64{10, 20, 30}, Parallelism: []float64{4, 4, 4}}, 1, 4, 4},
+		// the measured function seconds replace the time times the parallelism,
+		// so 4 functions cost less than 2 running twice as long
+		{"measured cost", JobHistory{
+			EpochDuration: []float64{10, 20, 25},
+			Parallelism:   []float64{2, 2, 4},
+			EpochCost:     []float64{20, 20, 12},
+		}, 3, 4, 4},
+		// the cheapest parallelism is recommended if it is as fast
+		{"as fast for less", JobHistory{
+			EpochDuration: []float64{10, 20, 30},
+			Parallelism:   []float64{8, 8, 4},
+		}, 5, 4, 4},
+		{"shorter parallelism history", JobHistory{EpochDuration: []float64{10, 20, 30}, Parallelism: []float64{2}}, 1, 2, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			curve := tt.h.EfficiencyCurve()
+			if tt.points == 0 {
+				if curve != nil {
+					t.Errorf("got curve %+v, want none", curve)
+				}
+				return
+			}
+			if curve == nil || len(curve.Points) != tt.points || curve.Fastest != tt.fastest || curve.Recommended != tt.recommended {
+				t.Errorf("got curve %+v, want %d points, fastest %d and recommended %d", curve, tt.points, tt.fastest, tt.recommended)
+			}
+		})
+	}
+}
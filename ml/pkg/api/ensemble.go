@@ -0,0 +1,246 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Modes of an ensemble inference, which combine the outputs
+// of its models for each datapoint
+const (
+	// EnsembleMean averages the probabilities of the classes given by the models,
+	// computed with a softmax of their logits, and predicts the most probable class
+	EnsembleMean = "mean"
+	// EnsembleVote predicts the value predicted by most models,
+	// ties are broken in favor of the model listed first
+	EnsembleVote = "vote"
+)
+
+// EnsembleResult is the result of an ensemble inference. Probabilities are the mean
+// probabilities of the classes of each datapoint in mean mode, and Individual the
+// outputs of each model, logits in mean mode, if the request includes them
+type EnsembleResult struct {
+	Predictions   []interface{}            `json:"predictions"`
+	Probabilities [][]float64              `json:"probabilities,omitempty"`
+	Individual    map[string][]interface{} `json:"individual,omitempty"`
+}
+
+// IsEnsemble returns whether the request combines the outputs of several models
+func (r *InferRequest) IsEnsemble() bool {
+	return len(r.ModelIds) > 0
+}
+
+// Models returns the ids of the models the request is inferred with
+func (r *InferRequest) Models() []string {
+	if r.IsEnsemble() {
+		return r.ModelIds
+	}
+	return []string{r.ModelId}
+}
+
+// EnsembleOutput returns the output requested to each of the models of the
+// ensemble, logits to compute the probabilities averaged in mean mode
+func (r *InferRequest) EnsembleOutput() string {
+	if r.Ensemble == EnsembleMean {
+		return OutputLogits
+	}
+	return OutputPrediction
+}
+
+// ValidateEnsemble checks the models and the mode of an ensemble inference
+func (r *InferRequest) ValidateEnsemble() error {
+	if !r.IsEnsemble() {
+		if len(r.Ensemble) > 0 {
+			return fmt.Errorf("ensemble mode %s needs the ids of the models of the ensemble", r.Ensemble)
+		}
+		return nil
+	}
+
+	if len(r.ModelId) > 0 {
+		return fmt.Errorf("set either the model id or the ids of the models of the ensemble, not both")
+	}
+	if len(r.ModelIds) < 2 {
+		return fmt.Errorf("an ensemble needs at least 2 models, got %d", len(r.ModelIds))
+	}
+	seen := make(map[string]bool, len(r.ModelIds))
+	for _, id := range r.ModelIds {
+		if len(id) == 0 {
+			return fmt.Errorf("the ids of the models of the ensemble should not be empty")
+		}
+		if seen[id] {
+			return fmt.Errorf("model %s is repeated in the ensemble", id)
+		}
+		seen[id] = true
+	}
+
+	switch r.Ensemble {
+	case EnsembleMean, EnsembleVote:
+	default:
+		return fmt.Errorf("ensemble mode should be %s or %s, got \"%s\"", EnsembleMean, EnsembleVote, r.Ensemble)
+	}
+	if r.Output() != OutputPrediction {
+		return fmt.Errorf("ensembles return predictions, output type %s is not supported", r.OutputType)
+	}
+	if r.AllowPartial {
+		return fmt.Errorf("ensembles do not allow partial results")
+	}
+	return nil
+}
+
+// CombineEnsemble combines the outputs of the models of the ensemble for each
+// datapoint, outputs holding the ones of each model in the order of the request.
+// Models whose outputs do not match in number or shape fail the combination
+func (r *InferRequest) CombineEnsemble(outputs [][]interface{}) (*EnsembleResult, error) {
+	for i, out := range outputs {
+		if len(out) != len(r.Data) {
+			return nil, fmt.Errorf("model %s returned %d outputs for %d datapoints",
+				r.ModelIds[i], len(out), len(r.Data))
+		}
+	}
+
+	result := &EnsembleResult{}
+	var err error
+	if r.Ensemble == EnsembleMean {
+		result.Predictions, result.Probabilities, err = combineMean(r.ModelIds, outputs)
+	} else {
+		result.Predictions, err = combineVote(r.ModelIds, outputs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if r.IncludeIndividual {
+		result.Individual = make(map[string][]interface{}, len(outputs))
+		for i, out := range outputs {
+			result.Individual[r.ModelIds[i]] = out
+		}
+	}
+	return result, nil
+}
+
+// combineMean averages the softmax of the logits of the models for each datapoint
+// and predicts the index of the most probable class
+func combineMean(models []string, outputs [][]interface{}) ([]interface{}, [][]float64, error) {
+	n := len(outputs[0])
+	predictions := make([]interface{}, n)
+	probabilities := make([][]float64, n)
+	for j := 0; j < n; j++ {
+		var mean []float64
+		for i, out := range outputs {
+			logits, ok := toFloats(out[j])
+			if !ok {
+				return nil, nil, fmt.Errorf("model %s did not return an array of logits for datapoint %d", models[i], j)
+			}
+			if i == 0 {
+				mean = make([]float64, len(logits))
+			} else if len(logits) != len(mean) {
+				return nil, nil, fmt.Errorf("models %s and %s returned %d and %d logits for datapoint %d, "+
+					"the models of the ensemble should predict the same classes", models[0], models[i], len(mean), len(logits), j)
+			}
+			for k, p := range softmax(logits) {
+				mean[k] += p / float64(len(outputs))
+			}
+		}
+
+		best := 0
+		for k := range mean {
+			if mean[k] > mean[best] {
+				best = k
+			}
+		}
+		predictions[j], probabilities[j] = best, mean
+	}
+	return predictions, probabilities, nil
+}
+
+// combineVote predicts for each datapoint the value predicted by most models,
+// the first model with the most voted value breaks the ties
+func combineVote(models []string, outputs [][]interface{}) ([]interface{}, error) {
+	n := len(outputs[0])
+	predictions := make([]interface{}, n)
+	for j := 0; j < n; j++ {
+		shape := shapeOf(outputs[0][j])
+		keys := make([]string, len(outputs))
+		votes := make(map[string]int, len(outputs))
+		for i, out := range outputs {
+			if s := shapeOf(out[j]); !reflect.DeepEqual(s, shape) {
+				return nil, fmt.Errorf("models %s and %s returned predictions of shape %v and %v for datapoint %d",
+					models[0], models[i], shape, s, j)
+			}
+			key, err := json.Marshal(out[j])
+			if err != nil {
+				return nil, fmt.Errorf("could not compare the prediction of model %s for datapoint %d: %v", models[i], j, err)
+			}
+			keys[i] = string(key)
+			votes[keys[i]]++
+		}
+
+		winner := 0
+		for i := range outputs {
+			if votes[keys[i]] > votes[keys[winner]] {
+				winner = i
+			}
+		}
+		predictions[j] = outputs[winner][j]
+	}
+	return predictions, nil
+}
+
+// softmax returns the probabilities given by the logits
+func softmax(logits []float64) []float64 {
+	max := math.Inf(-1)
+	for _, l := range logits {
+		max = math.Max(max, l)
+	}
+	var sum float64
+	probs := make([]float64, len(logits))
+	for i, l := range logits {
+		probs[i] = math.Exp(l - max)
+		sum += probs[i]
+	}
+	for i := range probs {
+		probs[i] /= sum
+	}
+	return probs
+}
+
+// toFloats returns the numbers of a flat array, false if it is not one
+func toFloats(value interface{}) ([]float64, bool) {
+	values, ok := value.([]interface{})
+	if !ok || len(values) == 0 {
+		return nil, false
+	}
+	floats := make([]float64, len(values))
+	for i, v := range values {
+		if _, nested := v.([]interface{}); nested {
+			return nil, false
+		}
+		err := walkValues(v, func(f float64) error {
+			floats[i] = f
+			return nil
+		})
+		if err != nil {
+			return nil, false
+		}
+	}
+	return floats, true
+}
+
+// shapeOf returns the length of each dimension of a prediction,
+// following the first element of nested arrays, empty for scalars
+func shapeOf(value interface{}) []int {
+	shape := []int{}
+	for {
+		values, ok := value.([]interface{})
+		if !ok {
+			return shape
+		}
+		shape = append(shape, len(values))
+		if len(values) == 0 {
+			return shape
+		}
+		value = values[0]
+	}
+}
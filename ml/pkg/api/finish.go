@@ -0,0 +1,48 @@
+package api
+
+import "time"
+
+// Lifecycle of the standalone jobs, which run the job api in a pod of their
+// own and exit once the job finishes and the parameter server knows
+const (
+	// Exit codes of the job pods, so the status of the pod reflects the job
+	JobExitCompleted = 0
+	JobExitFailed    = 1
+	JobExitStopped   = 2
+
+	// JobFinishRetries is the number of times a standalone job retries
+	// reporting its finish to the parameter server before leaving a marker
+	JobFinishRetries = 5
+
+	// JobShutdownTimeout bounds the time the job api waits for the
+	// requests in flight before the pod exits
+	JobShutdownTimeout = 30 * time.Second
+
+	// UnreportedFinishesCollection holds the markers of the jobs that
+	// could not report their finish to the parameter server
+	UnreportedFinishesCollection = "unreported_finishes"
+
+	// FinishReconcileInterval is how often the parameter server
+	// looks for the markers of the jobs that finished unreported
+	FinishReconcileInterval = time.Minute
+)
+
+// UnreportedFinish is written by a standalone job that finished but could not
+// report it to the parameter server, which finishes the job when it finds it
+type UnreportedFinish struct {
+	JobId    string    `bson:"_id" json:"job_id"`
+	Error    string    `json:"error,omitempty"`
+	Finished time.Time `json:"finished"`
+}
+
+// JobExitCode returns the exit code of the pod of a job that ended with the error
+func JobExitCode(exitErr error) int {
+	switch {
+	case exitErr == nil:
+		return JobExitCompleted
+	case exitErr.Error() == ForceStoppedError:
+		return JobExitStopped
+	default:
+		return JobExitFailed
+	}
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of the exponential buckets of the histograms of the durations of the
+// function invocations, from 0.1 seconds doubling up to 819.2 seconds
+const (
+	DefaultDurationBucketStart  = 0.1
+	DefaultDurationBucketFactor = 2
+	DefaultDurationBucketCount  = 14
+
+	// MaxDurationBuckets is the most buckets a histogram can have
+	MaxDurationBuckets = 64
+)
+
+type (
+	// DurationBuckets are the exponential buckets of the histograms of the
+	// durations of the invocations, Count upper bounds in seconds starting
+	// at Start, each Factor times the one before
+	DurationBuckets struct {
+		Start  float64 `json:"start"`
+		Factor float64 `json:"factor"`
+		Count  int     `json:"count"`
+	}
+
+	// DurationHistogram counts the durations in seconds of the invocations of a
+	// task. Counts has one count per bucket, the durations up to its bound and
+	// over the one before, plus the durations over the last bound
+	DurationHistogram struct {
+		Bounds []float64 `json:"bounds"`
+		Counts []uint64  `json:"counts"`
+		Sum    float64   `json:"sum"`
+		Count  uint64    `json:"count"`
+	}
+
+	// DurationPercentiles are the percentiles in seconds
+	// of the durations of the invocations of a task
+	DurationPercentiles struct {
+		P50   float64 `json:"p50"`
+		P95   float64 `json:"p95"`
+		P99   float64 `json:"p99"`
+		Count uint64  `json:"count"`
+	}
+
+	// EpochDurations are the percentiles of the durations of the invocations of each
+	// task in an epoch, keyed by the task. The first epoch also counts the init function
+	EpochDurations struct {
+		Epoch int                            `json:"epoch"`
+		Tasks map[string]DurationPercentiles `json:"tasks"`
+	}
+
+	// StragglerTimeout is the duration after which an invocation is taken as a
+	// straggler, either Absolute or Factor times the Percentile of the durations
+	// of the invocations of the previous epoch
+	StragglerTimeout struct {
+		Absolute   time.Duration
+		Percentile float64
+		Factor     float64
+	}
+)
+
+// DurationBucketsOrDefault returns the buckets of the duration histograms of the job
+func (o TrainOptions) DurationBucketsOrDefault() DurationBuckets {
+	if o.DurationBuckets == nil {
+		return DurationBuckets{
+			Start:  DefaultDurationBucketStart,
+			Factor: DefaultDurationBucketFactor,
+			Count:  DefaultDurationBucketCount,
+		}
+	}
+	return *o.DurationBuckets
+}
+
+// ValidateDurationBuckets checks that the buckets of the duration histograms grow
+func (o TrainOptions) ValidateDurationBuckets() error {
+	if o.DurationBuckets == nil {
+		return nil
+	}
+	b := *o.DurationBuckets
+	switch {
+	case b.Start <= 0:
+		return fmt.Errorf("the first duration bucket should be positive, got %v", b.Start)
+	case b.Factor <= 1:
+		return fmt.Errorf("the factor of the duration buckets should be over 1, got %v", b.Factor)
+	case b.Count < 1 || b.Count > MaxDurationBuckets:
+		return fmt.Errorf("the number of duration buckets should be between 1 and %d, got %d",
+			MaxDurationBuckets, b.Count)
+	}
+	return nil
+}
+
+// ParseDurationBuckets parses the buckets written as start,factor,count
+func ParseDurationBuckets(s string) (*DurationBuckets, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid duration buckets \"%s\", expected start,factor,count", s)
+	}
+
+	var b DurationBuckets
+	var err error
+	if b.Start, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64); err != nil {
+		return nil, fmt.Errorf("invalid start of the duration buckets \"%s\"", parts[0])
+	}
+	if b.Factor, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64); err != nil {
+		return nil, fmt.Errorf("invalid factor of the duration buckets \"%s\"", parts[1])
+	}
+	if b.Count, err = strconv.Atoi(strings.TrimSpace(parts[2])); err != nil {
+		return nil, fmt.Errorf("invalid count of the duration buckets \"%s\"", parts[2])
+	}
+	return &b, nil
+}
+
+// Bounds returns the upper bounds of the buckets in seconds
+func (b DurationBuckets) Bounds() []float64 {
+	bounds := make([]float64, b.Count)
+	bound := b.Start
+	for i := range bounds {
+		bounds[i] = bound
+		bound *= b.Factor
+	}
+	return bounds
+}
+
+// NewDurationHistogram creates an empty histogram with the upper bounds
+func NewDurationHistogram(bounds []float64) *DurationHistogram {
+	return &DurationHistogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe counts a duration in seconds
+func (h *DurationHistogram) Observe(seconds float64) {
+	i := 0
+	for i < len(h.Bounds) && seconds > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Sum += seconds
+	h.Count++
+}
+
+// Merge adds the counts of another histogram with the same bounds
+func (h *DurationHistogram) Merge(other *DurationHistogram) error {
+	if len(other.Counts) != len(h.Counts) {
+		return fmt.Errorf("can't merge histograms with %d and %d buckets", len(h.Counts), len(other.Counts))
+	}
+	for i, c := range other.Counts {
+		h.Counts[i] += c
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+	return nil
+}
+
+// Clone returns a copy of the histogram
+func (h *DurationHistogram) Clone() *DurationHistogram {
+	return &DurationHistogram{
+		Bounds: h.Bounds,
+		Counts: append([]uint64(nil), h.Counts...),
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+}
+
+// Quantile estimates the q quantile of the durations, between 0 and 1, interpolating
+// linearly inside the bucket it falls in as prometheus does, with the first bucket
+// starting at 0. Durations over the last bound are estimated as the last bound, and
+// an empty histogram has a quantile of 0
+func (h *DurationHistogram) Quantile(q float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	q = math.Max(0, math.Min(1, q))
+
+	rank := q * float64(h.Count)
+	var cumulative uint64
+	for i, c := range h.Counts {
+		if c == 0 || float64(cumulative+c) < rank {
+			cumulative += c
+			continue
+		}
+		if i == len(h.Bounds) {
+			break
+		}
+		var lower float64
+		if i > 0 {
+			lower = h.Bounds[i-1]
+		}
+		return lower + (h.Bounds[i]-lower)*(rank-float64(cumulative))/float64(c)
+	}
+
+	if len(h.Bounds) == 0 {
+		return h.Sum / float64(h.Count)
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// Percentiles returns the p50, p95 and p99 of the durations
+func (h *DurationHistogram) Percentiles() DurationPercentiles {
+	return DurationPercentiles{
+		P50:   h.Quantile(0.50),
+		P95:   h.Quantile(0.95),
+		P99:   h.Quantile(0.99),
+		Count: h.Count,
+	}
+}
+
+// ParseStragglerTimeout parses a straggler timeout, either a duration like 90s or a
+// percentile of the previous epoch times a factor like p95x1.5, also written p95×1.5
+func ParseStragglerTimeout(s string) (StragglerTimeout, error) {
+	if !strings.HasPrefix(s, "p") {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return StragglerTimeout{}, fmt.Errorf("invalid straggler timeout \"%s\", expected a positive duration like 90s or a percentile like p95x1.5", s)
+		}
+		return StragglerTimeout{Absolute: d}, nil
+	}
+
+	parts := strings.FieldsFunc(s[1:], func(r rune) bool { return r == 'x' || r == '×' })
+	if len(parts) != 2 {
+		return StragglerTimeout{}, fmt.Errorf("invalid straggler timeout \"%s\", expected a percentile times a factor like p95x1.5", s)
+	}
+	percentile, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || percentile <= 0 || percentile >= 100 {
+		return StragglerTimeout{}, fmt.Errorf("invalid percentile of the straggler timeout \"%s\", expected between 0 and 100", parts[0])
+	}
+	factor, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || factor <= 0 {
+		return StragglerTimeout{}, fmt.Errorf("invalid factor of the straggler timeout \"%s\", expected a positive number", parts[1])
+	}
+	return StragglerTimeout{Percentile: percentile, Factor: factor}, nil
+}
+
+// ValidateStragglerTimeout checks that the straggler timeout can be parsed
+func (o TrainOptions) ValidateStragglerTimeout() error {
+	if len(o.StragglerTimeout) == 0 {
+		return nil
+	}
+	_, err := ParseStragglerTimeout(o.StragglerTimeout)
+	return err
+}
+
+// Relative returns true if the timeout depends on the durations of the previous epoch
+func (t StragglerTimeout) Relative() bool {
+	return t.Absolute == 0
+}
+
+// Threshold returns the timeout evaluated against the histogram of the durations
+// of the previous epoch, and false if it is relative and there are none yet
+func (t StragglerTimeout) Threshold(previous *DurationHistogram) (time.Duration, bool) {
+	if !t.Relative() {
+		return t.Absolute, true
+	}
+	if previous == nil || previous.Count == 0 {
+		return 0, false
+	}
+	seconds := previous.Quantile(t.Percentile/100) * t.Factor
+	return time.Duration(seconds * float64(time.Second)), true
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// histogram returns a histogram with the bounds observing the durations
+func histogram(bounds []float64, durations ...float64) *DurationHistogram {
+	h := NewDurationHistogram(bounds)
+	for _, d := range durations {
+		h.Observe(d)
+	}
+	return h
+}
+
+func TestDurationBucketsBounds(t *testing.T) {
+	got := TrainOptions{}.DurationBucketsOrDefault().Bounds()
+	if len(got) != DefaultDurationBucketCount || got[0] != 0.1 || math.Abs(got[len(got)-1]-819.2) > 1e-9 {
+		t.Errorf("got default bounds %v, want 14 from 0.1 to 819.2", got)
+	}
+	if got = (DurationBuckets{Start: 1, Factor: 3, Count: 4}).Bounds(); !reflect.DeepEqual(got, []float64{1, 3, 9, 27}) {
+		t.Errorf("got bounds %v, want [1 3 9 27]", got)
+	}
+}
+
+func TestDurationHistogramObserve(t *testing.T) {
+	// a duration on a bound is counted in its bucket
+	h := histogram([]float64{1, 2, 4}, 0, 0.5, 1, 1.5, 4, 4.1, 100)
+	if want := []uint64{3, 1, 1, 2}; !reflect.DeepEqual(h.Counts, want) {
+		t.Errorf("got counts %v, want %v", h.Counts, want)
+	}
+	if h.Count != 7 || math.Abs(h.Sum-111.1) > 1e-9 {
+		t.Errorf("got count %d and sum %v, want 7 and 111.1", h.Count, h.Sum)
+	}
+}
+
+func TestDurationHistogramQuantile(t *testing.T) {
+	bounds := []float64{1, 2, 4, 8}
+	tests := []struct {
+		name string
+		h    *DurationHistogram
+		q    float64
+		want float64
+	}{
+		{"empty", histogram(bounds), 0.5, 0},
+		// 4 durations in (2, 4], the median is half way in the bucket
+		{"one bucket median", histogram(bounds, 3, 3, 3, 3), 0.5, 3},
+		{"one bucket p95", histogram(bounds, 3, 3, 3, 3), 0.95, 3.9},
+		{"minimum", histogram(bounds, 3, 3, 3, 3), 0, 2},
+		{"maximum", histogram(bounds, 3, 3, 3, 3), 1, 4},
+		// 10 durations: 5 in (0, 1], 4 in (1, 2], 1 in (4, 8]
+		{"first bucket", histogram(bounds, .5, .5, .5, .5, .5, 1.5, 1.5, 1.5, 1.5, 6), 0.3, 0.6},
+		{"on the edge of a bucket", histogram(bounds, .5, .5, .5, .5, .5, 1.5, 1.5, 1.5, 1.5, 6), 0.5, 1},
+		{"skipping empty buckets", histogram(bounds, .5, .5, .5, .5, .5, 1.5, 1.5, 1.5, 1.5, 6), 0.95, 6},
+		{"tail", histogram(bounds, .5, .5, .5, .5, .5, 1.5, 1.5, 1.5, 1.5, 6), 0.99, 7.6},
+		{"over the last bound", histogram(bounds, 1, 100, 100, 100), 0.5, 8},
+		{"quantile over 1", histogram(bounds, 3), 2, 4},
+		{"negative quantile", histogram(bounds, 3), -1, 2},
+		{"no bounds", histogram(nil, 2, 4), 0.5, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.h.Quantile(tt.q); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got quantile %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDurationHistogramPercentiles(t *testing.T) {
+	// 100 durations, one per bucket of (0, 1], ..., (99, 100]
+	bounds := make([]float64, 100)
+	h := NewDurationHistogram(bounds)
+	for i := range bounds {
+		bounds[i] = float64(i + 1)
+	}
+	for i := 0; i < 100; i++ {
+		h.Observe(float64(i) + 0.5)
+	}
+
+	want := DurationPercentiles{P50: 50, P95: 95, P99: 99, Count: 100}
+	if got := h.Percentiles(); got != want {
+		t.Errorf("got percentiles %+v, want %+v", got, want)
+	}
+}
+
+func TestDurationHistogramMerge(t *testing.T) {
+	bounds := []float64{1, 2}
+	h := histogram(bounds, 0.5, 3)
+	clone := h.Clone()
+	if err := h.Merge(histogram(bounds, 1.5, 1.5)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{1, 2, 1}; !reflect.DeepEqual(h.Counts, want) || h.Count != 4 || h.Sum != 6.5 {
+		t.Errorf("got counts %v, count %d and sum %v, want %v, 4 and 6.5", h.Counts, h.Count, h.Sum, want)
+	}
+
+	// the clone does not share the counts
+	if clone.Count != 2 || clone.Counts[1] != 0 {
+		t.Errorf("got clone %+v changed by the merge", clone)
+	}
+	if err := h.Merge(histogram([]float64{1})); err == nil {
+		t.Error("got no error merging histograms of other buckets")
+	}
+}
+
+func TestParseDurationBuckets(t *testing.T) {
+	tests := []struct {
+		s         string
+		want      *DurationBuckets
+		wantError bool
+	}{
+		{"0.5,2,10", &DurationBuckets{Start: 0.5, Factor: 2, Count: 10}, false},
+		{" 1 , 1.5 , 20 ", &DurationBuckets{Start: 1, Factor: 1.5, Count: 20}, false},
+		{"1,2", nil, true},
+		{"a,2,10", nil, true},
+		{"1,b,10", nil, true},
+		{"1,2,1.5", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDurationBuckets(tt.s)
+		if (err != nil) != tt.wantError || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got buckets %+v and error %v, want %+v", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidateDurationBuckets(t *testing.T) {
+	tests := []struct {
+		name      string
+		buckets   *DurationBuckets
+		wantError bool
+	}{
+		{"default", nil, false},
+		{"valid", &DurationBuckets{Start: 0.1, Factor: 2, Count: 10}, false},
+		{"zero start", &DurationBuckets{Start: 0, Factor: 2, Count: 10}, true},
+		{"factor of 1", &DurationBuckets{Start: 0.1, Factor: 1, Count: 10}, true},
+		{"no buckets", &DurationBuckets{Start: 0.1, Factor: 2, Count: 0}, true},
+		{"too many buckets", &DurationBuckets{Start: 0.1, Factor: 2, Count: MaxDurationBuckets + 1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (TrainOptions{DurationBuckets: tt.buckets}).ValidateDurationBuckets(); (err != nil) != tt.wantError {
+				t.Errorf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestParseStragglerTimeout(t *testing.T) {
+	tests := []struct {
+		s         string
+		want      StragglerTimeout
+		wantError bool
+	}{
+		{"90s", StragglerTimeout{Absolute: 90 * time.Second}, false},
+		{"p95x1.5", StragglerTimeout{Percentile: 95, Factor: 1.5}, false},
+		{"p99.9×2", StragglerTimeout{Percentile: 99.9, Factor: 2}, false},
+		{"0s", StragglerTimeout{}, true},
+		{"soon", StragglerTimeout{}, true},
+		{"p95", StragglerTimeout{}, true},
+		{"p100x2", StragglerTimeout{}, true},
+		{"p0x2", StragglerTimeout{}, true},
+		{"p95x0", StragglerTimeout{}, true},
+		{"p95x1.5x2", StragglerTimeout{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseStragglerTimeout(tt.s)
+		if (err != nil) != tt.wantError || got != tt.want {
+			t.Errorf("%q: got timeout %+v and error %v, want %+v", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestStragglerTimeoutThreshold(t *testing.T) {
+	previous := histogram([]float64{1, 2, 4, 8}, 3, 3, 3, 3)
+
+	tests := []struct {
+		name     string
+		timeout  StragglerTimeout
+		previous *DurationHistogram
+		want     time.Duration
+		ok       bool
+	}{
+		{"absolute", StragglerTimeout{Absolute: time.Minute}, nil, time.Minute, true},
+		{"relative", StragglerTimeout{Percentile: 50, Factor: 2}, previous, 6 * time.Second, true},
+		{"relative first epoch", StragglerTimeout{Percentile: 50, Factor: 2}, nil, 0, false},
+		{"relative no invocations", StragglerTimeout{Percentile: 50, Factor: 2}, histogram([]float64{1}), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.timeout.Threshold(tt.previous)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got threshold %v and %v, want %v and %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Names of the metrics kept in the job history
+const (
+	MetricValidationLoss = "validation_loss"
+	MetricAccuracy       = "accuracy"
+	MetricTrainLoss      = "train_loss"
+	MetricParallelism    = "parallelism"
+	MetricEpochDuration  = "epoch_duration"
+	MetricCanaryLoss     = "canary_loss"
+	MetricCanaryAccuracy = "canary_accuracy"
+
+	MetricValidationFunctions  = "validation_functions"
+	MetricValidationMerges     = "validation_merges"
+	MetricIterations           = "iterations"
+	MetricGlobalBatch          = "global_batch"
+	MetricLearningRate         = "learning_rate"
+	MetricGradNorm             = "grad_norm"
+	MetricStaleNotifications   = "stale_notifications"
+	MetricMergeWait            = "merge_wait"
+	MetricBarrierWait          = "barrier_wait"
+	MetricEffectiveParallelism = "effective_parallelism"
+	MetricEpochCost            = "epoch_cost"
+	MetricMeasuredConcurrency  = "measured_concurrency"
+	MetricK                    = "k"
+	MetricMergeOverhead        = "merge_overhead"
+)
+
+// Directions in which a metric improves
+const (
+	DirectionMaximize = "maximize"
+	DirectionMinimize = "minimize"
+)
+
+// Default decimal places of the metrics saved in the history
+const (
+	DefaultAccuracyDecimals = 4
+	DefaultLossDecimals     = 6
+)
+
+// SetEpochMetric sets the value of a metric for the given epoch (starting at 1).
+//
+// Setting the same metric twice for an epoch overwrites the previous value, so
+// updates that are retried or received twice do not grow the series. Metrics
+// recorded every epoch are indexed by epoch, while the validation metrics are
+// indexed by the position of the epoch in ValidationEpochs
+func (h *JobHistory) SetEpochMetric(metric string, epoch int, value float64) error {
+	if epoch < 1 {
+		return fmt.Errorf("invalid epoch %d for metric %s", epoch, metric)
+	}
+
+	switch metric {
+	case MetricValidationLoss:
+		h.ValidationLoss = setAt(h.ValidationLoss, h.validationIndex(epoch), value)
+	case MetricAccuracy:
+		h.Accuracy = setAt(h.Accuracy, h.validationIndex(epoch), value)
+	case MetricValidationFunctions:
+		h.ValidationFunctions = setAt(h.ValidationFunctions, h.validationIndex(epoch), value)
+	case MetricValidationMerges:
+		h.ValidationMerges = setAt(h.ValidationMerges, h.validationIndex(epoch), value)
+	case MetricTrainLoss:
+		h.TrainLoss = setAt(h.TrainLoss, epoch-1, value)
+	case MetricParallelism:
+		h.Parallelism = setAt(h.Parallelism, epoch-1, value)
+	case MetricEpochDuration:
+		h.EpochDuration = setAt(h.EpochDuration, epoch-1, value)
+	case MetricCanaryLoss:
+		h.CanaryLoss = setAt(h.CanaryLoss, epoch-1, value)
+	case MetricCanaryAccuracy:
+		h.CanaryAccuracy = setAt(h.CanaryAccuracy, epoch-1, value)
+	case MetricIterations:
+		h.Iterations = setAt(h.Iterations, epoch-1, value)
+	case MetricGlobalBatch:
+		h.GlobalBatch = setAt(h.GlobalBatch, epoch-1, value)
+	case MetricLearningRate:
+		h.LearningRate = setAt(h.LearningRate, epoch-1, value)
+	case MetricGradNorm:
+		h.GradNorm = setAt(h.GradNorm, epoch-1, value)
+	case MetricStaleNotifications:
+		h.StaleNotifications = setAt(h.StaleNotifications, epoch-1, value)
+	case MetricMergeWait:
+		h.MergeWait = setAt(h.MergeWait, epoch-1, value)
+	case MetricBarrierWait:
+		h.BarrierWait = setAt(h.BarrierWait, epoch-1, value)
+	case MetricEffectiveParallelism:
+		h.EffectiveParallelism = setAt(h.EffectiveParallelism, epoch-1, value)
+	case MetricEpochCost:
+		h.EpochCost = setAt(h.EpochCost, epoch-1, value)
+	case MetricMeasuredConcurrency:
+		h.MeasuredConcurrency = setAt(h.MeasuredConcurrency, epoch-1, value)
+	case MetricK:
+		h.K = setAt(h.K, epoch-1, value)
+	case MetricMergeOverhead:
+		h.MergeOverhead = setAt(h.MergeOverhead, epoch-1, value)
+	default:
+		return fmt.Errorf("unknown metric %s", metric)
+	}
+
+	return nil
+}
+
+// MetricDecimals returns the decimal places the metric is rounded to,
+// or -1 if the metric is not rounded
+func (o TrainOptions) MetricDecimals(metric string) int {
+	switch metric {
+	case MetricAccuracy, MetricCanaryAccuracy:
+		if o.AccuracyDecimals > 0 {
+			return o.AccuracyDecimals
+		}
+		return DefaultAccuracyDecimals
+	case MetricTrainLoss, MetricValidationLoss, MetricCanaryLoss:
+		if o.LossDecimals > 0 {
+			return o.LossDecimals
+		}
+		return DefaultLossDecimals
+	default:
+		return -1
+	}
+}
+
+// Direction returns whether the metric improves when it is maximized or minimized.
+// The accuracies are maximized and every other metric is minimized unless
+// the direction is set in the options
+func (o TrainOptions) Direction(metric string) string {
+	if d, exists := o.MetricDirection[metric]; exists {
+		return d
+	}
+
+	switch metric {
+	case MetricAccuracy, MetricCanaryAccuracy:
+		return DirectionMaximize
+	default:
+		return DirectionMinimize
+	}
+}
+
+// Better returns true if the value a of the metric improves on b
+func (o TrainOptions) Better(metric string, a, b float64) bool {
+	if o.Direction(metric) == DirectionMaximize {
+		return a > b
+	}
+	return a < b
+}
+
+// GoalReached returns true if the value of the metric reached the goal
+func (o TrainOptions) GoalReached(metric string, value, goal float64) bool {
+	if o.Direction(metric) == DirectionMaximize {
+		return value >= goal
+	}
+	return value <= goal
+}
+
+// ValidateMetricDirection checks that the directions set
+// in the options are either maximize or minimize
+func (o TrainOptions) ValidateMetricDirection() error {
+	metrics := make([]string, 0, len(o.MetricDirection))
+	for metric := range o.MetricDirection {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	for _, metric := range metrics {
+		d := o.MetricDirection[metric]
+		if d != DirectionMaximize && d != DirectionMinimize {
+			return fmt.Errorf("direction of metric %s should be %s or %s, got \"%s\"",
+				metric, DirectionMaximize, DirectionMinimize, d)
+		}
+	}
+	return nil
+}
+
+// Series returns the values of a metric along with the epoch
+// each one was recorded in, or nil if the metric is unknown
+func (h *JobHistory) Series(metric string) ([]float64, []int) {
+	var values []float64
+	validation := false
+	switch metric {
+	case MetricValidationLoss:
+		values, validation = h.ValidationLoss, true
+	case MetricAccuracy:
+		values, validation = h.Accuracy, true
+	case MetricValidationFunctions:
+		values, validation = h.ValidationFunctions, true
+	case MetricValidationMerges:
+		values, validation = h.ValidationMerges, true
+	case MetricTrainLoss:
+		values = h.TrainLoss
+	case MetricParallelism:
+		values = h.Parallelism
+	case MetricEpochDuration:
+		values = h.EpochDuration
+	case MetricCanaryLoss:
+		values = h.CanaryLoss
+	case MetricCanaryAccuracy:
+		values = h.CanaryAccuracy
+	case MetricIterations:
+		values = h.Iterations
+	case MetricGlobalBatch:
+		values = h.GlobalBatch
+	case MetricLearningRate:
+		values = h.LearningRate
+	case MetricGradNorm:
+		values = h.GradNorm
+	case MetricStaleNotifications:
+		values = h.StaleNotifications
+	case MetricMergeWait:
+		values = h.MergeWait
+	case MetricBarrierWait:
+		values = h.BarrierWait
+	case MetricEffectiveParallelism:
+		values = h.EffectiveParallelism
+	case MetricEpochCost:
+		values = h.EpochCost
+	case MetricMeasuredConcurrency:
+		values = h.MeasuredConcurrency
+	case MetricK:
+		values = h.K
+	case MetricMergeOverhead:
+		values = h.MergeOverhead
+	default:
+		return nil, nil
+	}
+
+	epochs := make([]int, len(values))
+	for i := range values {
+		if !validation {
+			epochs[i] = i + 1
+		} else if i < len(h.ValidationEpochs) {
+			epochs[i] = h.ValidationEpochs[i]
+		}
+	}
+	return values, epochs
+}
+
+// MetricSeries is the series of a single metric of a job, along
+// with the epoch each value was recorded in
+type MetricSeries struct {
+	JobId  string    `json:"job_id"`
+	Metric string    `json:"metric"`
+	Epochs []int     `json:"epochs"`
+	Values []float64 `json:"values"`
+}
+
+// MetricSeries returns the values of the metric recorded after sinceEpoch, so a
+// series can be followed by asking only for the epochs not seen yet. Returns an
+// error if the metric is unknown or the job never recorded it
+func (h *History) MetricSeries(metric string, sinceEpoch int) (*MetricSeries, error) {
+	values, epochs := h.Data.Series(metric)
+	// the epochs are only nil for the metrics that are not known
+	if epochs == nil {
+		return nil, fmt.Errorf("unknown metric %s", metric)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("job %s has no values of metric %s", h.Id, metric)
+	}
+
+	series := &MetricSeries{JobId: h.Id, Metric: metric, Epochs: []int{}, Values: []float64{}}
+	for i, value := range values {
+		if epochs[i] > sinceEpoch {
+			series.Epochs = append(series.Epochs, epochs[i])
+			series.Values = append(series.Values, value)
+		}
+	}
+	return series, nil
+}
+
+// Best returns the best value of a metric in the history following the direction
+// of the metric in the options, along with the epoch it was recorded in.
+// Returns false if the metric has no values
+func (h *JobHistory) Best(metric string, o TrainOptions) (float64, int, bool) {
+	values, epochs := h.Series(metric)
+	if len(values) == 0 {
+		return 0, 0, false
+	}
+
+	best := 0
+	for i := range values {
+		if o.Better(metric, values[i], values[best]) {
+			best = i
+		}
+	}
+	return values[best], epochs[best], true
+}
+
+// TotalBarrierWait returns the seconds the train functions spent blocked at the barrier of
+// the merges over the whole job, and their share of the function seconds of the epochs
+// that recorded it. Returns false if the job did not record the barrier wait
+func (h *JobHistory) TotalBarrierWait() (float64, float64, bool) {
+	if len(h.BarrierWait) == 0 {
+		return 0, 0, false
+	}
+
+	var wait float64
+	for _, w := range h.BarrierWait {
+		wait += w
+	}
+	var share float64
+	if seconds := h.FunctionSeconds(len(h.BarrierWait)); seconds > 0 {
+		share = wait / seconds
+	}
+	return wait, share, true
+}
+
+// RoundMetric rounds the value to the given decimal places,
+// a negative number of decimals leaves the value untouched
+func RoundMetric(value float64, decimals int) float64 {
+	if decimals < 0 {
+		return value
+	}
+	p := math.Pow(10, float64(decimals))
+	return math.Round(value*p) / p
+}
+
+// validationIndex returns the index of the epoch in the validation
+// series, adding the epoch if it was not validated before
+func (h *JobHistory) validationIndex(epoch int) int {
+	for i, e := range h.ValidationEpochs {
+		if e == epoch {
+			return i
+		}
+	}
+	h.ValidationEpochs = append(h.ValidationEpochs, epoch)
+	return len(h.ValidationEpochs) - 1
+}
+
+// setAt sets the value at index i of the series, extending it with
+// zeros if some previous entries are missing
+func setAt(series []float64, i int, value float64) []float64 {
+	for len(series) <= i {
+		series = append(series, 0)
+	}
+	series[i] = value
+	return series
+}
+
+// Migrate fills the epochs of the validation metrics for histories saved
+// before they were recorded, when the metrics were only appended.
+//
+// Validations were run every ValidateEvery epochs and after the last epoch, so
+// the epochs are recovered from the options of the task. If they do not match
+// the number of validations the epochs are left empty
+func (h *History) Migrate() {
+	data := &h.Data
+	if len(data.ValidationEpochs) != 0 || len(data.Accuracy) == 0 {
+		return
+	}
+
+	trained := len(data.TrainLoss)
+	var epochs []int
+	if every := h.Task.Options.ValidateEvery; every > 0 {
+		for e := every; e < trained; e += every {
+			epochs = append(epochs, e)
+		}
+	}
+	epochs = append(epochs, trained)
+
+	// jobs that reached the goal accuracy skipped the last validation
+	if len(epochs) > len(data.Accuracy) {
+		epochs = epochs[:len(data.Accuracy)]
+	}
+
+	if len(epochs) == len(data.Accuracy) {
+		data.ValidationEpochs = epochs
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSetEpochMetricReplay(t *testing.T) {
+	// every epoch sets its train loss and every other epoch is validated
+	type update struct {
+		metric string
+		epoch  int
+		value  float64
+	}
+	updates := []update{
+		{MetricTrainLoss, 1, 9},
+		{MetricTrainLoss, 2, 8},
+		{MetricAccuracy, 2, 20},
+		{MetricTrainLoss, 3, 7},
+		{MetricTrainLoss, 4, 6},
+		{MetricAccuracy, 4, 40},
+	}
+
+	tests := []struct {
+		name  string
+		order []int
+	}{
+		{"in order", []int{0, 1, 2, 3, 4, 5}},
+		{"duplicated", []int{0, 0, 1, 2, 2, 3, 4, 4, 5, 5}},
+		{"retried after newer epochs", []int{0, 1, 2, 3, 4, 5, 0, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h JobHistory
+			for _, i := range tt.order {
+				u := updates[i]
+				if err := h.SetEpochMetric(u.metric, u.epoch, u.value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !reflect.DeepEqual(h.TrainLoss, []float64{9, 8, 7, 6}) {
+				t.Errorf("got train loss %v, want one value per epoch", h.TrainLoss)
+			}
+			if !reflect.DeepEqual(h.Accuracy, []float64{20, 40}) || !reflect.DeepEqual(h.ValidationEpochs, []int{2, 4}) {
+				t.Errorf("got accuracy %v in epochs %v, want one value per validation", h.Accuracy, h.ValidationEpochs)
+			}
+		})
+	}
+}
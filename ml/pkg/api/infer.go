@@ -0,0 +1,48 @@
+package api
+
+import "fmt"
+
+// Representations an inference can return for each datapoint
+const (
+	// OutputPrediction is the output of the infer method of the function
+	OutputPrediction = "prediction"
+	// OutputLogits are the raw scores of the network before any activation
+	OutputLogits = "logits"
+	// OutputEmbedding is the output of the penultimate layer of the network
+	OutputEmbedding = "embedding"
+)
+
+// Headers with the number of datapoints that succeeded and failed in
+// an inference that allows partial results, sent as trailers if the
+// predictions are streamed
+const (
+	HeaderInferSucceeded = "X-Kubeml-Infer-Succeeded"
+	HeaderInferFailed    = "X-Kubeml-Infer-Failed"
+)
+
+// InferFailure is a datapoint of an inference that allows partial results
+// that could not be inferred, with its index in the data of the request
+type InferFailure struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
+
+// ValidateOutputType checks that the output type is one of the
+// representations the functions return, empty returns predictions
+func ValidateOutputType(output string) error {
+	switch output {
+	case "", OutputPrediction, OutputLogits, OutputEmbedding:
+		return nil
+	default:
+		return fmt.Errorf("output type should be one of %s, %s or %s, got \"%s\"",
+			OutputPrediction, OutputLogits, OutputEmbedding, output)
+	}
+}
+
+// Output returns the representation requested, predictions by default
+func (r *InferRequest) Output() string {
+	if len(r.OutputType) == 0 {
+		return OutputPrediction
+	}
+	return r.OutputType
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"math"
+)
+
+// InputRangeTolerance is the fraction of the range of the train set by which
+// the values of a datapoint can exceed it before they are out of range
+const InputRangeTolerance = 0.1
+
+// InputStats are the range and the type of the features of the train set
+// of a dataset, which the inputs of the models trained on it should follow
+type InputStats struct {
+	Min     float64
+	Max     float64
+	Integer bool
+}
+
+// InputStats returns the stats of the features of the train set,
+// false if they are unknown because the features are not numeric
+func (s *DatasetStats) InputStats() (InputStats, bool) {
+	if s.FeatureMin == nil || s.FeatureMax == nil {
+		return InputStats{}, false
+	}
+	return InputStats{
+		Min:     *s.FeatureMin,
+		Max:     *s.FeatureMax,
+		Integer: IsIntegerDtype(s.FeatureDtype),
+	}, true
+}
+
+// IsIntegerDtype returns whether the numpy dtype holds integers
+func IsIntegerDtype(dtype string) bool {
+	switch dtype {
+	case "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64":
+		return true
+	default:
+		return false
+	}
+}
+
+// CheckInput checks that the datapoints only hold finite numbers within the range
+// of the train set, and integers if the train set does. Values out of range usually
+// mean the inputs were not preprocessed like the train set, e.g. pixels in [0, 255]
+// sent to a model trained on pixels in [0, 1], which returns predictions that look
+// fine but make no sense
+func (s InputStats) CheckInput(data []interface{}) error {
+	margin := (s.Max - s.Min) * InputRangeTolerance
+	low, high := s.Min-margin, s.Max+margin
+
+	var outOfRange, first int
+	var firstMin, firstMax float64
+	for i, datapoint := range data {
+		min, max := math.Inf(1), math.Inf(-1)
+		var nonInteger bool
+		err := walkValues(datapoint, func(v float64) error {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("datapoint %d has the non-finite value %v", i, v)
+			}
+			min, max = math.Min(min, v), math.Max(max, v)
+			nonInteger = nonInteger || v != math.Trunc(v)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if s.Integer && nonInteger {
+			return fmt.Errorf("datapoint %d has non-integer values, but the model was trained on integer features", i)
+		}
+		if min < low || max > high {
+			if outOfRange == 0 {
+				first, firstMin, firstMax = i, min, max
+			}
+			outOfRange++
+		}
+	}
+
+	if outOfRange > 0 {
+		return fmt.Errorf("%d of %d datapoints have values outside of the range [%v, %v] of the train set "+
+			"(datapoint %d has values in [%v, %v]), the inputs may not be preprocessed like the train set",
+			outOfRange, len(data), s.Min, s.Max, first, firstMin, firstMax)
+	}
+	return nil
+}
+
+// walkValues calls the function with each of the numbers of a datapoint,
+// which can be a number or a nested array of numbers
+func walkValues(value interface{}, fn func(float64) error) error {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			if err := walkValues(elem, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	case float64:
+		return fn(v)
+	case float32:
+		return fn(float64(v))
+	case int:
+		return fn(float64(v))
+	case int8:
+		return fn(float64(v))
+	case int16:
+		return fn(float64(v))
+	case int32:
+		return fn(float64(v))
+	case int64:
+		return fn(float64(v))
+	case uint8:
+		return fn(float64(v))
+	case uint16:
+		return fn(float64(v))
+	case uint32:
+		return fn(float64(v))
+	case uint64:
+		return fn(float64(v))
+	default:
+		return fmt.Errorf("datapoints should only hold numbers, got %v (%T)", value, value)
+	}
+}
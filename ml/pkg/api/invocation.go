@@ -0,0 +1,125 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Transports used by the jobs to invoke the train and validation functions
+const (
+	// InvocationModeSync calls the functions through the fission router
+	// and waits for the response, subject to the router timeout
+	InvocationModeSync = "sync"
+
+	// InvocationModeQueue publishes the invocations to the message queue of
+	// the cluster, and the functions post their results back to the job
+	InvocationModeQueue = "queue"
+
+	// DefaultInvocationTimeout is the time a job waits for the result of a
+	// queued invocation before considering that the function failed
+	DefaultInvocationTimeout = time.Hour
+
+	// StragglerFactor is how many times the median age of the invocations
+	// of a task an invocation has to run to be taken as a straggler
+	StragglerFactor = 2
+)
+
+// InvocationMessage is published to the queue for every queued invocation. The
+// message queue trigger of the function sends it as the body of the request, and
+// the function posts its response to Callback with the status in the query,
+// serialized in the Accept content type
+type InvocationMessage struct {
+	Id       string            `json:"id"`
+	Function string            `json:"function"`
+	Args     map[string]string `json:"args"`
+	Callback string            `json:"callback"`
+	Accept   string            `json:"accept,omitempty"`
+}
+
+type (
+	// InvocationStatus is an invocation of a function in flight as tracked by its job.
+	// Iteration is the merge iteration of train invocations, and Shards the range of
+	// shards of the function, set if the job audits its data assignment
+	InvocationStatus struct {
+		FuncId    int       `json:"func_id"`
+		Task      string    `json:"task"`
+		Epoch     int       `json:"epoch"`
+		Iteration int       `json:"iteration,omitempty"`
+		Shards    *[2]int64 `json:"shards,omitempty"`
+		Start     time.Time `json:"start"`
+		Attempt   int       `json:"attempt"`
+		Transport string    `json:"transport"`
+	}
+
+	// InvocationTable holds the invocations in flight of a job sorted by age,
+	// Untracked is the number of them left out once the table is full. StragglerTimeout
+	// is the age in seconds after which the train invocations are stragglers, set
+	// if the job has a straggler timeout, see TrainOptions.StragglerTimeout
+	InvocationTable struct {
+		JobId            string             `json:"job_id"`
+		Invocations      []InvocationStatus `json:"invocations"`
+		Untracked        int                `json:"untracked,omitempty"`
+		StragglerTimeout float64            `json:"straggler_timeout,omitempty"`
+	}
+)
+
+// SortByAge sorts the invocations from the oldest to the newest
+func (t *InvocationTable) SortByAge() {
+	sort.SliceStable(t.Invocations, func(i, j int) bool {
+		return t.Invocations[i].Start.Before(t.Invocations[j].Start)
+	})
+}
+
+// StragglerThresholds returns for each task the age after which its invocations
+// are stragglers, StragglerFactor times the median age of the ones in flight, or
+// the straggler timeout of the job for the train invocations if it has one
+func (t *InvocationTable) StragglerThresholds(now time.Time) map[string]time.Duration {
+	ages := make(map[string][]time.Duration)
+	for _, inv := range t.Invocations {
+		ages[inv.Task] = append(ages[inv.Task], now.Sub(inv.Start))
+	}
+
+	thresholds := make(map[string]time.Duration, len(ages))
+	for task, a := range ages {
+		sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
+		median := a[len(a)/2]
+		if len(a)%2 == 0 {
+			median = (a[len(a)/2-1] + a[len(a)/2]) / 2
+		}
+		thresholds[task] = StragglerFactor * median
+	}
+	if _, exists := thresholds["train"]; exists && t.StragglerTimeout > 0 {
+		thresholds["train"] = time.Duration(t.StragglerTimeout * float64(time.Second))
+	}
+	return thresholds
+}
+
+// ValidateInvocation checks that the invocation mode is known, empty
+// being sync, and that the invocation timeout is not negative
+func (o TrainOptions) ValidateInvocation() error {
+	switch o.InvocationMode {
+	case "", InvocationModeSync, InvocationModeQueue:
+	default:
+		return fmt.Errorf("unknown invocation mode \"%s\", expected %s or %s",
+			o.InvocationMode, InvocationModeSync, InvocationModeQueue)
+	}
+
+	if o.InvocationTimeout < 0 {
+		return fmt.Errorf("invocation timeout should not be negative")
+	}
+	return nil
+}
+
+// QueueInvocations returns true if the functions of the job are invoked through the queue
+func (o TrainOptions) QueueInvocations() bool {
+	return o.InvocationMode == InvocationModeQueue
+}
+
+// InvocationWait returns the time the job waits for the result of a queued invocation
+func (o TrainOptions) InvocationWait() time.Duration {
+	if o.InvocationTimeout <= 0 {
+		return DefaultInvocationTimeout
+	}
+	return time.Duration(o.InvocationTimeout) * time.Second
+}
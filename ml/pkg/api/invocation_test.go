@@ -0,0 +1,38 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStragglerThresholds(t *testing.T) {
+	now := time.Now()
+	ago := func(s int) time.Time { return now.Add(-time.Duration(s) * time.Second) }
+	table := &InvocationTable{Invocations: []InvocationStatus{
+		{FuncId: 0, Task: "train", Start: ago(10)},
+		{FuncId: 1, Task: "train", Start: ago(50)},
+		{FuncId: 2, Task: "train", Start: ago(20)},
+		{FuncId: 0, Task: "val", Start: ago(4)},
+		{FuncId: 1, Task: "val", Start: ago(6)},
+	}}
+
+	// the median age of the train invocations is 20s and of the validations 5s
+	got := table.StragglerThresholds(now)
+	if got["train"] != 40*time.Second || got["val"] != 10*time.Second {
+		t.Errorf("got thresholds %v, want 40s for train and 10s for val", got)
+	}
+
+	// the straggler timeout of the job replaces the threshold of the train invocations
+	table.StragglerTimeout = 30
+	if got := table.StragglerThresholds(now); got["train"] != 30*time.Second || got["val"] != 10*time.Second {
+		t.Errorf("got thresholds %v, want 30s for train and 10s for val", got)
+	}
+
+	table.SortByAge()
+	for i, want := range []int{1, 2, 0, 1, 0} {
+		if table.Invocations[i].FuncId != want {
+			t.Errorf("got function %d at %d, want the invocations from the oldest", table.Invocations[i].FuncId, i)
+			break
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Operations applied to the jobs matching the filter of a batch request
+const (
+	JobOpStop   = "stop"
+	JobOpDelete = "delete"
+	JobOpAddTag = "add-tag"
+)
+
+// Results of the operation of a batch request on each job
+const (
+	JobOpSucceeded = "succeeded"
+	JobOpSkipped   = "skipped"
+	JobOpFailed    = "failed"
+	// JobOpMatched is the result of the jobs matched by a dry run
+	JobOpMatched = "matched"
+)
+
+const (
+	// JobBatchConcurrency is the most jobs the controller
+	// applies the operation of a batch request to at once
+	JobBatchConcurrency = 4
+
+	// JobBatchConfirmAbove is the most jobs the cli changes
+	// in a batch without an explicit confirmation
+	JobBatchConfirmAbove = 5
+)
+
+type (
+	// JobFilter selects the jobs of a batch request. A job matches if it has all the
+	// tags, is in the state if set, and started before CreatedBefore if set
+	JobFilter struct {
+		Tags          map[string]string `json:"tags,omitempty"`
+		State         string            `json:"state,omitempty"`
+		CreatedBefore time.Time         `json:"created_before,omitempty"`
+	}
+
+	// JobBatchRequest applies an operation to all the jobs matching the filter. Tag
+	// is the name=value tag added by the add-tag operation. With DryRun the jobs
+	// matching the filter are only listed
+	JobBatchRequest struct {
+		Filter    JobFilter `json:"filter"`
+		Operation string    `json:"operation"`
+		Tag       string    `json:"tag,omitempty"`
+		DryRun    bool      `json:"dry_run,omitempty"`
+	}
+
+	// JobOpResult is the result of the operation on a job, Reason is
+	// why it was skipped or the error if it failed
+	JobOpResult struct {
+		JobId  string `json:"job_id"`
+		State  string `json:"state"`
+		Result string `json:"result"`
+		Reason string `json:"reason,omitempty"`
+	}
+
+	// JobBatchResponse has the result of the operation on every job matched, by id
+	JobBatchResponse struct {
+		Operation string        `json:"operation"`
+		DryRun    bool          `json:"dry_run,omitempty"`
+		Results   []JobOpResult `json:"results"`
+	}
+)
+
+// Validate checks the operation and the filter of the request. The filter can't
+// be empty, so a request can't change every job by mistake
+func (r JobBatchRequest) Validate() error {
+	switch r.Operation {
+	case JobOpStop, JobOpDelete:
+	case JobOpAddTag:
+		if _, _, err := ParseTag(r.Tag); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown operation %s, expected %s, %s or %s", r.Operation, JobOpStop, JobOpDelete, JobOpAddTag)
+	}
+
+	f := r.Filter
+	if len(f.Tags) == 0 && len(f.State) == 0 && f.CreatedBefore.IsZero() {
+		return fmt.Errorf("the filter should set the tags, the state or the creation time of the jobs")
+	}
+	switch f.State {
+	case "", JobRunning, JobPaused, JobCompleted, JobFailed, JobStopped:
+	default:
+		return fmt.Errorf("unknown state %s", f.State)
+	}
+	return nil
+}
+
+// Matches returns whether the job of the history matches the filter
+func (f JobFilter) Matches(h *History) bool {
+	for name, value := range f.Tags {
+		if v, exists := h.Task.Tags[name]; !exists || v != value {
+			return false
+		}
+	}
+	if len(f.State) > 0 && h.Status() != f.State {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && (h.Started.IsZero() || !h.Started.Before(f.CreatedBefore)) {
+		return false
+	}
+	return true
+}
+
+// ParseTag parses a name=value tag
+func ParseTag(tag string) (string, string, error) {
+	parts := strings.SplitN(tag, "=", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return "", "", fmt.Errorf("tag \"%s\" should be name=value", tag)
+	}
+	if strings.ContainsAny(parts[0], ".$") {
+		return "", "", fmt.Errorf("tag %s should not contain dots or dollar signs", parts[0])
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DefaultLayerGroup is the group of the layers that match no override, synced
+// with the K of the job. The groups of the overrides follow it in their order
+const DefaultLayerGroup = 0
+
+type (
+	// LayerSyncOverride gives the layers whose name matches Pattern their own sync
+	// cadence, averaging them every K local steps instead of every K of the job.
+	// Patterns are globs where * matches any run of characters and ? a single one.
+	// A layer matched by several overrides takes the first one
+	LayerSyncOverride struct {
+		Pattern string `json:"pattern"`
+		K       int    `json:"k"`
+	}
+
+	// LayerGroups holds the layers of the model grouped by their sync cadence.
+	// Ks has the K of each group, the default group first, and Period is the
+	// number of local steps between the merges of the job, the smallest K
+	LayerGroups struct {
+		Period int
+		Ks     []int
+		Layers [][]string
+	}
+
+	// MergeResponse is sent to the functions once the merge of their iteration is
+	// done, with the layer groups that were averaged. The layers of the other groups
+	// keep training locally until they are due
+	MergeResponse struct {
+		Groups []int `json:"groups"`
+	}
+)
+
+// ValidateLayerSync checks that the patterns of the overrides are valid and unique,
+// and that the K of every group is a multiple of the smallest one, so the groups
+// are due at the merges of the job
+func (o TrainOptions) ValidateLayerSync() error {
+	seen := make(map[string]bool, len(o.LayerSyncOverrides))
+	for _, override := range o.LayerSyncOverrides {
+		if len(override.Pattern) == 0 {
+			return fmt.Errorf("the pattern of a layer sync override should not be empty")
+		}
+		// the patterns are sent to the functions separated by , and :, and
+		// character classes are left out since python matches them differently
+		if strings.ContainsAny(override.Pattern, ",:[]\\") {
+			return fmt.Errorf("layer pattern \"%s\" should not contain , : [ ] or \\", override.Pattern)
+		}
+		if _, err := path.Match(override.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid layer pattern \"%s\": %v", override.Pattern, err)
+		}
+		if seen[override.Pattern] {
+			return fmt.Errorf("layer pattern \"%s\" is repeated", override.Pattern)
+		}
+		seen[override.Pattern] = true
+
+		if override.K < 1 && override.K != -1 {
+			return fmt.Errorf("the K of layer pattern \"%s\" should be positive or -1 to sync once per epoch, got %d",
+				override.Pattern, override.K)
+		}
+	}
+
+	period := o.SyncPeriod()
+	for _, k := range o.groupKs() {
+		if k > 0 && k%period != 0 {
+			return fmt.Errorf("K %d is not a multiple of %d, the K of every layer group "+
+				"should be a multiple of the smallest one", k, period)
+		}
+	}
+	return nil
+}
+
+// groupKs returns the K of each layer group, the default group first
+func (o TrainOptions) groupKs() []int {
+	ks := []int{o.K}
+	for _, override := range o.LayerSyncOverrides {
+		ks = append(ks, override.K)
+	}
+	return ks
+}
+
+// SyncPeriod returns the number of local steps between the merges of the job, the
+// smallest K of the layer groups, or -1 if all of them sync once per epoch. It is
+// the K of the job if it has no overrides
+func (o TrainOptions) SyncPeriod() int {
+	if len(o.LayerSyncOverrides) == 0 {
+		return o.K
+	}
+
+	period := -1
+	for _, k := range o.groupKs() {
+		if k > 0 && (period < 0 || k < period) {
+			period = k
+		}
+	}
+	return period
+}
+
+// SyncGroupsArg returns the overrides as sent to the functions, pattern:K
+// separated by commas, or an empty string if the job has none
+func (o TrainOptions) SyncGroupsArg() string {
+	groups := make([]string, len(o.LayerSyncOverrides))
+	for i, override := range o.LayerSyncOverrides {
+		groups[i] = override.Pattern + ":" + strconv.Itoa(override.K)
+	}
+	return strings.Join(groups, ",")
+}
+
+// GroupLayers groups the layers of the model by the first override matching their
+// name, returning nil if the job has no overrides. Overrides that match no layer
+// are an error, since they are most likely a typo
+func (o TrainOptions) GroupLayers(layers []string) (*LayerGroups, error) {
+	if len(o.LayerSyncOverrides) == 0 {
+		return nil, nil
+	}
+
+	g := &LayerGroups{
+		Period: o.SyncPeriod(),
+		Ks:     o.groupKs(),
+		Layers: make([][]string, len(o.LayerSyncOverrides)+1),
+	}
+	for _, name := range layers {
+		group := DefaultLayerGroup
+		for i, override := range o.LayerSyncOverrides {
+			if matched, _ := path.Match(override.Pattern, name); matched {
+				group = i + 1
+				break
+			}
+		}
+		g.Layers[group] = append(g.Layers[group], name)
+	}
+
+	for i, override := range o.LayerSyncOverrides {
+		if len(g.Layers[i+1]) == 0 {
+			return nil, fmt.Errorf("layer pattern \"%s\" does not match any layer of the model not matched before",
+				override.Pattern)
+		}
+	}
+	return g, nil
+}
+
+// Due returns the groups averaged in the merge of the given iteration of the
+// epoch, counted from 0, which happens after (iteration+1)*Period local steps
+func (g *LayerGroups) Due(iteration int) []int {
+	steps := (iteration + 1) * g.Period
+	var due []int
+	for group, k := range g.Ks {
+		if k > 0 && steps%k == 0 {
+			due = append(due, group)
+		}
+	}
+	return due
+}
+
+// All returns every group, averaged in the merges that
+// include the functions that finished their data
+func (g *LayerGroups) All() []int {
+	all := make([]int, len(g.Ks))
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+// Select returns the layers of the groups
+func (g *LayerGroups) Select(groups []int) []string {
+	var layers []string
+	for _, group := range groups {
+		layers = append(layers, g.Layers[group]...)
+	}
+	return layers
+}
+
+// ParseLayerSyncOverride parses an override given as pattern:K, like bn*:1
+func ParseLayerSyncOverride(s string) (LayerSyncOverride, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return LayerSyncOverride{}, fmt.Errorf("invalid layer sync override \"%s\", expected pattern:K", s)
+	}
+	k, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		return LayerSyncOverride{}, fmt.Errorf("invalid K in layer sync override \"%s\": %v", s, err)
+	}
+	return LayerSyncOverride{Pattern: s[:i], K: k}, nil
+}
+
+// SetLayerGroupMerges sets the merges of each layer group for the given epoch
+// (starting at 1), the groups without merges in previous epochs padded with zeros
+func (h *JobHistory) SetLayerGroupMerges(epoch int, merges []int) {
+	for len(h.LayerGroupMerges) < len(merges) {
+		h.LayerGroupMerges = append(h.LayerGroupMerges, nil)
+	}
+	for group, n := range merges {
+		h.LayerGroupMerges[group] = setAt(h.LayerGroupMerges[group], epoch-1, float64(n))
+	}
+}
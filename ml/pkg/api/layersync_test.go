@@ -0,0 +1,182 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+var syncTestLayers = []string{
+	"conv1.weight", "conv1.bias", "bn1.weight", "bn1.bias", "bn1.running_mean", "bn1.running_var",
+	"bn2.running_mean", "bn2.running_var", "fc.weight", "fc.bias",
+}
+
+func TestGroupLayersOverlapping(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides []LayerSyncOverride
+		want      [][]string
+		wantError bool
+	}{
+		{
+			name:      "no overrides",
+			overrides: nil,
+		},
+		// the running statistics match both patterns and take the first one
+		{
+			name:      "narrow pattern first",
+			overrides: []LayerSyncOverride{{"bn*.running_*", 2}, {"bn*", 4}},
+			want: [][]string{
+				{"conv1.weight", "conv1.bias", "fc.weight", "fc.bias"},
+				{"bn1.running_mean", "bn1.running_var", "bn2.running_mean", "bn2.running_var"},
+				{"bn1.weight", "bn1.bias"},
+			},
+		},
+		// the wide pattern takes every layer the narrow one would have matched
+		{
+			name:      "wide pattern first",
+			overrides: []LayerSyncOverride{{"bn*", 4}, {"bn*.running_*", 2}},
+			wantError: true,
+		},
+		{
+			name:      "same layers by another pattern",
+			overrides: []LayerSyncOverride{{"*.bias", 2}, {"fc.bias", 4}},
+			wantError: true,
+		},
+		{
+			name:      "partly shadowed",
+			overrides: []LayerSyncOverride{{"*.bias", 2}, {"fc.*", 4}},
+			want: [][]string{
+				{"conv1.weight", "bn1.weight", "bn1.running_mean", "bn1.running_var", "bn2.running_mean", "bn2.running_var"},
+				{"conv1.bias", "bn1.bias", "fc.bias"},
+				{"fc.weight"},
+			},
+		},
+		{
+			name:      "single character wildcard",
+			overrides: []LayerSyncOverride{{"bn?.running_var", 2}},
+			want: [][]string{
+				{"conv1.weight", "conv1.bias", "bn1.weight", "bn1.bias", "bn1.running_mean", "bn2.running_mean", "fc.weight", "fc.bias"},
+				{"bn1.running_var", "bn2.running_var"},
+			},
+		},
+		{
+			name:      "no layer matched",
+			overrides: []LayerSyncOverride{{"layer4.*", 2}},
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := TrainOptions{K: 8, LayerSyncOverrides: tt.overrides}
+			g, err := o.GroupLayers(syncTestLayers)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if tt.want == nil {
+				if g != nil {
+					t.Errorf("got groups %+v, want none", g)
+				}
+				return
+			}
+			if !reflect.DeepEqual(g.Layers, tt.want) {
+				t.Errorf("got layers %v, want %v", g.Layers, tt.want)
+			}
+
+			// every layer is in exactly one group
+			if all := g.Select(g.All()); len(all) != len(syncTestLayers) {
+				t.Errorf("got %d layers in the groups, want %d", len(all), len(syncTestLayers))
+			}
+		})
+	}
+}
+
+func TestLayerGroupsDue(t *testing.T) {
+	// the job syncs every 2 steps, the weights every 8 and the stats every 4
+	o := TrainOptions{K: 8, LayerSyncOverrides: []LayerSyncOverride{{"bn*.running_*", 2}, {"bn*", 4}}}
+	g, err := o.GroupLayers(syncTestLayers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Period != 2 || !reflect.DeepEqual(g.Ks, []int{8, 2, 4}) {
+		t.Fatalf("got period %d and Ks %v, want 2 and [8 2 4]", g.Period, g.Ks)
+	}
+
+	want := [][]int{{1}, {1, 2}, {1}, {0, 1, 2}, {1}, {1, 2}, {1}, {0, 1, 2}}
+	for iteration, w := range want {
+		if got := g.Due(iteration); !reflect.DeepEqual(got, w) {
+			t.Errorf("got groups %v due in iteration %d, want %v", got, iteration, w)
+		}
+	}
+
+	// the groups synced once per epoch are never due at a barrier
+	o = TrainOptions{K: 4, LayerSyncOverrides: []LayerSyncOverride{{"bn*", -1}}}
+	if g, err = o.GroupLayers(syncTestLayers); err != nil {
+		t.Fatal(err)
+	}
+	for iteration := 0; iteration < 4; iteration++ {
+		if got := g.Due(iteration); !reflect.DeepEqual(got, []int{0}) {
+			t.Errorf("got groups %v due in iteration %d, want [0]", got, iteration)
+		}
+	}
+}
+
+func TestValidateLayerSync(t *testing.T) {
+	tests := []struct {
+		name      string
+		k         int
+		overrides []LayerSyncOverride
+		period    int
+		wantError bool
+	}{
+		{"no overrides", 8, nil, 8, false},
+		{"multiples", 8, []LayerSyncOverride{{"bn*", 2}, {"fc*", 4}}, 2, false},
+		{"override of the job K", 4, []LayerSyncOverride{{"bn*", 8}}, 4, false},
+		{"once per epoch", -1, []LayerSyncOverride{{"bn*", -1}}, -1, false},
+		{"job once per epoch", -1, []LayerSyncOverride{{"bn*", 2}}, 2, false},
+		{"not a multiple", 8, []LayerSyncOverride{{"bn*", 3}}, 3, true},
+		{"overrides not multiples", 8, []LayerSyncOverride{{"bn*", 4}, {"fc*", 6}}, 4, true},
+		{"empty pattern", 8, []LayerSyncOverride{{"", 2}}, 2, true},
+		{"separator in pattern", 8, []LayerSyncOverride{{"bn:1", 2}}, 2, true},
+		{"character class", 8, []LayerSyncOverride{{"bn[12]", 2}}, 2, true},
+		{"repeated pattern", 8, []LayerSyncOverride{{"bn*", 2}, {"bn*", 4}}, 2, true},
+		{"zero K", 8, []LayerSyncOverride{{"bn*", 0}}, 8, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := TrainOptions{K: tt.k, LayerSyncOverrides: tt.overrides}
+			if err := o.ValidateLayerSync(); (err != nil) != tt.wantError {
+				t.Errorf("got error %v, want error %v", err, tt.wantError)
+			}
+			if got := o.SyncPeriod(); got != tt.period {
+				t.Errorf("got period %d, want %d", got, tt.period)
+			}
+		})
+	}
+}
+
+func TestParseLayerSyncOverride(t *testing.T) {
+	tests := []struct {
+		s         string
+		want      LayerSyncOverride
+		wantError bool
+	}{
+		{"bn*:1", LayerSyncOverride{"bn*", 1}, false},
+		{"*.running_*:-1", LayerSyncOverride{"*.running_*", -1}, false},
+		{"bn*", LayerSyncOverride{}, true},
+		{"bn*:often", LayerSyncOverride{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLayerSyncOverride(tt.s)
+		if (err != nil) != tt.wantError || got != tt.want {
+			t.Errorf("%q: got override %+v and error %v, want %+v", tt.s, got, err, tt.want)
+		}
+	}
+
+	o := TrainOptions{LayerSyncOverrides: []LayerSyncOverride{{"bn*", 2}, {"fc.?ias", 4}}}
+	if got := o.SyncGroupsArg(); got != "bn*:2,fc.?ias:4" {
+		t.Errorf("got argument %q, want bn*:2,fc.?ias:4", got)
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import "fmt"
+
+// AdmissionLimits bound the training a single request can ask for, so a typo in
+// the epochs does not tie up the cluster. The function-epochs of a request are its
+// epochs times its default parallelism, and the max parallelism bounds the one the
+// scheduler can give a job. A limit of 0 disables the check
+type AdmissionLimits struct {
+	MaxEpochs         int `json:"max_epochs"`
+	MaxFunctionEpochs int `json:"max_function_epochs"`
+	MaxParallelism    int `json:"max_parallelism,omitempty"`
+}
+
+// DefaultAdmissionLimits returns the limits used until they are set by an admin
+func DefaultAdmissionLimits() AdmissionLimits {
+	return AdmissionLimits{
+		MaxEpochs:         DefaultMaxEpochs,
+		MaxFunctionEpochs: DefaultMaxFunctionEpochs,
+	}
+}
+
+// FunctionEpochs returns the function-epochs requested, using
+// the default parallelism if the request does not set one
+func (r TrainRequest) FunctionEpochs() int {
+	parallelism := r.Options.DefaultParallelism
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+	return r.Epochs * parallelism
+}
+
+// Validate checks that none of the limits is negative
+func (l AdmissionLimits) Validate() error {
+	if l.MaxEpochs < 0 || l.MaxFunctionEpochs < 0 || l.MaxParallelism < 0 {
+		return fmt.Errorf("admission limits should not be negative")
+	}
+	return nil
+}
+
+// Check returns an error explaining the limit exceeded by the request, or nil if
+// the request is within the limits. The request can still be admitted by setting
+// the budget override
+func (l AdmissionLimits) Check(req TrainRequest) error {
+	if l.MaxEpochs > 0 && req.Epochs > l.MaxEpochs {
+		return fmt.Errorf("request asks for %d epochs, above the limit of %d epochs per job; "+
+			"lower the epochs or set the budget override (--budget-override) if this is intended",
+			req.Epochs, l.MaxEpochs)
+	}
+
+	if fe := req.FunctionEpochs(); l.MaxFunctionEpochs > 0 && fe > l.MaxFunctionEpochs {
+		return fmt.Errorf("request asks for %d function-epochs (epochs x parallelism), above the limit of %d per job; "+
+			"lower the epochs or the parallelism, or set the budget override (--budget-override) if this is intended",
+			fe, l.MaxFunctionEpochs)
+	}
+
+	if max := req.Options.MaxParallelism; l.MaxParallelism > 0 && max > l.MaxParallelism {
+		return fmt.Errorf("request asks for a max parallelism of %d, above the limit of %d per job; "+
+			"lower the max parallelism or set the budget override (--budget-override) if this is intended",
+			max, l.MaxParallelism)
+	}
+
+	return nil
+}
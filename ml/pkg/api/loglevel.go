@@ -0,0 +1,28 @@
+package api
+
+import "fmt"
+
+// Log levels a job can be set to
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+// LogLevelRequest is sent to change the log level of a running job
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// ValidateLogLevel checks that the level is one of the levels
+// a job can be set to, an empty level keeps the default
+func ValidateLogLevel(level string) error {
+	switch level {
+	case "", LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError:
+		return nil
+	default:
+		return fmt.Errorf("log level should be one of %s, %s, %s or %s, got \"%s\"",
+			LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError, level)
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import "fmt"
+
+// Reductions combining the losses reported by the train functions
+// into the train loss of the epoch, see TrainOptions.LossReduction
+const (
+	// LossReductionMean averages the losses of the functions,
+	// each counting the same regardless of the data it trained on
+	LossReductionMean = "mean"
+
+	// LossReductionSum adds up the losses of the functions, for
+	// losses that are not averaged over the datapoints
+	LossReductionSum = "sum"
+
+	// LossReductionWeightedMean averages the losses of the functions
+	// weighted by the number of datapoints each trained on
+	LossReductionWeightedMean = "weighted_mean"
+)
+
+// ValidateLossReduction checks that the loss reduction is known, empty being mean
+func (o TrainOptions) ValidateLossReduction() error {
+	switch o.LossReduction {
+	case "", LossReductionMean, LossReductionSum, LossReductionWeightedMean:
+		return nil
+	default:
+		return fmt.Errorf("unknown loss reduction \"%s\", expected %s, %s or %s",
+			o.LossReduction, LossReductionMean, LossReductionSum, LossReductionWeightedMean)
+	}
+}
+
+// ReduceLoss combines the losses of the train functions into the loss of the epoch,
+// lengths being the datapoints each function trained on. The weighted mean falls
+// back to the mean if the functions did not report their datapoints
+func (o TrainOptions) ReduceLoss(losses, lengths []float64) float64 {
+	if len(losses) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, loss := range losses {
+		sum += loss
+	}
+
+	switch o.LossReduction {
+	case LossReductionSum:
+		return sum
+	case LossReductionWeightedMean:
+		var weighted, total float64
+		for i, loss := range losses {
+			weighted += loss * lengths[i]
+			total += lengths[i]
+		}
+		if total > 0 {
+			return weighted / total
+		}
+	}
+	return sum / float64(len(losses))
+}
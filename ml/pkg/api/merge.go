@@ -0,0 +1,84 @@
+package api
+
+import "fmt"
+
+// Operators combining the layers of the train functions into the reference
+// model in every merge, see TrainOptions.MergeOperator
+const (
+	// MergeMean averages the layers, weighted by the share of the data of
+	// each function. It is the default and the only one that sums the layers
+	// as they arrive, the rest keep the layers of every function until the merge
+	MergeMean = "mean"
+
+	// MergeMedian takes the weighted median of every value across the functions
+	MergeMedian = "median"
+
+	// MergeTrimmedMean drops the highest and lowest MergeTrimFraction of every
+	// value across the functions and averages the rest, or the ByzantineTolerance
+	// highest and lowest if the job sets it
+	MergeTrimmedMean = "trimmed_mean"
+
+	// MergeKrum takes the model of the function closest to the rest, the one with
+	// the lowest sum of squared distances to its n-f-2 nearest models over all the
+	// layers, f being the ByzantineTolerance of the job. The model of up to f faulty
+	// functions is never picked as long as more than 2f+2 functions are merged
+	MergeKrum = "krum"
+
+	// MergeTrimFraction is the fraction of the functions dropped from each
+	// end by the trimmed mean, at least one once there are three of them
+	MergeTrimFraction = 0.1
+)
+
+// MergeOperators are the merge operators built in kubeml. Others can be added to
+// the parameter server with model.RegisterMergeOperator, so the operator of a job is
+// checked against the registry by the controller, see model.ValidateMergeOperator
+var MergeOperators = []string{MergeMean, MergeMedian, MergeTrimmedMean, MergeKrum}
+
+// MaxByzantineTolerance is the most faulty functions a job can tolerate
+const MaxByzantineTolerance = 100
+
+// MergeOperatorOrDefault returns the merge operator of the job, MergeMean unless set
+func (o TrainOptions) MergeOperatorOrDefault() string {
+	if len(o.MergeOperator) == 0 {
+		return MergeMean
+	}
+	return o.MergeOperator
+}
+
+// ValidateByzantineTolerance checks that the tolerance is only set with the robust merge
+// operators and that the job starts with enough functions to outvote the faulty ones,
+// more than 2f for the median and the trimmed mean and more than 2f+2 for krum
+func (o TrainOptions) ValidateByzantineTolerance() error {
+	f := o.ByzantineTolerance
+	if f < 0 || f > MaxByzantineTolerance {
+		return fmt.Errorf("byzantine tolerance should be between 0 and %d, got %d", MaxByzantineTolerance, f)
+	}
+	if f == 0 {
+		return nil
+	}
+
+	op := o.MergeOperatorOrDefault()
+	if op == MergeMean {
+		return fmt.Errorf("byzantine tolerance needs a robust merge operator, %s, %s or %s",
+			MergeMedian, MergeTrimmedMean, MergeKrum)
+	}
+
+	parallelism := o.DefaultParallelism
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+	if min := MinRobustFunctions(op, f); parallelism < min {
+		return fmt.Errorf("%s tolerating %d faulty functions needs a parallelism of at least %d, got %d",
+			op, f, min, parallelism)
+	}
+	return nil
+}
+
+// MinRobustFunctions returns the fewest functions the merge operator needs
+// to ignore the layers of f faulty ones
+func MinRobustFunctions(op string, f int) int {
+	if op == MergeKrum {
+		return 2*f + 3
+	}
+	return 2*f + 1
+}
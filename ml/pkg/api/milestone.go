@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultAccuracyMilestones are the validation accuracies whose first
+// occurrence is recorded in the history unless the job sets others
+var DefaultAccuracyMilestones = []float64{80, 90, 95}
+
+// AccuracyMilestone is the first validation of a job that reached an accuracy. Elapsed
+// is the training time in seconds until then, excluding the pauses, and FunctionSeconds
+// the time the functions of the epochs up to it ran for. Milestones not reached yet
+// are kept with Reached unset so they are shown as such
+type AccuracyMilestone struct {
+	Accuracy        float64 `json:"accuracy"`
+	Reached         bool    `json:"reached"`
+	Epoch           int     `json:"epoch,omitempty"`
+	Elapsed         float64 `json:"elapsed,omitempty"`
+	FunctionSeconds float64 `json:"function_seconds,omitempty"`
+}
+
+// ValidateAccuracyMilestones checks that the milestones are accuracies
+func (o TrainOptions) ValidateAccuracyMilestones() error {
+	for _, accuracy := range o.AccuracyMilestones {
+		if accuracy <= 0 || accuracy > 100 {
+			return fmt.Errorf("accuracy milestones should be between 0 and 100, got %v", accuracy)
+		}
+	}
+	return nil
+}
+
+// Milestones returns the accuracies tracked by the job in increasing
+// order without duplicates, DefaultAccuracyMilestones unless set
+func (o TrainOptions) Milestones() []float64 {
+	if len(o.AccuracyMilestones) == 0 {
+		return DefaultAccuracyMilestones
+	}
+
+	milestones := append([]float64(nil), o.AccuracyMilestones...)
+	sort.Float64s(milestones)
+	unique := milestones[:1]
+	for _, m := range milestones[1:] {
+		if m != unique[len(unique)-1] {
+			unique = append(unique, m)
+		}
+	}
+	return unique
+}
+
+// FunctionSeconds returns the time the functions of the first epochs ran for, the
+// duration of each epoch times its parallelism. The epoch durations in the history
+// are the elapsed time at the end of each epoch, so they are subtracted in pairs
+func (h *JobHistory) FunctionSeconds(epochs int) float64 {
+	if epochs > len(h.EpochDuration) {
+		epochs = len(h.EpochDuration)
+	}
+
+	var total, previous float64
+	for i := 0; i < epochs; i++ {
+		parallelism := 1.0
+		if i < len(h.Parallelism) {
+			parallelism = h.Parallelism[i]
+		}
+		total += (h.EpochDuration[i] - previous) * parallelism
+		previous = h.EpochDuration[i]
+	}
+	return total
+}
+
+// TimeToAccuracy returns when the job first reached the accuracy. The milestone
+// recorded by the job is returned if it tracked the accuracy, otherwise it is
+// taken from the validations in the history, with the elapsed time at the end of
+// the epoch, which leaves out the time spent validating
+func (h *JobHistory) TimeToAccuracy(accuracy float64, o TrainOptions) AccuracyMilestone {
+	for _, m := range h.Milestones {
+		if m.Accuracy == accuracy {
+			return m
+		}
+	}
+
+	milestone := AccuracyMilestone{Accuracy: accuracy}
+	values, epochs := h.Series(MetricAccuracy)
+	for i := range values {
+		if !o.GoalReached(MetricAccuracy, values[i], accuracy) {
+			continue
+		}
+		milestone.Reached = true
+		milestone.Epoch = epochs[i]
+		if epoch := epochs[i]; epoch > 0 && epoch <= len(h.EpochDuration) {
+			milestone.Elapsed = h.EpochDuration[epoch-1]
+		}
+		milestone.FunctionSeconds = h.FunctionSeconds(epochs[i])
+		break
+	}
+	return milestone
+}
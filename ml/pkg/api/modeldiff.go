@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// ModelRef identifies a set of weights to compare. Epoch 0 is the current
+	// model of the job, read from redis or from its last checkpoint if the model
+	// is no longer there, other epochs are read from the checkpoint of the epoch
+	ModelRef struct {
+		JobId string
+		Epoch int
+	}
+
+	// ModelDiff compares the weights of two models layer by layer. The layers
+	// present in both models are ranked by how much they changed, and the
+	// ones present in only one of the models are listed separately
+	ModelDiff struct {
+		A       string      `json:"a"`
+		B       string      `json:"b"`
+		Layers  []LayerDiff `json:"layers"`
+		OnlyInA []string    `json:"only_in_a,omitempty"`
+		OnlyInB []string    `json:"only_in_b,omitempty"`
+	}
+
+	// LayerDiff holds the L2 distance and the cosine similarity between the weights
+	// of a layer in both models, and the relative change, which is the distance
+	// divided by the norm of the layer in a, which is not set if the layer is all
+	// zeros in a but not in b. If the layer has a different type or shape in
+	// both models Incompatible describes the difference and the metrics are 0
+	LayerDiff struct {
+		Layer          string   `json:"layer"`
+		Dtype          string   `json:"dtype"`
+		Shape          []int64  `json:"shape"`
+		L2Distance     float64  `json:"l2_distance"`
+		Cosine         float64  `json:"cosine_similarity"`
+		RelativeChange *float64 `json:"relative_change,omitempty"`
+		Incompatible   string   `json:"incompatible,omitempty"`
+	}
+)
+
+// ParseModelRef parses a reference to the weights of a model,
+// either <jobId> or <jobId>@<epoch> for the checkpoint of an epoch
+func ParseModelRef(ref string) (ModelRef, error) {
+	if strings.Contains(ref, ":") {
+		return ModelRef{}, fmt.Errorf("model versions are not supported, \"%s\" should be <jobId> or <jobId>@<epoch>", ref)
+	}
+
+	parts := strings.SplitN(ref, "@", 2)
+	if len(parts[0]) == 0 {
+		return ModelRef{}, fmt.Errorf("model reference \"%s\" has no job id", ref)
+	}
+	if len(parts) == 1 {
+		return ModelRef{JobId: parts[0]}, nil
+	}
+
+	epoch, err := strconv.Atoi(parts[1])
+	if err != nil || epoch <= 0 {
+		return ModelRef{}, fmt.Errorf("epoch of model reference \"%s\" should be a positive number", ref)
+	}
+	return ModelRef{JobId: parts[0], Epoch: epoch}, nil
+}
+
+// String returns the reference in the format parsed by ParseModelRef
+func (r ModelRef) String() string {
+	if r.Epoch == 0 {
+		return r.JobId
+	}
+	return fmt.Sprintf("%s@%d", r.JobId, r.Epoch)
+}
+
+// Rank sorts the layers from the one that changed the most, by relative change
+// and then by distance. Layers that were all zeros in a come first since their
+// change is unbounded, and incompatible layers last
+func (d *ModelDiff) Rank() {
+	sort.SliceStable(d.Layers, func(i, j int) bool {
+		a, b := &d.Layers[i], &d.Layers[j]
+		if (len(a.Incompatible) > 0) != (len(b.Incompatible) > 0) {
+			return len(b.Incompatible) > 0
+		}
+		if (a.RelativeChange == nil) != (b.RelativeChange == nil) {
+			return a.RelativeChange == nil
+		}
+		if a.RelativeChange != nil && *a.RelativeChange != *b.RelativeChange {
+			return *a.RelativeChange > *b.RelativeChange
+		}
+		if a.L2Distance != b.L2Distance {
+			return a.L2Distance > b.L2Distance
+		}
+		return a.Layer < b.Layer
+	})
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Types of the events sent to the notification url of a job
+const (
+	EventEpochCompleted = "epoch-completed"
+
+	// EventMergePaused and EventMergeResumed are sent when the merger of the
+	// job loses and recovers the connection to redis, see RedisOutageGrace
+	EventMergePaused  = "merge-paused"
+	EventMergeResumed = "merge-resumed"
+
+	// EventJobPaused and EventJobResumed are sent when the
+	// job is paused and resumed through the API
+	EventJobPaused  = "job-paused"
+	EventJobResumed = "job-resumed"
+
+	// EventInvalidResponse is sent when a function answers with a response that
+	// does not match its schema, with the id of the function and the error
+	EventInvalidResponse = "invalid-response"
+
+	// EventParallelismCapped is sent when the parallelism given to the job
+	// is lowered to its max parallelism, with the one asked and the cap
+	EventParallelismCapped = "parallelism-capped"
+
+	// EventEpochOverCost is sent when the function seconds of an epoch go over
+	// the max epoch cost of the job, with the cost, the cap and the parallelism
+	// of the next epoch
+	EventEpochOverCost = "epoch-over-cost"
+
+	// EventNoValidationData is sent when the validation functions of an epoch
+	// report no evaluated datapoints, with the number of functions that reported
+	EventNoValidationData = "no-validation-data"
+)
+
+// Headers of the notifications, the signature is the HMAC-SHA256 of the body
+// keyed with the secret returned in HeaderNotifySecret when the job is created
+const (
+	HeaderNotifySecret = "X-Kubeml-Notify-Secret"
+	HeaderEvent        = "X-Kubeml-Event"
+	HeaderSignature    = "X-Kubeml-Signature"
+
+	signaturePrefix = "sha256="
+)
+
+// EpochEvent is sent to the notification url of a job after every epoch. Metrics
+// holds the metrics recorded in the epoch, which only include the validation
+// metrics if the epoch was validated, and Cumulative the values over the whole
+// training such as the elapsed time, the best accuracy so far and the time it
+// took to reach the accuracy milestones, as time_to_accuracy_<accuracy>.
+//
+// The merge and pause events are sent with no metrics, except for the
+// seconds the merger waited for redis or the job was paused when resuming.
+// Error is only set in the events of errors such as EventInvalidResponse
+type EpochEvent struct {
+	Type       string             `json:"type"`
+	JobId      string             `json:"job_id"`
+	Epoch      int                `json:"epoch"`
+	Epochs     int                `json:"epochs"`
+	Time       time.Time          `json:"time"`
+	Metrics    map[string]float64 `json:"metrics"`
+	Cumulative map[string]float64 `json:"cumulative"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// SignPayload returns the signature of a notification body
+func SignPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks the signature of a notification body in constant time
+func VerifySignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignPayload(secret, body)), []byte(signature))
+}
@@ -0,0 +1,39 @@
+package api
+
+import "testing"
+
+func TestSignPayload(t *testing.T) {
+	// the HMAC-SHA256 test vector of the key "key"
+	body := []byte("The quick brown fox jumps over the lazy dog")
+	want := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if got := SignPayload("key", body); got != want {
+		t.Errorf("got signature %s, want %s", got, want)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"type":"epoch-completed","epoch":1}`)
+	signature := SignPayload("secret", body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		body      string
+		signature string
+		want      bool
+	}{
+		{"valid", "secret", string(body), signature, true},
+		{"other secret", "other", string(body), signature, false},
+		{"modified body", "secret", `{"type":"epoch-completed","epoch":2}`, signature, false},
+		{"no prefix", "secret", string(body), signature[len(signaturePrefix):], false},
+		{"no signature", "secret", string(body), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifySignature(tt.secret, []byte(tt.body), tt.signature); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
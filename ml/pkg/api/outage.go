@@ -0,0 +1,28 @@
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// MergePause is reported in the state of a job while its merger waits for
+// redis to come back after losing the connection, see RedisOutageGrace
+type MergePause struct {
+	Epoch  int       `json:"epoch"`
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason"`
+}
+
+// ValidateOutageGrace checks that the redis outage grace is not negative
+func (o TrainOptions) ValidateOutageGrace() error {
+	if o.RedisOutageGrace < 0 {
+		return fmt.Errorf("redis outage grace should not be negative")
+	}
+	return nil
+}
+
+// OutageGrace returns how long the merger waits for redis to come back,
+// 0 if the job fails the epoch as soon as the save retries run out
+func (o TrainOptions) OutageGrace() time.Duration {
+	return time.Duration(o.RedisOutageGrace) * time.Second
+}
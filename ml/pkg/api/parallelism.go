@@ -0,0 +1,25 @@
+package api
+
+import "fmt"
+
+// ValidateMaxParallelism checks that the max parallelism is not negative and
+// that it does not cap the job below the parallelism it starts with
+func (o TrainOptions) ValidateMaxParallelism() error {
+	if o.MaxParallelism < 0 {
+		return fmt.Errorf("max parallelism should not be negative, got %d", o.MaxParallelism)
+	}
+	if o.MaxParallelism > 0 && o.DefaultParallelism > 0 && o.MaxParallelism < o.DefaultParallelism {
+		return fmt.Errorf("max parallelism (%d) should not be lower than the default parallelism (%d)",
+			o.MaxParallelism, o.DefaultParallelism)
+	}
+	return nil
+}
+
+// CapParallelism returns the parallelism limited to the max parallelism
+// of the job, and whether it had to be lowered
+func (o TrainOptions) CapParallelism(parallelism int) (int, bool) {
+	if o.MaxParallelism > 0 && parallelism > o.MaxParallelism {
+		return o.MaxParallelism, true
+	}
+	return parallelism, false
+}
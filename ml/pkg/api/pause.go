@@ -0,0 +1,33 @@
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// PauseHeartbeat is the interval at which a paused job
+// refreshes its history so it is not taken for crashed
+const PauseHeartbeat = time.Minute
+
+// PauseRequest pauses a running job, which releases its functions after the
+// epoch in progress and waits until it is resumed. If Until is set the job
+// resumes by itself at that time
+type PauseRequest struct {
+	Until time.Time `json:"until,omitempty"`
+}
+
+// JobPause is reported in the state and the history of a job while it is paused
+// through the API. Epoch is the last epoch the job completed before pausing
+type JobPause struct {
+	Epoch int       `json:"epoch"`
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until,omitempty"`
+}
+
+// Validate checks that the auto resume time, if any, is in the future
+func (r PauseRequest) Validate(now time.Time) error {
+	if !r.Until.IsZero() && !r.Until.After(now) {
+		return fmt.Errorf("the job should resume in the future, got %v", r.Until.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ResponseSchemaVersion is the version of the responses of the functions known by the
+// jobs. The functions report the version they answer with in the HeaderResponseSchema
+// header of their init response, and the job checks every response against it
+const (
+	ResponseSchemaVersion = 1
+	HeaderResponseSchema  = "X-Kubeml-Response-Schema"
+
+	// maxResponseExcerpt is the number of bytes of an invalid
+	// response included in the error returned for it
+	maxResponseExcerpt = 500
+)
+
+type (
+	// ResponseSchema lists the fields of the response of a function task. The
+	// fields are all numbers, Prefixes being the prefixes of the fields named
+	// after the classes of the dataset, see MetricsPerClass
+	ResponseSchema struct {
+		Task     string
+		Required []string
+		Optional []string
+		Prefixes []string
+	}
+
+	// ResponseError is returned for a function response that does not match the
+	// schema of its task, with the field that failed and the start of the body
+	ResponseError struct {
+		Task   string
+		Field  string
+		Reason string
+		Body   string
+	}
+
+	// InferResult is the response of the functions to an inference, failed is
+	// only set if the request allows partial results
+	InferResult struct {
+		Predictions []interface{}  `json:"predictions"`
+		OutputType  string         `json:"output_type,omitempty"`
+		Failed      []InferFailure `json:"failed,omitempty"`
+	}
+)
+
+// Schemas of the responses of the function tasks in ResponseSchemaVersion
+var (
+	TrainResponseSchema = ResponseSchema{
+		Task:     "train",
+		Required: []string{"loss"},
+		Optional: []string{"capacity", "length", "devices"},
+	}
+	ValidationResponseSchema = ResponseSchema{
+		Task:     "val",
+		Required: []string{"loss", "accuracy", "length"},
+		Prefixes: []string{ClassCorrectPrefix, ClassTotalPrefix},
+	}
+	CanaryResponseSchema = ResponseSchema{
+		Task:     "canary",
+		Required: []string{"loss", "accuracy", "length"},
+	}
+)
+
+func (e *ResponseError) Error() string {
+	field := ""
+	if len(e.Field) > 0 {
+		field = fmt.Sprintf(" field \"%s\"", e.Field)
+	}
+	return fmt.Sprintf("invalid %s response:%s %s, body: %s", e.Task, field, e.Reason, e.Body)
+}
+
+// NewResponseError returns the error of an invalid response of the task,
+// keeping the first bytes of the body so the response can be told apart
+func NewResponseError(task, field, reason string, body []byte) *ResponseError {
+	excerpt := string(body)
+	if len(body) > maxResponseExcerpt {
+		excerpt = string(body[:maxResponseExcerpt]) + "..."
+	}
+	return &ResponseError{Task: task, Field: field, Reason: reason, Body: excerpt}
+}
+
+// CheckResponseSchema checks the schema version reported by a function in its
+// init response, empty if the function does not report one. Functions that do not
+// report it answer in the loose format, which is only accepted if allowed
+func CheckResponseSchema(version string, allowLoose bool) error {
+	if len(version) == 0 {
+		if allowLoose {
+			return nil
+		}
+		return fmt.Errorf("the function does not report the schema of its responses, " +
+			"update the kubeml library of the function or set loose_responses to accept them")
+	}
+	if version != fmt.Sprint(ResponseSchemaVersion) {
+		return fmt.Errorf("the function answers with response schema %s, but the job supports %d",
+			version, ResponseSchemaVersion)
+	}
+	return nil
+}
+
+// Metrics checks the fields of a response decoded from body against the schema and
+// returns them as numbers. Unless strict, unknown fields are ignored and missing ones
+// are zero, as the responses were read before the schema was versioned
+func (s ResponseSchema) Metrics(fields map[string]interface{}, body []byte, strict bool) (map[string]float64, error) {
+	metrics := make(map[string]float64, len(fields))
+
+	// the fields are checked in order so the same body fails on the same field
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !s.known(name) {
+			if strict {
+				return nil, NewResponseError(s.Task, name, "is unknown", body)
+			}
+			continue
+		}
+		value, ok := toFloat(fields[name])
+		if !ok {
+			return nil, NewResponseError(s.Task, name, fmt.Sprintf("should be a number, got %T", fields[name]), body)
+		}
+		metrics[name] = value
+	}
+
+	if strict {
+		for _, name := range s.Required {
+			if _, exists := fields[name]; !exists {
+				return nil, NewResponseError(s.Task, name, "is missing", body)
+			}
+		}
+	}
+	return metrics, nil
+}
+
+// known returns whether the field is part of the schema
+func (s ResponseSchema) known(name string) bool {
+	for _, field := range s.Required {
+		if field == name {
+			return true
+		}
+	}
+	for _, field := range s.Optional {
+		if field == name {
+			return true
+		}
+	}
+	for _, prefix := range s.Prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// toFloat converts a number decoded from JSON or msgpack to a float
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
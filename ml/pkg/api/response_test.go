@@ -0,0 +1,53 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewResponseError(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"short body", `{"loss": "a"}`, `{"loss": "a"}`},
+		{"body at the limit", strings.Repeat("a", maxResponseExcerpt), strings.Repeat("a", maxResponseExcerpt)},
+		{"long body", strings.Repeat("a", maxResponseExcerpt+1), strings.Repeat("a", maxResponseExcerpt) + "..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewResponseError("train", "loss", "is missing", []byte(tt.body)).Body; got != tt.want {
+				t.Errorf("got body of %d bytes, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+
+	err := NewResponseError("val", "length", "is missing", []byte("{}"))
+	if want := `invalid val response: field "length" is missing, body: {}`; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+}
+
+func TestCheckResponseSchema(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    string
+		allowLoose bool
+		wantError  bool
+	}{
+		{"current version", "1", false, false},
+		{"loose allowed", "", true, false},
+		{"loose not allowed", "", false, true},
+		{"newer version", "2", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckResponseSchema(tt.version, tt.allowLoose); (err != nil) != tt.wantError {
+				t.Errorf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package api
+
+import "fmt"
+
+// DefaultMaxInitialLoss is the largest loss the startup probe accepts
+// on the first batch, unless the job sets a different one
+const DefaultMaxInitialLoss = 1e4
+
+// SanityCheck is the result of the single batch the job trains on before the first
+// epoch to catch a function that does not match the dataset. The shapes and the
+// labels are reported by the function, Error is set if the check failed
+type SanityCheck struct {
+	Passed  bool    `json:"passed"`
+	Skipped bool    `json:"skipped,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	Elapsed float64 `json:"elapsed"`
+
+	InputShape  []int64 `json:"input_shape,omitempty"`
+	LabelShape  []int64 `json:"label_shape,omitempty"`
+	OutputShape []int64 `json:"output_shape,omitempty"`
+	// Classes is the size of the last dimension of the output of
+	// the network, and MaxLabel the largest label in the batch.
+	// They are only reported for classification networks
+	Classes  int64   `json:"classes,omitempty"`
+	MaxLabel *int64  `json:"max_label,omitempty"`
+	Loss     float64 `json:"loss"`
+	// ProbeLoss is the loss on the batch after one step, only reported
+	// if the job enables the startup probe. NonFinite holds the losses,
+	// loss or probe_loss, that were not finite along with their value
+	ProbeLoss *float64          `json:"probe_loss,omitempty"`
+	NonFinite map[string]string `json:"non_finite,omitempty"`
+	// ModelParameters and ModelBytes are the size of the model
+	// checked, taken from its summary, see ModelSummary
+	ModelParameters int64 `json:"model_parameters,omitempty"`
+	ModelBytes      int64 `json:"model_bytes,omitempty"`
+}
+
+// Verify compares the shapes reported by the function with each other and with
+// the batch size of the job, returning an error describing the first mismatch
+func (s *SanityCheck) Verify(batchSize int) error {
+	if len(s.InputShape) == 0 {
+		return fmt.Errorf("the function did not report the shape of the batch")
+	}
+
+	datapoints := s.InputShape[0]
+	if datapoints > int64(batchSize) {
+		return fmt.Errorf("the function loaded a batch of %d datapoints but the batch size is %d",
+			datapoints, batchSize)
+	}
+	if len(s.LabelShape) > 0 && s.LabelShape[0] != datapoints {
+		return fmt.Errorf("the batch has %d inputs %v but %d labels %v",
+			datapoints, s.InputShape, s.LabelShape[0], s.LabelShape)
+	}
+	if len(s.OutputShape) > 0 && s.OutputShape[0] != datapoints {
+		return fmt.Errorf("the network returned %d outputs %v for %d inputs %v",
+			s.OutputShape[0], s.OutputShape, datapoints, s.InputShape)
+	}
+	if s.Classes > 0 && s.MaxLabel != nil && *s.MaxLabel >= s.Classes {
+		return fmt.Errorf("the dataset has label %d but the network only outputs %d classes",
+			*s.MaxLabel, s.Classes)
+	}
+	return nil
+}
+
+// VerifyLoss checks the losses of the startup probe, returning an error that points
+// at the likely cause if the loss of the batch is not finite or over the limit, or
+// if it stops being so after one step with the learning rate of the job
+func (s *SanityCheck) VerifyLoss(limit float64, lr float32) error {
+	if value, exists := s.NonFinite["loss"]; exists {
+		return fmt.Errorf("the loss of the first batch is %s before any update, check the scaling of "+
+			"the data (set the normalization mean and std), the labels and the loss of the network", value)
+	}
+	if s.Loss > limit {
+		return fmt.Errorf("the loss of the first batch is %v, over the limit of %v, the data is probably "+
+			"not scaled (set the normalization mean and std) or the labels do not match the loss", s.Loss, limit)
+	}
+
+	if value, exists := s.NonFinite["probe_loss"]; exists {
+		return fmt.Errorf("the loss is %s after one step with learning rate %v, "+
+			"the learning rate is probably too high", value, lr)
+	}
+	if s.ProbeLoss == nil {
+		return fmt.Errorf("the function did not report the loss after one step, it may not support the startup probe")
+	}
+	if *s.ProbeLoss > limit {
+		return fmt.Errorf("the loss grows from %v to %v after one step with learning rate %v, "+
+			"the learning rate is probably too high", s.Loss, *s.ProbeLoss, lr)
+	}
+	return nil
+}
+
+// InitialLossLimit returns the largest loss the startup probe accepts
+func (o TrainOptions) InitialLossLimit() float64 {
+	if o.MaxInitialLoss <= 0 {
+		return DefaultMaxInitialLoss
+	}
+	return o.MaxInitialLoss
+}
+
+// ValidateStartupProbe checks that the startup probe runs with the
+// sanity check it is part of and that its loss limit is not negative
+func (o TrainOptions) ValidateStartupProbe() error {
+	if o.MaxInitialLoss < 0 {
+		return fmt.Errorf("max initial loss should not be negative, got %v", o.MaxInitialLoss)
+	}
+	if o.MaxInitialLoss > 0 && !o.StartupProbe {
+		return fmt.Errorf("max initial loss is only used by the startup probe, which is disabled")
+	}
+	if o.StartupProbe && o.SkipSanityCheck {
+		return fmt.Errorf("the startup probe runs with the sanity check, which is skipped")
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// Headers of an inference run on the snapshot of a model still being trained,
+// with the generation of the snapshot and the epoch it was taken after
+const (
+	HeaderSnapshotGeneration = "X-Kubeml-Snapshot-Generation"
+	HeaderSnapshotEpoch      = "X-Kubeml-Snapshot-Epoch"
+)
+
+const (
+	// SnapshotsKept is the number of snapshots each job keeps in redis, so an
+	// inference routed to a snapshot can still read it while the next is published
+	SnapshotsKept = 2
+
+	// DefaultLiveInferenceConcurrency is the number of inferences on models
+	// still being trained the controller runs at once, apart from the rest
+	DefaultLiveInferenceConcurrency = 2
+)
+
+// LiveSnapshot is a copy of the reference model of a running job taken after the
+// merge of an epoch, when no function is updating it. ModelId is the id the copy is
+// saved under, which the inference functions load as any other model
+type LiveSnapshot struct {
+	JobId      string    `json:"job_id"`
+	ModelId    string    `json:"model_id"`
+	Generation int       `json:"generation"`
+	Epoch      int       `json:"epoch"`
+	Created    time.Time `json:"created"`
+}
+
+// SnapshotModelId returns the id the snapshot of a job is saved under, which
+// starts with the job id so it is deleted along with the tensors of the job
+func SnapshotModelId(jobId string, generation int) string {
+	return fmt.Sprintf("%s-snapshot-%d", jobId, generation)
+}
+
+// LiveInferenceAllowed returns whether inferences can be run on the
+// snapshots of the model while it is trained, true unless disabled
+func (o TrainOptions) LiveInferenceAllowed() bool {
+	return o.AllowLiveInference == nil || *o.AllowLiveInference
+}
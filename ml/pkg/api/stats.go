@@ -0,0 +1,46 @@
+package api
+
+import "time"
+
+// Cluster overview
+const (
+	// DefaultStatsWindow is the default period covered by the overview
+	DefaultStatsWindow = 7 * 24 * time.Hour
+
+	// DefaultStatsTop and MaxStatsTop are the default and maximum
+	// number of entries of the lists of busiest datasets and functions
+	DefaultStatsTop = 5
+	MaxStatsTop     = 50
+)
+
+type (
+	// StatsOverview aggregates the jobs that started or finished in a time window.
+	// Function seconds are the epoch durations times the parallelism of the epoch,
+	// and the time to accuracy is the training time of the jobs that reached their
+	// goal accuracy, since they stop once it is reached
+	StatsOverview struct {
+		Since     time.Time `json:"since"`
+		Until     time.Time `json:"until"`
+		Generated time.Time `json:"generated"`
+
+		Jobs        int64   `json:"jobs"`
+		Running     int64   `json:"running"`
+		Succeeded   int64   `json:"succeeded"`
+		Failed      int64   `json:"failed"`
+		SuccessRate float64 `json:"success_rate"`
+
+		FunctionSeconds   float64 `json:"function_seconds"`
+		GoalReached       int64   `json:"goal_reached"`
+		AvgTimeToAccuracy float64 `json:"avg_time_to_accuracy"`
+
+		TopDatasets  []StatsEntry `json:"top_datasets"`
+		TopFunctions []StatsEntry `json:"top_functions"`
+	}
+
+	// StatsEntry is the usage of a dataset or a function in the window
+	StatsEntry struct {
+		Name            string  `json:"name" bson:"_id"`
+		Jobs            int64   `json:"jobs" bson:"jobs"`
+		FunctionSeconds float64 `json:"function_seconds" bson:"functionseconds"`
+	}
+)
@@ -0,0 +1,31 @@
+package api
+
+// Status of a job as derived from its history
+const (
+	JobRunning   = "running"
+	JobPaused    = "paused"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobStopped   = "stopped"
+)
+
+// ForceStoppedError is the error saved in the history
+// of the jobs stopped through the API
+const ForceStoppedError = "job was force stopped"
+
+// Status returns the status of the job the history belongs to. Jobs ended
+// by a stop rule are completed, since the rule is part of the request
+func (h *History) Status() string {
+	switch {
+	case h.InProgress && h.Paused != nil:
+		return JobPaused
+	case h.InProgress:
+		return JobRunning
+	case h.Error == ForceStoppedError:
+		return JobStopped
+	case len(h.Error) > 0:
+		return JobFailed
+	default:
+		return JobCompleted
+	}
+}
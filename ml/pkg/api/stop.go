@@ -0,0 +1,153 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Comparison operators of the stop rules
+const (
+	OpGreater      = ">"
+	OpGreaterEqual = ">="
+	OpLess         = "<"
+	OpLessEqual    = "<="
+)
+
+// StopRule stops a job once the latest value of a metric
+// of the history compares to the threshold with the operator,
+// e.g. train_loss > 10 to stop a job that diverges
+type StopRule struct {
+	Metric    string  `json:"metric"`
+	Op        string  `json:"op"`
+	Threshold float64 `json:"threshold"`
+}
+
+// ParseStopRule parses a rule written as <metric><op><threshold>, e.g. train_loss>10
+func ParseStopRule(s string) (StopRule, error) {
+	// the two character operators are checked first
+	// so >= is not taken as > followed by =
+	for _, op := range []string{OpGreaterEqual, OpLessEqual, OpGreater, OpLess} {
+		i := strings.Index(s, op)
+		if i < 0 {
+			continue
+		}
+
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(s[i+len(op):]), 64)
+		if err != nil {
+			return StopRule{}, fmt.Errorf("invalid threshold in stop rule \"%s\"", s)
+		}
+		return StopRule{
+			Metric:    strings.TrimSpace(s[:i]),
+			Op:        op,
+			Threshold: threshold,
+		}, nil
+	}
+	return StopRule{}, fmt.Errorf("stop rule \"%s\" has no operator, expected one of >, >=, <, <=", s)
+}
+
+// Validate checks that the metric is kept in the history and the operator is known
+func (r StopRule) Validate() error {
+	if !isMetric(r.Metric) {
+		return fmt.Errorf("unknown metric %s in stop rule %s", r.Metric, r)
+	}
+
+	switch r.Op {
+	case OpGreater, OpGreaterEqual, OpLess, OpLessEqual:
+		return nil
+	default:
+		return fmt.Errorf("unknown operator \"%s\" in stop rule %s", r.Op, r)
+	}
+}
+
+// Triggered returns true if the value fires the rule
+func (r StopRule) Triggered(value float64) bool {
+	switch r.Op {
+	case OpGreater:
+		return value > r.Threshold
+	case OpGreaterEqual:
+		return value >= r.Threshold
+	case OpLess:
+		return value < r.Threshold
+	case OpLessEqual:
+		return value <= r.Threshold
+	default:
+		return false
+	}
+}
+
+func (r StopRule) String() string {
+	return fmt.Sprintf("%s%s%v", r.Metric, r.Op, r.Threshold)
+}
+
+// ValidateStopRules checks all the stop rules set in the options
+func (o TrainOptions) ValidateStopRules() error {
+	for _, r := range o.StopRules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FiredStopRule returns the first rule fired by the latest value of
+// its metric in the history, or nil if none of the rules fired. The
+// rules of metrics without values are skipped
+func (h *JobHistory) FiredStopRule(rules []StopRule) *StopRule {
+	for i, r := range rules {
+		values, _ := h.Series(r.Metric)
+		if len(values) == 0 {
+			continue
+		}
+		if r.Triggered(values[len(values)-1]) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// TrainImprovementWindow returns the epochs over which the
+// train loss must improve, applying the default if not set
+func (o TrainOptions) TrainImprovementWindow() int {
+	if o.ImprovementWindow <= 0 {
+		return DefaultImprovementWindow
+	}
+	return o.ImprovementWindow
+}
+
+// ValidateStallGuard checks the minimum improvement of the train loss and its window
+func (o TrainOptions) ValidateStallGuard() error {
+	if o.MinTrainImprovement < 0 || o.MinTrainImprovement >= 1 {
+		return fmt.Errorf("minimum train improvement should be between 0 and 1, got %v", o.MinTrainImprovement)
+	}
+	if o.ImprovementWindow < 0 {
+		return fmt.Errorf("improvement window should not be negative, got %v", o.ImprovementWindow)
+	}
+	return nil
+}
+
+// Stalled returns whether the train loss improved less than the minimum of the options
+// over the window, along with the improvement. The guard only applies once the history
+// covers the whole window, and a loss that is not a number counts as stalled
+func (h *JobHistory) Stalled(opts TrainOptions) (bool, float64) {
+	window := opts.TrainImprovementWindow()
+	if opts.MinTrainImprovement <= 0 || len(h.TrainLoss) <= window {
+		return false, 0
+	}
+
+	prev := h.TrainLoss[len(h.TrainLoss)-1-window]
+	curr := h.TrainLoss[len(h.TrainLoss)-1]
+	if prev == 0 {
+		return false, 0
+	}
+
+	improvement := (prev - curr) / math.Abs(prev)
+	return !(improvement >= opts.MinTrainImprovement), improvement
+}
+
+// isMetric returns whether the metric is one of the metrics kept in the history
+func isMetric(metric string) bool {
+	var h JobHistory
+	return h.SetEpochMetric(metric, 1, 0) == nil
+}
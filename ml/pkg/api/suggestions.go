@@ -0,0 +1,115 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// SuggestionRuns is the most recent runs of a function and
+	// a dataset the suggestions of a train request are taken from
+	SuggestionRuns = 20
+
+	// HeaderSuggestions carries the suggestions of a train request
+	// as JSON in the response of the controller
+	HeaderSuggestions = "X-Kubeml-Suggestions"
+
+	// Options of a train request that can be filled from the suggestions
+	SuggestParallelism = "parallelism"
+	SuggestK           = "k"
+)
+
+// Suggestions are the settings suggested for a train request from the previous runs
+// of its function and dataset. EpochDuration is the median of the mean epoch time of
+// the runs, Parallelism the one recommended by the efficiency curve of the last run
+// with one and K the median of the K the runs ended with. The fields are not set if
+// no run had them, and Note tells why nothing is suggested
+type Suggestions struct {
+	Function       string  `json:"function"`
+	Dataset        string  `json:"dataset"`
+	Runs           int     `json:"runs"`
+	EpochDuration  float64 `json:"epoch_duration,omitempty"`
+	Parallelism    int     `json:"parallelism,omitempty"`
+	ParallelismJob string  `json:"parallelism_job,omitempty"`
+	K              *int    `json:"k,omitempty"`
+	Note           string  `json:"note,omitempty"`
+}
+
+// ValidateSuggestions checks that the options to fill from the suggestions are known
+func (o TrainOptions) ValidateSuggestions() error {
+	for _, option := range o.UseSuggestions {
+		if option != SuggestParallelism && option != SuggestK {
+			return fmt.Errorf("unknown option %s to fill from the suggestions, expected %s or %s",
+				option, SuggestParallelism, SuggestK)
+		}
+	}
+	return nil
+}
+
+// Suggest computes the suggestions for the function and the dataset from the histories
+// of their most recent runs and the parallelism recommended for them, if any. The runs
+// that failed or have no epochs are left out
+func Suggest(function, dataset string, histories []History, recommendation *ParallelismRecommendation) *Suggestions {
+	s := &Suggestions{Function: function, Dataset: dataset}
+
+	var durations, ks []float64
+	for _, h := range histories {
+		epochs := len(h.Data.EpochDuration)
+		if len(h.Error) > 0 || epochs == 0 {
+			continue
+		}
+		s.Runs++
+
+		// the epoch durations are the time elapsed since the start
+		durations = append(durations, h.Data.EpochDuration[epochs-1]/float64(epochs))
+		k := float64(h.Task.Options.K)
+		if n := len(h.Data.K); n > 0 {
+			k = h.Data.K[n-1]
+		}
+		ks = append(ks, k)
+	}
+
+	if recommendation != nil {
+		s.Parallelism = recommendation.Parallelism
+		s.ParallelismJob = recommendation.JobId
+	}
+	if s.Runs == 0 {
+		s.Note = fmt.Sprintf("no previous runs of function %s on dataset %s, using the defaults", function, dataset)
+		return s
+	}
+
+	s.EpochDuration = median(durations)
+	k := int(median(ks))
+	s.K = &k
+	return s
+}
+
+// ApplySuggestions fills the options the request takes from the suggestions,
+// recording each change in the effective options of the request
+func (r *TrainRequest) ApplySuggestions(s *Suggestions) {
+	for _, option := range r.Options.UseSuggestions {
+		switch {
+		case option == SuggestParallelism && s.Parallelism > 0:
+			original := r.Options.DefaultParallelism
+			r.Options.DefaultParallelism = s.Parallelism
+			r.RecordChange("options.default_parallelism", original, r.Options.DefaultParallelism,
+				fmt.Sprintf("suggested from the efficiency curve of job %s", s.ParallelismJob))
+		case option == SuggestK && s.K != nil:
+			original := r.Options.K
+			r.Options.K = *s.K
+			r.RecordChange("options.k", original, r.Options.K,
+				fmt.Sprintf("suggested from the K of the last %d runs", s.Runs))
+		}
+	}
+}
+
+// median returns the median of the values, which must not be empty
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
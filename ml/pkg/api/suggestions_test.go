@@ -0,0 +1,108 @@
+package api
+
+import "testing"
+
+func TestSuggest(t *testing.T) {
+	run := func(k int, durations []float64, ks []float64, err string) History {
+		return History{
+			Task:  TrainRequest{Options: TrainOptions{K: k}},
+			Data:  JobHistory{EpochDuration: durations, K: ks},
+			Error: err,
+		}
+	}
+	histories := []History{
+		run(8, []float64{30, 60, 90}, []float64{8, 16}, ""),
+		// the K of the request is taken if the run did not record any
+		run(4, []float64{40, 80}, nil, ""),
+		run(2, []float64{1, 2}, []float64{2}, "function crashed"),
+		run(2, nil, nil, ""),
+		run(8, []float64{20}, []float64{8}, ""),
+	}
+	recommendation := &ParallelismRecommendation{Parallelism: 6, JobId: "previous"}
+
+	s := Suggest("resnet", "cifar", histories, recommendation)
+	if s.Runs != 3 || s.EpochDuration != 30 || s.K == nil || *s.K != 8 {
+		t.Errorf("got suggestions %+v, want 3 runs, an epoch of 30s and K 8", s)
+	}
+	if s.Parallelism != 6 || s.ParallelismJob != "previous" || len(s.Note) != 0 {
+		t.Errorf("got suggestions %+v, want parallelism 6 from job previous", s)
+	}
+
+	tests := []struct {
+		name        string
+		use         []string
+		parallelism int
+		k           int
+		changes     int
+	}{
+		{"both", []string{SuggestParallelism, SuggestK}, 6, 8, 2},
+		{"only k", []string{SuggestK}, 2, 8, 1},
+		{"none", nil, 2, 4, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := TrainRequest{Options: TrainOptions{DefaultParallelism: 2, K: 4, UseSuggestions: tt.use}}
+			req.ApplySuggestions(s)
+			if req.Options.DefaultParallelism != tt.parallelism || req.Options.K != tt.k {
+				t.Errorf("got parallelism %d and K %d, want %d and %d",
+					req.Options.DefaultParallelism, req.Options.K, tt.parallelism, tt.k)
+			}
+			var changes int
+			if req.Effective != nil {
+				changes = len(req.Effective.Changes)
+			}
+			if changes != tt.changes {
+				t.Errorf("got %d changes, want %d", changes, tt.changes)
+			}
+		})
+	}
+}
+
+func TestSuggestWithoutHistory(t *testing.T) {
+	s := Suggest("resnet", "cifar", []History{{Error: "failed"}}, nil)
+	if s.Runs != 0 || s.K != nil || s.Parallelism != 0 || len(s.Note) == 0 {
+		t.Errorf("got suggestions %+v, want none with a note", s)
+	}
+
+	// the request keeps its options
+	req := TrainRequest{Options: TrainOptions{DefaultParallelism: 2, K: 4,
+		UseSuggestions: []string{SuggestParallelism, SuggestK}}}
+	req.ApplySuggestions(s)
+	if req.Options.DefaultParallelism != 2 || req.Options.K != 4 || req.Effective != nil {
+		t.Errorf("got options %+v and changes %+v, want the options sent", req.Options, req.Effective)
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   float64
+	}{
+		{[]float64{5}, 5},
+		{[]float64{3, 1, 2}, 2},
+		{[]float64{4, 1, 3, 2}, 2.5},
+	}
+
+	for _, tt := range tests {
+		values := append([]float64(nil), tt.values...)
+		if got := median(values); got != tt.want {
+			t.Errorf("got median %v of %v, want %v", got, tt.values, tt.want)
+		}
+		// the values are not sorted in place
+		for i := range values {
+			if values[i] != tt.values[i] {
+				t.Errorf("got values %v sorted by the median", values)
+				break
+			}
+		}
+	}
+}
+
+func TestValidateSuggestions(t *testing.T) {
+	if err := (TrainOptions{UseSuggestions: []string{SuggestParallelism, SuggestK}}).ValidateSuggestions(); err != nil {
+		t.Error(err)
+	}
+	if err := (TrainOptions{UseSuggestions: []string{"batch"}}).ValidateSuggestions(); err == nil {
+		t.Error("got no error filling an unknown option")
+	}
+}
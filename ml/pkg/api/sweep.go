@@ -0,0 +1,152 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TagSweep is the tag holding the id of the sweep a job is part of, shared
+// by the jobs trained with different hyperparameters to compare them
+const TagSweep = "sweep"
+
+// MetricTimeToAccuracy ranks the runs of a sweep by the training time in
+// seconds they took to reach a target accuracy, see FastestRun
+const MetricTimeToAccuracy = "time_to_accuracy"
+
+type (
+	// SweepRun is a job of a sweep along with the best value of the metric
+	// the sweep is ranked by and the epoch it was recorded in
+	SweepRun struct {
+		JobId      string       `json:"job_id"`
+		Value      float64      `json:"value"`
+		Epoch      int          `json:"epoch"`
+		InProgress bool         `json:"in_progress,omitempty"`
+		Task       TrainRequest `json:"task"`
+	}
+
+	// SweepBest is the best run of a sweep by a metric. Runs is the number of
+	// jobs in the sweep and Pending the ones that did not record the metric yet.
+	// Runs ranked by the time to a Target accuracy are all kept in Ranked,
+	// fastest first, and Pending holds the ones that did not reach it
+	SweepBest struct {
+		SweepId   string     `json:"sweep_id"`
+		Metric    string     `json:"metric"`
+		Direction string     `json:"direction"`
+		Target    float64    `json:"target,omitempty"`
+		Best      *SweepRun  `json:"best"`
+		Ranked    []SweepRun `json:"ranked,omitempty"`
+		Runs      int        `json:"runs"`
+		Pending   []string   `json:"pending,omitempty"`
+	}
+)
+
+// ValidateTags checks that the tags have names, which are
+// saved as fields of the history so they cannot hold dots
+func (r TrainRequest) ValidateTags() error {
+	for name := range r.Tags {
+		if len(name) == 0 {
+			return fmt.Errorf("the names of the tags should not be empty")
+		}
+		if strings.ContainsAny(name, ".$") {
+			return fmt.Errorf("tag %s should not contain dots or dollar signs", name)
+		}
+	}
+	return nil
+}
+
+// BestRun returns the run of the sweep with the best value of the metric across
+// all the epochs of the job. The runs are ranked in the direction of the metric
+// in the options of the first job, since all the jobs of a sweep track the same
+// metric. Returns an error if the metric is unknown or no run recorded it
+func BestRun(sweepId, metric string, histories []History) (*SweepBest, error) {
+	if len(histories) == 0 {
+		return nil, fmt.Errorf("sweep %s has no jobs", sweepId)
+	}
+	if _, epochs := histories[0].Data.Series(metric); epochs == nil {
+		return nil, fmt.Errorf("unknown metric %s", metric)
+	}
+
+	// sort the runs so ties are always broken in favor of the same job
+	sort.Slice(histories, func(i, j int) bool {
+		return histories[i].Id < histories[j].Id
+	})
+
+	opts := histories[0].Task.Options
+	result := &SweepBest{
+		SweepId:   sweepId,
+		Metric:    metric,
+		Direction: opts.Direction(metric),
+		Runs:      len(histories),
+	}
+	for i := range histories {
+		h := &histories[i]
+		value, epoch, ok := h.Data.Best(metric, opts)
+		if !ok {
+			result.Pending = append(result.Pending, h.Id)
+			continue
+		}
+		if result.Best == nil || opts.Better(metric, value, result.Best.Value) {
+			result.Best = &SweepRun{
+				JobId:      h.Id,
+				Value:      value,
+				Epoch:      epoch,
+				InProgress: h.InProgress,
+				Task:       h.Task,
+			}
+		}
+	}
+
+	if result.Best == nil {
+		return nil, fmt.Errorf("none of the %d jobs of sweep %s recorded metric %s", len(histories), sweepId, metric)
+	}
+	return result, nil
+}
+
+// FastestRun ranks the runs of the sweep by the training time they took to first
+// reach the target accuracy, see JobHistory.TimeToAccuracy. Returns an error if
+// none of the runs reached it
+func FastestRun(sweepId string, target float64, histories []History) (*SweepBest, error) {
+	if len(histories) == 0 {
+		return nil, fmt.Errorf("sweep %s has no jobs", sweepId)
+	}
+	if target <= 0 || target > 100 {
+		return nil, fmt.Errorf("target accuracy should be between 0 and 100, got %v", target)
+	}
+
+	sort.Slice(histories, func(i, j int) bool {
+		return histories[i].Id < histories[j].Id
+	})
+
+	result := &SweepBest{
+		SweepId:   sweepId,
+		Metric:    MetricTimeToAccuracy,
+		Direction: DirectionMinimize,
+		Target:    target,
+		Runs:      len(histories),
+	}
+	for i := range histories {
+		h := &histories[i]
+		milestone := h.Data.TimeToAccuracy(target, h.Task.Options)
+		if !milestone.Reached {
+			result.Pending = append(result.Pending, h.Id)
+			continue
+		}
+		result.Ranked = append(result.Ranked, SweepRun{
+			JobId:      h.Id,
+			Value:      milestone.Elapsed,
+			Epoch:      milestone.Epoch,
+			InProgress: h.InProgress,
+			Task:       h.Task,
+		})
+	}
+
+	if len(result.Ranked) == 0 {
+		return nil, fmt.Errorf("none of the %d jobs of sweep %s reached accuracy %v", len(histories), sweepId, target)
+	}
+	sort.SliceStable(result.Ranked, func(i, j int) bool {
+		return result.Ranked[i].Value < result.Ranked[j].Value
+	})
+	result.Best = &result.Ranked[0]
+	return result, nil
+}
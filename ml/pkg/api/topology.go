@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Topologies the functions of a job synchronize their models with.
+//
+// With the parameter server, the default, the job fetches the layers of every function
+// from redis after each iteration, merges them and saves the reference model, so all the
+// weights go through the job, which becomes the bottleneck with large models or many
+// functions. It supports every merge operator and the layer sync overrides.
+//
+// With allreduce the job only coordinates the iterations. Once every function saved its
+// model, the job assigns each one a part of the layers, balanced by their size, and the
+// function averages its part over all the functions and saves it as the reference. The
+// functions load the reference once every part is averaged. The merge is spread over the
+// functions at the cost of a second round trip to the job per iteration and of each
+// function reading the layers of all the others, so it pays off with few large layers
+// and slow merges in the job. Only the weighted mean is supported, and the last merge of
+// every epoch, which takes the functions that finished their data, is still made by the
+// job, so the model it checkpoints and validates is always its own
+const (
+	SyncTopologyParameterServer = "parameter_server"
+	SyncTopologyAllReduce       = "allreduce"
+)
+
+// AllReduceAssignment is sent to each function in an allreduce iteration with the
+// layers it averages, and the functions and weights it averages them over
+type AllReduceAssignment struct {
+	Layers  []string  `json:"layers"`
+	Funcs   []int     `json:"funcs"`
+	Weights []float64 `json:"weights"`
+}
+
+// AllReduce returns whether the functions of the job average the layers themselves
+func (o TrainOptions) AllReduce() bool {
+	return o.SyncTopology == SyncTopologyAllReduce
+}
+
+// ValidateSyncTopology checks that the topology is known, and that a job with the
+// allreduce topology only uses the features the functions can average on their own
+func (o TrainOptions) ValidateSyncTopology() error {
+	switch o.SyncTopology {
+	case "", SyncTopologyParameterServer:
+		return nil
+	case SyncTopologyAllReduce:
+	default:
+		return fmt.Errorf("unknown sync topology %s, expected %s or %s",
+			o.SyncTopology, SyncTopologyParameterServer, SyncTopologyAllReduce)
+	}
+
+	switch {
+	case o.K <= 0:
+		return fmt.Errorf("the allreduce topology needs a positive K, syncing once per epoch is always merged by the job")
+	case len(o.MergeOperator) > 0 && o.MergeOperator != MergeMean:
+		return fmt.Errorf("the allreduce topology only averages the layers, got merge operator %s", o.MergeOperator)
+	case o.ByzantineTolerance > 0:
+		return fmt.Errorf("the allreduce topology can't tolerate byzantine functions")
+	case len(o.LayerSyncOverrides) > 0:
+		return fmt.Errorf("the allreduce topology can't be used with layer sync overrides")
+	case o.ValidatesLatest():
+		return fmt.Errorf("the allreduce topology can't validate the latest merge, since the functions write it")
+	}
+	return nil
+}
+
+// AssignLayers splits the layers among n functions so that each averages about the
+// same bytes, taking the largest layers first and giving each to the function with
+// the fewest bytes so far. Some functions get no layers if there are fewer than n
+func AssignLayers(layers []LayerSummary, n int) [][]string {
+	sorted := append([]LayerSummary(nil), layers...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Bytes > sorted[j].Bytes })
+
+	assigned := make([][]string, n)
+	bytes := make([]int64, n)
+	for _, layer := range sorted {
+		least := 0
+		for i := range bytes {
+			if bytes[i] < bytes[least] {
+				least = i
+			}
+		}
+		assigned[least] = append(assigned[least], layer.Name)
+		bytes[least] += layer.Bytes
+	}
+	return assigned
+}
@@ -1,7 +1,16 @@
 package api
 
 import (
+	"fmt"
 	corev1 "k8s.io/api/core/v1"
+	"time"
+)
+
+// The confidence of the ETA is low when it is based on few
+// epochs or on the extrapolation of the accuracy trend
+const (
+	ETAConfidenceLow  ETAConfidence = "low"
+	ETAConfidenceHigh ETAConfidence = "high"
 )
 
 // Types used by the APIs of the controller and the scheduler
@@ -17,7 +26,57 @@ type (
 		Dataset      string       `json:"dataset"`
 		LearningRate float32      `json:"lr"`
 		FunctionName string       `json:"function_name"`
+		ScratchGB    int          `json:"scratch_gb,omitempty"`
 		Options      TrainOptions `json:"options,omitempty"`
+
+		// Tags are labels set by the user to group jobs, such
+		// as the sweep the job is part of, see TagSweep
+		Tags map[string]string `json:"tags,omitempty"`
+
+		// Command are the arguments of the command that submitted the
+		// job, with the values of the secret flags redacted
+		Command []string `json:"command,omitempty"`
+
+		// NormalizationMean and NormalizationStd are the per-channel stats
+		// of the dataset, passed to the functions to normalize the data
+		NormalizationMean []float64 `json:"normalization_mean,omitempty"`
+		NormalizationStd  []float64 `json:"normalization_std,omitempty"`
+
+		// PlannedIterations is the number of merges per epoch computed
+		// by the controller when the job is admitted
+		PlannedIterations int `json:"planned_iterations,omitempty"`
+
+		// DatasetShards is the number of train shards of the dataset
+		// counted by the controller when the job is admitted
+		DatasetShards int64 `json:"dataset_shards,omitempty"`
+
+		// NotifySecret is generated by the controller to sign the notifications
+		// of the job, it is removed from the tasks and histories returned
+		NotifySecret string `json:"notify_secret,omitempty"`
+
+		// BudgetOverride admits the request even if it exceeds the admission
+		// limits of the controller. It is kept in the task only if it was used
+		BudgetOverride bool `json:"budget_override,omitempty"`
+
+		// Effective are the settings the job ran with after the changes made
+		// by the controller and the job, set once the request is admitted
+		Effective *EffectiveOptions `json:"effective,omitempty"`
+
+		// Recommendation is the parallelism recommended by the last job of the
+		// same function and dataset, attached by the controller when the job is
+		// admitted. The scheduler starts the job from it unless it is static
+		Recommendation *ParallelismRecommendation `json:"recommendation,omitempty"`
+	}
+
+	// TrainResponse is returned by the controller when a train job is
+	// created, along with the planned iterations per epoch, the warning
+	// about the configuration of K and the secret of the notifications
+	TrainResponse struct {
+		Id                 string
+		IterationsPerEpoch int
+		Warning            string
+		NotifySecret       string
+		Suggestions        *Suggestions
 	}
 
 	// TrainOptions allows users to define extra configurations for the
@@ -31,12 +90,218 @@ type (
 		K int `json:"k"`
 		// GoalAccuracy accuracy objective, after which we'll stop the training
 		GoalAccuracy float64 `json:"goal_accuracy"`
+		// GoalScale is the scale of the goal accuracy and the quiet margin,
+		// AccuracyScaleFraction or AccuracyScalePercent. Unset, they are in
+		// the scale of the accuracy reported by the function, see NormalizeGoal
+		GoalScale string `json:"goal_scale,omitempty"`
+		// CanaryBatchSize is the number of datapoints of the fixed validation
+		// batch evaluated after every epoch, 0 disables the canary validation
+		CanaryBatchSize int `json:"canary_batch_size"`
+		// Serialization is the format of the payloads exchanged with
+		// the functions, either json (default) or msgpack
+		Serialization string `json:"serialization,omitempty"`
+		// TraceScheduler makes the scheduler keep a trace of the
+		// parallelism decisions taken for the job
+		TraceScheduler bool `json:"trace_scheduler,omitempty"`
+		// AccuracyDecimals and LossDecimals are the decimal places the
+		// metrics are rounded to, 0 uses the default precision
+		AccuracyDecimals int `json:"accuracy_decimals,omitempty"`
+		LossDecimals     int `json:"loss_decimals,omitempty"`
+		// ValidationQuorum is the fraction of validation functions that must
+		// report for a validation to be valid, 0 requires a majority
+		ValidationQuorum float64 `json:"validation_quorum,omitempty"`
+		// SchedulerTimeout is the time in seconds the job waits for the
+		// scheduler to send the next parallelism, 0 uses the default
+		SchedulerTimeout int `json:"scheduler_timeout,omitempty"`
+		// RedisBudgetMB is the maximum memory in MB the tensors of the job
+		// can use in redis, 0 uses the default of the cluster
+		RedisBudgetMB int `json:"redis_budget_mb,omitempty"`
+		// MetricDirection sets whether each metric is maximized or minimized
+		// when checking the goal and tracking the best value, by default the
+		// accuracies are maximized and the rest of the metrics minimized
+		MetricDirection map[string]string `json:"metric_direction,omitempty"`
+		// NotifyURL receives an event with the metrics of the
+		// job after every epoch, see EpochEvent
+		NotifyURL string `json:"notify_url,omitempty"`
+		// StopRules stop the job as soon as any of them fires, they
+		// are evaluated after every epoch on the latest value of the metric
+		StopRules []StopRule `json:"stop_rules,omitempty"`
+		// ThroughputTrigger is the relative change of the epoch time since the
+		// last scheduler decision after which the job asks the scheduler before
+		// the decision expires, 0 uses the default
+		ThroughputTrigger float64 `json:"throughput_trigger,omitempty"`
+		// PlateauTrigger is the relative improvement of the train loss in an
+		// epoch below which the job asks the scheduler before the decision
+		// expires, 0 disables it
+		PlateauTrigger float64 `json:"plateau_trigger,omitempty"`
+		// ShuffleSeed makes the functions shuffle their training data with seeds
+		// derived from it, 0 trains on the data in order
+		ShuffleSeed int64 `json:"shuffle_seed,omitempty"`
+		// AuditData keeps a record of the data each function trained
+		// on in every epoch, see DataAudit
+		AuditData bool `json:"audit_data,omitempty"`
+		// LogLevel is the initial log level of the job, it can be changed
+		// while the job runs. Empty logs everything
+		LogLevel string `json:"log_level,omitempty"`
+		// CheckpointBackend is where the model is checkpointed after every
+		// epoch besides redis, either s3 or gcs. Empty keeps it only in redis
+		CheckpointBackend string `json:"checkpoint_backend,omitempty"`
+		// ResumeFrom is the id of a previous job whose last checkpoint is used
+		// as the initial model. ResumeBackend is the backend the checkpoint is
+		// read from, set by the controller from the history of that job
+		ResumeFrom    string `json:"resume_from,omitempty"`
+		ResumeBackend string `json:"resume_backend,omitempty"`
+		// ContinueFrom is set by the controller in the requests that train a
+		// finished job for more epochs, see History.Continue. The job keeps the
+		// id of the finished one and appends the new epochs to its history
+		ContinueFrom string `json:"continue_from,omitempty"`
+		// KeepLast and KeepBest prune the checkpoints saved in object storage
+		// down to the most recent ones and the best ones by the KeepBestBy
+		// validation metric (accuracy by default). 0 for both keeps them all
+		KeepLast   int    `json:"keep_last,omitempty"`
+		KeepBest   int    `json:"keep_best,omitempty"`
+		KeepBestBy string `json:"keep_best_by,omitempty"`
+		// SkipSanityCheck starts training without first checking on
+		// a single batch that the function matches the dataset
+		SkipSanityCheck bool `json:"skip_sanity_check,omitempty"`
+		// StartupProbe makes the sanity check take a second step on its batch and
+		// fail the job if the loss before or after the step is not finite or over
+		// MaxInitialLoss, see DefaultMaxInitialLoss
+		StartupProbe   bool    `json:"startup_probe,omitempty"`
+		MaxInitialLoss float64 `json:"max_initial_loss,omitempty"`
+		// MinTrainImprovement stops the job if the train loss does not improve
+		// by at least this fraction over the last ImprovementWindow epochs,
+		// catching a stuck optimizer. 0 disables it, and a window of 0 uses
+		// the default
+		MinTrainImprovement float64 `json:"min_train_improvement,omitempty"`
+		ImprovementWindow   int     `json:"improvement_window,omitempty"`
+		// Metrics are the optional metrics computed by the validation
+		// functions, see MetricsPerClass
+		Metrics []string `json:"metrics,omitempty"`
+		// InvocationMode is how the train and validation functions are
+		// invoked, sync (default) or queue. InvocationTimeout is the time in
+		// seconds a queued invocation can take, 0 uses the default
+		InvocationMode    string `json:"invocation_mode,omitempty"`
+		InvocationTimeout int    `json:"invocation_timeout,omitempty"`
+		// QuietMargin is the distance in accuracy points to the goal under which
+		// the job stops scaling up, keeping or lowering its parallelism until
+		// it finishes. 0 disables it
+		QuietMargin float64 `json:"quiet_margin,omitempty"`
+		// GlobalBatchSize is the batch of all the functions together, the batch
+		// of each function is computed every epoch from the parallelism, see
+		// FunctionBatchSize. 0 keeps the batch size of the request per function
+		GlobalBatchSize int `json:"global_batch_size,omitempty"`
+		// ScaleLRWithParallelism scales the learning rate linearly with the
+		// effective global batch, relative to the one of the first epoch
+		ScaleLRWithParallelism bool `json:"scale_lr_with_parallelism,omitempty"`
+		// BalanceByCapacity splits the data and the global batch among the functions
+		// proportionally to the capacity they reported in the previous epoch, and
+		// weights their models by their share in the merge. Disabled, every function
+		// gets the same data and batch
+		BalanceByCapacity bool `json:"balance_by_capacity,omitempty"`
+		// UseClassWeights weights the loss of each class in the train and validation
+		// functions. ClassWeights are the weight of each class, taken from the dataset
+		// by the controller if empty, see DatasetStats.ResolveClassWeights
+		UseClassWeights bool      `json:"use_class_weights,omitempty"`
+		ClassWeights    []float64 `json:"class_weights,omitempty"`
+		// ValidationModel is the model the periodic validations run against,
+		// final (default) or latest, see ValidationModelLatest
+		ValidationModel string `json:"validation_model,omitempty"`
+		// ValidationFailurePolicy is what the job does when a validation fails,
+		// continue (default), fail or retry, see ValidationFailureRetry.
+		// ValidationRetries is the number of retries of the retry policy
+		ValidationFailurePolicy string `json:"validation_failure_policy,omitempty"`
+		ValidationRetries       int    `json:"validation_retries,omitempty"`
+		// AccuracyMilestones are the validation accuracies whose first occurrence
+		// is recorded in the history, DefaultAccuracyMilestones if empty
+		AccuracyMilestones []float64 `json:"accuracy_milestones,omitempty"`
+		// MaxEpochCost is the most function seconds an epoch should take, counting
+		// every invocation of its train, validation and canary functions. An epoch
+		// over it is flagged and the parallelism of the next one is lowered, 0 disables it
+		MaxEpochCost float64 `json:"max_epoch_cost,omitempty"`
+		// AllowLiveInference lets inferences run on the snapshot of the model taken
+		// after the last merged epoch while it is trained. Allowed unless set to false
+		AllowLiveInference *bool `json:"allow_live_inference,omitempty"`
+		// RedisOutageGrace is the time in seconds the merger waits for redis to
+		// come back when the connection is lost while saving the model, holding
+		// the functions at the merge. 0 fails the epoch after the save retries
+		RedisOutageGrace int `json:"redis_outage_grace,omitempty"`
+		// LossReduction is how the losses reported by the train functions are
+		// combined into the train loss of the epoch, mean (default), sum or
+		// weighted_mean, see LossReductionMean
+		LossReduction string `json:"loss_reduction,omitempty"`
+		// MergeOperator combines the layers of the functions into the reference model
+		// in every merge, mean (default), median, trimmed_mean or krum, see MergeMean
+		MergeOperator string `json:"merge_operator,omitempty"`
+		// ByzantineTolerance is the number of faulty functions whose layers the robust
+		// merge operators must ignore in every merge, see MergeKrum
+		ByzantineTolerance int `json:"byzantine_tolerance,omitempty"`
+		// LooseResponses accepts functions that do not report the schema of their
+		// responses, ignoring unknown fields and taking missing ones as zero. Kept
+		// for one release so older functions can be updated, see ResponseSchemaVersion
+		LooseResponses bool `json:"loose_responses,omitempty"`
+		// MaxParallelism is the most functions the scheduler can give the job,
+		// 0 if it is only bounded by the admission limits
+		MaxParallelism int `json:"max_parallelism,omitempty"`
+		// PolicyWindow is the number of recent epochs the scheduler policy
+		// considers to smooth the epoch time, see DefaultPolicyWindow
+		PolicyWindow int `json:"policy_window,omitempty"`
+		// LayerSyncOverrides give the layers matching their patterns their own
+		// sync cadence, such as the running stats of the batch norm layers
+		LayerSyncOverrides []LayerSyncOverride `json:"layer_sync_overrides,omitempty"`
+		// DevicesPerFunction is the number of GPUs each function trains on, splitting
+		// every step among them so the batch of the request is the batch per device.
+		// The job still counts each function once in its parallelism, 0 uses one device
+		DevicesPerFunction int `json:"devices_per_function,omitempty"`
+		// DurationBuckets are the buckets of the histograms of the durations of
+		// the function invocations, nil uses the defaults, see DurationHistogram
+		DurationBuckets *DurationBuckets `json:"duration_buckets,omitempty"`
+		// StragglerTimeout is the time after which a train invocation is taken as a
+		// straggler, either a duration like 90s or relative to a percentile of the train
+		// invocations of the previous epoch like p95x1.5. Empty uses the StragglerFactor
+		StragglerTimeout string `json:"straggler_timeout,omitempty"`
+		// WarmUpFunctions invokes the functions of the first epoch once before the
+		// training starts, so their cold starts are not measured in the first epoch
+		WarmUpFunctions bool `json:"warm_up_functions,omitempty"`
+		// AdaptiveK adapts K after every epoch to keep the time of the merges around
+		// KTargetOverhead of the compute time, up to MaxK, see NextK. A zero target
+		// or max K uses the defaults
+		AdaptiveK       bool    `json:"adaptive_k,omitempty"`
+		KTargetOverhead float64 `json:"k_target_overhead,omitempty"`
+		MaxK            int     `json:"max_k,omitempty"`
+		// SyncTopology is how the functions synchronize their models, through the
+		// job with the parameter server, the default, or among themselves with
+		// allreduce. See SyncTopologyAllReduce for the tradeoffs
+		SyncTopology string `json:"sync_topology,omitempty"`
+		// UseSuggestions are the options the controller fills from the suggestions
+		// of the previous runs of the function and the dataset, the ones left unset
+		// by the user, see Suggestions
+		UseSuggestions []string `json:"use_suggestions,omitempty"`
 	}
 
 	// InferRequest is sent when wanting to get a result back from a trained network
 	InferRequest struct {
-		ModelId string        `json:"model_id"`
-		Data    []interface{} `json:"data"`
+		ModelId       string        `json:"model_id"`
+		Data          []interface{} `json:"data"`
+		Serialization string        `json:"serialization,omitempty"`
+		// ModelIds are the models of an ensemble inference, set instead of
+		// ModelId, whose outputs for each datapoint are combined with the
+		// Ensemble mode, see EnsembleMean. IncludeIndividual also returns
+		// the outputs of each model
+		ModelIds          []string `json:"model_ids,omitempty"`
+		Ensemble          string   `json:"ensemble,omitempty"`
+		IncludeIndividual bool     `json:"include_individual,omitempty"`
+		// OutputType is the representation returned for each
+		// datapoint, see OutputPrediction. Empty returns predictions
+		OutputType string `json:"output_type,omitempty"`
+		// AllowPartial returns the predictions of the datapoints that succeeded
+		// along with the ones that failed instead of failing the whole request
+		AllowPartial bool `json:"allow_partial,omitempty"`
+		// CheckInput compares the datapoints with the range and type of the train
+		// set of the model, returning a warning if they do not match. StrictInput
+		// rejects the request instead
+		CheckInput  bool `json:"check_input,omitempty"`
+		StrictInput bool `json:"strict_input,omitempty"`
 	}
 
 	// TrainTask associates the train request sent by the user
@@ -56,38 +321,202 @@ type (
 	// pod and service definition definition
 	// Also include the channel for backwards compatibility with the thread deploying
 	// method and with a - so it is ignored
+	//
+	// If the job requested a scratch volume, FunctionName is the name
+	// of the per-job copy of the function that mounts it. RedisBudget is
+	// the memory in bytes the tensors of the job can use in redis as resolved
+	// by the parameter server, 0 if unlimited. HistoryFlushInterval is the time
+	// in seconds between the writes of the history while the job trains
 	JobInfo struct {
-		JobId   string          `json:"id"`
-		State   JobState        `json:"state"`
-		Pod     *corev1.Pod     `json:"-"`
-		Svc     *corev1.Service `json:"-"`
-		Channel chan *JobState  `json:"-"`
+		JobId                string          `json:"id"`
+		State                JobState        `json:"state"`
+		FunctionName         string          `json:"function_name,omitempty"`
+		RedisBudget          int64           `json:"redis_budget,omitempty"`
+		HistoryFlushInterval int             `json:"history_flush_interval,omitempty"`
+		Pod                  *corev1.Pod     `json:"-"`
+		Svc                  *corev1.Service `json:"-"`
+		Channel              chan *JobState  `json:"-"`
 	}
 
-	// JobState holds the training specific variables of the job
+	// JobState holds the training specific variables of the job. ValidFor is
+	// set by the scheduler to the number of epochs its decision holds
 	JobState struct {
 		Parallelism int     `json:"parallelism"`
+		ValidFor    int     `json:"valid_for,omitempty"`
 		ElapsedTime float64 `json:"elapsed_time"`
+		ETA         *ETA    `json:"eta,omitempty"`
+		RedisMemory int64   `json:"redis_memory,omitempty"`
+		// MergePaused is set while the merger waits for redis to come back
+		MergePaused *MergePause `json:"merge_paused,omitempty"`
+		// Paused is set while the job is paused through the API
+		Paused *JobPause `json:"paused,omitempty"`
+		// CappedFrom is the parallelism chosen by the scheduler policy when
+		// it was lowered to the max parallelism of the job
+		CappedFrom int `json:"capped_from,omitempty"`
+		// Recent are the last epochs of the job within the window
+		// of the scheduler policy, see TrainOptions.PolicyWindow
+		Recent []EpochPoint `json:"recent,omitempty"`
+		// MeasuredConcurrency is the most train invocations of the last
+		// epoch that ran at the same time, 0 if it was not measured
+		MeasuredConcurrency int `json:"measured_concurrency,omitempty"`
+	}
+
+	// ETA is the estimated remaining training time of a job
+	// along with the confidence of the estimation
+	ETA struct {
+		Seconds    float64       `json:"seconds"`
+		Confidence ETAConfidence `json:"confidence"`
 	}
 
+	ETAConfidence string
+
 	// JobHistory saves the intermediate results from the training process
-	// epoch to epoch
+	// epoch to epoch. ValidationEpochs holds the epoch in which each of the
+	// validation metrics was recorded
 	JobHistory struct {
-		ValidationLoss []float64 `json:"validation_loss"`
-		Accuracy       []float64 `json:"accuracy"`
-		TrainLoss      []float64 `json:"train_loss"`
-		Parallelism    []float64 `json:"parallelism"`
-		EpochDuration  []float64 `json:"epoch_duration"`
+		ValidationLoss   []float64 `json:"validation_loss"`
+		Accuracy         []float64 `json:"accuracy"`
+		TrainLoss        []float64 `json:"train_loss"`
+		Parallelism      []float64 `json:"parallelism"`
+		EpochDuration    []float64 `json:"epoch_duration"`
+		CanaryLoss       []float64 `json:"canary_loss,omitempty"`
+		CanaryAccuracy   []float64 `json:"canary_accuracy,omitempty"`
+		ValidationEpochs []int     `json:"validation_epochs,omitempty"`
+		// ValidationFunctions is the number of functions that
+		// contributed to each validation
+		ValidationFunctions []float64 `json:"validation_functions,omitempty"`
+		// Iterations is the number of merges of the model in each epoch
+		Iterations []float64 `json:"iterations,omitempty"`
+		// GlobalBatch is the effective global batch of each epoch, the batch of
+		// the functions times the parallelism and their devices, and LearningRate the learning
+		// rate of each epoch, only kept if it is scaled with the parallelism
+		GlobalBatch  []float64 `json:"global_batch,omitempty"`
+		LearningRate []float64 `json:"learning_rate,omitempty"`
+		// ValidationMerges is the number of merges of the epoch included in the
+		// model of each validation, only kept if the job validates the latest model
+		ValidationMerges []float64 `json:"validation_merges,omitempty"`
+		// GradNorm is the average L2 norm of the updates applied to the model by
+		// the merges of each epoch, the change of the weights after averaging the
+		// models of the functions, used to spot vanishing or exploding gradients
+		GradNorm []float64 `json:"grad_norm,omitempty"`
+		// StaleNotifications is the number of finish notifications of each epoch
+		// discarded because they belonged to a previous epoch or iteration
+		StaleNotifications []float64 `json:"stale_notifications,omitempty"`
+		// MergeWait is the time in seconds the merges of each epoch waited for a
+		// merge slot, shared with the other jobs running in the same process
+		MergeWait []float64 `json:"merge_wait,omitempty"`
+		// BarrierWait is the time in seconds the train functions of each epoch were
+		// blocked at the merges waiting for the slowest function to finish its
+		// iteration and for a merge slot, summed over the functions and the merges
+		BarrierWait []float64 `json:"barrier_wait,omitempty"`
+		// EffectiveParallelism is the number of devices the train functions of
+		// each epoch reported, only kept if the functions train on several devices
+		EffectiveParallelism []float64 `json:"effective_parallelism,omitempty"`
+		// EpochCost is the time in seconds the invocations of the functions of each
+		// epoch ran for, including the retries and the validation functions, and
+		// ExpensiveEpochs the epochs whose cost went over MaxEpochCost
+		EpochCost       []float64 `json:"epoch_cost,omitempty"`
+		ExpensiveEpochs []int     `json:"expensive_epochs,omitempty"`
+		// MeasuredConcurrency is the most train invocations of each epoch that ran
+		// at the same time, which is lower than the parallelism if they were queued
+		MeasuredConcurrency []float64 `json:"measured_concurrency,omitempty"`
+		// K is the K each epoch synced with, which changes with the adaptive K,
+		// and MergeOverhead the time of the merges of each epoch as a fraction
+		// of the compute time, see MergeOverhead
+		K             []float64 `json:"k,omitempty"`
+		MergeOverhead []float64 `json:"merge_overhead,omitempty"`
+		// InvocationDurations are the percentiles of the durations of the
+		// invocations of each task in every epoch
+		InvocationDurations []EpochDurations `json:"invocation_durations,omitempty"`
+		// Efficiency is the speed against the cost of the parallelisms the
+		// job trained with, computed when it finishes, see EfficiencyCurve
+		Efficiency *EfficiencyCurve `json:"efficiency,omitempty"`
+		// Capabilities are the operations the function of the job reported
+		// in its init response besides training, such as CapabilityExport.
+		// Nil for the jobs saved before they were reported
+		Capabilities []string `json:"capabilities,omitempty"`
+		// AccuracyScale is the scale of the accuracy reported by the validation
+		// functions, declared in the capabilities or detected from the first
+		// validation. The accuracies of the history are kept in percent once it
+		// is set. Empty for the jobs saved before, which kept them as reported
+		// until they are continued, see ConvertAccuracies
+		AccuracyScale string `json:"accuracy_scale,omitempty"`
+		// Milestones holds when the job first reached each of its accuracy
+		// milestones, in increasing order, see AccuracyMilestone
+		Milestones []AccuracyMilestone `json:"milestones,omitempty"`
+		// ValidationFailures is the number of validations that failed, after
+		// the retries of the job, leaving their epoch without validation metrics
+		ValidationFailures int `json:"validation_failures,omitempty"`
+		// NoValidationData are the epochs whose validation functions reported
+		// no evaluated datapoints, which are left without validation metrics
+		NoValidationData []int `json:"no_validation_data,omitempty"`
+		// StoppedBy is the stop rule that ended the job, if any
+		StoppedBy string `json:"stopped_by,omitempty"`
+		// SchedulerContacts and SchedulerSkips are the number of epochs in
+		// which the job asked the scheduler for a new parallelism or kept
+		// the previous one because the decision still held or the update
+		// did not change anything the scheduler policy reads
+		SchedulerContacts int `json:"scheduler_contacts,omitempty"`
+		SchedulerSkips    int `json:"scheduler_skips,omitempty"`
+		// SchedulerThrottled is the number of updates the scheduler rejected
+		// for going over the rate limit of the job, and SchedulerCoalesced the
+		// updates that replaced a throttled one before it was sent again
+		SchedulerThrottled int `json:"scheduler_throttled,omitempty"`
+		SchedulerCoalesced int `json:"scheduler_coalesced,omitempty"`
+		// QuietEpoch is the epoch after which the job stopped scaling
+		// up because it was close to its goal, see QuietMargin
+		QuietEpoch int `json:"quiet_epoch,omitempty"`
+		// CheckpointEpoch is the last epoch whose checkpoint was
+		// saved in object storage
+		CheckpointEpoch int `json:"checkpoint_epoch,omitempty"`
+		// ContinuedEpochs are the first epochs of the runs
+		// that continued the job after it completed
+		ContinuedEpochs []int `json:"continued_epochs,omitempty"`
+		// ResumedEpochs are the first epochs trained after each pause of the
+		// job and PausedTime the seconds it spent paused, which are not
+		// included in the epoch durations
+		ResumedEpochs []int   `json:"resumed_epochs,omitempty"`
+		PausedTime    float64 `json:"paused_time,omitempty"`
+		// SanityCheck is the result of the check done before
+		// the first epoch, nil if it was disabled
+		SanityCheck *SanityCheck `json:"sanity_check,omitempty"`
+		// WarmUp is the result of the warm-up invocations of
+		// the functions, nil unless the job enabled them
+		WarmUp *WarmUp `json:"warm_up,omitempty"`
+		// ClassAccuracy is the accuracy of each class in every validation,
+		// keyed by the label, and ClassSamples the number of validation
+		// datapoints of each class in the last one. Only kept for the jobs
+		// that enable MetricsPerClass
+		ClassAccuracy map[string][]float64 `json:"class_accuracy,omitempty"`
+		ClassSamples  map[string]float64   `json:"class_samples,omitempty"`
+		// LayerGroupMerges is the number of merges of each layer group in every
+		// epoch, the default group first followed by the layer sync overrides.
+		// Only kept for the jobs that set overrides
+		LayerGroupMerges [][]float64 `json:"layer_group_merges,omitempty"`
 	}
 
 	// MetricUpdate is received by the parameter server from the train jobs
-	// to refresh the metrics exposed to prometheus
+	// to refresh the metrics exposed to prometheus.
+	//
+	// The parameter server keeps the metrics by Epoch, and Seq increases with
+	// every update sent by a job, so the updates that are retried or arrive
+	// out of order don't replace the metrics of a newer one
 	MetricUpdate struct {
+		Epoch          int     `json:"epoch"`
+		Seq            int64   `json:"seq"`
 		ValidationLoss float64 `json:"validations_loss"`
 		Accuracy       float64 `json:"accuracy"`
 		TrainLoss      float64 `json:"train_loss"`
 		Parallelism    float64 `json:"parallelism"`
 		EpochDuration  float64 `json:"epoch_duration"`
+		ETA            ETA     `json:"eta"`
+		RedisMemory    int64   `json:"redis_memory"`
+		// MeasuredConcurrency is the most train invocations
+		// of the last epoch that ran at the same time
+		MeasuredConcurrency float64 `json:"measured_concurrency,omitempty"`
+		// Durations are the histograms of the durations of all
+		// the invocations of the job so far, keyed by the task
+		Durations map[string]*DurationHistogram `json:"durations,omitempty"`
 	}
 
 	// A single datapoint plus label
@@ -101,6 +530,49 @@ type (
 		Id   string       `bson:"_id" json:"id"`
 		Task TrainRequest `json:"task"`
 		Data JobHistory   `json:"data,omitempty"`
+		// InProgress is set in the histories written while the job trains,
+		// if the job crashed the history is kept up to the last flush
+		InProgress bool `json:"in_progress,omitempty"`
+		// Paused is set in the histories written while the job is paused
+		Paused *JobPause `json:"paused,omitempty"`
+		// Started and Finished are the times the job started training and
+		// exited, and Error the reason it failed. They are not set in the
+		// histories saved by older versions
+		Started  time.Time `json:"started,omitempty"`
+		Finished time.Time `json:"finished,omitempty"`
+		Error    string    `json:"error,omitempty"`
+		// Cleanup records the deletion of the tensors of the job from redis,
+		// which runs after the job reports that it finished
+		Cleanup *TensorCleanup `json:"cleanup,omitempty"`
+		// Continuing is the time a continue of the job was accepted. It claims
+		// the history until the continued run writes it, so the job can't be
+		// continued twice while the first continue waits to be scheduled
+		Continuing time.Time `json:"continuing,omitempty"`
+	}
+
+	// TensorCleanup is the result of deleting the tensors of a job, Keys
+	// is the number deleted and Error the reason the cleanup stopped
+	TensorCleanup struct {
+		Keys     int       `json:"keys"`
+		Elapsed  float64   `json:"elapsed"`
+		Finished time.Time `json:"finished"`
+		Error    string    `json:"error,omitempty"`
+	}
+
+	// SchedulerDecision is an entry of the trace of the scheduling decisions
+	// of a job. It holds the state of the job sent to the scheduler, the reference
+	// time used by the policy and the parallelism chosen along with the reason
+	// and the number of epochs it holds
+	SchedulerDecision struct {
+		Time          time.Time `json:"time"`
+		Request       int       `json:"request"`
+		Operation     string    `json:"operation"`
+		State         JobState  `json:"state"`
+		ReferenceTime float64   `json:"reference_time"`
+		Parallelism   int       `json:"parallelism"`
+		Reason        string    `json:"reason"`
+		ValidFor      int       `json:"valid_for,omitempty"`
+		CappedFrom    int       `json:"capped_from,omitempty"`
 	}
 
 	// DatasetSummary describes the contents a kubeml dataset
@@ -109,4 +581,216 @@ type (
 		TrainSetSize int64  `json:"train_set_size"`
 		TestSetSize  int64  `json:"test_set_size"`
 	}
+
+	// DatasetStats extends the summary of a dataset with the
+	// information about the shards it is split into in the storage
+	DatasetStats struct {
+		DatasetSummary
+		ShardSize   int64 `json:"shard_size"`
+		TrainShards int64 `json:"train_shards"`
+		TestShards  int64 `json:"test_shards"`
+		// ClassCounts is the number of train datapoints of each class, empty
+		// if the labels are not class indices. ClassWeights are the weights
+		// set for the dataset, empty if they are computed from the counts
+		ClassCounts  []int64   `json:"class_counts,omitempty"`
+		ClassWeights []float64 `json:"class_weights,omitempty"`
+		// FeatureMin and FeatureMax are the range of the values of the train
+		// features and FeatureDtype their numpy type, unset if the features
+		// are not numeric. See InputStats
+		FeatureMin   *float64 `json:"feature_min,omitempty"`
+		FeatureMax   *float64 `json:"feature_max,omitempty"`
+		FeatureDtype string   `json:"feature_dtype,omitempty"`
+	}
+
+	// ExportManifest lists the files of an exported job bundle
+	// along with their sha256 hashes so the bundle can be verified
+	ExportManifest struct {
+		JobId   string       `json:"job_id"`
+		Created time.Time    `json:"created"`
+		Files   []ExportFile `json:"files"`
+	}
+
+	// ExportFile is an entry of the manifest of a bundle
+	ExportFile struct {
+		Name   string `json:"name"`
+		Size   int64  `json:"size"`
+		SHA256 string `json:"sha256"`
+	}
+
+	// ExportWeights describes the weights of the model in a bundle. If they are
+	// not included the tensors point to the keys in the tensor storage along with
+	// their checksums, so they can still be matched with the bundle
+	ExportWeights struct {
+		Included bool           `json:"included"`
+		Storage  string         `json:"storage"`
+		Size     int64          `json:"size"`
+		Tensors  []ExportTensor `json:"tensors"`
+	}
+
+	// ExportTensor is a tensor of the model saved in the tensor storage
+	ExportTensor struct {
+		Layer  string  `json:"layer"`
+		Key    string  `json:"key"`
+		Dtype  string  `json:"dtype"`
+		Shape  []int64 `json:"shape"`
+		SHA256 string  `json:"sha256"`
+		File   string  `json:"file,omitempty"`
+	}
+
+	// ExportFunction is the version of the function used to train a job
+	ExportFunction struct {
+		Name                   string `json:"name"`
+		ResourceVersion        string `json:"resource_version,omitempty"`
+		Environment            string `json:"environment,omitempty"`
+		Package                string `json:"package,omitempty"`
+		PackageResourceVersion string `json:"package_resource_version,omitempty"`
+	}
+
+	// ModelSummary describes the architecture of the
+	// reference model of a job as saved in the tensor storage
+	ModelSummary struct {
+		JobId      string         `json:"job_id"`
+		Layers     []LayerSummary `json:"layers"`
+		Parameters int64          `json:"parameters"`
+		Bytes      int64          `json:"bytes"`
+	}
+
+	// LayerSummary holds the type, shape and number of parameters of one
+	// of the layers of a model, and the bytes its values take in a tensor
+	LayerSummary struct {
+		Name       string  `json:"name"`
+		Dtype      string  `json:"dtype"`
+		Shape      []int64 `json:"shape"`
+		Parameters int64   `json:"parameters"`
+		Bytes      int64   `json:"bytes"`
+	}
+
+	// DatasetShard is one of the documents a dataset split is divided into.
+	// Data and labels are the pickled arrays saved by the storage service
+	DatasetShard struct {
+		Id     int    `json:"id"`
+		Data   []byte `json:"data"`
+		Labels []byte `json:"labels"`
+	}
 )
+
+// UpdateTimeout returns how long the job waits for the
+// scheduler to send the parallelism of the next epoch
+func (o TrainOptions) UpdateTimeout() time.Duration {
+	if o.SchedulerTimeout > 0 {
+		return time.Duration(o.SchedulerTimeout) * time.Second
+	}
+	return DefaultSchedulerTimeout * time.Second
+}
+
+// SchedulerThroughputTrigger returns the relative change of the epoch time
+// that makes the job ask the scheduler before its decision expires
+func (o TrainOptions) SchedulerThroughputTrigger() float64 {
+	if o.ThroughputTrigger > 0 {
+		return o.ThroughputTrigger
+	}
+	return DefaultThroughputTrigger
+}
+
+// NearGoal returns true if the accuracy is within the quiet margin of the goal,
+// in the direction of the metric. It is always false if the margin is not set
+func (o TrainOptions) NearGoal(accuracy float64) bool {
+	if o.QuietMargin <= 0 {
+		return false
+	}
+	if o.Direction(MetricAccuracy) == DirectionMaximize {
+		return accuracy >= o.GoalAccuracy-o.QuietMargin
+	}
+	return accuracy <= o.GoalAccuracy+o.QuietMargin
+}
+
+// ValidateQuietMargin checks that the quiet margin is not negative
+func (o TrainOptions) ValidateQuietMargin() error {
+	if o.QuietMargin < 0 {
+		return fmt.Errorf("quiet margin should not be negative, got %v", o.QuietMargin)
+	}
+	return nil
+}
+
+// ValidateNormalization checks that the normalization stats of the request
+// are either both empty or have one positive deviation for each mean. The
+// number of channels of the data is checked by the functions
+func (r TrainRequest) ValidateNormalization() error {
+	if len(r.NormalizationMean) != len(r.NormalizationStd) {
+		return fmt.Errorf("normalization mean and std have different lengths (%d and %d)",
+			len(r.NormalizationMean), len(r.NormalizationStd))
+	}
+
+	for i, std := range r.NormalizationStd {
+		if std <= 0 {
+			return fmt.Errorf("normalization std of channel %d should be positive", i)
+		}
+	}
+
+	return nil
+}
+
+// IterationsPerEpoch returns how many times the model is merged in an epoch. Each function
+// trains on an equal part of the shards of the dataset and syncs after every K batches,
+// loading whole shards, so this mirrors the split done by the functions. A K of -1 syncs
+// once per epoch, and a parallelism of 0 uses the default parallelism
+func IterationsPerEpoch(shards int64, batchSize, parallelism, K int) int {
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+	if shards <= 0 {
+		return 0
+	}
+
+	perFunction := ShardsPerFunction(shards, parallelism)
+	if K <= 0 || batchSize <= 0 {
+		return 1
+	}
+
+	perIteration := (int64(batchSize)*int64(K) + DatasetShardSize - 1) / DatasetShardSize
+	return int((perFunction + perIteration - 1) / perIteration)
+}
+
+// ShardsPerFunction returns the maximum number of shards each function trains on in
+// an epoch, the shards are split evenly and the first functions take the remainder
+func ShardsPerFunction(shards int64, parallelism int) int64 {
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+	return (shards + int64(parallelism) - 1) / int64(parallelism)
+}
+
+// IterationsWarning returns a warning if the iterations per epoch show that K is
+// probably misconfigured, or an empty string otherwise. No warning is given
+// when K is -1 since syncing once per epoch is then intended
+func IterationsWarning(K, iterations, max int) string {
+	switch {
+	case K != -1 && iterations == 1:
+		return fmt.Sprintf("K=%d merges the model only once per epoch, which is the same as sparse averaging", K)
+	case max > 0 && iterations > max:
+		return fmt.Sprintf("K=%d merges the model %d times per epoch, above the limit of %d, consider a larger K",
+			K, iterations, max)
+	default:
+		return ""
+	}
+}
+
+// AddLayer appends a layer to the summary and adds its parameters to the total
+func (s *ModelSummary) AddLayer(layer LayerSummary) {
+	s.Layers = append(s.Layers, layer)
+	s.Parameters += layer.Parameters
+	s.Bytes += layer.Bytes
+}
+
+// RedisFootprint estimates the bytes the tensors of a job with the model take
+// in redis, the reference model plus the copy saved by each of the functions.
+// It does not include the overhead of redis for each key
+func (s *ModelSummary) RedisFootprint(parallelism int) int64 {
+	return s.Bytes * int64(1+parallelism)
+}
+
+// Redacted returns a copy of the task without the secret used to sign its notifications
+func (t TrainTask) Redacted() TrainTask {
+	t.Parameters.NotifySecret = ""
+	return t
+}
@@ -0,0 +1,91 @@
+package api
+
+import "fmt"
+
+// Models the periodic validations of a job run against
+const (
+	// ValidationModelFinal validates the model once all
+	// the merges of the epoch are done
+	ValidationModelFinal = "final"
+
+	// ValidationModelLatest starts validating as soon as the train functions of the
+	// epoch return, against the model of the last intermediate merge, while the final
+	// merge of the epoch waits for the validation functions to finish. Epochs without
+	// intermediate merges and the validation after the last epoch use the final model
+	ValidationModelLatest = "latest"
+)
+
+// ValidateValidationModel checks that the validation model is known, empty being final
+func (o TrainOptions) ValidateValidationModel() error {
+	switch o.ValidationModel {
+	case "", ValidationModelFinal, ValidationModelLatest:
+		return nil
+	default:
+		return fmt.Errorf("unknown validation model \"%s\", expected %s or %s",
+			o.ValidationModel, ValidationModelFinal, ValidationModelLatest)
+	}
+}
+
+// ValidatesLatest returns true if the periodic validations
+// can start against the last intermediate merge of the epoch
+func (o TrainOptions) ValidatesLatest() bool {
+	return o.ValidationModel == ValidationModelLatest
+}
+
+// Policies applied when a validation of a job fails, for instance because
+// fewer validation functions than the quorum reported
+const (
+	// ValidationFailureContinue logs the failure and keeps training, the
+	// epoch is left without validation metrics. It is the default
+	ValidationFailureContinue = "continue"
+
+	// ValidationFailureFail stops the job with the error of the validation
+	ValidationFailureFail = "fail"
+
+	// ValidationFailureRetry runs the validation again up to ValidationRetries
+	// times, failing the job if none of the attempts succeeds
+	ValidationFailureRetry = "retry"
+
+	DefaultValidationRetries = 2
+	MaxValidationRetries     = 10
+)
+
+// ValidateValidationFailurePolicy checks that the policy is known, empty being
+// continue, and that the retries are only set for the retry policy
+func (o TrainOptions) ValidateValidationFailurePolicy() error {
+	switch o.ValidationFailurePolicy {
+	case "", ValidationFailureContinue, ValidationFailureFail, ValidationFailureRetry:
+	default:
+		return fmt.Errorf("unknown validation failure policy \"%s\", expected %s, %s or %s",
+			o.ValidationFailurePolicy, ValidationFailureContinue, ValidationFailureFail, ValidationFailureRetry)
+	}
+
+	if o.ValidationRetries < 0 || o.ValidationRetries > MaxValidationRetries {
+		return fmt.Errorf("validation retries should be between 0 and %d, got %d",
+			MaxValidationRetries, o.ValidationRetries)
+	}
+	if o.ValidationRetries > 0 && o.ValidationFailurePolicy != ValidationFailureRetry {
+		return fmt.Errorf("validation retries are only used with the %s validation failure policy", ValidationFailureRetry)
+	}
+	return nil
+}
+
+// ValidationFailurePolicyOrDefault returns the policy applied to the failed validations
+func (o TrainOptions) ValidationFailurePolicyOrDefault() string {
+	if len(o.ValidationFailurePolicy) == 0 {
+		return ValidationFailureContinue
+	}
+	return o.ValidationFailurePolicy
+}
+
+// ValidationRetryLimit returns the times a failed validation is run again, only
+// non zero for the retry policy, DefaultValidationRetries unless set
+func (o TrainOptions) ValidationRetryLimit() int {
+	if o.ValidationFailurePolicy != ValidationFailureRetry {
+		return 0
+	}
+	if o.ValidationRetries == 0 {
+		return DefaultValidationRetries
+	}
+	return o.ValidationRetries
+}
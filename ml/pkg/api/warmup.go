@@ -0,0 +1,13 @@
+package api
+
+// WarmUp is the result of the invocations of the functions made before the first
+// epoch to start their pods, so the cold starts are not measured in it. Ready is
+// the number of functions that answered, and Error the last failure if any did not.
+// Skipped is set if the function does not know the task, which still starts the pods
+type WarmUp struct {
+	Functions int     `json:"functions"`
+	Ready     int     `json:"ready"`
+	Skipped   bool    `json:"skipped,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	Elapsed   float64 `json:"elapsed"`
+}
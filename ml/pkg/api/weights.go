@@ -0,0 +1,83 @@
+package api
+
+import (
+	"fmt"
+	"math"
+)
+
+// Sources of the class weights of a dataset
+const (
+	// ClassWeightsAuto are computed from the class distribution of the
+	// train set, see BalancedClassWeights
+	ClassWeightsAuto = "auto"
+	// ClassWeightsExplicit were set for the dataset with set-weights
+	ClassWeightsExplicit = "explicit"
+)
+
+// BalancedClassWeights returns the weight of each class so that all the classes
+// weigh the same in the loss, the number of datapoints divided by the number of
+// classes times the datapoints of the class. Classes without datapoints get a
+// weight of 0, since they never appear in the loss
+func BalancedClassWeights(counts []int64) []float64 {
+	var total, classes int64
+	for _, c := range counts {
+		if c > 0 {
+			total += c
+			classes++
+		}
+	}
+
+	weights := make([]float64, len(counts))
+	for i, c := range counts {
+		if c > 0 {
+			weights[i] = float64(total) / float64(classes*c)
+		}
+	}
+	return weights
+}
+
+// ResolveClassWeights returns the weights of the classes of the dataset, the ones set for
+// it if any or else the ones computed from its class distribution, along with their
+// source. Returns an error if the labels of the dataset are not class indices
+func (s *DatasetStats) ResolveClassWeights() ([]float64, string, error) {
+	if len(s.ClassWeights) > 0 {
+		return s.ClassWeights, ClassWeightsExplicit, nil
+	}
+	if len(s.ClassCounts) == 0 {
+		return nil, "", fmt.Errorf("dataset %s has no class distribution, its labels are not class indices", s.Name)
+	}
+	return BalancedClassWeights(s.ClassCounts), ClassWeightsAuto, nil
+}
+
+// CheckClassWeights checks that there is one finite non-negative weight for each
+// of the classes, not all of them 0. The classes are not checked if unknown (0)
+func CheckClassWeights(weights []float64, classes int) error {
+	if classes > 0 && len(weights) != classes {
+		return fmt.Errorf("got %d class weights but the dataset has %d classes", len(weights), classes)
+	}
+
+	var sum float64
+	for i, w := range weights {
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return fmt.Errorf("class weights should be finite and not negative, got %v for class %d", w, i)
+		}
+		sum += w
+	}
+	if sum == 0 {
+		return fmt.Errorf("at least one class weight should be positive")
+	}
+	return nil
+}
+
+// ValidateClassWeights checks the class weights of the request, which can only be
+// set along with UseClassWeights. Their number is checked against the classes of
+// the dataset when they are resolved
+func (o TrainOptions) ValidateClassWeights() error {
+	if len(o.ClassWeights) == 0 {
+		return nil
+	}
+	if !o.UseClassWeights {
+		return fmt.Errorf("class weights are only used if the job uses class weights")
+	}
+	return CheckClassWeights(o.ClassWeights, 0)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestBalancedClassWeights(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts []int64
+		want   []float64
+	}{
+		{"balanced", []int64{50, 50}, []float64{1, 1}},
+		{"skewed", []int64{90, 9, 1}, []float64{100.0 / 270, 100.0 / 27, 100.0 / 3}},
+		{"empty class", []int64{75, 25, 0}, []float64{100.0 / 150, 100.0 / 50, 0}},
+		{"no datapoints", []int64{0, 0}, []float64{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BalancedClassWeights(tt.counts)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got weights %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+					t.Errorf("got weights %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestBalancedClassWeightsEqualize(t *testing.T) {
+	// every class with datapoints weighs the same in the loss, the datapoints over the classes
+	counts := []int64{9000, 900, 90, 9, 1, 0}
+	weights := BalancedClassWeights(counts)
+	for i, c := range counts[:5] {
+		if weight := float64(c) * weights[i]; math.Abs(weight-10000.0/5) > 1e-6 {
+			t.Errorf("got class %d weighing %v in the loss, want %v", i, weight, 10000.0/5)
+		}
+	}
+}
+
+func TestResolveClassWeights(t *testing.T) {
+	tests := []struct {
+		name       string
+		stats      DatasetStats
+		want       []float64
+		wantSource string
+		wantError  bool
+	}{
+		{"computed", DatasetStats{ClassCounts: []int64{80, 20}}, []float64{100.0 / 160, 100.0 / 40}, ClassWeightsAuto, false},
+		{"explicit", DatasetStats{ClassCounts: []int64{80, 20}, ClassWeights: []float64{1, 2}}, []float64{1, 2}, ClassWeightsExplicit, false},
+		{"explicit without distribution", DatasetStats{ClassWeights: []float64{1, 2}}, []float64{1, 2}, ClassWeightsExplicit, false},
+		{"no distribution", DatasetStats{}, nil, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weights, source, err := tt.stats.ResolveClassWeights()
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if !reflect.DeepEqual(weights, tt.want) || source != tt.wantSource {
+				t.Errorf("got weights %v from %q, want %v from %q", weights, source, tt.want, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestCheckClassWeights(t *testing.T) {
+	tests := []struct {
+		name      string
+		weights   []float64
+		classes   int
+		wantError bool
+	}{
+		{"one per class", []float64{1, 2, 0}, 3, false},
+		{"classes unknown", []float64{1, 2}, 0, false},
+		{"fewer than the classes", []float64{1, 2}, 3, true},
+		{"more than the classes", []float64{1, 2, 3, 4}, 3, true},
+		{"none", nil, 3, true},
+		{"negative", []float64{1, -1}, 2, true},
+		{"not a number", []float64{1, math.NaN()}, 2, true},
+		{"infinite", []float64{math.Inf(1), 1}, 2, true},
+		{"all zeros", []float64{0, 0}, 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckClassWeights(tt.weights, tt.classes); (err != nil) != tt.wantError {
+				t.Errorf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestValidateClassWeights(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      TrainOptions
+		wantError bool
+	}{
+		{"no weights", TrainOptions{}, false},
+		{"resolved from the dataset", TrainOptions{UseClassWeights: true}, false},
+		{"set in the request", TrainOptions{UseClassWeights: true, ClassWeights: []float64{1, 2}}, false},
+		{"set but not used", TrainOptions{ClassWeights: []float64{1, 2}}, true},
+		{"invalid", TrainOptions{UseClassWeights: true, ClassWeights: []float64{0, 0}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.ValidateClassWeights(); (err != nil) != tt.wantError {
+				t.Errorf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import "fmt"
+
+const (
+	// DefaultPolicyWindow is the number of recent epochs the scheduler policy
+	// looks at by default, only the latest one as before the window existed
+	DefaultPolicyWindow = 1
+	// MaxPolicyWindow is the largest window a job can set
+	MaxPolicyWindow = 20
+)
+
+// EpochPoint holds the metrics of a finished epoch the scheduler policy reads,
+// ElapsedTime being the time the functions took to train the epoch and
+// MeasuredConcurrency the most of them that ran at the same time
+type EpochPoint struct {
+	Epoch               int     `json:"epoch"`
+	Parallelism         int     `json:"parallelism"`
+	ElapsedTime         float64 `json:"elapsed_time"`
+	TrainLoss           float64 `json:"train_loss"`
+	MeasuredConcurrency int     `json:"measured_concurrency,omitempty"`
+}
+
+// ValidatePolicyWindow checks that the window of the scheduler policy is in range, 0 being the default
+func (o TrainOptions) ValidatePolicyWindow() error {
+	if o.PolicyWindow < 0 || o.PolicyWindow > MaxPolicyWindow {
+		return fmt.Errorf("policy window should be between 1 and %d epochs, got %d", MaxPolicyWindow, o.PolicyWindow)
+	}
+	return nil
+}
+
+// SchedulerPolicyWindow returns the number of recent epochs the scheduler policy considers
+func (o TrainOptions) SchedulerPolicyWindow() int {
+	if o.PolicyWindow > 0 {
+		return o.PolicyWindow
+	}
+	return DefaultPolicyWindow
+}
+
+// AppendWindow adds the point to the recent epochs of the state,
+// keeping only the last size of them
+func (s *JobState) AppendWindow(point EpochPoint, size int) {
+	recent := append(s.Recent, point)
+	if len(recent) > size {
+		recent = recent[len(recent)-size:]
+	}
+	s.Recent = append([]EpochPoint(nil), recent...)
+}
+
+// WindowTime returns the epoch time the scheduler policy compares, the mean of
+// the recent epochs trained with the current parallelism, since the ones with a
+// different parallelism do not tell how the current one performs. It is the time
+// of the last epoch if the state holds no recent epochs
+func (s JobState) WindowTime() float64 {
+	var total float64
+	var n int
+	for i := len(s.Recent) - 1; i >= 0 && s.Recent[i].Parallelism == s.Parallelism; i-- {
+		total += s.Recent[i].ElapsedTime
+		n++
+	}
+	if n == 0 {
+		return s.ElapsedTime
+	}
+	return total / float64(n)
+}
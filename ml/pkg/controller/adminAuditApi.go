@@ -0,0 +1,245 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminAuditCollection keeps the audit log of the administrative actions,
+// the data audits of the jobs are kept in the audit collection
+const adminAuditCollection = "admin_audit"
+
+type (
+	auditContextKey struct{}
+
+	// auditRecord is filled by the admin handlers with
+	// the values changed by the action
+	auditRecord struct {
+		before interface{}
+		after  interface{}
+	}
+
+	// auditRecorder buffers the response of an admin handler so
+	// it is only sent once the action was recorded in the log
+	auditRecorder struct {
+		header http.Header
+		status int
+		body   bytes.Buffer
+	}
+)
+
+func (rec *auditRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *auditRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(data)
+}
+
+func (rec *auditRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+// auditChange records the values before and after an admin action, it
+// does nothing for the requests that do not go through the audit middleware
+func auditChange(r *http.Request, before, after interface{}) {
+	if record, ok := r.Context().Value(auditContextKey{}).(*auditRecord); ok {
+		record.before = before
+		record.after = after
+	}
+}
+
+// admin wraps the handler of an administrative action, which is recorded in the audit
+// log with its result. The response is held until the entry is saved, and if it cannot
+// be saved the request fails, so no admin action is answered without being recorded
+func (c *Controller) admin(action string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get(api.RequestIdHeader)
+		if len(requestId) == 0 {
+			requestId = uuid.New().String()
+		}
+		actor := r.Header.Get(api.AdminActorHeader)
+		if len(actor) == 0 {
+			actor = api.UnknownActor
+		}
+
+		record := &auditRecord{}
+		rec := &auditRecorder{header: make(http.Header)}
+		handler(rec, r.WithContext(context.WithValue(r.Context(), auditContextKey{}, record)))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		entry := &api.AdminAuditEntry{
+			Time:      time.Now().UTC().Truncate(time.Millisecond),
+			Actor:     actor,
+			Action:    action,
+			Target:    auditTarget(mux.Vars(r)),
+			RequestId: requestId,
+			Status:    rec.status,
+			Before:    encodeAuditValue(record.before),
+			After:     encodeAuditValue(record.after),
+		}
+		if err := c.appendAudit(entry); err != nil {
+			c.logger.Error("Could not record admin action",
+				zap.String("action", action),
+				zap.String("requestId", requestId),
+				zap.Int("status", rec.status),
+				zap.Error(err))
+			w.Header().Set(api.RequestIdHeader, requestId)
+			http.Error(w, fmt.Sprintf("could not record the action in the audit log, it may have been applied (status %d)",
+				rec.status), http.StatusInternalServerError)
+			return
+		}
+
+		for key, values := range rec.header {
+			w.Header()[key] = values
+		}
+		w.Header().Set(api.RequestIdHeader, requestId)
+		w.WriteHeader(rec.status)
+		w.Write(rec.body.Bytes())
+	}
+}
+
+// auditTarget returns the variables of the route, which identify the object of the action
+func auditTarget(vars map[string]string) string {
+	target := make([]string, 0, len(vars))
+	for key, value := range vars {
+		target = append(target, key+"="+value)
+	}
+	sort.Strings(target)
+	return strings.Join(target, ",")
+}
+
+// encodeAuditValue encodes a value recorded by a handler as JSON
+func encodeAuditValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
+
+// appendAudit adds the entry at the end of the log, chained to the last entry. The
+// sequence number is the id of the entry, so if two writers race the second insert
+// fails instead of forking the chain
+func (c *Controller) appendAudit(entry *api.AdminAuditEntry) error {
+	c.auditMu.Lock()
+	defer c.auditMu.Unlock()
+
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(adminAuditCollection)
+
+	var last api.AdminAuditEntry
+	err := collection.FindOne(context.TODO(), bson.M{},
+		options.FindOne().SetSort(bson.M{"_id": -1})).Decode(&last)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return errors.Wrap(err, "could not read the last audit entry")
+	}
+
+	entry.Seq = last.Seq + 1
+	entry.PrevHash = last.Hash
+	entry.Hash = entry.ComputeHash()
+
+	if _, err = collection.InsertOne(context.TODO(), entry); err != nil {
+		return errors.Wrap(err, "could not save audit entry")
+	}
+	return nil
+}
+
+// getAdminAudit returns a page of the audit log of the administrative actions in the
+// order they were done. The log can be filtered with the since (RFC3339 time), actor
+// and action parameters, and paginated with after (the last sequence number seen)
+// and limit
+func (c *Controller) getAdminAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := bson.M{}
+
+	if s := query.Get("since"); len(s) > 0 {
+		since, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since \"%s\", expected an RFC3339 time", s), http.StatusBadRequest)
+			return
+		}
+		filter["time"] = bson.M{"$gte": since}
+	}
+	if actor := query.Get("actor"); len(actor) > 0 {
+		filter["actor"] = actor
+	}
+	if action := query.Get("action"); len(action) > 0 {
+		filter["action"] = action
+	}
+	if s := query.Get("after"); len(s) > 0 {
+		after, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || after < 0 {
+			http.Error(w, "after should be a sequence number", http.StatusBadRequest)
+			return
+		}
+		filter["_id"] = bson.M{"$gt": after}
+	}
+
+	limit := api.DefaultAdminAuditLimit
+	if s := query.Get("limit"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > api.MaxAdminAuditLimit {
+			http.Error(w, fmt.Sprintf("limit should be between 1 and %d", api.MaxAdminAuditLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	// ask for one more entry to know if there is another page
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(adminAuditCollection)
+	cursor, err := collection.Find(context.TODO(), filter,
+		options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit+1)))
+	if err != nil {
+		c.logger.Error("Could not query audit log", zap.Error(err))
+		http.Error(w, "could not query audit log", http.StatusInternalServerError)
+		return
+	}
+
+	page := api.AdminAuditPage{Entries: []api.AdminAuditEntry{}}
+	if err = cursor.All(context.TODO(), &page.Entries); err != nil {
+		c.logger.Error("Could not decode audit log", zap.Error(err))
+		http.Error(w, "could not decode audit log", http.StatusInternalServerError)
+		return
+	}
+	if len(page.Entries) > limit {
+		page.Entries = page.Entries[:limit]
+		page.Next = page.Entries[limit-1].Seq
+	}
+
+	resp, err := json.Marshal(page)
+	if err != nil {
+		c.logger.Error("Could not marshal audit log", zap.Error(err))
+		http.Error(w, "error marshaling audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// auditEntries returns the entries inserted in the audit log and the
+// number of commands sent to mongo by name
+func auditEntries(mt *mtest.T) ([]api.AdminAuditEntry, map[string]int) {
+	var entries []api.AdminAuditEntry
+	commands := make(map[string]int)
+	for _, evt := range mt.GetAllStartedEvents() {
+		commands[evt.CommandName]++
+		if evt.CommandName != "insert" || evt.Command.Lookup("insert").StringValue() != adminAuditCollection {
+			continue
+		}
+		docs, err := evt.Command.Lookup("documents").Array().Values()
+		if err != nil {
+			mt.Fatal(err)
+		}
+		for _, doc := range docs {
+			var entry api.AdminAuditEntry
+			if err := bson.Unmarshal(doc.Document(), &entry); err != nil {
+				mt.Fatal(err)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, commands
+}
+
+func TestAdminAudit(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	deleted := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+	noEntries := mtest.CreateCursorResponse(0, "kubeml.admin_audit", mtest.FirstBatch)
+	inserted := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+
+	deleteHistory := func(mt *mtest.T) *httptest.ResponseRecorder {
+		c := &Controller{logger: zap.NewNop(), mongoClient: mt.Client}
+		r := mux.NewRouter()
+		r.HandleFunc("/history/{taskId}", c.admin("history.delete", c.deleteHistory)).Methods("DELETE")
+
+		req := httptest.NewRequest(http.MethodDelete, "/history/job", nil)
+		req.Header.Set(api.AdminActorHeader, "alice")
+		req.Header.Set(api.RequestIdHeader, "request")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	mt.Run("one entry per action", func(mt *mtest.T) {
+		mt.AddMockResponses(deleted, noEntries, inserted)
+		w := deleteHistory(mt)
+		if w.Code != http.StatusOK || w.Header().Get(api.RequestIdHeader) != "request" {
+			mt.Errorf("got status %d and request id %q, want 200 and request", w.Code, w.Header().Get(api.RequestIdHeader))
+		}
+
+		entries, commands := auditEntries(mt)
+		if len(entries) != 1 || commands["delete"] != 1 || commands["insert"] != 1 {
+			mt.Fatalf("got entries %+v and commands %v, want the deletion and its entry", entries, commands)
+		}
+		e := entries[0]
+		if e.Seq != 1 || e.Actor != "alice" || e.Action != "history.delete" || e.Target != "taskId=job" ||
+			e.RequestId != "request" || e.Status != http.StatusOK || len(e.PrevHash) != 0 {
+			mt.Errorf("got entry %+v, want the first entry of the deletion by alice", e)
+		}
+		if err := api.VerifyAuditChain(entries); err != nil {
+			mt.Error(err)
+		}
+	})
+
+	mt.Run("failed action", func(mt *mtest.T) {
+		failed := mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "unavailable"})
+		mt.AddMockResponses(failed, noEntries, inserted)
+		if w := deleteHistory(mt); w.Code != http.StatusNotFound {
+			mt.Errorf("got status %d, want 404", w.Code)
+		}
+
+		entries, _ := auditEntries(mt)
+		if len(entries) != 1 || entries[0].Status != http.StatusNotFound {
+			mt.Errorf("got entries %+v, want one with status 404", entries)
+		}
+	})
+
+	mt.Run("chained to the last entry", func(mt *mtest.T) {
+		last := api.AdminAuditEntry{Seq: 7, Actor: "bob", Action: "limits.set"}
+		last.Hash = last.ComputeHash()
+		lastDoc := bson.D{{Key: "_id", Value: last.Seq}, {Key: "hash", Value: last.Hash}}
+		mt.AddMockResponses(deleted, mtest.CreateCursorResponse(0, "kubeml.admin_audit", mtest.FirstBatch, lastDoc), inserted)
+		deleteHistory(mt)
+
+		entries, _ := auditEntries(mt)
+		if len(entries) != 1 || entries[0].Seq != 8 || entries[0].PrevHash != last.Hash || entries[0].Hash != entries[0].ComputeHash() {
+			mt.Errorf("got entries %+v, want entry 8 chained to entry 7", entries)
+		}
+	})
+
+	mt.Run("entry not saved", func(mt *mtest.T) {
+		duplicate := mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"})
+		mt.AddMockResponses(deleted, noEntries, duplicate)
+		w := deleteHistory(mt)
+		if w.Code != http.StatusInternalServerError {
+			mt.Errorf("got status %d, want 500 if the action is not recorded", w.Code)
+		}
+		if _, commands := auditEntries(mt); commands["insert"] != 1 {
+			mt.Errorf("got commands %v, want a single insert", commands)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package controller
 import (
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"net/http"
 )
@@ -19,25 +20,67 @@ func (c *Controller) getHandler() http.Handler {
 	// training and inference
 	r.HandleFunc("/train", c.train).Methods("POST")
 	r.HandleFunc("/infer", c.infer).Methods("POST")
+	r.HandleFunc("/models/diff", c.modelDiff).Methods("GET")
+	r.HandleFunc("/models/{jobId}/summary", c.modelSummary).Methods("GET")
+	r.HandleFunc("/models/{jobId}/export", c.exportModel).Methods("POST")
+	r.HandleFunc("/artifacts/{jobId}/{format}", c.storageServiceProxy).Methods("GET")
 
 	// dataset proxy and methods
 	r.HandleFunc("/dataset/{name}", c.getDataset).Methods("GET")
 	r.HandleFunc("/dataset/{name}", c.storageServiceProxy).Methods("POST", "DELETE")
+	r.HandleFunc("/dataset/{name}/stats", c.storageServiceProxy).Methods("GET")
+	r.HandleFunc("/dataset/{name}/weights", c.storageServiceProxy).Methods("PUT", "DELETE")
+	r.HandleFunc("/dataset/{name}/{split}/shards", c.storageServiceProxy).Methods("GET")
+	r.HandleFunc("/dataset/{name}/{split}/shards/range", c.storageServiceProxy).Methods("GET")
 	r.HandleFunc("/dataset", c.listDatasets).Methods("GET")
 
+	// functions
+	r.HandleFunc("/functions/{name}/cache", c.invalidateFunction).Methods("DELETE")
+	r.HandleFunc("/functions/{name}/conformance", c.conformanceFunction).Methods("POST")
+
 	// get current tasks
 	r.HandleFunc("/tasks", c.listTasks).Methods("GET")
-	r.HandleFunc("/tasks/{jobId}", c.stopTask).Methods("DELETE")
+	r.HandleFunc("/tasks/{jobId}", c.getTask).Methods("GET")
+	r.HandleFunc("/tasks/{jobId}/trace", c.getTrace).Methods("GET")
+	r.HandleFunc("/tasks/{jobId}/invocations", c.getInvocations).Methods("GET")
+	r.HandleFunc("/tasks/{jobId}/continue", c.continueTask).Methods("POST")
 
 	// history
 	r.HandleFunc("/history/{taskId}", c.getHistory).Methods("GET")
-	r.HandleFunc("/history/{taskId}", c.deleteHistory).Methods("DELETE")
+	r.HandleFunc("/history/{taskId}/export", c.exportBundle).Methods("GET")
+	r.HandleFunc("/history/{taskId}/audit", c.getAudit).Methods("GET")
+	r.HandleFunc("/history/{taskId}/metrics/{metric}", c.getMetric).Methods("GET")
 	r.HandleFunc("/history", c.listHistories).Methods("GET")
-	r.HandleFunc("/history", c.pruneHistories).Methods("DELETE")
+	r.HandleFunc("/recommendations/{function}/{dataset}", c.getRecommendation).Methods("GET")
+	r.HandleFunc("/suggestions/{function}/{dataset}", c.getSuggestions).Methods("GET")
+
+	// sweeps
+	r.HandleFunc("/sweeps/{sweepId}/best", c.getSweepBest).Methods("GET")
+
+	// admin
+	r.HandleFunc("/admin/limits", c.getLimits).Methods("GET")
+	r.HandleFunc("/audit", c.getAdminAudit).Methods("GET")
+	r.HandleFunc("/deployments", c.listDeployments).Methods("GET")
+
+	// administrative actions, all of them are recorded in the audit log
+	r.HandleFunc("/admin/limits", c.admin("limits.set", c.setLimits)).Methods("PUT")
+	r.HandleFunc("/tasks/{jobId}", c.admin("task.stop", c.stopTask)).Methods("DELETE")
+	r.HandleFunc("/tasks/{jobId}/pause", c.admin("task.pause", c.pauseTask)).Methods("POST")
+	r.HandleFunc("/tasks/{jobId}/resume", c.admin("task.resume", c.resumeTask)).Methods("POST")
+	r.HandleFunc("/tasks/{jobId}/loglevel", c.admin("task.loglevel", c.setLogLevel)).Methods("PUT")
+	r.HandleFunc("/history/{taskId}", c.admin("history.delete", c.deleteHistory)).Methods("DELETE")
+	r.HandleFunc("/history", c.admin("history.prune", c.pruneHistories)).Methods("DELETE")
+	r.HandleFunc("/jobs:batch", c.admin("jobs.batch", c.batchJobs)).Methods("POST")
+
+	// stats
+	r.HandleFunc("/stats/overview", c.getStatsOverview).Methods("GET")
 
 	// k8s health handler
 	r.HandleFunc("/health", c.handleHealth).Methods("GET")
 
+	// prometheus metrics of the inference cache
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+
 	return r
 }
 
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// progressWriter streams the steps of an export as JSON lines
+type progressWriter struct {
+	w   http.ResponseWriter
+	enc *json.Encoder
+}
+
+func (p *progressWriter) write(progress *api.ExportProgress) error {
+	if err := p.enc.Encode(progress); err != nil {
+		return err
+	}
+	if f, ok := p.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// exportModel invokes the function of a job with the export task, which loads the model
+// from redis, exports it to the format requested and uploads the artifact to the storage
+// service. TorchScript artifacts are loaded back by the function before the upload. The progress is streamed as JSON lines, the last one being either done with
+// the artifact, which is then downloaded from the storage service, or failed with the error.
+//
+// The errors found before invoking the function are returned with the status code,
+// including the functions that reported in their init response that they can't export
+func (c *Controller) exportModel(w http.ResponseWriter, r *http.Request) {
+	jobId := mux.Vars(r)["jobId"]
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = api.ExportFormatONNX
+	}
+	if err := api.ValidateExportFormat(format); err != nil {
+		c.logger.Error("Invalid export format", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.logger.Debug("Exporting model",
+		zap.String("jobId", jobId),
+		zap.String("format", format))
+
+	var history api.History
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	err := collection.FindOne(context.TODO(), bson.M{"_id": jobId}).Decode(&history)
+	if err != nil {
+		c.logger.Error("Could not find history", zap.Error(err))
+		http.Error(w, "Could not find history for request", http.StatusNotFound)
+		return
+	}
+
+	if capability := api.ExportCapability(format); !history.Data.HasCapability(capability) {
+		msg := fmt.Sprintf("function %s does not implement the %s export, it reported %v",
+			history.Task.FunctionName, format, history.Data.Capabilities)
+		c.logger.Warn("Unsupported export", zap.String("jobId", jobId), zap.String("reason", msg))
+		http.Error(w, msg, http.StatusNotImplemented)
+		return
+	}
+
+	// the function loads the weights of the model from redis
+	redisClient := util.GetRedisAIClient(c.redisPool, false)
+	weights, err := c.modelWeights(redisClient, jobId)
+	redisClient.Close()
+	if err != nil {
+		c.logger.Error("Could not list the weights of the model", zap.Error(err))
+		http.Error(w, "Could not list the weights of the model", http.StatusInternalServerError)
+		return
+	}
+	if len(weights.Tensors) == 0 {
+		http.Error(w, "Could not find the model of the job in redis", http.StatusNotFound)
+		return
+	}
+
+	// once the response is started errors are reported as the last step
+	w.Header().Set("Content-Type", util.ContentTypeNDJSON)
+	w.WriteHeader(http.StatusOK)
+	progress := &progressWriter{w: w, enc: json.NewEncoder(w)}
+
+	steps := []*api.ExportProgress{
+		{Stage: api.ExportStageChecking, Message: fmt.Sprintf("found model with %d layers", len(weights.Tensors))},
+		{Stage: api.ExportStageExporting, Message: fmt.Sprintf("invoking function %s", history.Task.FunctionName)},
+	}
+	for _, step := range steps {
+		if err := progress.write(step); err != nil {
+			c.logger.Error("Could not write export progress", zap.Error(err))
+			return
+		}
+	}
+
+	artifact, err := c.invokeExport(history.Task.FunctionName, jobId, format)
+	last := &api.ExportProgress{Stage: api.ExportStageDone, Artifact: artifact}
+	if err != nil {
+		c.logger.Error("Could not export model",
+			zap.String("jobId", jobId),
+			zap.Error(err))
+		last = &api.ExportProgress{Stage: api.ExportStageFailed, Error: err.Error()}
+	}
+	if err := progress.write(last); err != nil {
+		c.logger.Error("Could not write export progress", zap.Error(err))
+	}
+}
+
+// invokeExport invokes the export task of the function and checks that the
+// artifact it uploaded to the storage service matches the one it exported
+func (c *Controller) invokeExport(funcName, jobId, format string) (*api.ModelArtifact, error) {
+	routerAddr := util.RouterUrl()
+
+	values := url.Values{}
+	values.Set("task", "export")
+	values.Set("jobId", jobId)
+	values.Set("format", format)
+	values.Set("funcId", "0")
+	values.Set("N", "1")
+
+	resp, err := http.Get(routerAddr + "/" + funcName + "?" + values.Encode())
+	if err != nil {
+		return nil, errors.Wrap(err, "could not invoke function")
+	}
+	if err = kerror.CheckFunctionError(resp); err != nil {
+		if e, ok := err.(kerror.Error); ok && e.Code == http.StatusNotImplemented {
+			return nil, fmt.Errorf("function %s does not implement export: %v", funcName, e.Message)
+		}
+		return nil, errors.Wrap(err, "function could not export the model")
+	}
+
+	artifact := &api.ModelArtifact{JobId: jobId, Format: format, Created: time.Now()}
+	if err = util.DecodeResponse(resp, artifact); err != nil {
+		return nil, errors.Wrap(err, "could not decode exported artifact")
+	}
+
+	stored, err := c.storedArtifact(jobId, format)
+	if err != nil {
+		return nil, err
+	}
+	if stored.SHA256 != artifact.SHA256 || stored.Size != artifact.Size {
+		return nil, fmt.Errorf("stored artifact does not match the exported one, got %d bytes with sha256 %s",
+			stored.Size, stored.SHA256)
+	}
+	return artifact, nil
+}
+
+// storedArtifact returns the size and checksum of the artifact kept in the storage service
+func (c *Controller) storedArtifact(jobId, format string) (*api.ModelArtifact, error) {
+	storageAddr := api.StorageUrl
+	if util.IsDebugEnv() {
+		storageAddr = api.StorageAddressDebug
+	}
+
+	resp, err := http.Head(fmt.Sprintf("%s/artifacts/%s/%s", storageAddr, jobId, format))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not reach the storage service")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("storage service has no %s artifact for the job, status %d", format, resp.StatusCode)
+	}
+
+	return &api.ModelArtifact{
+		JobId:  jobId,
+		Format: format,
+		Size:   resp.ContentLength,
+		SHA256: resp.Header.Get(api.HeaderChecksum),
+	}, nil
+}
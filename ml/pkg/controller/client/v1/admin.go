@@ -0,0 +1,170 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type (
+	AdminGetter interface {
+		Admin() AdminInterface
+	}
+
+	AdminInterface interface {
+		GetLimits() (*api.AdmissionLimits, error)
+		SetLimits(limits *api.AdmissionLimits) error
+		Audit(query AuditQuery) (*api.AdminAuditPage, error)
+		Components() ([]api.DeploymentInfo, error)
+	}
+
+	// AuditQuery filters the entries of the audit log, the zero values
+	// do not filter. After is the last sequence number already read
+	AuditQuery struct {
+		Since  time.Time
+		Actor  string
+		Action string
+		After  int64
+		Limit  int
+	}
+
+	admin struct {
+		controllerUrl string
+		httpClient    *http.Client
+	}
+)
+
+func newAdmin(c *V1) AdminInterface {
+	return &admin{
+		controllerUrl: c.controllerUrl,
+		httpClient:    c.httpClient,
+	}
+}
+
+// GetLimits returns the admission limits of the train requests
+func (a *admin) GetLimits() (*api.AdmissionLimits, error) {
+	url := a.controllerUrl + "/admin/limits"
+
+	resp, err := a.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform limits request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read body")
+	}
+
+	var limits api.AdmissionLimits
+	if err = json.Unmarshal(body, &limits); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal limits")
+	}
+
+	return &limits, nil
+}
+
+// SetLimits replaces the admission limits of the train requests
+func (a *admin) SetLimits(limits *api.AdmissionLimits) error {
+	url := a.controllerUrl + "/admin/limits"
+
+	body, err := json.Marshal(limits)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal limits")
+	}
+
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not perform limits request")
+	}
+
+	return kerror.CheckHttpResponse(resp)
+}
+
+// Audit returns a page of the audit log of the administrative actions
+func (a *admin) Audit(query AuditQuery) (*api.AdminAuditPage, error) {
+	values := url.Values{}
+	if !query.Since.IsZero() {
+		values.Set("since", query.Since.Format(time.RFC3339))
+	}
+	if len(query.Actor) > 0 {
+		values.Set("actor", query.Actor)
+	}
+	if len(query.Action) > 0 {
+		values.Set("action", query.Action)
+	}
+	if query.After > 0 {
+		values.Set("after", strconv.FormatInt(query.After, 10))
+	}
+	if query.Limit > 0 {
+		values.Set("limit", strconv.Itoa(query.Limit))
+	}
+	u := a.controllerUrl + "/audit?" + values.Encode()
+
+	resp, err := a.httpClient.Get(u)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform audit request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read body")
+	}
+
+	var page api.AdminAuditPage
+	if err = json.Unmarshal(body, &page); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal audit log")
+	}
+
+	return &page, nil
+}
+
+// Components returns the components of kubeml that reported
+// their deployment, with the stale ones marked
+func (a *admin) Components() ([]api.DeploymentInfo, error) {
+	url := a.controllerUrl + "/deployments"
+
+	resp, err := a.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform deployments request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read body")
+	}
+
+	var components []api.DeploymentInfo
+	if err = json.Unmarshal(body, &components); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal deployments")
+	}
+
+	return components, nil
+}
@@ -0,0 +1,84 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+)
+
+type (
+	FunctionGetter interface {
+		Functions() FunctionInterface
+	}
+
+	FunctionInterface interface {
+		Invalidate(name string) error
+		Conformance(name string, batchSize int) (*api.ConformanceReport, error)
+	}
+
+	functions struct {
+		controllerUrl string
+		httpClient    *http.Client
+	}
+)
+
+func newFunctions(c *V1) FunctionInterface {
+	return &functions{
+		controllerUrl: c.controllerUrl,
+		httpClient:    c.httpClient,
+	}
+}
+
+// Invalidate makes the controller look up the function again the next
+// time it is used, called after the function is created, updated or deleted
+func (f *functions) Invalidate(name string) error {
+	url := f.controllerUrl + "/functions/" + name + "/cache"
+
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return errors.Wrap(err, "could not create request")
+	}
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not invalidate function")
+	}
+	defer resp.Body.Close()
+
+	return kerror.CheckHttpResponse(resp)
+}
+
+// Conformance runs the conformance checks against the function and returns
+// the report. A zero batch size uses the default of the controller
+func (f *functions) Conformance(name string, batchSize int) (*api.ConformanceReport, error) {
+	url := f.controllerUrl + "/functions/" + name + "/conformance"
+	if batchSize > 0 {
+		url += fmt.Sprintf("?batchSize=%d", batchSize)
+	}
+
+	resp, err := f.httpClient.Post(url, "application/json", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not run conformance checks")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read body")
+	}
+
+	var report api.ConformanceReport
+	if err = json.Unmarshal(body, &report); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal conformance report")
+	}
+
+	return &report, nil
+}
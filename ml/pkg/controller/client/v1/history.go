@@ -5,8 +5,10 @@ import (
 	"github.com/diegostock12/kubeml/ml/pkg/api"
 	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
 	"github.com/pkg/errors"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 )
 
 type (
@@ -19,6 +21,12 @@ type (
 		Delete(taskId string) error
 		List() ([]api.History, error)
 		Prune() error
+		Export(taskId string, includeWeights, fromCheckpoint bool) (io.ReadCloser, error)
+		Audit(taskId string) (*api.DataAudit, error)
+		Metric(taskId, metric string, sinceEpoch int) (*api.MetricSeries, error)
+		SweepBest(sweepId, metric string, target float64) (*api.SweepBest, error)
+		Recommendation(function, dataset string) (*api.ParallelismRecommendation, error)
+		Suggestions(function, dataset string) (*api.Suggestions, error)
 	}
 
 	histories struct {
@@ -119,3 +127,170 @@ func (h *histories) Prune() error {
 	return kerror.CheckHttpResponse(resp)
 
 }
+
+// Export returns the bundle of a job as a gzipped tarball, which
+// is streamed from the controller and must be closed by the caller
+func (h *histories) Export(taskId string, includeWeights, fromCheckpoint bool) (io.ReadCloser, error) {
+	url := h.controllerUrl + "/history/" + taskId + "/export?includeWeights=" + strconv.FormatBool(includeWeights) +
+		"&fromCheckpoint=" + strconv.FormatBool(fromCheckpoint)
+
+	resp, err := h.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform export request")
+	}
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// Audit returns the record of the data each function of
+// the job trained on in every epoch
+func (h *histories) Audit(taskId string) (*api.DataAudit, error) {
+	url := h.controllerUrl + "/history/" + taskId + "/audit"
+
+	resp, err := h.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform audit request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse body")
+	}
+
+	var audit api.DataAudit
+	err = json.Unmarshal(body, &audit)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal audit")
+	}
+
+	return &audit, nil
+}
+
+// Metric returns the series of a single metric of the job,
+// only with the values recorded after sinceEpoch
+func (h *histories) Metric(taskId, metric string, sinceEpoch int) (*api.MetricSeries, error) {
+	url := h.controllerUrl + "/history/" + taskId + "/metrics/" + metric + "?sinceEpoch=" + strconv.Itoa(sinceEpoch)
+
+	resp, err := h.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform metric request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse body")
+	}
+
+	var series api.MetricSeries
+	err = json.Unmarshal(body, &series)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal metric")
+	}
+
+	return &series, nil
+}
+
+// SweepBest returns the job of the sweep with the best value of the metric, or the
+// one that reached the target accuracy the fastest if the target is not zero
+func (h *histories) SweepBest(sweepId, metric string, target float64) (*api.SweepBest, error) {
+	url := h.controllerUrl + "/sweeps/" + sweepId + "/best?metric=" + metric
+	if target != 0 {
+		url += "&target=" + strconv.FormatFloat(target, 'f', -1, 64)
+	}
+
+	resp, err := h.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform sweep request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse body")
+	}
+
+	var best api.SweepBest
+	err = json.Unmarshal(body, &best)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal sweep")
+	}
+
+	return &best, nil
+}
+
+// Recommendation returns the parallelism recommended for the function and the
+// dataset by the last job that trained them, the error has status 404 if none did
+func (h *histories) Recommendation(function, dataset string) (*api.ParallelismRecommendation, error) {
+	url := h.controllerUrl + "/recommendations/" + function + "/" + dataset
+
+	resp, err := h.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform recommendation request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse body")
+	}
+
+	var recommendation api.ParallelismRecommendation
+	err = json.Unmarshal(body, &recommendation)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal recommendation")
+	}
+
+	return &recommendation, nil
+}
+
+// Suggestions returns the settings suggested for the function and the
+// dataset from their previous runs
+func (h *histories) Suggestions(function, dataset string) (*api.Suggestions, error) {
+	url := h.controllerUrl + "/suggestions/" + function + "/" + dataset
+
+	resp, err := h.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform suggestions request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse body")
+	}
+
+	var suggestions api.Suggestions
+	err = json.Unmarshal(body, &suggestions)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal suggestions")
+	}
+
+	return &suggestions, nil
+}
@@ -4,9 +4,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
 	"github.com/pkg/errors"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
 type (
@@ -15,14 +21,26 @@ type (
 	}
 
 	NetworkInterface interface {
-		Train(req *api.TrainRequest) (string, error)
-		Infer(req *api.InferRequest) ([]byte, error)
+		Train(req *api.TrainRequest) (*api.TrainResponse, error)
+		Continue(jobId string, epochs int) (*api.TrainResponse, error)
+		Infer(req *api.InferRequest, noCache bool) ([]byte, error)
+		InferStream(req *api.InferRequest, noCache, ndjson bool) (*Predictions, error)
+		Summary(jobId string) (*api.ModelSummary, error)
+		Diff(a, b string) (*api.ModelDiff, error)
+		Export(jobId, format string, progress func(*api.ExportProgress)) (*api.ModelArtifact, error)
+		Artifact(jobId, format string) (io.ReadCloser, *api.ModelArtifact, error)
 	}
 
 	networks struct {
 		controllerUrl string
 		httpClient    *http.Client
 	}
+
+	// Predictions is the stream of predictions returned by an inference
+	Predictions struct {
+		io.ReadCloser
+		resp *http.Response
+	}
 )
 
 func newNetworks(c *V1) NetworkInterface {
@@ -32,51 +50,296 @@ func newNetworks(c *V1) NetworkInterface {
 	}
 }
 
-func (n *networks) Train(req *api.TrainRequest) (string, error) {
+// Train submits the train request, returning the id of the job along
+// with the iterations per epoch planned by the controller
+func (n *networks) Train(req *api.TrainRequest) (*api.TrainResponse, error) {
 	url := n.controllerUrl + "/train"
 
 	body, err := json.Marshal(req)
 	if err != nil {
-		return "", errors.Wrap(err, "could not send train job to the controller")
+		return nil, errors.Wrap(err, "could not send train job to the controller")
 	}
 
 	// send the request and get the task id
 	// TODO this task id could be generated by the client
 	resp, err := n.httpClient.Post(url, "application/json", bytes.NewBuffer(body))
 	if err != nil {
-		return "", errors.Wrap(err, "could not process train job")
+		return nil, errors.Wrap(err, "could not process train job")
+	}
+
+	defer resp.Body.Close()
+
+	return trainResponse(resp)
+}
+
+// Continue trains a completed job for more epochs, resuming from its last
+// checkpoint and appending the new epochs to its history. The job keeps its id
+func (n *networks) Continue(jobId string, epochs int) (*api.TrainResponse, error) {
+	url := n.controllerUrl + "/tasks/" + jobId + "/continue"
+
+	body, err := json.Marshal(api.ContinueRequest{Epochs: epochs})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode continue request")
+	}
+
+	resp, err := n.httpClient.Post(url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not continue job")
 	}
 
 	defer resp.Body.Close()
 
+	return trainResponse(resp)
+}
+
+// trainResponse reads the id of the job submitted and the
+// headers set by the controller from its response
+func trainResponse(resp *http.Response) (*api.TrainResponse, error) {
+	if err := kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
 	id, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return string(id), nil
+	// the suggestions are only a hint, so they are left out if they can't be read
+	var suggestions *api.Suggestions
+	if header := resp.Header.Get(api.HeaderSuggestions); len(header) > 0 {
+		suggestions = &api.Suggestions{}
+		if json.Unmarshal([]byte(header), suggestions) != nil {
+			suggestions = nil
+		}
+	}
+
+	iterations, _ := strconv.Atoi(resp.Header.Get(api.HeaderIterationsPerEpoch))
+	return &api.TrainResponse{
+		Id:                 string(id),
+		IterationsPerEpoch: iterations,
+		Warning:            resp.Header.Get(api.HeaderWarning),
+		NotifySecret:       resp.Header.Get(api.HeaderNotifySecret),
+		Suggestions:        suggestions,
+	}, nil
 }
 
-func (n *networks) Infer(req *api.InferRequest) ([]byte, error) {
+// Infer sends the inference request, if noCache is set the
+// controller does not use its inference cache for the request
+func (n *networks) Infer(req *api.InferRequest, noCache bool) ([]byte, error) {
+	preds, err := n.InferStream(req, noCache, false)
+	if err != nil {
+		return nil, err
+	}
+	defer preds.Close()
+
+	body, err := ioutil.ReadAll(preds)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read response body")
+	}
+	return body, nil
+}
+
+// InferStream returns the predictions of the request as a JSON stream that the caller
+// must close, so big results can be written out without holding them in memory. If
+// ndjson is set the predictions are returned as JSON lines instead of a JSON object
+func (n *networks) InferStream(req *api.InferRequest, noCache, ndjson bool) (*Predictions, error) {
 	url := n.controllerUrl + "/infer"
+	if noCache {
+		url += "?noCache=true"
+	}
 
-	// Create the request body
-	body, err := json.Marshal(req)
+	// Create the request body in the requested format
+	body, contentType, err := util.Encode(req.Serialization, req)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not send train request to scheduler")
 	}
 
+	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create request")
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	if ndjson {
+		httpReq.Header.Set("Accept", util.ContentTypeNDJSON)
+	}
+
 	// Send the request and return the id
-	resp, err := n.httpClient.Post(url, "application/json", bytes.NewBuffer(body))
+	resp, err := n.httpClient.Do(httpReq)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not process inference job")
 	}
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	// the predictions are always returned as JSON, only the
+	// results that are not streamed can be encoded in msgpack
+	if util.IsMsgpack(resp.Header.Get("Content-Type")) {
+		var preds interface{}
+		if err = util.DecodeResponse(resp, &preds); err != nil {
+			return nil, errors.Wrap(err, "could not decode predictions")
+		}
+		body, err = json.Marshal(preds)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not encode predictions")
+		}
+		return &Predictions{ReadCloser: ioutil.NopCloser(bytes.NewReader(body)), resp: resp}, nil
+	}
+
+	return &Predictions{ReadCloser: resp.Body, resp: resp}, nil
+}
+
+// Counts returns the number of datapoints that succeeded and failed in an inference
+// that allows partial results. The counts of streamed predictions are sent after
+// them, so they are only known once the stream is read to the end
+func (p *Predictions) Counts() (succeeded, failed int, ok bool) {
+	for _, h := range []http.Header{p.resp.Trailer, p.resp.Header} {
+		s, errS := strconv.Atoi(h.Get(api.HeaderInferSucceeded))
+		f, errF := strconv.Atoi(h.Get(api.HeaderInferFailed))
+		if errS == nil && errF == nil {
+			return s, f, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Warning returns the warnings of the controller about the inputs of the request,
+// one for each model of an ensemble, empty if they were not checked or match the
+// train set
+func (p *Predictions) Warning() string {
+	return strings.Join(p.resp.Header[http.CanonicalHeaderKey(api.HeaderWarning)], "; ")
+}
+
+// Snapshot returns the generation and epoch of the snapshot the predictions were
+// made with if the model was still being trained, ok is false otherwise
+func (p *Predictions) Snapshot() (generation, epoch int, ok bool) {
+	g, errG := strconv.Atoi(p.resp.Header.Get(api.HeaderSnapshotGeneration))
+	e, errE := strconv.Atoi(p.resp.Header.Get(api.HeaderSnapshotEpoch))
+	if errG != nil || errE != nil {
+		return 0, 0, false
+	}
+	return g, e, true
+}
+
+// Summary returns the layers of the model trained by a job
+func (n *networks) Summary(jobId string) (*api.ModelSummary, error) {
+	url := n.controllerUrl + "/models/" + jobId + "/summary"
+
+	resp, err := n.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform summary request")
+	}
 	defer resp.Body.Close()
 
-	body, err = ioutil.ReadAll(resp.Body)
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not read response body")
 	}
 
-	return body, nil
+	var summary api.ModelSummary
+	if err = json.Unmarshal(body, &summary); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal model summary")
+	}
+
+	return &summary, nil
+}
+
+// Diff compares the weights of two models, each given as
+// <jobId> or <jobId>@<epoch> for the checkpoint of an epoch
+func (n *networks) Diff(a, b string) (*api.ModelDiff, error) {
+	query := url.Values{}
+	query.Set("a", a)
+	query.Set("b", b)
+	u := n.controllerUrl + "/models/diff?" + query.Encode()
+
+	resp, err := n.httpClient.Get(u)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform diff request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read response body")
+	}
+
+	var diff api.ModelDiff
+	if err = json.Unmarshal(body, &diff); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal model diff")
+	}
+
+	return &diff, nil
+}
+
+// Export exports the model of a job to the format, calling progress with every step
+// reported by the controller, and returns the artifact kept in the storage service
+func (n *networks) Export(jobId, format string, progress func(*api.ExportProgress)) (*api.ModelArtifact, error) {
+	query := url.Values{}
+	query.Set("format", format)
+	u := n.controllerUrl + "/models/" + jobId + "/export?" + query.Encode()
+
+	resp, err := n.httpClient.Post(u, "application/json", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform export request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var step api.ExportProgress
+		if err := dec.Decode(&step); err == io.EOF {
+			return nil, errors.New("export ended without a result")
+		} else if err != nil {
+			return nil, errors.Wrap(err, "could not read export progress")
+		}
+
+		if progress != nil {
+			progress(&step)
+		}
+		switch step.Stage {
+		case api.ExportStageDone:
+			if step.Artifact == nil {
+				return nil, errors.New("export finished without an artifact")
+			}
+			return step.Artifact, nil
+		case api.ExportStageFailed:
+			return nil, errors.New(step.Error)
+		}
+	}
+}
+
+// Artifact returns the exported model of a job as a stream that the caller must close,
+// along with the size and checksum reported by the storage service
+func (n *networks) Artifact(jobId, format string) (io.ReadCloser, *api.ModelArtifact, error) {
+	u := n.controllerUrl + "/artifacts/" + jobId + "/" + format
+
+	resp, err := n.httpClient.Get(u)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not perform artifact request")
+	}
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, nil, err
+	}
+
+	artifact := &api.ModelArtifact{
+		JobId:  jobId,
+		Format: format,
+		Size:   resp.ContentLength,
+		SHA256: resp.Header.Get(api.HeaderChecksum),
+	}
+	return resp.Body, artifact, nil
 }
@@ -0,0 +1,70 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type (
+	StatsGetter interface {
+		Stats() StatsInterface
+	}
+
+	StatsInterface interface {
+		Overview(window time.Duration, top int) (*api.StatsOverview, error)
+	}
+
+	stats struct {
+		controllerUrl string
+		httpClient    *http.Client
+	}
+)
+
+func newStats(c *V1) StatsInterface {
+	return &stats{
+		controllerUrl: c.controllerUrl,
+		httpClient:    c.httpClient,
+	}
+}
+
+// Overview returns the aggregated usage of the cluster in the window. A zero
+// window or top uses the defaults of the controller
+func (s *stats) Overview(window time.Duration, top int) (*api.StatsOverview, error) {
+	query := url.Values{}
+	if window > 0 {
+		query.Set("window", window.String())
+	}
+	if top > 0 {
+		query.Set("top", fmt.Sprint(top))
+	}
+	u := s.controllerUrl + "/stats/overview?" + query.Encode()
+
+	resp, err := s.httpClient.Get(u)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform stats request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read body")
+	}
+
+	var overview api.StatsOverview
+	if err = json.Unmarshal(body, &overview); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal overview")
+	}
+
+	return &overview, nil
+}
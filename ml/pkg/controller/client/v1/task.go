@@ -1,11 +1,14 @@
 package v1
 
 import (
+	"bytes"
 	"encoding/json"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
 	"github.com/pkg/errors"
 	"io/ioutil"
 	"net/http"
+	"time"
 )
 
 type (
@@ -15,7 +18,14 @@ type (
 
 	TaskInterface interface {
 		List() ([]api.TrainTask, error)
+		Get(id string) (*api.TrainTask, error)
+		Trace(id string) ([]api.SchedulerDecision, error)
+		Invocations(id string) (*api.InvocationTable, error)
 		Stop(id string) error
+		Pause(id string, until time.Time) error
+		Resume(id string) error
+		SetLogLevel(id, level string) error
+		Batch(req *api.JobBatchRequest) (*api.JobBatchResponse, error)
 	}
 
 	tasks struct {
@@ -55,6 +65,87 @@ func (t *tasks) List() ([]api.TrainTask, error) {
 
 }
 
+func (t *tasks) Get(id string) (*api.TrainTask, error) {
+	url := t.controllerUrl + "/tasks/" + id
+
+	resp, err := t.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform task request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var task api.TrainTask
+	err = json.Unmarshal(body, &task)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal task")
+	}
+
+	return &task, nil
+}
+
+func (t *tasks) Trace(id string) ([]api.SchedulerDecision, error) {
+	url := t.controllerUrl + "/tasks/" + id + "/trace"
+
+	resp, err := t.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform trace request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var trace []api.SchedulerDecision
+	err = json.Unmarshal(body, &trace)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal trace")
+	}
+
+	return trace, nil
+}
+
+func (t *tasks) Invocations(id string) (*api.InvocationTable, error) {
+	url := t.controllerUrl + "/tasks/" + id + "/invocations"
+
+	resp, err := t.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform invocations request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var table api.InvocationTable
+	err = json.Unmarshal(body, &table)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal invocations")
+	}
+
+	return &table, nil
+}
+
 func (t *tasks) Stop(id string) error {
 	url := t.controllerUrl + "/tasks/" + id
 
@@ -80,3 +171,83 @@ func (t *tasks) Stop(id string) error {
 	return nil
 
 }
+
+// SetLogLevel changes the log level of a running task
+func (t *tasks) SetLogLevel(id, level string) error {
+	url := t.controllerUrl + "/tasks/" + id + "/loglevel"
+
+	body, err := json.Marshal(api.LogLevelRequest{Level: level})
+	if err != nil {
+		return errors.Wrap(err, "could not marshal log level")
+	}
+
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not create request body")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not handle request")
+	}
+
+	return kerror.CheckHttpResponse(resp)
+}
+
+// Pause pauses a running task after the epoch in progress,
+// if until is set the task resumes by itself at that time
+func (t *tasks) Pause(id string, until time.Time) error {
+	url := t.controllerUrl + "/tasks/" + id + "/pause"
+
+	body, err := json.Marshal(api.PauseRequest{Until: until})
+	if err != nil {
+		return errors.Wrap(err, "could not marshal pause request")
+	}
+
+	resp, err := t.httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not handle request")
+	}
+
+	return kerror.CheckHttpResponse(resp)
+}
+
+// Resume resumes a paused task
+func (t *tasks) Resume(id string) error {
+	url := t.controllerUrl + "/tasks/" + id + "/resume"
+
+	resp, err := t.httpClient.Post(url, "application/json", nil)
+	if err != nil {
+		return errors.Wrap(err, "could not handle request")
+	}
+
+	return kerror.CheckHttpResponse(resp)
+}
+
+// Batch applies an operation to all the jobs matching the filter of the request,
+// returning the result of each job, or only the jobs matched if it is a dry run
+func (t *tasks) Batch(req *api.JobBatchRequest) (*api.JobBatchResponse, error) {
+	url := t.controllerUrl + "/jobs:batch"
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal batch request")
+	}
+
+	resp, err := t.httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not handle request")
+	}
+	defer resp.Body.Close()
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var batch api.JobBatchResponse
+	if err = json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal batch response")
+	}
+	return &batch, nil
+}
@@ -1,12 +1,20 @@
 package v1
 
-import "net/http"
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"net/http"
+	"os"
+	"os/user"
+)
 
 type V1Interface interface {
 	NetworkGetter
 	DatasetsGetter
 	HistoryGetter
 	TaskGetter
+	AdminGetter
+	StatsGetter
+	FunctionGetter
 }
 
 type V1 struct {
@@ -14,10 +22,38 @@ type V1 struct {
 	httpClient    *http.Client
 }
 
+// actorTransport sets the user running the client in the requests, which
+// the controller records in the audit log of the administrative actions
+type actorTransport struct {
+	actor string
+}
+
+func (t *actorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for key, values := range req.Header {
+		r.Header[key] = values
+	}
+	r.Header.Set(api.AdminActorHeader, t.actor)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// clientActor returns the user set in KUBEML_USER or the user running the client
+func clientActor() string {
+	if actor := os.Getenv("KUBEML_USER"); len(actor) > 0 {
+		return actor
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return api.UnknownActor
+}
+
 func MakeV1Client(serverUrl string) V1Interface {
 	return &V1{
 		controllerUrl: serverUrl,
-		httpClient:    &http.Client{},
+		httpClient:    &http.Client{Transport: &actorTransport{actor: clientActor()}},
 	}
 }
 
@@ -36,3 +72,15 @@ func (c *V1) Datasets() DatasetInterface {
 func (c *V1) Tasks() TaskInterface {
 	return newTasks(c)
 }
+
+func (c *V1) Admin() AdminInterface {
+	return newAdmin(c)
+}
+
+func (c *V1) Stats() StatsInterface {
+	return newStats(c)
+}
+
+func (c *V1) Functions() FunctionInterface {
+	return newFunctions(c)
+}
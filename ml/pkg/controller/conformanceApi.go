@@ -0,0 +1,292 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"github.com/diegostock12/kubeml/ml/pkg/model"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// conformanceRunner invokes every task of a function through the router under a
+// throwaway job and checks the responses against the schemas of the jobs. The router
+// is passed so the same checks can be run against a fake router
+type conformanceRunner struct {
+	logger    *zap.Logger
+	routerUrl string
+	jobId     string
+	batchSize int
+	report    *api.ConformanceReport
+}
+
+// conformanceFunction checks that a function follows the contract of the jobs, running
+// init, sanity, train, validation, canary and the exports on a tiny slice of its dataset.
+// The report lists every check with the capability it belongs to and, for the failures,
+// the field of the response that deviated. The model and the artifacts of the throwaway
+// job are deleted once the checks finish
+func (c *Controller) conformanceFunction(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	batchSize := api.DefaultConformanceBatchSize
+	if s := r.URL.Query().Get("batchSize"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			c.logger.Error("Invalid conformance batch size", zap.String("batchSize", s))
+			http.Error(w, fmt.Sprintf("batch size should be a positive integer, got \"%s\"", s), http.StatusBadRequest)
+			return
+		}
+		batchSize = n
+	}
+
+	runner := &conformanceRunner{
+		logger:    c.logger.Named("conformance"),
+		routerUrl: util.RouterUrl() + "/" + name,
+		jobId:     api.ConformanceJobPrefix + uuid.New().String()[:8],
+		batchSize: batchSize,
+		report:    &api.ConformanceReport{Function: name},
+	}
+
+	c.logger.Debug("Running conformance checks",
+		zap.String("function", name),
+		zap.String("jobId", runner.jobId))
+
+	runner.run()
+	c.cleanConformance(runner.jobId)
+
+	resp, err := json.Marshal(runner.report)
+	if err != nil {
+		c.logger.Error("Could not marshal conformance report", zap.Error(err))
+		http.Error(w, "Could not marshal conformance report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// cleanConformance deletes the model and the artifacts of the throwaway job
+func (c *Controller) cleanConformance(jobId string) {
+	if _, err := model.NewRedisStore(c.redisPool).DeletePrefix(jobId); err != nil {
+		c.logger.Warn("Could not delete the model of the conformance checks",
+			zap.String("jobId", jobId),
+			zap.Error(err))
+	}
+
+	storageAddr := api.StorageUrl
+	if util.IsDebugEnv() {
+		storageAddr = api.StorageAddressDebug
+	}
+	for _, format := range []string{api.ExportFormatONNX, api.ExportFormatTorchScript} {
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/artifacts/%s/%s", storageAddr, jobId, format), nil)
+		if err != nil {
+			continue
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			c.logger.Warn("Could not delete the artifacts of the conformance checks", zap.Error(err))
+			return
+		}
+		resp.Body.Close()
+	}
+}
+
+// run invokes the tasks in the order of a job. The model is created by init, so
+// if it fails the rest of the checks are skipped
+func (r *conformanceRunner) run() {
+	if !r.check("init", api.CapabilityTrain, r.checkInit) {
+		for _, task := range []string{"sanity", "train", "val", "canary"} {
+			r.skip(task, api.CapabilityTrain)
+		}
+		r.skip("export onnx", api.CapabilityExport)
+		r.skip("export torchscript", api.CapabilityTorchScript)
+		return
+	}
+
+	r.check("sanity", api.CapabilityTrain, r.checkSanity)
+	r.check("train", api.CapabilityTrain, r.checkMetrics(api.TrainResponseSchema, url.Values{"lr": {"0.01"}}))
+	r.check("val", api.CapabilityTrain, r.checkMetrics(api.ValidationResponseSchema, nil))
+	r.check("canary", api.CapabilityTrain, r.checkMetrics(api.CanaryResponseSchema,
+		url.Values{"canarySize": {strconv.Itoa(r.batchSize)}}))
+	for _, format := range []string{api.ExportFormatONNX, api.ExportFormatTorchScript} {
+		r.check("export "+format, api.ExportCapability(format), r.checkExport(format))
+	}
+}
+
+// check runs a check and adds its result to the report, returning whether it passed.
+// The field is taken from the error if it is an invalid response
+func (r *conformanceRunner) check(task, capability string, check func(declared bool) error) bool {
+	start := time.Now()
+	err := check(r.report.Declares(capability))
+	result := api.ConformanceCheck{
+		Task:       task,
+		Capability: capability,
+		Declared:   r.report.Declares(capability),
+		Result:     api.ConformancePass,
+		Elapsed:    time.Since(start).Seconds(),
+	}
+
+	if err != nil {
+		result.Result = api.ConformanceFail
+		result.Message = err.Error()
+		if e, ok := err.(*api.ResponseError); ok {
+			result.Field = e.Field
+			result.Message = e.Reason
+		}
+		r.logger.Debug("Conformance check failed",
+			zap.String("task", task),
+			zap.String("field", result.Field),
+			zap.Error(err))
+	}
+
+	r.report.Checks = append(r.report.Checks, result)
+	return err == nil
+}
+
+// skip adds a check that could not run to the report
+func (r *conformanceRunner) skip(task, capability string) {
+	r.report.Checks = append(r.report.Checks, api.ConformanceCheck{
+		Task:       task,
+		Capability: capability,
+		Declared:   r.report.Declares(capability),
+		Result:     api.ConformanceSkip,
+		Message:    "init failed",
+	})
+}
+
+// invoke calls the function with the task and returns the body of the response.
+// The function trains as one of many so it only gets a tiny slice of the dataset,
+// and with K -1 it does not notify the job, which does not exist
+func (r *conformanceRunner) invoke(task string, extra url.Values) (*http.Response, []byte, error) {
+	values := url.Values{}
+	values.Set("task", task)
+	values.Set("jobId", r.jobId)
+	values.Set("N", strconv.Itoa(api.DefaultConformanceParallelism))
+	values.Set("K", "-1")
+	values.Set("funcId", "0")
+	values.Set("batchSize", strconv.Itoa(r.batchSize))
+	values.Set("epoch", "1")
+	for key, v := range extra {
+		values[key] = v
+	}
+
+	resp, err := http.Get(r.routerUrl + "?" + values.Encode())
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not invoke function")
+	}
+	if err = kerror.CheckFunctionError(resp); err != nil {
+		return resp, nil, err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil, errors.Wrap(err, "could not read response body")
+	}
+	return resp, body, nil
+}
+
+// checkInit checks the capabilities and schema version in the headers
+// of the init response and the layer names in its body
+func (r *conformanceRunner) checkInit(bool) error {
+	resp, body, err := r.invoke("init", nil)
+	if err != nil {
+		return err
+	}
+
+	header := resp.Header.Get(api.HeaderCapabilities)
+	r.report.Capabilities = api.ParseCapabilities(header)
+	if len(r.report.Capabilities) == 0 {
+		return api.NewResponseError("init", api.HeaderCapabilities, "is missing", body)
+	}
+	if !r.report.Declares(api.CapabilityTrain) {
+		return api.NewResponseError("init", api.HeaderCapabilities,
+			fmt.Sprintf("should include %s, got %s", api.CapabilityTrain, header), body)
+	}
+
+	r.report.SchemaVersion = resp.Header.Get(api.HeaderResponseSchema)
+	if err = api.CheckResponseSchema(r.report.SchemaVersion, false); err != nil {
+		return api.NewResponseError("init", api.HeaderResponseSchema, err.Error(), body)
+	}
+
+	var layers []string
+	if err = util.DecodeStrict(resp.Header.Get("Content-Type"), body, &layers); err != nil {
+		return api.NewResponseError("init", "", "should be the list of layer names: "+err.Error(), body)
+	}
+	if len(layers) == 0 {
+		return api.NewResponseError("init", "", "has no layers", body)
+	}
+	return nil
+}
+
+// checkSanity checks the shapes reported by the sanity check
+func (r *conformanceRunner) checkSanity(bool) error {
+	resp, body, err := r.invoke("sanity", nil)
+	if err != nil {
+		return err
+	}
+
+	var check api.SanityCheck
+	if err = util.DecodeStrict(resp.Header.Get("Content-Type"), body, &check); err != nil {
+		return api.NewResponseError("sanity", "", err.Error(), body)
+	}
+	return check.Verify(r.batchSize)
+}
+
+// checkMetrics checks the response of a task against its schema in strict mode
+func (r *conformanceRunner) checkMetrics(schema api.ResponseSchema, extra url.Values) func(bool) error {
+	return func(bool) error {
+		resp, body, err := r.invoke(schema.Task, extra)
+		if err != nil {
+			return err
+		}
+
+		var fields map[string]interface{}
+		if err = util.Decode(resp.Header.Get("Content-Type"), body, &fields); err != nil {
+			return api.NewResponseError(schema.Task, "", err.Error(), body)
+		}
+		_, err = schema.Metrics(fields, body, true)
+		return err
+	}
+}
+
+// checkExport checks that the function exports the model to the format if and only
+// if it declares the capability, and that the artifact it reports is the one stored
+func (r *conformanceRunner) checkExport(format string) func(bool) error {
+	return func(declared bool) error {
+		resp, body, err := r.invoke("export", url.Values{"format": {format}})
+		if e, ok := err.(kerror.Error); ok && e.Code == http.StatusNotImplemented {
+			if declared {
+				return fmt.Errorf("declares %s but does not implement it: %s", api.ExportCapability(format), e.Message)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !declared {
+			return api.NewResponseError("export", api.HeaderCapabilities,
+				fmt.Sprintf("exports to %s but does not declare %s", format, api.ExportCapability(format)), body)
+		}
+
+		var artifact api.ModelArtifact
+		if err = util.DecodeStrict(resp.Header.Get("Content-Type"), body, &artifact); err != nil {
+			return api.NewResponseError("export", "", err.Error(), body)
+		}
+		switch {
+		case artifact.Size <= 0:
+			return api.NewResponseError("export", "size", "should be positive", body)
+		case len(artifact.SHA256) == 0:
+			return api.NewResponseError("export", "sha256", "is missing", body)
+		}
+		return nil
+	}
+}
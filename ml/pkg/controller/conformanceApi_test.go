@@ -0,0 +1,307 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeFunction answers the tasks of the router like the reference python functions,
+// unless a task is overridden. Exports are only implemented for the capabilities
+// in exports
+type fakeFunction struct {
+	capabilities string
+	schema       string
+	exports      []string
+	overrides    map[string]interface{}
+}
+
+func newFakeFunction() *fakeFunction {
+	return &fakeFunction{
+		capabilities: api.CapabilityTrain,
+		schema:       fmt.Sprint(api.ResponseSchemaVersion),
+		overrides:    make(map[string]interface{}),
+	}
+}
+
+func (f *fakeFunction) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("K") != "-1" || !strings.HasPrefix(query.Get("jobId"), api.ConformanceJobPrefix) {
+		kerror.RespondWithError(w, kerror.New(http.StatusBadRequest, "not invoked as a conformance check"))
+		return
+	}
+
+	task := query.Get("task")
+	var resp interface{}
+	switch task {
+	case "init":
+		w.Header().Set(api.HeaderCapabilities, f.capabilities)
+		if len(f.schema) > 0 {
+			w.Header().Set(api.HeaderResponseSchema, f.schema)
+		}
+		resp = []string{"fc.weight", "fc.bias"}
+	case "sanity":
+		label := int64(3)
+		resp = api.SanityCheck{
+			Passed:      true,
+			InputShape:  []int64{8, 1, 28, 28},
+			LabelShape:  []int64{8},
+			OutputShape: []int64{8, 10},
+			Classes:     10,
+			MaxLabel:    &label,
+		}
+	case "train":
+		resp = map[string]float64{"loss": 2.3, "length": 8}
+	case "val":
+		resp = map[string]float64{"loss": 2.3, "accuracy": 10, "length": 8}
+	case "canary":
+		resp = map[string]float64{"loss": 2.3, "accuracy": 10, "length": 8}
+	case "export":
+		format := query.Get("format")
+		implemented := false
+		for _, e := range f.exports {
+			implemented = implemented || e == format
+		}
+		if !implemented {
+			kerror.RespondWithError(w, kerror.New(http.StatusNotImplemented, "export not implemented"))
+			return
+		}
+		resp = api.ModelArtifact{JobId: query.Get("jobId"), Format: format, Size: 100, SHA256: "abc"}
+	default:
+		kerror.RespondWithError(w, kerror.New(http.StatusBadRequest, "unknown task "+task))
+		return
+	}
+
+	if override, exists := f.overrides[task]; exists {
+		resp = override
+	}
+	body, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// runConformance runs the conformance checks against the function behind a fake router
+func runConformance(t *testing.T, f *fakeFunction) *api.ConformanceReport {
+	t.Helper()
+	router := httptest.NewServer(f)
+	defer router.Close()
+
+	runner := &conformanceRunner{
+		logger:    zap.NewNop(),
+		routerUrl: router.URL + "/fn",
+		jobId:     api.ConformanceJobPrefix + "test",
+		batchSize: api.DefaultConformanceBatchSize,
+		report:    &api.ConformanceReport{Function: "fn"},
+	}
+	runner.run()
+	return runner.report
+}
+
+// findCheck returns the check of the task in the report
+func findCheck(t *testing.T, report *api.ConformanceReport, task string) api.ConformanceCheck {
+	t.Helper()
+	for _, check := range report.Checks {
+		if check.Task == task {
+			return check
+		}
+	}
+	t.Fatalf("no check for task %s in %+v", task, report.Checks)
+	return api.ConformanceCheck{}
+}
+
+func TestConformancePasses(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities string
+		exports      []string
+	}{
+		{"train only", "train", nil},
+		{"onnx export", "train,export", []string{api.ExportFormatONNX}},
+		{"all exports", "train, export, torchscript", []string{api.ExportFormatONNX, api.ExportFormatTorchScript}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeFunction()
+			f.capabilities = tt.capabilities
+			f.exports = tt.exports
+
+			report := runConformance(t, f)
+			if !report.Passed() {
+				t.Errorf("got failed checks %+v, want all passed", report.Checks)
+			}
+			if len(report.Checks) != 7 {
+				t.Errorf("got %d checks, want 7", len(report.Checks))
+			}
+			if report.SchemaVersion != fmt.Sprint(api.ResponseSchemaVersion) {
+				t.Errorf("got schema version %s, want %d", report.SchemaVersion, api.ResponseSchemaVersion)
+			}
+			for _, e := range tt.exports {
+				if check := findCheck(t, report, "export "+e); !check.Declared {
+					t.Errorf("got export %s not declared, want declared", e)
+				}
+			}
+		})
+	}
+}
+
+func TestConformanceFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(f *fakeFunction)
+		task   string
+		field  string
+		reason string
+	}{
+		{
+			name:   "no capabilities",
+			setup:  func(f *fakeFunction) { f.capabilities = "" },
+			task:   "init",
+			field:  api.HeaderCapabilities,
+			reason: "is missing",
+		},
+		{
+			name:   "train capability missing",
+			setup:  func(f *fakeFunction) { f.capabilities = "export" },
+			task:   "init",
+			field:  api.HeaderCapabilities,
+			reason: "should include train",
+		},
+		{
+			name:   "no schema version",
+			setup:  func(f *fakeFunction) { f.schema = "" },
+			task:   "init",
+			field:  api.HeaderResponseSchema,
+			reason: "does not report the schema",
+		},
+		{
+			name:   "newer schema version",
+			setup:  func(f *fakeFunction) { f.schema = "99" },
+			task:   "init",
+			field:  api.HeaderResponseSchema,
+			reason: "schema 99",
+		},
+		{
+			name:   "no layers",
+			setup:  func(f *fakeFunction) { f.overrides["init"] = []string{} },
+			task:   "init",
+			reason: "has no layers",
+		},
+		{
+			name: "batch bigger than the batch size",
+			setup: func(f *fakeFunction) {
+				f.overrides["sanity"] = api.SanityCheck{Passed: true, InputShape: []int64{64, 10}}
+			},
+			task:   "sanity",
+			reason: "batch of 64 datapoints",
+		},
+		{
+			name:   "unknown train field",
+			setup:  func(f *fakeFunction) { f.overrides["train"] = map[string]float64{"loss": 1, "acc": 1} },
+			task:   "train",
+			field:  "acc",
+			reason: "is unknown",
+		},
+		{
+			name:   "train field not a number",
+			setup:  func(f *fakeFunction) { f.overrides["train"] = map[string]interface{}{"loss": "1.0"} },
+			task:   "train",
+			field:  "loss",
+			reason: "should be a number",
+		},
+		{
+			name:   "missing validation field",
+			setup:  func(f *fakeFunction) { f.overrides["val"] = map[string]float64{"loss": 1, "accuracy": 1} },
+			task:   "val",
+			field:  "length",
+			reason: "is missing",
+		},
+		{
+			name:   "missing canary field",
+			setup:  func(f *fakeFunction) { f.overrides["canary"] = map[string]float64{"loss": 1, "length": 8} },
+			task:   "canary",
+			field:  "accuracy",
+			reason: "is missing",
+		},
+		{
+			name:   "declared export not implemented",
+			setup:  func(f *fakeFunction) { f.capabilities = "train,torchscript" },
+			task:   "export torchscript",
+			reason: "declares torchscript but does not implement it",
+		},
+		{
+			name:   "export not declared",
+			setup:  func(f *fakeFunction) { f.exports = []string{api.ExportFormatONNX} },
+			task:   "export onnx",
+			field:  api.HeaderCapabilities,
+			reason: "does not declare export",
+		},
+		{
+			name: "export without checksum",
+			setup: func(f *fakeFunction) {
+				f.capabilities = "train,export"
+				f.exports = []string{api.ExportFormatONNX}
+				f.overrides["export"] = api.ModelArtifact{Format: api.ExportFormatONNX, Size: 100}
+			},
+			task:   "export onnx",
+			field:  "sha256",
+			reason: "is missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeFunction()
+			tt.setup(f)
+
+			report := runConformance(t, f)
+			if report.Passed() {
+				t.Fatal("got all checks passed, want a failure")
+			}
+
+			// the failure points at the task and field that deviated
+			// and the rest of the checks are not affected by it
+			for _, check := range report.Checks {
+				if check.Task != tt.task {
+					if check.Result == api.ConformanceFail {
+						t.Errorf("got unexpected failure of %s: %s", check.Task, check.Message)
+					}
+					continue
+				}
+				if check.Result != api.ConformanceFail {
+					t.Errorf("got result %s for %s, want %s", check.Result, check.Task, api.ConformanceFail)
+				}
+				if check.Field != tt.field {
+					t.Errorf("got field %q, want %q", check.Field, tt.field)
+				}
+				if !strings.Contains(check.Message, tt.reason) {
+					t.Errorf("got message %q, want it to contain %q", check.Message, tt.reason)
+				}
+			}
+		})
+	}
+}
+
+func TestConformanceSkipsAfterFailedInit(t *testing.T) {
+	f := newFakeFunction()
+	f.capabilities = ""
+
+	report := runConformance(t, f)
+	if len(report.Checks) != 7 {
+		t.Fatalf("got %d checks, want 7", len(report.Checks))
+	}
+	for _, check := range report.Checks[1:] {
+		if check.Result != api.ConformanceSkip {
+			t.Errorf("got result %s for %s, want %s", check.Result, check.Task, api.ConformanceSkip)
+		}
+	}
+	if check := findCheck(t, report, "export torchscript"); check.Capability != api.CapabilityTorchScript {
+		t.Errorf("got capability %s for the torchscript export, want %s", check.Capability, api.CapabilityTorchScript)
+	}
+}
@@ -4,14 +4,20 @@ import (
 	"context"
 	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/deployment"
+	"github.com/diegostock12/kubeml/ml/pkg/ps"
 	psClient "github.com/diegostock12/kubeml/ml/pkg/ps/client"
 	schedulerClient "github.com/diegostock12/kubeml/ml/pkg/scheduler/client"
+	storageClient "github.com/diegostock12/kubeml/ml/pkg/storage/client"
 	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/fission/fission/pkg/crd"
+	"github.com/gomodule/redigo/redis"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 	"log"
+	"sync"
 )
 
 // TODO the controller should also take care of creating the functions and so on
@@ -24,6 +30,53 @@ type (
 		scheduler   *schedulerClient.Client
 		ps          *psClient.Client
 		mongoClient *mongo.Client
+
+		// histories are the histories of the jobs changed by the batch
+		// operations and read by the checks of the inference inputs
+		histories jobHistories
+
+		// storage reads the stats of the datasets to resolve the class weights
+		// and check the test split of the train requests, and to check the
+		// inputs of the inference requests
+		storage storageClient.Interface
+
+		// maxScratchGB is the cap on the scratch volume size
+		// that a train request can ask for
+		maxScratchGB int
+
+		// maxIterations is the number of merges per epoch above
+		// which a train request is warned about its K
+		maxIterations int
+
+		// inferCache keeps the results of inference requests,
+		// nil if the cache is disabled
+		inferCache *inferCache
+
+		// inferChunkSize is the number of datapoints of each of the chunks
+		// of a streamed inference, and inferWindow the number of chunks
+		// in flight or waiting to be written
+		inferChunkSize int
+		inferWindow    int
+
+		// liveInfer holds a slot for every inference in flight on the
+		// snapshot of a model still being trained, see routeLive
+		liveInfer chan struct{}
+
+		// redisPool is used to export the weights of a job, and functions
+		// looks up the functions of the train requests and exports. The
+		// functions are nil if the fission client is not available
+		redisPool *redis.Pool
+		functions *functionCache
+
+		// exportMaxWeightsBytes is the size above which the weights
+		// are left out of the exported bundles by default
+		exportMaxWeightsBytes int64
+
+		// statsCache keeps the cluster overviews for a minute
+		statsCache *statsCache
+
+		// auditMu serializes the writes to the audit log
+		auditMu sync.Mutex
 	}
 )
 
@@ -52,19 +105,91 @@ func getMongoClient() (*mongo.Client, error) {
 func Start(logger *zap.Logger, port int, schedulerUrl, psUrl string) {
 
 	c := &Controller{
-		logger: logger.Named("controller"),
+		logger:     logger.Named("controller"),
+		statsCache: newStatsCache(),
 	}
 
 	// Set the scheduler and mongo clients
 	c.scheduler = schedulerClient.MakeClient(c.logger, schedulerUrl)
 	c.ps = psClient.MakeClient(c.logger, psUrl)
+	storageUrl := api.StorageUrl
+	if util.IsDebugEnv() {
+		storageUrl = api.StorageAddressDebug
+	}
+	c.storage = storageClient.MakeClient(c.logger, storageUrl)
 
 	client, err := getMongoClient()
 	if err != nil {
 		log.Fatal(err)
 	}
 	c.mongoClient = client
+	c.histories = &mongoHistories{client: client}
+	if err = c.createSuggestionIndex(); err != nil {
+		c.logger.Warn("Could not index the histories, the suggestions will scan them", zap.Error(err))
+	}
+
+	c.maxScratchGB, err = intFromEnv("MAX_SCRATCH_GB", api.DefaultMaxScratchGB)
+	if err != nil {
+		c.logger.Fatal("Invalid scratch volume cap", zap.Error(err))
+	}
+	c.logger.Debug("Set scratch volume cap", zap.Int("sizeGB", c.maxScratchGB))
+
+	c.maxIterations, err = intFromEnv("MAX_ITERATIONS_PER_EPOCH", api.DefaultMaxIterationsPerEpoch)
+	if err != nil {
+		c.logger.Fatal("Invalid iterations per epoch limit", zap.Error(err))
+	}
+
+	c.inferCache, err = makeInferCache()
+	if err != nil {
+		c.logger.Fatal("Invalid inference cache configuration", zap.Error(err))
+	}
+
+	c.inferChunkSize, err = intFromEnv("INFER_CHUNK_SIZE", defaultInferChunkSize)
+	if err == nil && c.inferChunkSize <= 0 {
+		err = errors.New("INFER_CHUNK_SIZE should be positive")
+	}
+	if err != nil {
+		c.logger.Fatal("Invalid inference chunk size", zap.Error(err))
+	}
+
+	c.inferWindow, err = intFromEnv("INFER_WINDOW", defaultInferWindow)
+	if err == nil && c.inferWindow <= 0 {
+		err = errors.New("INFER_WINDOW should be positive")
+	}
+	if err != nil {
+		c.logger.Fatal("Invalid inference window", zap.Error(err))
+	}
+
+	liveConcurrency, err := intFromEnv("LIVE_INFER_CONCURRENCY", api.DefaultLiveInferenceConcurrency)
+	if err == nil && liveConcurrency <= 0 {
+		err = errors.New("LIVE_INFER_CONCURRENCY should be positive")
+	}
+	if err != nil {
+		c.logger.Fatal("Invalid live inference concurrency", zap.Error(err))
+	}
+	c.liveInfer = make(chan struct{}, liveConcurrency)
+
+	maxWeights, err := intFromEnv("EXPORT_MAX_WEIGHTS_BYTES", api.DefaultExportMaxWeightsBytes)
+	if err != nil {
+		c.logger.Fatal("Invalid export weights limit", zap.Error(err))
+	}
+	c.exportMaxWeightsBytes = int64(maxWeights)
+
+	c.redisPool = util.GetRedisConnectionPool()
+	fissionClient, _, _, err := crd.MakeFissionClient()
+	if err != nil {
+		c.logger.Warn("Unable to create fission client, the functions will not be checked and bundles will not include the function version",
+			zap.Error(err))
+	} else {
+		functions := fissionClient.CoreV1().Functions(ps.FunctionNamespace)
+		c.functions, err = makeFunctionCache(c.logger, functions)
+		if err != nil {
+			c.logger.Fatal("Invalid function cache configuration", zap.Error(err))
+		}
+		go c.watchFunctions(functions)
+	}
 
+	deployment.Report(c.logger, "controller")
 	c.Serve(port)
 
 }
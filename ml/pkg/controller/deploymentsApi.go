@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// listDeployments returns the components that reported their deployment,
+// marking as stale the ones that stopped sending heartbeats
+func (c *Controller) listDeployments(w http.ResponseWriter, r *http.Request) {
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection("deployments")
+	opts := options.Find().SetSort(bson.D{{"component", 1}, {"host", 1}})
+	cursor, err := collection.Find(context.TODO(), bson.M{}, opts)
+	if err != nil {
+		c.logger.Error("Could not list deployments", zap.Error(err))
+		http.Error(w, "Could not list deployments", http.StatusInternalServerError)
+		return
+	}
+
+	deployments := make([]api.DeploymentInfo, 0)
+	if err = cursor.All(context.TODO(), &deployments); err != nil {
+		c.logger.Error("Could not decode deployments", zap.Error(err))
+		http.Error(w, "error processing request", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	for i := range deployments {
+		deployments[i].Stale = deployments[i].IsStale(now)
+	}
+
+	resp, err := json.Marshal(deployments)
+	if err != nil {
+		c.logger.Error("Could not marshal deployments", zap.Error(err))
+		http.Error(w, "error processing request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestListDeployments(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("stale heartbeats", func(mt *mtest.T) {
+		// the scheduler missed its heartbeats, the stale flag stored is ignored
+		now := time.Now()
+		deployment := func(id string, heartbeat time.Time) bson.D {
+			return bson.D{{Key: "_id", Value: id}, {Key: "heartbeat", Value: heartbeat}, {Key: "stale", Value: true}}
+		}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "kubeml.deployments", mtest.FirstBatch,
+			deployment("controller/a", now.Add(-time.Minute)),
+			deployment("ps/a", now.Add(-api.DeploymentStaleAfter+time.Second)),
+			deployment("scheduler/a", now.Add(-api.DeploymentStaleAfter-time.Second))))
+
+		c := &Controller{logger: zap.NewNop(), mongoClient: mt.Client}
+		w := httptest.NewRecorder()
+		c.listDeployments(w, httptest.NewRequest(http.MethodGet, "/deployments", nil))
+		if w.Code != http.StatusOK {
+			mt.Fatalf("got status %d, want 200", w.Code)
+		}
+
+		var deployments []api.DeploymentInfo
+		if err := json.Unmarshal(w.Body.Bytes(), &deployments); err != nil {
+			mt.Fatal(err)
+		}
+		want := map[string]bool{"controller/a": false, "ps/a": false, "scheduler/a": true}
+		if len(deployments) != len(want) {
+			mt.Fatalf("got deployments %+v, want %d", deployments, len(want))
+		}
+		for _, d := range deployments {
+			if d.Stale != want[d.Id] {
+				mt.Errorf("got %s stale %v, want %v", d.Id, d.Stale, want[d.Id])
+			}
+		}
+	})
+
+	mt.Run("no deployments", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "kubeml.deployments", mtest.FirstBatch))
+		c := &Controller{logger: zap.NewNop(), mongoClient: mt.Client}
+		w := httptest.NewRecorder()
+		c.listDeployments(w, httptest.NewRequest(http.MethodGet, "/deployments", nil))
+		if w.Code != http.StatusOK || w.Body.String() != "[]" {
+			mt.Errorf("got status %d and body %s, want 200 and an empty list", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,471 @@
+package controller
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/RedisAI/redisai-go/redisai"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/model"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bytes taken by each element of the tensor types of RedisAI
+var dtypeSizes = map[string]int64{
+	redisai.TypeFloat:  4,
+	redisai.TypeDouble: 8,
+	redisai.TypeInt8:   1,
+	redisai.TypeInt16:  2,
+	redisai.TypeInt32:  4,
+	redisai.TypeInt64:  8,
+	redisai.TypeUint8:  1,
+	redisai.TypeUint16: 2,
+}
+
+type (
+	// bundleWriter writes the files of a bundle to a gzipped tarball,
+	// keeping the hashes of the files for the manifest
+	bundleWriter struct {
+		gz       *gzip.Writer
+		tw       *tar.Writer
+		manifest api.ExportManifest
+	}
+
+	// blobReader opens the values of a tensor of the model given its key,
+	// the caller closes the reader once it is done with the tensor
+	blobReader func(key string) (io.ReadCloser, error)
+
+	// exportDataset is the information about the dataset of the job saved in the bundle.
+	// The storage does not keep revisions of the datasets, so the size of the
+	// splits is used to tell different uploads apart
+	exportDataset struct {
+		Name           string `json:"name"`
+		TrainDocuments int64  `json:"train_documents"`
+		TestDocuments  int64  `json:"test_documents"`
+	}
+)
+
+func newBundleWriter(w io.Writer, jobId string) *bundleWriter {
+	gz := gzip.NewWriter(w)
+	return &bundleWriter{
+		gz: gz,
+		tw: tar.NewWriter(gz),
+		manifest: api.ExportManifest{
+			JobId:   jobId,
+			Created: time.Now(),
+		},
+	}
+}
+
+// writeFile adds a file to the tarball and to the manifest
+func (b *bundleWriter) writeFile(name string, data []byte) error {
+	_, err := b.writeStream(name, int64(len(data)), bytes.NewReader(data))
+	return err
+}
+
+// writeStream adds a file of a known size to the tarball and to the manifest,
+// hashing it as it is copied so it is never held in memory. It returns the
+// checksum of the file
+func (b *bundleWriter) writeStream(name string, size int64, r io.Reader) (string, error) {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: b.manifest.Created,
+	}
+	if err := b.tw.WriteHeader(header); err != nil {
+		return "", errors.Wrapf(err, "could not write header of %s", name)
+	}
+
+	h := sha256.New()
+	written, err := io.Copy(b.tw, io.TeeReader(r, h))
+	if err != nil {
+		return "", errors.Wrapf(err, "could not write %s", name)
+	}
+	if written != size {
+		return "", fmt.Errorf("could not write %s, got %d bytes but want %d", name, written, size)
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	b.manifest.Files = append(b.manifest.Files, api.ExportFile{
+		Name:   name,
+		Size:   size,
+		SHA256: sum,
+	})
+	return sum, nil
+}
+
+// writeJSON adds a file with the indented JSON encoding of v
+func (b *bundleWriter) writeJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "could not encode %s", name)
+	}
+	return b.writeFile(name, data)
+}
+
+// writeWeights streams the tensors of the model into the bundle if the weights are
+// included, or only hashes them if not, followed by the index of the weights
+// with their checksums
+func (b *bundleWriter) writeWeights(readBlob blobReader, weights *api.ExportWeights) error {
+	for i := range weights.Tensors {
+		if err := b.writeTensor(readBlob, &weights.Tensors[i], weights.Included); err != nil {
+			return err
+		}
+	}
+	return b.writeJSON("weights.json", weights)
+}
+
+// writeTensor sets the checksum of a tensor, and its file if it is included
+func (b *bundleWriter) writeTensor(readBlob blobReader, t *api.ExportTensor, include bool) error {
+	size, err := tensorSize(t.Dtype, t.Shape)
+	if err != nil {
+		return errors.Wrapf(err, "could not get size of tensor %s", t.Key)
+	}
+
+	r, err := readBlob(t.Key)
+	if err != nil {
+		return errors.Wrapf(err, "could not get tensor %s", t.Key)
+	}
+	defer r.Close()
+
+	if !include {
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return errors.Wrapf(err, "could not read tensor %s", t.Key)
+		}
+		t.SHA256 = hex.EncodeToString(h.Sum(nil))
+		return nil
+	}
+
+	t.File = "weights/" + t.Layer
+	t.SHA256, err = b.writeStream(t.File, size, r)
+	return err
+}
+
+// close writes the manifest as the last file of the bundle and flushes the archive
+func (b *bundleWriter) close() error {
+	data, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode manifest")
+	}
+
+	header := &tar.Header{
+		Name:    api.ExportManifestFile,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: b.manifest.Created,
+	}
+	if err := b.tw.WriteHeader(header); err != nil {
+		return errors.Wrap(err, "could not write header of manifest")
+	}
+	if _, err := b.tw.Write(data); err != nil {
+		return errors.Wrap(err, "could not write manifest")
+	}
+
+	if err := b.tw.Close(); err != nil {
+		return errors.Wrap(err, "could not close tarball")
+	}
+	return b.gz.Close()
+}
+
+// exportBundle streams a gzipped tarball with everything needed to audit a finished job: the
+// history, the original request, the effective configuration, the weights of the model, the
+// function and dataset used and the scheduling decisions if the job was traced.
+//
+// The tensors are streamed one at a time so the model is never held in memory.
+// Weights bigger than the configured limit are replaced by the checksums of the tensors
+// unless the includeWeights query parameter is set. The weights are read from the last
+// checkpoint in object storage if the fromCheckpoint query parameter is set, or if
+// the job was checkpointed and its model is no longer in redis
+func (c *Controller) exportBundle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskId := vars["taskId"]
+	includeWeights, _ := strconv.ParseBool(r.URL.Query().Get("includeWeights"))
+	fromCheckpoint, _ := strconv.ParseBool(r.URL.Query().Get("fromCheckpoint"))
+
+	c.logger.Debug("Exporting bundle", zap.String("taskId", taskId))
+
+	var history api.History
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	err := collection.FindOne(context.TODO(), bson.M{"_id": taskId}).Decode(&history)
+	if err != nil {
+		c.logger.Error("Could not find history", zap.Error(err))
+		http.Error(w, "Could not find history for request", http.StatusNotFound)
+		return
+	}
+	history.Migrate()
+
+	redisClient := util.GetRedisAIClient(c.redisPool, false)
+	defer redisClient.Close()
+
+	var weights *api.ExportWeights
+	var readBlob blobReader
+	if !fromCheckpoint {
+		weights, err = c.modelWeights(redisClient, taskId)
+		if err != nil {
+			c.logger.Error("Could not list the weights of the model", zap.Error(err))
+			http.Error(w, "Could not list the weights of the model", http.StatusInternalServerError)
+			return
+		}
+		readBlob = redisBlobs(redisClient)
+	}
+
+	checkpointed := api.IsObjectStorage(history.Task.Options.CheckpointBackend) && history.Data.CheckpointEpoch > 0
+	if fromCheckpoint || (len(weights.Tensors) == 0 && checkpointed) {
+		if !checkpointed {
+			http.Error(w, "The job has no checkpoints in object storage", http.StatusNotFound)
+			return
+		}
+
+		weights, readBlob, err = c.checkpointWeights(&history, 0)
+		if err != nil {
+			c.logger.Error("Could not read the checkpoint of the model", zap.Error(err))
+			http.Error(w, "Could not read the checkpoint of the model", http.StatusInternalServerError)
+			return
+		}
+	}
+	weights.Included = includeWeights || c.exportMaxWeightsBytes <= 0 || weights.Size <= c.exportMaxWeightsBytes
+
+	// once the response is started errors can't be reported with the status code,
+	// so the connection is aborted leaving a bundle without manifest
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.tar.gz", taskId))
+	w.WriteHeader(http.StatusOK)
+
+	if err := c.writeBundle(newBundleWriter(w, taskId), readBlob, &history, weights); err != nil {
+		c.logger.Error("Could not export bundle",
+			zap.String("taskId", taskId),
+			zap.Error(err))
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// writeBundle writes all the files of the bundle of a job
+func (c *Controller) writeBundle(b *bundleWriter, readBlob blobReader, history *api.History, weights *api.ExportWeights) error {
+	if err := b.writeJSON("history.json", history); err != nil {
+		return err
+	}
+	if err := b.writeJSON("request.json", history.Task); err != nil {
+		return err
+	}
+	if err := b.writeJSON("config.json", effectiveConfig(history.Task)); err != nil {
+		return err
+	}
+	if err := b.writeJSON("dataset.json", c.exportDataset(history.Task.Dataset)); err != nil {
+		return err
+	}
+
+	if fn, err := c.exportFunction(history.Task.FunctionName); err != nil {
+		c.logger.Warn("Could not get the function version, omitting it from the bundle",
+			zap.String("function", history.Task.FunctionName),
+			zap.Error(err))
+	} else if err := b.writeJSON("function.json", fn); err != nil {
+		return err
+	}
+
+	// the decisions are only available if the job was traced
+	// and it is still among the traces kept by the scheduler
+	if history.Task.Options.TraceScheduler {
+		if trace, err := c.scheduler.GetTrace(history.Id); err != nil {
+			c.logger.Warn("Could not get the scheduler trace, omitting it from the bundle",
+				zap.String("taskId", history.Id),
+				zap.Error(err))
+		} else if err := b.writeFile("events.json", trace); err != nil {
+			return err
+		}
+	}
+
+	// the index of the weights is written after the tensors so it has their checksums
+	if err := b.writeWeights(readBlob, weights); err != nil {
+		return err
+	}
+
+	return b.close()
+}
+
+// modelWeights lists the tensors of the reference model of a job and their size.
+// The tensors of the functions, suffixed with the function id, are skipped
+func (c *Controller) modelWeights(redisClient *redisai.Client, jobId string) (*api.ExportWeights, error) {
+	prefix := jobId + ":"
+	keys, err := redis.Strings(redisClient.DoOrSend("KEYS", redis.Args{prefix + "*"}, nil))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list tensors")
+	}
+	sort.Strings(keys)
+
+	weights := &api.ExportWeights{
+		Storage: fmt.Sprintf("redis://%s:%d", api.RedisUrl, api.RedisPort),
+	}
+	for _, key := range keys {
+		if strings.Contains(key, "/") {
+			continue
+		}
+
+		dtype, shape, err := redisClient.TensorGetMeta(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get metadata of tensor %s", key)
+		}
+
+		size, err := tensorSize(dtype, shape)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get size of tensor %s", key)
+		}
+
+		weights.Size += size
+		weights.Tensors = append(weights.Tensors, api.ExportTensor{
+			Layer: strings.TrimPrefix(key, prefix),
+			Key:   key,
+			Dtype: dtype,
+			Shape: shape,
+		})
+	}
+
+	return weights, nil
+}
+
+// checkpointWeights lists the tensors of the checkpoint of an epoch of a job in object
+// storage, or of its last checkpoint if epoch is 0, and returns the function to download them
+func (c *Controller) checkpointWeights(history *api.History, epoch int) (*api.ExportWeights, blobReader, error) {
+	store, err := model.NewObjectStore(history.Task.Options.CheckpointBackend)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var manifest *api.CheckpointManifest
+	if epoch == 0 {
+		manifest, err = model.LatestCheckpoint(store, history.Id)
+	} else {
+		manifest, err = model.EpochCheckpoint(store, history.Id, epoch)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	weights := &api.ExportWeights{
+		Storage: manifest.Storage,
+		Size:    manifest.Size,
+	}
+	checksums := make(map[string]api.ExportTensor, len(manifest.Tensors))
+	for _, t := range manifest.Tensors {
+		checksums[t.Key] = t
+		weights.Tensors = append(weights.Tensors, api.ExportTensor{
+			Layer: t.Layer,
+			Key:   t.Key,
+			Dtype: t.Dtype,
+			Shape: t.Shape,
+		})
+	}
+
+	// the tensors are checked against the checksums of the manifest as they are read
+	return weights, func(key string) (io.ReadCloser, error) {
+		return model.OpenCheckpointTensor(store, checksums[key])
+	}, nil
+}
+
+// redisBlobs returns the function to read the tensors of the models in redis.
+// RedisAI replies with the whole tensor, so only one is in memory at a time
+func redisBlobs(redisClient *redisai.Client) blobReader {
+	return func(key string) (io.ReadCloser, error) {
+		_, _, blob, err := redisClient.TensorGetBlob(key)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(blob)), nil
+	}
+}
+
+// readTensor reads all the values of a tensor
+func readTensor(readBlob blobReader, key string) ([]byte, error) {
+	r, err := readBlob(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// tensorSize returns the bytes taken by a tensor of a type and shape
+func tensorSize(dtype string, shape []int64) (int64, error) {
+	size, exists := dtypeSizes[dtype]
+	if !exists {
+		return 0, fmt.Errorf("unknown type %s", dtype)
+	}
+	for _, dim := range shape {
+		size *= dim
+	}
+	return size, nil
+}
+
+// exportDataset returns the size of the splits of a dataset,
+// which are left at 0 if the dataset can't be read
+func (c *Controller) exportDataset(name string) exportDataset {
+	dataset := exportDataset{Name: name}
+
+	db := c.mongoClient.Database(name)
+	count, err := db.Collection(CollectionTrain).EstimatedDocumentCount(context.Background(), nil)
+	if err != nil {
+		c.logger.Warn("error counting documents of collection",
+			zap.String("dataset", name),
+			zap.String("collection", CollectionTrain))
+	}
+	dataset.TrainDocuments = count
+
+	count, err = db.Collection(CollectionTest).EstimatedDocumentCount(context.Background(), nil)
+	if err != nil {
+		c.logger.Warn("error counting documents of collection",
+			zap.String("dataset", name),
+			zap.String("collection", CollectionTest))
+	}
+	dataset.TestDocuments = count
+
+	return dataset
+}
+
+// exportFunction returns the current version of the function and its package
+func (c *Controller) exportFunction(name string) (*api.ExportFunction, error) {
+	if c.functions == nil {
+		return nil, errors.New("fission client not available")
+	}
+
+	fn, err := c.functions.get(name)
+	if err != nil {
+		return nil, err
+	}
+	if fn == nil {
+		return nil, fmt.Errorf("function %s does not exist", name)
+	}
+
+	// the metadata is shared with the cache
+	export := *fn
+	return &export, nil
+}
+
+// effectiveConfig returns the request of a job with the defaults
+// applied to the options that were not set by the user
+func effectiveConfig(req api.TrainRequest) api.TrainRequest {
+	opts := &req.Options
+	if len(opts.Serialization) == 0 {
+		opts.Serialization = api.SerializationJSON
+	}
+	opts.AccuracyDecimals = opts.MetricDecimals(api.MetricAccuracy)
+	opts.LossDecimals = opts.MetricDecimals(api.MetricValidationLoss)
+	return req
+}
@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/RedisAI/redisai-go/redisai"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// bundleEntry is a file read back from a bundle
+type bundleEntry struct {
+	name string
+	data []byte
+}
+
+// readBundle returns the files of a bundle in the order they were written
+func readBundle(t *testing.T, data []byte) []bundleEntry {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []bundleEntry
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, bundleEntry{header.Name, data})
+	}
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkManifest fails if the manifest, the last file of the bundle,
+// does not list every other file with its size and checksum
+func checkManifest(t *testing.T, entries []bundleEntry) api.ExportManifest {
+	t.Helper()
+	last := entries[len(entries)-1]
+	if last.name != api.ExportManifestFile {
+		t.Fatalf("got %s as the last file, want the manifest", last.name)
+	}
+	var manifest api.ExportManifest
+	if err := json.Unmarshal(last.data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	files := entries[:len(entries)-1]
+	if len(manifest.Files) != len(files) {
+		t.Fatalf("got %d files in the manifest, want %d", len(manifest.Files), len(files))
+	}
+	for i, f := range files {
+		want := api.ExportFile{Name: f.name, Size: int64(len(f.data)), SHA256: checksum(f.data)}
+		if manifest.Files[i] != want {
+			t.Errorf("got manifest entry %+v, want %+v", manifest.Files[i], want)
+		}
+	}
+	return manifest
+}
+
+// memoryBlobs reads the tensors from a map, counting the readers left open
+type memoryBlobs struct {
+	blobs map[string][]byte
+	open  int
+}
+
+type countedReader struct {
+	io.Reader
+	blobs *memoryBlobs
+}
+
+func (r *countedReader) Close() error {
+	r.blobs.open--
+	return nil
+}
+
+func (m *memoryBlobs) read(key string) (io.ReadCloser, error) {
+	blob, exists := m.blobs[key]
+	if !exists {
+		return nil, errors.New("tensor not found")
+	}
+	m.open++
+	return &countedReader{bytes.NewReader(blob), m}, nil
+}
+
+func TestBundleWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	b := newBundleWriter(buf, "job")
+
+	if err := b.writeJSON("history.json", map[string]string{"id": "job"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.writeFile("events.json", []byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+	streamed := bytes.Repeat([]byte{1, 2, 3, 4}, 1<<14)
+	sum, err := b.writeStream("weights/fc.weight", int64(len(streamed)), bytes.NewReader(streamed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != checksum(streamed) {
+		t.Errorf("got checksum %s, want %s", sum, checksum(streamed))
+	}
+	if err := b.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readBundle(t, buf.Bytes())
+	manifest := checkManifest(t, entries)
+	if manifest.JobId != "job" {
+		t.Errorf("got job %s in the manifest, want job", manifest.JobId)
+	}
+	if !bytes.Equal(entries[2].data, streamed) {
+		t.Error("got the streamed file changed in the bundle")
+	}
+}
+
+func TestBundleWriterSizeMismatch(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+	}{
+		{"shorter than its size", 8},
+		{"longer than its size", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBundleWriter(ioutil.Discard, "job")
+			if _, err := b.writeStream("weights/fc.weight", tt.size, strings.NewReader("1234")); err == nil {
+				t.Fatal("got no error, want the size mismatch refused")
+			}
+			if len(b.manifest.Files) != 0 {
+				t.Errorf("got files %+v in the manifest, want none", b.manifest.Files)
+			}
+		})
+	}
+}
+
+func TestWriteWeights(t *testing.T) {
+	blobs := map[string][]byte{
+		"job:fc.weight": {0, 0, 128, 63, 0, 0, 0, 64},
+		"job:fc.bias":   {0, 0, 64, 64},
+	}
+	newWeights := func(included bool) *api.ExportWeights {
+		return &api.ExportWeights{
+			Included: included,
+			Tensors: []api.ExportTensor{
+				{Layer: "fc.weight", Key: "job:fc.weight", Dtype: redisai.TypeFloat32, Shape: []int64{2}},
+				{Layer: "fc.bias", Key: "job:fc.bias", Dtype: redisai.TypeFloat32, Shape: []int64{1}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		included  bool
+		wantFiles []string
+	}{
+		{"included", true, []string{"weights/fc.weight", "weights/fc.bias", "weights.json"}},
+		{"checksums only", false, []string{"weights.json"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := &memoryBlobs{blobs: blobs}
+			weights := newWeights(tt.included)
+			buf := new(bytes.Buffer)
+			b := newBundleWriter(buf, "job")
+			if err := b.writeWeights(reader.read, weights); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.close(); err != nil {
+				t.Fatal(err)
+			}
+			if reader.open != 0 {
+				t.Errorf("got %d tensors left open", reader.open)
+			}
+
+			entries := readBundle(t, buf.Bytes())
+			checkManifest(t, entries)
+			var names []string
+			for _, e := range entries[:len(entries)-1] {
+				names = append(names, e.name)
+			}
+			if !reflect.DeepEqual(names, tt.wantFiles) {
+				t.Fatalf("got files %v, want %v", names, tt.wantFiles)
+			}
+
+			// the index has the checksums of the tensors whether or not they are included
+			var index api.ExportWeights
+			if err := json.Unmarshal(entries[len(entries)-2].data, &index); err != nil {
+				t.Fatal(err)
+			}
+			for i, tensor := range index.Tensors {
+				if tensor.SHA256 != checksum(blobs[tensor.Key]) {
+					t.Errorf("got checksum %s of %s, want the one of its values", tensor.SHA256, tensor.Key)
+				}
+				if tt.included && (tensor.File != tt.wantFiles[i] || !bytes.Equal(entries[i].data, blobs[tensor.Key])) {
+					t.Errorf("got tensor %s in file %s, want it in %s", tensor.Key, tensor.File, tt.wantFiles[i])
+				}
+				if !tt.included && tensor.File != "" {
+					t.Errorf("got file %s for tensor %s, want none", tensor.File, tensor.Key)
+				}
+			}
+		})
+	}
+}
+
+// failingTensor returns its values followed by an error, as the
+// reader of a checkpoint tensor that does not match its checksum
+type failingTensor struct {
+	io.Reader
+}
+
+func (f *failingTensor) Read(p []byte) (int, error) {
+	n, err := f.Reader.Read(p)
+	if err == io.EOF {
+		return n, errors.New("checksum does not match the manifest")
+	}
+	return n, err
+}
+
+func (f *failingTensor) Close() error {
+	return nil
+}
+
+func TestWriteWeightsFails(t *testing.T) {
+	tests := []struct {
+		name     string
+		dtype    string
+		read     blobReader
+		included bool
+	}{
+		{
+			name:  "unknown type",
+			dtype: "COMPLEX",
+			read:  (&memoryBlobs{blobs: map[string][]byte{"job:fc": {0, 0, 0, 0}}}).read,
+		},
+		{
+			name:  "missing tensor",
+			dtype: redisai.TypeFloat32,
+			read:  (&memoryBlobs{}).read,
+		},
+		{
+			name:     "short tensor",
+			dtype:    redisai.TypeFloat32,
+			read:     (&memoryBlobs{blobs: map[string][]byte{"job:fc": {0, 0}}}).read,
+			included: true,
+		},
+		{
+			name:  "corrupt checkpoint tensor",
+			dtype: redisai.TypeFloat32,
+			read: func(key string) (io.ReadCloser, error) {
+				return &failingTensor{bytes.NewReader([]byte{0, 0, 0, 0})}, nil
+			},
+		},
+		{
+			name:  "corrupt checkpoint tensor included",
+			dtype: redisai.TypeFloat32,
+			read: func(key string) (io.ReadCloser, error) {
+				return &failingTensor{bytes.NewReader([]byte{0, 0, 0, 0})}, nil
+			},
+			included: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weights := &api.ExportWeights{
+				Included: tt.included,
+				Tensors:  []api.ExportTensor{{Layer: "fc", Key: "job:fc", Dtype: tt.dtype, Shape: []int64{1}}},
+			}
+			b := newBundleWriter(ioutil.Discard, "job")
+			if err := b.writeWeights(tt.read, weights); err == nil {
+				t.Fatal("got no error, want the tensor refused")
+			}
+			if len(b.manifest.Files) != 0 {
+				t.Errorf("got files %+v in the manifest, want none", b.manifest.Files)
+			}
+		})
+	}
+}
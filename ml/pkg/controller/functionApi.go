@@ -0,0 +1,20 @@
+package controller
+
+import (
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// invalidateFunction removes a function from the function cache, called by
+// the CLI after creating, updating or deleting the function
+func (c *Controller) invalidateFunction(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if c.functions != nil {
+		c.functions.invalidate(name)
+	}
+
+	c.logger.Debug("Invalidated function", zap.String("function", name))
+	w.WriteHeader(http.StatusOK)
+}
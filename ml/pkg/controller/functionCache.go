@@ -0,0 +1,227 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFunctionCacheTTL = time.Minute
+
+	// functionWatchBackoff is the time waited before watching
+	// the functions again after the watch is closed
+	functionWatchBackoff = 5 * time.Second
+)
+
+var (
+	functionCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubeml",
+		Subsystem: "function_cache",
+		Name:      "hits_total",
+		Help:      "Number of function lookups answered from the cache",
+	})
+
+	functionCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubeml",
+		Subsystem: "function_cache",
+		Name:      "misses_total",
+		Help:      "Number of function lookups sent to the kubernetes API",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(functionCacheHits, functionCacheMisses)
+}
+
+type (
+	// functionCache keeps the metadata of the fission functions for a TTL, so the
+	// train requests and exports do not query the kubernetes API every time. Only
+	// the functions that exist are cached. The entries are invalidated by the function
+	// commands of the CLI and by a watch on the functions if the controller can start
+	// one. If the API can not be reached the last known metadata of a function is
+	// used even if it expired. A TTL of 0 disables the cache
+	functionCache struct {
+		logger *zap.Logger
+		ttl    time.Duration
+
+		// lookup gets the metadata of a function from the API,
+		// returning nil if the function does not exist
+		lookup func(name string) (*api.ExportFunction, error)
+
+		mu      sync.Mutex
+		entries map[string]functionEntry
+	}
+
+	// functionEntry is the metadata of a function
+	// along with the time it expires
+	functionEntry struct {
+		fn      *api.ExportFunction
+		expires time.Time
+	}
+
+	// fissionFunctions is the part of the fission client used by the cache
+	fissionFunctions interface {
+		Get(name string, options metav1.GetOptions) (*fv1.Function, error)
+		Watch(opts metav1.ListOptions) (watch.Interface, error)
+	}
+)
+
+// makeFunctionCache creates the cache of the functions found with the fission client,
+// with the TTL set in FUNCTION_CACHE_TTL
+func makeFunctionCache(logger *zap.Logger, client fissionFunctions) (*functionCache, error) {
+	ttl := defaultFunctionCacheTTL
+	if s := os.Getenv("FUNCTION_CACHE_TTL"); len(s) > 0 {
+		var err error
+		ttl, err = time.ParseDuration(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid FUNCTION_CACHE_TTL")
+		}
+	}
+	if ttl < 0 {
+		return nil, errors.Errorf("FUNCTION_CACHE_TTL should not be negative, got %v", ttl)
+	}
+
+	lookup := func(name string) (*api.ExportFunction, error) {
+		fn, err := client.Get(name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get function")
+		}
+		return functionMetadata(fn), nil
+	}
+	return newFunctionCache(logger, ttl, lookup), nil
+}
+
+// newFunctionCache creates a cache that looks up the functions with the given function
+func newFunctionCache(logger *zap.Logger, ttl time.Duration, lookup func(string) (*api.ExportFunction, error)) *functionCache {
+	return &functionCache{
+		logger:  logger.Named("functions"),
+		ttl:     ttl,
+		lookup:  lookup,
+		entries: make(map[string]functionEntry),
+	}
+}
+
+// functionMetadata returns the version of the function and its package
+func functionMetadata(fn *fv1.Function) *api.ExportFunction {
+	return &api.ExportFunction{
+		Name:                   fn.Name,
+		ResourceVersion:        fn.ResourceVersion,
+		Environment:            fn.Spec.Environment.Name,
+		Package:                fn.Spec.Package.PackageRef.Name,
+		PackageResourceVersion: fn.Spec.Package.PackageRef.ResourceVersion,
+	}
+}
+
+// get returns the metadata of the function, nil if it does not exist
+func (c *functionCache) get(name string) (*api.ExportFunction, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, cached := c.entries[name]
+	c.mu.Unlock()
+
+	if cached && now.Before(entry.expires) {
+		functionCacheHits.Inc()
+		return entry.fn, nil
+	}
+
+	functionCacheMisses.Inc()
+	fn, err := c.lookup(name)
+	if err != nil {
+		if cached {
+			c.logger.Warn("Could not look up function, using the expired metadata",
+				zap.String("function", name),
+				zap.Error(err))
+			return entry.fn, nil
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fn == nil || c.ttl == 0 {
+		delete(c.entries, name)
+	} else {
+		c.entries[name] = functionEntry{fn: fn, expires: now.Add(c.ttl)}
+	}
+	return fn, nil
+}
+
+// invalidate removes the metadata of a function
+func (c *functionCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, name)
+}
+
+// clear removes the metadata of all the functions
+func (c *functionCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]functionEntry)
+}
+
+// watch invalidates the functions changed while the watch is open
+func (c *functionCache) watch(w watch.Interface) {
+	defer w.Stop()
+	for event := range w.ResultChan() {
+		if fn, ok := event.Object.(*fv1.Function); ok {
+			c.logger.Debug("Function changed",
+				zap.String("function", fn.Name),
+				zap.String("event", string(event.Type)))
+			c.invalidate(fn.Name)
+		}
+	}
+}
+
+// watchFunctions keeps a watch on the functions open to invalidate the cache. The
+// server closes the watch after a while, so it is opened again, clearing the cache
+// since the changes in between are missed. If the watch can not be opened, for
+// example without the permissions, the cache relies on the TTL alone
+func (c *Controller) watchFunctions(client fissionFunctions) {
+	for {
+		w, err := client.Watch(metav1.ListOptions{})
+		if err != nil {
+			c.logger.Warn("Could not watch the functions, the function cache relies on its TTL",
+				zap.Error(err))
+			return
+		}
+
+		c.functions.watch(w)
+		c.functions.clear()
+		time.Sleep(functionWatchBackoff)
+	}
+}
+
+// checkFunction returns an error if the function does not exist. If the functions
+// can not be looked up the check is skipped, the job fails to start anyway if
+// the function does not exist
+func (c *Controller) checkFunction(name string) error {
+	if c.functions == nil {
+		return nil
+	}
+
+	fn, err := c.functions.get(name)
+	if err != nil {
+		c.logger.Warn("Could not check the function, skipping the check",
+			zap.String("function", name),
+			zap.Error(err))
+		return nil
+	}
+	if fn == nil {
+		return fmt.Errorf("function \"%s\" does not exist", name)
+	}
+	return nil
+}
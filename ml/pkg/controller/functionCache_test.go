@@ -0,0 +1,237 @@
+package controller
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFunctions are the functions of fission, answering the gets with err if set
+type fakeFunctions struct {
+	mu        sync.Mutex
+	functions map[string]*fv1.Function
+	err       error
+	gets      int
+	watcher   *watch.FakeWatcher
+}
+
+func newFakeFunctions(names ...string) *fakeFunctions {
+	f := &fakeFunctions{functions: make(map[string]*fv1.Function), watcher: watch.NewFake()}
+	for _, name := range names {
+		f.set(name, "1")
+	}
+	return f
+}
+
+// set creates or updates the function with the resource version
+func (f *fakeFunctions) set(name, version string) *fv1.Function {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: name, ResourceVersion: version}}
+	fn.Spec.Environment.Name = "torch"
+	f.functions[name] = fn
+	return fn
+}
+
+func (f *fakeFunctions) Get(name string, _ metav1.GetOptions) (*fv1.Function, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gets++
+	if f.err != nil {
+		return nil, f.err
+	}
+	fn, exists := f.functions[name]
+	if !exists {
+		return nil, k8serrors.NewNotFound(schema.GroupResource{Group: "fission.io", Resource: "functions"}, name)
+	}
+	return fn, nil
+}
+
+func (f *fakeFunctions) Watch(_ metav1.ListOptions) (watch.Interface, error) {
+	return f.watcher, nil
+}
+
+// lookups returns the number of functions looked up in the API
+func (f *fakeFunctions) lookups() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.gets
+}
+
+// version returns the resource version of the function in the cache, empty if it does not exist
+func version(t *testing.T, c *functionCache, name string) string {
+	t.Helper()
+	fn, err := c.get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fn == nil {
+		return ""
+	}
+	return fn.ResourceVersion
+}
+
+func TestFunctionCacheHits(t *testing.T) {
+	functions := newFakeFunctions("lenet")
+	c, err := makeFunctionCache(zap.NewNop(), functions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits, misses := testutil.ToFloat64(functionCacheHits), testutil.ToFloat64(functionCacheMisses)
+
+	for i := 0; i < 3; i++ {
+		if v := version(t, c, "lenet"); v != "1" {
+			t.Errorf("got version %q, want 1", v)
+		}
+	}
+	if n := functions.lookups(); n != 1 {
+		t.Errorf("got %d lookups, want the function looked up once", n)
+	}
+	if h, m := testutil.ToFloat64(functionCacheHits)-hits, testutil.ToFloat64(functionCacheMisses)-misses; h != 2 || m != 1 {
+		t.Errorf("got %v hits and %v misses, want 2 and 1", h, m)
+	}
+
+	// the functions that do not exist are not cached, so they are seen once created
+	if v := version(t, c, "resnet"); v != "" {
+		t.Errorf("got version %q of a missing function, want none", v)
+	}
+	functions.set("resnet", "5")
+	if v := version(t, c, "resnet"); v != "5" {
+		t.Errorf("got version %q of the function created, want 5", v)
+	}
+}
+
+func TestFunctionCacheExpiry(t *testing.T) {
+	functions := newFakeFunctions("lenet")
+	lookup := func(name string) (*api.ExportFunction, error) {
+		fn, err := functions.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return functionMetadata(fn), nil
+	}
+	c := newFunctionCache(zap.NewNop(), 20*time.Millisecond, lookup)
+
+	version(t, c, "lenet")
+	functions.set("lenet", "2")
+	if v := version(t, c, "lenet"); v != "1" {
+		t.Errorf("got version %q before the entry expired, want the cached 1", v)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if v := version(t, c, "lenet"); v != "2" {
+		t.Errorf("got version %q after the entry expired, want 2", v)
+	}
+
+	// the expired metadata is used while the API can't be reached
+	time.Sleep(30 * time.Millisecond)
+	functions.err = errors.New("connection refused")
+	if v := version(t, c, "lenet"); v != "2" {
+		t.Errorf("got version %q with the API down, want the expired 2", v)
+	}
+	if _, err := c.get("resnet"); err == nil {
+		t.Error("got no error for a function never cached with the API down")
+	}
+}
+
+func TestFunctionCacheDisabled(t *testing.T) {
+	t.Setenv("FUNCTION_CACHE_TTL", "0")
+	functions := newFakeFunctions("lenet")
+	c, err := makeFunctionCache(zap.NewNop(), functions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version(t, c, "lenet")
+	version(t, c, "lenet")
+	if n := functions.lookups(); n != 2 {
+		t.Errorf("got %d lookups, want every lookup sent to the API", n)
+	}
+
+	for _, ttl := range []string{"soon", "-1m"} {
+		t.Setenv("FUNCTION_CACHE_TTL", ttl)
+		if _, err := makeFunctionCache(zap.NewNop(), functions); err == nil {
+			t.Errorf("%s: got no error for an invalid TTL", ttl)
+		}
+	}
+}
+
+func TestFunctionCacheInvalidation(t *testing.T) {
+	functions := newFakeFunctions("lenet")
+	c, err := makeFunctionCache(zap.NewNop(), functions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller := &Controller{logger: zap.NewNop(), functions: c}
+
+	// the CLI invalidates the function after updating it
+	version(t, c, "lenet")
+	functions.set("lenet", "2")
+	r := mux.NewRouter()
+	r.HandleFunc("/functions/{name}/cache", controller.invalidateFunction).Methods("DELETE")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/functions/lenet/cache", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want 200", w.Code)
+	}
+	if v := version(t, c, "lenet"); v != "2" {
+		t.Errorf("got version %q after invalidating it, want 2", v)
+	}
+
+	// the watch invalidates the functions updated with fission
+	done := make(chan struct{})
+	go func() {
+		c.watch(functions.watcher)
+		close(done)
+	}()
+	functions.watcher.Modify(functions.set("lenet", "3"))
+	functions.watcher.Stop()
+	<-done
+	if v := version(t, c, "lenet"); v != "3" {
+		t.Errorf("got version %q after the watch event, want 3", v)
+	}
+
+	// the cache is cleared when the watch is reopened
+	version(t, c, "lenet")
+	functions.set("lenet", "4")
+	c.clear()
+	if v := version(t, c, "lenet"); v != "4" {
+		t.Errorf("got version %q after clearing the cache, want 4", v)
+	}
+}
+
+func TestCheckFunction(t *testing.T) {
+	if err := (&Controller{logger: zap.NewNop()}).checkFunction("lenet"); err != nil {
+		t.Errorf("got error %v without the fission client, want the check skipped", err)
+	}
+
+	functions := newFakeFunctions("lenet")
+	c, err := makeFunctionCache(zap.NewNop(), functions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller := &Controller{logger: zap.NewNop(), functions: c}
+	if err := controller.checkFunction("lenet"); err != nil {
+		t.Error(err)
+	}
+	if err := controller.checkFunction("resnet"); err == nil {
+		t.Error("got no error for a missing function")
+	}
+
+	functions.err = errors.New("connection refused")
+	if err := controller.checkFunction("resnet"); err != nil {
+		t.Errorf("got error %v with the API down, want the check skipped", err)
+	}
+}
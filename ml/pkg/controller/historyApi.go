@@ -3,11 +3,14 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.uber.org/zap"
 	"net/http"
+	"strconv"
 )
 
 // listHistories returns a list of the histories in the database
@@ -16,7 +19,7 @@ func (c *Controller) listHistories(w http.ResponseWriter, r *http.Request) {
 	c.logger.Debug("Listing histories")
 
 	var histories []api.History
-	collection := c.mongoClient.Database("kubeml").Collection("history")
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
 	//opts := options.Find().SetProjection(bson.M{"_id":1, "task":1})
 	cursor, err := collection.Find(context.TODO(), bson.M{})
 	if err != nil {
@@ -32,6 +35,11 @@ func (c *Controller) listHistories(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// fill the validation epochs of the histories saved without them
+	for i := range histories {
+		histories[i].Migrate()
+	}
+
 	resp, err := json.Marshal(histories)
 	if err != nil {
 		c.logger.Error("Could not parse json histories", zap.Error(err))
@@ -54,7 +62,7 @@ func (c *Controller) getHistory(w http.ResponseWriter, r *http.Request) {
 
 	// Use the mongo client to get the history
 	var history api.History
-	collection := c.mongoClient.Database("kubeml").Collection("history")
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
 	err := collection.FindOne(context.TODO(), bson.M{"_id": taskId}).Decode(&history)
 	if err != nil {
 		c.logger.Error("Could not find history",
@@ -62,6 +70,7 @@ func (c *Controller) getHistory(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Could not find history for request", http.StatusNotFound)
 		return
 	}
+	history.Migrate()
 
 	resp, err := json.MarshalIndent(history, "", "  ")
 	if err != nil {
@@ -76,6 +85,89 @@ func (c *Controller) getHistory(w http.ResponseWriter, r *http.Request) {
 	w.Write(resp)
 }
 
+// getAudit gets the record of the data used by a job from mongoDB
+func (c *Controller) getAudit(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskId := vars["taskId"]
+
+	c.logger.Debug("Getting data audit", zap.String("taskId", taskId))
+
+	var audit api.DataAudit
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection("audit")
+	err := collection.FindOne(context.TODO(), bson.M{"_id": taskId}).Decode(&audit)
+	if err != nil {
+		c.logger.Error("Could not find data audit",
+			zap.Error(err))
+		http.Error(w, "Could not find data audit for the job, was it started with --audit-data?", http.StatusNotFound)
+		return
+	}
+
+	resp, err := json.Marshal(audit)
+	if err != nil {
+		c.logger.Error("Could not marshal data audit",
+			zap.Error(err))
+		http.Error(w, "Error marshaling request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// getMetric returns the series of a single metric from the history of a job,
+// so dashboards following a curve do not fetch the whole history. The sinceEpoch
+// parameter returns only the values recorded after that epoch
+func (c *Controller) getMetric(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskId, metric := vars["taskId"], vars["metric"]
+
+	sinceEpoch := 0
+	if s := r.URL.Query().Get("sinceEpoch"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid sinceEpoch \"%s\", expected a non negative epoch", s), http.StatusBadRequest)
+			return
+		}
+		sinceEpoch = n
+	}
+
+	c.logger.Debug("Getting metric",
+		zap.String("taskId", taskId),
+		zap.String("metric", metric),
+		zap.Int("sinceEpoch", sinceEpoch))
+
+	var history api.History
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	err := collection.FindOne(context.TODO(), bson.M{"_id": taskId}).Decode(&history)
+	if err != nil {
+		c.logger.Error("Could not find history",
+			zap.Error(err))
+		http.Error(w, "Could not find history for request", http.StatusNotFound)
+		return
+	}
+	history.Migrate()
+
+	series, err := history.MetricSeries(metric, sinceEpoch)
+	if err != nil {
+		c.logger.Debug("Could not get metric", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	resp, err := json.Marshal(series)
+	if err != nil {
+		c.logger.Error("Could not marshal metric",
+			zap.Error(err))
+		http.Error(w, "Error marshaling request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
 // deleteHistory deletes a training history from the database given its ID
 func (c *Controller) deleteHistory(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -83,7 +175,7 @@ func (c *Controller) deleteHistory(w http.ResponseWriter, r *http.Request) {
 
 	c.logger.Debug("Deleting history", zap.String("taskId", taskId))
 
-	collection := c.mongoClient.Database("kubeml").Collection("history")
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
 	_, err := collection.DeleteOne(context.TODO(), bson.M{"_id": taskId}, nil)
 	if err != nil {
 		c.logger.Error("Could not find history", zap.Error(err))
@@ -99,7 +191,7 @@ func (c *Controller) pruneHistories(w http.ResponseWriter, r *http.Request) {
 
 	c.logger.Debug("Deleting all histories")
 
-	collection := c.mongoClient.Database("kubeml").Collection("history")
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
 	err := collection.Drop(context.TODO())
 	if err != nil {
 		c.logger.Error("Could not delete histories", zap.Error(err))
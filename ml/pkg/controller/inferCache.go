@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheTTL = 10 * time.Minute
+)
+
+var (
+	inferCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubeml",
+		Subsystem: "inference_cache",
+		Name:      "hits_total",
+		Help:      "Number of inference requests answered from the cache",
+	})
+
+	inferCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubeml",
+		Subsystem: "inference_cache",
+		Name:      "misses_total",
+		Help:      "Number of inference requests not found in the cache",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(inferCacheHits, inferCacheMisses)
+}
+
+type (
+
+	// inferCache is an LRU cache of inference results keyed by the model, its
+	// version and the hash of the input data. Entries expire after the TTL and the
+	// least recently used ones are evicted when the cache exceeds its maximum number
+	// of entries or bytes. Only the results of the latest version of a model are
+	// kept, so the entries of a model are dropped once its weights change
+	inferCache struct {
+		maxEntries int
+		maxBytes   int
+		ttl        time.Duration
+
+		mu    sync.Mutex
+		size  int
+		ll    *list.List
+		items map[string]*list.Element
+	}
+
+	// cacheEntry is a cached inference response along with
+	// the content type it was encoded with
+	cacheEntry struct {
+		key         string
+		modelId     string
+		version     string
+		body        []byte
+		contentType string
+		expires     time.Time
+	}
+)
+
+// makeInferCache creates the cache from the configuration in the environment,
+// INFER_CACHE_ENTRIES enables it when set to a positive number. Returns nil
+// if the cache is disabled
+func makeInferCache() (*inferCache, error) {
+	entries, err := intFromEnv("INFER_CACHE_ENTRIES", 0)
+	if err != nil || entries <= 0 {
+		return nil, err
+	}
+
+	maxBytes, err := intFromEnv("INFER_CACHE_BYTES", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := defaultCacheTTL
+	if s := os.Getenv("INFER_CACHE_TTL"); len(s) > 0 {
+		ttl, err = time.ParseDuration(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid INFER_CACHE_TTL")
+		}
+	}
+
+	return newInferCache(entries, maxBytes, ttl), nil
+}
+
+// newInferCache creates a cache with the given limits,
+// a maxBytes of 0 does not limit the size of the cache
+func newInferCache(maxEntries, maxBytes int, ttl time.Duration) *inferCache {
+	return &inferCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// cacheKey returns the key of an inference request, which is the hash of the model
+// id, the version of the model, the output type and the data. The data is encoded back
+// to JSON so requests with the same datapoints produce the same key no matter how they
+// were serialized
+func cacheKey(req *api.InferRequest, version string) (string, error) {
+	data, err := json.Marshal(req.Data)
+	if err != nil {
+		return "", errors.Wrap(err, "could not encode data")
+	}
+
+	h := sha256.New()
+	h.Write([]byte(req.ModelId))
+	h.Write([]byte{0})
+	h.Write([]byte(version))
+	h.Write([]byte{0})
+	h.Write([]byte(req.Output()))
+	h.Write([]byte{0})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// get returns the cached response for the key if present and not expired
+func (c *inferCache) get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.items[key]
+	if !exists {
+		inferCacheMisses.Inc()
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		inferCacheMisses.Inc()
+		return nil, false
+	}
+
+	c.ll.MoveToFront(elem)
+	inferCacheHits.Inc()
+	return entry, true
+}
+
+// add saves the response of a version of the model in the cache, evicting the least
+// recently used entries if the limits are exceeded. The entries of other versions of
+// the model are removed, since they were computed with weights that changed
+func (c *inferCache) add(key, modelId, version string, body []byte, contentType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// responses bigger than the whole cache are not saved
+	if c.maxBytes > 0 && len(body) > c.maxBytes {
+		return
+	}
+
+	if elem, exists := c.items[key]; exists {
+		c.remove(elem)
+	}
+	c.removeModel(modelId, func(e *cacheEntry) bool { return e.version != version })
+
+	entry := &cacheEntry{
+		key:         key,
+		modelId:     modelId,
+		version:     version,
+		body:        body,
+		contentType: contentType,
+		expires:     time.Now().Add(c.ttl),
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	c.size += len(body)
+
+	for c.ll.Len() > c.maxEntries || (c.maxBytes > 0 && c.size > c.maxBytes) {
+		c.remove(c.ll.Back())
+	}
+}
+
+// invalidate removes all the entries of a model
+func (c *inferCache) invalidate(modelId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeModel(modelId, func(*cacheEntry) bool { return true })
+}
+
+// removeModel deletes the entries of a model that match, the lock must be held
+func (c *inferCache) removeModel(modelId string, match func(*cacheEntry) bool) {
+	for elem := c.ll.Front(); elem != nil; {
+		next := elem.Next()
+		if entry := elem.Value.(*cacheEntry); entry.modelId == modelId && match(entry) {
+			c.remove(elem)
+		}
+		elem = next
+	}
+}
+
+// remove deletes an element from the cache, the lock must be held
+func (c *inferCache) remove(elem *list.Element) {
+	entry := c.ll.Remove(elem).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.size -= len(entry.body)
+}
+
+// modelVersion returns the version of the weights of a model, which changes when the
+// job of the model trains more epochs or saves a new checkpoint
+func (c *Controller) modelVersion(modelId string) (string, error) {
+	var history api.History
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	err := collection.FindOne(context.TODO(), bson.M{"_id": modelId},
+		options.FindOne().SetProjection(bson.M{"data.trainloss": 1, "data.checkpointepoch": 1})).Decode(&history)
+	if err != nil {
+		return "", errors.Wrap(err, "could not find the history of the model")
+	}
+	return fmt.Sprintf("%d.%d", history.Data.Epochs(), history.Data.CheckpointEpoch), nil
+}
+
+// intFromEnv reads an integer from the environment, returning
+// the default value if the variable is not set
+func intFromEnv(name string, defaultValue int) (int, error) {
+	s := os.Getenv(name)
+	if len(s) == 0 {
+		return defaultValue, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s", name)
+	}
+	return v, nil
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"testing"
+	"time"
+)
+
+// keys returns the keys of the cache from the most to the least recently used
+func (c *inferCache) keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var keys []string
+	for elem := c.ll.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*cacheEntry).key)
+	}
+	return keys
+}
+
+func checkKeys(t *testing.T, c *inferCache, want ...string) {
+	t.Helper()
+	got := c.keys()
+	if len(got) != len(want) {
+		t.Fatalf("got keys %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got keys %v, want %v", got, want)
+		}
+	}
+}
+
+func TestInferCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newInferCache(2, 0, time.Minute)
+	c.add("a", "model", "1.1", []byte("a"), "application/json")
+	c.add("b", "model", "1.1", []byte("b"), "application/json")
+
+	// using a moves it to the front so b is evicted
+	if _, hit := c.get("a"); !hit {
+		t.Fatal("expected a hit for a")
+	}
+	c.add("c", "model", "1.1", []byte("c"), "application/json")
+	checkKeys(t, c, "c", "a")
+
+	if _, hit := c.get("b"); hit {
+		t.Error("expected b to be evicted")
+	}
+}
+
+func TestInferCacheEvictsOverMaxBytes(t *testing.T) {
+	c := newInferCache(10, 10, time.Minute)
+	c.add("a", "model", "1.1", []byte("aaaa"), "application/json")
+	c.add("b", "model", "1.1", []byte("bbbb"), "application/json")
+	c.add("c", "model", "1.1", []byte("cccc"), "application/json")
+	checkKeys(t, c, "c", "b")
+	if c.size != 8 {
+		t.Errorf("got size %d, want 8", c.size)
+	}
+
+	// a response bigger than the whole cache is not saved
+	c.add("d", "model", "1.1", make([]byte, 11), "application/json")
+	checkKeys(t, c, "c", "b")
+
+	// replacing an entry does not count it twice
+	c.add("c", "model", "1.1", []byte("cc"), "application/json")
+	checkKeys(t, c, "c", "b")
+	if c.size != 6 {
+		t.Errorf("got size %d, want 6", c.size)
+	}
+}
+
+func TestInferCacheExpiresEntries(t *testing.T) {
+	c := newInferCache(10, 0, 20*time.Millisecond)
+	c.add("a", "model", "1.1", []byte("a"), "application/json")
+
+	entry, hit := c.get("a")
+	if !hit || string(entry.body) != "a" {
+		t.Fatalf("got (%v, %v), want a hit for a", entry, hit)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, hit := c.get("a"); hit {
+		t.Error("expected a to expire")
+	}
+	checkKeys(t, c)
+	if c.size != 0 {
+		t.Errorf("got size %d, want 0", c.size)
+	}
+}
+
+func TestInferCacheInvalidatesModel(t *testing.T) {
+	c := newInferCache(10, 0, time.Minute)
+	c.add("a", "model-1", "1.1", []byte("a"), "application/json")
+	c.add("b", "model-2", "1.1", []byte("b"), "application/json")
+	c.add("c", "model-1", "1.1", []byte("c"), "application/json")
+
+	c.invalidate("model-1")
+	checkKeys(t, c, "b")
+	if c.size != 1 {
+		t.Errorf("got size %d, want 1", c.size)
+	}
+}
+
+func TestInferCacheDropsOtherVersions(t *testing.T) {
+	c := newInferCache(10, 0, time.Minute)
+	c.add("a", "model-1", "2.2", []byte("a"), "application/json")
+	c.add("b", "model-2", "2.2", []byte("b"), "application/json")
+	c.add("c", "model-1", "2.2", []byte("c"), "application/json")
+
+	// the model trained more epochs, so the old results are dropped
+	c.add("d", "model-1", "4.4", []byte("d"), "application/json")
+	checkKeys(t, c, "d", "b")
+}
+
+func TestCacheKey(t *testing.T) {
+	decode := func(s string) []interface{} {
+		var data []interface{}
+		if err := json.Unmarshal([]byte(s), &data); err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	key := func(req api.InferRequest, version string) string {
+		k, err := cacheKey(&req, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	base := api.InferRequest{ModelId: "model", Data: decode("[[1, 2], [3, 4]]")}
+	k := key(base, "1.1")
+
+	if other := key(api.InferRequest{ModelId: "model", Data: decode("[[1,2],[3,4]]")}, "1.1"); other != k {
+		t.Error("the same data serialized differently should have the same key")
+	}
+	if other := key(api.InferRequest{ModelId: "model", Data: decode("[[1, 2], [3, 4]]"), OutputType: api.OutputPrediction}, "1.1"); other != k {
+		t.Error("the default output type should have the same key")
+	}
+
+	tests := []struct {
+		name    string
+		req     api.InferRequest
+		version string
+	}{
+		{"version", base, "2.2"},
+		{"model", api.InferRequest{ModelId: "other", Data: base.Data}, "1.1"},
+		{"output type", api.InferRequest{ModelId: "model", Data: base.Data, OutputType: api.OutputLogits}, "1.1"},
+		{"data", api.InferRequest{ModelId: "model", Data: decode("[[1, 2]]")}, "1.1"},
+	}
+	for _, tt := range tests {
+		if key(tt.req, tt.version) == k {
+			t.Errorf("a different %s should change the key", tt.name)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+)
+
+// ensembleInference sends the datapoints of the request to each of the models of the
+// ensemble concurrently, and answers with their outputs combined for each datapoint
+// with the mode of the request. The request fails if any of the models fails, since
+// the combination would silently change with the models that answered
+func (c *Controller) ensembleInference(w http.ResponseWriter, req *api.InferRequest) {
+	c.logger.Debug("Ensemble inference",
+		zap.Strings("models", req.ModelIds),
+		zap.String("mode", req.Ensemble),
+		zap.Int("datapoints", len(req.Data)))
+
+	outputs := make([][]interface{}, len(req.ModelIds))
+	errs := make([]error, len(req.ModelIds))
+	var wg sync.WaitGroup
+	for i, modelId := range req.ModelIds {
+		wg.Add(1)
+		go func(i int, modelId string) {
+			defer wg.Done()
+			member := api.InferRequest{
+				ModelId:       modelId,
+				Serialization: req.Serialization,
+				OutputType:    req.EnsembleOutput(),
+			}
+			res := c.inferChunk(&member, req.Data, 0)
+			outputs[i], errs[i] = res.predictions, res.err
+		}(i, modelId)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			err = errors.Wrapf(err, "model %s of the ensemble failed", req.ModelIds[i])
+			c.logger.Error("Could not infer ensemble", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result, err := req.CombineEnsemble(outputs)
+	if err != nil {
+		c.logger.Error("Could not combine the outputs of the ensemble", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, contentType, err := util.Encode(req.Serialization, result)
+	if err != nil {
+		c.logger.Error("Could not encode ensemble result", zap.Error(err))
+		http.Error(w, "Failed to send ensemble result", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+// liveSnapshot returns the snapshot the inferences on the model should run on if it
+// is still being trained, or nil if it is not. The ps only knows the running jobs, so
+// a 404 means the training finished and the model is loaded as is. If the ps can't be
+// reached the model is also taken as finished, so inference does not depend on it
+func (c *Controller) liveSnapshot(modelId string) (*api.LiveSnapshot, error) {
+	snapshot, err := c.ps.GetSnapshot(modelId)
+	if err == nil {
+		return snapshot, nil
+	}
+
+	e, ok := err.(kerror.Error)
+	if ok && e.Code == http.StatusNotFound {
+		return nil, nil
+	}
+	if !ok {
+		c.logger.Warn("Could not ask the ps if the model is being trained",
+			zap.String("modelId", modelId),
+			zap.Error(err))
+		return nil, nil
+	}
+	return nil, err
+}
+
+// routeLive points the inference on a model still being trained to its last snapshot,
+// taking one of the slots of the live inferences, which are kept apart so they
+// don't take the capacity of the finished models. It returns the function that
+// frees the slot, or responds with the error and returns false
+func (c *Controller) routeLive(w http.ResponseWriter, req *api.InferRequest, snapshot *api.LiveSnapshot) (func(), bool) {
+	select {
+	case c.liveInfer <- struct{}{}:
+	default:
+		c.logger.Warn("Too many live inferences, rejecting request",
+			zap.String("modelId", req.ModelId))
+		http.Error(w, "too many inferences on models being trained, try again later", http.StatusTooManyRequests)
+		return nil, false
+	}
+
+	c.logger.Debug("Routing inference to the snapshot of a running job",
+		zap.String("jobId", snapshot.JobId),
+		zap.Int("generation", snapshot.Generation),
+		zap.Int("epoch", snapshot.Epoch))
+
+	req.ModelId = snapshot.ModelId
+	w.Header().Set(api.HeaderSnapshotGeneration, strconv.Itoa(snapshot.Generation))
+	w.Header().Set(api.HeaderSnapshotEpoch, strconv.Itoa(snapshot.Epoch))
+	return func() { <-c.liveInfer }, true
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	psClient "github.com/diegostock12/kubeml/ml/pkg/ps/client"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeSnapshots is a parameter server whose job publishes a new
+// snapshot, taken after the epoch of the same number, on every request
+type fakeSnapshots struct {
+	generation int64
+}
+
+func (f *fakeSnapshots) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g := int(atomic.AddInt64(&f.generation, 1))
+	json.NewEncoder(w).Encode(api.LiveSnapshot{
+		JobId:      "job",
+		ModelId:    api.SnapshotModelId("job", g),
+		Generation: g,
+		Epoch:      g,
+	})
+}
+
+func newLiveController(t *testing.T, ps http.Handler, slots int) *Controller {
+	t.Helper()
+	server := httptest.NewServer(ps)
+	t.Cleanup(server.Close)
+	return &Controller{
+		logger:    zap.NewNop(),
+		ps:        psClient.MakeClient(zap.NewNop(), server.URL),
+		liveInfer: make(chan struct{}, slots),
+	}
+}
+
+func TestLiveSnapshot(t *testing.T) {
+	tests := []struct {
+		name      string
+		ps        http.Handler
+		live      bool
+		wantError bool
+	}{
+		{"running job", &fakeSnapshots{}, true, false},
+		{
+			name: "finished job",
+			ps: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				kerror.RespondWithError(w, kerror.New(http.StatusNotFound, "job not found"))
+			}),
+		},
+		{
+			name: "live inference disabled",
+			ps: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				kerror.RespondWithError(w, kerror.New(http.StatusConflict, "the job does not allow inference while it trains"))
+			}),
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newLiveController(t, tt.ps, 1)
+			snapshot, err := c.liveSnapshot("job")
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if (snapshot != nil) != tt.live {
+				t.Errorf("got snapshot %+v, want one %v", snapshot, tt.live)
+			}
+		})
+	}
+}
+
+func TestLiveSnapshotUnreachable(t *testing.T) {
+	c := newLiveController(t, &fakeSnapshots{}, 1)
+	c.ps = psClient.MakeClient(zap.NewNop(), "http://127.0.0.1:1")
+
+	// the model is loaded as is if the ps can't tell
+	snapshot, err := c.liveSnapshot("job")
+	if snapshot != nil || err != nil {
+		t.Errorf("got snapshot %+v and error %v, want neither", snapshot, err)
+	}
+}
+
+func TestRouteLiveRacingSnapshots(t *testing.T) {
+	const inferences = 50
+	c := newLiveController(t, &fakeSnapshots{}, inferences)
+
+	// every inference gets a newer snapshot than the one before, and each
+	// is routed to the model of the generation it tells the caller
+	var wg sync.WaitGroup
+	for i := 0; i < inferences; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			snapshot, err := c.liveSnapshot("job")
+			if err != nil || snapshot == nil {
+				t.Errorf("got snapshot %+v and error %v", snapshot, err)
+				return
+			}
+
+			w := httptest.NewRecorder()
+			req := &api.InferRequest{ModelId: "job"}
+			release, ok := c.routeLive(w, req, snapshot)
+			if !ok {
+				t.Errorf("got inference rejected with status %d", w.Code)
+				return
+			}
+			defer release()
+
+			generation, _ := strconv.Atoi(w.Header().Get(api.HeaderSnapshotGeneration))
+			epoch, _ := strconv.Atoi(w.Header().Get(api.HeaderSnapshotEpoch))
+			if req.ModelId != api.SnapshotModelId("job", generation) || epoch != generation {
+				t.Errorf("got model %s for generation %d and epoch %d", req.ModelId, generation, epoch)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(c.liveInfer) != 0 {
+		t.Errorf("got %d live inference slots taken, want all released", len(c.liveInfer))
+	}
+}
+
+func TestRouteLiveBudget(t *testing.T) {
+	c := newLiveController(t, &fakeSnapshots{}, 1)
+	snapshot := &api.LiveSnapshot{JobId: "job", ModelId: api.SnapshotModelId("job", 1), Generation: 1, Epoch: 1}
+
+	release, ok := c.routeLive(httptest.NewRecorder(), &api.InferRequest{ModelId: "job"}, snapshot)
+	if !ok {
+		t.Fatal("got the first inference rejected")
+	}
+
+	// a second inference while the first runs is rejected and left as it was
+	w := httptest.NewRecorder()
+	req := &api.InferRequest{ModelId: "job"}
+	if _, ok := c.routeLive(w, req, snapshot); ok || w.Code != http.StatusTooManyRequests {
+		t.Errorf("got routed %v with status %d, want %d", ok, w.Code, http.StatusTooManyRequests)
+	}
+	if req.ModelId != "job" {
+		t.Errorf("got model %s for a rejected inference, want job", req.ModelId)
+	}
+
+	release()
+	if _, ok := c.routeLive(httptest.NewRecorder(), req, snapshot); !ok {
+		t.Error("got the inference rejected after the slot was released")
+	}
+}
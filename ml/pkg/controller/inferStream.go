@@ -0,0 +1,299 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultInferChunkSize = 1000
+	defaultInferWindow    = 4
+)
+
+type (
+	// chunkResult holds the predictions of one of the chunks of an inference request
+	// and the datapoints that failed if the request allows partial results
+	chunkResult struct {
+		index       int
+		predictions []interface{}
+		failed      []api.InferFailure
+		err         error
+	}
+
+	// predictionWriter encodes the predictions of a stream either as the
+	// predictions array of a JSON object, the same shape returned by the
+	// functions, or as JSON lines with one prediction per line
+	predictionWriter struct {
+		w      io.Writer
+		enc    *json.Encoder
+		ndjson bool
+		count  int
+	}
+)
+
+// wantsNDJSON returns whether the client accepts the predictions as JSON lines
+func wantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), util.ContentTypeNDJSON)
+}
+
+// splitData splits the datapoints of a request in chunks of at most size elements
+func splitData(data []interface{}, size int) [][]interface{} {
+	var chunks [][]interface{}
+	for start := 0; start < len(data); start += size {
+		end := start + size
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, data[start:end])
+	}
+	return chunks
+}
+
+func newPredictionWriter(w io.Writer, ndjson bool) *predictionWriter {
+	return &predictionWriter{w: w, enc: json.NewEncoder(w), ndjson: ndjson}
+}
+
+func (p *predictionWriter) begin() error {
+	if p.ndjson {
+		return nil
+	}
+	_, err := io.WriteString(p.w, `{"predictions":[`)
+	return err
+}
+
+// write encodes the predictions of a chunk, the encoder
+// already ends each of them with a new line
+func (p *predictionWriter) write(predictions []interface{}) error {
+	for _, pred := range predictions {
+		if !p.ndjson && p.count > 0 {
+			if _, err := io.WriteString(p.w, ","); err != nil {
+				return err
+			}
+		}
+		if err := p.enc.Encode(pred); err != nil {
+			return err
+		}
+		p.count++
+	}
+	return nil
+}
+
+// end closes the predictions. If the request allows partial results the
+// datapoints that failed are added as the failed array of the JSON object,
+// or as JSON lines with a failed object after the predictions
+func (p *predictionWriter) end(partial bool, failed []api.InferFailure) error {
+	if p.ndjson {
+		if !partial {
+			return nil
+		}
+		for _, f := range failed {
+			if err := p.enc.Encode(map[string]api.InferFailure{"failed": f}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !partial {
+		_, err := io.WriteString(p.w, "]}\n")
+		return err
+	}
+	if failed == nil {
+		failed = []api.InferFailure{}
+	}
+	if _, err := io.WriteString(p.w, `],"failed":`); err != nil {
+		return err
+	}
+	if err := p.enc.Encode(failed); err != nil {
+		return err
+	}
+	_, err := io.WriteString(p.w, "}\n")
+	return err
+}
+
+// streamInference splits the datapoints of the request in chunks that are sent to the
+// scheduler concurrently, and writes the predictions to the response in the order of
+// the input as the chunks are answered.
+//
+// At most inferWindow chunks are in flight or waiting to be written, since a chunk
+// is only submitted after the one inferWindow positions before it is written. This
+// bounds the memory used by the predictions no matter the size of the request.
+//
+// If the request allows partial results, a chunk that fails marks all its datapoints
+// as failed instead of aborting the stream, and the number of datapoints that
+// succeeded and failed are sent as trailers
+func (c *Controller) streamInference(w http.ResponseWriter, req *api.InferRequest, ndjson bool) {
+	chunks := splitData(req.Data, c.inferChunkSize)
+	c.logger.Debug("Streaming inference",
+		zap.String("modelId", req.ModelId),
+		zap.Int("datapoints", len(req.Data)),
+		zap.Int("chunks", len(chunks)))
+
+	results := make(chan chunkResult)
+	slots := make(chan struct{}, c.inferWindow)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for i := range chunks {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+
+			go func(i int) {
+				res := c.inferChunk(req, chunks[i], i*c.inferChunkSize)
+				res.index = i
+				select {
+				case results <- res:
+				case <-done:
+				}
+			}(i)
+		}
+	}()
+
+	contentType := util.ContentTypeJSON
+	if ndjson {
+		contentType = util.ContentTypeNDJSON
+	}
+	w.Header().Set("Content-Type", contentType)
+	if req.AllowPartial {
+		w.Header().Set("Trailer", api.HeaderInferSucceeded+", "+api.HeaderInferFailed)
+	}
+
+	// the reorder buffer holds the chunks answered before the previous ones
+	pw := newPredictionWriter(w, ndjson)
+	pending := make(map[int][]interface{}, c.inferWindow)
+	var failed []api.InferFailure
+	next := 0
+	started := false
+	for next < len(chunks) {
+		res := <-results
+		if res.err != nil && !req.AllowPartial {
+			c.abortInference(w, started, errors.Wrapf(res.err, "could not infer chunk %d", res.index))
+			return
+		}
+		if res.err != nil {
+			c.logger.Warn("Could not infer chunk, marking its datapoints as failed",
+				zap.Int("chunk", res.index),
+				zap.Error(res.err))
+			start := res.index * c.inferChunkSize
+			for j := range chunks[res.index] {
+				res.failed = append(res.failed, api.InferFailure{Index: start + j, Reason: res.err.Error()})
+			}
+		}
+		pending[res.index] = res.predictions
+		failed = append(failed, res.failed...)
+
+		for {
+			predictions, ok := pending[next]
+			if !ok {
+				break
+			}
+
+			if !started {
+				started = true
+				w.WriteHeader(http.StatusOK)
+				if err := pw.begin(); err != nil {
+					c.abortInference(w, started, err)
+					return
+				}
+			}
+			if err := pw.write(predictions); err != nil {
+				c.abortInference(w, started, err)
+				return
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+
+			delete(pending, next)
+			next++
+			<-slots
+		}
+	}
+
+	// the chunks are answered out of order, so the failures are sorted by index
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Index < failed[j].Index })
+	if !started {
+		// the request had no datapoints, so no chunk started the response
+		w.WriteHeader(http.StatusOK)
+		if err := pw.begin(); err != nil {
+			c.abortInference(w, true, err)
+			return
+		}
+	}
+	if err := pw.end(req.AllowPartial, failed); err != nil {
+		c.abortInference(w, true, err)
+		return
+	}
+	if req.AllowPartial {
+		w.Header().Set(api.HeaderInferSucceeded, strconv.Itoa(pw.count))
+		w.Header().Set(api.HeaderInferFailed, strconv.Itoa(len(failed)))
+	}
+}
+
+// abortInference reports an error of the stream. If the response was not started
+// the error is returned with the status code, otherwise the connection is
+// aborted so the client does not take the truncated stream as complete
+func (c *Controller) abortInference(w http.ResponseWriter, started bool, err error) {
+	c.logger.Error("Could not stream inference", zap.Bool("started", started), zap.Error(err))
+	if !started {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// inferChunk submits one chunk of the request to the scheduler and returns its predictions,
+// along with the datapoints that failed indexed from offset if the request allows partial results
+func (c *Controller) inferChunk(req *api.InferRequest, data []interface{}, offset int) chunkResult {
+	chunk := api.InferRequest{
+		ModelId:       req.ModelId,
+		Data:          data,
+		Serialization: req.Serialization,
+		OutputType:    req.OutputType,
+		AllowPartial:  req.AllowPartial,
+	}
+
+	body, contentType, err := util.Encode(req.Serialization, &chunk)
+	if err != nil {
+		return chunkResult{err: errors.Wrap(err, "could not encode request")}
+	}
+
+	resp, respType, err := c.scheduler.SubmitInferenceTask(body, contentType)
+	if err != nil {
+		return chunkResult{err: err}
+	}
+
+	result, err := decodeInferResult(respType, resp)
+	if err != nil {
+		return chunkResult{err: err}
+	}
+	for i := range result.Failed {
+		result.Failed[i].Index += offset
+	}
+	return chunkResult{predictions: result.Predictions, failed: result.Failed}
+}
+
+// decodeInferResult decodes the result returned by the functions, which
+// fails if the result has fields that are not part of api.InferResult
+func decodeInferResult(contentType string, body []byte) (*api.InferResult, error) {
+	var result api.InferResult
+	if err := util.DecodeStrict(contentType, body, &result); err != nil {
+		return nil, api.NewResponseError("infer", "", err.Error(), body)
+	}
+	if result.Predictions == nil {
+		return nil, api.NewResponseError("infer", "predictions", "is missing", body)
+	}
+	return &result, nil
+}
@@ -0,0 +1,228 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	schedulerClient "github.com/diegostock12/kubeml/ml/pkg/scheduler/client"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeInference answers the inference chunks sent to the scheduler predicting
+// each datapoint as a [datapoint, padding] pair, so the predictions can be
+// made larger than the request. The chunks of the datapoints in fail fail
+type fakeInference struct {
+	padding string
+	fail    map[float64]bool
+	// delay makes the chunks of the larger datapoints answer first
+	delay bool
+}
+
+func (f *fakeInference) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req api.InferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	predictions := make([]interface{}, len(req.Data))
+	for i, d := range req.Data {
+		if f.fail[d.(float64)] {
+			http.Error(w, "function failed", http.StatusInternalServerError)
+			return
+		}
+		predictions[i] = []interface{}{d, f.padding}
+	}
+	if f.delay && len(req.Data) > 0 {
+		time.Sleep(time.Duration(100-req.Data[0].(float64)) * time.Millisecond)
+	}
+	json.NewEncoder(w).Encode(api.InferResult{Predictions: predictions})
+}
+
+func newInferController(t *testing.T, inference *fakeInference, chunkSize, window int) *Controller {
+	t.Helper()
+	scheduler := httptest.NewServer(inference)
+	t.Cleanup(scheduler.Close)
+	return &Controller{
+		logger:         zap.NewNop(),
+		scheduler:      schedulerClient.MakeClient(zap.NewNop(), scheduler.URL),
+		inferChunkSize: chunkSize,
+		inferWindow:    window,
+	}
+}
+
+func inferRequest(datapoints int, partial bool) *api.InferRequest {
+	data := make([]interface{}, datapoints)
+	for i := range data {
+		data[i] = float64(i)
+	}
+	return &api.InferRequest{ModelId: "model", Data: data, AllowPartial: partial}
+}
+
+// predicted returns the datapoints of the predictions in the order they were written
+func predicted(t *testing.T, predictions []json.RawMessage) []int {
+	t.Helper()
+	var datapoints []int
+	for _, raw := range predictions {
+		var p []interface{}
+		if err := json.Unmarshal(raw, &p); err != nil {
+			t.Fatal(err)
+		}
+		datapoints = append(datapoints, int(p[0].(float64)))
+	}
+	return datapoints
+}
+
+func TestStreamInferenceOrder(t *testing.T) {
+	// the chunks are answered in reverse order and written in the order of the input
+	c := newInferController(t, &fakeInference{delay: true}, 3, 4)
+	want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	w := httptest.NewRecorder()
+	c.streamInference(w, inferRequest(11, false), false)
+	var result struct {
+		Predictions []json.RawMessage `json:"predictions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("got invalid response %s: %v", w.Body.String(), err)
+	}
+	if got := predicted(t, result.Predictions); !reflect.DeepEqual(got, want) {
+		t.Errorf("got predictions of %v, want %v", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	c.streamInference(w, inferRequest(11, false), true)
+	var lines []json.RawMessage
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		lines = append(lines, json.RawMessage(line))
+	}
+	if got := predicted(t, lines); !reflect.DeepEqual(got, want) {
+		t.Errorf("got predictions of %v as JSON lines, want %v", got, want)
+	}
+}
+
+func TestStreamInferencePartial(t *testing.T) {
+	c := newInferController(t, &fakeInference{fail: map[float64]bool{4: true}}, 3, 2)
+
+	// the chunk of datapoint 4 fails as a whole
+	w := httptest.NewRecorder()
+	c.streamInference(w, inferRequest(8, true), false)
+	var result struct {
+		Predictions []json.RawMessage  `json:"predictions"`
+		Failed      []api.InferFailure `json:"failed"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("got invalid response %s: %v", w.Body.String(), err)
+	}
+	if got := predicted(t, result.Predictions); !reflect.DeepEqual(got, []int{0, 1, 2, 6, 7}) {
+		t.Errorf("got predictions of %v, want [0 1 2 6 7]", got)
+	}
+	var failed []int
+	for _, f := range result.Failed {
+		failed = append(failed, f.Index)
+	}
+	if !reflect.DeepEqual(failed, []int{3, 4, 5}) {
+		t.Errorf("got datapoints %v failed, want [3 4 5]", failed)
+	}
+
+	trailer := w.Result().Trailer
+	if trailer.Get(api.HeaderInferSucceeded) != "5" || trailer.Get(api.HeaderInferFailed) != "3" {
+		t.Errorf("got trailers %v, want 5 succeeded and 3 failed", trailer)
+	}
+}
+
+func TestStreamInferenceFails(t *testing.T) {
+	// without partial results a failed chunk fails the request before it is started
+	c := newInferController(t, &fakeInference{fail: map[float64]bool{0: true}}, 3, 2)
+	w := httptest.NewRecorder()
+	c.streamInference(w, inferRequest(8, false), false)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	// once started, the stream is aborted instead of ending it as complete
+	c = newInferController(t, &fakeInference{fail: map[float64]bool{7: true}}, 3, 1)
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("got %v, want the handler aborted", r)
+		}
+	}()
+	c.streamInference(httptest.NewRecorder(), inferRequest(8, false), false)
+}
+
+// heapWriter discards the stream checking the order of the JSON lines, and
+// records the heap in use after a collection every few predictions
+type heapWriter struct {
+	t       *testing.T
+	line    bytes.Buffer
+	next    int
+	maxHeap uint64
+}
+
+func (w *heapWriter) Header() http.Header {
+	return http.Header{}
+}
+
+func (w *heapWriter) WriteHeader(int) {}
+
+func (w *heapWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != '\n' {
+			w.line.WriteByte(b)
+			continue
+		}
+		if prefix := fmt.Sprintf("[%d,", w.next); !strings.HasPrefix(w.line.String(), prefix) {
+			w.t.Fatalf("got prediction %.20s, want datapoint %d", w.line.String(), w.next)
+		}
+		w.line.Reset()
+		w.next++
+
+		if w.next%100 == 0 {
+			var stats runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > w.maxHeap {
+				w.maxHeap = stats.HeapAlloc
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func TestStreamInferenceMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams a large inference")
+	}
+
+	// the predictions add up to 32MB, and the chunks of the
+	// reorder buffer and in flight to 4 x 25 x 4KB
+	const (
+		datapoints = 8000
+		padding    = 4 << 10
+		ceiling    = 8 << 20
+	)
+	c := newInferController(t, &fakeInference{padding: strings.Repeat("a", padding)}, 25, 4)
+	req := inferRequest(datapoints, false)
+
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	w := &heapWriter{t: t}
+	c.streamInference(w, req, true)
+
+	if w.next != datapoints {
+		t.Fatalf("got %d predictions, want %d", w.next, datapoints)
+	}
+	if grown := w.maxHeap - stats.HeapAlloc; w.maxHeap > stats.HeapAlloc && grown > ceiling {
+		t.Errorf("got heap grown by %d bytes while streaming %d bytes, want at most %d",
+			grown, datapoints*padding, ceiling)
+	}
+}
@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+type (
+	// jobHistories finds and changes the histories of the jobs of the batch operations.
+	// get returns nil and delete and setTag return false if the history of the job does not exist
+	jobHistories interface {
+		get(jobId string) (*api.History, error)
+		find(query bson.M) ([]api.History, error)
+		delete(jobId string) (bool, error)
+		setTag(jobId, name, value string) (bool, error)
+	}
+
+	// mongoHistories are the histories of the jobs saved in mongo
+	mongoHistories struct {
+		client *mongo.Client
+	}
+)
+
+func (m *mongoHistories) collection() *mongo.Collection {
+	return m.client.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+}
+
+func (m *mongoHistories) get(jobId string) (*api.History, error) {
+	var history api.History
+	err := m.collection().FindOne(context.TODO(), bson.M{"_id": jobId}).Decode(&history)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find history")
+	}
+	history.Migrate()
+	return &history, nil
+}
+
+func (m *mongoHistories) find(query bson.M) ([]api.History, error) {
+	var histories []api.History
+	cursor, err := m.collection().Find(context.TODO(), query)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find histories")
+	}
+	if err = cursor.All(context.TODO(), &histories); err != nil {
+		return nil, errors.Wrap(err, "could not decode histories")
+	}
+	return histories, nil
+}
+
+func (m *mongoHistories) delete(jobId string) (bool, error) {
+	res, err := m.collection().DeleteOne(context.TODO(), bson.M{"_id": jobId})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount > 0, nil
+}
+
+func (m *mongoHistories) setTag(jobId, name, value string) (bool, error) {
+	res, err := m.collection().UpdateOne(context.TODO(), bson.M{"_id": jobId},
+		bson.M{"$set": bson.M{"task.tags." + name: value}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// batchJobs applies an operation to all the jobs matching the filter of the request,
+// at most JobBatchConcurrency at once. Each job is changed on its own, so a job that
+// fails or is skipped does not stop the others, and the result of every job is
+// returned. A dry run only returns the jobs that would be changed
+func (c *Controller) batchJobs(w http.ResponseWriter, r *http.Request) {
+	var req api.JobBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.logger.Error("Could not parse the batch request", zap.Error(err))
+		http.Error(w, "Failed to decode the request", http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jobs, err := c.matchJobs(req.Filter)
+	if err != nil {
+		c.logger.Error("Could not find the jobs of the batch", zap.Error(err))
+		http.Error(w, "could not find the jobs matching the filter", http.StatusInternalServerError)
+		return
+	}
+
+	resp := &api.JobBatchResponse{
+		Operation: req.Operation,
+		DryRun:    req.DryRun,
+		Results:   make([]api.JobOpResult, len(jobs)),
+	}
+	if req.DryRun {
+		for i := range jobs {
+			resp.Results[i] = api.JobOpResult{JobId: jobs[i].Id, State: jobs[i].Status(), Result: api.JobOpMatched}
+		}
+	} else {
+		sem := make(chan struct{}, api.JobBatchConcurrency)
+		var wg sync.WaitGroup
+		for i := range jobs {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				resp.Results[i] = c.applyJobOp(req, &jobs[i])
+			}(i)
+		}
+		wg.Wait()
+		auditChange(r, req, resp.Results)
+	}
+
+	c.logger.Info("Applied batch operation",
+		zap.String("operation", req.Operation),
+		zap.Bool("dryRun", req.DryRun),
+		zap.Int("jobs", len(jobs)))
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		c.logger.Error("Could not marshal batch response", zap.Error(err))
+		http.Error(w, "Error marshaling request", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// matchJobs returns the jobs matching the filter sorted by id, from their histories
+// and from the tasks of the parameter server, since a job that just started might
+// not have written its history yet
+func (c *Controller) matchJobs(filter api.JobFilter) ([]api.History, error) {
+	query := bson.M{}
+	for name, value := range filter.Tags {
+		query["task.tags."+name] = value
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query["started"] = bson.M{"$lt": filter.CreatedBefore}
+	}
+
+	histories, err := c.histories.find(query)
+	if err != nil {
+		return nil, err
+	}
+
+	taskBytes, err := c.ps.ListTasks()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list the running tasks")
+	}
+	var tasks []api.TrainTask
+	if err = json.Unmarshal(taskBytes, &tasks); err != nil {
+		return nil, errors.Wrap(err, "could not decode the running tasks")
+	}
+
+	seen := make(map[string]bool, len(histories))
+	for _, h := range histories {
+		seen[h.Id] = true
+	}
+	for _, task := range tasks {
+		if !seen[task.Job.JobId] {
+			histories = append(histories, api.History{Id: task.Job.JobId, Task: task.Parameters, InProgress: true})
+		}
+	}
+
+	var jobs []api.History
+	for i := range histories {
+		histories[i].Migrate()
+		if filter.Matches(&histories[i]) {
+			jobs = append(jobs, histories[i])
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Id < jobs[j].Id })
+	return jobs, nil
+}
+
+// applyJobOp applies the operation of the batch to a job. Running jobs are only
+// stopped, since their history is rewritten while they train, and only running
+// jobs can be stopped
+func (c *Controller) applyJobOp(req api.JobBatchRequest, job *api.History) api.JobOpResult {
+	result := api.JobOpResult{JobId: job.Id, State: job.Status()}
+	running := result.State == api.JobRunning || result.State == api.JobPaused
+	skip := func(reason string) api.JobOpResult {
+		result.Result, result.Reason = api.JobOpSkipped, reason
+		return result
+	}
+
+	var err error
+	switch req.Operation {
+	case api.JobOpStop:
+		if !running {
+			return skip(fmt.Sprintf("the job is %s", result.State))
+		}
+		err = c.ps.StopTask(job.Id)
+
+	case api.JobOpDelete:
+		if running {
+			return skip("the job is running, stop it first")
+		}
+		deleted, e := c.histories.delete(job.Id)
+		if e == nil && !deleted {
+			return skip("the history was already deleted")
+		}
+		err = e
+
+	case api.JobOpAddTag:
+		name, value, _ := api.ParseTag(req.Tag)
+		if running {
+			return skip("the history of a running job is rewritten while it trains")
+		}
+		if v, exists := job.Task.Tags[name]; exists && v == value {
+			return skip("the job already has the tag")
+		}
+		tagged, e := c.histories.setTag(job.Id, name, value)
+		if e == nil && !tagged {
+			return skip("the history was deleted")
+		}
+		err = e
+	}
+
+	if err != nil {
+		c.logger.Warn("Batch operation failed on job",
+			zap.String("operation", req.Operation),
+			zap.String("jobId", job.Id),
+			zap.Error(err))
+		result.Result, result.Reason = api.JobOpFailed, err.Error()
+		return result
+	}
+	result.Result = api.JobOpSucceeded
+	return result
+}
@@ -0,0 +1,378 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	psClient "github.com/diegostock12/kubeml/ml/pkg/ps/client"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHistories keeps the histories in memory and fails the changes of the jobs in broken
+type fakeHistories struct {
+	mu        sync.Mutex
+	histories map[string]api.History
+	broken    map[string]bool
+	query     bson.M
+}
+
+func (f *fakeHistories) get(jobId string) (*api.History, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.broken[jobId] {
+		return nil, errors.New("database unavailable")
+	}
+	h, exists := f.histories[jobId]
+	if !exists {
+		return nil, nil
+	}
+	return &h, nil
+}
+
+func (f *fakeHistories) find(query bson.M) ([]api.History, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.query = query
+	var histories []api.History
+	for _, h := range f.histories {
+		histories = append(histories, h)
+	}
+	return histories, nil
+}
+
+func (f *fakeHistories) delete(jobId string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.broken[jobId] {
+		return false, errors.New("database unavailable")
+	}
+	_, exists := f.histories[jobId]
+	delete(f.histories, jobId)
+	return exists, nil
+}
+
+func (f *fakeHistories) setTag(jobId, name, value string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.broken[jobId] {
+		return false, errors.New("database unavailable")
+	}
+	h, exists := f.histories[jobId]
+	if !exists {
+		return false, nil
+	}
+	tags := map[string]string{name: value}
+	for k, v := range h.Task.Tags {
+		if k != name {
+			tags[k] = v
+		}
+	}
+	h.Task.Tags = tags
+	f.histories[jobId] = h
+	return true, nil
+}
+
+// fakeTasks is a parameter server running the tasks, which refuses to stop the ones in broken
+type fakeTasks struct {
+	mu      sync.Mutex
+	tasks   []api.TrainTask
+	broken  map[string]bool
+	stopped []string
+}
+
+func (f *fakeTasks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/tasks":
+		body, _ := json.Marshal(f.tasks)
+		w.Write(body)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/stop/"):
+		jobId := strings.TrimPrefix(r.URL.Path, "/stop/")
+		if f.broken[jobId] {
+			kerror.RespondWithError(w, kerror.New(http.StatusInternalServerError, "could not stop the job"))
+			return
+		}
+		f.stopped = append(f.stopped, jobId)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newBatchController returns a controller with the histories and the running tasks
+func newBatchController(t *testing.T, histories *fakeHistories, tasks *fakeTasks) *Controller {
+	t.Helper()
+	ps := httptest.NewServer(tasks)
+	t.Cleanup(ps.Close)
+	return &Controller{
+		logger:    zap.NewNop(),
+		ps:        psClient.MakeClient(zap.NewNop(), ps.URL),
+		histories: histories,
+	}
+}
+
+func history(id, team string, started time.Time, exitErr string) api.History {
+	return api.History{
+		Id:      id,
+		Task:    api.TrainRequest{Tags: map[string]string{"team": team}},
+		Started: started,
+		Error:   exitErr,
+	}
+}
+
+func runningTask(id, team string) api.TrainTask {
+	return api.TrainTask{
+		Parameters: api.TrainRequest{Tags: map[string]string{"team": team}},
+		Job:        api.JobInfo{JobId: id},
+	}
+}
+
+// batch sends the batch request to the controller and returns the results by job
+func batch(t *testing.T, c *Controller, req api.JobBatchRequest) map[string]api.JobOpResult {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	c.batchJobs(w, httptest.NewRequest(http.MethodPost, "/jobs/batch", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status code %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp api.JobBatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Operation != req.Operation || resp.DryRun != req.DryRun {
+		t.Errorf("got operation %s and dry run %v, want %s and %v", resp.Operation, resp.DryRun, req.Operation, req.DryRun)
+	}
+	results := make(map[string]api.JobOpResult, len(resp.Results))
+	for _, result := range resp.Results {
+		results[result.JobId] = result
+	}
+	return results
+}
+
+func TestMatchJobs(t *testing.T) {
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	histories := &fakeHistories{histories: map[string]api.History{
+		"c": history("c", "vision", day, ""),
+		"a": history("a", "vision", day.Add(48*time.Hour), ""),
+		"b": history("b", "nlp", day, ""),
+	}}
+
+	// a running job might not have written its history yet
+	tasks := &fakeTasks{tasks: []api.TrainTask{
+		runningTask("d", "vision"),
+		runningTask("e", "nlp"),
+		runningTask("c", "vision"),
+	}}
+	c := newBatchController(t, histories, tasks)
+
+	tests := []struct {
+		name   string
+		filter api.JobFilter
+		query  bson.M
+		want   []string
+	}{
+		{
+			name:   "tag",
+			filter: api.JobFilter{Tags: map[string]string{"team": "vision"}},
+			query:  bson.M{"task.tags.team": "vision"},
+			want:   []string{"a", "c", "d"},
+		},
+		{
+			name:   "tag and state",
+			filter: api.JobFilter{Tags: map[string]string{"team": "vision"}, State: api.JobRunning},
+			query:  bson.M{"task.tags.team": "vision"},
+			want:   []string{"d"},
+		},
+		{
+			name:   "created before",
+			filter: api.JobFilter{CreatedBefore: day.Add(24 * time.Hour)},
+			query:  bson.M{"started": bson.M{"$lt": day.Add(24 * time.Hour)}},
+			want:   []string{"b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs, err := c.matchJobs(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, job := range jobs {
+				ids = append(ids, job.Id)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("got jobs %v, want %v", ids, tt.want)
+			}
+			if !reflect.DeepEqual(histories.query, tt.query) {
+				t.Errorf("got query %v, want %v", histories.query, tt.query)
+			}
+		})
+	}
+}
+
+func TestBatchJobsMixedResults(t *testing.T) {
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	newHistories := func() *fakeHistories {
+		return &fakeHistories{
+			histories: map[string]api.History{
+				"completed": history("completed", "vision", day, ""),
+				"failed":    history("failed", "vision", day, "out of memory"),
+				"broken":    history("broken", "vision", day, ""),
+				"tagged":    history("tagged", "vision", day, ""),
+			},
+			broken: map[string]bool{"broken": true},
+		}
+	}
+	newTasks := func() *fakeTasks {
+		return &fakeTasks{
+			tasks:  []api.TrainTask{runningTask("running", "vision"), runningTask("stuck", "vision")},
+			broken: map[string]bool{"stuck": true},
+		}
+	}
+	filter := api.JobFilter{Tags: map[string]string{"team": "vision"}}
+
+	tests := []struct {
+		operation string
+		tag       string
+		want      map[string]string
+	}{
+		{
+			operation: api.JobOpStop,
+			want: map[string]string{
+				"completed": api.JobOpSkipped,
+				"failed":    api.JobOpSkipped,
+				"broken":    api.JobOpSkipped,
+				"tagged":    api.JobOpSkipped,
+				"running":   api.JobOpSucceeded,
+				"stuck":     api.JobOpFailed,
+			},
+		},
+		{
+			operation: api.JobOpDelete,
+			want: map[string]string{
+				"completed": api.JobOpSucceeded,
+				"failed":    api.JobOpSucceeded,
+				"broken":    api.JobOpFailed,
+				"tagged":    api.JobOpSucceeded,
+				"running":   api.JobOpSkipped,
+				"stuck":     api.JobOpSkipped,
+			},
+		},
+		{
+			operation: api.JobOpAddTag,
+			tag:       "team=vision",
+			want: map[string]string{
+				"completed": api.JobOpSkipped,
+				"failed":    api.JobOpSkipped,
+				"broken":    api.JobOpSkipped,
+				"tagged":    api.JobOpSkipped,
+				"running":   api.JobOpSkipped,
+				"stuck":     api.JobOpSkipped,
+			},
+		},
+		{
+			operation: api.JobOpAddTag,
+			tag:       "owner=ml",
+			want: map[string]string{
+				"completed": api.JobOpSucceeded,
+				"failed":    api.JobOpSucceeded,
+				"broken":    api.JobOpFailed,
+				"tagged":    api.JobOpSucceeded,
+				"running":   api.JobOpSkipped,
+				"stuck":     api.JobOpSkipped,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.operation+" "+tt.tag, func(t *testing.T) {
+			histories, tasks := newHistories(), newTasks()
+			c := newBatchController(t, histories, tasks)
+
+			// a job that fails or is skipped does not stop the rest
+			results := batch(t, c, api.JobBatchRequest{Filter: filter, Operation: tt.operation, Tag: tt.tag})
+			if len(results) != len(tt.want) {
+				t.Errorf("got %d results, want %d", len(results), len(tt.want))
+			}
+			for jobId, want := range tt.want {
+				result := results[jobId]
+				if result.Result != want {
+					t.Errorf("got result %s for %s, want %s", result.Result, jobId, want)
+				}
+				if result.Result != api.JobOpSucceeded && len(result.Reason) == 0 {
+					t.Errorf("got no reason for the %s result of %s", result.Result, jobId)
+				}
+			}
+
+			for jobId, want := range tt.want {
+				_, exists := histories.histories[jobId]
+				switch {
+				case tt.operation == api.JobOpDelete && want == api.JobOpSucceeded && exists:
+					t.Errorf("got the history of %s after deleting it", jobId)
+				case tt.operation == api.JobOpAddTag && want == api.JobOpSucceeded && histories.histories[jobId].Task.Tags["owner"] != "ml":
+					t.Errorf("got tags %v for %s, want the new tag", histories.histories[jobId].Task.Tags, jobId)
+				}
+			}
+			if tt.operation == api.JobOpStop && !reflect.DeepEqual(tasks.stopped, []string{"running"}) {
+				t.Errorf("got stopped jobs %v, want [running]", tasks.stopped)
+			}
+		})
+	}
+}
+
+func TestBatchJobsDryRun(t *testing.T) {
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	histories := &fakeHistories{histories: map[string]api.History{
+		"completed": history("completed", "vision", day, ""),
+		"failed":    history("failed", "vision", day, "out of memory"),
+		"other":     history("other", "nlp", day, ""),
+	}}
+	tasks := &fakeTasks{tasks: []api.TrainTask{runningTask("running", "vision")}}
+	c := newBatchController(t, histories, tasks)
+
+	for _, operation := range []string{api.JobOpStop, api.JobOpDelete} {
+		t.Run(operation, func(t *testing.T) {
+			results := batch(t, c, api.JobBatchRequest{
+				Filter:    api.JobFilter{Tags: map[string]string{"team": "vision"}},
+				Operation: operation,
+				DryRun:    true,
+			})
+
+			// every matched job is listed with its state, even
+			// the ones the operation would skip
+			want := map[string]string{
+				"completed": api.JobCompleted,
+				"failed":    api.JobFailed,
+				"running":   api.JobRunning,
+			}
+			if len(results) != len(want) {
+				t.Errorf("got %d results, want %d", len(results), len(want))
+			}
+			for jobId, state := range want {
+				result := results[jobId]
+				if result.Result != api.JobOpMatched || result.State != state {
+					t.Errorf("got result %s and state %s for %s, want %s and %s",
+						result.Result, result.State, jobId, api.JobOpMatched, state)
+				}
+			}
+
+			// and nothing is changed
+			if len(histories.histories) != 3 || len(tasks.stopped) != 0 {
+				t.Errorf("got %d histories and stopped jobs %v, want 3 and none", len(histories.histories), tasks.stopped)
+			}
+		})
+	}
+}
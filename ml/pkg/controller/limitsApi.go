@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+)
+
+// limitsDocumentId is the id of the document holding the
+// admission limits in the config collection
+const limitsDocumentId = "admission_limits"
+
+// limitsDocument is the admission limits as saved in the database
+type limitsDocument struct {
+	Id                  string `bson:"_id"`
+	api.AdmissionLimits `bson:",inline"`
+}
+
+// getAdmissionLimits returns the limits saved in the database,
+// or the default ones if they were never set
+func (c *Controller) getAdmissionLimits() (api.AdmissionLimits, error) {
+	var doc limitsDocument
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection("config")
+	err := collection.FindOne(context.TODO(), bson.M{"_id": limitsDocumentId}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return api.DefaultAdmissionLimits(), nil
+	}
+	if err != nil {
+		return api.AdmissionLimits{}, errors.Wrap(err, "could not read admission limits")
+	}
+	return doc.AdmissionLimits, nil
+}
+
+// checkAdmissionLimits checks the request against the admission limits. A request
+// over the limits is only admitted with the budget override, which is cleared
+// when it is not needed so the task only records the overrides actually used.
+// Requests without a max parallelism are capped at the one of the limits
+func (c *Controller) checkAdmissionLimits(req *api.TrainRequest) error {
+	limits, err := c.getAdmissionLimits()
+	if err != nil {
+		c.logger.Warn("Could not read admission limits, using the defaults", zap.Error(err))
+		limits = api.DefaultAdmissionLimits()
+	}
+
+	if req.Options.MaxParallelism == 0 && limits.MaxParallelism > 0 {
+		req.Options.MaxParallelism = limits.MaxParallelism
+		req.RecordChange("max_parallelism", 0, req.Options.MaxParallelism,
+			"capped at the max parallelism of the admission limits")
+	}
+
+	err = limits.Check(*req)
+	if err == nil {
+		override := req.BudgetOverride
+		req.BudgetOverride = false
+		req.RecordChange("budget_override", override, req.BudgetOverride,
+			"the request is within the admission limits")
+		return nil
+	}
+	if !req.BudgetOverride {
+		return err
+	}
+
+	// TODO only allow the override for admin tokens once there is auth
+	c.logger.Warn("Admitting request over the limits with a budget override",
+		zap.Int("epochs", req.Epochs),
+		zap.Int("functionEpochs", req.FunctionEpochs()),
+		zap.Any("limits", limits))
+	return nil
+}
+
+// getLimits returns the admission limits of the train requests
+func (c *Controller) getLimits(w http.ResponseWriter, r *http.Request) {
+	limits, err := c.getAdmissionLimits()
+	if err != nil {
+		c.logger.Error("Could not get admission limits", zap.Error(err))
+		http.Error(w, "could not get admission limits", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(limits)
+	if err != nil {
+		c.logger.Error("Could not marshal admission limits", zap.Error(err))
+		http.Error(w, "error marshaling limits", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// setLimits replaces the admission limits of the train requests
+func (c *Controller) setLimits(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		c.logger.Error("Could not read body", zap.Error(err))
+		http.Error(w, "Failed to read request", http.StatusInternalServerError)
+		return
+	}
+
+	var limits api.AdmissionLimits
+	if err = json.Unmarshal(body, &limits); err != nil {
+		c.logger.Error("Failed to parse the limits", zap.Error(err))
+		http.Error(w, "Failed to decode the request", http.StatusBadRequest)
+		return
+	}
+	if err = limits.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before, err := c.getAdmissionLimits()
+	if err != nil {
+		c.logger.Error("Could not get admission limits", zap.Error(err))
+		http.Error(w, "could not get admission limits", http.StatusInternalServerError)
+		return
+	}
+
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection("config")
+	_, err = collection.ReplaceOne(context.TODO(), bson.M{"_id": limitsDocumentId},
+		limitsDocument{Id: limitsDocumentId, AdmissionLimits: limits}, options.Replace().SetUpsert(true))
+	if err != nil {
+		c.logger.Error("Could not save admission limits", zap.Error(err))
+		http.Error(w, "could not save admission limits", http.StatusInternalServerError)
+		return
+	}
+
+	auditChange(r, before, limits)
+	c.logger.Info("Updated admission limits", zap.Any("limits", limits))
+	w.WriteHeader(http.StatusOK)
+}
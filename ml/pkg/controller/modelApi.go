@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/RedisAI/redisai-go/redisai"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"github.com/diegostock12/kubeml/ml/pkg/model"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+)
+
+// modelSummary returns the layers of the reference model of a job with their
+// type, shape and parameters. Only the metadata of the tensors is read
+// from redis, so the weights of the model are not fetched
+func (c *Controller) modelSummary(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	c.logger.Debug("Getting model summary", zap.String("jobId", jobId))
+
+	redisClient := util.GetRedisAIClient(c.redisPool, false)
+	defer redisClient.Close()
+
+	weights, err := c.modelWeights(redisClient, jobId)
+	if err != nil {
+		c.logger.Error("Could not list the weights of the model", zap.Error(err))
+		http.Error(w, "Could not list the weights of the model", http.StatusInternalServerError)
+		return
+	}
+	if len(weights.Tensors) == 0 {
+		http.Error(w, "Could not find model for job", http.StatusNotFound)
+		return
+	}
+
+	summary := &api.ModelSummary{JobId: jobId}
+	for _, t := range weights.Tensors {
+		summary.AddLayer(model.DescribeLayer(t.Layer, t.Dtype, t.Shape))
+	}
+
+	resp, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		c.logger.Error("Could not marshal model summary", zap.Error(err))
+		http.Error(w, "Error marshaling summary", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// modelDiff compares the weights of the models referenced by the a and b query
+// parameters, either <jobId> for the current model of a job or <jobId>@<epoch>
+// for the checkpoint of an epoch, returning the layers ranked by how much they changed
+func (c *Controller) modelDiff(w http.ResponseWriter, r *http.Request) {
+	refA, err := api.ParseModelRef(r.URL.Query().Get("a"))
+	if err != nil {
+		c.logger.Error("Invalid model reference", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	refB, err := api.ParseModelRef(r.URL.Query().Get("b"))
+	if err != nil {
+		c.logger.Error("Invalid model reference", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.logger.Debug("Comparing models",
+		zap.String("a", refA.String()),
+		zap.String("b", refB.String()))
+
+	redisClient := util.GetRedisAIClient(c.redisPool, false)
+	defer redisClient.Close()
+
+	weightsA, readA, err := c.refWeights(redisClient, refA)
+	if err != nil {
+		c.respondRefError(w, refA, err)
+		return
+	}
+	weightsB, readB, err := c.refWeights(redisClient, refB)
+	if err != nil {
+		c.respondRefError(w, refB, err)
+		return
+	}
+
+	diff, err := diffWeights(weightsA, weightsB, readA, readB)
+	if err != nil {
+		c.logger.Error("Could not compare the models", zap.Error(err))
+		http.Error(w, "Could not compare the models", http.StatusInternalServerError)
+		return
+	}
+	diff.A, diff.B = refA.String(), refB.String()
+
+	resp, err := json.MarshalIndent(diff, "", "  ")
+	if err != nil {
+		c.logger.Error("Could not marshal model diff", zap.Error(err))
+		http.Error(w, "Error marshaling diff", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// respondRefError responds with the error found reading the weights of a model
+func (c *Controller) respondRefError(w http.ResponseWriter, ref api.ModelRef, err error) {
+	c.logger.Error("Could not read the weights of the model",
+		zap.String("model", ref.String()),
+		zap.Error(err))
+	code := http.StatusInternalServerError
+	if e, ok := err.(kerror.Error); ok {
+		code = e.Code
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// refWeights lists the tensors of a referenced model and returns the function to read
+// them. The current model of a job is read from redis, or from its last checkpoint
+// once it is no longer there, and the other epochs from their checkpoint
+func (c *Controller) refWeights(redisClient *redisai.Client, ref api.ModelRef) (*api.ExportWeights, blobReader, error) {
+	if ref.Epoch == 0 {
+		weights, err := c.modelWeights(redisClient, ref.JobId)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(weights.Tensors) > 0 {
+			return weights, redisBlobs(redisClient), nil
+		}
+	}
+
+	var history api.History
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	err := collection.FindOne(context.TODO(), bson.M{"_id": ref.JobId}).Decode(&history)
+	if err != nil {
+		return nil, nil, kerror.New(http.StatusNotFound, fmt.Sprintf("could not find model %s", ref))
+	}
+	history.Migrate()
+
+	if !api.IsObjectStorage(history.Task.Options.CheckpointBackend) || history.Data.CheckpointEpoch == 0 {
+		return nil, nil, kerror.New(http.StatusNotFound,
+			fmt.Sprintf("job %s has no checkpoints in object storage", ref.JobId))
+	}
+
+	weights, readBlob, err := c.checkpointWeights(&history, ref.Epoch)
+	if errors.Cause(err) == model.ErrObjectNotFound {
+		return nil, nil, kerror.New(http.StatusNotFound, fmt.Sprintf("could not find checkpoint of model %s", ref))
+	}
+	return weights, readBlob, err
+}
+
+// diffWeights compares two models one layer at a time, so only the
+// tensors of a layer in both models are held in memory at once
+func diffWeights(a, b *api.ExportWeights, readA, readB blobReader) (*api.ModelDiff, error) {
+	inB := make(map[string]api.ExportTensor, len(b.Tensors))
+	for _, t := range b.Tensors {
+		inB[t.Layer] = t
+	}
+
+	diff := &api.ModelDiff{Layers: []api.LayerDiff{}}
+	for _, ta := range a.Tensors {
+		tb, exists := inB[ta.Layer]
+		if !exists {
+			diff.OnlyInA = append(diff.OnlyInA, ta.Layer)
+			continue
+		}
+		delete(inB, ta.Layer)
+
+		blobA, err := readTensor(readA, ta.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get tensor %s", ta.Key)
+		}
+		blobB, err := readTensor(readB, tb.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get tensor %s", tb.Key)
+		}
+
+		layer, err := model.DiffLayer(ta.Layer,
+			&model.Tensor{Dtype: ta.Dtype, Shape: ta.Shape, Blob: blobA},
+			&model.Tensor{Dtype: tb.Dtype, Shape: tb.Shape, Blob: blobB})
+		if err != nil {
+			return nil, err
+		}
+		diff.Layers = append(diff.Layers, layer)
+	}
+
+	for layer := range inB {
+		diff.OnlyInB = append(diff.OnlyInB, layer)
+	}
+	sort.Strings(diff.OnlyInA)
+	sort.Strings(diff.OnlyInB)
+
+	diff.Rank()
+	return diff, nil
+}
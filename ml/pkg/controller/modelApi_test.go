@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/RedisAI/redisai-go/redisai"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"reflect"
+	"testing"
+)
+
+// diffModel returns the weights of a model with float layers of two values
+// and the blobs to read them, keyed by the model and the layer
+func diffModel(id string, layers map[string][2]float32, blobs map[string][]byte) *api.ExportWeights {
+	weights := &api.ExportWeights{}
+	for layer, values := range layers {
+		buf := new(bytes.Buffer)
+		binary.Write(buf, binary.LittleEndian, values[:])
+		key := id + ":" + layer
+		blobs[key] = buf.Bytes()
+		weights.Tensors = append(weights.Tensors, api.ExportTensor{
+			Layer: layer, Key: key, Dtype: redisai.TypeFloat32, Shape: []int64{2},
+		})
+	}
+	return weights
+}
+
+func TestDiffWeightsIdentical(t *testing.T) {
+	blobs := &memoryBlobs{blobs: make(map[string][]byte)}
+	layers := map[string][2]float32{"fc.weight": {0, 0}, "fc.bias": {0, 0}}
+	a := diffModel("a", layers, blobs.blobs)
+	b := diffModel("b", layers, blobs.blobs)
+
+	diff, err := diffWeights(a, b, blobs.read, blobs.read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.OnlyInA) > 0 || len(diff.OnlyInB) > 0 || len(diff.Layers) != 2 {
+		t.Fatalf("got diff %+v, want the two layers compared", diff)
+	}
+
+	// the zeros are identical, and the layers are ranked by name
+	for i, layer := range []string{"fc.bias", "fc.weight"} {
+		l := diff.Layers[i]
+		if l.Layer != layer || l.L2Distance != 0 || l.Cosine != 1 || l.RelativeChange == nil || *l.RelativeChange != 0 {
+			t.Errorf("got layer %+v in position %d, want %s unchanged", l, i, layer)
+		}
+	}
+	if blobs.open != 0 {
+		t.Errorf("got %d tensors left open", blobs.open)
+	}
+}
+
+func TestDiffWeightsDisjoint(t *testing.T) {
+	blobs := &memoryBlobs{blobs: make(map[string][]byte)}
+	a := diffModel("a", map[string][2]float32{"conv.weight": {1, 2}, "conv.bias": {1, 0}}, blobs.blobs)
+	b := diffModel("b", map[string][2]float32{"fc.weight": {1, 2}}, blobs.blobs)
+
+	// no layer is compared, so no tensor is read
+	diff, err := diffWeights(a, b, blobs.read, blobs.read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Layers) != 0 {
+		t.Errorf("got layers %+v compared, want none", diff.Layers)
+	}
+	if want := []string{"conv.bias", "conv.weight"}; !reflect.DeepEqual(diff.OnlyInA, want) {
+		t.Errorf("got layers %v only in a, want %v", diff.OnlyInA, want)
+	}
+	if want := []string{"fc.weight"}; !reflect.DeepEqual(diff.OnlyInB, want) {
+		t.Errorf("got layers %v only in b, want %v", diff.OnlyInB, want)
+	}
+}
+
+func TestDiffWeightsRanked(t *testing.T) {
+	blobs := &memoryBlobs{blobs: make(map[string][]byte)}
+	a := diffModel("a", map[string][2]float32{
+		"fc1.weight": {3, 4}, "fc2.weight": {3, 4}, "fc2.bias": {0, 0}, "fc3.weight": {1, 1},
+	}, blobs.blobs)
+	b := diffModel("b", map[string][2]float32{
+		"fc1.weight": {3, 4}, "fc2.weight": {6, 8}, "fc2.bias": {1, 0}, "head.weight": {1, 1},
+	}, blobs.blobs)
+
+	diff, err := diffWeights(a, b, blobs.read, blobs.read)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the layer that was all zeros in a changed the most
+	var ranked []string
+	for _, l := range diff.Layers {
+		ranked = append(ranked, l.Layer)
+	}
+	if want := []string{"fc2.bias", "fc2.weight", "fc1.weight"}; !reflect.DeepEqual(ranked, want) {
+		t.Errorf("got layers ranked %v, want %v", ranked, want)
+	}
+	if !reflect.DeepEqual(diff.OnlyInA, []string{"fc3.weight"}) || !reflect.DeepEqual(diff.OnlyInB, []string{"head.weight"}) {
+		t.Errorf("got layers %v only in a and %v only in b, want fc3.weight and head.weight", diff.OnlyInA, diff.OnlyInB)
+	}
+}
+
+func TestDiffWeightsMissingTensor(t *testing.T) {
+	blobs := &memoryBlobs{blobs: make(map[string][]byte)}
+	a := diffModel("a", map[string][2]float32{"fc.weight": {1, 2}}, blobs.blobs)
+	b := diffModel("b", map[string][2]float32{"fc.weight": {1, 2}}, blobs.blobs)
+	delete(blobs.blobs, "b:fc.weight")
+
+	if _, err := diffWeights(a, b, blobs.read, blobs.read); err == nil {
+		t.Error("got no error, want the missing tensor reported")
+	}
+}
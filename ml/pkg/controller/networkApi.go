@@ -1,11 +1,24 @@
 package controller
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"github.com/diegostock12/kubeml/ml/pkg/model"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 )
 
 // Handle a train request and forward it to the scheduler
@@ -28,24 +41,609 @@ func (c *Controller) train(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// jobs are only continued in place through continueTask
+	continueFrom := req.Options.ContinueFrom
+	req.Options.ContinueFrom = ""
+	req.RecordChange("options.continue_from", continueFrom, req.Options.ContinueFrom,
+		"jobs are only continued through the continue endpoint")
+
+	// the options are filled from the suggestions before they are validated
+	if err := req.Options.ValidateSuggestions(); err != nil {
+		c.logger.Error("Invalid suggested options", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.suggest(w, &req)
+
+	if req.ScratchGB < 0 || req.ScratchGB > c.maxScratchGB {
+		c.logger.Error("Invalid scratch volume size",
+			zap.Int("sizeGB", req.ScratchGB),
+			zap.Int("maxSizeGB", c.maxScratchGB))
+		http.Error(w, fmt.Sprintf("scratch volume size must be between 0 and %d GB", c.maxScratchGB), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.ValidateNormalization(); err != nil {
+		c.logger.Error("Invalid normalization stats", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.ValidateTags(); err != nil {
+		c.logger.Error("Invalid tags", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the command is redacted again in case it was not sent by the cli
+	req.Command = api.RedactCommand(req.Command)
+
+	if err := req.Options.ValidateMetricDirection(); err != nil {
+		c.logger.Error("Invalid metric direction", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateStopRules(); err != nil {
+		c.logger.Error("Invalid stop rules", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateStallGuard(); err != nil {
+		c.logger.Error("Invalid stall guard", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateMetrics(); err != nil {
+		c.logger.Error("Invalid metrics", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateRetention(); err != nil {
+		c.logger.Error("Invalid checkpoint retention", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateInvocation(); err != nil {
+		c.logger.Error("Invalid invocation mode", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateOutageGrace(); err != nil {
+		c.logger.Error("Invalid redis outage grace", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateValidationModel(); err != nil {
+		c.logger.Error("Invalid validation model", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateValidationFailurePolicy(); err != nil {
+		c.logger.Error("Invalid validation failure policy", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateAccuracyMilestones(); err != nil {
+		c.logger.Error("Invalid accuracy milestones", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateMaxEpochCost(); err != nil {
+		c.logger.Error("Invalid max epoch cost", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := model.ValidateMergeOperator(req.Options.MergeOperator); err != nil {
+		c.logger.Error("Invalid merge operator", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateByzantineTolerance(); err != nil {
+		c.logger.Error("Invalid byzantine tolerance", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateDurationBuckets(); err != nil {
+		c.logger.Error("Invalid duration buckets", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateStragglerTimeout(); err != nil {
+		c.logger.Error("Invalid straggler timeout", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateLossReduction(); err != nil {
+		c.logger.Error("Invalid loss reduction", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateMaxParallelism(); err != nil {
+		c.logger.Error("Invalid max parallelism", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidatePolicyWindow(); err != nil {
+		c.logger.Error("Invalid policy window", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateLayerSync(); err != nil {
+		c.logger.Error("Invalid layer sync overrides", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateAdaptiveK(); err != nil {
+		c.logger.Error("Invalid adaptive K", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateSyncTopology(); err != nil {
+		c.logger.Error("Invalid sync topology", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateQuietMargin(); err != nil {
+		c.logger.Error("Invalid quiet margin", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateGoalScale(); err != nil {
+		c.logger.Error("Invalid goal accuracy scale", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateGlobalBatch(); err != nil {
+		c.logger.Error("Invalid global batch size", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateDevices(); err != nil {
+		c.logger.Error("Invalid devices per function", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateStartupProbe(); err != nil {
+		c.logger.Error("Invalid startup probe", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Options.ValidateClassWeights(); err != nil {
+		c.logger.Error("Invalid class weights", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// with a global batch the batch size of the request
+	// is the batch of the functions in the first epoch
+	if req.Options.GlobalBatchSize > 0 {
+		batchSize := req.BatchSize
+		req.BatchSize = req.FunctionBatchSize(req.Options.DefaultParallelism * req.Options.FunctionDevices())
+		req.RecordChange("batch_size", batchSize, req.BatchSize,
+			fmt.Sprintf("global batch of %d split among %d functions with %d devices each",
+				req.Options.GlobalBatchSize, req.Options.DefaultParallelism, req.Options.FunctionDevices()))
+	}
+
+	if err := api.ValidateLogLevel(req.Options.LogLevel); err != nil {
+		c.logger.Error("Invalid log level", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := api.ValidateCheckpointBackend(req.Options.CheckpointBackend); err != nil {
+		c.logger.Error("Invalid checkpoint backend", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := c.setResumeBackend(&req); err != nil {
+		c.logger.Error("Could not resume from job", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := c.resolveClassWeights(&req); err != nil {
+		c.logger.Error("Could not resolve the class weights", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := c.checkTestSplit(&req); err != nil {
+		c.logger.Error("Dataset has no test split", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.attachRecommendation(&req)
+	c.submitTrain(w, &req)
+}
+
+// continueTask trains a completed job for more epochs in place. The request of the
+// job is rebuilt from its history so the new run resumes from its last checkpoint,
+// keeps its id and appends the new epochs to the same history
+func (c *Controller) continueTask(w http.ResponseWriter, r *http.Request) {
+	jobId := mux.Vars(r)["jobId"]
+
+	var cont api.ContinueRequest
+	if err := json.NewDecoder(r.Body).Decode(&cont); err != nil {
+		c.logger.Error("Could not parse the continue request", zap.Error(err))
+		http.Error(w, "Failed to decode the request", http.StatusBadRequest)
+		return
+	}
+
+	var history api.History
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	err := collection.FindOne(context.TODO(), bson.M{"_id": jobId}).Decode(&history)
+	if err != nil {
+		c.logger.Error("Could not find history", zap.String("jobId", jobId), zap.Error(err))
+		http.Error(w, fmt.Sprintf("could not find the history of job %s", jobId), http.StatusNotFound)
+		return
+	}
+	history.Migrate()
+
+	req, err := history.Continue(cont.Epochs)
+	if err != nil {
+		c.logger.Error("Job can not be continued", zap.String("jobId", jobId), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// two continues can read the history as completed before either is scheduled,
+	// so only the one that claims the history is submitted
+	claimed, err := c.claimContinue(&history)
+	if err != nil {
+		c.logger.Error("Could not claim the history", zap.String("jobId", jobId), zap.Error(err))
+		http.Error(w, "could not claim the history of the job", http.StatusInternalServerError)
+		return
+	}
+	if !claimed {
+		http.Error(w, fmt.Sprintf("job %s is already being continued", jobId), http.StatusConflict)
+		return
+	}
+
+	c.logger.Info("Continuing job",
+		zap.String("jobId", jobId),
+		zap.Int("trained", history.Data.Epochs()),
+		zap.Int("epochs", cont.Epochs))
+
+	// the cached results are from the weights about to be trained again. Nothing is
+	// cached while the job trains, and the results cached after it finishes are
+	// for a new version of the model, see modelVersion
+	if c.inferCache != nil {
+		c.inferCache.invalidate(jobId)
+	}
+	if !c.submitTrain(w, req) {
+		c.releaseContinue(jobId)
+	}
+}
+
+// continueClaimFilter matches the history if it was not changed since it was read
+// and no other continue holds a claim on it newer than ContinueClaimTimeout
+func continueClaimFilter(history *api.History, now time.Time) bson.M {
+	filter := bson.M{
+		"_id":        history.Id,
+		"inprogress": bson.M{"$ne": true},
+		"continuing": bson.M{"$not": bson.M{"$gt": now.Add(-api.ContinueClaimTimeout)}},
+	}
+	// the histories saved by older versions have no finish time
+	if history.Finished.IsZero() {
+		filter["finished"] = bson.M{"$in": bson.A{nil, time.Time{}}}
+	} else {
+		filter["finished"] = history.Finished
+	}
+	return filter
+}
+
+// claimContinue marks the history as being continued in a single update, which
+// fails if another continue claimed it first. The claim is cleared once the
+// continued run writes its history, which replaces the document
+func (c *Controller) claimContinue(history *api.History) (bool, error) {
+	now := time.Now()
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	res, err := collection.UpdateOne(context.TODO(), continueClaimFilter(history, now),
+		bson.M{"$set": bson.M{"continuing": now}})
+	if err != nil {
+		return false, errors.Wrap(err, "could not update history")
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// releaseContinue clears the claim of a continue that could not be submitted
+func (c *Controller) releaseContinue(jobId string) {
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	_, err := collection.UpdateOne(context.TODO(), bson.M{"_id": jobId},
+		bson.M{"$set": bson.M{"continuing": time.Time{}}})
+	if err != nil {
+		c.logger.Warn("Could not release the continue of the job, it expires on its own",
+			zap.String("jobId", jobId),
+			zap.Error(err))
+	}
+}
+
+// submitTrain checks that the function of the request exists and that the request
+// is within the admission limits, and forwards it to the scheduler, responding
+// with the id of the job. Returns whether the request was submitted
+func (c *Controller) submitTrain(w http.ResponseWriter, req *api.TrainRequest) bool {
+	if err := c.checkFunction(req.FunctionName); err != nil {
+		c.logger.Error("Invalid function", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	if err := c.checkAdmissionLimits(req); err != nil {
+		c.logger.Error("Train request exceeds the admission limits", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	if err := c.setNotifySecret(w, req); err != nil {
+		c.logger.Error("Invalid notification url", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	c.planIterations(w, req)
+	req.SnapshotOptions()
+
 	// TODO filter if the dataset exists before submitting
 
 	// Forward the request to the scheduler
-	id, err := c.scheduler.SubmitTrainTask(req)
+	id, err := c.scheduler.SubmitTrainTask(*req)
 	if err != nil {
 		c.logger.Error("Could not get job id",
 			zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	c.logger.Debug("got job id", zap.String("id", id))
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte(id))
+	return true
+}
+
+// setNotifySecret checks the notification url of the request and generates the secret
+// used to sign its notifications, which is only returned to the client in the headers
+func (c *Controller) setNotifySecret(w http.ResponseWriter, req *api.TrainRequest) error {
+	req.NotifySecret = ""
+	if len(req.Options.NotifyURL) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(req.Options.NotifyURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("notification url \"%s\" should be an absolute http or https url", req.Options.NotifyURL)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return errors.Wrap(err, "could not generate notification secret")
+	}
+	req.NotifySecret = hex.EncodeToString(secret)
+	w.Header().Set(api.HeaderNotifySecret, req.NotifySecret)
+	return nil
+}
+
+// resolveClassWeights sets the class weights of a request that uses them, taking the
+// ones of the dataset if the request does not set any, and checks that there is one
+// for each class of the dataset. The weights are kept in the request so they are
+// recorded in the task of the job and sent to all its functions
+func (c *Controller) resolveClassWeights(req *api.TrainRequest) error {
+	if !req.Options.UseClassWeights {
+		return nil
+	}
+
+	stats, err := c.storage.Stats(context.Background(), req.Dataset)
+	if err != nil {
+		return errors.Wrapf(err, "could not read the classes of dataset %s", req.Dataset)
+	}
+	if len(req.Options.ClassWeights) > 0 {
+		return api.CheckClassWeights(req.Options.ClassWeights, len(stats.ClassCounts))
+	}
+
+	weights, source, err := stats.ResolveClassWeights()
+	if err != nil {
+		return err
+	}
+	if err = api.CheckClassWeights(weights, len(stats.ClassCounts)); err != nil {
+		return errors.Wrapf(err, "invalid %s class weights of dataset %s", source, req.Dataset)
+	}
+
+	c.logger.Debug("Resolved class weights",
+		zap.String("dataset", req.Dataset),
+		zap.String("source", source),
+		zap.Float64s("weights", weights))
+	original := req.Options.ClassWeights
+	req.Options.ClassWeights = weights
+	req.RecordChange("options.class_weights", original, req.Options.ClassWeights,
+		fmt.Sprintf("%s class weights of dataset %s", source, req.Dataset))
+	return nil
+}
+
+// checkTestSplit rejects the jobs on a dataset uploaded without a test split, whose
+// validation functions would fail to load it. Every job validates its final model,
+// so all of them need one. If the stats of the dataset can't be read the check is
+// skipped, and the job records its validations as having no data instead
+func (c *Controller) checkTestSplit(req *api.TrainRequest) error {
+	stats, err := c.storage.Stats(context.Background(), req.Dataset)
+	if err != nil {
+		c.logger.Warn("Could not read the stats of the dataset, skipping the test split check",
+			zap.String("dataset", req.Dataset),
+			zap.Error(err))
+		return nil
+	}
+	if stats.TestSetSize > 0 {
+		return nil
+	}
+
+	reason := "the final model of every job is validated"
+	switch {
+	case req.Options.ValidateEvery > 0:
+		reason = fmt.Sprintf("the job validates every %d epochs", req.Options.ValidateEvery)
+	case req.Options.GoalAccuracy < api.NoGoalAccuracy:
+		reason = fmt.Sprintf("the job stops at a goal accuracy of %v", req.Options.GoalAccuracy)
+	}
+	return fmt.Errorf("dataset %s has an empty test split and %s, upload the dataset again with a test split",
+		req.Dataset, reason)
+}
+
+// setResumeBackend looks up the history of the job the request resumes from and
+// sets the backend its last checkpoint is read from. Jobs checkpointed in object
+// storage are resumed from their bucket and the rest from the model kept in redis
+func (c *Controller) setResumeBackend(req *api.TrainRequest) error {
+	original := req.Options.ResumeBackend
+	req.Options.ResumeBackend = ""
+	if len(req.Options.ResumeFrom) == 0 {
+		req.RecordChange("options.resume_backend", original, req.Options.ResumeBackend,
+			"the request does not resume from a job")
+		return nil
+	}
+
+	var history api.History
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	err := collection.FindOne(context.TODO(), bson.M{"_id": req.Options.ResumeFrom}).Decode(&history)
+	if err != nil {
+		return fmt.Errorf("could not find the history of job %s", req.Options.ResumeFrom)
+	}
+
+	if history.Task.ModelType != req.ModelType {
+		return fmt.Errorf("job %s trained a %s model, not a %s",
+			history.Id, history.Task.ModelType, req.ModelType)
+	}
+
+	backend := history.Task.Options.CheckpointBackend
+	if api.IsObjectStorage(backend) && history.Data.CheckpointEpoch > 0 {
+		req.Options.ResumeBackend = backend
+	} else {
+		req.Options.ResumeBackend = api.CheckpointBackendRedis
+	}
+	req.RecordChange("options.resume_backend", original, req.Options.ResumeBackend,
+		fmt.Sprintf("where the last checkpoint of job %s is kept", history.Id))
+	return nil
+}
+
+// planIterations computes the merges per epoch of the request from the size of the
+// dataset and saves them in the request, so they are kept in the history of the job.
+// They are returned in the headers of the response along with the warning about K
+func (c *Controller) planIterations(w http.ResponseWriter, req *api.TrainRequest) {
+	shards, err := c.mongoClient.Database(req.Dataset).Collection(CollectionTrain).
+		EstimatedDocumentCount(context.Background(), nil)
+	if err != nil {
+		c.logger.Warn("Could not count the shards of the dataset, skipping the iteration plan",
+			zap.String("dataset", req.Dataset),
+			zap.Error(err))
+		return
+	}
+
+	req.DatasetShards = shards
+	req.PlannedIterations = api.IterationsPerEpoch(shards, req.StepBatchSize(), req.Options.DefaultParallelism, req.Options.SyncPeriod())
+	w.Header().Set(api.HeaderIterationsPerEpoch, strconv.Itoa(req.PlannedIterations))
+
+	if warning := api.IterationsWarning(req.Options.SyncPeriod(), req.PlannedIterations, c.maxIterations); len(warning) > 0 {
+		c.logger.Warn("Train request with a misconfigured K",
+			zap.Int("K", req.Options.SyncPeriod()),
+			zap.Int("iterations", req.PlannedIterations),
+			zap.String("warning", warning))
+		w.Header().Set(api.HeaderWarning, warning)
+	}
+}
+
+// checkInput compares the datapoints of a request that asks for it with the stats
+// of the train set of each of its models. Mismatches are returned as a warning, or
+// as an error if the request is strict. The check is skipped with a warning if the
+// stats can't be read, so inference does not depend on the storage service
+// or on the history of the models
+func (c *Controller) checkInput(w http.ResponseWriter, req *api.InferRequest) error {
+	if !req.CheckInput && !req.StrictInput {
+		return nil
+	}
+
+	for _, modelId := range req.Models() {
+		if err := c.checkModelInput(w, req, modelId); err != nil {
+			if req.IsEnsemble() {
+				return errors.Wrapf(err, "model %s", modelId)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// checkModelInput checks the datapoints of the request against the train set of the model
+func (c *Controller) checkModelInput(w http.ResponseWriter, req *api.InferRequest, modelId string) error {
+	history, err := c.histories.get(modelId)
+	if err != nil || history == nil {
+		c.logger.Warn("Could not find the history of the model, skipping the input check",
+			zap.String("modelId", modelId),
+			zap.Error(err))
+		w.Header().Add(api.HeaderWarning, "input not checked, could not find the history of model "+modelId)
+		return nil
+	}
+
+	stats, err := c.storage.Stats(context.Background(), history.Task.Dataset)
+	if err != nil {
+		c.logger.Warn("Could not read the stats of the dataset, skipping the input check",
+			zap.String("dataset", history.Task.Dataset),
+			zap.Error(err))
+		w.Header().Add(api.HeaderWarning, "input not checked, could not read the stats of dataset "+history.Task.Dataset)
+		return nil
+	}
+	inputStats, ok := stats.InputStats()
+	if !ok {
+		w.Header().Add(api.HeaderWarning, "input not checked, dataset "+history.Task.Dataset+" has no numeric features")
+		return nil
+	}
+
+	if err = inputStats.CheckInput(req.Data); err != nil {
+		if req.StrictInput {
+			return err
+		}
+		c.logger.Warn("Inference input does not match the train set",
+			zap.String("modelId", modelId),
+			zap.Error(err))
+		w.Header().Add(api.HeaderWarning, err.Error())
+	}
+	return nil
 }
 
 // infer gets an Inference request from the client
-// and simply sends the query to the scheduler
+// and simply sends the query to the scheduler.
+//
+// If the inference cache is enabled the results are looked up and saved in
+// it, unless the request sets the noCache query parameter or allows partial
+// results, which are answered with the number of datapoints that succeeded
+// and failed in the headers.
+//
+// Requests with more datapoints than the inference chunk size, or that accept
+// JSON lines, are split in chunks and their predictions streamed without
+// going through the cache, see streamInference. Ensembles are sent to each
+// of their models and answered at once, see ensembleInference
 func (c *Controller) infer(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -57,7 +655,91 @@ func (c *Controller) infer(w http.ResponseWriter, r *http.Request) {
 
 	// Instead of unmarshalling and marshalling again the
 	// request, send the body as is to improve performance
-	resp, err := c.scheduler.SubmitInferenceTask(body)
+	contentType := r.Header.Get("Content-Type")
+	if len(contentType) == 0 {
+		contentType = util.ContentTypeJSON
+	}
+
+	// if the request can't be decoded it is sent as is
+	// so the error is returned by the scheduler
+	var req api.InferRequest
+	decodeErr := util.Decode(contentType, body, &req)
+	if decodeErr == nil {
+		if err := api.ValidateOutputType(req.OutputType); err != nil {
+			c.logger.Error("Invalid output type", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := req.ValidateEnsemble(); err != nil {
+			c.logger.Error("Invalid ensemble", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := c.checkInput(w, &req); err != nil {
+			c.logger.Error("Invalid inference input", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	// the inferences on a model still being trained run on its last snapshot
+	var snapshot *api.LiveSnapshot
+	if decodeErr == nil && !req.IsEnsemble() {
+		snapshot, err = c.liveSnapshot(req.ModelId)
+		if err != nil {
+			c.logger.Error("Could not get the snapshot of the model",
+				zap.String("modelId", req.ModelId),
+				zap.Error(err))
+			code := http.StatusInternalServerError
+			if e, ok := err.(kerror.Error); ok {
+				code = e.Code
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+	}
+	if snapshot != nil {
+		release, ok := c.routeLive(w, &req, snapshot)
+		if !ok {
+			return
+		}
+		defer release()
+
+		body, contentType, err = util.Encode(req.Serialization, &req)
+		if err != nil {
+			c.logger.Error("Could not encode inference request", zap.Error(err))
+			http.Error(w, "Failed to route request to the snapshot", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if decodeErr == nil && req.IsEnsemble() {
+		c.ensembleInference(w, &req)
+		return
+	}
+	if ndjson := wantsNDJSON(r); decodeErr == nil && (ndjson || len(req.Data) > c.inferChunkSize) {
+		c.streamInference(w, &req, ndjson)
+		return
+	}
+
+	var key, version string
+	noCache, _ := strconv.ParseBool(r.URL.Query().Get("noCache"))
+	if c.inferCache != nil && !noCache && !req.AllowPartial && snapshot == nil {
+		err = decodeErr
+		if err == nil {
+			version, err = c.modelVersion(req.ModelId)
+		}
+		if err == nil {
+			key, err = cacheKey(&req, version)
+		}
+		if err != nil {
+			c.logger.Warn("Could not compute cache key, skipping cache", zap.Error(err))
+		} else if entry, hit := c.inferCache.get(key); hit {
+			c.respondCached(w, entry, req.Serialization)
+			return
+		}
+	}
+
+	resp, respType, err := c.scheduler.SubmitInferenceTask(body, contentType)
 	if err != nil {
 		c.logger.Error("Could not get job id",
 			zap.Error(err))
@@ -65,8 +747,73 @@ func (c *Controller) infer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c.logger.Debug("got response", zap.String("predictions", string(resp)))
+	c.logger.Debug("got response", zap.Int("size", len(resp)))
+	if len(respType) == 0 {
+		respType = util.ContentTypeJSON
+	}
+
+	if len(key) > 0 {
+		c.cacheResponse(key, req.ModelId, version, resp, respType)
+	}
+
+	if req.AllowPartial {
+		if result, err := decodeInferResult(respType, resp); err != nil {
+			c.logger.Warn("Could not count the datapoints of a partial result", zap.Error(err))
+		} else {
+			w.Header().Set(api.HeaderInferSucceeded, strconv.Itoa(len(result.Predictions)))
+			w.Header().Set(api.HeaderInferFailed, strconv.Itoa(len(result.Failed)))
+		}
+	}
+
+	w.Header().Set("Content-Type", respType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// respondCached sends a cached inference result, marking it as cached. The result
+// is encoded in the format asked by the request, which might not be the one
+// used when it was cached
+func (c *Controller) respondCached(w http.ResponseWriter, entry *cacheEntry, serialization string) {
+	var result map[string]interface{}
+	err := util.Decode(entry.contentType, entry.body, &result)
+	if err != nil {
+		c.logger.Error("Could not decode cached result", zap.Error(err))
+		http.Error(w, "Failed to read cached result", http.StatusInternalServerError)
+		return
+	}
+	result["cached"] = true
+
+	resp, contentType, err := util.Encode(serialization, result)
+	if err != nil {
+		c.logger.Error("Could not encode cached result", zap.Error(err))
+		http.Error(w, "Failed to send cached result", http.StatusInternalServerError)
+		return
+	}
+
+	c.logger.Debug("returning cached result", zap.Int("size", len(resp)))
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
 	w.Write(resp)
 }
+
+// cacheResponse saves the inference result in the cache. Errors returned by the
+// function are not cached, and neither are the results of models that are still
+// being trained, since their weights change after every epoch
+func (c *Controller) cacheResponse(key, modelId, version string, resp []byte, contentType string) {
+	var result map[string]interface{}
+	if err := util.Decode(contentType, resp, &result); err != nil {
+		return
+	}
+	if _, ok := result["predictions"]; !ok {
+		return
+	}
+
+	// the job of a model is only found in the ps while it is training
+	_, err := c.ps.GetTask(modelId)
+	if e, ok := err.(kerror.Error); !ok || e.Code != http.StatusNotFound {
+		c.inferCache.invalidate(modelId)
+		return
+	}
+
+	c.inferCache.add(key, modelId, version, resp, contentType)
+}
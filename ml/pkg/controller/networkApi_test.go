@@ -0,0 +1,235 @@
+package controller
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/storage/client/fake"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContinueClaimFilter(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	finished := now.Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		finished time.Time
+		want     interface{}
+	}{
+		{"finished", finished, finished},
+		{"saved by an older version", time.Time{}, bson.M{"$in": bson.A{nil, time.Time{}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := continueClaimFilter(&api.History{Id: "job", Finished: tt.finished}, now)
+			want := bson.M{
+				"_id":        "job",
+				"inprogress": bson.M{"$ne": true},
+				"continuing": bson.M{"$not": bson.M{"$gt": now.Add(-api.ContinueClaimTimeout)}},
+				"finished":   tt.want,
+			}
+			if !reflect.DeepEqual(filter, want) {
+				t.Errorf("got filter %v, want %v", filter, want)
+			}
+		})
+	}
+}
+
+func TestContinueClaimFilterFields(t *testing.T) {
+	// the filter and the claim use the names the histories are saved with
+	doc, err := bson.Marshal(api.History{Id: "job"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved bson.M
+	if err = bson.Unmarshal(doc, &saved); err != nil {
+		t.Fatal(err)
+	}
+	for field := range continueClaimFilter(&api.History{Id: "job", Finished: time.Now()}, time.Now()) {
+		if _, exists := saved[field]; !exists {
+			t.Errorf("got field %s in the filter, which the history is not saved with", field)
+		}
+	}
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+// newStorageController returns a controller reading the datasets from the fake storage
+func newStorageController(storage *fake.Storage, histories ...api.History) *Controller {
+	fakeH := &fakeHistories{histories: make(map[string]api.History), broken: map[string]bool{"broken": true}}
+	for _, h := range histories {
+		fakeH.histories[h.Id] = h
+	}
+	return &Controller{
+		logger:    zap.NewNop(),
+		storage:   storage,
+		histories: fakeH,
+	}
+}
+
+func TestResolveClassWeights(t *testing.T) {
+	skewed := api.DatasetStats{
+		DatasetSummary: api.DatasetSummary{Name: "skewed"},
+		ClassCounts:    []int64{90, 10, 0},
+	}
+	explicit := api.DatasetStats{
+		DatasetSummary: api.DatasetSummary{Name: "explicit"},
+		ClassCounts:    []int64{50, 50},
+		ClassWeights:   []float64{1, 3},
+	}
+	regression := api.DatasetStats{DatasetSummary: api.DatasetSummary{Name: "regression"}}
+	mismatched := api.DatasetStats{
+		DatasetSummary: api.DatasetSummary{Name: "mismatched"},
+		ClassCounts:    []int64{50, 50},
+		ClassWeights:   []float64{1, 2, 3},
+	}
+
+	tests := []struct {
+		name      string
+		dataset   string
+		use       bool
+		weights   []float64
+		want      []float64
+		changed   bool
+		wantError bool
+	}{
+		{name: "not used", dataset: "skewed"},
+		{name: "computed from a skewed distribution", dataset: "skewed", use: true, want: []float64{100.0 / 180, 100.0 / 20, 0}, changed: true},
+		{name: "set for the dataset", dataset: "explicit", use: true, want: []float64{1, 3}, changed: true},
+		{name: "set in the request", dataset: "explicit", use: true, weights: []float64{2, 1}, want: []float64{2, 1}},
+		{name: "one per class missing in the request", dataset: "skewed", use: true, weights: []float64{1, 2}, wantError: true},
+		{name: "invalid weights of the dataset", dataset: "mismatched", use: true, wantError: true},
+		{name: "labels not class indices", dataset: "regression", use: true, wantError: true},
+		{name: "unknown dataset", dataset: "missing", use: true, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := fake.NewStorage(skewed, explicit, regression, mismatched)
+			c := newStorageController(storage)
+			req := &api.TrainRequest{Dataset: tt.dataset}
+			req.Options.UseClassWeights = tt.use
+			req.Options.ClassWeights = tt.weights
+
+			err := c.resolveClassWeights(req)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if tt.wantError {
+				return
+			}
+			if !reflect.DeepEqual(req.Options.ClassWeights, tt.want) {
+				t.Errorf("got weights %v, want %v", req.Options.ClassWeights, tt.want)
+			}
+			if changed := req.Effective != nil && len(req.Effective.Changes) > 0; changed != tt.changed {
+				t.Errorf("got the change recorded %v, want %v", changed, tt.changed)
+			}
+			if !tt.use && storage.Calls["Stats"] != 0 {
+				t.Error("got the stats read for a job without class weights")
+			}
+		})
+	}
+}
+
+func TestCheckTestSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		dataset string
+		opts    api.TrainOptions
+		reason  string
+	}{
+		{name: "test split", dataset: "mnist"},
+		{name: "unknown dataset", dataset: "missing"},
+		{name: "final validation", dataset: "empty", opts: api.TrainOptions{GoalAccuracy: api.NoGoalAccuracy}, reason: "the final model of every job is validated"},
+		{name: "periodic validation", dataset: "empty", opts: api.TrainOptions{ValidateEvery: 2, GoalAccuracy: 90}, reason: "validates every 2 epochs"},
+		{name: "goal accuracy", dataset: "empty", opts: api.TrainOptions{GoalAccuracy: 90}, reason: "goal accuracy of 90"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newStorageController(fake.NewStorage(
+				api.DatasetStats{DatasetSummary: api.DatasetSummary{Name: "mnist", TrainSetSize: 100, TestSetSize: 10}},
+				api.DatasetStats{DatasetSummary: api.DatasetSummary{Name: "empty", TrainSetSize: 100}},
+			))
+			err := c.checkTestSplit(&api.TrainRequest{Dataset: tt.dataset, Options: tt.opts})
+			if tt.reason == "" {
+				if err != nil {
+					t.Errorf("got error %v, want the job admitted", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("got error %v, want it to contain %q", err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestCheckTestSplitStorageUnreachable(t *testing.T) {
+	storage := fake.NewStorage()
+	storage.Err = errors.New("storage unavailable")
+	c := newStorageController(storage)
+
+	// the check is skipped and the job admitted
+	if err := c.checkTestSplit(&api.TrainRequest{Dataset: "mnist"}); err != nil {
+		t.Errorf("got error %v, want the check skipped", err)
+	}
+}
+
+func TestCheckModelInput(t *testing.T) {
+	pixels := api.DatasetStats{
+		DatasetSummary: api.DatasetSummary{Name: "mnist"},
+		FeatureMin:     floatPtr(0),
+		FeatureMax:     floatPtr(1),
+		FeatureDtype:   "float32",
+	}
+	texts := api.DatasetStats{DatasetSummary: api.DatasetSummary{Name: "texts"}}
+	histories := []api.History{
+		{Id: "model", Task: api.TrainRequest{Dataset: "mnist"}},
+		{Id: "texts", Task: api.TrainRequest{Dataset: "texts"}},
+		{Id: "deleted", Task: api.TrainRequest{Dataset: "deleted"}},
+	}
+
+	tests := []struct {
+		name      string
+		modelId   string
+		data      []interface{}
+		strict    bool
+		warning   string
+		wantError bool
+	}{
+		{name: "in range", modelId: "model", data: []interface{}{[]interface{}{0.0, 0.5, 1.0}}},
+		{name: "out of range", modelId: "model", data: []interface{}{[]interface{}{0.0, 255.0}}, warning: "outside of the range"},
+		{name: "out of range strict", modelId: "model", data: []interface{}{[]interface{}{0.0, 255.0}}, strict: true, wantError: true},
+		{name: "unknown model", modelId: "missing", warning: "could not find the history of model missing"},
+		{name: "history unavailable", modelId: "broken", strict: true, warning: "could not find the history of model broken"},
+		{name: "dataset deleted", modelId: "deleted", strict: true, warning: "could not read the stats of dataset deleted"},
+		{name: "features not numeric", modelId: "texts", strict: true, warning: "dataset texts has no numeric features"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newStorageController(fake.NewStorage(pixels, texts), histories...)
+			w := httptest.NewRecorder()
+			req := &api.InferRequest{ModelId: tt.modelId, Data: tt.data, CheckInput: true, StrictInput: tt.strict}
+
+			err := c.checkModelInput(w, req, tt.modelId)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			warning := w.Header().Get(api.HeaderWarning)
+			if (tt.warning == "") != (warning == "") || !strings.Contains(warning, tt.warning) {
+				t.Errorf("got warning %q, want %q", warning, tt.warning)
+			}
+		})
+	}
+}
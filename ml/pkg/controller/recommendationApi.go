@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// recommendationsCollection holds the parallelism recommended for each function
+// and dataset, written by the jobs when they finish
+const recommendationsCollection = "recommendations"
+
+// findRecommendation returns the parallelism recommended for the function and
+// the dataset by the last job that trained them, nil if there is none
+func (c *Controller) findRecommendation(function, dataset string) (*api.ParallelismRecommendation, error) {
+	var recommendation api.ParallelismRecommendation
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(recommendationsCollection)
+	err := collection.FindOne(context.TODO(), bson.M{"_id": api.RecommendationId(function, dataset)}).Decode(&recommendation)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &recommendation, nil
+}
+
+// attachRecommendation attaches the recommended parallelism of the function and
+// the dataset to the request, so the scheduler can start the job from it. A
+// recommendation that can't be read is skipped, since it is only a hint
+func (c *Controller) attachRecommendation(req *api.TrainRequest) {
+	recommendation, err := c.findRecommendation(req.FunctionName, req.Dataset)
+	if err != nil {
+		c.logger.Warn("Could not read the recommended parallelism, skipping it",
+			zap.String("function", req.FunctionName),
+			zap.String("dataset", req.Dataset),
+			zap.Error(err))
+		return
+	}
+	req.Recommendation = recommendation
+}
+
+// getRecommendation returns the parallelism recommended for the function and the dataset
+func (c *Controller) getRecommendation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	function, dataset := vars["function"], vars["dataset"]
+
+	recommendation, err := c.findRecommendation(function, dataset)
+	if err != nil {
+		c.logger.Error("Could not read the recommended parallelism", zap.Error(err))
+		http.Error(w, "Could not read the recommended parallelism", http.StatusInternalServerError)
+		return
+	}
+	if recommendation == nil {
+		http.Error(w, "No parallelism was recommended for the function and the dataset yet", http.StatusNotFound)
+		return
+	}
+
+	resp, err := json.Marshal(recommendation)
+	if err != nil {
+		c.logger.Error("Could not marshal recommendation", zap.Error(err))
+		http.Error(w, "Error marshaling request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// statsCacheTTL is the time an overview is served from the cache,
+// which bounds the aggregations run on the database
+const statsCacheTTL = time.Minute
+
+type (
+	// statsCache keeps the last overview computed for each window and top size
+	statsCache struct {
+		mu      sync.Mutex
+		entries map[string]*statsCacheEntry
+	}
+
+	statsCacheEntry struct {
+		overview *api.StatsOverview
+		expires  time.Time
+	}
+
+	// statsFacets is the result of the aggregation of the histories
+	statsFacets struct {
+		Summary   []statsSummary   `bson:"summary"`
+		Datasets  []api.StatsEntry `bson:"datasets"`
+		Functions []api.StatsEntry `bson:"functions"`
+	}
+
+	statsSummary struct {
+		Jobs            int64    `bson:"jobs"`
+		Running         int64    `bson:"running"`
+		Failed          int64    `bson:"failed"`
+		FunctionSeconds float64  `bson:"functionseconds"`
+		GoalReached     int64    `bson:"goalreached"`
+		TimeToAccuracy  *float64 `bson:"timetoaccuracy"`
+	}
+)
+
+func newStatsCache() *statsCache {
+	return &statsCache{entries: make(map[string]*statsCacheEntry)}
+}
+
+// get returns the cached overview of the key if it did not expire
+func (sc *statsCache) get(key string) (*api.StatsOverview, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	entry, exists := sc.entries[key]
+	if !exists || time.Now().After(entry.expires) {
+		delete(sc.entries, key)
+		return nil, false
+	}
+	return entry.overview, true
+}
+
+func (sc *statsCache) put(key string, overview *api.StatsOverview) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.entries[key] = &statsCacheEntry{overview: overview, expires: time.Now().Add(statsCacheTTL)}
+}
+
+// getStatsOverview returns the aggregated usage of the cluster in the window given
+// in the query (a duration like 24h, by default a week). The lists of busiest datasets
+// and functions are capped by the top parameter
+func (c *Controller) getStatsOverview(w http.ResponseWriter, r *http.Request) {
+	window := api.DefaultStatsWindow
+	if s := r.URL.Query().Get("window"); len(s) > 0 {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid window \"%s\", expected a positive duration like 24h", s), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+
+	top := api.DefaultStatsTop
+	if s := r.URL.Query().Get("top"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > api.MaxStatsTop {
+			http.Error(w, fmt.Sprintf("top should be between 1 and %d", api.MaxStatsTop), http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+
+	key := fmt.Sprintf("%v/%d", window, top)
+	overview, cached := c.statsCache.get(key)
+	if !cached {
+		var err error
+		overview, err = c.aggregateStats(window, top)
+		if err != nil {
+			c.logger.Error("Could not aggregate the stats of the cluster", zap.Error(err))
+			http.Error(w, "Could not aggregate the stats of the cluster", http.StatusInternalServerError)
+			return
+		}
+		c.statsCache.put(key, overview)
+	}
+
+	resp, err := json.Marshal(overview)
+	if err != nil {
+		c.logger.Error("Could not marshal the overview", zap.Error(err))
+		http.Error(w, "error processing request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// aggregateStats runs the aggregation of the histories of the jobs that started
+// or finished in the window. Histories saved by older versions have no times
+// and are left out, and the missing metrics count as empty
+func (c *Controller) aggregateStats(window time.Duration, top int) (*api.StatsOverview, error) {
+	until := time.Now()
+	since := until.Add(-window)
+
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	cursor, err := collection.Aggregate(context.TODO(), statsPipeline(since, top))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not run aggregation")
+	}
+
+	var results []statsFacets
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return nil, errors.Wrap(err, "could not decode aggregation")
+	}
+
+	overview := &api.StatsOverview{
+		Since:        since,
+		Until:        until,
+		Generated:    until,
+		TopDatasets:  []api.StatsEntry{},
+		TopFunctions: []api.StatsEntry{},
+	}
+	if len(results) == 0 {
+		return overview, nil
+	}
+
+	facets := results[0]
+	if len(facets.Summary) > 0 {
+		summary := facets.Summary[0]
+		overview.Jobs = summary.Jobs
+		overview.Running = summary.Running
+		overview.Failed = summary.Failed
+		overview.Succeeded = summary.Jobs - summary.Running - summary.Failed
+		if finished := summary.Jobs - summary.Running; finished > 0 {
+			overview.SuccessRate = float64(overview.Succeeded) / float64(finished)
+		}
+		overview.FunctionSeconds = summary.FunctionSeconds
+		overview.GoalReached = summary.GoalReached
+		if summary.TimeToAccuracy != nil {
+			overview.AvgTimeToAccuracy = *summary.TimeToAccuracy
+		}
+	}
+	if facets.Datasets != nil {
+		overview.TopDatasets = facets.Datasets
+	}
+	if facets.Functions != nil {
+		overview.TopFunctions = facets.Functions
+	}
+	return overview, nil
+}
+
+// statsPipeline builds the aggregation of the histories. The fields are named as
+// saved by the default bson encoding of api.History, and every field read is
+// wrapped in $ifNull so the histories missing it are still aggregated
+func statsPipeline(since time.Time, top int) mongo.Pipeline {
+	ifNull := func(field string, value interface{}) bson.D {
+		return bson.D{{"$ifNull", bson.A{field, value}}}
+	}
+	byUsage := func(field string) bson.A {
+		return bson.A{
+			bson.D{{"$group", bson.D{
+				{"_id", field},
+				{"jobs", bson.D{{"$sum", 1}}},
+				{"functionseconds", bson.D{{"$sum", "$functionseconds"}}},
+			}}},
+			bson.D{{"$sort", bson.D{{"jobs", -1}, {"functionseconds", -1}, {"_id", 1}}}},
+			bson.D{{"$limit", top}},
+		}
+	}
+
+	// epoch durations times the parallelism of the epoch, which
+	// defaults to one function if the series is shorter
+	functionSeconds := bson.D{{"$reduce", bson.D{
+		{"input", bson.D{{"$zip", bson.D{
+			{"inputs", bson.A{ifNull("$data.epochduration", bson.A{}), ifNull("$data.parallelism", bson.A{})}},
+			{"useLongestLength", true},
+			{"defaults", bson.A{0.0, 1.0}},
+		}}}},
+		{"initialValue", 0.0},
+		{"in", bson.D{{"$add", bson.A{"$$value", bson.D{{"$multiply", bson.A{
+			bson.D{{"$arrayElemAt", bson.A{"$$this", 0}}},
+			bson.D{{"$arrayElemAt", bson.A{"$$this", 1}}},
+		}}}}}}},
+	}}}
+
+	return mongo.Pipeline{
+		{{"$match", bson.D{{"$or", bson.A{
+			bson.D{{"finished", bson.D{{"$gte", since}}}},
+			bson.D{{"started", bson.D{{"$gte", since}}}},
+		}}}}},
+		{{"$project", bson.D{
+			{"dataset", ifNull("$task.dataset", "unknown")},
+			{"function", ifNull("$task.functionname", "unknown")},
+			{"running", ifNull("$inprogress", false)},
+			{"failed", bson.D{{"$gt", bson.A{bson.D{{"$strLenCP", ifNull("$error", "")}}, 0}}}},
+			{"functionseconds", functionSeconds},
+			{"duration", bson.D{{"$sum", ifNull("$data.epochduration", bson.A{})}}},
+			{"goalreached", bson.D{{"$gte", bson.A{
+				bson.D{{"$max", ifNull("$data.accuracy", bson.A{})}},
+				ifNull("$task.options.goalaccuracy", 100),
+			}}}},
+		}}},
+		{{"$facet", bson.D{
+			{"summary", bson.A{
+				bson.D{{"$group", bson.D{
+					{"_id", nil},
+					{"jobs", bson.D{{"$sum", 1}}},
+					{"running", bson.D{{"$sum", bson.D{{"$cond", bson.A{"$running", 1, 0}}}}}},
+					{"failed", bson.D{{"$sum", bson.D{{"$cond", bson.A{
+						bson.D{{"$and", bson.A{bson.D{{"$not", bson.A{"$running"}}}, "$failed"}}}, 1, 0,
+					}}}}}},
+					{"functionseconds", bson.D{{"$sum", "$functionseconds"}}},
+					{"goalreached", bson.D{{"$sum", bson.D{{"$cond", bson.A{"$goalreached", 1, 0}}}}}},
+					{"timetoaccuracy", bson.D{{"$avg", bson.D{{"$cond", bson.A{"$goalreached", "$duration", nil}}}}}},
+				}}},
+			}},
+			{"datasets", byUsage("$dataset")},
+			{"functions", byUsage("$function")},
+		}}},
+	}
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// statsOverview requests the overview with the query and decodes it
+func statsOverview(mt *mtest.T, c *Controller, query string) (*httptest.ResponseRecorder, *api.StatsOverview) {
+	w := httptest.NewRecorder()
+	c.getStatsOverview(w, httptest.NewRequest(http.MethodGet, "/stats/overview"+query, nil))
+	if w.Code != http.StatusOK {
+		return w, nil
+	}
+	var overview api.StatsOverview
+	if err := json.Unmarshal(w.Body.Bytes(), &overview); err != nil {
+		mt.Fatal(err)
+	}
+	return w, &overview
+}
+
+// aggregations returns the pipelines sent to mongo
+func aggregations(mt *mtest.T) []bson.Raw {
+	var pipelines []bson.Raw
+	for _, evt := range mt.GetAllStartedEvents() {
+		if evt.CommandName == "aggregate" {
+			pipelines = append(pipelines, evt.Command.Lookup("pipeline").Array())
+		}
+	}
+	return pipelines
+}
+
+func TestStatsPipelineFields(t *testing.T) {
+	// every field read by the pipeline is saved with the histories
+	h := api.History{Started: time.Now(), Finished: time.Now(), Error: "failed", InProgress: true}
+	h.Task.Dataset, h.Task.FunctionName = "mnist", "lenet"
+	h.Task.Options.GoalAccuracy = 90
+	h.Data.EpochDuration, h.Data.Parallelism, h.Data.Accuracy = []float64{1}, []float64{1}, []float64{1}
+	doc, err := bson.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipeline, err := bson.MarshalExtJSON(bson.D{{Key: "pipeline", Value: statsPipeline(time.Now(), 5)}}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := []string{"task.dataset", "task.functionname", "task.options.goalaccuracy", "inprogress", "error",
+		"data.epochduration", "data.parallelism", "data.accuracy", "started", "finished"}
+	for _, field := range fields {
+		if !strings.Contains(string(pipeline), field) {
+			t.Errorf("got no field %s in the pipeline", field)
+		}
+		if _, err := bson.Raw(doc).LookupErr(strings.Split(field, ".")...); err != nil {
+			t.Errorf("got no field %s in the saved history: %v", field, err)
+		}
+	}
+}
+
+func TestStatsOverview(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	facets := func(summary bson.D) bson.D {
+		var summaries bson.A
+		if summary != nil {
+			summaries = bson.A{summary}
+		}
+		return bson.D{
+			{Key: "summary", Value: summaries},
+			{Key: "datasets", Value: bson.A{bson.D{{Key: "_id", Value: "mnist"}, {Key: "jobs", Value: 3}, {Key: "functionseconds", Value: 120.0}}}},
+			{Key: "functions", Value: bson.A{}},
+		}
+	}
+	newController := func(mt *mtest.T) *Controller {
+		return &Controller{logger: zap.NewNop(), mongoClient: mt.Client, statsCache: newStatsCache()}
+	}
+
+	mt.Run("summary", func(mt *mtest.T) {
+		// 10 jobs, 2 of them running and 2 failed
+		summary := bson.D{
+			{Key: "jobs", Value: 10}, {Key: "running", Value: 2}, {Key: "failed", Value: 2},
+			{Key: "functionseconds", Value: 300.0}, {Key: "goalreached", Value: 4}, {Key: "timetoaccuracy", Value: 60.0},
+		}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "kubeml.history", mtest.FirstBatch, facets(summary)))
+
+		_, overview := statsOverview(mt, newController(mt), "?window=24h&top=3")
+		if overview == nil {
+			mt.Fatal("got no overview")
+		}
+		if overview.Jobs != 10 || overview.Running != 2 || overview.Succeeded != 6 || overview.Failed != 2 ||
+			math.Abs(overview.SuccessRate-0.75) > 1e-9 {
+			mt.Errorf("got overview %+v, want 6 of 8 finished jobs succeeded", overview)
+		}
+		if overview.FunctionSeconds != 300 || overview.GoalReached != 4 || overview.AvgTimeToAccuracy != 60 {
+			mt.Errorf("got overview %+v, want 300 function seconds and 4 jobs reaching the goal in 60s", overview)
+		}
+		if len(overview.TopDatasets) != 1 || overview.TopDatasets[0].Name != "mnist" || overview.TopFunctions == nil {
+			mt.Errorf("got datasets %+v and functions %+v, want mnist and none", overview.TopDatasets, overview.TopFunctions)
+		}
+		if window := overview.Until.Sub(overview.Since); window != 24*time.Hour {
+			mt.Errorf("got window %v, want 24h", window)
+		}
+
+		// the window and the top size are sent in the pipeline
+		pipelines := aggregations(mt)
+		if len(pipelines) != 1 {
+			mt.Fatalf("got %d aggregations, want 1", len(pipelines))
+		}
+		since, ok := pipelines[0].Index(0).Value().Document().Lookup("$match", "$or", "0", "finished", "$gte").TimeOK()
+		if !ok || since.Unix() != overview.Since.Unix() {
+			mt.Errorf("got jobs matched since %v, want %v", since, overview.Since)
+		}
+		if limit := pipelines[0].Index(2).Value().Document().Lookup("$facet", "datasets", "2", "$limit").Int32(); limit != 3 {
+			mt.Errorf("got limit %d of the datasets, want 3", limit)
+		}
+	})
+
+	mt.Run("no jobs", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "kubeml.history", mtest.FirstBatch))
+		_, overview := statsOverview(mt, newController(mt), "")
+		if overview == nil {
+			mt.Fatal("got no overview")
+		}
+		if overview.Jobs != 0 || overview.SuccessRate != 0 || overview.TopDatasets == nil || overview.TopFunctions == nil {
+			mt.Errorf("got overview %+v, want an empty one", overview)
+		}
+		if window := overview.Until.Sub(overview.Since); window != api.DefaultStatsWindow {
+			mt.Errorf("got window %v, want the default", window)
+		}
+	})
+
+	mt.Run("only running jobs", func(mt *mtest.T) {
+		summary := bson.D{{Key: "jobs", Value: 2}, {Key: "running", Value: 2}, {Key: "timetoaccuracy", Value: nil}}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "kubeml.history", mtest.FirstBatch, facets(summary)))
+		_, overview := statsOverview(mt, newController(mt), "")
+		if overview == nil || overview.Succeeded != 0 || overview.SuccessRate != 0 || overview.AvgTimeToAccuracy != 0 {
+			mt.Errorf("got overview %+v, want no finished jobs", overview)
+		}
+	})
+
+	mt.Run("cached", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "kubeml.history", mtest.FirstBatch, facets(nil)))
+		c := newController(mt)
+		statsOverview(mt, c, "?window=1h")
+		statsOverview(mt, c, "?window=1h")
+		if n := len(aggregations(mt)); n != 1 {
+			mt.Errorf("got %d aggregations, want the second overview from the cache", n)
+		}
+	})
+
+	mt.Run("invalid parameters", func(mt *mtest.T) {
+		c := newController(mt)
+		for _, query := range []string{"?window=week", "?window=-1h", "?top=0", "?top=51", "?top=many"} {
+			if w, _ := statsOverview(mt, c, query); w.Code != http.StatusBadRequest {
+				mt.Errorf("%s: got status %d, want 400", query, w.Code)
+			}
+		}
+		if n := len(aggregations(mt)); n != 0 {
+			mt.Errorf("got %d aggregations, want none", n)
+		}
+	})
+
+	mt.Run("aggregation failed", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "unavailable"}))
+		if w, _ := statsOverview(mt, newController(mt), ""); w.Code != http.StatusInternalServerError {
+			mt.Errorf("got status %d, want 500", w.Code)
+		}
+	})
+}
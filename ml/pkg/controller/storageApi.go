@@ -22,14 +22,19 @@ const CollectionTrain = "train"
 const CollectionTest = "test"
 
 // defaultDatabases shows the admin or non-dataset databases that we will
-// omit when returning the list of datasets
+// omit when returning the list of datasets, along with the kubeml database
 var defaultDatabases = map[string]struct{}{
 	"admin":  {},
 	"config": {},
-	"kubeml": {},
 	"local":  {},
 }
 
+// isDefaultDatabase returns true if the database is not a dataset
+func isDefaultDatabase(name string) bool {
+	_, exists := defaultDatabases[name]
+	return exists || name == util.MongoDatabase()
+}
+
 // storageServiceProxy returns the reverse proxy that the controller
 // uses to redirect all the storage uploads and deletions to the storage service
 func (c *Controller) storageServiceProxy(w http.ResponseWriter, r *http.Request) {
@@ -83,7 +88,7 @@ func (c *Controller) getDataset(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, dataset := range results.Databases {
-		if _, isDefaultDatabase := defaultDatabases[dataset.Name]; !isDefaultDatabase && datasetName == dataset.Name {
+		if !isDefaultDatabase(dataset.Name) && datasetName == dataset.Name {
 			summary := api.DatasetSummary{
 				Name: dataset.Name,
 			}
@@ -145,7 +150,7 @@ func (c *Controller) listDatasets(w http.ResponseWriter, r *http.Request) {
 	// check if the dataset belongs to the admin datasets and omit it
 	// if that's the case
 	for _, dataset := range results.Databases {
-		if _, isDefaultDatabase := defaultDatabases[dataset.Name]; !isDefaultDatabase {
+		if !isDefaultDatabase(dataset.Name) {
 			summary := api.DatasetSummary{
 				Name: dataset.Name,
 			}
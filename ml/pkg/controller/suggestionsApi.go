@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// createSuggestionIndex indexes the histories by function, dataset and start,
+// so the suggestions only read the most recent runs of a request
+func (c *Controller) createSuggestionIndex() error {
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	_, err := collection.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{"task.functionname", 1}, {"task.dataset", 1}, {"started", -1}},
+	})
+	return err
+}
+
+// findSuggestions computes the suggestions for the function and the dataset from
+// their last SuggestionRuns finished runs, reading only the fields they need
+func (c *Controller) findSuggestions(function, dataset string) (*api.Suggestions, error) {
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	opts := options.Find().
+		SetSort(bson.D{{"started", -1}}).
+		SetLimit(api.SuggestionRuns).
+		SetProjection(bson.M{"task.options.k": 1, "data.epochduration": 1, "data.k": 1, "error": 1})
+	cursor, err := collection.Find(context.TODO(),
+		bson.M{"task.functionname": function, "task.dataset": dataset, "inprogress": bson.M{"$ne": true}}, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find the previous runs")
+	}
+	var histories []api.History
+	if err = cursor.All(context.TODO(), &histories); err != nil {
+		return nil, errors.Wrap(err, "could not decode the previous runs")
+	}
+
+	recommendation, err := c.findRecommendation(function, dataset)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read the recommended parallelism")
+	}
+	return api.Suggest(function, dataset, histories, recommendation), nil
+}
+
+// suggest returns the suggestions of the request in the response and fills the options
+// the request takes from them. The suggestions are only a hint, so they are skipped
+// with a warning if they can't be read and the request keeps the options it was sent with
+func (c *Controller) suggest(w http.ResponseWriter, req *api.TrainRequest) {
+	suggestions, err := c.findSuggestions(req.FunctionName, req.Dataset)
+	if err != nil {
+		c.logger.Warn("Could not compute the suggestions, skipping them",
+			zap.String("function", req.FunctionName),
+			zap.String("dataset", req.Dataset),
+			zap.Error(err))
+		return
+	}
+
+	if resp, err := json.Marshal(suggestions); err == nil {
+		w.Header().Set(api.HeaderSuggestions, string(resp))
+	}
+	req.ApplySuggestions(suggestions)
+}
+
+// getSuggestions returns the settings suggested for the function and the dataset
+func (c *Controller) getSuggestions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	function, dataset := vars["function"], vars["dataset"]
+
+	suggestions, err := c.findSuggestions(function, dataset)
+	if err != nil {
+		c.logger.Error("Could not compute the suggestions", zap.Error(err))
+		http.Error(w, "Could not compute the suggestions", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(suggestions)
+	if err != nil {
+		c.logger.Error("Could not marshal suggestions", zap.Error(err))
+		http.Error(w, "Error marshaling request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
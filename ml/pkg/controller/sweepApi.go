@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+// getSweepBest returns the job of a sweep with the best value of a metric, along
+// with the request it was trained with. The jobs of the sweep are the ones whose
+// histories are tagged with its id, including the jobs still training. If a target
+// accuracy is given the jobs are ranked by the time they took to reach it instead
+func (c *Controller) getSweepBest(w http.ResponseWriter, r *http.Request) {
+	sweepId := mux.Vars(r)["sweepId"]
+	metric := r.URL.Query().Get("metric")
+	if len(metric) == 0 {
+		metric = api.MetricAccuracy
+	}
+	var target float64
+	if s := r.URL.Query().Get("target"); len(s) > 0 {
+		var err error
+		if target, err = strconv.ParseFloat(s, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid target accuracy \"%s\"", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	c.logger.Debug("Getting best run of sweep",
+		zap.String("sweepId", sweepId),
+		zap.String("metric", metric))
+
+	var histories []api.History
+	collection := c.mongoClient.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	cursor, err := collection.Find(context.TODO(), bson.M{"task.tags." + api.TagSweep: sweepId})
+	if err != nil {
+		c.logger.Error("Could not get the histories of the sweep", zap.Error(err))
+		http.Error(w, "could not get the histories of the sweep", http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(context.TODO(), &histories); err != nil {
+		c.logger.Error("could not extract histories from cursor", zap.Error(err))
+		http.Error(w, "error processing request", http.StatusInternalServerError)
+		return
+	}
+	if len(histories) == 0 {
+		http.Error(w, "sweep "+sweepId+" has no jobs", http.StatusNotFound)
+		return
+	}
+
+	for i := range histories {
+		histories[i].Migrate()
+	}
+
+	var best *api.SweepBest
+	if target != 0 {
+		best, err = api.FastestRun(sweepId, target, histories)
+	} else {
+		best, err = api.BestRun(sweepId, metric, histories)
+	}
+	if err != nil {
+		c.logger.Debug("Could not get best run of sweep", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	resp, err := json.Marshal(best)
+	if err != nil {
+		c.logger.Error("Could not marshal best run", zap.Error(err))
+		http.Error(w, "Error marshaling request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
@@ -1,9 +1,14 @@
 package controller
 
 import (
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
+	"io/ioutil"
 	"net/http"
+	"time"
 )
 
 // listTasks gets the tasks from the ps and simply redirects them
@@ -20,6 +25,57 @@ func (c *Controller) listTasks(w http.ResponseWriter, r *http.Request) {
 	w.Write(taskBytes)
 }
 
+// getTask gets the status of a task from the ps and redirects it
+func (c *Controller) getTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	taskBytes, err := c.ps.GetTask(jobId)
+	if err != nil {
+		c.logger.Error("error getting task from ps", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(taskBytes)
+}
+
+// getInvocations gets the invocations of the functions of a task in flight from the ps
+func (c *Controller) getInvocations(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	invocations, err := c.ps.GetInvocations(jobId)
+	if err != nil {
+		c.logger.Error("error getting invocations from ps", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(invocations)
+}
+
+// getTrace gets the trace of the scheduling decisions of a task from the scheduler
+func (c *Controller) getTrace(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	trace, err := c.scheduler.GetTrace(jobId)
+	if err != nil {
+		c.logger.Error("error getting trace from scheduler", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(trace)
+}
+
 func (c *Controller) stopTask(w http.ResponseWriter, r *http.Request)  {
 	vars := mux.Vars(r)
 	jobId := vars["jobId"]
@@ -34,3 +90,99 @@ func (c *Controller) stopTask(w http.ResponseWriter, r *http.Request)  {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// setLogLevel changes the log level of a running task through the ps
+func (c *Controller) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		c.logger.Error("Could not read body", zap.Error(err))
+		http.Error(w, "Failed to read request", http.StatusInternalServerError)
+		return
+	}
+
+	var req api.LogLevelRequest
+	if err = json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Failed to decode the request", http.StatusBadRequest)
+		return
+	}
+
+	auditChange(r, nil, req)
+	err = c.ps.SetLogLevel(jobId, req.Level)
+	if err != nil {
+		c.logger.Error("Error setting log level of task",
+			zap.String("jobId", jobId),
+			zap.Error(err))
+		code := http.StatusInternalServerError
+		if e, ok := err.(kerror.Error); ok {
+			code = e.Code
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// pauseTask pauses a running task through the ps after the epoch in progress
+func (c *Controller) pauseTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		c.logger.Error("Could not read body", zap.Error(err))
+		http.Error(w, "Failed to read request", http.StatusInternalServerError)
+		return
+	}
+
+	var req api.PauseRequest
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "Failed to decode the request", http.StatusBadRequest)
+			return
+		}
+	}
+	if err = req.Validate(time.Now()); err != nil {
+		c.logger.Error("Invalid pause request", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	auditChange(r, nil, req)
+	if err = c.ps.PauseTask(jobId, req); err != nil {
+		c.logger.Error("Error pausing task",
+			zap.String("jobId", jobId),
+			zap.Error(err))
+		code := http.StatusInternalServerError
+		if e, ok := err.(kerror.Error); ok {
+			code = e.Code
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// resumeTask resumes a paused task through the ps
+func (c *Controller) resumeTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	if err := c.ps.ResumeTask(jobId); err != nil {
+		c.logger.Error("Error resuming task",
+			zap.String("jobId", jobId),
+			zap.Error(err))
+		code := http.StatusInternalServerError
+		if e, ok := err.(kerror.Error); ok {
+			code = e.Code
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
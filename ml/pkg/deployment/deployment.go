@@ -0,0 +1,207 @@
+package deployment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxHeartbeatBackoff caps the time between the heartbeats
+	// when they fail, the delay doubles after every failure
+	maxHeartbeatBackoff = 10 * time.Minute
+
+	// requestTimeout bounds every request to the databases
+	requestTimeout = 10 * time.Second
+)
+
+// reporter keeps the document of a component in the deployments collection
+type reporter struct {
+	logger     *zap.Logger
+	client     *mongo.Client
+	collection *mongo.Collection
+	redisPool  *redis.Pool
+	info       api.DeploymentInfo
+}
+
+// Report logs the startup banner of the component and starts writing its
+// document to the deployments collection, refreshing the heartbeat every
+// interval. Errors never stop the component, the heartbeats back off while
+// the database is unreachable
+func Report(logger *zap.Logger, component string) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	r := &reporter{
+		logger:    logger.Named("deployment"),
+		redisPool: util.GetRedisConnectionPool(),
+		info: api.DeploymentInfo{
+			Id:         component + "/" + host,
+			Component:  component,
+			Host:       host,
+			Version:    util.GetVersion(),
+			Commit:     util.Commit,
+			BuildDate:  util.BuildDate,
+			GoVersion:  runtime.Version(),
+			ConfigHash: configHash(os.Environ()),
+			Started:    time.Now(),
+		},
+	}
+
+	logger.Info("Starting kubeml component",
+		zap.String("component", component),
+		zap.String("host", host),
+		zap.String("version", r.info.Version),
+		zap.String("commit", r.info.Commit),
+		zap.String("buildDate", r.info.BuildDate),
+		zap.String("goVersion", r.info.GoVersion),
+		zap.String("configHash", r.info.ConfigHash))
+
+	r.client, err = mongo.NewClient(options.Client().ApplyURI(mongoURI()))
+	if err == nil {
+		err = r.client.Connect(context.Background())
+	}
+	if err != nil {
+		r.logger.Error("Could not create mongo client, the component will not be reported", zap.Error(err))
+		return
+	}
+	r.collection = r.client.Database(util.MongoDatabase()).Collection("deployments")
+
+	go r.run()
+}
+
+// run writes the heartbeats until the process exits
+func (r *reporter) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			r.logger.Error("Deployment heartbeats stopped", zap.Any("error", err))
+		}
+	}()
+
+	failures := 0
+	for {
+		delay := api.DeploymentHeartbeatInterval
+		if err := r.heartbeat(); err != nil {
+			failures++
+			delay = backoff(failures)
+			r.logger.Warn("Could not write deployment heartbeat",
+				zap.Int("failures", failures),
+				zap.Duration("retryIn", delay),
+				zap.Error(err))
+		} else {
+			failures = 0
+		}
+		time.Sleep(delay)
+	}
+}
+
+// backoff returns the delay after the given number of consecutive failures
+func backoff(failures int) time.Duration {
+	delay := api.DeploymentHeartbeatInterval
+	for i := 1; i < failures && delay < maxHeartbeatBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxHeartbeatBackoff {
+		delay = maxHeartbeatBackoff
+	}
+	return delay
+}
+
+// heartbeat replaces the document of the component, detecting the
+// versions of the databases that are not known yet
+func (r *reporter) heartbeat() error {
+	if len(r.info.RedisVersion) == 0 {
+		if v, err := r.redisVersion(); err != nil {
+			r.logger.Debug("Could not detect redis version", zap.Error(err))
+		} else {
+			r.info.RedisVersion = v
+		}
+	}
+	if len(r.info.MongoVersion) == 0 {
+		if v, err := r.mongoVersion(); err != nil {
+			r.logger.Debug("Could not detect mongo version", zap.Error(err))
+		} else {
+			r.info.MongoVersion = v
+		}
+	}
+
+	r.info.Heartbeat = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	_, err := r.collection.ReplaceOne(ctx, bson.M{"_id": r.info.Id}, r.info, options.Replace().SetUpsert(true))
+	if err != nil {
+		return errors.Wrap(err, "could not write deployment")
+	}
+	return nil
+}
+
+// redisVersion returns the version reported by INFO
+func (r *reporter) redisVersion() (string, error) {
+	conn := r.redisPool.Get()
+	defer conn.Close()
+
+	info, err := redis.String(conn.Do("INFO", "server"))
+	if err != nil {
+		return "", errors.Wrap(err, "could not get redis info")
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if strings.HasPrefix(line, "redis_version:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "redis_version:")), nil
+		}
+	}
+	return "", errors.New("redis info has no version")
+}
+
+// mongoVersion returns the version reported by serverStatus
+func (r *reporter) mongoVersion() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	var status struct {
+		Version string `bson:"version"`
+	}
+	err := r.client.Database("admin").RunCommand(ctx, bson.D{{"serverStatus", 1}}).Decode(&status)
+	if err != nil {
+		return "", errors.Wrap(err, "could not get server status")
+	}
+	return status.Version, nil
+}
+
+// configHash returns a short hash of the environment of the component. The
+// host name is left out so the replicas with the same configuration match
+func configHash(env []string) string {
+	vars := make([]string, 0, len(env))
+	for _, v := range env {
+		if !strings.HasPrefix(v, "HOSTNAME=") {
+			vars = append(vars, v)
+		}
+	}
+	sort.Strings(vars)
+
+	sum := sha256.Sum256([]byte(strings.Join(vars, "\n")))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// mongoURI returns the address of the database
+func mongoURI() string {
+	if util.IsDebugEnv() {
+		return api.MongoUrlDebug
+	}
+	return fmt.Sprintf("mongodb://%s:%d", api.MongoUrl, api.MongoPort)
+}
@@ -0,0 +1,156 @@
+package deployment
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// replaced returns the documents written by the heartbeats and the commands sent to mongo
+func replaced(mt *mtest.T) ([]bson.Raw, []string) {
+	var docs []bson.Raw
+	var commands []string
+	for _, evt := range mt.GetAllStartedEvents() {
+		commands = append(commands, evt.CommandName)
+		if evt.CommandName != "update" {
+			continue
+		}
+		updates, err := evt.Command.Lookup("updates").Array().Values()
+		if err != nil {
+			mt.Fatal(err)
+		}
+		for _, u := range updates {
+			if upsert, ok := u.Document().Lookup("upsert").BooleanOK(); !ok || !upsert {
+				mt.Errorf("got update %v, want an upsert", u)
+			}
+			docs = append(docs, u.Document().Lookup("u").Document())
+		}
+	}
+	return docs, commands
+}
+
+func TestHeartbeat(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	// redis can't be reached, so its version is left empty
+	unreachable := &redis.Pool{Dial: func() (redis.Conn, error) {
+		return nil, errors.New("unreachable")
+	}}
+	newReporter := func(mt *mtest.T) *reporter {
+		return &reporter{
+			logger:     zap.NewNop(),
+			client:     mt.Client,
+			collection: mt.Coll,
+			redisPool:  unreachable,
+			info: api.DeploymentInfo{
+				Id:        "controller/host",
+				Component: "controller",
+				Host:      "host",
+				Version:   "v1",
+				Started:   time.Now(),
+			},
+		}
+	}
+	status := mtest.CreateSuccessResponse(bson.E{Key: "version", Value: "4.4.1"})
+	written := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+
+	mt.Run("document shape", func(mt *mtest.T) {
+		mt.AddMockResponses(status, written)
+		if err := newReporter(mt).heartbeat(); err != nil {
+			mt.Fatal(err)
+		}
+
+		docs, _ := replaced(mt)
+		if len(docs) != 1 {
+			mt.Fatalf("got %d documents written, want 1", len(docs))
+		}
+		elements, err := docs[0].Elements()
+		if err != nil {
+			mt.Fatal(err)
+		}
+		var keys []string
+		for _, e := range elements {
+			keys = append(keys, e.Key())
+		}
+		sort.Strings(keys)
+		want := []string{"_id", "builddate", "commit", "component", "confighash", "goversion",
+			"heartbeat", "host", "mongoversion", "redisversion", "started", "version"}
+		if !reflect.DeepEqual(keys, want) {
+			mt.Errorf("got fields %v, want %v", keys, want)
+		}
+
+		var info api.DeploymentInfo
+		if err := bson.Unmarshal(docs[0], &info); err != nil {
+			mt.Fatal(err)
+		}
+		if info.Id != "controller/host" || info.MongoVersion != "4.4.1" || len(info.RedisVersion) != 0 || info.Heartbeat.IsZero() {
+			mt.Errorf("got document %+v, want the controller with mongo 4.4.1 and a heartbeat", info)
+		}
+	})
+
+	mt.Run("versions detected once", func(mt *mtest.T) {
+		mt.AddMockResponses(status, written, written)
+		r := newReporter(mt)
+		for i := 0; i < 2; i++ {
+			if err := r.heartbeat(); err != nil {
+				mt.Fatal(err)
+			}
+		}
+		if _, commands := replaced(mt); !reflect.DeepEqual(commands, []string{"serverStatus", "update", "update"}) {
+			mt.Errorf("got commands %v, want the server status once", commands)
+		}
+	})
+
+	mt.Run("write failed", func(mt *mtest.T) {
+		failed := mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "unavailable"})
+		mt.AddMockResponses(failed, failed)
+		r := newReporter(mt)
+		if err := r.heartbeat(); err == nil {
+			mt.Error("got no error writing the heartbeat")
+		}
+		// the version is asked again in the next heartbeat
+		if len(r.info.MongoVersion) != 0 {
+			mt.Errorf("got mongo version %q, want none", r.info.MongoVersion)
+		}
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 8 * time.Minute},
+		{5, maxHeartbeatBackoff},
+		{50, maxHeartbeatBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.failures); got != tt.want {
+			t.Errorf("got backoff %v after %d failures, want %v", got, tt.failures, tt.want)
+		}
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	a := configHash([]string{"HOSTNAME=a", "KUBEML_VERSION=1", "MONGO=db"})
+	if b := configHash([]string{"MONGO=db", "KUBEML_VERSION=1", "HOSTNAME=b"}); a != b {
+		t.Errorf("got hashes %s and %s of replicas with the same configuration", a, b)
+	}
+	if c := configHash([]string{"HOSTNAME=a", "KUBEML_VERSION=2", "MONGO=db"}); a == c {
+		t.Error("got the same hash for different configurations")
+	}
+	if len(a) != 12 {
+		t.Errorf("got hash %s, want 12 characters", a)
+	}
+}
@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
+	v1 "github.com/diegostock12/kubeml/ml/pkg/controller/client/v1"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	maxEpochs         int
+	maxFunctionEpochs int
+	maxJobParallelism int
+
+	auditSince     string
+	auditActor     string
+	auditAction    string
+	auditAfter     int64
+	auditLimit     int
+	auditAll       bool
+	auditVerify    bool
+	adminAuditJSON bool
+
+	adminCmd = &cobra.Command{
+		Use:   "admin",
+		Short: "Manage the settings of the cluster",
+	}
+
+	adminLimitsCmd = &cobra.Command{
+		Use:   "limits",
+		Short: "Manage the admission limits of the train requests",
+	}
+
+	adminLimitsGetCmd = &cobra.Command{
+		Use:   "get",
+		Short: "Show the admission limits of the train requests",
+		RunE:  getLimits,
+	}
+
+	adminLimitsSetCmd = &cobra.Command{
+		Use:   "set",
+		Short: "Set the admission limits of the train requests",
+		Long: `Set the maximum epochs, function-epochs (epochs x parallelism) and max
+parallelism of a train request. Only the limits given are changed, and a limit
+of 0 disables the check. Requests over the limits are rejected unless they are
+submitted with --budget-override, and requests without a max parallelism are
+capped at the one of the limits.`,
+		RunE: setLimits,
+	}
+
+	adminAuditCmd = &cobra.Command{
+		Use:   "audit",
+		Short: "Show the audit log of the administrative actions",
+		Long: `Show the administrative actions (setting the limits, stopping tasks,
+changing their log level and deleting histories) in the order they were done.
+Each entry holds the hash of the previous one, and --verify checks that the
+entries shown were not changed or removed.`,
+		RunE: getAudit,
+	}
+
+	adminComponentsCmd = &cobra.Command{
+		Use:   "components",
+		Short: "Show the deployed components of kubeml and their versions",
+		Long: fmt.Sprintf(`Show every replica of the controller, scheduler and parameter server with
+its version, the versions of redis and mongo it detected and its last heartbeat.
+Components without a heartbeat in the last %v are marked as stale.`, api.DeploymentStaleAfter),
+		RunE: listComponents,
+	}
+)
+
+// printLimit returns the limit or none if it is disabled
+func printLimit(limit int) interface{} {
+	if limit == 0 {
+		return "none"
+	}
+	return limit
+}
+
+func getLimits(_ *cobra.Command, _ []string) error {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	limits, err := client.V1().Admin().GetLimits()
+	if err != nil {
+		return errors.Wrap(err, "could not get admission limits")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\n", "MAX EPOCHS", printLimit(limits.MaxEpochs))
+	fmt.Fprintf(w, "%v\t%v\n", "MAX FUNCTION-EPOCHS", printLimit(limits.MaxFunctionEpochs))
+	fmt.Fprintf(w, "%v\t%v\n", "MAX PARALLELISM", printLimit(limits.MaxParallelism))
+	w.Flush()
+
+	return nil
+}
+
+func setLimits(cmd *cobra.Command, _ []string) error {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	limits, err := client.V1().Admin().GetLimits()
+	if err != nil {
+		return errors.Wrap(err, "could not get admission limits")
+	}
+
+	if cmd.Flags().Changed("max-epochs") {
+		limits.MaxEpochs = maxEpochs
+	}
+	if cmd.Flags().Changed("max-function-epochs") {
+		limits.MaxFunctionEpochs = maxFunctionEpochs
+	}
+	if cmd.Flags().Changed("max-parallelism") {
+		limits.MaxParallelism = maxJobParallelism
+	}
+	if err = limits.Validate(); err != nil {
+		return err
+	}
+
+	if err = client.V1().Admin().SetLimits(limits); err != nil {
+		return errors.Wrap(err, "could not set admission limits")
+	}
+
+	fmt.Println("Updated admission limits")
+	return nil
+}
+
+// parseSince parses the start of the audit log shown, either
+// a duration before the current time like 24h or a RFC3339 time
+func parseSince(since string) (time.Time, error) {
+	if len(since) == 0 {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since \"%s\", expected a duration like 24h or a RFC3339 time", since)
+	}
+	return t, nil
+}
+
+func getAudit(_ *cobra.Command, _ []string) error {
+	since, err := parseSince(auditSince)
+	if err != nil {
+		return err
+	}
+
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	// follow the pages of the log if all the entries are requested
+	query := v1.AuditQuery{
+		Since:  since,
+		Actor:  auditActor,
+		Action: auditAction,
+		After:  auditAfter,
+		Limit:  auditLimit,
+	}
+	var entries []api.AdminAuditEntry
+	for {
+		page, err := client.V1().Admin().Audit(query)
+		if err != nil {
+			return errors.Wrap(err, "could not get audit log")
+		}
+		entries = append(entries, page.Entries...)
+		if !auditAll || page.Next == 0 {
+			break
+		}
+		query.After = page.Next
+	}
+
+	if auditVerify {
+		if err = api.VerifyAuditChain(entries); err != nil {
+			return errors.Wrap(err, "audit log verification failed")
+		}
+	}
+
+	if adminAuditJSON {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "could not marshal json")
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "SEQ", "TIME", "ACTOR", "ACTION", "TARGET", "STATUS", "REQUEST", "CHANGE")
+	for _, e := range entries {
+		change := "-"
+		if len(e.Before) > 0 || len(e.After) > 0 {
+			change = fmt.Sprintf("%v -> %v", orNone(e.Before), orNone(e.After))
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			e.Seq, e.Time.Local().Format(time.RFC3339), e.Actor, e.Action, orNone(e.Target), e.Status, e.RequestId, change)
+	}
+	w.Flush()
+
+	if auditVerify {
+		fmt.Printf("Verified %d entries\n", len(entries))
+	}
+	return nil
+}
+
+func listComponents(_ *cobra.Command, _ []string) error {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	components, err := client.V1().Admin().Components()
+	if err != nil {
+		return errors.Wrap(err, "could not get components")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+		"COMPONENT", "HOST", "VERSION", "COMMIT", "BUILT", "GO", "CONFIG", "REDIS", "MONGO", "HEARTBEAT")
+	for _, c := range components {
+		heartbeat := fmt.Sprintf("%v ago", time.Since(c.Heartbeat).Round(time.Second))
+		if c.Stale {
+			heartbeat += " (stale)"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			c.Component, c.Host, c.Version, c.Commit, c.BuildDate, c.GoVersion, c.ConfigHash,
+			orNone(c.RedisVersion), orNone(c.MongoVersion), heartbeat)
+	}
+	w.Flush()
+
+	return nil
+}
+
+// orNone returns the value or - if it is empty
+func orNone(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
+
+func init() {
+	rootCmd.AddCommand(adminCmd)
+	adminCmd.AddCommand(adminLimitsCmd)
+	adminLimitsCmd.AddCommand(adminLimitsGetCmd)
+	adminLimitsCmd.AddCommand(adminLimitsSetCmd)
+	adminCmd.AddCommand(adminAuditCmd)
+	adminCmd.AddCommand(adminComponentsCmd)
+
+	adminLimitsSetCmd.Flags().IntVar(&maxEpochs, "max-epochs", 0, "Maximum epochs of a train request, 0 disables the limit")
+	adminLimitsSetCmd.Flags().IntVar(&maxFunctionEpochs, "max-function-epochs", 0, "Maximum epochs x parallelism of a train request, 0 disables the limit")
+	adminLimitsSetCmd.Flags().IntVar(&maxJobParallelism, "max-parallelism", 0, "Maximum parallelism the scheduler can give a job, 0 disables the limit")
+
+	adminAuditCmd.Flags().StringVar(&auditSince, "since", "", "Show the actions after a time (RFC3339) or in the last duration (e.g. 24h)")
+	adminAuditCmd.Flags().StringVar(&auditActor, "actor", "", "Show only the actions of a user")
+	adminAuditCmd.Flags().StringVar(&auditAction, "action", "", "Show only an action (e.g. limits.set, task.stop)")
+	adminAuditCmd.Flags().Int64Var(&auditAfter, "after", 0, "Show the entries after a sequence number")
+	adminAuditCmd.Flags().IntVar(&auditLimit, "limit", api.DefaultAdminAuditLimit, "Maximum entries of a page")
+	adminAuditCmd.Flags().BoolVar(&auditAll, "all", false, "Follow the pages to show all the entries")
+	adminAuditCmd.Flags().BoolVar(&auditVerify, "verify", false, "Check the hash chain of the entries shown")
+	adminAuditCmd.Flags().BoolVar(&adminAuditJSON, "json", false, "Print the entries as JSON")
+}
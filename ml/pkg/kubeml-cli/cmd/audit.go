@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+var (
+	auditJSON bool
+
+	auditCmd = &cobra.Command{
+		Use:   "audit <jobId>",
+		Short: "Show the data each function of a job trained on",
+		Long: `Show the shards of the dataset each function of a job trained on in every
+epoch, along with the seed it shuffled them with. The order of the datapoints
+can be derived again from the scheme and the seeds. Only available for jobs
+started with --audit-data.`,
+		Args: cobra.ExactArgs(1),
+		RunE: dataAudit,
+	}
+)
+
+// dataAudit prints the data assigned to the functions of a job
+func dataAudit(_ *cobra.Command, args []string) error {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	audit, err := client.V1().Histories().Audit(args[0])
+	if err != nil {
+		return errors.Wrap(err, "could not get data audit")
+	}
+
+	if auditJSON {
+		data, err := json.MarshalIndent(audit, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "could not encode data audit")
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("Dataset: %v (%v shards of %v datapoints)\n", audit.Dataset, audit.Shards, audit.ShardSize)
+	fmt.Printf("Scheme: %v\n", audit.Scheme)
+	if audit.Seed != 0 {
+		fmt.Printf("Seed: %v\n", audit.Seed)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", "EPOCH", "FUNCTION", "SHARDS", "DATAPOINTS", "SEED")
+	for _, e := range audit.Epochs {
+		for _, f := range e.Functions {
+			shards, points := "-", "-"
+			if audit.Shards > 0 {
+				shards = fmt.Sprintf("[%v, %v)", f.FirstShard, f.EndShard)
+				points = fmt.Sprintf("[%v, %v)", f.FirstShard*int64(audit.ShardSize), f.EndShard*int64(audit.ShardSize))
+			}
+			seed := "-"
+			if audit.Scheme == api.AuditSchemeShuffled {
+				seed = fmt.Sprint(f.Seed)
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", e.Epoch, f.FuncId, shards, points, seed)
+		}
+	}
+	w.Flush()
+
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+
+	auditCmd.Flags().BoolVar(&auditJSON, "json", false, "Print the audit as JSON")
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	// variables used to select the tasks of the batch operations
+	batchAll           bool
+	batchTags          map[string]string
+	batchState         string
+	batchCreatedBefore string
+	batchYes           bool
+	batchDryRun        bool
+
+	tasksTagCmd = &cobra.Command{
+		Use:   "tag <name>=<value> --all",
+		Short: "Add a tag to the finished tasks matching --tag, --state and --created-before",
+		Args:  cobra.ExactArgs(1),
+		RunE:  tagTasks,
+	}
+)
+
+// addBatchFlags adds the flags that select the tasks of a batch operation
+func addBatchFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&batchAll, "all", false, "Apply to all the tasks matching --tag, --state and --created-before")
+	cmd.Flags().StringToStringVar(&batchTags, "tag", nil, "With --all, only the tasks with the tag, as name=value")
+	cmd.Flags().StringVar(&batchState, "state", "", "With --all, only the tasks in the state (running, paused, completed, failed or stopped)")
+	cmd.Flags().StringVar(&batchCreatedBefore, "created-before", "", "With --all, only the tasks started before, either an RFC3339 time or a duration ago such as 24h")
+	cmd.Flags().BoolVar(&batchYes, "yes", false, fmt.Sprintf("Confirm changing more than %d tasks with --all", api.JobBatchConfirmAbove))
+	cmd.Flags().BoolVar(&batchDryRun, "dry-run", false, "With --all, only list the tasks that would be changed")
+}
+
+// tagTasks adds the tag to the tasks matching the filter
+func tagTasks(_ *cobra.Command, args []string) error {
+	if !batchAll {
+		return fmt.Errorf("select the tasks to tag with --all and the filter flags")
+	}
+	return runJobBatch(api.JobOpAddTag, args[0])
+}
+
+// runJobBatch applies the operation to all the tasks matching the filter flags and
+// prints the result of each. Unless it is a dry run, more than JobBatchConfirmAbove
+// tasks are only changed with --yes, so a broad filter is not applied by mistake
+func runJobBatch(operation, tag string) error {
+	req := &api.JobBatchRequest{
+		Filter:    api.JobFilter{Tags: batchTags, State: batchState},
+		Operation: operation,
+		Tag:       tag,
+		DryRun:    batchDryRun,
+	}
+	if len(batchCreatedBefore) > 0 {
+		before, err := parseCreatedBefore(batchCreatedBefore, time.Now())
+		if err != nil {
+			return err
+		}
+		req.Filter.CreatedBefore = before
+	}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	if !req.DryRun && !batchYes {
+		dry := *req
+		dry.DryRun = true
+		matched, err := client.V1().Tasks().Batch(&dry)
+		if err != nil {
+			return err
+		}
+		if n := len(matched.Results); n > api.JobBatchConfirmAbove {
+			return fmt.Errorf("%d tasks match the filter, list them with --dry-run and confirm with --yes", n)
+		}
+	}
+
+	resp, err := client.V1().Tasks().Batch(req)
+	if err != nil {
+		return err
+	}
+	if len(resp.Results) == 0 {
+		fmt.Println("No tasks match the filter")
+		return nil
+	}
+
+	counts := make(map[string]int)
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tRESULT\tREASON")
+	for _, r := range resp.Results {
+		counts[r.Result]++
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.JobId, r.State, r.Result, r.Reason)
+	}
+	w.Flush()
+
+	if resp.DryRun {
+		fmt.Printf("%d tasks would be changed by %s\n", len(resp.Results), resp.Operation)
+		return nil
+	}
+	fmt.Printf("%d succeeded, %d skipped, %d failed\n",
+		counts[api.JobOpSucceeded], counts[api.JobOpSkipped], counts[api.JobOpFailed])
+	if counts[api.JobOpFailed] > 0 {
+		return fmt.Errorf("%d of the %d tasks failed", counts[api.JobOpFailed], len(resp.Results))
+	}
+	return nil
+}
+
+// parseCreatedBefore parses the time the tasks of a batch started before,
+// either as an RFC3339 time or as a duration ago such as 24h
+func parseCreatedBefore(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --created-before \"%v\", expected an RFC3339 time or a duration", s)
+	}
+	return t, nil
+}
+
+func init() {
+	tasksCmd.AddCommand(tasksTagCmd)
+	addBatchFlags(tasksTagCmd)
+	addBatchFlags(tasksStopCmd)
+	addBatchFlags(historyDeleteCmd)
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var (
+	continueEpochs int
+
+	continueCmd = &cobra.Command{
+		Use:   "continue <jobId>",
+		Short: "Train a completed job for more epochs",
+		Long: `Continue trains a completed job for more epochs in place. The job keeps its id and
+settings, resumes from the checkpoint of its last epoch and appends the new epochs
+to its history, numbered after the last one.
+
+Only jobs checkpointed in object storage can be continued (see --checkpoint-backend),
+since the model kept in redis is deleted once the job finishes`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return continueJob(args[0])
+		},
+	}
+)
+
+// continueJob submits the continuation of the job and prints its id
+func continueJob(jobId string) error {
+	if continueEpochs <= 0 {
+		return errors.New("epochs should be a positive value")
+	}
+
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.V1().Networks().Continue(jobId, continueEpochs)
+	if err != nil {
+		return err
+	}
+
+	if len(resp.Warning) > 0 {
+		fmt.Fprintln(os.Stderr, "Warning:", resp.Warning)
+	}
+	if len(resp.NotifySecret) > 0 {
+		fmt.Fprintln(os.Stderr, "Notification secret:", resp.NotifySecret)
+	}
+	fmt.Println(resp.Id)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(continueCmd)
+
+	continueCmd.Flags().IntVarP(&continueEpochs, "epochs", "e", 1, "Number of additional epochs to train")
+}
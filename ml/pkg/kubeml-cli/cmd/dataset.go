@@ -1,9 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
 	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
+	storageClient "github.com/diegostock12/kubeml/ml/pkg/storage/client"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 	"os"
 	"text/tabwriter"
 )
@@ -20,6 +25,10 @@ var (
 	// Variables used by dataset command in general
 	name string
 
+	// variables used in the set-weights command
+	datasetWeights []float64
+	autoWeights    bool
+
 	datasetCmd = &cobra.Command{
 		Use:   "dataset",
 		Short: "Upload or delete a dataset used by kubeml",
@@ -39,6 +48,15 @@ upload the files to KubeMl so they can be used in training tasks. Files must be
 		RunE:  deleteDataset,
 	}
 
+	datasetWeightsCmd = &cobra.Command{
+		Use:   "set-weights",
+		Short: "Set the class weights of a dataset",
+		Long: `Set the weight of each class of a dataset used by the jobs that weight their loss
+with --use-class-weights. With --auto the weights set are deleted and the jobs compute
+them from the class distribution of the train set`,
+		RunE: setClassWeights,
+	}
+
 	listDatasetCmd = &cobra.Command{
 		Use:   "list",
 		Short: "List dataset information",
@@ -46,36 +64,92 @@ upload the files to KubeMl so they can be used in training tasks. Files must be
 	}
 )
 
+// makeStorageClient returns a storage client that reaches
+// the storage service through the controller proxy
+func makeStorageClient() (storageClient.Interface, error) {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return storageClient.MakeClient(zap.NewNop(), client.ServerUrl()), nil
+}
+
 // createDataset creates a dataset in KubeML
 func createDataset(_ *cobra.Command, _ []string) error {
-	client, err := kubemlClient.MakeKubemlClient()
+	client, err := makeStorageClient()
 	if err != nil {
 		return err
 	}
 
 	// pass the commands to the client creation command
-	return client.V1().Datasets().Create(name, trainData, trainLabels, testData, testLabels)
+	err = client.Upload(context.Background(), name, storageClient.DatasetFiles{
+		TrainData:   trainData,
+		TrainLabels: trainLabels,
+		TestData:    testData,
+		TestLabels:  testLabels,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Dataset created")
+	return nil
 }
 
 // deleteDataset deletes a dataset from KubeML
 func deleteDataset(_ *cobra.Command, _ []string) error {
-	client, err := kubemlClient.MakeKubemlClient()
+	client, err := makeStorageClient()
+	if err != nil {
+		return err
+	}
+
+	err = client.Delete(context.Background(), name)
 	if err != nil {
 		return err
 	}
 
-	// return the deletion
-	return client.V1().Datasets().Delete(name)
+	fmt.Println("Dataset deleted")
+	return nil
+}
+
+// setClassWeights sets or deletes the class weights of a dataset
+func setClassWeights(_ *cobra.Command, _ []string) error {
+	if autoWeights == (len(datasetWeights) > 0) {
+		return errors.New("either --weights or --auto must be set")
+	}
+	if !autoWeights {
+		if err := api.CheckClassWeights(datasetWeights, 0); err != nil {
+			return err
+		}
+	}
+
+	client, err := makeStorageClient()
+	if err != nil {
+		return err
+	}
+
+	err = client.SetClassWeights(context.Background(), name, datasetWeights)
+	if err != nil {
+		return err
+	}
+
+	if autoWeights {
+		fmt.Println("Class weights deleted, computed from the class distribution")
+		return nil
+	}
+	fmt.Println("Class weights set")
+	return nil
 }
 
 // listDatasets lists the datasets from kubeml
 func listDatasets(_ *cobra.Command, _ []string) error {
-	client, err := kubemlClient.MakeKubemlClient()
+	client, err := makeStorageClient()
 	if err != nil {
 		return err
 	}
 
-	datasets, err := client.V1().Datasets().List()
+	datasets, err := client.List(context.Background())
 	if err != nil {
 		return err
 	}
@@ -93,7 +167,7 @@ func listDatasets(_ *cobra.Command, _ []string) error {
 
 func init() {
 	rootCmd.AddCommand(datasetCmd)
-	datasetCmd.AddCommand(datasetCreateCmd, datasetDeleteCmd, listDatasetCmd)
+	datasetCmd.AddCommand(datasetCreateCmd, datasetDeleteCmd, datasetWeightsCmd, listDatasetCmd)
 
 	// Add the flags to each command
 	// Flags for the create command
@@ -113,4 +187,10 @@ func init() {
 	// Flags for the delete command
 	datasetDeleteCmd.Flags().StringVarP(&name, "name", "n", "", "Dataset Name (required)")
 	datasetDeleteCmd.MarkFlagRequired("name")
+
+	// Flags for the set-weights command
+	datasetWeightsCmd.Flags().StringVarP(&name, "name", "n", "", "Dataset Name (required)")
+	datasetWeightsCmd.Flags().Float64SliceVar(&datasetWeights, "weights", nil, "Weight of each class, e.g. 1,2.5,1")
+	datasetWeightsCmd.Flags().BoolVar(&autoWeights, "auto", false, "Delete the weights set so they are computed from the class distribution")
+	datasetWeightsCmd.MarkFlagRequired("name")
 }
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+var (
+	bundleFile     string
+	includeWeights bool
+	fromCheckpoint bool
+
+	exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export the artifacts of a training job",
+	}
+
+	exportBundleCmd = &cobra.Command{
+		Use:   "bundle <jobId>",
+		Short: "Package the model, history, config and lineage of a job into one archive",
+		Long: `Download a gzipped tarball with the weights of the model, the history, the original
+request and the effective configuration of a job, along with the version of the function, the
+dataset and the scheduling decisions if the job was traced. The archive includes a manifest with
+the hashes of every file, which can be checked with 'kubeml export verify'.
+
+Weights bigger than the limit configured in the controller are replaced by the checksums
+of the tensors, use --include-weights to always include them. The weights of jobs checkpointed
+in object storage are read from their last checkpoint if they are no longer in redis, or
+always with --from-checkpoint.`,
+		Args: cobra.ExactArgs(1),
+		RunE: exportBundle,
+	}
+
+	exportVerifyCmd = &cobra.Command{
+		Use:   "verify <bundle>",
+		Short: "Check the files of a bundle against its manifest",
+		Args:  cobra.ExactArgs(1),
+		RunE:  verifyBundle,
+	}
+)
+
+// exportBundle downloads the bundle of a job to the output file
+func exportBundle(_ *cobra.Command, args []string) error {
+	jobId := args[0]
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	if len(bundleFile) == 0 {
+		bundleFile = jobId + ".tar.gz"
+	}
+
+	bundle, err := client.V1().Histories().Export(jobId, includeWeights, fromCheckpoint)
+	if err != nil {
+		return errors.Wrap(err, "could not export job")
+	}
+	defer bundle.Close()
+
+	f, err := os.Create(bundleFile)
+	if err != nil {
+		return errors.Wrap(err, "could not create output file")
+	}
+
+	if _, err = io.Copy(f, bundle); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(bundleFile)
+		return errors.Wrap(err, "could not download bundle")
+	}
+
+	fmt.Println("Exported job", jobId, "to", bundleFile)
+	return nil
+}
+
+// verifyBundle checks the files of the bundle against its manifest
+func verifyBundle(_ *cobra.Command, args []string) error {
+	f, err := os.Open(args[0])
+	if err != nil {
+		return errors.Wrap(err, "could not open bundle")
+	}
+	defer f.Close()
+
+	manifest, err := checkBundle(f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Bundle of job %s verified, %d files match the manifest\n", manifest.JobId, len(manifest.Files))
+	return nil
+}
+
+// checkBundle hashes the files of the bundle and compares them with
+// the manifest, reporting the missing, modified and unexpected files
+func checkBundle(r io.Reader) (*api.ExportManifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decompress bundle")
+	}
+
+	var manifest *api.ExportManifest
+	files := make(map[string]api.ExportFile)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read bundle")
+		}
+
+		if header.Name == api.ExportManifestFile {
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, errors.Wrap(err, "could not read manifest")
+			}
+			manifest = &api.ExportManifest{}
+			if err = json.Unmarshal(data, manifest); err != nil {
+				return nil, errors.Wrap(err, "could not parse manifest")
+			}
+			continue
+		}
+
+		h := sha256.New()
+		size, err := io.Copy(h, tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read %s", header.Name)
+		}
+		files[header.Name] = api.ExportFile{
+			Name:   header.Name,
+			Size:   size,
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+		}
+	}
+
+	if manifest == nil {
+		return nil, errors.New("bundle has no manifest, it may be truncated")
+	}
+
+	var e *multierror.Error
+	for _, expected := range manifest.Files {
+		actual, exists := files[expected.Name]
+		switch {
+		case !exists:
+			e = multierror.Append(e, fmt.Errorf("%s is missing", expected.Name))
+		case actual.Size != expected.Size || actual.SHA256 != expected.SHA256:
+			e = multierror.Append(e, fmt.Errorf("%s does not match its hash", expected.Name))
+		}
+		delete(files, expected.Name)
+	}
+	extra := make([]string, 0, len(files))
+	for name := range files {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		e = multierror.Append(e, fmt.Errorf("%s is not in the manifest", name))
+	}
+
+	if err := e.ErrorOrNil(); err != nil {
+		return nil, errors.Wrap(err, "bundle verification failed")
+	}
+	return manifest, nil
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.AddCommand(exportBundleCmd)
+	exportCmd.AddCommand(exportVerifyCmd)
+
+	exportBundleCmd.Flags().StringVarP(&bundleFile, "output", "o", "", "File where the bundle is saved (default <jobId>.tar.gz)")
+	exportBundleCmd.Flags().BoolVar(&includeWeights, "include-weights", false, "Include the weights even if they exceed the size limit of the controller")
+	exportBundleCmd.Flags().BoolVar(&fromCheckpoint, "from-checkpoint", false, "Read the weights from the last checkpoint in object storage instead of redis")
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"strings"
+	"testing"
+)
+
+type tarFile struct {
+	name string
+	data string
+}
+
+// exportFile returns the entry of the manifest of a file with the data given
+func exportFile(name, data string) api.ExportFile {
+	sum := sha256.Sum256([]byte(data))
+	return api.ExportFile{Name: name, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+}
+
+// newBundle returns a gzipped tarball with the files and, if set, the manifest as the last file
+func newBundle(t *testing.T, files []tarFile, manifest *api.ExportManifest) []byte {
+	t.Helper()
+	files = append([]tarFile(nil), files...)
+	if manifest != nil {
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, tarFile{api.ExportManifestFile, string(data)})
+	}
+
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCheckBundle(t *testing.T) {
+	files := []tarFile{
+		{"history.json", `{"id":"job"}`},
+		{"weights/fc.weight", "\x00\x00\x80\x3f"},
+	}
+	manifest := &api.ExportManifest{
+		JobId: "job",
+		Files: []api.ExportFile{
+			exportFile("history.json", `{"id":"job"}`),
+			exportFile("weights/fc.weight", "\x00\x00\x80\x3f"),
+		},
+	}
+
+	tests := []struct {
+		name     string
+		files    []tarFile
+		manifest *api.ExportManifest
+		want     []string
+	}{
+		{"valid bundle", files, manifest, nil},
+		{
+			name:     "modified file",
+			files:    []tarFile{files[0], {"weights/fc.weight", "\x00\x00\x00\x40"}},
+			manifest: manifest,
+			want:     []string{"weights/fc.weight does not match its hash"},
+		},
+		{
+			name:     "truncated file",
+			files:    []tarFile{files[0], {"weights/fc.weight", "\x00\x00"}},
+			manifest: manifest,
+			want:     []string{"weights/fc.weight does not match its hash"},
+		},
+		{
+			name:     "missing file",
+			files:    files[:1],
+			manifest: manifest,
+			want:     []string{"weights/fc.weight is missing"},
+		},
+		{
+			name:     "unexpected files",
+			files:    append([]tarFile{{"z.json", "{}"}, {"a.json", "{}"}}, files...),
+			manifest: manifest,
+			want:     []string{"a.json is not in the manifest", "z.json is not in the manifest"},
+		},
+		{
+			name:  "no manifest",
+			files: files,
+			want:  []string{"bundle has no manifest"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checked, err := checkBundle(bytes.NewReader(newBundle(t, tt.files, tt.manifest)))
+			if tt.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if checked.JobId != "job" || len(checked.Files) != len(manifest.Files) {
+					t.Errorf("got manifest %+v, want the one of the bundle", checked)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("got no error, want the verification to fail")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("got error %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckBundleTruncatedArchive(t *testing.T) {
+	bundle := newBundle(t, []tarFile{{"history.json", strings.Repeat("x", 4096)}}, &api.ExportManifest{JobId: "job"})
+
+	// a download cut before the manifest leaves an archive that can't be read to the end
+	if _, err := checkBundle(bytes.NewReader(bundle[:len(bundle)/2])); err == nil {
+		t.Error("got no error, want the truncated archive refused")
+	}
+}
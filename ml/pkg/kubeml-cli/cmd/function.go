@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
 	"github.com/fission/fission/pkg/crd"
 	"github.com/hashicorp/go-multierror"
@@ -55,6 +57,20 @@ var (
 		Short: "List deployed Deep Learning functions",
 		RunE:  listFunctions,
 	}
+
+	// variables for the conformance command
+	fnConformanceBatch int
+	fnConformanceJSON  bool
+
+	functionConformanceCmd = &cobra.Command{
+		Use:   "conformance",
+		Short: "Check that a function follows the contract of the jobs",
+		Long: `Invokes every task of the function through the router on a tiny slice of its dataset,
+checks the responses against the schemas of the jobs and prints whether each task passed, along
+with the capability it belongs to and whether the function declared it. Failures point at the
+field of the response that deviated. The command fails if any check fails`,
+		RunE: functionConformance,
+	}
 )
 
 // createFunction creates a new function
@@ -139,6 +155,7 @@ func createFunction(_ *cobra.Command, _ []string) error {
 	}
 
 	fmt.Println("Function", fnName, "created")
+	invalidateFunction(fnName)
 
 	return nil
 
@@ -290,6 +307,7 @@ func deleteFunction(_ *cobra.Command, _ []string) error {
 	triggerName := fmt.Sprintf("%s-%s", fnName, strings.ToLower(http.MethodGet))
 	err = fissionClient.CoreV1().HTTPTriggers(DefaultNamespace).Delete(triggerName, &metav1.DeleteOptions{})
 	result = multierror.Append(result, err)
+	invalidateFunction(fnName)
 
 	if err = result.ErrorOrNil(); err == nil {
 		fmt.Printf("Function \"%s\" deleted", fnName)
@@ -300,6 +318,19 @@ func deleteFunction(_ *cobra.Command, _ []string) error {
 
 }
 
+// invalidateFunction makes the controller look up the function again in the next
+// train request. A failure is only reported, since the controller also watches
+// the functions and its cached entries expire
+func invalidateFunction(name string) {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err == nil {
+		err = client.V1().Functions().Invalidate(name)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: could not invalidate the function in the controller:", err)
+	}
+}
+
 // listFunctions returns a table with the information of the current functions
 func listFunctions(_ *cobra.Command, _ []string) error {
 	// make fission client
@@ -329,11 +360,62 @@ func listFunctions(_ *cobra.Command, _ []string) error {
 	return nil
 }
 
+// functionConformance runs the conformance checks against a function
+// and prints the result of every check as a table
+func functionConformance(_ *cobra.Command, _ []string) error {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	report, err := client.V1().Functions().Conformance(fnName, fnConformanceBatch)
+	if err != nil {
+		return errors.Wrap(err, "could not run conformance checks")
+	}
+
+	if fnConformanceJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "could not encode conformance report")
+		}
+		fmt.Println(string(data))
+	} else {
+		schema := report.SchemaVersion
+		if len(schema) == 0 {
+			schema = "-"
+		}
+		fmt.Printf("Function: %v\n", report.Function)
+		fmt.Printf("Response schema: %v\n", schema)
+		fmt.Printf("Capabilities: %v\n\n", strings.Join(report.Capabilities, ", "))
+
+		w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", "TASK", "CAPABILITY", "DECLARED", "RESULT", "FIELD", "DETAIL")
+		for _, check := range report.Checks {
+			field, detail := "-", "-"
+			if len(check.Field) > 0 {
+				field = check.Field
+			}
+			if len(check.Message) > 0 {
+				detail = check.Message
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n",
+				check.Task, check.Capability, check.Declared, strings.ToUpper(check.Result), field, detail)
+		}
+		w.Flush()
+	}
+
+	if !report.Passed() {
+		return fmt.Errorf("function %s failed the conformance checks", report.Function)
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(functionCmd)
 	functionCmd.AddCommand(functionCreateCmd)
 	functionCmd.AddCommand(functionDeleteCmd)
 	functionCmd.AddCommand(functionListCmd)
+	functionCmd.AddCommand(functionConformanceCmd)
 
 	// create command
 	functionCreateCmd.Flags().StringVar(&fnName, "name", "", "Name of the function (required)")
@@ -342,9 +424,15 @@ func init() {
 	// delete command
 	functionDeleteCmd.Flags().StringVar(&fnName, "name", "", "Name of the function (required)")
 
+	// conformance command
+	functionConformanceCmd.Flags().StringVarP(&fnName, "function", "f", "", "Name of the function (required)")
+	functionConformanceCmd.Flags().IntVar(&fnConformanceBatch, "batch", 0, "Batch size of the checks (default from the controller)")
+	functionConformanceCmd.Flags().BoolVar(&fnConformanceJSON, "json", false, "Print the report as JSON")
+
 	// mark required fields
 	functionCreateCmd.MarkFlagRequired("name")
 	functionCreateCmd.MarkFlagRequired("code")
 	functionDeleteCmd.MarkFlagRequired("name")
+	functionConformanceCmd.MarkFlagRequired("function")
 
 }
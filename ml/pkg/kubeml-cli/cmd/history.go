@@ -3,17 +3,22 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
 	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"math"
 	"os"
+	"sort"
 	"strings"
 	"text/tabwriter"
 )
 
 var (
-	taskId string
+	taskId     string
+	perClass   bool
+	durations  bool
+	efficiency bool
 
 	historyCmd = &cobra.Command{
 		Use:   "history",
@@ -38,6 +43,17 @@ var (
 		RunE:  listHistories,
 	}
 
+	historyCompareCmd = &cobra.Command{
+		Use:   "compare <jobId> <jobId>...",
+		Short: "Compare the time jobs took to reach their accuracy milestones",
+		Long: `Compare jobs side by side with the best accuracy they reached, their training time
+and function seconds, and the time, epoch and function seconds they took to first reach
+each of their accuracy milestones. Milestones a job did not reach are shown as such. Jobs
+trained before the milestones were recorded get them from their validations.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: compareHistories,
+	}
+
 	historyPruneCmd = &cobra.Command{
 		Use:   "prune",
 		Short: "Delete all histories",
@@ -58,17 +74,138 @@ func getHistory(_ *cobra.Command, _ []string) error {
 		return err
 	}
 
+	if perClass {
+		return printClassAccuracy(history)
+	}
+	if durations {
+		return printInvocationDurations(history)
+	}
+	if efficiency {
+		return printEfficiency(client, history)
+	}
+
 	out, err := json.MarshalIndent(history, "", "  ")
 	if err != nil {
 		return errors.Wrap(err, "could not marshal json")
 	}
 
 	fmt.Println(string(out))
+	if len(history.Data.Accuracy) > 0 {
+		fmt.Fprintf(os.Stderr, "The accuracies are %s\n", history.Data.AccuracyUnit())
+	}
+	return nil
+}
+
+// printClassAccuracy prints the accuracy of each class in every validation
+// of the job, along with the datapoints of the class in the last one
+func printClassAccuracy(history *api.History) error {
+	classes := history.Data.Classes()
+	if len(classes) == 0 {
+		return fmt.Errorf("job %s has no per-class metrics, enable them with --metrics %s",
+			history.Id, api.MetricsPerClass)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v", "CLASS", "SAMPLES")
+	for _, epoch := range history.Data.ValidationEpochs {
+		fmt.Fprintf(w, "\tEPOCH %v", epoch)
+	}
+	fmt.Fprintln(w)
+
+	for _, class := range classes {
+		fmt.Fprintf(w, "%v\t%v", class, history.Data.ClassSamples[class])
+		series := history.Data.ClassAccuracy[class]
+		for i := range history.Data.ValidationEpochs {
+			if i < len(series) {
+				fmt.Fprintf(w, "\t%.2f", series[i])
+			} else {
+				fmt.Fprint(w, "\t-")
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+
+	return nil
+}
+
+// printInvocationDurations prints the percentiles of the durations of the
+// invocations of each task in every epoch of the job, in seconds
+func printInvocationDurations(history *api.History) error {
+	if len(history.Data.InvocationDurations) == 0 {
+		return fmt.Errorf("job %s has no invocation durations, it was trained before they were recorded", history.Id)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", "EPOCH", "TASK", "INVOCATIONS", "P50", "P95", "P99")
+	for _, epoch := range history.Data.InvocationDurations {
+		tasks := make([]string, 0, len(epoch.Tasks))
+		for task := range epoch.Tasks {
+			tasks = append(tasks, task)
+		}
+		sort.Strings(tasks)
+
+		for _, task := range tasks {
+			p := epoch.Tasks[task]
+			fmt.Fprintf(w, "%v\t%v\t%v\t%.2f\t%.2f\t%.2f\n", epoch.Epoch, task, p.Count, p.P50, p.P95, p.P99)
+		}
+	}
+	w.Flush()
+
+	return nil
+}
+
+// printEfficiency prints the efficiency curve of the job, the time and the function
+// seconds of an epoch with each parallelism relative to the fastest one, and the
+// parallelism recommended for future runs. The curve of the jobs that finished before
+// it was saved is computed from their history. The recommendation stored for the
+// function and the dataset is shown too, it can come from a more recent job
+func printEfficiency(client *kubemlClient.KubemlClient, history *api.History) error {
+	curve := history.Data.Efficiency
+	if curve == nil {
+		curve = history.Data.EfficiencyCurve()
+	}
+	if curve == nil {
+		return fmt.Errorf("job %s has no epochs to compute its efficiency from", history.Id)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", "PARALLELISM", "EPOCHS", "EPOCH TIME", "FUNCTION SECONDS", "SPEED", "COST")
+	for _, p := range curve.Points {
+		epochs := fmt.Sprint(p.Epochs)
+		if p.Epochs == 0 {
+			epochs = "interpolated"
+		}
+		mark := ""
+		if p.Parallelism == curve.Recommended {
+			mark = " (recommended)"
+		}
+		fmt.Fprintf(w, "%v%v\t%v\t%.1fs\t%.1f\t%.0f%%\t%.0f%%\n",
+			p.Parallelism, mark, epochs, p.EpochTime, p.EpochCost, p.Speed*100, p.Cost*100)
+	}
+	w.Flush()
+
+	fmt.Println()
+	fmt.Println("Summary:", curve.Summary)
+	fmt.Println("Recommended parallelism:", curve.Recommended)
+
+	task := history.Task
+	if r, err := client.V1().Histories().Recommendation(task.FunctionName, task.Dataset); err == nil && r.JobId != history.Id {
+		fmt.Printf("Stored recommendation for %s on %s: %d, from job %s\n",
+			r.Function, r.Dataset, r.Parallelism, r.JobId)
+	}
 	return nil
 }
 
 // deleteHistory deletes a history from the database given the taskId
 func deleteHistory(_ *cobra.Command, _ []string) error {
+	if batchAll == (len(taskId) > 0) {
+		return errors.New("either set the task with --id or delete the histories of all the tasks matching the filter with --all")
+	}
+	if batchAll {
+		return runJobBatch(api.JobOpDelete, "")
+	}
+
 	client, err := kubemlClient.MakeKubemlClient()
 	if err != nil {
 		return err
@@ -132,14 +269,40 @@ func listHistories(_ *cobra.Command, _ []string) error {
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
-	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "NAME", "MODEL", "DATASET", "EPOCHS", "BATCH", "LR", "PARALLELISM", "K", "STATIC", "ACCURACY", "LOSS", "TIME (s)")
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "NAME", "MODEL", "DATASET", "EPOCHS", "BATCH", "LR", "PARALLELISM", "K", "STATIC", "ACCURACY", "BEST ACCURACY", "LOSS", "GRAD NORM", "TIME (s)")
 
 	for _, h := range histories {
 
-		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
-			h.Id, h.Task.ModelType, h.Task.Dataset, h.Task.Epochs, h.Task.BatchSize, h.Task.LearningRate,
+		// the best accuracy follows the direction of the metric set in the options
+		best := "-"
+		if value, epoch, ok := h.Data.Best(api.MetricAccuracy, h.Task.Options); ok {
+			best = fmt.Sprintf("%v (epoch %v)", api.RoundMetric(value, h.Task.Options.MetricDecimals(api.MetricAccuracy)), epoch)
+		}
+
+		// histories of running or crashed jobs are saved while training
+		name := h.Id
+		if h.Paused != nil {
+			name += " (paused)"
+		} else if h.InProgress {
+			name += " (in progress)"
+		}
+		if len(h.Data.StoppedBy) > 0 {
+			name += fmt.Sprintf(" (stopped by %v)", h.Data.StoppedBy)
+		}
+		if h.Data.SanityCheck != nil && len(h.Data.SanityCheck.Error) > 0 {
+			name += " (failed sanity check)"
+		}
+		if n := len(h.Data.ContinuedEpochs); n > 0 {
+			name += fmt.Sprintf(" (continued at epoch %v)", h.Data.ContinuedEpochs[n-1])
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			name, h.Task.ModelType, h.Task.Dataset, h.Task.Epochs, h.Task.BatchSize, h.Task.LearningRate,
 			getMeanParallelism(h.Data.Parallelism), h.Task.Options.K, h.Task.Options.StaticParallelism,
-			last(h.Data.Accuracy), last(h.Data.ValidationLoss), last(h.Data.EpochDuration))
+			api.RoundMetric(last(h.Data.Accuracy), h.Task.Options.MetricDecimals(api.MetricAccuracy)), best,
+			api.RoundMetric(last(h.Data.ValidationLoss), h.Task.Options.MetricDecimals(api.MetricValidationLoss)),
+			api.RoundMetric(last(h.Data.GradNorm), api.DefaultLossDecimals),
+			last(h.Data.EpochDuration))
 	}
 
 	w.Flush()
@@ -147,6 +310,62 @@ func listHistories(_ *cobra.Command, _ []string) error {
 	return nil
 }
 
+// compareHistories prints the milestones of the jobs as columns, the
+// milestones being the ones tracked by any of the jobs
+func compareHistories(_ *cobra.Command, args []string) error {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	var histories []*api.History
+	accuracies := make(map[float64]bool)
+	for _, jobId := range args {
+		h, err := client.V1().Histories().Get(jobId)
+		if err != nil {
+			return errors.Wrapf(err, "could not get history of job %s", jobId)
+		}
+		histories = append(histories, h)
+		for _, accuracy := range h.Task.Options.Milestones() {
+			accuracies[accuracy] = true
+		}
+	}
+	milestones := make([]float64, 0, len(accuracies))
+	for accuracy := range accuracies {
+		milestones = append(milestones, accuracy)
+	}
+	sort.Float64s(milestones)
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v", "JOB", "EPOCHS", "BEST ACCURACY", "TIME (s)", "FUNCTION SECONDS")
+	for _, accuracy := range milestones {
+		fmt.Fprintf(w, "\tTO %v%%", accuracy)
+	}
+	fmt.Fprintln(w)
+
+	for _, h := range histories {
+		best := "-"
+		if value, _, ok := h.Data.Best(api.MetricAccuracy, h.Task.Options); ok {
+			best = fmt.Sprint(api.RoundMetric(value, h.Task.Options.MetricDecimals(api.MetricAccuracy)))
+		}
+		epochs := len(h.Data.EpochDuration)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%.1f\t%.1f", h.Id, epochs, best, last(h.Data.EpochDuration), h.Data.FunctionSeconds(epochs))
+
+		for _, accuracy := range milestones {
+			m := h.Data.TimeToAccuracy(accuracy, h.Task.Options)
+			if !m.Reached {
+				fmt.Fprint(w, "\tnot reached")
+				continue
+			}
+			fmt.Fprintf(w, "\t%.1fs (epoch %v, %.0f fn-s)", m.Elapsed, m.Epoch, m.FunctionSeconds)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+
+	return nil
+}
+
 func getMeanParallelism(parallelisms []float64) float64 {
 	var total float64 = 0
 	for _, p := range parallelisms {
@@ -163,12 +382,16 @@ func init() {
 	historyCmd.AddCommand(historyDeleteCmd)
 	historyCmd.AddCommand(historyListCmd)
 	historyCmd.AddCommand(historyPruneCmd)
+	historyCmd.AddCommand(historyCompareCmd)
 
 	// Get command
 	historyGetCmd.Flags().StringVar(&taskId, "id", "", "Id of the train task (required)")
+	historyGetCmd.Flags().BoolVar(&perClass, "per-class", false, "Show the accuracy of each class in every validation")
+	historyGetCmd.Flags().BoolVar(&durations, "durations", false, "Show the p50, p95 and p99 of the invocation durations of each task in every epoch")
+	historyGetCmd.Flags().BoolVar(&efficiency, "efficiency", false, "Show the speed against the cost of each parallelism the job used and the recommended parallelism")
 
 	// Delete command
-	historyDeleteCmd.Flags().StringVar(&taskId, "id", "", "Id of the train task (required)")
+	historyDeleteCmd.Flags().StringVar(&taskId, "id", "", "Id of the train task, or --all to delete the histories of the tasks matching the filter")
 
 	historyGetCmd.MarkFlagRequired("network")
 	historyDeleteCmd.MarkFlagRequired("network")
@@ -5,16 +5,31 @@ import (
 	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
 	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
+	v1 "github.com/diegostock12/kubeml/ml/pkg/controller/client/v1"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"io"
 	"io/ioutil"
+	"os"
+	"strings"
 )
 
 var (
 	// network ID and data where
 	// the datapoints are saved in JSON format
-	network  string
-	dataFile string
+	network       string
+	dataFile      string
+	serialization string
+	noCache       bool
+	inferOutput   string
+	inferNDJSON   bool
+	outputType    string
+	allowPartial  bool
+	checkInput    bool
+	strictInput   bool
+	ensemble      string
+	includeIndiv  bool
 
 	inferCmd = &cobra.Command{
 		Use:   "infer",
@@ -42,25 +57,116 @@ func infer(_ *cobra.Command, _ []string) error {
 		return errors.Wrap(err, "could not unmarshal data")
 	}
 
+	if !util.IsValidSerialization(serialization) {
+		return fmt.Errorf("unknown serialization format \"%v\"", serialization)
+	}
+
+	if err = api.ValidateOutputType(outputType); err != nil {
+		return err
+	}
+
 	req := api.InferRequest{
-		ModelId: network,
-		Data:    data,
+		Data:              data,
+		Serialization:     serialization,
+		OutputType:        outputType,
+		AllowPartial:      allowPartial,
+		CheckInput:        checkInput,
+		StrictInput:       strictInput,
+		Ensemble:          ensemble,
+		IncludeIndividual: includeIndiv,
+	}
+
+	// several comma separated networks are combined in an ensemble
+	if ids := strings.Split(network, ","); len(ids) > 1 || len(ensemble) > 0 {
+		req.ModelIds = ids
+	} else {
+		req.ModelId = network
+	}
+	if err = req.ValidateEnsemble(); err != nil {
+		return err
+	}
+	if req.IsEnsemble() && inferNDJSON {
+		return errors.New("the predictions of an ensemble are not streamed, --ndjson can not be used with --ensemble")
 	}
 
-	resp, err := client.V1().Networks().Infer(&req)
+	preds, err := client.V1().Networks().InferStream(&req, noCache, inferNDJSON)
 	if err != nil {
 		return errors.Wrap(err, "could not complete inference")
 	}
+	defer preds.Close()
+
+	// the warning is printed to the standard error
+	// so it is not mixed with the predictions
+	if warning := preds.Warning(); len(warning) > 0 {
+		fmt.Fprintln(os.Stderr, "Warning:", warning)
+	}
+	if generation, epoch, ok := preds.Snapshot(); ok {
+		fmt.Fprintf(os.Stderr, "The model is still being trained, using snapshot %d taken after epoch %d\n", generation, epoch)
+	}
+
+	// the predictions are copied as they arrive so
+	// big results are never held in memory
+	if len(inferOutput) == 0 {
+		if _, err = io.Copy(os.Stdout, preds); err != nil {
+			return errors.Wrap(err, "could not read predictions")
+		}
+		reportPartial(preds)
+		return nil
+	}
 
-	fmt.Println(string(resp))
+	f, err := os.Create(inferOutput)
+	if err != nil {
+		return errors.Wrap(err, "could not create output file")
+	}
+	if _, err = io.Copy(f, preds); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(inferOutput)
+		return errors.Wrap(err, "could not write predictions")
+	}
+	reportPartial(preds)
 	return nil
 }
 
+// reportPartial prints the number of datapoints that succeeded and failed
+// if the inference allowed partial results. It is printed to the standard
+// error so it is not mixed with the predictions
+func reportPartial(preds *v1.Predictions) {
+	if !allowPartial {
+		return
+	}
+	succeeded, failed, ok := preds.Counts()
+	if !ok {
+		fmt.Fprintln(os.Stderr, "The controller did not report the datapoints that failed")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d datapoints succeeded, %d failed\n", succeeded, failed)
+}
+
 func init() {
 	rootCmd.AddCommand(inferCmd)
 
-	inferCmd.Flags().StringVarP(&network, "network", "n", "", "Network ID (required)")
+	inferCmd.Flags().StringVarP(&network, "network", "n", "", "Network ID, or comma separated IDs of the networks of an ensemble (required)")
 	inferCmd.Flags().StringVar(&dataFile, "datafile", "", "File with the data (required)")
+	inferCmd.Flags().StringVar(&serialization, "serialization", api.SerializationJSON, "Format of the payloads sent to the function (json or msgpack)")
+	inferCmd.Flags().StringVarP(&inferOutput, "output", "o", "", "File where the predictions are written instead of the standard output")
+	inferCmd.Flags().StringVar(&outputType, "output-type", api.OutputPrediction,
+		fmt.Sprintf("Output returned for each datapoint (%v, %v or %v), logits and embeddings are returned as arrays",
+			api.OutputPrediction, api.OutputLogits, api.OutputEmbedding))
+	inferCmd.Flags().BoolVar(&inferNDJSON, "ndjson", false, "Write one prediction per line instead of a JSON object")
+	inferCmd.Flags().BoolVar(&noCache, "no-cache", false, "Do not use the cached results of the controller")
+	inferCmd.Flags().BoolVar(&allowPartial, "allow-partial", false,
+		"Return the predictions of the datapoints that succeeded along with the ones that failed instead of failing the whole request")
+	inferCmd.Flags().BoolVar(&checkInput, "check-input", false,
+		"Warn if the datapoints are out of the range or of a different type than the train set of the model, e.g. if they are not normalized")
+	inferCmd.Flags().BoolVar(&strictInput, "strict-input", false, "Like --check-input but rejects the request instead of warning")
+	inferCmd.Flags().StringVar(&ensemble, "ensemble", "",
+		fmt.Sprintf("Combine the networks by averaging their probabilities (%v) or by majority vote of their predictions (%v)",
+			api.EnsembleMean, api.EnsembleVote))
+	inferCmd.Flags().BoolVar(&includeIndiv, "include-individual", false, "Also return the predictions of each network of the ensemble")
 	inferCmd.MarkFlagRequired("network")
 	inferCmd.MarkFlagRequired("datafile")
 }
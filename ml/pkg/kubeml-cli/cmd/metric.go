@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+var (
+	metricSinceEpoch int
+	metricJSON       bool
+
+	metricCmd = &cobra.Command{
+		Use:   "metric <jobId> <name>",
+		Short: "Show the values of a single metric of a job",
+		Long: `Show the values of a single metric from the history of a job along with the
+epoch each one was recorded in, e.g. train_loss or accuracy. With --since-epoch
+only the values recorded after that epoch are shown, so a curve can be followed
+without fetching the whole history.`,
+		Args: cobra.ExactArgs(2),
+		RunE: getMetric,
+	}
+)
+
+// getMetric prints the series of a metric of a job
+func getMetric(_ *cobra.Command, args []string) error {
+	if metricSinceEpoch < 0 {
+		return fmt.Errorf("--since-epoch should not be negative, got %d", metricSinceEpoch)
+	}
+
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	series, err := client.V1().Histories().Metric(args[0], args[1], metricSinceEpoch)
+	if err != nil {
+		return errors.Wrap(err, "could not get metric")
+	}
+
+	if metricJSON {
+		data, err := json.MarshalIndent(series, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "could not encode metric")
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\n", "EPOCH", "VALUE")
+	for i, value := range series.Values {
+		fmt.Fprintf(w, "%v\t%v\n", series.Epochs[i], value)
+	}
+	w.Flush()
+
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(metricCmd)
+
+	metricCmd.Flags().IntVar(&metricSinceEpoch, "since-epoch", 0, "Only show the values recorded after this epoch")
+	metricCmd.Flags().BoolVar(&metricJSON, "json", false, "Print the series as JSON")
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	diffJSON     bool
+	exportFormat string
+	artifactFile string
+
+	modelCmd = &cobra.Command{
+		Use:   "model",
+		Short: "Inspect the models trained by the jobs",
+	}
+
+	modelDiffCmd = &cobra.Command{
+		Use:   "diff <a> <b>",
+		Short: "Show which layers changed the most between two models",
+		Long: `Compare the weights of two models layer by layer, ranking the layers by their
+relative change, the L2 distance between the weights divided by the norm of
+the weights in a. Each model is given as <jobId> for the current model of a
+job, or <jobId>@<epoch> for the checkpoint of an epoch saved in object storage.
+
+Layers present in only one of the models are listed after the table.`,
+		Args: cobra.ExactArgs(2),
+		RunE: modelDiff,
+	}
+
+	modelShowCmd = &cobra.Command{
+		Use:   "show <jobId>",
+		Short: "Show the layers of the model trained by a job",
+		Long:  summaryCmd.Long,
+		Args:  cobra.ExactArgs(1),
+		RunE:  modelSummary,
+	}
+
+	modelExportCmd = &cobra.Command{
+		Use:   "export <jobId>",
+		Short: "Export the model of a job to a format for serving, such as ONNX or TorchScript",
+		Long: `Export the model of a job by invoking its function with the export task, which loads
+the weights from redis and converts them to the format requested. The artifact is kept in the
+storage service and downloaded to the output file, verifying its checksum.
+
+The ONNX export needs the function to implement export_sample, which returns the sample input
+used to trace the network. The TorchScript export scripts the network, falling back to tracing
+it with the sample for networks that can not be scripted, and checks that the artifact loads
+with torch.jit.load. Jobs whose function did not report the capability are refused.`,
+		Args: cobra.ExactArgs(1),
+		RunE: modelExport,
+	}
+)
+
+// modelDiff prints the layers of two models ranked by how much they changed
+func modelDiff(_ *cobra.Command, args []string) error {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	diff, err := client.V1().Networks().Diff(args[0], args[1])
+	if err != nil {
+		return errors.Wrap(err, "could not compare models")
+	}
+
+	if diffJSON {
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "could not encode model diff")
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", "LAYER", "SHAPE", "L2 DISTANCE", "COSINE", "RELATIVE CHANGE")
+	for _, layer := range diff.Layers {
+		dims := make([]string, len(layer.Shape))
+		for i, d := range layer.Shape {
+			dims[i] = fmt.Sprint(d)
+		}
+		shape := fmt.Sprintf("(%v)", strings.Join(dims, ", "))
+
+		if len(layer.Incompatible) > 0 {
+			fmt.Fprintf(w, "%v\t%v\t-\t-\tincompatible: %v\n", layer.Layer, shape, layer.Incompatible)
+			continue
+		}
+
+		relative := "-"
+		if layer.RelativeChange != nil {
+			relative = fmt.Sprintf("%.2f%%", *layer.RelativeChange*100)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%.6f\t%.6f\t%v\n", layer.Layer, shape, layer.L2Distance, layer.Cosine, relative)
+	}
+	w.Flush()
+
+	if len(diff.OnlyInA) > 0 {
+		fmt.Printf("\nOnly in %v: %v\n", diff.A, strings.Join(diff.OnlyInA, ", "))
+	}
+	if len(diff.OnlyInB) > 0 {
+		fmt.Printf("\nOnly in %v: %v\n", diff.B, strings.Join(diff.OnlyInB, ", "))
+	}
+
+	return nil
+}
+
+// modelExport exports the model of a job and downloads the artifact to the output file
+func modelExport(_ *cobra.Command, args []string) error {
+	jobId := args[0]
+	if err := api.ValidateExportFormat(exportFormat); err != nil {
+		return err
+	}
+	if len(artifactFile) == 0 {
+		artifactFile = jobId + "." + api.ExportExtension(exportFormat)
+	}
+
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	artifact, err := client.V1().Networks().Export(jobId, exportFormat, func(step *api.ExportProgress) {
+		if len(step.Message) > 0 {
+			fmt.Printf("%v: %v\n", step.Stage, step.Message)
+		}
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not export model")
+	}
+	fmt.Printf("exported: %v bytes, sha256 %v\n", artifact.Size, artifact.SHA256)
+
+	body, stored, err := client.V1().Networks().Artifact(jobId, exportFormat)
+	if err != nil {
+		return errors.Wrap(err, "could not download artifact")
+	}
+	defer body.Close()
+
+	f, err := os.Create(artifactFile)
+	if err != nil {
+		return errors.Wrap(err, "could not create output file")
+	}
+
+	// the artifact is hashed while it is written so it is only read once
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), body)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = verifyArtifact(artifact, stored, size, hex.EncodeToString(h.Sum(nil)))
+	}
+	if err != nil {
+		os.Remove(artifactFile)
+		return errors.Wrap(err, "could not download artifact")
+	}
+
+	fmt.Println("Exported model of job", jobId, "to", artifactFile)
+	return nil
+}
+
+// verifyArtifact checks the downloaded artifact against the one
+// exported by the function and the one kept in the storage service
+func verifyArtifact(exported, stored *api.ModelArtifact, size int64, sum string) error {
+	if len(stored.SHA256) > 0 && stored.SHA256 != exported.SHA256 {
+		return fmt.Errorf("storage service has a different artifact, sha256 %v", stored.SHA256)
+	}
+	if size != exported.Size {
+		return fmt.Errorf("downloaded %v bytes, expected %v", size, exported.Size)
+	}
+	if sum != exported.SHA256 {
+		return fmt.Errorf("checksum mismatch, got sha256 %v", sum)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(modelCmd)
+	modelCmd.AddCommand(modelDiffCmd)
+	modelCmd.AddCommand(modelExportCmd)
+	modelCmd.AddCommand(modelShowCmd)
+
+	modelDiffCmd.Flags().BoolVar(&diffJSON, "json", false, "Print the diff as JSON")
+	modelShowCmd.Flags().BoolVar(&summaryJSON, "json", false, "Print the summary as JSON")
+	modelShowCmd.Flags().IntVar(&summaryParallelism, "parallelism", 0, "Functions included in the estimated redis footprint, each one saves a copy of the model")
+	modelExportCmd.Flags().StringVar(&exportFormat, "format", api.ExportFormatONNX, "Format the model is exported to (onnx or torchscript)")
+	modelExportCmd.Flags().StringVarP(&artifactFile, "output", "o", "", "File where the model is saved (default <jobId>.onnx or <jobId>.pt)")
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+)
+
+// pollBackoff is the factor the interval between polls grows
+// by after every poll in which the state did not change
+const pollBackoff = 2
+
+// poller spaces out the requests of the commands that poll the controller. The
+// interval starts at the base and backs off exponentially up to the max while the
+// state polled stays the same, going back to the base as soon as it changes
+type poller struct {
+	base     time.Duration
+	max      time.Duration
+	interval time.Duration
+	state    string
+}
+
+func newPoller(base, max time.Duration) *poller {
+	return &poller{base: base, max: max, interval: base}
+}
+
+// validatePollIntervals checks the intervals given to a polling command
+func validatePollIntervals(base, max time.Duration) error {
+	if base <= 0 {
+		return fmt.Errorf("the poll interval should be positive, got %v", base)
+	}
+	if max < base {
+		return fmt.Errorf("the max poll interval (%v) should not be lower than the poll interval (%v)", max, base)
+	}
+	return nil
+}
+
+// observe records the state seen in the last poll, resetting
+// the interval to the base if it is not the one seen before
+func (p *poller) observe(state string) {
+	if state != p.state {
+		p.state = state
+		p.interval = p.base
+	}
+}
+
+// next returns the time to wait before the next poll
+// and backs off the interval of the following one
+func (p *poller) next() time.Duration {
+	d := p.interval
+	p.interval *= pollBackoff
+	if p.interval > p.max {
+		p.interval = p.max
+	}
+	return d
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	statsWindow time.Duration
+	statsTop    int
+	statsJSON   bool
+
+	statsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "Show an overview of the jobs run in the cluster",
+		Long: `Show the jobs that started or finished in a time window, how many of them
+succeeded, the function seconds they used (epoch duration x parallelism) and
+the datasets and functions trained the most. The overview is cached by the
+controller for a minute.`,
+		Args: cobra.NoArgs,
+		RunE: statsOverview,
+	}
+)
+
+// statsOverview prints the overview of the cluster
+func statsOverview(_ *cobra.Command, _ []string) error {
+	if statsTop < 1 || statsTop > api.MaxStatsTop {
+		return fmt.Errorf("top should be between 1 and %d", api.MaxStatsTop)
+	}
+
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	overview, err := client.V1().Stats().Overview(statsWindow, statsTop)
+	if err != nil {
+		return errors.Wrap(err, "could not get stats overview")
+	}
+
+	if statsJSON {
+		data, err := json.MarshalIndent(overview, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "could not encode stats overview")
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v - %v\n", "WINDOW",
+		overview.Since.Format(time.RFC3339), overview.Until.Format(time.RFC3339))
+	fmt.Fprintf(w, "%v\t%v\n", "JOBS", overview.Jobs)
+	fmt.Fprintf(w, "%v\t%v\n", "RUNNING", overview.Running)
+	fmt.Fprintf(w, "%v\t%v\n", "SUCCEEDED", overview.Succeeded)
+	fmt.Fprintf(w, "%v\t%v\n", "FAILED", overview.Failed)
+	fmt.Fprintf(w, "%v\t%.1f%%\n", "SUCCESS RATE", overview.SuccessRate*100)
+	fmt.Fprintf(w, "%v\t%.0fs\n", "FUNCTION SECONDS", overview.FunctionSeconds)
+	fmt.Fprintf(w, "%v\t%v\n", "REACHED GOAL", overview.GoalReached)
+	if overview.GoalReached > 0 {
+		fmt.Fprintf(w, "%v\t%.1fs\n", "AVG TIME TO ACCURACY", overview.AvgTimeToAccuracy)
+	}
+	w.Flush()
+
+	printStatsEntries("DATASET", overview.TopDatasets)
+	printStatsEntries("FUNCTION", overview.TopFunctions)
+
+	return nil
+}
+
+// printStatsEntries prints a table of the busiest datasets or functions
+func printStatsEntries(title string, entries []api.StatsEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\n", title, "JOBS", "FUNCTION SECONDS")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%v\t%v\t%.0f\n", e.Name, e.Jobs, e.FunctionSeconds)
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+
+	statsCmd.Flags().DurationVar(&statsWindow, "window", api.DefaultStatsWindow, "Period of time covered by the overview")
+	statsCmd.Flags().IntVar(&statsTop, "top", api.DefaultStatsTop, "Number of datasets and functions listed")
+	statsCmd.Flags().BoolVar(&statsJSON, "json", false, "Print the overview as JSON")
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	summaryJSON        bool
+	summaryParallelism int
+
+	summaryCmd = &cobra.Command{
+		Use:   "summary <jobId>",
+		Short: "Show the layers of the model trained by a job",
+		Long: `Show the name, type, shape, number of parameters and bytes of each layer of the
+model trained by a job, read from the tensor storage, along with the estimated
+memory the tensors of the job take in redis. Use --json to get the summary in a
+format that can be used to prepare the inputs of the model.`,
+		Args: cobra.ExactArgs(1),
+		RunE: modelSummary,
+	}
+)
+
+// modelSummary prints the layers of the model of a job
+func modelSummary(_ *cobra.Command, args []string) error {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	summary, err := client.V1().Networks().Summary(args[0])
+	if err != nil {
+		return errors.Wrap(err, "could not get model summary")
+	}
+
+	if summaryJSON {
+		data, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "could not encode model summary")
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	printModelSummary(os.Stdout, summary, summaryParallelism)
+	return nil
+}
+
+// printModelSummary prints the layer table of a model with the totals and the
+// estimated redis footprint of a job training it with the given parallelism
+func printModelSummary(out io.Writer, summary *api.ModelSummary, parallelism int) {
+	w := tabwriter.NewWriter(out, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", "LAYER", "DTYPE", "SHAPE", "PARAMS", "BYTES")
+	for _, layer := range summary.Layers {
+		dims := make([]string, len(layer.Shape))
+		for i, d := range layer.Shape {
+			dims[i] = fmt.Sprint(d)
+		}
+		fmt.Fprintf(w, "%v\t%v\t(%v)\t%v\t%v\n", layer.Name, layer.Dtype, strings.Join(dims, ", "), layer.Parameters, layer.Bytes)
+	}
+	fmt.Fprintf(w, "%v\t\t\t%v\t%v\n", "TOTAL", summary.Parameters, summary.Bytes)
+	w.Flush()
+
+	footprint := float64(summary.RedisFootprint(parallelism)) / (1 << 20)
+	if parallelism > 0 {
+		fmt.Fprintf(out, "Estimated redis footprint: %.1fMB (reference model and %d functions)\n", footprint, parallelism)
+	} else {
+		fmt.Fprintf(out, "Estimated redis footprint: %.1fMB (reference model)\n", footprint)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(summaryCmd)
+
+	summaryCmd.Flags().BoolVar(&summaryJSON, "json", false, "Print the summary as JSON")
+	summaryCmd.Flags().IntVar(&summaryParallelism, "parallelism", 0, "Functions included in the estimated redis footprint, each one saves a copy of the model")
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkGolden compares the output with the golden file in testdata,
+// rewriting the file instead if the tests run with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got output\n%s\nwant the output in %s\n%s", got, path, want)
+	}
+}
+
+// lenetSummary is the summary of the lenet network of the examples
+func lenetSummary() *api.ModelSummary {
+	summary := &api.ModelSummary{JobId: "example"}
+	for _, layer := range []api.LayerSummary{
+		{Name: "conv1.weight", Dtype: "FLOAT", Shape: []int64{6, 1, 5, 5}, Parameters: 150, Bytes: 600},
+		{Name: "conv1.bias", Dtype: "FLOAT", Shape: []int64{6}, Parameters: 6, Bytes: 24},
+		{Name: "conv2.weight", Dtype: "FLOAT", Shape: []int64{16, 6, 5, 5}, Parameters: 2400, Bytes: 9600},
+		{Name: "conv2.bias", Dtype: "FLOAT", Shape: []int64{16}, Parameters: 16, Bytes: 64},
+		{Name: "fc1.weight", Dtype: "FLOAT", Shape: []int64{120, 256}, Parameters: 30720, Bytes: 122880},
+		{Name: "fc1.bias", Dtype: "FLOAT", Shape: []int64{120}, Parameters: 120, Bytes: 480},
+		{Name: "fc2.weight", Dtype: "DOUBLE", Shape: []int64{84, 120}, Parameters: 10080, Bytes: 80640},
+		{Name: "bn.num_batches_tracked", Dtype: "INT64", Shape: []int64{}, Parameters: 1, Bytes: 8},
+	} {
+		summary.AddLayer(layer)
+	}
+	return summary
+}
+
+func TestPrintModelSummary(t *testing.T) {
+	tests := []struct {
+		golden      string
+		summary     *api.ModelSummary
+		parallelism int
+	}{
+		{"summary.golden", lenetSummary(), 0},
+		{"summary_parallelism.golden", lenetSummary(), 16},
+		{"summary_empty.golden", &api.ModelSummary{JobId: "example"}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			printModelSummary(out, tt.summary, tt.parallelism)
+			checkGolden(t, tt.golden, out.Bytes())
+		})
+	}
+}
+
+func TestModelSummaryJSON(t *testing.T) {
+	data, err := json.MarshalIndent(lenetSummary(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "summary.json", append(data, '\n'))
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+var (
+	sweepBestId     string
+	sweepBestMetric string
+	sweepBestJSON   bool
+	sweepBestTarget float64
+
+	sweepCmd = &cobra.Command{
+		Use:   "sweep",
+		Short: "Compare the jobs of a hyperparameter sweep",
+	}
+
+	sweepBestCmd = &cobra.Command{
+		Use:   "best",
+		Short: "Show the best job of a sweep by a metric",
+		Long: `Show the job of a sweep with the best value of a metric, e.g. accuracy or
+validation_loss, along with the settings it was trained with. The jobs of a
+sweep are the ones submitted with the same --sweep-id, including the ones still
+training. The metric improves in the direction set in the options of the jobs.
+
+With --target-accuracy the jobs are instead ranked by the training time they
+took to first reach the accuracy, and all of them are listed, fastest first.`,
+		RunE: getSweepBest,
+	}
+)
+
+// getSweepBest prints the best job of a sweep and its configuration
+func getSweepBest(_ *cobra.Command, _ []string) error {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	best, err := client.V1().Histories().SweepBest(sweepBestId, sweepBestMetric, sweepBestTarget)
+	if err != nil {
+		return errors.Wrap(err, "could not get the best job of the sweep")
+	}
+
+	if sweepBestJSON {
+		data, err := json.MarshalIndent(best, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "could not encode the best job")
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if best.Target != 0 {
+		printSweepRanking(best)
+		return nil
+	}
+
+	run := best.Best
+	name := run.JobId
+	if run.InProgress {
+		name += " (in progress)"
+	}
+	decimals := run.Task.Options.MetricDecimals(best.Metric)
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\n", "JOB", name)
+	fmt.Fprintf(w, "%v\t%v (%v)\n", "METRIC", best.Metric, best.Direction)
+	fmt.Fprintf(w, "%v\t%v (epoch %v)\n", "VALUE", api.RoundMetric(run.Value, decimals), run.Epoch)
+	fmt.Fprintf(w, "%v\t%v of %v\n", "RUNS RANKED", best.Runs-len(best.Pending), best.Runs)
+	fmt.Fprintf(w, "%v\t%v\n", "MODEL", run.Task.ModelType)
+	fmt.Fprintf(w, "%v\t%v\n", "DATASET", run.Task.Dataset)
+	fmt.Fprintf(w, "%v\t%v\n", "EPOCHS", run.Task.Epochs)
+	fmt.Fprintf(w, "%v\t%v\n", "BATCH", run.Task.BatchSize)
+	fmt.Fprintf(w, "%v\t%v\n", "LR", run.Task.LearningRate)
+	w.Flush()
+
+	// the options hold the rest of the hyperparameters of the job
+	options, err := json.MarshalIndent(run.Task.Options, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode the options of the job")
+	}
+	fmt.Printf("\nOptions:\n%s\n", options)
+
+	return nil
+}
+
+// printSweepRanking prints the jobs of a sweep ranked by the time
+// they took to reach the target accuracy, and the ones that did not
+func printSweepRanking(best *api.SweepBest) {
+	fmt.Printf("Jobs of sweep %v ranked by the time to %v%% accuracy\n\n", best.SweepId, best.Target)
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "RANK", "JOB", "TIME (s)", "EPOCH", "BATCH", "LR", "PARALLELISM")
+	for i, run := range best.Ranked {
+		name := run.JobId
+		if run.InProgress {
+			name += " (in progress)"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%.1f\t%v\t%v\t%v\t%v\n",
+			i+1, name, run.Value, run.Epoch, run.Task.BatchSize, run.Task.LearningRate, run.Task.Options.DefaultParallelism)
+	}
+	for _, jobId := range best.Pending {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "-", jobId, "not reached", "-", "-", "-", "-")
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(sweepCmd)
+	sweepCmd.AddCommand(sweepBestCmd)
+
+	sweepBestCmd.Flags().StringVar(&sweepBestId, "sweep-id", "", "Id of the sweep (required)")
+	sweepBestCmd.Flags().StringVar(&sweepBestMetric, "metric", api.MetricAccuracy, "Metric the jobs are ranked by")
+	sweepBestCmd.Flags().BoolVar(&sweepBestJSON, "json", false, "Print the best job as JSON")
+	sweepBestCmd.Flags().Float64Var(&sweepBestTarget, "target-accuracy", 0, "Rank the jobs by the time they took to reach this accuracy instead")
+
+	sweepBestCmd.MarkFlagRequired("sweep-id")
+}
@@ -1,22 +1,38 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
 	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
 	"github.com/fission/fission/pkg/crd"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"os"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 )
 
 const KubemlNamespace = "kubeml"
 
+// traceRefreshInterval is the default time between requests when following the
+// trace of the scheduler, which backs off up to traceMaxRefreshInterval while
+// there are no new decisions
+const (
+	traceRefreshInterval    = 2 * time.Second
+	traceMaxRefreshInterval = 30 * time.Second
+)
+
 var (
-	short bool
-	id    string
+	short      bool
+	id         string
+	pauseUntil string
+
+	tracePollInterval    time.Duration
+	traceMaxPollInterval time.Duration
 
 	tasksCmd = &cobra.Command{
 		Use:   "task",
@@ -35,6 +51,53 @@ var (
 		RunE:  stopTask,
 	}
 
+	tasksStatusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Get the status of a running task",
+		RunE:  taskStatus,
+	}
+
+	tasksTraceCmd = &cobra.Command{
+		Use:   "trace",
+		Short: "Show the scheduling decisions of a task started with --follow-scheduler",
+		RunE:  taskTrace,
+	}
+
+	tasksSetLogLevelCmd = &cobra.Command{
+		Use:   "set-loglevel <id> <level>",
+		Short: "Change the log level of a running task (debug, info, warn or error)",
+		Args:  cobra.ExactArgs(2),
+		RunE:  setTaskLogLevel,
+	}
+
+	tasksPauseCmd = &cobra.Command{
+		Use:   "pause <id>",
+		Short: "Pause a running task after the epoch in progress, releasing its functions until it is resumed",
+		Args:  cobra.ExactArgs(1),
+		RunE:  pauseTask,
+	}
+
+	tasksResumeCmd = &cobra.Command{
+		Use:   "resume <id>",
+		Short: "Resume a paused task",
+		Args:  cobra.ExactArgs(1),
+		RunE:  resumeTask,
+	}
+
+	tasksInvocationsCmd = &cobra.Command{
+		Use:   "invocations <id>",
+		Short: "List the function invocations of a running task in flight, oldest first",
+		Args:  cobra.ExactArgs(1),
+		RunE:  taskInvocations,
+	}
+
+	tasksDescribeCmd = &cobra.Command{
+		Use:   "describe <id>",
+		Short: "Show the options a task runs with after the changes made by KubeML, and why they were made",
+		Args:  cobra.ExactArgs(1),
+		RunE:  describeTask,
+	}
+
 	tasksPruneCmd = &cobra.Command{
 		Use:   "prune",
 		Short: "Prune finished tasks",
@@ -43,6 +106,13 @@ var (
 )
 
 func stopTask(_ *cobra.Command, _ []string) error {
+	if batchAll == (len(id) > 0) {
+		return errors.New("either set the task with --id or stop all the tasks matching the filter with --all")
+	}
+	if batchAll {
+		return runJobBatch(api.JobOpStop, "")
+	}
+
 	// make fission client
 	client, err := kubemlClient.MakeKubemlClient()
 	if err != nil {
@@ -58,6 +128,320 @@ func stopTask(_ *cobra.Command, _ []string) error {
 
 }
 
+// pauseTask pauses a running task, which resumes by itself
+// at the time set with --until, if any
+func pauseTask(_ *cobra.Command, args []string) error {
+	var until time.Time
+	if len(pauseUntil) > 0 {
+		var err error
+		if until, err = parseUntil(pauseUntil, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	if err = client.V1().Tasks().Pause(args[0], until); err != nil {
+		return errors.Wrap(err, "could not pause task")
+	}
+
+	if until.IsZero() {
+		fmt.Printf("Task %v will pause after the epoch in progress\n", args[0])
+	} else {
+		fmt.Printf("Task %v will pause after the epoch in progress until %v\n", args[0], until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// parseUntil parses the time a paused task resumes, either as
+// an RFC3339 time or as a duration from now such as 1h30m
+func parseUntil(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --until \"%v\", expected an RFC3339 time or a duration", s)
+	}
+	return t, nil
+}
+
+// resumeTask resumes a paused task
+func resumeTask(_ *cobra.Command, args []string) error {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	if err = client.V1().Tasks().Resume(args[0]); err != nil {
+		return errors.Wrap(err, "could not resume task")
+	}
+
+	fmt.Printf("Resumed task %v\n", args[0])
+	return nil
+}
+
+// describeTask prints the effective options of a task along with the changes made
+// to its request after it was sent. Finished tasks are described from their history
+func describeTask(_ *cobra.Command, args []string) error {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	// running tasks only have a history once it is first flushed
+	var req api.TrainRequest
+	var parallelism int
+	history, herr := client.V1().Histories().Get(args[0])
+	if task, err := client.V1().Tasks().Get(args[0]); err == nil {
+		req = task.Parameters
+		parallelism = task.Job.State.Parallelism
+	} else {
+		if herr != nil {
+			return errors.Wrap(err, "could not find task")
+		}
+		req = history.Task
+		if n := len(history.Data.Parallelism); n > 0 {
+			parallelism = int(history.Data.Parallelism[n-1])
+		}
+	}
+
+	effective := req.Effective
+	if effective == nil {
+		fmt.Println("The task was submitted before the effective options were recorded, showing its request")
+		effective = &api.EffectiveOptions{Epochs: req.Epochs, BatchSize: req.BatchSize, Options: req.Options}
+	}
+
+	options, err := json.MarshalIndent(effective.Options, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode options")
+	}
+	if len(req.Command) > 0 {
+		fmt.Printf("Command: %v\n", req.CommandLine())
+	}
+	fmt.Printf("Epochs: %v\n", effective.Epochs)
+	fmt.Printf("Batch size: %v\n", effective.BatchSize)
+	fmt.Printf("Options: %v\n", string(options))
+
+	if len(effective.Changes) == 0 {
+		fmt.Println("No changes were made to the request")
+	} else {
+		fmt.Println("Changes:")
+		w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", "FIELD", "ORIGINAL", "EFFECTIVE", "REASON")
+		for _, c := range effective.Changes {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", c.Field, c.Original, c.Effective, c.Reason)
+		}
+		w.Flush()
+	}
+
+	if herr == nil {
+		if wait, share, ok := history.Data.TotalBarrierWait(); ok {
+			fmt.Printf("Barrier wait: %.1f function seconds blocked at the merges, %.1f%% of the function time\n",
+				wait, share*100)
+		}
+	}
+
+	// the model is only in redis while the job runs or until it is cleaned up
+	summary, err := client.V1().Networks().Summary(args[0])
+	if err != nil {
+		fmt.Printf("Model: not available (%v)\n", err)
+		return nil
+	}
+	fmt.Println("Model:")
+	printModelSummary(os.Stdout, summary, parallelism)
+
+	return nil
+}
+
+// setTaskLogLevel changes the log level of a running task
+func setTaskLogLevel(_ *cobra.Command, args []string) error {
+	if err := api.ValidateLogLevel(args[1]); err != nil {
+		return err
+	}
+
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	if err = client.V1().Tasks().SetLogLevel(args[0], args[1]); err != nil {
+		return errors.Wrap(err, "could not set log level")
+	}
+
+	fmt.Printf("Set log level of task %v to %v\n", args[0], args[1])
+	return nil
+}
+
+// taskStatus prints the current state of a running task
+// along with the estimated remaining time
+func taskStatus(_ *cobra.Command, _ []string) error {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	task, err := client.V1().Tasks().Get(id)
+	if err != nil {
+		return err
+	}
+
+	eta := "unknown"
+	if task.Job.State.ETA != nil {
+		eta = fmt.Sprintf("%v (%v confidence)",
+			time.Duration(task.Job.State.ETA.Seconds)*time.Second, task.Job.State.ETA.Confidence)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\n", "ID", task.Job.JobId)
+	fmt.Fprintf(w, "%v\t%v\n", "FUNCTION", task.Parameters.FunctionName)
+	fmt.Fprintf(w, "%v\t%v\n", "DATASET", task.Parameters.Dataset)
+	fmt.Fprintf(w, "%v\t%v\n", "EPOCHS", task.Parameters.Epochs)
+	fmt.Fprintf(w, "%v\t%v\n", "PARALLELISM", task.Job.State.Parallelism)
+	if task.Parameters.ScratchGB > 0 {
+		fmt.Fprintf(w, "%v\t%vGB\n", "SCRATCH", task.Parameters.ScratchGB)
+	}
+	if task.Job.State.RedisMemory > 0 {
+		memory := fmt.Sprintf("%.1fMB", float64(task.Job.State.RedisMemory)/(1<<20))
+		if task.Job.RedisBudget > 0 {
+			memory += fmt.Sprintf(" / %.1fMB", float64(task.Job.RedisBudget)/(1<<20))
+		}
+		fmt.Fprintf(w, "%v\t%v\n", "REDIS MEMORY", memory)
+	}
+	if len(task.Parameters.NormalizationMean) > 0 {
+		fmt.Fprintf(w, "%v\tmean=%v std=%v\n", "NORMALIZATION",
+			task.Parameters.NormalizationMean, task.Parameters.NormalizationStd)
+	}
+	logLevel := task.Parameters.Options.LogLevel
+	if len(logLevel) == 0 {
+		logLevel = api.LogLevelDebug
+	}
+	fmt.Fprintf(w, "%v\t%v\n", "LOG LEVEL", logLevel)
+	if pause := task.Job.State.MergePaused; pause != nil {
+		fmt.Fprintf(w, "%v\tepoch %v, waiting for redis for %v (%v)\n", "MERGE PAUSED",
+			pause.Epoch, time.Since(pause.Since).Round(time.Second), pause.Reason)
+	}
+	if pause := task.Job.State.Paused; pause != nil {
+		paused := fmt.Sprintf("after epoch %v for %v", pause.Epoch, time.Since(pause.Since).Round(time.Second))
+		if !pause.Until.IsZero() {
+			paused += fmt.Sprintf(", resumes at %v", pause.Until.Format(time.RFC3339))
+		}
+		fmt.Fprintf(w, "%v\t%v\n", "PAUSED", paused)
+	}
+	fmt.Fprintf(w, "%v\t%v\n", "ETA", eta)
+	w.Flush()
+
+	return nil
+}
+
+// taskInvocations prints the invocations of a task in flight sorted by age,
+// marking the stragglers that run for longer than the rest of their task
+func taskInvocations(_ *cobra.Command, args []string) error {
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	table, err := client.V1().Tasks().Invocations(args[0])
+	if err != nil {
+		return err
+	}
+	if len(table.Invocations) == 0 {
+		fmt.Println("No invocations in flight")
+		return nil
+	}
+
+	now := time.Now()
+	thresholds := table.StragglerThresholds(now)
+	stragglers := 0
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+		"FUNC", "TASK", "EPOCH", "ITERATION", "SHARDS", "AGE", "ATTEMPT", "TRANSPORT")
+	for _, inv := range table.Invocations {
+		shards := "-"
+		if inv.Shards != nil {
+			shards = fmt.Sprintf("%v-%v", inv.Shards[0], inv.Shards[1])
+		}
+		age := now.Sub(inv.Start)
+		ageText := age.Round(time.Second).String()
+		if age > thresholds[inv.Task] {
+			ageText += " (straggler)"
+			stragglers++
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			inv.FuncId, inv.Task, inv.Epoch, inv.Iteration, shards, ageText, inv.Attempt, inv.Transport)
+	}
+	w.Flush()
+
+	if stragglers > 0 {
+		fmt.Printf("\n%d stragglers, running for over %v times the median age of their task\n",
+			stragglers, api.StragglerFactor)
+		if table.StragglerTimeout > 0 {
+			fmt.Printf("The train invocations are stragglers after the timeout of the job, %v\n",
+				time.Duration(table.StragglerTimeout*float64(time.Second)).Round(time.Millisecond))
+		}
+	}
+	if table.Untracked > 0 {
+		fmt.Printf("%d more invocations in flight are not tracked\n", table.Untracked)
+	}
+	return nil
+}
+
+// taskTrace prints the scheduling decisions taken for a task. If follow is set
+// it keeps printing the new decisions until the task finishes
+func taskTrace(_ *cobra.Command, _ []string) error {
+	if err := validatePollIntervals(tracePollInterval, traceMaxPollInterval); err != nil {
+		return err
+	}
+
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+		"REQUEST", "OPERATION", "PARALLELISM", "EPOCH TIME", "REFERENCE TIME", "NEW PARALLELISM", "REASON")
+
+	printed := 0
+	p := newPoller(tracePollInterval, traceMaxPollInterval)
+	for {
+		trace, err := client.V1().Tasks().Trace(id)
+		if err != nil {
+			return err
+		}
+
+		// the trace is kept by the scheduler in memory, so it starts
+		// over if the scheduler restarts and is then printed again
+		if printed > len(trace) {
+			printed = 0
+		}
+
+		for _, d := range trace[printed:] {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%.2fs\t%.2fs\t%v\t%v\n",
+				d.Request, d.Operation, d.State.Parallelism, d.State.ElapsedTime,
+				d.ReferenceTime, d.Parallelism, d.Reason)
+		}
+		printed = len(trace)
+		w.Flush()
+		p.observe(strconv.Itoa(printed))
+
+		if !follow {
+			return nil
+		}
+
+		// stop following once the task is no longer running
+		if _, err := client.V1().Tasks().Get(id); err != nil {
+			return nil
+		}
+		time.Sleep(p.next())
+	}
+}
+
 // pruneTasks deletes all the tasks from the namespace that are
 // still left after finishing
 func pruneTasks(_ *cobra.Command, _ []string) error {
@@ -140,12 +524,16 @@ func listTasks(_ *cobra.Command, _ []string) error {
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
-	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "NAME", "FUNCTION", "DATASET", "MODEL", "EPOCHS", "BATCH", "LR")
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "NAME", "STATE", "FUNCTION", "DATASET", "MODEL", "EPOCHS", "BATCH", "LR")
 
 	// Display functions that use the default environment
 	for _, task := range tasks {
-		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
-			task.Job.JobId, task.Parameters.FunctionName, task.Parameters.Dataset,
+		state := api.JobRunning
+		if task.Job.State.Paused != nil {
+			state = api.JobPaused
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			task.Job.JobId, state, task.Parameters.FunctionName, task.Parameters.Dataset,
 			task.Parameters.ModelType, task.Parameters.Epochs, task.Parameters.BatchSize, task.Parameters.LearningRate)
 	}
 
@@ -158,10 +546,28 @@ func init() {
 	rootCmd.AddCommand(tasksCmd)
 	tasksCmd.AddCommand(tasksListCmd)
 	tasksCmd.AddCommand(tasksStopCmd)
+	tasksCmd.AddCommand(tasksStatusCmd)
+	tasksCmd.AddCommand(tasksTraceCmd)
 	tasksCmd.AddCommand(tasksPruneCmd)
+	tasksCmd.AddCommand(tasksSetLogLevelCmd)
+	tasksCmd.AddCommand(tasksPauseCmd)
+	tasksCmd.AddCommand(tasksResumeCmd)
+	tasksCmd.AddCommand(tasksDescribeCmd)
+	tasksCmd.AddCommand(tasksInvocationsCmd)
+
+	tasksPauseCmd.Flags().StringVar(&pauseUntil, "until", "",
+		"Resume the task by itself at this time, either an RFC3339 time or a duration from now such as 1h")
 
 	tasksListCmd.Flags().BoolVar(&short, "short", false, "Trigger short format")
 
-	tasksStopCmd.Flags().StringVar(&id, "id", "", "Id of the task")
-	tasksStopCmd.MarkFlagRequired("id")
+	tasksStopCmd.Flags().StringVar(&id, "id", "", "Id of the task, or --all to stop the tasks matching the filter")
+
+	tasksStatusCmd.Flags().StringVar(&id, "id", "", "Id of the task")
+	tasksStatusCmd.MarkFlagRequired("id")
+
+	tasksTraceCmd.Flags().StringVar(&id, "id", "", "Id of the task")
+	tasksTraceCmd.Flags().BoolVarP(&follow, "follow", "f", false, "Keep printing new decisions until the task finishes")
+	tasksTraceCmd.Flags().DurationVar(&tracePollInterval, "poll-interval", traceRefreshInterval, "Time between checks for new decisions when following, doubled while there are none")
+	tasksTraceCmd.Flags().DurationVar(&traceMaxPollInterval, "max-poll-interval", traceMaxRefreshInterval, "Longest time between checks for new decisions when following")
+	tasksTraceCmd.MarkFlagRequired("id")
 }
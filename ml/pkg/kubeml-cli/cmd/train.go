@@ -1,14 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
 	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
-	"github.com/fission/fission/pkg/crd"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
 	"github.com/hashicorp/go-multierror"
 	"github.com/spf13/cobra"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"io"
+	"os"
+	"text/tabwriter"
 )
 
 const (
@@ -31,6 +34,73 @@ var (
 	K                  int
 	sparseAvg          bool    // if true, it means we only synchronize once per epoch
 	goalAccuracy       float64 // accuracy objective, after which we'll stop the training
+	goalScale          string
+	canaryBatchSize    int
+	scratchGB          int
+	trainSerialization string
+	followScheduler    bool
+	accuracyDecimals   int
+	lossDecimals       int
+	normalizationMean  []float64
+	normalizationStd   []float64
+	validationQuorum   float64
+	schedulerTimeout   int
+	redisBudgetMB      int
+	dryRun             bool
+	explain            bool
+	metricDirection    map[string]string
+	notifyURL          string
+	stopWhen           []string
+	throughputTrigger  float64
+	plateauTrigger     float64
+	budgetOverride     bool
+	shuffleSeed        int64
+	auditData          bool
+	logLevel           string
+	checkpointBackend  string
+	resumeFrom         string
+	skipSanityCheck    bool
+	startupProbe       bool
+	maxInitialLoss     float64
+	minImprovement     float64
+	improvementWindow  int
+	extraMetrics       []string
+	invocationMode     string
+	keepLast           int
+	keepBest           int
+	keepBestBy         string
+	invocationTimeout  int
+	quietMargin        float64
+	globalBatchSize    int
+	scaleLR            bool
+	balanceByCapacity  bool
+	useClassWeights    bool
+	classWeights       []float64
+	validationModel    string
+	validationFailure  string
+	validationRetries  int
+	milestones         []float64
+	maxEpochCost       float64
+	noLiveInference    bool
+	redisOutageGrace   int
+	lossReduction      string
+	mergeOperator      string
+	byzantineTolerance int
+	looseResponses     bool
+	maxParallelism     int
+	policyWindow       int
+	syncLayers         []string
+	devicesPerFunction int
+	durationBuckets    string
+	stragglerTimeout   string
+	warmUpFunctions    bool
+	adaptiveK          bool
+	kTargetOverhead    float64
+	maxK               int
+	syncTopology       string
+	useSuggestions     bool
+	tags               map[string]string
+	sweepId            string
 
 	trainCmd = &cobra.Command{
 		Use:   "train",
@@ -41,7 +111,7 @@ var (
 
 // train builds the request and sends it to the controller so
 // the job can be scheduled
-func train(_ *cobra.Command, _ []string) error {
+func train(cmd *cobra.Command, _ []string) error {
 	client, err := kubemlClient.MakeKubemlClient()
 	if err != nil {
 		return err
@@ -53,6 +123,21 @@ func train(_ *cobra.Command, _ []string) error {
 		K = -1
 	}
 
+	stopRules, err := parseStopRules(stopWhen)
+	if err != nil {
+		return err
+	}
+
+	overrides, err := parseLayerSyncOverrides(syncLayers)
+	if err != nil {
+		return err
+	}
+
+	buckets, err := parseDurationBuckets(durationBuckets)
+	if err != nil {
+		return err
+	}
+
 	req := api.TrainRequest{
 		ModelType:    "example",
 		BatchSize:    batchSize,
@@ -60,13 +145,102 @@ func train(_ *cobra.Command, _ []string) error {
 		Dataset:      dataset,
 		LearningRate: lr,
 		FunctionName: functionName,
+		ScratchGB:    scratchGB,
 		Options: api.TrainOptions{
-			DefaultParallelism: defaultParallelism,
-			StaticParallelism:  staticParallelism,
-			ValidateEvery:      validateEvery,
-			K:                  K,
-			GoalAccuracy:       goalAccuracy,
+			DefaultParallelism:      defaultParallelism,
+			StaticParallelism:       staticParallelism,
+			ValidateEvery:           validateEvery,
+			K:                       K,
+			GoalAccuracy:            goalAccuracy,
+			GoalScale:               goalScale,
+			CanaryBatchSize:         canaryBatchSize,
+			Serialization:           trainSerialization,
+			TraceScheduler:          followScheduler,
+			AccuracyDecimals:        accuracyDecimals,
+			LossDecimals:            lossDecimals,
+			ValidationQuorum:        validationQuorum,
+			SchedulerTimeout:        schedulerTimeout,
+			RedisBudgetMB:           redisBudgetMB,
+			MetricDirection:         metricDirection,
+			NotifyURL:               notifyURL,
+			StopRules:               stopRules,
+			ThroughputTrigger:       throughputTrigger,
+			PlateauTrigger:          plateauTrigger,
+			ShuffleSeed:             shuffleSeed,
+			AuditData:               auditData,
+			LogLevel:                logLevel,
+			CheckpointBackend:       checkpointBackend,
+			ResumeFrom:              resumeFrom,
+			SkipSanityCheck:         skipSanityCheck,
+			StartupProbe:            startupProbe,
+			MaxInitialLoss:          maxInitialLoss,
+			MinTrainImprovement:     minImprovement,
+			ImprovementWindow:       improvementWindow,
+			Metrics:                 extraMetrics,
+			InvocationMode:          invocationMode,
+			KeepLast:                keepLast,
+			KeepBest:                keepBest,
+			KeepBestBy:              keepBestBy,
+			InvocationTimeout:       invocationTimeout,
+			QuietMargin:             quietMargin,
+			GlobalBatchSize:         globalBatchSize,
+			ScaleLRWithParallelism:  scaleLR,
+			BalanceByCapacity:       balanceByCapacity,
+			UseClassWeights:         useClassWeights,
+			ClassWeights:            classWeights,
+			ValidationModel:         validationModel,
+			ValidationFailurePolicy: validationFailure,
+			ValidationRetries:       validationRetries,
+			AccuracyMilestones:      milestones,
+			MaxEpochCost:            maxEpochCost,
+			AllowLiveInference:      allowLiveInference(),
+			RedisOutageGrace:        redisOutageGrace,
+			LossReduction:           lossReduction,
+			MergeOperator:           mergeOperator,
+			ByzantineTolerance:      byzantineTolerance,
+			LooseResponses:          looseResponses,
+			MaxParallelism:          maxParallelism,
+			PolicyWindow:            policyWindow,
+			LayerSyncOverrides:      overrides,
+			DevicesPerFunction:      devicesPerFunction,
+			DurationBuckets:         buckets,
+			StragglerTimeout:        stragglerTimeout,
+			WarmUpFunctions:         warmUpFunctions,
+			AdaptiveK:               adaptiveK,
+			KTargetOverhead:         kTargetOverhead,
+			MaxK:                    maxK,
+			SyncTopology:            syncTopology,
 		},
+		NormalizationMean: normalizationMean,
+		NormalizationStd:  normalizationStd,
+		BudgetOverride:    budgetOverride,
+		Tags:              tags,
+		// keep the command that submitted the job to reproduce it
+		Command: api.RedactCommand(append([]string{"kubeml"}, os.Args[1:]...)),
+	}
+
+	// only the options left unset are filled from the suggestions
+	if useSuggestions {
+		if !cmd.Flags().Changed("parallelism") {
+			req.Options.UseSuggestions = append(req.Options.UseSuggestions, api.SuggestParallelism)
+		}
+		if !cmd.Flags().Changed("K") && !sparseAvg {
+			req.Options.UseSuggestions = append(req.Options.UseSuggestions, api.SuggestK)
+		}
+	}
+
+	// the sweep id is a shortcut for its tag
+	if len(sweepId) > 0 {
+		if req.Tags == nil {
+			req.Tags = make(map[string]string)
+		}
+		req.Tags[api.TagSweep] = sweepId
+	}
+
+	// with a global batch the batch of the functions
+	// is derived from it, so the check applies to it
+	if req.Options.GlobalBatchSize > 0 {
+		req.BatchSize = req.FunctionBatchSize(req.Options.DefaultParallelism * req.Options.FunctionDevices())
 	}
 
 	// validate the train request fields
@@ -74,12 +248,28 @@ func train(_ *cobra.Command, _ []string) error {
 		return err
 	}
 
-	id, err := client.V1().Networks().Train(&req)
+	if explain {
+		return explainTrainRequest(&req)
+	}
+	if dryRun {
+		return planTrainRequest(client, &req)
+	}
+
+	resp, err := client.V1().Networks().Train(&req)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(id)
+	if len(resp.Warning) > 0 {
+		fmt.Fprintln(os.Stderr, "Warning:", resp.Warning)
+	}
+	if len(resp.NotifySecret) > 0 {
+		fmt.Fprintln(os.Stderr, "Notification secret:", resp.NotifySecret)
+	}
+	if resp.Suggestions != nil {
+		printSuggestions(os.Stderr, resp.Suggestions)
+	}
+	fmt.Println(resp.Id)
 	return nil
 
 }
@@ -100,6 +290,196 @@ func validateTrainRequest(client *kubemlClient.KubemlClient, req *api.TrainReque
 		e = multierror.Append(e, errors.New("epochs should be a positive value"))
 	}
 
+	// check canary batch size
+	if req.Options.CanaryBatchSize < 0 {
+		e = multierror.Append(e, errors.New("canary batch size should not be negative"))
+	}
+
+	// check scratch volume size, the upper limit is checked by the controller
+	if req.ScratchGB < 0 {
+		e = multierror.Append(e, errors.New("scratch volume size should not be negative"))
+	}
+
+	// check normalization stats
+	if err := req.ValidateNormalization(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check tags
+	if err := req.ValidateTags(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check validation quorum
+	if req.Options.ValidationQuorum < 0 || req.Options.ValidationQuorum > 1 {
+		e = multierror.Append(e, errors.New("validation quorum should be between 0 and 1"))
+	}
+
+	// check scheduler timeout
+	if req.Options.SchedulerTimeout < 0 {
+		e = multierror.Append(e, errors.New("scheduler timeout should not be negative"))
+	}
+
+	// check scheduler triggers
+	if req.Options.ThroughputTrigger < 0 || req.Options.PlateauTrigger < 0 {
+		e = multierror.Append(e, errors.New("scheduler triggers should not be negative"))
+	}
+
+	// check log level
+	if err := api.ValidateLogLevel(req.Options.LogLevel); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check checkpoint backend
+	if err := api.ValidateCheckpointBackend(req.Options.CheckpointBackend); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check redis budget
+	if req.Options.RedisBudgetMB < 0 {
+		e = multierror.Append(e, errors.New("redis budget should not be negative"))
+	}
+
+	// check metric directions
+	if err := req.Options.ValidateMetricDirection(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check stop rules
+	if err := req.Options.ValidateStopRules(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check stall guard
+	if err := req.Options.ValidateStallGuard(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check optional metrics
+	if err := req.Options.ValidateMetrics(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check checkpoint retention
+	if err := req.Options.ValidateRetention(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check invocation mode
+	if err := req.Options.ValidateInvocation(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check redis outage grace
+	if err := req.Options.ValidateOutageGrace(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check validation model
+	if err := req.Options.ValidateValidationModel(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check accuracy milestones
+	if err := req.Options.ValidateAccuracyMilestones(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check max epoch cost
+	if err := req.Options.ValidateMaxEpochCost(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check validation failure policy
+	if err := req.Options.ValidateValidationFailurePolicy(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check byzantine tolerance
+	if err := req.Options.ValidateByzantineTolerance(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check duration buckets
+	if err := req.Options.ValidateDurationBuckets(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check straggler timeout
+	if err := req.Options.ValidateStragglerTimeout(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check loss reduction
+	if err := req.Options.ValidateLossReduction(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check max parallelism
+	if err := req.Options.ValidateMaxParallelism(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check policy window
+	if err := req.Options.ValidatePolicyWindow(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check layer sync overrides
+	if err := req.Options.ValidateLayerSync(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check adaptive K
+	if err := req.Options.ValidateAdaptiveK(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check sync topology
+	if err := req.Options.ValidateSyncTopology(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check quiet margin
+	if err := req.Options.ValidateQuietMargin(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check goal accuracy scale
+	if err := req.Options.ValidateGoalScale(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check global batch
+	if err := req.Options.ValidateGlobalBatch(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check devices per function
+	if err := req.Options.ValidateDevices(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check startup probe
+	if err := req.Options.ValidateStartupProbe(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check class weights
+	if err := req.Options.ValidateClassWeights(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	// check metric precision
+	if req.Options.AccuracyDecimals < 0 || req.Options.LossDecimals < 0 {
+		e = multierror.Append(e, errors.New("metric decimal places should not be negative"))
+	}
+
+	// check serialization format
+	if !util.IsValidSerialization(req.Options.Serialization) {
+		e = multierror.Append(e, fmt.Errorf("unknown serialization format \"%v\"", req.Options.Serialization))
+	}
+
 	// check learning rate
 	if lr <= 0 {
 		e = multierror.Append(e, errors.New("learning rate should be bigger than zero"))
@@ -110,40 +490,339 @@ func validateTrainRequest(client *kubemlClient.KubemlClient, req *api.TrainReque
 		e = multierror.Append(e, fmt.Errorf("dataset \"%v\" does not exist", dataset))
 	}
 
-	// check function exists
-	if exists, err := functionExists(functionName); err != nil || !exists {
-		e = multierror.Append(e, fmt.Errorf("function \"%v\" does not exist", functionName))
-	}
+	// the function is checked by the controller, which caches the
+	// functions so the CLI does not need to reach the kubernetes API
 
 	return e.ErrorOrNil()
 }
 
-// datasetExists returns true if dataset is present in kubeml
-func datasetExists(client *kubemlClient.KubemlClient, name string) (bool, error) {
+// planTrainRequest prints the merges per epoch that the request would run with
+// its starting parallelism, the suggestions from the previous runs of the function
+// and the dataset and the parallelism recommended by the last job that trained
+// them, without submitting it. The options taken from the suggestions are filled
+func planTrainRequest(client *kubemlClient.KubemlClient, req *api.TrainRequest) error {
+	shards, err := trainShards(req.Dataset)
+	if err != nil {
+		return err
+	}
 
-	_, err := client.V1().Datasets().Get(name)
+	// the suggestions are only a hint, so they are left out if they can't be read
+	if s, err := client.V1().Histories().Suggestions(req.FunctionName, req.Dataset); err == nil {
+		printSuggestions(os.Stdout, s)
+		req.ApplySuggestions(s)
+		if req.Effective != nil {
+			for _, change := range req.Effective.Changes {
+				fmt.Printf("Using %s %s, %s\n", change.Field, change.Effective, change.Reason)
+			}
+		}
+	}
+
+	iterations := api.IterationsPerEpoch(shards, req.StepBatchSize(), req.Options.DefaultParallelism, req.Options.SyncPeriod())
+	fmt.Println("Iterations per epoch:", iterations)
+	if warning := api.IterationsWarning(req.Options.SyncPeriod(), iterations, api.DefaultMaxIterationsPerEpoch); len(warning) > 0 {
+		fmt.Fprintln(os.Stderr, "Warning:", warning)
+	}
+
+	// the recommendation is only a hint, so it is left out if it can't be read
+	if r, err := client.V1().Histories().Recommendation(req.FunctionName, req.Dataset); err == nil {
+		start := "the scheduler starts from it"
+		if req.Options.StaticParallelism {
+			start = "the job is static and keeps its parallelism"
+		}
+		fmt.Printf("Recommended parallelism: %d from job %s, %.0f%% of its fastest speed at %.0f%% of the cost, %s\n",
+			r.Parallelism, r.JobId, r.Speed*100, r.Cost*100, start)
+	}
+	return nil
+}
+
+// printSuggestions prints the settings suggested from the previous runs
+func printSuggestions(out io.Writer, s *api.Suggestions) {
+	if s.Runs == 0 {
+		fmt.Fprintln(out, "Suggestions:", s.Note)
+		return
+	}
+
+	suggested := fmt.Sprintf("median epoch of %.1fs", s.EpochDuration)
+	if s.Parallelism > 0 {
+		suggested += fmt.Sprintf(", parallelism %d", s.Parallelism)
+	}
+	if s.K != nil {
+		suggested += fmt.Sprintf(", K %d", *s.K)
+	}
+	fmt.Fprintf(out, "Suggestions from the last %d runs: %s\n", s.Runs, suggested)
+}
+
+// explainTrainRequest prints the execution plan of the request with the defaults
+// of the cluster applied, without submitting it. The parallelism and the iterations
+// are the ones of the first epoch, the scheduler might change them afterwards
+func explainTrainRequest(req *api.TrainRequest) error {
+	shards, err := trainShards(req.Dataset)
 	if err != nil {
-		return false, err
+		return err
 	}
 
-	return true, nil
+	opts := req.Options
+	parallelism := opts.DefaultParallelism
+	if parallelism <= 0 {
+		parallelism = api.DefaultParallelism
+	}
+	scheduling := fmt.Sprintf("dynamic, asking the scheduler when its decision expires or the epoch time changes over %v%%",
+		opts.SchedulerThroughputTrigger()*100)
+	if opts.PlateauTrigger > 0 {
+		scheduling += fmt.Sprintf(" or the train loss improves under %v%%", opts.PlateauTrigger*100)
+	}
+	if window := opts.SchedulerPolicyWindow(); window > 1 {
+		scheduling += fmt.Sprintf(", comparing the mean time of up to %d recent epochs", window)
+	}
+	if opts.QuietMargin > 0 {
+		scheduling += fmt.Sprintf(", not scaling up once the accuracy is within %v of the goal", opts.QuietMargin)
+	}
+	if opts.StaticParallelism {
+		scheduling = "static"
+	}
+	if opts.MaxEpochCost > 0 {
+		scheduling += fmt.Sprintf(", lowered after epochs over %v function seconds", opts.MaxEpochCost)
+	}
+
+	sync := fmt.Sprintf("every %d batches", opts.K)
+	if opts.K <= 0 {
+		sync = "once per epoch (sparse averaging)"
+	}
+	if opts.AdaptiveK {
+		sync += fmt.Sprintf(" at first, adapted up to %d to keep the merges under %v of the compute time",
+			opts.MaxKOrDefault(), opts.KTargetOverheadOrDefault())
+	}
+	if opts.AllReduce() {
+		sync += ", averaged among the functions with allreduce"
+	}
+	for _, o := range opts.LayerSyncOverrides {
+		if o.K > 0 {
+			sync += fmt.Sprintf(", layers matching %v every %d batches", o.Pattern, o.K)
+		} else {
+			sync += fmt.Sprintf(", layers matching %v once per epoch", o.Pattern)
+		}
+	}
+	iterations := api.IterationsPerEpoch(shards, req.StepBatchSize(), parallelism, opts.SyncPeriod())
+	perFunction := api.ShardsPerFunction(shards, parallelism)
+
+	validation := "after the last epoch"
+	if opts.ValidateEvery > 0 {
+		validation = fmt.Sprintf("every %d epochs and after the last one", opts.ValidateEvery)
+		if opts.ValidatesLatest() {
+			validation += ", starting on the last intermediate merge"
+		}
+	}
+	switch opts.ValidationFailurePolicyOrDefault() {
+	case api.ValidationFailureFail:
+		validation += ", a failed validation stops the job"
+	case api.ValidationFailureRetry:
+		validation += fmt.Sprintf(", a failed validation is retried %d times before stopping the job", opts.ValidationRetryLimit())
+	}
+	serialization := opts.Serialization
+	if len(serialization) == 0 {
+		serialization = api.SerializationJSON
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\n", "FUNCTION", req.FunctionName)
+	fmt.Fprintf(w, "%v\t%v (%v train shards)\n", "DATASET", req.Dataset, shards)
+	fmt.Fprintf(w, "%v\t%v functions, %v\n", "PARALLELISM", parallelism, scheduling)
+	if opts.MultiDevice() {
+		fmt.Fprintf(w, "%v\t%v per function, each function counts once in the parallelism\n", "DEVICES", opts.FunctionDevices())
+	}
+	shardSplit := fmt.Sprintf("%v shards (~%v datapoints) per function", perFunction, perFunction*api.DatasetShardSize)
+	if opts.BalanceByCapacity {
+		shardSplit += " in the first epoch, then by the capacity the functions report"
+	}
+	fmt.Fprintf(w, "%v\t%v\n", "SHARDS", shardSplit)
+	fmt.Fprintf(w, "%v\t%v every epoch, the functions receive the epoch to apply their own schedule\n", "LEARNING RATE", req.LearningRate)
+	fmt.Fprintf(w, "%v\t%v epochs, batch size %v\n", "EPOCHS", req.Epochs, req.BatchSize)
+	batch := fmt.Sprintf("%v (batch %v x %v functions), changes with the parallelism",
+		req.BatchSize*parallelism, req.BatchSize, parallelism)
+	if opts.MultiDevice() {
+		batch = fmt.Sprintf("%v (batch %v x %v devices x %v functions), changes with the parallelism",
+			req.StepBatchSize()*parallelism, req.BatchSize, opts.FunctionDevices(), parallelism)
+	}
+	if opts.GlobalBatchSize > 0 {
+		batch = fmt.Sprintf("%v, split among the functions every epoch (%v x %v functions)",
+			opts.GlobalBatchSize, req.FunctionBatchSize(parallelism), parallelism)
+		if opts.MultiDevice() {
+			batch = fmt.Sprintf("%v, split among the devices of the functions every epoch (%v x %v devices x %v functions)",
+				opts.GlobalBatchSize, req.FunctionBatchSize(parallelism*opts.FunctionDevices()), opts.FunctionDevices(), parallelism)
+		}
+	}
+	if opts.ScaleLRWithParallelism {
+		batch += ", learning rate scaled with it"
+	}
+	if opts.BalanceByCapacity {
+		batch += ", balanced by the capacity the functions report"
+	}
+	fmt.Fprintf(w, "%v\t%v\n", "GLOBAL BATCH", batch)
+	fmt.Fprintf(w, "%v\t%v\n", "SYNC", sync)
+	fmt.Fprintf(w, "%v\t%v per epoch\n", "MERGES", iterations)
+	strategy := "K-averaging, the models of the functions are averaged by the parallel SGD optimizer"
+	switch opts.MergeOperatorOrDefault() {
+	case api.MergeMedian:
+		strategy = "K-averaging, the median of every weight across the functions is taken"
+	case api.MergeTrimmedMean:
+		strategy = fmt.Sprintf("K-averaging, the highest and lowest %v%% of every weight across the functions are dropped and the rest averaged",
+			api.MergeTrimFraction*100)
+		if opts.ByzantineTolerance > 0 {
+			strategy = fmt.Sprintf("K-averaging, the %d highest and lowest values of every weight across the functions are dropped and the rest averaged",
+				opts.ByzantineTolerance)
+		}
+	case api.MergeKrum:
+		strategy = "Krum, the model of the function closest to the rest is taken"
+	}
+	if opts.ByzantineTolerance > 0 {
+		strategy += fmt.Sprintf(", tolerating %d faulty functions with at least %d merged",
+			opts.ByzantineTolerance, api.MinRobustFunctions(opts.MergeOperatorOrDefault(), opts.ByzantineTolerance))
+	}
+	if opts.BalanceByCapacity {
+		strategy += ", weighted by the data of each function"
+	}
+	fmt.Fprintf(w, "%v\t%v\n", "MERGE STRATEGY", strategy)
+	if opts.UseClassWeights {
+		weights := "from the class distribution of the dataset, unless set with 'dataset set-weights'"
+		if len(opts.ClassWeights) > 0 {
+			weights = fmt.Sprintf("%v", opts.ClassWeights)
+		}
+		fmt.Fprintf(w, "%v\t%v\n", "CLASS WEIGHTS", weights)
+	}
+	fmt.Fprintf(w, "%v\t%v\n", "VALIDATION", validation)
+	if opts.GoalAccuracy < api.NoGoalAccuracy {
+		scale := opts.GoalScale
+		if len(scale) == 0 {
+			scale = "scale of the function"
+		}
+		fmt.Fprintf(w, "%v\taccuracy %v in the %v (%v)\n", "GOAL", opts.GoalAccuracy, scale, opts.Direction(api.MetricAccuracy))
+	}
+	for _, r := range opts.StopRules {
+		fmt.Fprintf(w, "%v\t%v\n", "STOP WHEN", r)
+	}
+	if opts.MinTrainImprovement > 0 {
+		fmt.Fprintf(w, "%v\ttrain loss improves less than %v%% in %v epochs\n", "STOP WHEN",
+			opts.MinTrainImprovement*100, opts.TrainImprovementWindow())
+	}
+	order := "in order"
+	if opts.ShuffleSeed != 0 {
+		order = fmt.Sprintf("shuffled with seed %v", opts.ShuffleSeed)
+	}
+	if opts.AuditData {
+		order += ", audited"
+	}
+	fmt.Fprintf(w, "%v\t%v\n", "DATA ORDER", order)
+	checkpoints := "redis"
+	if api.IsObjectStorage(opts.CheckpointBackend) {
+		checkpoints = fmt.Sprintf("redis and %v every epoch", opts.CheckpointBackend)
+	}
+	if opts.KeepLast > 0 || opts.KeepBest > 0 {
+		checkpoints += fmt.Sprintf(", keeping the last %v and the best %v by %v",
+			opts.KeepLast, opts.KeepBest, opts.CheckpointBestMetric())
+	}
+	if len(opts.ResumeFrom) > 0 {
+		checkpoints += fmt.Sprintf(", resumed from %v", opts.ResumeFrom)
+	}
+	fmt.Fprintf(w, "%v\t%v\n", "CHECKPOINTS", checkpoints)
+	sanity := "one batch before the first epoch"
+	if opts.StartupProbe {
+		sanity += fmt.Sprintf(", with a second step, both losses finite and under %v", opts.InitialLossLimit())
+	}
+	if opts.SkipSanityCheck {
+		sanity = "skipped"
+	}
+	fmt.Fprintf(w, "%v\t%v\n", "SANITY CHECK", sanity)
+	if opts.WantsMetric(api.MetricsPerClass) {
+		fmt.Fprintf(w, "%v\t%v\n", "VALIDATION METRICS", "accuracy, loss, per-class accuracy")
+	}
+	fmt.Fprintf(w, "%v\t%v\n", "SERIALIZATION", serialization)
+	invocation := "sync, through the router"
+	if opts.QueueInvocations() {
+		invocation = fmt.Sprintf("queue, results awaited for %v", opts.InvocationWait())
+	}
+	fmt.Fprintf(w, "%v\t%v\n", "INVOCATION", invocation)
+	fmt.Fprintf(w, "%v\t%v\n", "SCHEDULER TIMEOUT", opts.UpdateTimeout())
+	outage := "fail the epoch once the save retries run out"
+	if opts.RedisOutageGrace > 0 {
+		outage = fmt.Sprintf("pause the merge for up to %v until redis comes back", opts.OutageGrace())
+	}
+	fmt.Fprintf(w, "%v\t%v\n", "REDIS OUTAGE", outage)
+	w.Flush()
 
+	if warning := api.IterationsWarning(opts.SyncPeriod(), iterations, api.DefaultMaxIterationsPerEpoch); len(warning) > 0 {
+		fmt.Fprintln(os.Stderr, "Warning:", warning)
+	}
+	return nil
 }
 
-// functionExists returns true if function is in kubeml
-func functionExists(functionName string) (bool, error) {
+// parseStopRules parses the stop rules given in the command line
+func parseStopRules(rules []string) ([]api.StopRule, error) {
+	var parsed []api.StopRule
+	for _, s := range rules {
+		r, err := api.ParseStopRule(s)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, r)
+	}
+	return parsed, nil
+}
+
+// parseLayerSyncOverrides parses the layer sync overrides given in the command line
+func parseLayerSyncOverrides(overrides []string) ([]api.LayerSyncOverride, error) {
+	var parsed []api.LayerSyncOverride
+	for _, s := range overrides {
+		o, err := api.ParseLayerSyncOverride(s)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, o)
+	}
+	return parsed, nil
+}
+
+// parseDurationBuckets parses the buckets of the duration histograms given in
+// the command line, nil if not given so the job uses the default ones
+func parseDurationBuckets(s string) (*api.DurationBuckets, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	return api.ParseDurationBuckets(s)
+}
+
+// allowLiveInference returns the live inference option of the request,
+// left unset unless disabled so the job uses the default
+func allowLiveInference() *bool {
+	if !noLiveInference {
+		return nil
+	}
+	allow := false
+	return &allow
+}
 
-	fissionClient, _, _, err := crd.MakeFissionClient()
+// trainShards returns the number of shards of the train split of a dataset
+func trainShards(dataset string) (int64, error) {
+	storage, err := makeStorageClient()
 	if err != nil {
-		return false, err
+		return 0, err
 	}
 
-	// check if the fission function exists
-	_, err = fissionClient.CoreV1().Functions(metav1.NamespaceDefault).Get(functionName, metav1.GetOptions{})
-	if err == nil {
-		return true, nil
+	stats, err := storage.Stats(context.Background(), dataset)
+	if err != nil {
+		return 0, fmt.Errorf("could not get dataset stats: %v", err)
 	}
-	return false, err
+	return stats.TrainShards, nil
+}
+
+// datasetExists returns true if dataset is present in kubeml
+func datasetExists(client *kubemlClient.KubemlClient, name string) (bool, error) {
+
+	_, err := client.V1().Datasets().Get(name)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
 
 }
 
@@ -153,20 +832,94 @@ func init() {
 	trainCmd.Flags().StringVarP(&dataset, "dataset", "d", "", "Dataset name (required)")
 	trainCmd.Flags().StringVarP(&functionName, "function", "f", "", "Function name (required)")
 	trainCmd.Flags().IntVarP(&epochs, "epochs", "e", 1, "Number of epochs to run (required)")
-	trainCmd.Flags().IntVarP(&batchSize, "batch", "b", 64, "Batch Size (required)")
+	trainCmd.Flags().IntVarP(&batchSize, "batch", "b", 64, "Batch size of each function, ignored with --global-batch")
+	trainCmd.Flags().IntVar(&globalBatchSize, "global-batch", 0, fmt.Sprintf("Batch of all the functions together, split among them every epoch (at least %v per function)", api.MinFunctionBatchSize))
+	trainCmd.Flags().BoolVar(&scaleLR, "scale-lr", false, "Scale the learning rate linearly with the global batch when the parallelism changes")
+	trainCmd.Flags().IntVar(&devicesPerFunction, "devices", 0, fmt.Sprintf("GPUs each function trains on, the batch being the batch per device (at most %v)", api.MaxFunctionDevices))
+	trainCmd.Flags().BoolVar(&balanceByCapacity, "balance-by-capacity", false, "Give more data and a larger batch to the functions that report more capacity, weighting their models in the merge")
+	trainCmd.Flags().BoolVar(&useClassWeights, "use-class-weights", false, "Weight the loss by class, with the weights set for the dataset or computed from its class distribution")
+	trainCmd.Flags().Float64SliceVar(&classWeights, "class-weights", nil, "Weight of each class in the loss instead of the ones of the dataset, requires --use-class-weights")
 	trainCmd.Flags().Float32Var(&lr, "lr", 0.01, "Learning Rate (required)")
 
 	// optional params
 	trainCmd.Flags().IntVar(&validateEvery, "validate-every", 0, "Validate the network every N epochs")
+	trainCmd.Flags().IntVar(&redisOutageGrace, "redis-outage-grace", 0, "Seconds the merge waits for redis to come back after losing the connection, holding the functions (0 fails the epoch)")
+	trainCmd.Flags().StringVar(&lossReduction, "loss-reduction", api.LossReductionMean,
+		fmt.Sprintf("How the losses of the train functions are combined into the epoch loss (%v, %v or %v weighted by the datapoints of each function)",
+			api.LossReductionMean, api.LossReductionSum, api.LossReductionWeightedMean))
+	trainCmd.Flags().StringVar(&mergeOperator, "merge-operator", api.MergeMean,
+		fmt.Sprintf("How the models of the functions are combined in every merge, one of %v or an operator registered in the parameter server", api.MergeOperators))
+	trainCmd.Flags().IntVar(&byzantineTolerance, "byzantine-tolerance", 0,
+		fmt.Sprintf("Faulty functions whose models the merge ignores, needs --merge-operator %v, %v or %v", api.MergeMedian, api.MergeTrimmedMean, api.MergeKrum))
+	trainCmd.Flags().BoolVar(&looseResponses, "loose-responses", false, "Accept functions built with an older kubeml library that do not report the schema of their responses (deprecated)")
+	trainCmd.Flags().StringVar(&validationModel, "validation-model", api.ValidationModelFinal, "Model the periodic validations run against, final once the epoch is merged or latest to start on the last intermediate merge")
+	trainCmd.Flags().StringVar(&validationFailure, "on-validation-failure", api.ValidationFailureContinue,
+		fmt.Sprintf("What to do when a validation fails, %v training without its metrics, %v the job or %v it before failing",
+			api.ValidationFailureContinue, api.ValidationFailureFail, api.ValidationFailureRetry))
+	trainCmd.Flags().IntVar(&validationRetries, "validation-retries", 0, fmt.Sprintf("Times a failed validation is retried with --on-validation-failure %v (default %v)", api.ValidationFailureRetry, api.DefaultValidationRetries))
+	trainCmd.Flags().BoolVar(&noLiveInference, "no-live-inference", false, "Reject inferences on the model while it is trained instead of running them on the snapshot of the last epoch")
+	trainCmd.Flags().Float64SliceVar(&milestones, "milestones", nil, fmt.Sprintf("Validation accuracies whose first occurrence is recorded in the history (default %v)", api.DefaultAccuracyMilestones))
 	trainCmd.Flags().IntVar(&defaultParallelism, "parallelism", api.DebugParallelism, "Starting level of parallelism")
+	trainCmd.Flags().Float64Var(&maxEpochCost, "max-epoch-cost", 0, "Function seconds an epoch should take at most, epochs over it are flagged and the next one uses fewer functions (0 for no cap)")
+	trainCmd.Flags().IntVar(&maxParallelism, "max-parallelism", 0, "Most functions the scheduler can give the job (0 for no cap besides the admission limits)")
+	trainCmd.Flags().IntVar(&policyWindow, "policy-window", api.DefaultPolicyWindow, fmt.Sprintf("Recent epochs the scheduler averages the epoch time over, at most %v", api.MaxPolicyWindow))
 	trainCmd.Flags().BoolVar(&staticParallelism, "static", false, "Whether to keep parallelism static")
 	trainCmd.Flags().IntVar(&K, "K", -1, "Sync every K updates to the local network")
 	trainCmd.Flags().BoolVar(&sparseAvg, "sparse-avg", false, "If true, average only once per epoch, no matter the value of K")
-	trainCmd.Flags().Float64Var(&goalAccuracy, "goal-accuracy", 100, "Accuracy after which the training will stop")
+	trainCmd.Flags().StringArrayVar(&syncLayers, "sync-layers", nil, "Sync the layers matching a pattern with their own K, e.g. '*.running_*:1', the first matching pattern wins (can be repeated)")
+	trainCmd.Flags().Float64Var(&goalAccuracy, "goal-accuracy", api.NoGoalAccuracy, "Accuracy after which the training will stop")
+	trainCmd.Flags().StringVar(&goalScale, "goal-scale", "", fmt.Sprintf("Scale of --goal-accuracy and --quiet-margin, %v (0-1) or %v (0-100), by default the scale of the accuracy of the function", api.AccuracyScaleFraction, api.AccuracyScalePercent))
+	trainCmd.Flags().IntVar(&canaryBatchSize, "canary-batch", 0, "Size of the fixed validation batch evaluated every epoch")
+	trainCmd.Flags().IntVar(&scratchGB, "scratch-gb", 0, "Size in GB of the scratch volume mounted in the functions")
+	trainCmd.Flags().IntVar(&accuracyDecimals, "accuracy-decimals", api.DefaultAccuracyDecimals, "Decimal places of the accuracy saved in the history")
+	trainCmd.Flags().IntVar(&lossDecimals, "loss-decimals", api.DefaultLossDecimals, "Decimal places of the losses saved in the history")
+	trainCmd.Flags().Float64SliceVar(&normalizationMean, "mean", nil, "Per-channel mean used by the functions to normalize the dataset")
+	trainCmd.Flags().Float64SliceVar(&normalizationStd, "std", nil, "Per-channel standard deviation used by the functions to normalize the dataset")
+	trainCmd.Flags().Float64Var(&validationQuorum, "validation-quorum", 0, "Fraction of validation functions that must report, a majority by default")
+	trainCmd.Flags().IntVar(&schedulerTimeout, "scheduler-timeout", api.DefaultSchedulerTimeout, "Seconds to wait for the scheduler to update the parallelism before keeping the current one")
+	trainCmd.Flags().IntVar(&redisBudgetMB, "redis-budget-mb", 0, "Maximum memory in MB the tensors of the job can use in redis, 0 uses the cluster default")
+	trainCmd.Flags().StringToStringVar(&tags, "tag", nil, "Tags of the job to group it with others, e.g. team=vision,sweep=lr-search")
+	trainCmd.Flags().StringVar(&sweepId, "sweep-id", "", "Id of the sweep the job is part of, see 'sweep best'")
+	trainCmd.Flags().StringToStringVar(&metricDirection, "metric-direction", nil, "Whether each metric is maximized or minimized, e.g. accuracy=maximize,validation_loss=minimize")
+	trainCmd.Flags().StringVar(&notifyURL, "notify-url", "", "URL that receives the metrics of the job after every epoch, signed with the secret printed on submission")
+	trainCmd.Flags().StringArrayVar(&stopWhen, "stop-when", nil, "Stop the job when the latest value of a metric crosses a threshold, e.g. train_loss>10 (can be repeated)")
+	trainCmd.Flags().Float64Var(&throughputTrigger, "throughput-trigger", api.DefaultThroughputTrigger, "Relative change of the epoch time that makes the job ask the scheduler before its decision expires")
+	trainCmd.Flags().Float64Var(&quietMargin, "quiet-margin", 0, "Accuracy points from the goal under which the job stops scaling up, 0 disables it")
+	trainCmd.Flags().Float64Var(&plateauTrigger, "plateau-trigger", 0, "Relative improvement of the train loss under which the job asks the scheduler before its decision expires, 0 disables it")
+	trainCmd.Flags().Int64Var(&shuffleSeed, "shuffle-seed", 0, "Seed the functions shuffle their training data with, 0 trains on the data in order")
+	trainCmd.Flags().BoolVar(&auditData, "audit-data", false, "Record the data each function trains on every epoch, see 'kubeml audit'")
+	trainCmd.Flags().StringVar(&checkpointBackend, "checkpoint-backend", "", "Also checkpoint the model every epoch to object storage (s3 or gcs), redis is always used")
+	trainCmd.Flags().IntVar(&keepLast, "keep-last", 0, "Number of most recent checkpoints kept in object storage, with --keep-best 0 keeps them all")
+	trainCmd.Flags().IntVar(&keepBest, "keep-best", 0, "Number of best checkpoints by --keep-best-by kept in object storage besides the most recent ones")
+	trainCmd.Flags().StringVar(&keepBestBy, "keep-best-by", api.MetricAccuracy, fmt.Sprintf("Validation metric the best checkpoints are ranked by (%v or %v)", api.MetricAccuracy, api.MetricValidationLoss))
+	trainCmd.Flags().StringVar(&resumeFrom, "resume-from", "", "Id of a previous job whose last checkpoint is used as the initial model")
+	trainCmd.Flags().Float64Var(&minImprovement, "min-train-improvement", 0, "Stop the job if the train loss improves less than this fraction over the improvement window, 0 disables it")
+	trainCmd.Flags().IntVar(&improvementWindow, "improvement-window", 0, fmt.Sprintf("Epochs over which the train loss must improve (default %v)", api.DefaultImprovementWindow))
+	trainCmd.Flags().StringSliceVar(&extraMetrics, "metrics", nil, fmt.Sprintf("Optional metrics computed during validation (%v), see 'history get --per-class'", api.MetricsPerClass))
+	trainCmd.Flags().StringVar(&invocationMode, "invocation-mode", api.InvocationModeSync, "How the train and validation functions are invoked, sync through the router or queue for functions that outlast the router timeout")
+	trainCmd.Flags().IntVar(&invocationTimeout, "invocation-timeout", 0, fmt.Sprintf("Seconds a queued invocation can take before it fails (default %v)", api.DefaultInvocationTimeout))
+	trainCmd.Flags().StringVar(&durationBuckets, "duration-buckets", "",
+		fmt.Sprintf("Exponential buckets of the histograms of the invocation durations as start,factor,count in seconds (default %v,%v,%v)",
+			api.DefaultDurationBucketStart, api.DefaultDurationBucketFactor, api.DefaultDurationBucketCount))
+	trainCmd.Flags().StringVar(&stragglerTimeout, "straggler-timeout", "", "Time after which a train invocation is a straggler, a duration like 90s or a percentile of the previous epoch like p95x1.5")
+	trainCmd.Flags().BoolVar(&skipSanityCheck, "skip-sanity-check", false, "Start training without first checking on a single batch that the function matches the dataset")
+	trainCmd.Flags().BoolVar(&warmUpFunctions, "warm-up", false, "Invoke the functions once before training so their cold starts are not measured in the first epoch")
+	trainCmd.Flags().BoolVar(&adaptiveK, "adaptive-k", false, "Adapt K after every epoch to keep the time of the merges around --k-target-overhead of the compute time")
+	trainCmd.Flags().Float64Var(&kTargetOverhead, "k-target-overhead", 0, fmt.Sprintf("Fraction of the compute time the merges are kept under with --adaptive-k (default %v)", api.DefaultKTargetOverhead))
+	trainCmd.Flags().IntVar(&maxK, "k-max", 0, fmt.Sprintf("Largest K reached with --adaptive-k (default %v)", api.DefaultMaxAdaptiveK))
+	trainCmd.Flags().BoolVar(&useSuggestions, "use-suggestions", false, "Fill the parallelism and K, if not set, from the previous runs of the function on the dataset")
+	trainCmd.Flags().StringVar(&syncTopology, "sync-topology", api.SyncTopologyParameterServer, fmt.Sprintf("How the functions sync their models, %s merges them in the job and %s averages them among the functions", api.SyncTopologyParameterServer, api.SyncTopologyAllReduce))
+	trainCmd.Flags().BoolVar(&startupProbe, "startup-probe", false, "Take a second step in the sanity check and fail if the loss is not finite or too large")
+	trainCmd.Flags().Float64Var(&maxInitialLoss, "max-initial-loss", 0, fmt.Sprintf("Largest loss the startup probe accepts (0 uses %v)", api.DefaultMaxInitialLoss))
+	trainCmd.Flags().StringVar(&logLevel, "log-level", "", "Log level of the job (debug, info, warn or error), can be changed with 'task set-loglevel'")
+	trainCmd.Flags().BoolVar(&budgetOverride, "budget-override", false, "Admit the request even if it exceeds the epochs limits of the cluster")
+	trainCmd.Flags().BoolVar(&explain, "explain", false, "Print the execution plan of the request without submitting it")
+	trainCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Validate the request and print the planned iterations per epoch without submitting it")
+	trainCmd.Flags().BoolVar(&followScheduler, "follow-scheduler", false, "Keep a trace of the scheduling decisions of the job, see 'task trace'")
+	trainCmd.Flags().StringVar(&trainSerialization, "serialization", api.SerializationJSON, "Format of the payloads returned by the functions (json or msgpack)")
 
 	trainCmd.MarkFlagRequired("dataset")
 	trainCmd.MarkFlagRequired("function")
 	trainCmd.MarkFlagRequired("epochs")
-	trainCmd.MarkFlagRequired("batch")
 	trainCmd.MarkFlagRequired("lr")
 }
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kubemlClient "github.com/diegostock12/kubeml/ml/pkg/controller/client"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+const (
+	// waitPollInterval is the default time between requests when waiting for
+	// a job, which backs off up to waitMaxPollInterval while its state is the same
+	waitPollInterval    = 2 * time.Second
+	waitMaxPollInterval = time.Minute
+
+	// waitNotFoundGrace is how long a job can have neither a running
+	// task nor a history before wait gives up on it, so a job that was
+	// just submitted has time to start
+	waitNotFoundGrace = 30 * time.Second
+)
+
+// Exit codes of the wait command
+const (
+	waitExitCompleted = 0
+	waitExitFailed    = 1
+	waitExitStopped   = 2
+	waitExitTimeout   = 3
+	waitExitError     = 4
+)
+
+var (
+	waitTimeout     time.Duration
+	waitInterval    time.Duration
+	waitMaxInterval time.Duration
+
+	waitCmd = &cobra.Command{
+		Use:   "wait <jobId>",
+		Short: "Wait until a job completes, fails or is stopped",
+		Long: `Wait polls the status of an already submitted job until it reaches a terminal state.
+
+The exit code reflects the status of the job:
+  0  the job completed, including jobs ended by a stop rule
+  1  the job failed
+  2  the job was stopped
+  3  the timeout expired before the job finished
+  4  the job could not be found or the controller could not be reached`,
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			os.Exit(waitJob(args[0]))
+		},
+	}
+)
+
+// waitJob polls the job until it finishes and returns the exit code
+// for its status. The job is running while its history is in progress, which
+// includes the time it is paused, or, before the first history is saved,
+// while its task exists. The polls back off while the status of the job
+// does not change, so long waits do not load the controller
+func waitJob(jobId string) int {
+	if err := validatePollIntervals(waitInterval, waitMaxInterval); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return waitExitError
+	}
+
+	client, err := kubemlClient.MakeKubemlClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return waitExitError
+	}
+
+	var deadline <-chan time.Time
+	if waitTimeout > 0 {
+		deadline = time.After(waitTimeout)
+	}
+
+	lastSeen := time.Now()
+	p := newPoller(waitInterval, waitMaxInterval)
+	for {
+		history, err := client.V1().Histories().Get(jobId)
+		switch {
+		case err == nil && !history.InProgress:
+			return reportWait(jobId, history)
+		case err == nil:
+			lastSeen = time.Now()
+			p.observe(history.Status())
+		default:
+			if _, err := client.V1().Tasks().Get(jobId); err == nil {
+				lastSeen = time.Now()
+				p.observe("starting")
+			} else if time.Since(lastSeen) > waitNotFoundGrace {
+				fmt.Fprintf(os.Stderr, "could not find job %v: %v\n", jobId, err)
+				return waitExitError
+			}
+		}
+
+		select {
+		case <-deadline:
+			fmt.Fprintf(os.Stderr, "timed out after %v waiting for job %v\n", waitTimeout, jobId)
+			return waitExitTimeout
+		case <-time.After(p.next()):
+		}
+	}
+}
+
+// reportWait prints the terminal status of the job
+// and returns the matching exit code
+func reportWait(jobId string, history *api.History) int {
+	switch history.Status() {
+	case api.JobFailed:
+		fmt.Printf("Job %v failed: %v\n", jobId, history.Error)
+		return waitExitFailed
+	case api.JobStopped:
+		fmt.Printf("Job %v was stopped\n", jobId)
+		return waitExitStopped
+	}
+
+	if len(history.Data.StoppedBy) > 0 {
+		fmt.Printf("Job %v completed, stopped by %v\n", jobId, history.Data.StoppedBy)
+	} else {
+		fmt.Printf("Job %v completed\n", jobId)
+	}
+	if len(history.Data.Accuracy) == 0 && len(history.Data.NoValidationData) > 0 {
+		fmt.Println("No validation was performed, the validation functions evaluated no datapoints")
+	} else if epochs := history.Data.NoValidationData; len(epochs) > 0 {
+		fmt.Printf("Warning: the validations of epochs %v evaluated no datapoints and have no validation metrics\n", epochs)
+	}
+	if n := history.Data.ValidationFailures; n > 0 {
+		fmt.Printf("Warning: %v validations failed, their epochs have no validation metrics\n", n)
+	}
+	return waitExitCompleted
+}
+
+func init() {
+	rootCmd.AddCommand(waitCmd)
+
+	waitCmd.Flags().DurationVar(&waitTimeout, "timeout", 0, "Maximum time to wait for the job, 0 waits forever")
+	waitCmd.Flags().DurationVar(&waitInterval, "poll-interval", waitPollInterval, "Time between checks of the job status, doubled while the status does not change")
+	waitCmd.Flags().DurationVar(&waitMaxInterval, "max-poll-interval", waitMaxPollInterval, "Longest time between checks of the job status")
+	waitCmd.Flags().DurationVar(&waitInterval, "interval", waitPollInterval, "Time between checks of the job status")
+	waitCmd.Flags().MarkDeprecated("interval", "use --poll-interval instead")
+}
@@ -0,0 +1,266 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"hash"
+	"io"
+	"sort"
+	"time"
+)
+
+// SaveCheckpoint uploads the layers of the model to the object store followed by the
+// manifest of the checkpoint. The layers are read back and checked against their checksums
+// before the manifest of the latest checkpoint is replaced, so if an upload fails the
+// previous one is kept. Saving the same epoch again skips the layers already uploaded
+func (m *Model) SaveCheckpoint(store ObjectStore, epoch int) (*api.CheckpointManifest, error) {
+	prefix := api.CheckpointEpochPrefix(m.jobId, epoch)
+	manifest := &api.CheckpointManifest{
+		JobId:   m.jobId,
+		Epoch:   epoch,
+		Model:   m.Name,
+		Storage: store.URL(prefix),
+		Created: time.Now(),
+	}
+
+	names := make([]string, 0, len(m.StateDict))
+	for name := range m.StateDict {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t, err := encodeLayer(m.StateDict[name])
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not encode weights of layer %v", name)
+		}
+
+		sum := sha256.Sum256(t.Blob)
+		tensor := api.ExportTensor{
+			Layer:  name,
+			Key:    prefix + name,
+			Dtype:  t.Dtype,
+			Shape:  t.Shape,
+			SHA256: hex.EncodeToString(sum[:]),
+		}
+
+		uploaded, err := uploadedTensor(store, tensor)
+		if err != nil {
+			return nil, err
+		}
+		if uploaded {
+			m.logger.Debug("Layer already in the checkpoint, skipping", zap.String("layer", name))
+		} else if err := store.Put(tensor.Key, t.Blob); err != nil {
+			return nil, err
+		}
+
+		manifest.Size += int64(len(t.Blob))
+		manifest.Tensors = append(manifest.Tensors, tensor)
+	}
+
+	// check the uploaded layers before the checkpoint is visible
+	for _, t := range manifest.Tensors {
+		if _, err := CheckpointTensor(store, t); err != nil {
+			return nil, errors.Wrap(err, "could not verify checkpoint")
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode manifest")
+	}
+	if err := store.Put(prefix+api.ExportManifestFile, data); err != nil {
+		return nil, err
+	}
+	if err := store.Put(api.CheckpointLatestKey(m.jobId), data); err != nil {
+		return nil, err
+	}
+
+	m.logger.Debug("Saved checkpoint",
+		zap.Int("epoch", epoch),
+		zap.String("storage", manifest.Storage),
+		zap.Int64("size", manifest.Size))
+	return manifest, nil
+}
+
+// Restore replaces the reference model with the tensors of a checkpoint, keyed by
+// the layer name. The checkpoint must have all the layers of the model with the same
+// type and shape as the ones created by the init function
+func (m *Model) Restore(tensors map[string]*Tensor) error {
+	restored := make(map[string]*Tensor, len(m.layerNames))
+	for _, name := range m.layerNames {
+		t, exists := tensors[name]
+		if !exists {
+			return fmt.Errorf("layer %s is not in the checkpoint", name)
+		}
+
+		key := getWeightKeys(name, m.jobId, -1)
+		dtype, shape, err := m.store.GetMeta(key)
+		if err != nil {
+			return err
+		}
+		if dtype != t.Dtype || fmt.Sprint(shape) != fmt.Sprint(t.Shape) {
+			return fmt.Errorf("layer %s is %s %v in the checkpoint but %s %v in the model",
+				name, t.Dtype, t.Shape, dtype, shape)
+		}
+		restored[key] = t
+	}
+
+	if err := m.store.SetTensors(restored); err != nil {
+		return errors.Wrap(err, "could not save tensors")
+	}
+	return nil
+}
+
+// ReferenceTensors returns the tensors of the reference model of a job
+// saved in the model store, keyed by the layer name
+func ReferenceTensors(store ModelStore, jobId string, layerNames []string) (map[string]*Tensor, error) {
+	keys := make([]string, len(layerNames))
+	for i, name := range layerNames {
+		keys[i] = getWeightKeys(name, jobId, -1)
+	}
+
+	list, err := store.GetTensors(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	tensors := make(map[string]*Tensor, len(layerNames))
+	for i, name := range layerNames {
+		tensors[name] = list[i]
+	}
+	return tensors, nil
+}
+
+// LatestCheckpoint returns the manifest of the last checkpoint of a job
+func LatestCheckpoint(store ObjectStore, jobId string) (*api.CheckpointManifest, error) {
+	return readManifest(store, api.CheckpointLatestKey(jobId))
+}
+
+// EpochCheckpoint returns the manifest of the checkpoint of an epoch of a job
+func EpochCheckpoint(store ObjectStore, jobId string, epoch int) (*api.CheckpointManifest, error) {
+	return readManifest(store, api.CheckpointEpochPrefix(jobId, epoch)+api.ExportManifestFile)
+}
+
+// readManifest downloads and decodes the manifest of a checkpoint
+func readManifest(store ObjectStore, key string) (*api.CheckpointManifest, error) {
+	data, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest api.CheckpointManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Wrap(err, "could not decode manifest")
+	}
+	return &manifest, nil
+}
+
+// DeleteCheckpoint removes the tensors and the manifest of a checkpoint
+func DeleteCheckpoint(store ObjectStore, manifest *api.CheckpointManifest) error {
+	keys := make([]string, 0, len(manifest.Tensors)+1)
+	for _, t := range manifest.Tensors {
+		keys = append(keys, t.Key)
+	}
+	keys = append(keys, api.CheckpointEpochPrefix(manifest.JobId, manifest.Epoch)+api.ExportManifestFile)
+	return store.Delete(keys)
+}
+
+// uploadedTensor returns whether a tensor is already in the object
+// store with the checksum of the manifest, from an interrupted save
+func uploadedTensor(store ObjectStore, t api.ExportTensor) (bool, error) {
+	blob, err := store.Get(t.Key)
+	if errors.Cause(err) == ErrObjectNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	sum := sha256.Sum256(blob)
+	return hex.EncodeToString(sum[:]) == t.SHA256, nil
+}
+
+// CheckpointTensor downloads a tensor of a checkpoint and checks it against its checksum
+func CheckpointTensor(store ObjectStore, t api.ExportTensor) (*Tensor, error) {
+	blob, err := store.Get(t.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(blob)
+	if hex.EncodeToString(sum[:]) != t.SHA256 {
+		return nil, fmt.Errorf("checksum of tensor %s does not match the manifest", store.URL(t.Key))
+	}
+	return &Tensor{Dtype: t.Dtype, Shape: t.Shape, Blob: blob}, nil
+}
+
+// checkedTensor is a reader of a tensor of a checkpoint that fails
+// at the end of the tensor if it does not match its checksum
+type checkedTensor struct {
+	body io.ReadCloser
+	tee  io.Reader
+	hash hash.Hash
+	want string
+	url  string
+}
+
+func (c *checkedTensor) Read(p []byte) (int, error) {
+	n, err := c.tee.Read(p)
+	if err == io.EOF && hex.EncodeToString(c.hash.Sum(nil)) != c.want {
+		return n, fmt.Errorf("checksum of tensor %s does not match the manifest", c.url)
+	}
+	return n, err
+}
+
+func (c *checkedTensor) Close() error {
+	return c.body.Close()
+}
+
+// OpenCheckpointTensor streams a tensor of a checkpoint, checking it against
+// its checksum as it is read. The reader fails at the end of the tensor if it
+// does not match, so the caller must not trust what it read until then
+func OpenCheckpointTensor(store ObjectStore, t api.ExportTensor) (io.ReadCloser, error) {
+	body, err := store.Open(t.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	return &checkedTensor{
+		body: body,
+		tee:  io.TeeReader(body, h),
+		hash: h,
+		want: t.SHA256,
+		url:  store.URL(t.Key),
+	}, nil
+}
+
+// CheckpointTensors downloads all the tensors of a checkpoint, keyed by the layer name.
+// A manifest without tensors or with a tensor missing its checksum is refused
+func CheckpointTensors(store ObjectStore, manifest *api.CheckpointManifest) (map[string]*Tensor, error) {
+	if len(manifest.Tensors) == 0 {
+		return nil, fmt.Errorf("checkpoint %s has no tensors", manifest.Storage)
+	}
+	for _, t := range manifest.Tensors {
+		if t.Key == "" || t.SHA256 == "" {
+			return nil, fmt.Errorf("checkpoint %s is incomplete, layer %s has no key or checksum",
+				manifest.Storage, t.Layer)
+		}
+	}
+
+	tensors := make(map[string]*Tensor, len(manifest.Tensors))
+	for _, t := range manifest.Tensors {
+		tensor, err := CheckpointTensor(store, t)
+		if err != nil {
+			return nil, err
+		}
+		tensors[t.Layer] = tensor
+	}
+	return tensors, nil
+}
@@ -0,0 +1,225 @@
+package model
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// checkpointLayers are the layers of the model of the checkpoint tests
+var checkpointLayers = []string{"fc.weight", "fc.bias"}
+
+// newCheckpointModel returns a model of the job with all its weights set to the value
+func newCheckpointModel(t *testing.T, value float32) *Model {
+	t.Helper()
+	store := NewMemoryStore()
+	for _, name := range checkpointLayers {
+		if err := store.SetTensor(getWeightKeys(name, "job", -1), floatTensor([]int64{2}, value, value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := NewModel(zap.NewNop(), "job", api.TrainRequest{}, checkpointLayers, store)
+	if err := m.Build(); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// corruptStore flips the first byte of the object written under the key if on
+type corruptStore struct {
+	*MemoryObjectStore
+	key string
+	on  bool
+}
+
+func (s *corruptStore) Put(key string, data []byte) error {
+	if s.on && key == s.key {
+		data = append([]byte{data[0] ^ 0xff}, data[1:]...)
+	}
+	return s.MemoryObjectStore.Put(key, data)
+}
+
+// latestValue returns the epoch of the latest checkpoint of the job and the first value of its weights
+func latestValue(t *testing.T, store ObjectStore) (int, float32) {
+	t.Helper()
+	manifest, err := LatestCheckpoint(store, "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tensors, err := CheckpointTensors(store, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := blobToFloatArray(tensors["fc.weight"].Blob, tensors["fc.weight"].Shape)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest.Epoch, values[0]
+}
+
+func TestSaveCheckpointFaults(t *testing.T) {
+	epoch := api.CheckpointEpochPrefix("job", 2)
+	errFault := errors.New("injected fault")
+
+	// nth fails the nth operation op on the key
+	nth := func(n int, op, key string) func(string, string) error {
+		seen := 0
+		return func(o, k string) error {
+			if o == op && k == key {
+				if seen++; seen == n {
+					return errFault
+				}
+			}
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name    string
+		fault   func(op, key string) error
+		corrupt bool
+		// the layers uploaded again by the save after the fault
+		reuploaded []string
+	}{
+		{
+			name:       "uploading a layer",
+			fault:      nth(1, ObjectPut, epoch+"fc.weight"),
+			reuploaded: []string{"fc.weight"},
+		},
+		{
+			name:       "looking for an interrupted upload",
+			fault:      nth(1, ObjectGet, epoch+"fc.bias"),
+			reuploaded: []string{"fc.bias", "fc.weight"},
+		},
+		{
+			name:  "verifying the layers",
+			fault: nth(2, ObjectGet, epoch+"fc.bias"),
+		},
+		{
+			name:       "corrupted upload",
+			corrupt:    true,
+			reuploaded: []string{"fc.weight"},
+		},
+		{
+			name:  "writing the manifest of the epoch",
+			fault: nth(1, ObjectPut, epoch+api.ExportManifestFile),
+		},
+		{
+			name:  "replacing the latest manifest",
+			fault: nth(1, ObjectPut, api.CheckpointLatestKey("job")),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &corruptStore{MemoryObjectStore: NewMemoryObjectStore(), key: epoch + "fc.weight"}
+			if _, err := newCheckpointModel(t, 1).SaveCheckpoint(store, 1); err != nil {
+				t.Fatal(err)
+			}
+
+			// the save fails and the checkpoint of the previous epoch is still the latest
+			m := newCheckpointModel(t, 2)
+			store.Fault, store.on = tt.fault, tt.corrupt
+			if _, err := m.SaveCheckpoint(store, 2); err == nil {
+				t.Fatal("got no error, want the save to fail")
+			}
+			store.Fault, store.on = nil, false
+			if epoch, value := latestValue(t, store); epoch != 1 || value != 1 {
+				t.Fatalf("got epoch %d with weights %v as the latest, want the previous checkpoint", epoch, value)
+			}
+
+			// saving again only uploads the layers that are not in the checkpoint yet
+			var reuploaded []string
+			store.Fault = func(op, key string) error {
+				if op == ObjectPut && strings.HasPrefix(key, epoch) && !strings.HasSuffix(key, api.ExportManifestFile) {
+					reuploaded = append(reuploaded, strings.TrimPrefix(key, epoch))
+				}
+				return nil
+			}
+			if _, err := m.SaveCheckpoint(store, 2); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(reuploaded, tt.reuploaded) {
+				t.Errorf("got layers %v uploaded again, want %v", reuploaded, tt.reuploaded)
+			}
+			if epoch, value := latestValue(t, store); epoch != 2 || value != 2 {
+				t.Errorf("got epoch %d with weights %v as the latest, want the new checkpoint", epoch, value)
+			}
+		})
+	}
+}
+
+func TestDeleteCheckpoint(t *testing.T) {
+	store := NewMemoryObjectStore()
+	first, err := newCheckpointModel(t, 1).SaveCheckpoint(store, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newCheckpointModel(t, 2).SaveCheckpoint(store, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = DeleteCheckpoint(store, first); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range store.Keys() {
+		if strings.HasPrefix(key, api.CheckpointEpochPrefix("job", 1)) {
+			t.Errorf("got %s left after deleting the checkpoint", key)
+		}
+	}
+	if epoch, _ := latestValue(t, store); epoch != 2 {
+		t.Errorf("got epoch %d as the latest, want 2", epoch)
+	}
+}
+
+func TestOpenCheckpointTensor(t *testing.T) {
+	blob := floatTensor([]int64{3}, 1, 2, 3).Blob
+	sum := sha256.Sum256(blob)
+	tensor := api.ExportTensor{Layer: "fc", Key: "job/1/fc", SHA256: hex.EncodeToString(sum[:])}
+
+	tests := []struct {
+		name      string
+		stored    []byte
+		wantError bool
+	}{
+		{"matching tensor", blob, false},
+		{"modified tensor", append(blob[:len(blob)-1:len(blob)-1], 0xff), true},
+		{"truncated tensor", blob[:4], true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryObjectStore()
+			store.Put(tensor.Key, tt.stored)
+
+			r, err := OpenCheckpointTensor(store, tensor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			// the values are streamed and the checksum only fails at the end
+			data, err := ioutil.ReadAll(r)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if !bytes.Equal(data, tt.stored) {
+				t.Errorf("got values %v, want %v", data, tt.stored)
+			}
+		})
+	}
+}
+
+func TestOpenCheckpointTensorMissing(t *testing.T) {
+	_, err := OpenCheckpointTensor(NewMemoryObjectStore(), api.ExportTensor{Key: "job/1/fc"})
+	if errors.Cause(err) != ErrObjectNotFound {
+		t.Errorf("got error %v, want %v", err, ErrObjectNotFound)
+	}
+}
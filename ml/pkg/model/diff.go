@@ -0,0 +1,108 @@
+package model
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/RedisAI/redisai-go/redisai"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"math"
+)
+
+// tensorElement returns the function that decodes the i-th value of a
+// tensor of the given type as a float64, and the bytes taken by each value
+func tensorElement(dtype string) (func(blob []byte, i int) float64, int, error) {
+	switch dtype {
+	case redisai.TypeFloat:
+		return func(blob []byte, i int) float64 {
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(blob[i*4:])))
+		}, 4, nil
+	case redisai.TypeDouble:
+		return func(blob []byte, i int) float64 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(blob[i*8:]))
+		}, 8, nil
+	case redisai.TypeInt32:
+		return func(blob []byte, i int) float64 {
+			return float64(int32(binary.LittleEndian.Uint32(blob[i*4:])))
+		}, 4, nil
+	case redisai.TypeInt64:
+		return func(blob []byte, i int) float64 {
+			return float64(int64(binary.LittleEndian.Uint64(blob[i*8:])))
+		}, 8, nil
+	default:
+		return nil, 0, fmt.Errorf("tensors of type %s can't be compared", dtype)
+	}
+}
+
+// DiffLayer compares the weights of a layer in two models. The values are decoded
+// from the blobs as they are read, so no copies of the tensors are made
+func DiffLayer(name string, a, b *Tensor) (api.LayerDiff, error) {
+	diff := api.LayerDiff{Layer: name, Dtype: a.Dtype, Shape: a.Shape}
+	if a.Dtype != b.Dtype || fmt.Sprint(a.Shape) != fmt.Sprint(b.Shape) {
+		diff.Incompatible = fmt.Sprintf("%s %v in a but %s %v in b", a.Dtype, a.Shape, b.Dtype, b.Shape)
+		return diff, nil
+	}
+
+	element, size, err := tensorElement(a.Dtype)
+	if err != nil {
+		return diff, err
+	}
+	length := int(dimsToLength(a.Shape...))
+	if len(a.Blob) != length*size || len(b.Blob) != length*size {
+		return diff, fmt.Errorf("blobs of layer %s do not match its shape %v", name, a.Shape)
+	}
+
+	var dot, normA, normB, distance float64
+	for i := 0; i < length; i++ {
+		x, y := element(a.Blob, i), element(b.Blob, i)
+		dot += x * y
+		normA += x * x
+		normB += y * y
+		distance += (x - y) * (x - y)
+	}
+	normA, normB = math.Sqrt(normA), math.Sqrt(normB)
+	diff.L2Distance = math.Sqrt(distance)
+
+	// layers that are all zeros in both models are identical,
+	// and have nothing in common with any other layer
+	switch {
+	case normA == 0 && normB == 0:
+		diff.Cosine = 1
+	case normA > 0 && normB > 0:
+		diff.Cosine = dot / (normA * normB)
+	}
+
+	if normA > 0 {
+		relative := diff.L2Distance / normA
+		diff.RelativeChange = &relative
+	} else if diff.L2Distance == 0 {
+		relative := 0.0
+		diff.RelativeChange = &relative
+	}
+	return diff, nil
+}
+
+// updateNorm returns the L2 norm of the change of the floating point layers between
+// two versions of a model. The integer layers, like the batches tracked by the batch
+// normalization, are not trained by the optimizer and are left out of the norm
+func updateNorm(prev, next map[string]*Tensor) (float64, error) {
+	var squares float64
+	for name, t := range next {
+		if t.Dtype != redisai.TypeFloat && t.Dtype != redisai.TypeDouble {
+			continue
+		}
+		p, exists := prev[name]
+		if !exists {
+			continue
+		}
+
+		diff, err := DiffLayer(name, p, t)
+		if err != nil {
+			return 0, err
+		}
+		if len(diff.Incompatible) > 0 {
+			return 0, fmt.Errorf("layer %s changed: %s", name, diff.Incompatible)
+		}
+		squares += diff.L2Distance * diff.L2Distance
+	}
+	return math.Sqrt(squares), nil
+}
@@ -0,0 +1,121 @@
+package model
+
+import (
+	"github.com/RedisAI/redisai-go/redisai"
+	"math"
+	"testing"
+)
+
+func TestDiffLayer(t *testing.T) {
+	shape := []int64{2}
+	tests := []struct {
+		name         string
+		a, b         *Tensor
+		distance     float64
+		cosine       float64
+		relative     float64
+		noRelative   bool
+		incompatible bool
+	}{
+		{name: "identical zeros", a: floatTensor(shape, 0, 0), b: floatTensor(shape, 0, 0),
+			distance: 0, cosine: 1, relative: 0},
+		{name: "identical", a: floatTensor(shape, 3, 4), b: floatTensor(shape, 3, 4),
+			distance: 0, cosine: 1, relative: 0},
+		{name: "opposite", a: floatTensor(shape, 3, 4), b: floatTensor(shape, -3, -4),
+			distance: 10, cosine: -1, relative: 2},
+		{name: "orthogonal", a: floatTensor(shape, 3, 0), b: floatTensor(shape, 0, 4),
+			distance: 5, cosine: 0, relative: 5.0 / 3},
+		{name: "scaled", a: floatTensor(shape, 3, 4), b: floatTensor(shape, 6, 8),
+			distance: 5, cosine: 1, relative: 1},
+		{name: "zeros in a", a: floatTensor(shape, 0, 0), b: floatTensor(shape, 3, 4),
+			distance: 5, cosine: 0, noRelative: true},
+		{name: "zeros in b", a: floatTensor(shape, 3, 4), b: floatTensor(shape, 0, 0),
+			distance: 5, cosine: 0, relative: 1},
+		{name: "integers", a: intTensor(shape, 1, 2), b: intTensor(shape, 1, 4),
+			distance: 2, cosine: 9 / (math.Sqrt(5) * math.Sqrt(17)), relative: 2 / math.Sqrt(5)},
+		{name: "other shape", a: floatTensor(shape, 3, 4), b: floatTensor([]int64{1, 2}, 3, 4),
+			incompatible: true},
+		{name: "other type", a: floatTensor(shape, 3, 4), b: intTensor(shape, 3, 4),
+			incompatible: true},
+	}
+
+	const tolerance = 1e-6
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := DiffLayer("fc", tt.a, tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(diff.Incompatible) > 0) != tt.incompatible {
+				t.Fatalf("got incompatible %q, want incompatible %v", diff.Incompatible, tt.incompatible)
+			}
+			if tt.incompatible {
+				if diff.L2Distance != 0 || diff.Cosine != 0 || diff.RelativeChange != nil {
+					t.Errorf("got metrics %+v of incompatible layers, want none", diff)
+				}
+				return
+			}
+
+			if math.Abs(diff.L2Distance-tt.distance) > tolerance || math.Abs(diff.Cosine-tt.cosine) > tolerance {
+				t.Errorf("got distance %v and cosine %v, want %v and %v", diff.L2Distance, diff.Cosine, tt.distance, tt.cosine)
+			}
+			switch {
+			case tt.noRelative && diff.RelativeChange != nil:
+				t.Errorf("got relative change %v, want none", *diff.RelativeChange)
+			case !tt.noRelative && diff.RelativeChange == nil:
+				t.Errorf("got no relative change, want %v", tt.relative)
+			case !tt.noRelative && math.Abs(*diff.RelativeChange-tt.relative) > tolerance:
+				t.Errorf("got relative change %v, want %v", *diff.RelativeChange, tt.relative)
+			}
+		})
+	}
+}
+
+func TestDiffLayerErrors(t *testing.T) {
+	shape := []int64{2}
+	tests := []struct {
+		name string
+		a, b *Tensor
+	}{
+		{"truncated blob", floatTensor(shape, 1, 2), &Tensor{Dtype: redisai.TypeFloat32, Shape: shape, Blob: []byte{0, 0, 0, 0}}},
+		{"unsupported type", &Tensor{Dtype: redisai.TypeUint8, Shape: shape, Blob: []byte{1, 2}},
+			&Tensor{Dtype: redisai.TypeUint8, Shape: shape, Blob: []byte{1, 2}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DiffLayer("fc", tt.a, tt.b); err == nil {
+				t.Error("got no error, want the layers not compared")
+			}
+		})
+	}
+}
+
+func TestUpdateNorm(t *testing.T) {
+	prev := map[string]*Tensor{
+		"fc.weight":    floatTensor([]int64{2}, 1, 1),
+		"fc.bias":      floatTensor([]int64{1}, 0),
+		"bn.batches":   intTensor([]int64{1}, 10),
+		"removed.bias": floatTensor([]int64{1}, 5),
+	}
+	next := map[string]*Tensor{
+		"fc.weight":  floatTensor([]int64{2}, 4, 5),
+		"fc.bias":    floatTensor([]int64{1}, 12),
+		"bn.batches": intTensor([]int64{1}, 1000),
+		"added.bias": floatTensor([]int64{1}, 7),
+	}
+
+	// only the float layers in both versions count, sqrt(3² + 4² + 12²)
+	norm, err := updateNorm(prev, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if norm != 13 {
+		t.Errorf("got norm %v, want 13", norm)
+	}
+
+	next["fc.bias"] = floatTensor([]int64{2}, 12, 0)
+	if _, err = updateNorm(prev, next); err == nil {
+		t.Error("got no error with a layer that changed its shape")
+	}
+}
@@ -0,0 +1,318 @@
+package model
+
+import (
+	"fmt"
+	"github.com/RedisAI/redisai-go/redisai"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/pkg/errors"
+	"gorgonia.org/tensor"
+	"math"
+	"sort"
+	"sync"
+)
+
+// MergeOperator combines the values of a layer trained by several functions, one
+// slice of values per function, into the values of the reference model. The weights
+// are the ones the functions are merged with, their share of the data, and the
+// slices all have the same length
+type MergeOperator func(layerTensors [][]float32, weights []float64) []float32
+
+var (
+	operatorsMu    sync.RWMutex
+	mergeOperators = map[string]MergeOperator{
+		api.MergeMedian:      MedianMerge,
+		api.MergeTrimmedMean: TrimmedMeanMerge,
+	}
+)
+
+// RegisterMergeOperator adds a merge operator under the name, replacing the one
+// registered before with it. The mean is not in the registry, since the layers
+// are summed as they arrive instead of combined at the merge, and neither is krum,
+// which is built with the byzantine tolerance of each job, see operatorFor
+func RegisterMergeOperator(name string, op MergeOperator) {
+	operatorsMu.Lock()
+	defer operatorsMu.Unlock()
+	mergeOperators[name] = op
+}
+
+// LookupMergeOperator returns the merge operator registered under the name
+func LookupMergeOperator(name string) (MergeOperator, bool) {
+	operatorsMu.RLock()
+	defer operatorsMu.RUnlock()
+	op, exists := mergeOperators[name]
+	return op, exists
+}
+
+// MergeOperatorNames returns the names of the operators a job can merge with,
+// the mean, krum and the ones in the registry, sorted
+func MergeOperatorNames() []string {
+	operatorsMu.RLock()
+	defer operatorsMu.RUnlock()
+
+	names := []string{api.MergeMean, api.MergeKrum}
+	for name := range mergeOperators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateMergeOperator checks that a job can merge with the operator, empty being
+// the mean, looking it up in the registry so the registered operators are accepted
+func ValidateMergeOperator(name string) error {
+	if len(name) == 0 {
+		return nil
+	}
+	if _, exists := operatorFor(name, 0); exists || name == api.MergeMean {
+		return nil
+	}
+	return fmt.Errorf("unknown merge operator \"%s\", expected one of %v", name, MergeOperatorNames())
+}
+
+// weightedValue is the value of a function at one position of a layer
+type weightedValue struct {
+	value  float32
+	weight float64
+}
+
+// sortedColumn returns the values of the functions at the position sorted
+func sortedColumn(layerTensors [][]float32, weights []float64, i int, column []weightedValue) []weightedValue {
+	column = column[:0]
+	for f := range layerTensors {
+		column = append(column, weightedValue{value: layerTensors[f][i], weight: weights[f]})
+	}
+	sort.Slice(column, func(a, b int) bool { return column[a].value < column[b].value })
+	return column
+}
+
+// MedianMerge takes the weighted median of every value, the value at which half of
+// the weight of the functions is reached, averaging the two values around it if
+// exactly half is reached. With equal weights it is the usual median
+func MedianMerge(layerTensors [][]float32, weights []float64) []float32 {
+	if len(layerTensors) == 0 {
+		return nil
+	}
+
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+
+	merged := make([]float32, len(layerTensors[0]))
+	column := make([]weightedValue, 0, len(layerTensors))
+	for i := range merged {
+		column = sortedColumn(layerTensors, weights, i, column)
+
+		var cumulative float64
+		for j, v := range column {
+			cumulative += v.weight
+			if cumulative < total/2 {
+				continue
+			}
+			merged[i] = v.value
+			if cumulative == total/2 && j+1 < len(column) {
+				merged[i] = (v.value + column[j+1].value) / 2
+			}
+			break
+		}
+	}
+	return merged
+}
+
+// TrimmedMeanMerge drops the api.MergeTrimFraction highest and lowest values of the
+// functions at every position, at least one of each once there are three functions,
+// and takes the weighted mean of the rest
+func TrimmedMeanMerge(layerTensors [][]float32, weights []float64) []float32 {
+	n := len(layerTensors)
+	trim := int(math.Floor(float64(n) * api.MergeTrimFraction))
+	if trim == 0 && n >= 3 {
+		trim = 1
+	}
+	return trimmedMean(layerTensors, weights, trim)
+}
+
+// TrimmedMeanOf returns the trimmed mean that drops the f highest and lowest values
+// at every position, so the values of f faulty functions are never averaged. If fewer
+// than 2f+1 functions are merged as many are dropped as leave at least one value
+func TrimmedMeanOf(f int) MergeOperator {
+	return func(layerTensors [][]float32, weights []float64) []float32 {
+		trim := f
+		if n := len(layerTensors); 2*trim >= n {
+			trim = (n - 1) / 2
+		}
+		return trimmedMean(layerTensors, weights, trim)
+	}
+}
+
+// trimmedMean drops the trim highest and lowest values of the functions
+// at every position and takes the weighted mean of the rest
+func trimmedMean(layerTensors [][]float32, weights []float64, trim int) []float32 {
+	if len(layerTensors) == 0 {
+		return nil
+	}
+
+	n := len(layerTensors)
+	merged := make([]float32, len(layerTensors[0]))
+	column := make([]weightedValue, 0, n)
+	for i := range merged {
+		column = sortedColumn(layerTensors, weights, i, column)
+
+		var sum, total float64
+		for _, v := range column[trim : n-trim] {
+			sum += float64(v.value) * v.weight
+			total += v.weight
+		}
+		if total > 0 {
+			merged[i] = float32(sum / total)
+		}
+	}
+	return merged
+}
+
+// KrumMerge returns the krum operator tolerating f faulty functions on a single layer,
+// scoring every function by the distances from its layer to the layers of the others and
+// taking the layer with the lowest score as is, see krumIndex. The merges of the jobs pick
+// one function for the whole model instead, see ParallelSGD, this operator is only used for
+// the layers that function did not push
+func KrumMerge(f int) MergeOperator {
+	return func(layerTensors [][]float32, weights []float64) []float32 {
+		n := len(layerTensors)
+		if n == 0 {
+			return nil
+		}
+
+		best := krumIndex(n, f, func(i, j int) float64 {
+			return squaredDistance(layerTensors[i], layerTensors[j])
+		})
+		return append([]float32(nil), layerTensors[best]...)
+	}
+}
+
+// krumIndex returns the function krum picks out of n tolerating f faulty ones, given the
+// squared distance between the models of two functions. Every function is scored with the
+// sum of the distances to the n-f-2 closest functions and the lowest score wins. If fewer
+// than 2f+3 functions are merged the score uses at least the closest function
+func krumIndex(n, f int, distance func(i, j int) float64) int {
+	neighbours := n - f - 2
+	if neighbours < 1 {
+		neighbours = 1
+	}
+	if neighbours > n-1 {
+		neighbours = n - 1
+	}
+
+	// distances between every pair of functions
+	distances := make([][]float64, n)
+	for i := range distances {
+		distances[i] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			d := distance(i, j)
+			distances[i][j], distances[j][i] = d, d
+		}
+	}
+
+	best, bestScore := 0, math.Inf(1)
+	for i := 0; i < n; i++ {
+		others := make([]float64, 0, n-1)
+		for j := 0; j < n; j++ {
+			if j != i {
+				others = append(others, distances[i][j])
+			}
+		}
+		sort.Float64s(others)
+
+		var score float64
+		for _, d := range others[:neighbours] {
+			score += d
+		}
+		if score < bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// squaredDistance returns the squared euclidean distance between the values
+func squaredDistance(a, b []float32) float64 {
+	var d float64
+	for k := range a {
+		diff := float64(a[k] - b[k])
+		d += diff * diff
+	}
+	return d
+}
+
+// operatorFor returns the operator merging the layers with the given name. Krum and
+// the trimmed mean of the jobs with a byzantine tolerance are built to tolerate it,
+// the rest are looked up in the registry
+func operatorFor(name string, tolerance int) (MergeOperator, bool) {
+	switch {
+	case name == api.MergeKrum:
+		return KrumMerge(tolerance), true
+	case name == api.MergeTrimmedMean && tolerance > 0:
+		return TrimmedMeanOf(tolerance), true
+	}
+	return LookupMergeOperator(name)
+}
+
+// mergeLayers combines the layers collected from the functions with the operator.
+// Integer layers, like the batches tracked by the norm layers, are averaged as
+// the mean merge does, since the operators only take float values
+func mergeLayers(op MergeOperator, layers []*Layer, weights []float64) (*Layer, error) {
+	first := layers[0]
+	shape := first.Weights.Shape().Clone()
+
+	if first.Dtype != redisai.TypeFloat32 {
+		sum := first.Weights.Clone().(*tensor.Dense)
+		var err error
+		for _, l := range layers[1:] {
+			if sum, err = sum.Add(l.Weights); err != nil {
+				return nil, errors.Wrap(err, "error adding weights")
+			}
+		}
+		if sum, err = sum.DivScalar(int64(len(layers)), true); err != nil {
+			return nil, errors.Wrap(err, "error dividing int weights")
+		}
+		return &Layer{Name: first.Name, Dtype: first.Dtype, Weights: sum}, nil
+	}
+
+	values := make([][]float32, len(layers))
+	for i, l := range layers {
+		var err error
+		if values[i], err = floatValues(l); err != nil {
+			return nil, err
+		}
+		if len(values[i]) != len(values[0]) {
+			return nil, fmt.Errorf("layer %s has %d values in one function and %d in another",
+				l.Name, len(values[i]), len(values[0]))
+		}
+	}
+
+	merged := op(values, weights)
+	if len(merged) != len(values[0]) {
+		return nil, fmt.Errorf("merge operator returned %d values for layer %s, expected %d",
+			len(merged), first.Name, len(values[0]))
+	}
+
+	var weightsTensor *tensor.Dense
+	if len(shape) == 0 {
+		weightsTensor = tensor.New(tensor.FromScalar(merged[0]))
+	} else {
+		weightsTensor = tensor.New(tensor.WithShape(shape...), tensor.WithBacking(merged))
+	}
+	return &Layer{Name: first.Name, Dtype: first.Dtype, Weights: weightsTensor}, nil
+}
+
+// floatValues returns the values of a float layer
+func floatValues(l *Layer) ([]float32, error) {
+	switch data := l.Weights.Data().(type) {
+	case []float32:
+		return data, nil
+	case float32:
+		return []float32{data}, nil
+	default:
+		return nil, fmt.Errorf("layer %s has %T values, expected float32", l.Name, data)
+	}
+}
@@ -0,0 +1,262 @@
+package model
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMedianMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		layers  [][]float32
+		weights []float64
+		want    []float32
+	}{
+		{"no functions", nil, nil, nil},
+		{"single function", [][]float32{{1, 2}}, []float64{1}, []float32{1, 2}},
+		{"odd functions", [][]float32{{1, 9}, {3, 1}, {2, 5}}, []float64{1, 1, 1}, []float32{2, 5}},
+		{"even functions", [][]float32{{1, 8}, {4, 2}, {2, 6}, {3, 4}}, []float64{1, 1, 1, 1}, []float32{2.5, 5}},
+		{"outlier", [][]float32{{1}, {2}, {1000}}, []float64{1, 1, 1}, []float32{2}},
+		{"weighted", [][]float32{{1}, {2}, {3}}, []float64{1, 1, 3}, []float32{3}},
+		{"half the weight", [][]float32{{1}, {2}, {3}}, []float64{2, 1, 1}, []float32{1.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MedianMerge(tt.layers, tt.weights); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimmedMeanMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		layers  [][]float32
+		weights []float64
+		want    []float32
+	}{
+		{"no functions", nil, nil, nil},
+		{"two functions are not trimmed", [][]float32{{1, 2}, {3, 6}}, []float64{1, 1}, []float32{2, 4}},
+		{"three functions trim one of each end", [][]float32{{1}, {2}, {1000}}, []float64{1, 1, 1}, []float32{2}},
+		{"each position trimmed apart", [][]float32{{1, 30}, {2, 10}, {3, 20}, {4, -50}}, []float64{1, 1, 1, 1}, []float32{2.5, 15}},
+		{"weighted mean of the rest", [][]float32{{0}, {1}, {4}, {9}}, []float64{1, 3, 1, 1}, []float32{1.75}},
+		{
+			name: "ten percent of twenty functions",
+			layers: [][]float32{
+				{-100}, {-100}, {1}, {1}, {1}, {1}, {1}, {1}, {1}, {1},
+				{1}, {1}, {1}, {1}, {1}, {1}, {1}, {1}, {100}, {100},
+			},
+			weights: []float64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+			want:    []float32{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TrimmedMeanMerge(tt.layers, tt.weights); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimmedMeanOf(t *testing.T) {
+	layers := [][]float32{{-100}, {1}, {2}, {3}, {100}}
+	weights := []float64{1, 1, 1, 1, 1}
+
+	if got := TrimmedMeanOf(1)(layers, weights); !reflect.DeepEqual(got, []float32{2}) {
+		t.Errorf("got %v, want [2]", got)
+	}
+
+	// tolerating more functions than can be dropped keeps the middle one
+	if got := TrimmedMeanOf(4)(layers, weights); !reflect.DeepEqual(got, []float32{2}) {
+		t.Errorf("got %v, want [2]", got)
+	}
+}
+
+func TestParallelSGDMergeWithOperator(t *testing.T) {
+	tests := []struct {
+		operator string
+		want     []float32
+	}{
+		{api.MergeMedian, []float32{2, 3, 4, 5}},
+		{api.MergeTrimmedMean, []float32{2, 3, 4, 5}},
+		{api.MergeMean, []float32{34, 35, 36, 37}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.operator, func(t *testing.T) {
+			m, store := newTestModel(api.TrainOptions{MergeOperator: tt.operator})
+			setFunction(t, store, 0, []float32{1, 2, 3, 4}, 10)
+			setFunction(t, store, 1, []float32{2, 3, 4, 5}, 20)
+			setFunction(t, store, 2, []float32{99, 100, 101, 102}, 30)
+
+			for funcId := 0; funcId < 3; funcId++ {
+				m.Update(funcId, 1, nil)
+			}
+			if err := MakeParallelSGD(zap.NewNop()).Average(m, 3); err != nil {
+				t.Fatal(err)
+			}
+
+			// the robust operators ignore the outlier
+			if got := m.StateDict["fc.weight"].Weights.Data(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got weights %v, want %v", got, tt.want)
+			}
+			if got := m.StateDict["bn.num_batches_tracked"].Weights.Data(); !reflect.DeepEqual(got, []int64{20}) {
+				t.Errorf("got batches %v, want [20]", got)
+			}
+		})
+	}
+}
+
+func TestValidateMergeOperator(t *testing.T) {
+	RegisterMergeOperator("first", func(layerTensors [][]float32, weights []float64) []float32 {
+		return layerTensors[0]
+	})
+	defer func() {
+		operatorsMu.Lock()
+		delete(mergeOperators, "first")
+		operatorsMu.Unlock()
+	}()
+
+	for _, name := range []string{"", api.MergeMean, api.MergeMedian, api.MergeTrimmedMean, api.MergeKrum, "first"} {
+		if err := ValidateMergeOperator(name); err != nil {
+			t.Errorf("got error %v for %q, want nil", err, name)
+		}
+	}
+
+	err := ValidateMergeOperator("mode")
+	if err == nil {
+		t.Fatal("got no error for an unknown operator")
+	}
+	if !strings.Contains(err.Error(), "first") {
+		t.Errorf("got error %v, want it to list the registered operators", err)
+	}
+}
+
+func TestKrumMerge(t *testing.T) {
+	layers := [][]float32{{1, 1}, {1.5, 1}, {1, 1.5}, {50, -50}}
+	weights := []float64{1, 1, 1, 1}
+
+	// the layer of the function closest to the rest is taken as is
+	if got := KrumMerge(1)(layers, weights); !reflect.DeepEqual(got, []float32{1, 1}) {
+		t.Errorf("got %v, want [1 1]", got)
+	}
+	if got := KrumMerge(1)(nil, nil); got != nil {
+		t.Errorf("got %v for no functions, want nil", got)
+	}
+}
+
+func TestParallelSGDRobustMergeIgnoresAttacker(t *testing.T) {
+	honest := [][]float32{{1, 2, 3, 4}, {1.1, 2.1, 3.1, 4.1}, {0.9, 1.9, 2.9, 3.9}, {1, 2.2, 2.8, 4}}
+	attacker := []float32{1000, -1000, 1000, -1000}
+
+	tests := []struct {
+		operator string
+		poisoned bool
+	}{
+		{api.MergeMean, true},
+		{api.MergeMedian, false},
+		{api.MergeTrimmedMean, false},
+		{api.MergeKrum, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.operator, func(t *testing.T) {
+			m, store := newTestModel(api.TrainOptions{MergeOperator: tt.operator, ByzantineTolerance: 1})
+			for funcId, weights := range append(honest, attacker) {
+				setFunction(t, store, funcId, weights, 10)
+				m.Update(funcId, 1, nil)
+			}
+			if err := MakeParallelSGD(zap.NewNop()).Average(m, len(honest)+1); err != nil {
+				t.Fatal(err)
+			}
+
+			got := m.StateDict["fc.weight"].Weights.Data().([]float32)
+			poisoned := false
+			for i, v := range got {
+				if v < honest[2][i] || v > honest[1][i]+0.2 {
+					poisoned = true
+				}
+			}
+			if poisoned != tt.poisoned {
+				t.Errorf("got weights %v, want poisoned %v", got, tt.poisoned)
+			}
+		})
+	}
+}
+
+func TestParallelSGDKrumPicksWholeModel(t *testing.T) {
+	store := NewMemoryStore()
+	task := api.TrainRequest{ModelType: "test", Options: api.TrainOptions{MergeOperator: api.MergeKrum, ByzantineTolerance: 1}}
+	m := NewModel(zap.NewNop(), "job", task, []string{"fc.weight", "fc.bias", "bn.num_batches_tracked"}, store)
+
+	// picked layer by layer, krum would take the weights of function 1 and
+	// the bias of function 2, but over the whole model 2 is the closest
+	functions := []struct {
+		weight float32
+		bias   float32
+	}{
+		{1, 0}, {1.1, 5}, {1.2, 5.1}, {3, 5.2}, {100, -100},
+	}
+	for funcId, f := range functions {
+		setFunction(t, store, funcId, []float32{f.weight, f.weight, f.weight, f.weight}, int64(10*(funcId+1)))
+		if err := store.SetTensor(getWeightKeys("fc.bias", "job", funcId), floatTensor([]int64{1}, f.bias)); err != nil {
+			t.Fatal(err)
+		}
+		m.Update(funcId, 1, nil)
+	}
+
+	if got := KrumMerge(1)([][]float32{{1}, {1.1}, {1.2}, {3}, {100}}, []float64{1, 1, 1, 1, 1}); !reflect.DeepEqual(got, []float32{1.1}) {
+		t.Fatalf("got weight %v picked by the layer, want [1.1]", got)
+	}
+
+	if err := MakeParallelSGD(zap.NewNop()).Average(m, len(functions)); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.StateDict["fc.weight"].Weights.Data(); !reflect.DeepEqual(got, []float32{1.2, 1.2, 1.2, 1.2}) {
+		t.Errorf("got weights %v, want the ones of function 2", got)
+	}
+	if got := m.StateDict["fc.bias"].Weights.Data(); !reflect.DeepEqual(got, []float32{5.1}) {
+		t.Errorf("got bias %v, want the one of function 2", got)
+	}
+
+	// the int layers are still averaged
+	if got := m.StateDict["bn.num_batches_tracked"].Weights.Data(); !reflect.DeepEqual(got, []int64{30}) {
+		t.Errorf("got batches %v, want [30]", got)
+	}
+}
+
+func TestParallelSGDKrumWithLayerGroups(t *testing.T) {
+	store := NewMemoryStore()
+	task := api.TrainRequest{ModelType: "test", Options: api.TrainOptions{MergeOperator: api.MergeKrum}}
+	m := NewModel(zap.NewNop(), "job", task, []string{"fc.weight", "fc.bias", "bn.num_batches_tracked"}, store)
+
+	// function 1 is picked from the weights, but only 0 and 2 pushed
+	// the bias, which is merged with krum on its own
+	weights := []float32{1, 1.1, 1.15}
+	for funcId, w := range weights {
+		setFunction(t, store, funcId, []float32{w, w, w, w}, 10)
+		if err := store.SetTensor(getWeightKeys("fc.bias", "job", funcId), floatTensor([]int64{1}, float32(funcId))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Update(0, 1, nil)
+	m.Update(1, 1, []string{"fc.weight"})
+	m.Update(2, 1, nil)
+
+	if err := MakeParallelSGD(zap.NewNop()).Average(m, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.StateDict["fc.weight"].Weights.Data(); !reflect.DeepEqual(got, []float32{1.1, 1.1, 1.1, 1.1}) {
+		t.Errorf("got weights %v, want the ones of function 1", got)
+	}
+	if got := m.StateDict["fc.bias"].Weights.Data(); !reflect.DeepEqual(got, []float32{0}) {
+		t.Errorf("got bias %v, want the one of function 0", got)
+	}
+}
@@ -3,11 +3,10 @@ package model
 import (
 	"github.com/RedisAI/redisai-go/redisai"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
-	"github.com/diegostock12/kubeml/ml/pkg/util"
-	"github.com/gomodule/redigo/redis"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"gorgonia.org/tensor"
+	"sort"
 	"sync"
 )
 
@@ -38,7 +37,36 @@ type (
 		// first time
 		layerNames []string
 
-		redisPool *redis.Pool
+		// store holds the tensors of the reference
+		// model and of the functions
+		store ModelStore
+
+		// saved keeps the tensors of the reference model last built or
+		// saved, keyed by the layer name, and updateNorm the L2 norm of
+		// the change made to the reference model by the last save
+		saved      map[string]*Tensor
+		updateNorm float64
+
+		// weight is the sum of the weights of the
+		// function models added since the last clear
+		weight float64
+
+		// layerWeights and layerFuncs are the sum of the weights and the
+		// number of function models each layer was added from since the
+		// last clear, which differ between the layers merged in groups
+		layerWeights map[string]float64
+		layerFuncs   map[string]int
+
+		// mergeOperator combines the layers of the functions, see
+		// api.MergeMean. Unless it is the mean the layers are kept apart in
+		// collected, keyed by the layer name, along with their weights and
+		// the functions they came from, see collectedFuncs.
+		// byzantineTolerance is the number of faulty functions it ignores
+		mergeOperator      string
+		byzantineTolerance int
+		collected          map[string][]*Layer
+		collectedWeights   map[string][]float64
+		collectedFuncs     map[string][]int
 
 		// Internal Lock to be applied during the update
 		mu sync.Mutex
@@ -59,15 +87,23 @@ func NewModel(
 	jobId string,
 	task api.TrainRequest,
 	layerNames []string,
-	pool *redis.Pool) *Model {
+	store ModelStore) *Model {
 
 	return &Model{
-		logger:     logger.Named("model"),
-		Name:       task.ModelType,
-		jobId:      jobId,
-		layerNames: layerNames,
-		StateDict:  make(map[string]*Layer),
-		redisPool:  pool,
+		logger:       logger.Named("model"),
+		Name:         task.ModelType,
+		jobId:        jobId,
+		layerNames:   layerNames,
+		StateDict:    make(map[string]*Layer),
+		layerWeights: make(map[string]float64),
+		layerFuncs:   make(map[string]int),
+		store:        store,
+
+		mergeOperator:      task.Options.MergeOperatorOrDefault(),
+		byzantineTolerance: task.Options.ByzantineTolerance,
+		collected:          make(map[string][]*Layer),
+		collectedWeights:   make(map[string][]float64),
+		collectedFuncs:     make(map[string][]int),
 	}
 }
 
@@ -77,37 +113,20 @@ func (m *Model) Build() error {
 	// For each layer name create a new layer with the tensors from the database
 	m.logger.Debug("Building the model", zap.String("jobId", m.jobId))
 
-	// get the client
-	redisClient := util.GetRedisAIClient(m.redisPool, true)
-	defer redisClient.Close()
-
-	// fetch the layers, they will be pipelined
-	// so we perform one loop to query, flush, and then parse all in another loop
-	for _, name := range m.layerNames {
-		m.logger.Debug("Creating new layer", zap.String("layerName", name))
-
-		err := m.fetchLayer(redisClient, name, -1)
-		if err != nil {
-			m.logger.Error("Error building layer",
-				zap.String("layer", name),
-				zap.Error(err))
-			return err
-		}
-
-	}
-
-	err := redisClient.Flush()
+	layers, err := m.fetchLayers(-1, m.layerNames)
 	if err != nil {
-		return errors.Wrap(err, "error flushing commands")
+		m.logger.Error("Error building model", zap.Error(err))
+		return err
 	}
 
-	// parse all responses
-	for _, name := range m.layerNames {
-		layer, err := m.buildLayer(redisClient, name)
+	m.saved = make(map[string]*Tensor, len(layers))
+	for _, layer := range layers {
+		m.StateDict[layer.Name] = layer
+		t, err := encodeLayer(layer)
 		if err != nil {
-			return errors.Wrapf(err, "error loading layer %s", name)
+			return errors.Wrapf(err, "could not encode weights of layer %v", layer.Name)
 		}
-		m.StateDict[name] = layer
+		m.saved[layer.Name] = t
 	}
 
 	return nil
@@ -116,175 +135,248 @@ func (m *Model) Build() error {
 // Clear wipes the statedict of the model
 func (m *Model) Clear() {
 	m.StateDict = make(map[string]*Layer)
+	m.weight = 0
+	m.layerWeights = make(map[string]float64)
+	m.layerFuncs = make(map[string]int)
+	m.collected = make(map[string][]*Layer)
+	m.collectedWeights = make(map[string][]float64)
+	m.collectedFuncs = make(map[string][]int)
 	m.logger.Debug("Wiped model state")
 }
 
 // Summary runs through the layers of a model and prints its info
 func (m *Model) Summary() {
-	for name, layer := range m.StateDict {
+	summary := m.Describe()
+	for _, layer := range summary.Layers {
 		m.logger.Info("Layer",
-			zap.String("name", name),
-			zap.Any("shape", layer.Weights.Shape()),
+			zap.String("name", layer.Name),
+			zap.String("dtype", layer.Dtype),
+			zap.Int64s("shape", layer.Shape),
+			zap.Int64("parameters", layer.Parameters),
+			zap.Int64("bytes", layer.Bytes),
 		)
 	}
+	m.logger.Info("Model parameters",
+		zap.Int64("total", summary.Parameters),
+		zap.Int64("bytes", summary.Bytes))
 
 }
 
+// Describe returns the summary of the layers of the model sorted by name
+func (m *Model) Describe() *api.ModelSummary {
+	summary := &api.ModelSummary{JobId: m.jobId}
+	for name, layer := range m.StateDict {
+		shape := make([]int64, len(layer.Weights.Shape()))
+		for i, d := range layer.Weights.Shape() {
+			shape[i] = int64(d)
+		}
+		summary.AddLayer(DescribeLayer(name, layer.Dtype, shape))
+	}
+
+	sort.Slice(summary.Layers, func(i, j int) bool {
+		return summary.Layers[i].Name < summary.Layers[j].Name
+	})
+	return summary
+}
+
+// DescribeLayer returns the summary of a layer given its type and shape,
+// which can be read from the metadata of the tensor without fetching it
+func DescribeLayer(name, dtype string, shape []int64) api.LayerSummary {
+	parameters := dimsToLength(shape...)
+	return api.LayerSummary{
+		Name:       name,
+		Dtype:      dtype,
+		Shape:      shape,
+		Parameters: parameters,
+		Bytes:      parameters * dtypeSize(dtype),
+	}
+}
+
+// dtypeSize returns the bytes taken by each value of a tensor
+// of the given type, 0 if the type is not known
+func dtypeSize(dtype string) int64 {
+	switch dtype {
+	case redisai.TypeInt8, redisai.TypeUint8:
+		return 1
+	case redisai.TypeInt16, redisai.TypeUint16:
+		return 2
+	case redisai.TypeFloat32, redisai.TypeInt32:
+		return 4
+	case redisai.TypeFloat64, redisai.TypeInt64:
+		return 8
+	default:
+		return 0
+	}
+}
+
 // Save saves the new updated weights and bias in the database so it can be retrieved
-// by the following functions
+// by the following functions. Only the layers in the statedict are saved, the rest
+// of the reference model is left as it was
 func (m *Model) Save() error {
 	m.logger.Info("Publishing model on the database")
 
-	// get the client
-	redisClient := util.GetRedisAIClient(m.redisPool, true)
-	defer redisClient.Close()
-
-	// start the transaction in the redis client
-	redisClient.DoOrSend("MULTI", nil, nil)
+	tensors := make(map[string]*Tensor, len(m.StateDict))
+	layers := make(map[string]*Tensor, len(m.StateDict))
 	for name, layer := range m.StateDict {
 		m.logger.Debug("Setting layer", zap.String("name", name))
-		err := m.setLayer(redisClient, name, layer)
+		t, err := encodeLayer(layer)
 		if err != nil {
-			return err
+			return errors.Wrapf(err, "could not encode weights of layer %v", name)
 		}
+		tensors[getWeightKeys(name, m.jobId, -1)] = t
+		layers[name] = t
 	}
 
-	// execute all commands as a batch and empty response buffer
-	_, err := redisClient.ActiveConn.Do("EXEC")
+	// all the layers are saved as a batch
+	err := m.store.SetTensors(tensors)
 	if err != nil {
 		return errors.Wrap(err, "could not save tensors")
 	}
 
-	m.logger.Info("Model published in the DB")
-	return nil
-
-}
-
-// SetLayer saves a layer's weights and bias if available in the storage
-func (m *Model) setLayer(redisClient *redisai.Client, name string, layer *Layer) error {
-
-	err := m.setWeights(redisClient, name, layer)
+	// the norm is only a diagnostic, so the save does not fail if it can't be computed
+	m.updateNorm, err = updateNorm(m.saved, layers)
 	if err != nil {
-		return err
+		m.logger.Warn("Could not compute the norm of the update", zap.Error(err))
+	}
+	if m.saved == nil {
+		m.saved = make(map[string]*Tensor, len(layers))
+	}
+	for name, t := range layers {
+		m.saved[name] = t
 	}
 
+	m.logger.Info("Model published in the DB")
 	return nil
+
 }
 
-func (m *Model) setWeights(redisClient *redisai.Client, name string, layer *Layer) error {
-	args, _ := makeArgs(m.jobId, name, layer.Weights.Shape(), layer.Dtype, layer.Weights.Data())
-	_, err := redisClient.DoOrSend("AI.TENSORSET", *args, nil)
-	if err != nil {
-		return errors.Wrapf(err, "could not set weights of layer %v", name)
-	}
-	return nil
+// UpdateNorm returns the L2 norm of the change made to the weights of the
+// reference model by the last save, 0 if the model was not saved yet
+func (m *Model) UpdateNorm() float64 {
+	return m.updateNorm
 }
 
-// fetchLayer calls the tensor get function in the pipelined client
-// the results are pipelined and are thus gathered later on in the buildLayer
-// function
-func (m *Model) fetchLayer(redisClient *redisai.Client, name string, funcId int) error {
+// fetchLayers gets the given layers of the model saved with the
+// function id, or the reference model if the function id is -1
+func (m *Model) fetchLayers(funcId int, names []string) ([]*Layer, error) {
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = getWeightKeys(name, m.jobId, funcId)
+	}
 
-	// call get blob but ignore the results cause those are pipelined
-	tensorName := getWeightKeys(name, m.jobId, funcId)
-	_, _, _, err := redisClient.TensorGetBlob(tensorName)
+	tensors, err := m.store.GetTensors(keys)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
-
+	layers := make([]*Layer, len(tensors))
+	for i, t := range tensors {
+		layers[i], err = m.buildLayer(names[i], t)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading layer %s", names[i])
+		}
+	}
+	return layers, nil
 }
 
-// buildLayer reads the pipelined response, parses it and returns the Layer
-func (m *Model) buildLayer(redisClient *redisai.Client, name string) (*Layer, error) {
+// buildLayer parses the tensor fetched from the store and returns the Layer
+func (m *Model) buildLayer(name string, t *Tensor) (*Layer, error) {
 
-	// get the next response in the pipelined client
-	resp, err := redisClient.Receive()
-	err, dtype, shapeInt64, blob := redisai.ProcessTensorGetReply(resp, err)
-	if err != nil {
-		return nil, err
-	}
-
-	switch dtype {
+	switch t.Dtype {
 	case redisai.TypeFloat32:
-		values, err := blobToFloatArray(blob.([]byte), shapeInt64)
+		values, err := blobToFloatArray(t.Blob, t.Shape)
 		if err != nil {
 			return nil, err
 		}
-		shapeInt := shapeToIntArray(shapeInt64...)
+		shapeInt := shapeToIntArray(t.Shape...)
 
-		t := tensor.New(tensor.WithShape(shapeInt...), tensor.WithBacking(values))
+		weights := tensor.New(tensor.WithShape(shapeInt...), tensor.WithBacking(values))
 
 		return &Layer{
 			Name:    name,
-			Dtype:   dtype,
-			Weights: t,
+			Dtype:   t.Dtype,
+			Weights: weights,
 		}, nil
 
 	case redisai.TypeInt64:
-		values, err := blobtoIntArray(blob.([]byte), shapeInt64)
+		values, err := blobtoIntArray(t.Blob, t.Shape)
 		if err != nil {
 			return nil, err
 		}
-		shapeInt := shapeToIntArray(shapeInt64...)
+		shapeInt := shapeToIntArray(t.Shape...)
 
-		t := tensor.New(tensor.WithShape(shapeInt...), tensor.WithBacking(values))
+		weights := tensor.New(tensor.WithShape(shapeInt...), tensor.WithBacking(values))
 
 		return &Layer{
 			Name:    name,
-			Dtype:   dtype,
-			Weights: t,
+			Dtype:   t.Dtype,
+			Weights: weights,
 		}, nil
 
 	default:
 		m.logger.Error("Unknown datatype for tensor",
-			zap.String("dtype", dtype))
+			zap.String("dtype", t.Dtype))
 		return nil, errors.New("Unkown datatype for tensor")
 	}
 
 }
 
-// Update fetches the layers saved by a function and adds them to the statedict
-func (m *Model) Update(funcId int) {
+// Update fetches the layers saved by a function and adds them to the statedict. The
+// float layers are multiplied by the weight of the function before adding them, so
+// that the average of the model is weighted. Integer layers, like the batches tracked
+// by the norm layers, are always added as they are. If the model merges with another
+// operator than the mean, the layers are kept along with the weight instead.
+//
+// Only the given layers are fetched, all of them if nil, so the functions can
+// push the layer groups due in the merge instead of the whole model
+func (m *Model) Update(funcId int, weight float64, names []string) {
 
 	m.logger.Debug("Updating model layers",
-		zap.Int("funcId", funcId))
+		zap.Int("funcId", funcId),
+		zap.Float64("weight", weight),
+		zap.Int("layers", len(names)))
 
-	redisClient := util.GetRedisAIClient(m.redisPool, true)
-	defer redisClient.Close()
+	if names == nil {
+		names = m.layerNames
+	}
 
 	// load the function layers
-	for _, layer := range m.layerNames {
-		err := m.fetchLayer(redisClient, layer, funcId)
-		if err != nil {
-			m.logger.Error("could not fetch layer",
-				zap.Error(err),
-				zap.String("name", layer),
-				zap.Int("funcId", funcId))
-			return
-		}
+	layers, err := m.fetchLayers(funcId, names)
+	if err != nil {
+		m.logger.Error("Could not build layers from database",
+			zap.Error(err),
+			zap.Int("funcId", funcId))
+		return
 	}
 
-	redisClient.Flush()
-
 	// lock the model, only one thread can access the model
 	// concurrently,
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	for _, layerName := range m.layerNames {
-		layer, err := m.buildLayer(redisClient, layerName)
-		if err != nil {
-			m.logger.Error("Could not build layer from database",
-				zap.Error(err),
-				zap.String("name", layerName),
-				zap.Int("funcId", funcId))
-			return
+	for _, layer := range layers {
+		// the layers are combined at the merge by the operators other than the mean
+		if m.mergeOperator != api.MergeMean {
+			m.collected[layer.Name] = append(m.collected[layer.Name], layer)
+			m.collectedWeights[layer.Name] = append(m.collectedWeights[layer.Name], weight)
+			m.collectedFuncs[layer.Name] = append(m.collectedFuncs[layer.Name], funcId)
+			m.layerWeights[layer.Name] += weight
+			m.layerFuncs[layer.Name]++
+			continue
+		}
+
+		if weight != 1 && layer.Dtype == redisai.TypeFloat32 {
+			layer.Weights, err = layer.Weights.MulScalar(float32(weight), true)
+			if err != nil {
+				m.logger.Error("Error weighting weights",
+					zap.Error(err))
+
+				return
+			}
 		}
 
-		if total, exists := m.StateDict[layerName]; !exists {
-			m.StateDict[layerName] = layer
+		if total, exists := m.StateDict[layer.Name]; !exists {
+			m.StateDict[layer.Name] = layer
 		} else {
 			total.Weights, err = total.Weights.Add(layer.Weights)
 			if err != nil {
@@ -294,9 +386,29 @@ func (m *Model) Update(funcId int) {
 				return
 			}
 		}
+		m.layerWeights[layer.Name] += weight
+		m.layerFuncs[layer.Name]++
 	}
 
+	m.weight += weight
+
 	m.logger.Debug("Model updated",
 		zap.Int("funcId", funcId))
 
 }
+
+// Weight returns the sum of the weights of the
+// function models added since the last clear
+func (m *Model) Weight() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.weight
+}
+
+// LayerWeight returns the sum of the weights and the number of the function
+// models the layer was added from since the last clear
+func (m *Model) LayerWeight(name string) (float64, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.layerWeights[name], m.layerFuncs[name]
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"github.com/RedisAI/redisai-go/redisai"
+	"testing"
+)
+
+func TestDescribeLayer(t *testing.T) {
+	tests := []struct {
+		dtype      string
+		shape      []int64
+		parameters int64
+		bytes      int64
+	}{
+		{redisai.TypeFloat32, []int64{6, 1, 5, 5}, 150, 600},
+		{redisai.TypeFloat64, []int64{84, 120}, 10080, 80640},
+		{redisai.TypeInt64, []int64{}, 1, 8},
+		{redisai.TypeInt32, []int64{10}, 10, 40},
+		{redisai.TypeInt16, []int64{10}, 10, 20},
+		{redisai.TypeUint8, []int64{3, 3}, 9, 9},
+		// the bytes of an unknown type are not counted
+		{"BOOL", []int64{4}, 4, 0},
+	}
+
+	for _, tt := range tests {
+		layer := DescribeLayer("layer", tt.dtype, tt.shape)
+		if layer.Parameters != tt.parameters || layer.Bytes != tt.bytes {
+			t.Errorf("%s %v: got %d parameters and %d bytes, want %d and %d",
+				tt.dtype, tt.shape, layer.Parameters, layer.Bytes, tt.parameters, tt.bytes)
+		}
+	}
+}
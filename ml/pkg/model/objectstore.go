@@ -0,0 +1,269 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// gcsEndpoint is the interoperable endpoint of google cloud storage,
+// which accepts the s3 api with the HMAC keys of a service account
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// url schemes of the objects of each backend
+var objectSchemes = map[string]string{
+	api.CheckpointBackendS3:  "s3",
+	api.CheckpointBackendGCS: "gs",
+}
+
+// Operations of the object store, passed to the
+// fault hook of the in-memory object store
+const (
+	ObjectGet    = "get"
+	ObjectPut    = "put"
+	ObjectDelete = "delete"
+)
+
+// ErrObjectNotFound is returned when a key is not in the bucket
+var ErrObjectNotFound = errors.New("object not found")
+
+type (
+	// ObjectStore saves the checkpoints of the models in a bucket
+	ObjectStore interface {
+		Put(key string, data []byte) error
+		Get(key string) ([]byte, error)
+
+		// Open returns a reader of the object, so big objects
+		// can be streamed without holding them in memory
+		Open(key string) (io.ReadCloser, error)
+
+		Delete(keys []string) error
+
+		// URL returns the location of a key, used in the manifests
+		URL(key string) string
+	}
+
+	// BucketStore keeps the objects in an s3 or gcs bucket
+	BucketStore struct {
+		scheme string
+		bucket string
+		client *s3.S3
+	}
+
+	// MemoryObjectStore keeps the objects in a map, it is meant to save the
+	// checkpoints without a bucket. Fault, if set, is called with the operation
+	// and the key before each object is read, written or deleted so errors can
+	// be injected
+	MemoryObjectStore struct {
+		Fault func(op, key string) error
+
+		mu      sync.RWMutex
+		objects map[string][]byte
+	}
+)
+
+// NewObjectStore returns the store of the checkpoint backend. The bucket is set with
+// CHECKPOINT_BUCKET and the credentials are read from the usual AWS variables, which
+// hold the HMAC keys for gcs. CHECKPOINT_ENDPOINT and CHECKPOINT_REGION can point the
+// store to other s3 compatible services
+func NewObjectStore(backend string) (*BucketStore, error) {
+	if !api.IsObjectStorage(backend) {
+		return nil, fmt.Errorf("backend %s does not use object storage", backend)
+	}
+
+	bucket := os.Getenv("CHECKPOINT_BUCKET")
+	if len(bucket) == 0 {
+		return nil, errors.New("CHECKPOINT_BUCKET is not set")
+	}
+
+	config := aws.NewConfig().WithRegion("us-east-1")
+	if region := os.Getenv("CHECKPOINT_REGION"); len(region) > 0 {
+		config = config.WithRegion(region)
+	}
+
+	endpoint := os.Getenv("CHECKPOINT_ENDPOINT")
+	if len(endpoint) == 0 && backend == api.CheckpointBackendGCS {
+		endpoint = gcsEndpoint
+	}
+	if len(endpoint) > 0 {
+		config = config.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create object storage session")
+	}
+
+	return &BucketStore{
+		scheme: objectSchemes[backend],
+		bucket: bucket,
+		client: s3.New(sess),
+	}, nil
+}
+
+// Put uploads the object, replacing it if it exists
+func (s *BucketStore) Put(key string, data []byte) error {
+	_, err := s.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "could not upload %s", s.URL(key))
+	}
+	return nil
+}
+
+// Get downloads the object
+func (s *BucketStore) Get(key string) ([]byte, error) {
+	body, err := s.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read %s", s.URL(key))
+	}
+	return data, nil
+}
+
+// Open starts the download of the object, which the caller must close
+func (s *BucketStore) Open(key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, errors.Wrap(ErrObjectNotFound, s.URL(key))
+		}
+		return nil, errors.Wrapf(err, "could not download %s", s.URL(key))
+	}
+	return out.Body, nil
+}
+
+// Delete removes the objects, the keys that do not exist are ignored
+func (s *BucketStore) Delete(keys []string) error {
+	// a request deletes at most 1000 objects
+	for start := 0; start < len(keys); start += 1000 {
+		end := start + 1000
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		objects := make([]*s3.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		out, err := s.client.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return errors.Wrapf(err, "could not delete objects from %s", s.URL(""))
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return fmt.Errorf("could not delete %s: %s", s.URL(aws.StringValue(e.Key)), aws.StringValue(e.Message))
+		}
+	}
+	return nil
+}
+
+// URL returns the location of the key as scheme://bucket/key
+func (s *BucketStore) URL(key string) string {
+	return fmt.Sprintf("%s://%s/%s", s.scheme, s.bucket, key)
+}
+
+// NewMemoryObjectStore returns an empty in-memory object store
+func NewMemoryObjectStore() *MemoryObjectStore {
+	return &MemoryObjectStore{objects: make(map[string][]byte)}
+}
+
+// before runs the fault hook
+func (s *MemoryObjectStore) before(op, key string) error {
+	if s.Fault != nil {
+		return s.Fault(op, key)
+	}
+	return nil
+}
+
+// Put saves a copy of the object, replacing it if it exists
+func (s *MemoryObjectStore) Put(key string, data []byte) error {
+	if err := s.before(ObjectPut, key); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = append([]byte(nil), data...)
+	return nil
+}
+
+// Get returns a copy of the object
+func (s *MemoryObjectStore) Get(key string) ([]byte, error) {
+	if err := s.before(ObjectGet, key); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, exists := s.objects[key]
+	if !exists {
+		return nil, errors.Wrap(ErrObjectNotFound, s.URL(key))
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Open returns a reader of a copy of the object
+func (s *MemoryObjectStore) Open(key string) (io.ReadCloser, error) {
+	data, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Delete removes the objects, the keys that do not exist are ignored
+func (s *MemoryObjectStore) Delete(keys []string) error {
+	for _, key := range keys {
+		if err := s.before(ObjectDelete, key); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		delete(s.objects, key)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// URL returns the location of the key as mem://key
+func (s *MemoryObjectStore) URL(key string) string {
+	return "mem://" + key
+}
+
+// Keys returns the sorted keys of the objects in the store
+func (s *MemoryObjectStore) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
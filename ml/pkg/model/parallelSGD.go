@@ -1,9 +1,12 @@
 package model
 
 import (
+	"fmt"
 	"github.com/RedisAI/redisai-go/redisai"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"sort"
 )
 
 type (
@@ -22,17 +25,36 @@ func MakeParallelSGD(logger *zap.Logger) ParallelSGD {
 	return ParallelSGD{logger: logger.Named("parallel-sgd")}
 }
 
-// Average averages the layers by the number of finished functions
+// Average averages the layers by the number of finished functions. The float layers
+// are divided by the sum of the weights the functions were added with instead, which
+// is the same number unless the functions are weighted by their share of the data.
+//
+// Each layer is averaged over the functions it was added from, which are fewer than
+// num for the layer groups that only the functions that finished their data pushed.
+//
+// If the model merges with another operator the layers collected from the
+// functions are combined with it instead, see MergeOperator
 func (psgd ParallelSGD) Average(m *Model, num int) error {
+	if m.mergeOperator != api.MergeMean {
+		return psgd.merge(m)
+	}
 
-	psgd.logger.Debug("Averaging", zap.Int("num", num))
+	psgd.logger.Debug("Averaging", zap.Int("num", num), zap.Float64("weight", m.Weight()))
 
 	var err error
-	for _, layer := range m.StateDict {
+	for name, layer := range m.StateDict {
+		weight, funcs := m.LayerWeight(name)
+		if funcs == 0 {
+			funcs = num
+		}
+		if weight <= 0 {
+			weight = float64(funcs)
+		}
+
 		// divide the sum of the layer weights by the
 		switch layer.Dtype {
 		case redisai.TypeFloat32:
-			layer.Weights, err = layer.Weights.DivScalar(float32(num), true)
+			layer.Weights, err = layer.Weights.DivScalar(float32(weight), true)
 			if err != nil {
 				psgd.logger.Error("Error dividing weights",
 					zap.Error(err))
@@ -40,7 +62,7 @@ func (psgd ParallelSGD) Average(m *Model, num int) error {
 			}
 
 		case redisai.TypeInt64:
-			layer.Weights, err = layer.Weights.DivScalar(int64(num), true)
+			layer.Weights, err = layer.Weights.DivScalar(int64(funcs), true)
 			if err != nil {
 				psgd.logger.Error("Error dividing weights",
 					zap.Error(err))
@@ -52,3 +74,138 @@ func (psgd ParallelSGD) Average(m *Model, num int) error {
 	return nil
 
 }
+
+// merge combines the layers collected from the functions with the merge operator
+// of the model and sets the result in the statedict. Functions merged without
+// a weight count as one, like in the average
+func (psgd ParallelSGD) merge(m *Model) error {
+	op, exists := operatorFor(m.mergeOperator, m.byzantineTolerance)
+	if !exists {
+		return fmt.Errorf("unknown merge operator \"%s\"", m.mergeOperator)
+	}
+
+	psgd.logger.Debug("Merging",
+		zap.String("operator", m.mergeOperator),
+		zap.Int("tolerance", m.byzantineTolerance),
+		zap.Int("layers", len(m.collected)))
+
+	// krum picks a single function for the whole model, since the layers of
+	// different functions picked one by one would not make a model together
+	krumFunc := -1
+	if m.mergeOperator == api.MergeKrum {
+		var err error
+		if krumFunc, err = psgd.krumFunction(m); err != nil {
+			return err
+		}
+	}
+
+	for name, layers := range m.collected {
+		// the float layers of the function krum picked are taken as they are
+		if i := indexOf(m.collectedFuncs[name], krumFunc); i >= 0 && layers[0].Dtype == redisai.TypeFloat32 {
+			m.StateDict[name] = layers[i]
+			continue
+		}
+
+		weights := make([]float64, len(layers))
+		for i, w := range m.collectedWeights[name] {
+			weights[i] = w
+			if w <= 0 {
+				weights[i] = 1
+			}
+		}
+
+		if min := api.MinRobustFunctions(m.mergeOperator, m.byzantineTolerance); m.byzantineTolerance > 0 &&
+			m.mergeOperator != api.MergeKrum && len(layers) < min {
+			psgd.logger.Warn("Too few functions to tolerate the faulty ones in the merge",
+				zap.String("layer", name),
+				zap.Int("functions", len(layers)),
+				zap.Int("needed", min))
+		}
+
+		layer, err := mergeLayers(op, layers, weights)
+		if err != nil {
+			psgd.logger.Error("Error merging layer",
+				zap.String("layer", name),
+				zap.Error(err))
+			return errors.Wrapf(err, "could not merge layer %s with %s", name, m.mergeOperator)
+		}
+		m.StateDict[name] = layer
+	}
+
+	return nil
+}
+
+// krumFunction returns the function whose model krum takes, scoring the functions by the
+// distances between their models over all the float layers both of them pushed. The
+// layers of the functions are only pushed in groups if the job syncs them apart, and
+// the ones the picked function did not push are merged with krum layer by layer
+func (psgd ParallelSGD) krumFunction(m *Model) (int, error) {
+	// the values of every float layer by function
+	values := make(map[int]map[string][]float32)
+	for name, layers := range m.collected {
+		if layers[0].Dtype != redisai.TypeFloat32 {
+			continue
+		}
+		for i, l := range layers {
+			v, err := floatValues(l)
+			if err != nil {
+				return 0, err
+			}
+			funcId := m.collectedFuncs[name][i]
+			if values[funcId] == nil {
+				values[funcId] = make(map[string][]float32)
+			}
+			values[funcId][name] = v
+		}
+	}
+
+	funcs := make([]int, 0, len(values))
+	for funcId := range values {
+		funcs = append(funcs, funcId)
+	}
+	if len(funcs) == 0 {
+		return -1, nil
+	}
+	sort.Ints(funcs)
+
+	if min := api.MinRobustFunctions(api.MergeKrum, m.byzantineTolerance); m.byzantineTolerance > 0 && len(funcs) < min {
+		psgd.logger.Warn("Too few functions to tolerate the faulty ones in the merge",
+			zap.Int("functions", len(funcs)),
+			zap.Int("needed", min))
+	}
+
+	var err error
+	best := krumIndex(len(funcs), m.byzantineTolerance, func(i, j int) float64 {
+		var d float64
+		for name, a := range values[funcs[i]] {
+			b, exists := values[funcs[j]][name]
+			if !exists {
+				continue
+			}
+			if len(a) != len(b) {
+				err = fmt.Errorf("layer %s has %d values in one function and %d in another", name, len(a), len(b))
+				continue
+			}
+			d += squaredDistance(a, b)
+		}
+		return d
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "could not merge the model with krum")
+	}
+
+	psgd.logger.Debug("Krum picked the model of a function",
+		zap.Int("funcId", funcs[best]),
+		zap.Ints("funcs", funcs))
+	return funcs[best], nil
+}
+
+// indexOf returns the position of the function in the list, or -1 if it is not in it
+func indexOf(funcs []int, funcId int) int {
+	for i, f := range funcs {
+		if f == funcId {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,28 @@
+package model
+
+import "github.com/pkg/errors"
+
+// SaveSnapshot copies the reference model last saved to the keys of the model id, so
+// it can be loaded by the inference functions while the reference model is updated.
+// It should be called when no merge is in flight, all the layers are saved as a batch
+func (m *Model) SaveSnapshot(modelId string) error {
+	if len(m.saved) == 0 {
+		return errors.New("the model was not saved yet")
+	}
+
+	tensors := make(map[string]*Tensor, len(m.saved))
+	for name, t := range m.saved {
+		tensors[getWeightKeys(name, modelId, -1)] = t
+	}
+
+	if err := m.store.SetTensors(tensors); err != nil {
+		return errors.Wrap(err, "could not save snapshot")
+	}
+	return nil
+}
+
+// DeleteSnapshot deletes the tensors saved under the model id
+func (m *Model) DeleteSnapshot(modelId string) error {
+	_, err := m.store.DeletePrefix(modelId + ":")
+	return err
+}
@@ -0,0 +1,313 @@
+package model
+
+import (
+	"fmt"
+	"github.com/RedisAI/redisai-go/redisai"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations of the model store, passed to the
+// fault hook of the in-memory store
+const (
+	OpGet          = "get"
+	OpSet          = "set"
+	OpMeta         = "meta"
+	OpDeletePrefix = "delete"
+)
+
+// ErrTensorNotFound is returned when a key is not in the store
+var ErrTensorNotFound = errors.New("tensor not found")
+
+type (
+
+	// Tensor is a tensor as saved in the model store, with
+	// its values encoded as a little endian blob
+	Tensor struct {
+		Dtype string
+		Shape []int64
+		Blob  []byte
+	}
+
+	// ModelStore holds the tensors of the models. The batch variants fetch or
+	// save all the tensors at once, pipelining the commands when the backend
+	// supports it
+	ModelStore interface {
+		GetTensor(key string) (*Tensor, error)
+		GetTensors(keys []string) ([]*Tensor, error)
+		SetTensor(key string, t *Tensor) error
+		SetTensors(tensors map[string]*Tensor) error
+		GetMeta(key string) (dtype string, shape []int64, err error)
+		DeletePrefix(prefix string) (int, error)
+	}
+
+	// RedisStore keeps the tensors in RedisAI
+	RedisStore struct {
+		pool *redis.Pool
+	}
+
+	// MemoryStore keeps the tensors in a map, it is meant to run the model
+	// without a RedisAI instance. Latency is added to every operation, and
+	// Fault, if set, is called with the operation and the key before each
+	// tensor is read or written so errors can be injected. Batch writes are
+	// applied one tensor at a time, so a fault in the middle of a batch leaves
+	// it partially written
+	MemoryStore struct {
+		Latency time.Duration
+		Fault   func(op, key string) error
+
+		mu      sync.RWMutex
+		tensors map[string]*Tensor
+	}
+)
+
+// NewRedisStore returns a store that uses the connections of the pool
+func NewRedisStore(pool *redis.Pool) *RedisStore {
+	return &RedisStore{pool: pool}
+}
+
+// GetTensor fetches a single tensor
+func (s *RedisStore) GetTensor(key string) (*Tensor, error) {
+	client := util.GetRedisAIClient(s.pool, false)
+	defer client.Close()
+
+	dtype, shape, blob, err := client.TensorGetBlob(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not get tensor %s", key)
+	}
+	return &Tensor{Dtype: dtype, Shape: shape, Blob: blob}, nil
+}
+
+// GetTensors fetches the tensors in a pipeline and
+// returns them in the order of the keys
+func (s *RedisStore) GetTensors(keys []string) ([]*Tensor, error) {
+	client := util.GetRedisAIClient(s.pool, true)
+	defer client.Close()
+
+	// the replies are pipelined, so they are all
+	// received after the commands are flushed
+	for _, key := range keys {
+		if _, _, _, err := client.TensorGetBlob(key); err != nil {
+			return nil, errors.Wrapf(err, "could not get tensor %s", key)
+		}
+	}
+	if err := client.Flush(); err != nil {
+		return nil, errors.Wrap(err, "error flushing commands")
+	}
+
+	tensors := make([]*Tensor, len(keys))
+	for i, key := range keys {
+		resp, err := client.Receive()
+		err, dtype, shape, blob := redisai.ProcessTensorGetReply(resp, err)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get tensor %s", key)
+		}
+		tensors[i] = &Tensor{Dtype: dtype, Shape: shape, Blob: blob.([]byte)}
+	}
+	return tensors, nil
+}
+
+// SetTensor saves a single tensor
+func (s *RedisStore) SetTensor(key string, t *Tensor) error {
+	client := util.GetRedisAIClient(s.pool, false)
+	defer client.Close()
+
+	if _, err := client.DoOrSend("AI.TENSORSET", tensorSetArgs(key, t), nil); err != nil {
+		return errors.Wrapf(err, "could not set tensor %s", key)
+	}
+	return nil
+}
+
+// SetTensors saves the tensors in a single transaction
+func (s *RedisStore) SetTensors(tensors map[string]*Tensor) error {
+	client := util.GetRedisAIClient(s.pool, true)
+	defer client.Close()
+
+	// start the transaction in the redis client
+	client.DoOrSend("MULTI", nil, nil)
+	for key, t := range tensors {
+		if _, err := client.DoOrSend("AI.TENSORSET", tensorSetArgs(key, t), nil); err != nil {
+			return errors.Wrapf(err, "could not set tensor %s", key)
+		}
+	}
+
+	// execute all commands as a batch and empty response buffer
+	if _, err := client.ActiveConn.Do("EXEC"); err != nil {
+		return errors.Wrap(err, "could not save tensors")
+	}
+	return nil
+}
+
+// GetMeta returns the type and shape of a tensor without fetching its values
+func (s *RedisStore) GetMeta(key string) (string, []int64, error) {
+	client := util.GetRedisAIClient(s.pool, false)
+	defer client.Close()
+
+	dtype, shape, err := client.TensorGetMeta(key)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "could not get metadata of tensor %s", key)
+	}
+	return dtype, shape, nil
+}
+
+// DeletePrefix deletes the tensors whose key starts with the
+// prefix and returns the number of tensors deleted
+func (s *RedisStore) DeletePrefix(prefix string) (int, error) {
+	client := util.GetRedisAIClient(s.pool, false)
+	defer client.Close()
+
+	keys, err := redis.Strings(client.DoOrSend("KEYS", redis.Args{prefix + "*"}, nil))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not list tensors")
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	num, err := redis.Int(client.DoOrSend("DEL", redis.Args{}.AddFlat(keys), nil))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not delete tensors")
+	}
+	return num, nil
+}
+
+// tensorSetArgs returns the arguments of AI.TENSORSET, the values are
+// saved as a blob since redis gives an error if the layer is too big
+func tensorSetArgs(key string, t *Tensor) redis.Args {
+	return redis.Args{}.Add(key, t.Dtype).AddFlat(t.Shape).Add("BLOB").Add(t.Blob)
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tensors: make(map[string]*Tensor)}
+}
+
+// before waits the latency of the store and runs the fault hook
+func (s *MemoryStore) before(op, key string) error {
+	if s.Latency > 0 {
+		time.Sleep(s.Latency)
+	}
+	if s.Fault != nil {
+		return s.Fault(op, key)
+	}
+	return nil
+}
+
+// GetTensor returns a copy of the tensor
+func (s *MemoryStore) GetTensor(key string) (*Tensor, error) {
+	if err := s.before(OpGet, key); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, exists := s.tensors[key]
+	if !exists {
+		return nil, errors.Wrap(ErrTensorNotFound, key)
+	}
+	return t.copy(), nil
+}
+
+// GetTensors returns a copy of the tensors in the order of the keys
+func (s *MemoryStore) GetTensors(keys []string) ([]*Tensor, error) {
+	tensors := make([]*Tensor, len(keys))
+	for i, key := range keys {
+		t, err := s.GetTensor(key)
+		if err != nil {
+			return nil, err
+		}
+		tensors[i] = t
+	}
+	return tensors, nil
+}
+
+// SetTensor saves a copy of the tensor
+func (s *MemoryStore) SetTensor(key string, t *Tensor) error {
+	if err := s.before(OpSet, key); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tensors[key] = t.copy()
+	return nil
+}
+
+// SetTensors saves the tensors in the order of their keys
+func (s *MemoryStore) SetTensors(tensors map[string]*Tensor) error {
+	keys := make([]string, 0, len(tensors))
+	for key := range tensors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := s.SetTensor(key, tensors[key]); err != nil {
+			return errors.Wrapf(err, "could not set tensor %s", key)
+		}
+	}
+	return nil
+}
+
+// GetMeta returns the type and shape of the tensor
+func (s *MemoryStore) GetMeta(key string) (string, []int64, error) {
+	if err := s.before(OpMeta, key); err != nil {
+		return "", nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, exists := s.tensors[key]
+	if !exists {
+		return "", nil, errors.Wrap(ErrTensorNotFound, key)
+	}
+	return t.Dtype, append([]int64(nil), t.Shape...), nil
+}
+
+// DeletePrefix deletes the tensors whose key starts with the prefix
+func (s *MemoryStore) DeletePrefix(prefix string) (int, error) {
+	if err := s.before(OpDeletePrefix, prefix); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var num int
+	for key := range s.tensors {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.tensors, key)
+			num++
+		}
+	}
+	return num, nil
+}
+
+// Keys returns the sorted keys of the tensors in the store
+func (s *MemoryStore) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.tensors))
+	for key := range s.tensors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (t *Tensor) copy() *Tensor {
+	return &Tensor{
+		Dtype: t.Dtype,
+		Shape: append([]int64(nil), t.Shape...),
+		Blob:  append([]byte(nil), t.Blob...),
+	}
+}
+
+// String describes the tensor without its values
+func (t *Tensor) String() string {
+	return fmt.Sprintf("Tensor{%s %v, %d bytes}", t.Dtype, t.Shape, len(t.Blob))
+}
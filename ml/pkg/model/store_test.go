@@ -0,0 +1,258 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/RedisAI/redisai-go/redisai"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"reflect"
+	"testing"
+)
+
+// floatTensor returns a float32 tensor with the values as a blob
+func floatTensor(shape []int64, values ...float32) *Tensor {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, values)
+	return &Tensor{Dtype: redisai.TypeFloat32, Shape: shape, Blob: buf.Bytes()}
+}
+
+// intTensor returns an int64 tensor with the values as a blob
+func intTensor(shape []int64, values ...int64) *Tensor {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, values)
+	return &Tensor{Dtype: redisai.TypeInt64, Shape: shape, Blob: buf.Bytes()}
+}
+
+// storedFloats decodes the float tensor saved in the store under the key
+func storedFloats(t *testing.T, store ModelStore, key string) []float32 {
+	t.Helper()
+	tensor, err := store.GetTensor(key)
+	if err != nil {
+		t.Fatalf("could not get tensor %s: %v", key, err)
+	}
+	values, err := blobToFloatArray(tensor.Blob, tensor.Shape)
+	if err != nil {
+		t.Fatalf("could not decode tensor %s: %v", key, err)
+	}
+	return values
+}
+
+func TestMemoryStoreCopiesTensors(t *testing.T) {
+	store := NewMemoryStore()
+	orig := floatTensor([]int64{2}, 1, 2)
+	if err := store.SetTensor("a", orig); err != nil {
+		t.Fatal(err)
+	}
+
+	// changing the tensor after saving it or after reading it
+	// does not change the one in the store
+	orig.Blob[0] = 0xff
+	got, err := store.GetTensor("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Shape[0] = 10
+	if values := storedFloats(t, store, "a"); !reflect.DeepEqual(values, []float32{1, 2}) {
+		t.Errorf("got values %v, want [1 2]", values)
+	}
+
+	dtype, shape, err := store.GetMeta("a")
+	if err != nil || dtype != redisai.TypeFloat32 || !reflect.DeepEqual(shape, []int64{2}) {
+		t.Errorf("got meta (%v, %v, %v), want (%v, [2], nil)", dtype, shape, err, redisai.TypeFloat32)
+	}
+
+	if _, err := store.GetTensor("missing"); errors.Cause(err) != ErrTensorNotFound {
+		t.Errorf("got error %v, want %v", err, ErrTensorNotFound)
+	}
+}
+
+func TestMemoryStoreDeletePrefix(t *testing.T) {
+	store := NewMemoryStore()
+	err := store.SetTensors(map[string]*Tensor{
+		"job:fc.weight":   floatTensor([]int64{1}, 1),
+		"job:fc.weight/0": floatTensor([]int64{1}, 2),
+		"other:fc.weight": floatTensor([]int64{1}, 3),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	num, err := store.DeletePrefix("job:")
+	if err != nil || num != 2 {
+		t.Errorf("got (%v, %v), want (2, nil)", num, err)
+	}
+	if keys := store.Keys(); !reflect.DeepEqual(keys, []string{"other:fc.weight"}) {
+		t.Errorf("got keys %v, want [other:fc.weight]", keys)
+	}
+}
+
+func TestMemoryStoreFaultLeavesBatchPartiallyWritten(t *testing.T) {
+	store := NewMemoryStore()
+	injected := errors.New("connection reset")
+	var ops []string
+	store.Fault = func(op, key string) error {
+		ops = append(ops, op+" "+key)
+		if op == OpSet && key == "b" {
+			return injected
+		}
+		return nil
+	}
+
+	err := store.SetTensors(map[string]*Tensor{
+		"c": floatTensor([]int64{1}, 3),
+		"a": floatTensor([]int64{1}, 1),
+		"b": floatTensor([]int64{1}, 2),
+	})
+	if errors.Cause(err) != injected {
+		t.Fatalf("got error %v, want %v", err, injected)
+	}
+
+	// the tensors are written in the order of their keys
+	// and the batch stops at the first failure
+	if !reflect.DeepEqual(ops, []string{"set a", "set b"}) {
+		t.Errorf("got operations %v, want [set a set b]", ops)
+	}
+	if keys := store.Keys(); !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Errorf("got keys %v, want [a]", keys)
+	}
+
+	// reads fail as well while the fault is injected
+	store.Fault = func(op, key string) error {
+		if op == OpGet {
+			return injected
+		}
+		return nil
+	}
+	if _, err := store.GetTensors([]string{"a"}); errors.Cause(err) != injected {
+		t.Errorf("got error %v, want %v", err, injected)
+	}
+}
+
+// newTestModel returns a model of the job with a float and an int
+// layer, whose functions save their layers in a memory store
+func newTestModel(options api.TrainOptions) (*Model, *MemoryStore) {
+	store := NewMemoryStore()
+	task := api.TrainRequest{ModelType: "test", Options: options}
+	m := NewModel(zap.NewNop(), "job", task, []string{"fc.weight", "bn.num_batches_tracked"}, store)
+	return m, store
+}
+
+// setFunction saves the layers of a function in the store
+func setFunction(t *testing.T, store ModelStore, funcId int, weights []float32, batches int64) {
+	t.Helper()
+	err := store.SetTensors(map[string]*Tensor{
+		getWeightKeys("fc.weight", "job", funcId):              floatTensor([]int64{2, 2}, weights...),
+		getWeightKeys("bn.num_batches_tracked", "job", funcId): intTensor([]int64{1}, batches),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParallelSGDAverage(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []float64
+		want    []float32
+	}{
+		{"equal weights", []float64{1, 1}, []float32{2, 3, 4, 5}},
+		{"weighted by the data share", []float64{1, 3}, []float32{2.5, 3.5, 4.5, 5.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, store := newTestModel(api.TrainOptions{})
+			setFunction(t, store, 0, []float32{1, 2, 3, 4}, 10)
+			setFunction(t, store, 1, []float32{3, 4, 5, 6}, 20)
+
+			for funcId, weight := range tt.weights {
+				m.Update(funcId, weight, nil)
+			}
+			if err := MakeParallelSGD(zap.NewNop()).Average(m, len(tt.weights)); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Save(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := storedFloats(t, store, "job:fc.weight"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got weights %v, want %v", got, tt.want)
+			}
+
+			// the int layers are divided by the number of functions
+			batches, err := store.GetTensor("job:bn.num_batches_tracked")
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, err := blobtoIntArray(batches.Blob, batches.Shape)
+			if err != nil || !reflect.DeepEqual(values, []int64{15}) {
+				t.Errorf("got batches (%v, %v), want [15]", values, err)
+			}
+		})
+	}
+}
+
+func TestParallelSGDAverageOfLayerGroups(t *testing.T) {
+	m, store := newTestModel(api.TrainOptions{})
+	setFunction(t, store, 0, []float32{1, 2, 3, 4}, 10)
+	setFunction(t, store, 1, []float32{3, 4, 5, 6}, 20)
+
+	// only the first function pushed the int layer, so it
+	// is averaged over one function instead of two
+	m.Update(0, 1, nil)
+	m.Update(1, 1, []string{"fc.weight"})
+	if err := MakeParallelSGD(zap.NewNop()).Average(m, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.StateDict["fc.weight"].Weights.Data(); !reflect.DeepEqual(got, []float32{2, 3, 4, 5}) {
+		t.Errorf("got weights %v, want [2 3 4 5]", got)
+	}
+	if got := m.StateDict["bn.num_batches_tracked"].Weights.Data(); !reflect.DeepEqual(got, []int64{10}) {
+		t.Errorf("got batches %v, want [10]", got)
+	}
+}
+
+func TestSaveFailureKeepsPreviousModel(t *testing.T) {
+	m, store := newTestModel(api.TrainOptions{})
+	err := store.SetTensors(map[string]*Tensor{
+		"job:fc.weight":              floatTensor([]int64{2, 2}, 0, 0, 0, 0),
+		"job:bn.num_batches_tracked": intTensor([]int64{1}, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFunction(t, store, 0, []float32{1, 2, 3, 4}, 10)
+
+	m.Update(0, 1, nil)
+	if err := MakeParallelSGD(zap.NewNop()).Average(m, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the float layer is saved after the int one, so the
+	// failed save leaves only the int layer updated
+	injected := errors.New("connection reset")
+	store.Fault = func(op, key string) error {
+		if op == OpSet && key == "job:fc.weight" {
+			return injected
+		}
+		return nil
+	}
+	if err := m.Save(); errors.Cause(err) != injected {
+		t.Fatalf("got error %v, want %v", err, injected)
+	}
+	if got := storedFloats(t, store, "job:fc.weight"); !reflect.DeepEqual(got, []float32{0, 0, 0, 0}) {
+		t.Errorf("got weights %v, want the previous ones", got)
+	}
+
+	// saving again once the store recovers writes the whole model
+	store.Fault = nil
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedFloats(t, store, "job:fc.weight"); !reflect.DeepEqual(got, []float32{1, 2, 3, 4}) {
+		t.Errorf("got weights %v, want [1 2 3 4]", got)
+	}
+}
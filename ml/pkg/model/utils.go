@@ -5,8 +5,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/RedisAI/redisai-go/redisai"
-	"github.com/gomodule/redigo/redis"
-	"gorgonia.org/tensor"
 )
 
 func shapeToIntArray(shape64 ...int64) []int {
@@ -84,12 +82,13 @@ func fetchTensor(client *redisai.Client, name string) ([]int64, []float32, error
 	return shape, values, nil
 }
 
-// REDIS gives an error if the layer is too big, we must save the
-// layer as a blob directly
-func makeArgs(id, name string, shape tensor.Shape, dtype string, values interface{}) (*redis.Args, error) {
+// encodeLayer converts the weights of a layer to the tensor saved in the store,
+// with the values as a blob since REDIS gives an error if the layer is too big
+func encodeLayer(layer *Layer) (*Tensor, error) {
 
 	// Need to get the blob
 	valBlob := new(bytes.Buffer)
+	values := layer.Weights.Data()
 
 
 	// Some layers inside batch normalization can have special mean and variance
@@ -122,17 +121,16 @@ func makeArgs(id, name string, shape tensor.Shape, dtype string, values interfac
 
 	}
 
+	shape := make([]int64, len(layer.Weights.Shape()))
+	for i, d := range layer.Weights.Shape() {
+		shape[i] = int64(d)
+	}
 
-
-	// build layer name
-	entryName := fmt.Sprintf("%s:%s", id, name)
-
-	// Save the weights and the bias
-	args := redis.Args{}
-	args = args.Add(entryName, dtype).AddFlat(shape)
-	args = args.Add("BLOB").Add(valBlob.Bytes())
-
-	return &args, nil
+	return &Tensor{
+		Dtype: layer.Dtype,
+		Shape: shape,
+		Blob:  valBlob.Bytes(),
+	}, nil
 }
 
 // getWeightKeys returns the proper formatted name of the weights and bias for a specific
@@ -4,11 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
 	"github.com/diegostock12/kubeml/ml/pkg/train"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"time"
 )
 
@@ -19,10 +22,13 @@ func (ps *ParameterServer) listTasks(w http.ResponseWriter, r *http.Request) {
 	ps.mu.RLock()
 	defer ps.mu.RUnlock()
 
-	var tasks []*api.TrainTask
+	var tasks []api.TrainTask
 	for _, task := range ps.jobIndex {
-		tasks = append(tasks, task)
+		tasks = append(tasks, task.Redacted())
 	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Job.JobId < tasks[j].Job.JobId
+	})
 
 	resp, err := json.Marshal(tasks)
 	if err != nil {
@@ -38,6 +44,116 @@ func (ps *ParameterServer) listTasks(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// getTask returns the status of a running task given its id
+func (ps *ParameterServer) getTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	task, exists := ps.jobIndex[jobId]
+	if !exists {
+		http.Error(w, "Job does not exist", http.StatusNotFound)
+		return
+	}
+
+	resp, err := json.Marshal(task.Redacted())
+	if err != nil {
+		ps.logger.Error("error marshalling task", zap.Error(err))
+		http.Error(w, "error sending task", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// getInvocations returns the invocations of the functions of a task in flight,
+// asked to the job directly if it runs as a goroutine or through its api
+func (ps *ParameterServer) getInvocations(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	ps.mu.RLock()
+	task, exists := ps.jobIndex[jobId]
+	job, threaded := ps.jobs[jobId]
+	ps.mu.RUnlock()
+	if !exists {
+		http.Error(w, "Job does not exist", http.StatusNotFound)
+		return
+	}
+
+	var table *api.InvocationTable
+	var err error
+	if threaded {
+		table = job.Invocations()
+	} else {
+		table, err = ps.jobClient.Invocations(task)
+	}
+	if err != nil {
+		ps.logger.Error("could not get invocations of job",
+			zap.String("jobId", jobId),
+			zap.Error(err))
+		respondError(w, err)
+		return
+	}
+
+	resp, err := json.Marshal(table)
+	if err != nil {
+		ps.logger.Error("error marshalling invocations", zap.Error(err))
+		http.Error(w, "error sending invocations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// getSnapshot returns the last snapshot of the model of a running task, asked
+// to the job directly if it runs as a goroutine or through its api
+func (ps *ParameterServer) getSnapshot(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	ps.mu.RLock()
+	task, exists := ps.jobIndex[jobId]
+	job, threaded := ps.jobs[jobId]
+	ps.mu.RUnlock()
+	if !exists {
+		http.Error(w, "Job does not exist", http.StatusNotFound)
+		return
+	}
+
+	var snapshot *api.LiveSnapshot
+	var err error
+	if threaded {
+		snapshot, err = job.LatestSnapshot()
+	} else {
+		snapshot, err = ps.jobClient.Snapshot(task)
+	}
+	if err != nil {
+		ps.logger.Debug("could not get snapshot of job",
+			zap.String("jobId", jobId),
+			zap.Error(err))
+		respondError(w, err)
+		return
+	}
+
+	resp, err := json.Marshal(snapshot)
+	if err != nil {
+		ps.logger.Error("error marshalling snapshot", zap.Error(err))
+		http.Error(w, "error sending snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
 // stopTask stops a task given the id
 func (ps *ParameterServer) stopTask(w http.ResponseWriter, r *http.Request) {
 
@@ -67,6 +183,235 @@ func (ps *ParameterServer) stopTask(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// pauseTask pauses a running job after the epoch in progress, either through
+// the api of the job or directly if the job runs in the parameter server
+func (ps *ParameterServer) pauseTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	var req api.PauseRequest
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ps.logger.Error("Could not read request body", zap.Error(err))
+		http.Error(w, "could not read request body", http.StatusInternalServerError)
+		return
+	}
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "could not unmarshal pause request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ps.mu.RLock()
+	task, exists := ps.jobIndex[jobId]
+	job, threaded := ps.jobs[jobId]
+	ps.mu.RUnlock()
+	if !exists {
+		http.Error(w, "Job does not exist", http.StatusNotFound)
+		return
+	}
+
+	if threaded {
+		err = job.Pause(req)
+	} else {
+		err = ps.jobClient.Pause(task, req)
+	}
+	if err != nil {
+		ps.logger.Error("could not pause job",
+			zap.String("jobId", jobId),
+			zap.Error(err))
+		respondError(w, err)
+		return
+	}
+
+	ps.logger.Info("Pause requested for job",
+		zap.String("jobId", jobId),
+		zap.Time("until", req.Until))
+	w.WriteHeader(http.StatusOK)
+}
+
+// resumeTask resumes a paused job, either through the api of
+// the job or directly if the job runs in the parameter server
+func (ps *ParameterServer) resumeTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	ps.mu.RLock()
+	task, exists := ps.jobIndex[jobId]
+	job, threaded := ps.jobs[jobId]
+	ps.mu.RUnlock()
+	if !exists {
+		http.Error(w, "Job does not exist", http.StatusNotFound)
+		return
+	}
+
+	var err error
+	if threaded {
+		err = job.Resume()
+	} else {
+		err = ps.jobClient.Resume(task)
+	}
+	if err != nil {
+		ps.logger.Error("could not resume job",
+			zap.String("jobId", jobId),
+			zap.Error(err))
+		respondError(w, err)
+		return
+	}
+
+	ps.logger.Info("Resume requested for job", zap.String("jobId", jobId))
+	w.WriteHeader(http.StatusOK)
+}
+
+// respondError answers with the error and its status code if it has one
+func respondError(w http.ResponseWriter, err error) {
+	e, ok := err.(kerror.Error)
+	if !ok {
+		e = kerror.New(http.StatusInternalServerError, err.Error())
+	}
+	kerror.RespondWithError(w, e)
+}
+
+// setLogLevel changes the log level of a running job, either through the
+// api of the job or directly if the job runs in the parameter server. The level
+// is saved in the options of the task so it is shown in its status
+func (ps *ParameterServer) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	var req api.LogLevelRequest
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ps.logger.Error("Could not read request body", zap.Error(err))
+		http.Error(w, "could not read request body", http.StatusInternalServerError)
+		return
+	}
+	if err = json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "could not unmarshal log level", http.StatusBadRequest)
+		return
+	}
+	if err = api.ValidateLogLevel(req.Level); err != nil || len(req.Level) == 0 {
+		http.Error(w, fmt.Sprintf("invalid log level \"%s\"", req.Level), http.StatusBadRequest)
+		return
+	}
+
+	ps.mu.RLock()
+	task, exists := ps.jobIndex[jobId]
+	level, threaded := ps.jobLevels[jobId]
+	ps.mu.RUnlock()
+	if !exists {
+		http.Error(w, "Job does not exist", http.StatusNotFound)
+		return
+	}
+
+	if threaded {
+		var l zapcore.Level
+		if err = l.UnmarshalText([]byte(req.Level)); err == nil {
+			level.SetLevel(l)
+		}
+	} else {
+		err = ps.jobClient.SetLogLevel(task, req.Level)
+	}
+	if err != nil {
+		ps.logger.Error("could not set log level of job",
+			zap.String("jobId", jobId),
+			zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ps.mu.Lock()
+	original := task.Parameters.Options.LogLevel
+	task.Parameters.Options.LogLevel = req.Level
+	task.Parameters.RecordChange("options.log_level", original, task.Parameters.Options.LogLevel,
+		"changed while the job ran")
+	ps.mu.Unlock()
+
+	ps.logger.Info("Changed log level of job",
+		zap.String("jobId", jobId),
+		zap.String("level", req.Level))
+	w.WriteHeader(http.StatusOK)
+}
+
+// setMergePause keeps in the state of a job that its merger is waiting for
+// redis to come back, so it is returned with the task status. The jobs
+// send the pause with PUT and clear it with DELETE once they resume
+func (ps *ParameterServer) setMergePause(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	var pause *api.MergePause
+	if r.Method == http.MethodPut {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			ps.logger.Error("Could not read request body", zap.Error(err))
+			http.Error(w, "could not read request body", http.StatusInternalServerError)
+			return
+		}
+		pause = &api.MergePause{}
+		if err = json.Unmarshal(body, pause); err != nil {
+			http.Error(w, "could not unmarshal merge pause", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ps.mu.Lock()
+	task, exists := ps.jobIndex[jobId]
+	if exists {
+		task.Job.State.MergePaused = pause
+	}
+	ps.mu.Unlock()
+	if !exists {
+		http.Error(w, "Job does not exist", http.StatusNotFound)
+		return
+	}
+
+	ps.logger.Info("Merge pause of job updated",
+		zap.String("jobId", jobId),
+		zap.Bool("paused", pause != nil))
+	w.WriteHeader(http.StatusOK)
+}
+
+// setJobPause keeps in the state of a job that it is paused through the
+// API, so it is returned with the task status. The jobs send the pause
+// with PUT and clear it with DELETE once they resume
+func (ps *ParameterServer) setJobPause(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	var pause *api.JobPause
+	if r.Method == http.MethodPut {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			ps.logger.Error("Could not read request body", zap.Error(err))
+			http.Error(w, "could not read request body", http.StatusInternalServerError)
+			return
+		}
+		pause = &api.JobPause{}
+		if err = json.Unmarshal(body, pause); err != nil {
+			http.Error(w, "could not unmarshal job pause", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ps.mu.Lock()
+	task, exists := ps.jobIndex[jobId]
+	if exists {
+		task.Job.State.Paused = pause
+	}
+	ps.mu.Unlock()
+	if !exists {
+		http.Error(w, "Job does not exist", http.StatusNotFound)
+		return
+	}
+
+	ps.logger.Info("Pause of job updated",
+		zap.String("jobId", jobId),
+		zap.Bool("paused", pause != nil))
+	w.WriteHeader(http.StatusOK)
+}
+
 // updateTask Handles the responses from the scheduler to the
 // requests by the parameter servers to
 func (ps *ParameterServer) updateTask(w http.ResponseWriter, r *http.Request) {
@@ -113,7 +458,16 @@ func (ps *ParameterServer) updateTask(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
-		task.Job.Channel <- &update
+		// the job stops waiting for the update after the timeout, in that
+		// case the update is dropped instead of blocking the request
+		select {
+		case task.Job.Channel <- &update:
+		case <-time.After(task.Parameters.Options.UpdateTimeout()):
+			ps.logger.Error("Timed out sending the update, the job is not waiting for it",
+				zap.String("jobId", jobId))
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
 	}
 
 }
@@ -133,6 +487,19 @@ func (ps *ParameterServer) updateEntry(id string, task *api.TrainTask) {
 	ps.jobIndex[id] = task
 }
 
+// addEntry adds the task to the jobIndex unless there is already a task with the
+// same id, returning whether it was added
+func (ps *ParameterServer) addEntry(id string, task *api.TrainTask) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, exists := ps.jobIndex[id]; exists {
+		return false
+	}
+	ps.jobIndex[id] = task
+	return true
+}
+
 // startTask Handles the request of the scheduler to create a
 // new training job. It creates a new parameter server thread and returns the id
 // of the created parameeter server
@@ -156,9 +523,37 @@ func (ps *ParameterServer) startTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the budget of the job overrides the default of the cluster
+	task.Job.RedisBudget = ps.redisBudget
+	if task.Parameters.Options.RedisBudgetMB > 0 {
+		task.Job.RedisBudget = int64(task.Parameters.Options.RedisBudgetMB) << 20
+	}
+	task.Job.HistoryFlushInterval = ps.historyFlushInterval
+
 	// set the task even before trying to start it for visibility,
-	// we will update it later
-	ps.updateEntry(task.Job.JobId, &task)
+	// we will update it later. A continued job keeps the id of the job
+	// it continues, so two continues of a job could start the same id.
+	// A copy is published since the task is still filled in below
+	published := task
+	if !ps.addEntry(task.Job.JobId, &published) {
+		ps.logger.Error("Task is already running", zap.String("jobId", task.Job.JobId))
+		http.Error(w, fmt.Sprintf("job %s is already running", task.Job.JobId), http.StatusConflict)
+		return
+	}
+
+	// create the copy of the function that mounts the scratch volume
+	// so the job invokes it instead of the shared function
+	if task.Parameters.ScratchGB > 0 {
+		name, err := ps.createScratchFunction(&task)
+		if err != nil {
+			ps.logger.Error("error creating function with scratch volume",
+				zap.Error(err))
+			ps.deleteEntry(task.Job.JobId)
+			http.Error(w, "unable to create scratch volume for job", http.StatusInternalServerError)
+			return
+		}
+		task.Job.FunctionName = name
+	}
 
 	ps.logger.Debug("About to create pod")
 	// if we are deploying the jobs in different pods
@@ -170,6 +565,7 @@ func (ps *ParameterServer) startTask(w http.ResponseWriter, r *http.Request) {
 				zap.Error(err))
 
 			// delete the entry
+			ps.cleanScratchFunction(&task)
 			ps.deleteEntry(task.Job.JobId)
 			http.Error(w, "unable to create resources for job", http.StatusInternalServerError)
 			return
@@ -213,6 +609,10 @@ func (ps *ParameterServer) startTask(w http.ResponseWriter, r *http.Request) {
 		ch := make(chan *api.JobState)
 		task.Job.Channel = ch
 		job := train.NewTrainJob(ps.logger, &task, ch, ps.scheduler)
+		ps.mu.Lock()
+		ps.jobLevels[task.Job.JobId] = job.LogLevel()
+		ps.jobs[task.Job.JobId] = job
+		ps.mu.Unlock()
 		go job.Train()
 	}
 
@@ -250,44 +650,99 @@ func (ps *ParameterServer) updateJobMetrics(w http.ResponseWriter, r *http.Reque
 		zap.String("jobId", jobId),
 		zap.Any("metrics", metrics))
 
+	// the metrics are kept by epoch and metric, so the updates that were
+	// retried or arrived out of order are set in their own epoch. Only the
+	// newest update of the job is exported and kept in the index
+	ps.mu.Lock()
+	recorded, exists := ps.metrics[jobId]
+	if !exists {
+		recorded = newJobMetrics()
+		ps.metrics[jobId] = recorded
+	}
+	if !recorded.apply(metrics) {
+		ps.mu.Unlock()
+		ps.logger.Debug("Received an older metric update",
+			zap.String("jobId", jobId),
+			zap.Int("epoch", metrics.Epoch),
+			zap.Int64("seq", metrics.Seq))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	updateMetrics(jobId, metrics)
+
+	// keep the latest estimation of the remaining time, the redis memory and
+	// the measured concurrency in the index so they are returned with the task status
+	if task, exists := ps.jobIndex[jobId]; exists {
+		eta := metrics.ETA
+		task.Job.State.ETA = &eta
+		task.Job.State.RedisMemory = metrics.RedisMemory
+		task.Job.State.MeasuredConcurrency = int(metrics.MeasuredConcurrency)
+	}
+	ps.mu.Unlock()
 	ps.logger.Debug("metrics updated", zap.String("jobId", jobId))
 
 	w.WriteHeader(http.StatusOK)
 }
 
-// jobFinish receives the finish signal from the jobs and takes care of the job cleaning
-// process.
-//
-// 1) Deletes the metrics corresponding to that job
-// 2) Communicates the finish to the scheduler so it is also cleaned there
-// 3) Deletes the Pod using the kubernetes client
-// 4) Deletes the entry in the job index of the parameter server
+// jobFinish receives the finish signal from the jobs, with the error of
+// the job in the body if it failed, and finishes the job
 func (ps *ParameterServer) jobFinish(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	jobId := vars["jobId"]
 
-	ps.mu.RLock()
-	// TODO reget task here
-	_, exists := ps.jobIndex[jobId]
-	ps.mu.RUnlock()
-	if !exists {
+	// check if the body is not nil, in that case, report the error to notify of a failure
+	var exitErr string
+	if r.Body != http.NoBody {
+		errorStr, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			ps.logger.Debug("error reading error body", zap.Error(err))
+		}
+		exitErr = string(errorStr)
+	}
+
+	if err := ps.finishJob(jobId, exitErr); err != nil {
 		ps.logger.Error("Received finish from untracked job",
 			zap.String("jobId", jobId))
-		http.Error(w, "job not found in index", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	w.WriteHeader(http.StatusOK)
+
+}
+
+// finishJob takes care of the job cleaning process once it finishes.
+//
+// 1) Deletes the metrics corresponding to that job
+// 2) Communicates the finish to the scheduler so it is also cleaned there
+// 3) Deletes the function copy with the scratch volume if any
+// 4) Deletes the Pod using the kubernetes client
+// 5) Deletes the entry in the job index of the parameter server
+func (ps *ParameterServer) finishJob(jobId, exitErr string) error {
+	ps.mu.RLock()
+	task, exists := ps.jobIndex[jobId]
+	ps.mu.RUnlock()
+	if !exists {
+		return errJobNotFound
+	}
+
 	// clean the metrics for that job
 	clearMetrics(jobId)
 
-	// communicate the scheduler that the job is done
-	err := ps.scheduler.FinishJob(jobId)
-	if err != nil {
-		ps.logger.Error("Error sending finish to scheduler",
-			zap.Error(err))
+	// communicate the scheduler that the job is done, static
+	// jobs are not tracked by the scheduler after admission
+	if !task.Parameters.Options.StaticParallelism {
+		err := ps.scheduler.FinishJob(jobId)
+		if err != nil {
+			ps.logger.Error("Error sending finish to scheduler",
+				zap.Error(err))
+		}
 	}
 
+	// remove the per-job function with the scratch volume
+	ps.cleanScratchFunction(task)
+
 	// delete the pod and service if standalone
 	if ps.deployStandaloneJobs {
 		// TODO should we retry or something here
@@ -304,26 +759,21 @@ func (ps *ParameterServer) jobFinish(w http.ResponseWriter, r *http.Request) {
 	// finally delete the pod from the index
 	ps.mu.Lock()
 	delete(ps.jobIndex, jobId)
+	delete(ps.metrics, jobId)
+	delete(ps.jobLevels, jobId)
+	delete(ps.jobs, jobId)
 	ps.mu.Unlock()
 
 	taskFinished(TrainTask)
 
-	// check if the body is not nil, in that case, report the error to notify of a failure
-	if r.Body == http.NoBody {
+	if len(exitErr) == 0 {
 		ps.logger.Info("Job finished successfully", zap.String("jobId", jobId))
 	} else {
-		errorStr, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			ps.logger.Debug("error reading error body", zap.Error(err))
-		} else {
-			ps.logger.Info("Job finished with error message",
-				zap.String("jobId", jobId),
-				zap.String("error", string(errorStr)))
-		}
+		ps.logger.Info("Job finished with error message",
+			zap.String("jobId", jobId),
+			zap.String("error", exitErr))
 	}
-
-	w.WriteHeader(http.StatusOK)
-
+	return nil
 }
 
 // Handle Kubernetes heartbeats
@@ -340,7 +790,15 @@ func (ps *ParameterServer) GetHandler() http.Handler {
 	r.HandleFunc("/metrics/{jobId}", ps.updateJobMetrics).Methods("POST")
 	r.HandleFunc("/finish/{jobId}", ps.jobFinish).Methods("POST")
 	r.HandleFunc("/stop/{jobId}", ps.stopTask).Methods("DELETE")
+	r.HandleFunc("/pause/{jobId}", ps.pauseTask).Methods("POST")
+	r.HandleFunc("/resume/{jobId}", ps.resumeTask).Methods("POST")
 	r.HandleFunc("/tasks", ps.listTasks).Methods("GET")
+	r.HandleFunc("/tasks/{jobId}", ps.getTask).Methods("GET")
+	r.HandleFunc("/tasks/{jobId}/invocations", ps.getInvocations).Methods("GET")
+	r.HandleFunc("/tasks/{jobId}/snapshot", ps.getSnapshot).Methods("GET")
+	r.HandleFunc("/tasks/{jobId}/loglevel", ps.setLogLevel).Methods("PUT")
+	r.HandleFunc("/tasks/{jobId}/pause", ps.setMergePause).Methods("PUT", "DELETE")
+	r.HandleFunc("/tasks/{jobId}/paused", ps.setJobPause).Methods("PUT", "DELETE")
 	return r
 }
 
@@ -0,0 +1,104 @@
+package ps
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestStartTaskAlreadyRunning(t *testing.T) {
+	running := &api.TrainTask{Job: api.JobInfo{JobId: "job"}}
+	ps := &ParameterServer{
+		logger:   zap.NewNop(),
+		jobIndex: map[string]*api.TrainTask{"job": running},
+	}
+
+	// a second continue of the job is refused and the running one is kept
+	body, _ := json.Marshal(api.TrainTask{Job: api.JobInfo{JobId: "job"}})
+	w := httptest.NewRecorder()
+	ps.startTask(w, httptest.NewRequest(http.MethodPost, "/start", bytes.NewReader(body)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status code %d, want %d", w.Code, http.StatusConflict)
+	}
+	if ps.jobIndex["job"] != running {
+		t.Error("got the running task replaced")
+	}
+}
+
+func TestUpdateJobMetricsReplay(t *testing.T) {
+	// each epoch sends the metrics after training and then after validating
+	var updates []api.MetricUpdate
+	for epoch := 1; epoch <= 3; epoch++ {
+		train := api.MetricUpdate{
+			Epoch:     epoch,
+			Seq:       int64(2*epoch - 1),
+			TrainLoss: float64(10 - epoch),
+			Accuracy:  float64(10 * (epoch - 1)),
+			ETA:       api.ETA{Seconds: float64(100 - 2*epoch + 1)},
+		}
+		validation := train
+		validation.Seq++
+		validation.Accuracy = float64(10 * epoch)
+		validation.ETA.Seconds--
+		updates = append(updates, train, validation)
+	}
+
+	tests := []struct {
+		name  string
+		order []int
+	}{
+		{"in order", []int{0, 1, 2, 3, 4, 5}},
+		{"duplicated", []int{0, 0, 1, 1, 2, 3, 3, 2, 4, 5, 5}},
+		{"out of order", []int{1, 0, 3, 5, 2, 4}},
+		{"retried after newer epochs", []int{0, 1, 2, 3, 4, 5, 1, 0, 3, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobId := "job-" + tt.name
+			defer clearMetrics(jobId)
+			task := &api.TrainTask{Job: api.JobInfo{JobId: jobId}}
+			ps := &ParameterServer{
+				logger:   zap.NewNop(),
+				jobIndex: map[string]*api.TrainTask{jobId: task},
+				metrics:  make(map[string]*jobMetrics),
+			}
+
+			for _, i := range tt.order {
+				body, _ := json.Marshal(updates[i])
+				r := httptest.NewRequest(http.MethodPost, "/metrics", bytes.NewReader(body))
+				w := httptest.NewRecorder()
+				ps.updateJobMetrics(w, mux.SetURLVars(r, map[string]string{"jobId": jobId}))
+				if w.Code != http.StatusOK {
+					t.Fatalf("got status code %d for update %d, want %d", w.Code, updates[i].Seq, http.StatusOK)
+				}
+			}
+
+			// one value per epoch, the one of its newest update
+			recorded := ps.metrics[jobId]
+			for metric, want := range map[string][]float64{
+				api.MetricTrainLoss: {9, 8, 7},
+				api.MetricAccuracy:  {10, 20, 30},
+			} {
+				if got := recorded.series(metric); !reflect.DeepEqual(got, want) {
+					t.Errorf("got %s %v, want %v", metric, got, want)
+				}
+			}
+
+			// the newest update is the one exported
+			if got := testutil.ToFloat64(accuracy.WithLabelValues(jobId)); got != 30 {
+				t.Errorf("got exported accuracy %v, want %v", got, 30)
+			}
+			if task.Job.State.ETA == nil || task.Job.State.ETA.Seconds != 94 {
+				t.Errorf("got eta %v, want the one of the last update", task.Job.State.ETA)
+			}
+		})
+	}
+}
@@ -4,11 +4,18 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
+)
+
+const (
+	metricsRetries = 3
+	metricsBackoff = 100 * time.Millisecond
 )
 
 type (
@@ -24,7 +31,7 @@ func MakeClient(logger *zap.Logger, psUrl string) *Client {
 	return &Client{
 		logger:     logger.Named("ps-client"),
 		psUrl:      strings.TrimSuffix(psUrl, "/"),
-		httpClient: &http.Client{},
+		httpClient: &http.Client{Timeout: api.RequestTimeout},
 	}
 
 }
@@ -80,6 +87,186 @@ func (c *Client) ListTasks() ([]byte, error) {
 	return body, nil
 }
 
+// GetTask returns the status of a running task in a byte format,
+// the controller will just redirect the bytes to the requester
+func (c *Client) GetTask(id string) ([]byte, error) {
+	url := c.psUrl + "/tasks/" + id
+
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "error performing request")
+	}
+
+	// keep the status code so callers can tell if the task does not exist
+	if err = kerror.CheckFunctionError(resp); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading response body")
+	}
+
+	return body, nil
+}
+
+// GetInvocations returns the invocations of the functions of a running task
+// in flight in a byte format, the controller redirects the bytes to the requester
+func (c *Client) GetInvocations(id string) ([]byte, error) {
+	url := c.psUrl + "/tasks/" + id + "/invocations"
+
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "error performing request")
+	}
+
+	// keep the status code so callers can tell if the task does not exist
+	if err = kerror.CheckFunctionError(resp); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading response body")
+	}
+
+	return body, nil
+}
+
+// GetSnapshot returns the last snapshot of the model of a running task. The error
+// keeps the status code, 404 if the task is not running, so callers can tell why
+func (c *Client) GetSnapshot(id string) (*api.LiveSnapshot, error) {
+	url := c.psUrl + "/tasks/" + id + "/snapshot"
+
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "error performing request")
+	}
+	if err = kerror.CheckFunctionError(resp); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var snapshot api.LiveSnapshot
+	if err = json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return nil, errors.Wrap(err, "could not decode snapshot")
+	}
+	return &snapshot, nil
+}
+
+// SetLogLevel changes the log level of a running task
+func (c *Client) SetLogLevel(id, level string) error {
+	url := c.psUrl + "/tasks/" + id + "/loglevel"
+
+	body, err := json.Marshal(api.LogLevelRequest{Level: level})
+	if err != nil {
+		return errors.Wrap(err, "could not marshal log level")
+	}
+
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error performing request")
+	}
+
+	// keep the status code so the controller can forward it
+	return kerror.CheckFunctionError(resp)
+}
+
+// PauseTask pauses the task given the task id after the epoch in progress
+func (c *Client) PauseTask(id string, pause api.PauseRequest) error {
+	url := c.psUrl + "/pause/" + id
+
+	body, err := json.Marshal(pause)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal pause request")
+	}
+
+	resp, err := c.httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "error performing request")
+	}
+
+	// keep the status code so the controller can forward it
+	return kerror.CheckFunctionError(resp)
+}
+
+// ResumeTask resumes the paused task given the task id
+func (c *Client) ResumeTask(id string) error {
+	url := c.psUrl + "/resume/" + id
+
+	resp, err := c.httpClient.Post(url, "application/json", nil)
+	if err != nil {
+		return errors.Wrap(err, "error performing request")
+	}
+
+	// keep the status code so the controller can forward it
+	return kerror.CheckFunctionError(resp)
+}
+
+// SetJobPause reports that a job is paused through the API,
+// a nil pause clears it once the job resumes
+func (c *Client) SetJobPause(jobId string, pause *api.JobPause) error {
+	url := c.psUrl + "/tasks/" + jobId + "/paused"
+
+	method, body := http.MethodDelete, []byte(nil)
+	if pause != nil {
+		var err error
+		method = http.MethodPut
+		body, err = json.Marshal(pause)
+		if err != nil {
+			return errors.Wrap(err, "could not marshal job pause")
+		}
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error performing request")
+	}
+	return kerror.CheckHttpResponse(resp)
+}
+
+// SetMergePause reports that the merger of a job is waiting for redis
+// to come back, a nil pause clears it once the merger resumes
+func (c *Client) SetMergePause(jobId string, pause *api.MergePause) error {
+	url := c.psUrl + "/tasks/" + jobId + "/pause"
+
+	method, body := http.MethodDelete, []byte(nil)
+	if pause != nil {
+		var err error
+		method = http.MethodPut
+		body, err = json.Marshal(pause)
+		if err != nil {
+			return errors.Wrap(err, "could not marshal merge pause")
+		}
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error performing request")
+	}
+	return kerror.CheckHttpResponse(resp)
+}
+
 // UpdateTask sends the parameters to the PS for the
 // next epoch of a particular training job
 func (c *Client) UpdateTask(task *api.TrainTask) error {
@@ -120,7 +307,10 @@ func (c *Client) StartTask(task *api.TrainTask) error {
 }
 
 // UpdateMetrics sends a new metric set to the parameter server from the Jobs
-// so they can be exposed to prometheus
+// so they can be exposed to prometheus.
+//
+// The update is retried if it fails, since it carries its sequence number the
+// parameter server ignores it if a previous attempt already reached it
 func (c *Client) UpdateMetrics(jobId string, update *api.MetricUpdate) error {
 	url := c.psUrl + "/metrics/" + jobId
 
@@ -129,12 +319,33 @@ func (c *Client) UpdateMetrics(jobId string, update *api.MetricUpdate) error {
 		return errors.Wrap(err, "could not marshal metrics object")
 	}
 
-	_, err = c.httpClient.Post(url, "application/json", bytes.NewReader(body))
+	wait := metricsBackoff
+	for attempt := 0; ; attempt++ {
+		err = c.postMetrics(url, body)
+		if err == nil || attempt == metricsRetries {
+			break
+		}
+
+		c.logger.Debug("retrying metrics update",
+			zap.String("jobId", jobId),
+			zap.Int("attempt", attempt+1),
+			zap.Error(err))
+		time.Sleep(wait)
+		wait *= 2
+	}
+
+	return err
+}
+
+// postMetrics performs a single attempt to send the metrics to the ps
+func (c *Client) postMetrics(url string, body []byte) error {
+	resp, err := c.httpClient.Post(url, "application/json", bytes.NewReader(body))
 	if err != nil {
 		return errors.Wrap(err, "could not send metrics to the ps")
 	}
+	defer resp.Body.Close()
 
-	return nil
+	return kerror.CheckHttpResponse(resp)
 }
 
 // JobFinished communicates to the parameter server that a job has finished. The PS
@@ -142,19 +353,21 @@ func (c *Client) UpdateMetrics(jobId string, update *api.MetricUpdate) error {
 func (c *Client) JobFinished(jobId string, exitErr error) error {
 	url := c.psUrl + "/finish/" + jobId
 
+	var resp *http.Response
 	var err error
 	// if there is an error add it in the body so that the
 	// parameter server reports it
 	if exitErr != nil {
 		body := []byte(exitErr.Error())
-		_, err = c.httpClient.Post(url, "text/plain", bytes.NewReader(body))
+		resp, err = c.httpClient.Post(url, "text/plain", bytes.NewReader(body))
 	} else {
-		_, err = c.httpClient.Post(url, "text/plain", nil)
+		resp, err = c.httpClient.Post(url, "text/plain", nil)
 	}
 
 	if err != nil {
 		return errors.Wrap(err, "could not send finish notification")
 	}
+	defer resp.Body.Close()
 
-	return nil
+	return errors.Wrap(kerror.CheckHttpResponse(resp), "parameter server refused finish notification")
 }
@@ -3,6 +3,7 @@ package ps
 import (
 	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -12,9 +13,36 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"net/http"
+	"os"
 	"time"
 )
 
+// jobEnvVars are the settings of the parameter server deployment that the
+// standalone jobs also need, they are passed to the job pods if they are set
+var jobEnvVars = []string{
+	"CHECKPOINT_BUCKET",
+	"CHECKPOINT_ENDPOINT",
+	"CHECKPOINT_REGION",
+	"INVOCATION_QUEUE_URL",
+	"INVOCATION_QUEUE_PREFIX",
+	"INVOCATION_CONCURRENCY",
+	"FISSION_ROUTER_URL",
+	"FISSION_NAMESPACE",
+	util.MongoDatabaseEnv,
+	util.MongoHistoryCollectionEnv,
+}
+
+// jobEnv returns the environment of the job pods
+func jobEnv() []corev1.EnvVar {
+	var env []corev1.EnvVar
+	for _, name := range jobEnvVars {
+		if value := os.Getenv(name); len(value) > 0 {
+			env = append(env, corev1.EnvVar{Name: name, Value: value})
+		}
+	}
+	return env
+}
+
 func isPodReady(kubeClient *kubernetes.Clientset, podName string) wait.ConditionFunc {
 	return func() (done bool, err error) {
 
@@ -162,6 +190,7 @@ func (ps *ParameterServer) createJobPod(task api.TrainTask) (*corev1.Pod, error)
 						"--jobId",
 						task.Job.JobId,
 					},
+					Env: jobEnv(),
 					Ports: []corev1.ContainerPort{
 						{
 							Name:          "http",
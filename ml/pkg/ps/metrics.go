@@ -4,6 +4,8 @@ import (
 	"github.com/diegostock12/kubeml/ml/pkg/api"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sort"
+	"sync"
 )
 
 type (
@@ -70,6 +72,30 @@ var (
 		labelsJob,
 	)
 
+	eta = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kubeml_job_eta_seconds",
+			Help: "Estimated remaining training time of a train job",
+		},
+		labelsJob,
+	)
+
+	redisMemory = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kubeml_job_redis_memory_bytes",
+			Help: "Memory used in redis by the tensors of a train job",
+		},
+		labelsJob,
+	)
+
+	measuredConcurrency = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kubeml_job_measured_concurrency",
+			Help: "Most train functions of the last epoch of a train job that ran at the same time",
+		},
+		labelsJob,
+	)
+
 	// Parameter server level metrics
 	tasksRunning = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -80,9 +106,137 @@ var (
 	)
 )
 
+// invocationDurations exports the histograms of the durations of the function
+// invocations of each job by task. The jobs send their histograms with the metric
+// updates, so they are exported as they are instead of observed by the ps
+var invocationDurations = &durationCollector{
+	desc: prometheus.NewDesc(
+		"kubeml_job_invocation_duration_seconds",
+		"Duration of the function invocations of a train job",
+		[]string{"jobid", "task"}, nil,
+	),
+	histograms: make(map[string]map[string]*api.DurationHistogram),
+}
+
+// durationCollector collects the last duration histograms sent by each job
+type durationCollector struct {
+	desc *prometheus.Desc
+
+	mu         sync.Mutex
+	histograms map[string]map[string]*api.DurationHistogram
+}
+
+func (c *durationCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *durationCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for jobId, tasks := range c.histograms {
+		for task, h := range tasks {
+			// prometheus takes the cumulative count of each bucket
+			buckets := make(map[float64]uint64, len(h.Bounds))
+			var cumulative uint64
+			for i, bound := range h.Bounds {
+				cumulative += h.Counts[i]
+				buckets[bound] = cumulative
+			}
+			ch <- prometheus.MustNewConstHistogram(c.desc, h.Count, h.Sum, buckets, jobId, task)
+		}
+	}
+}
+
+// set replaces the histograms of the job, keeping the last ones if none are sent
+func (c *durationCollector) set(jobId string, histograms map[string]*api.DurationHistogram) {
+	if len(histograms) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.histograms[jobId] = histograms
+}
+
+func (c *durationCollector) delete(jobId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.histograms, jobId)
+}
+
+// epochMetric is the value of a metric in an epoch of a job,
+// along with the sequence number of the update that set it
+type epochMetric struct {
+	value float64
+	seq   int64
+}
+
+// jobMetrics keeps the metrics of each epoch of a job, keyed by the epoch and the
+// metric. An update only sets the metrics of its epoch that were not set by a newer
+// update, so the updates that are retried or arrive out of order leave a single
+// value of each metric per epoch instead of being dropped or applied twice
+type jobMetrics struct {
+	epochs map[int]map[string]epochMetric
+	// latest is the newest update received, the one exported to prometheus
+	latest api.MetricUpdate
+}
+
+func newJobMetrics() *jobMetrics {
+	return &jobMetrics{epochs: make(map[int]map[string]epochMetric)}
+}
+
+// apply sets the metrics of the update in its epoch and returns
+// whether it is the newest update received from the job
+func (m *jobMetrics) apply(update api.MetricUpdate) bool {
+	metrics, exists := m.epochs[update.Epoch]
+	if !exists {
+		metrics = make(map[string]epochMetric)
+		m.epochs[update.Epoch] = metrics
+	}
+
+	values := map[string]float64{
+		api.MetricValidationLoss:      update.ValidationLoss,
+		api.MetricAccuracy:            update.Accuracy,
+		api.MetricTrainLoss:           update.TrainLoss,
+		api.MetricParallelism:         update.Parallelism,
+		api.MetricEpochDuration:       update.EpochDuration,
+		api.MetricMeasuredConcurrency: update.MeasuredConcurrency,
+	}
+	for metric, value := range values {
+		if current, set := metrics[metric]; set && current.seq >= update.Seq {
+			continue
+		}
+		metrics[metric] = epochMetric{value: value, seq: update.Seq}
+	}
+
+	if update.Seq <= m.latest.Seq {
+		return false
+	}
+	m.latest = update
+	return true
+}
+
+// series returns the values of the metric in each epoch of the job, in order
+func (m *jobMetrics) series(metric string) []float64 {
+	epochs := make([]int, 0, len(m.epochs))
+	for epoch := range m.epochs {
+		epochs = append(epochs, epoch)
+	}
+	sort.Ints(epochs)
+
+	var values []float64
+	for _, epoch := range epochs {
+		if v, set := m.epochs[epoch][metric]; set {
+			values = append(values, v.value)
+		}
+	}
+	return values
+}
+
 func init() {
 	tasksRunning.WithLabelValues("train").Set(0)
 	tasksRunning.WithLabelValues("inference").Set(0)
+	prometheus.MustRegister(invocationDurations)
 }
 
 // updateMetrics takes the history of the job and refreshes the
@@ -93,6 +247,10 @@ func updateMetrics(jobId string, metrics api.MetricUpdate) {
 	trainLoss.WithLabelValues(jobId).Set(metrics.TrainLoss)
 	epochDuration.WithLabelValues(jobId).Set(metrics.EpochDuration)
 	parallelism.WithLabelValues(jobId).Set(metrics.Parallelism)
+	eta.WithLabelValues(jobId).Set(metrics.ETA.Seconds)
+	redisMemory.WithLabelValues(jobId).Set(float64(metrics.RedisMemory))
+	measuredConcurrency.WithLabelValues(jobId).Set(metrics.MeasuredConcurrency)
+	invocationDurations.set(jobId, metrics.Durations)
 }
 
 // clearMetrics deletes the metrics associated with a jobId after
@@ -103,6 +261,10 @@ func clearMetrics(jobId string) {
 	trainLoss.DeleteLabelValues(jobId)
 	parallelism.DeleteLabelValues(jobId)
 	epochDuration.DeleteLabelValues(jobId)
+	eta.DeleteLabelValues(jobId)
+	redisMemory.DeleteLabelValues(jobId)
+	measuredConcurrency.DeleteLabelValues(jobId)
+	invocationDurations.delete(jobId)
 }
 
 // taskStarted updates the gauges for tasks in currently
@@ -2,7 +2,9 @@ package ps
 
 import (
 	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/deployment"
 	schedulerClient "github.com/diegostock12/kubeml/ml/pkg/scheduler/client"
+	"github.com/diegostock12/kubeml/ml/pkg/train"
 	jobClient "github.com/diegostock12/kubeml/ml/pkg/train/client"
 	"github.com/fission/fission/pkg/crd"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -10,6 +12,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"net/http"
 	"os"
+	"strconv"
 	"sync"
 )
 
@@ -34,9 +37,10 @@ type (
 		port int
 
 		// clients for other components
-		scheduler  *schedulerClient.Client
-		jobClient  *jobClient.Client
-		kubeClient *kubernetes.Clientset
+		scheduler     *schedulerClient.Client
+		jobClient     *jobClient.Client
+		kubeClient    *kubernetes.Clientset
+		fissionClient *crd.FissionClient
 
 		// jobIndex with all the train jobs
 		// when receiving a response from the scheduler the
@@ -45,12 +49,32 @@ type (
 		jobIndex map[string]*api.TrainTask
 		mu       sync.RWMutex
 
+		// metrics keeps the metrics of each epoch of the jobs
+		// received in their updates, see jobMetrics
+		metrics map[string]*jobMetrics
+
+		// jobLevels keeps the log levels of the jobs run as goroutines,
+		// the levels of standalone jobs are set through their api
+		jobLevels map[string]zap.AtomicLevel
+
+		// jobs keeps the jobs run as goroutines so they are paused and
+		// resumed directly, standalone jobs are paused through their api
+		jobs map[string]*train.TrainJob
+
 		// flag to choose deployment mode for jobs,
 		// false is goroutines and true is in a pod of their own
 		// TODO just for A/B testing, choose best one in future
 		deployStandaloneJobs bool
 
 		kubemlImageVersion string
+
+		// redisBudget is the default memory in bytes the tensors of
+		// a job can use in redis, 0 if unlimited
+		redisBudget int64
+
+		// historyFlushInterval is the time in seconds between the
+		// writes of the history of the jobs while they train
+		historyFlushInterval int
 	}
 )
 
@@ -77,17 +101,21 @@ func Start(logger *zap.Logger, port int, schedulerUrl string, standaloneJobs boo
 		logger:               logger.Named("ps"),
 		port:                 port,
 		jobIndex:             make(map[string]*api.TrainTask),
+		metrics:              make(map[string]*jobMetrics),
+		jobLevels:            make(map[string]zap.AtomicLevel),
+		jobs:                 make(map[string]*train.TrainJob),
 		deployStandaloneJobs: standaloneJobs,
 	}
 
 	// set the clients
 	ps.scheduler = schedulerClient.MakeClient(ps.logger, schedulerUrl)
 	ps.jobClient = jobClient.MakeClient(ps.logger)
-	_, kubeClient, _, err := crd.GetKubernetesClient()
+	fissionClient, kubeClient, _, err := crd.MakeFissionClient()
 	if err != nil {
 		logger.Fatal("Unable to create kubernetes client", zap.Error(err))
 	}
 	ps.kubeClient = kubeClient
+	ps.fissionClient = fissionClient
 	ps.logger.Info("Started new parameter server")
 
 	version := os.Getenv("KUBEML_VERSION")
@@ -98,7 +126,31 @@ func Start(logger *zap.Logger, port int, schedulerUrl string, standaloneJobs boo
 	}
 	ps.logger.Debug("Set version", zap.String("v", ps.kubemlImageVersion))
 
+	if budget := os.Getenv("JOB_REDIS_BUDGET_MB"); len(budget) > 0 {
+		mb, err := strconv.ParseInt(budget, 10, 64)
+		if err != nil {
+			logger.Fatal("Invalid JOB_REDIS_BUDGET_MB", zap.Error(err))
+		}
+		ps.redisBudget = mb << 20
+	}
+	ps.logger.Debug("Set default redis budget", zap.Int64("bytes", ps.redisBudget))
+
+	ps.historyFlushInterval = api.DefaultHistoryFlushInterval
+	if interval := os.Getenv("HISTORY_FLUSH_INTERVAL"); len(interval) > 0 {
+		ps.historyFlushInterval, err = strconv.Atoi(interval)
+		if err != nil {
+			logger.Fatal("Invalid HISTORY_FLUSH_INTERVAL", zap.Error(err))
+		}
+	}
+	ps.logger.Debug("Set history flush interval", zap.Int("seconds", ps.historyFlushInterval))
+
 	go serveMetrics(ps.logger)
+	deployment.Report(ps.logger, "ps")
+
+	// the standalone jobs that could not report their finish leave a marker
+	if ps.deployStandaloneJobs {
+		go ps.reconcileFinishes()
+	}
 
 	// Start the API to receive requests
 	ps.Serve(port)
@@ -0,0 +1,78 @@
+package ps
+
+import (
+	"context"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"time"
+)
+
+// errJobNotFound is returned when finishing a job the parameter server does not track
+var errJobNotFound = errors.New("job not found in index")
+
+// reconcileFinishes finishes the standalone jobs that exited without reporting their
+// finish, e.g. because the parameter server was unreachable, from the markers they
+// left in the database. It runs every FinishReconcileInterval until the process exits
+func (ps *ParameterServer) reconcileFinishes() {
+	for {
+		time.Sleep(api.FinishReconcileInterval)
+		if err := ps.finishUnreported(); err != nil {
+			ps.logger.Warn("Could not reconcile unreported job finishes", zap.Error(err))
+		}
+	}
+}
+
+// finishUnreported finishes the jobs with a marker and deletes the markers. The markers
+// of the jobs the parameter server does not track are also deleted, since those jobs
+// were already finished or started by a previous parameter server
+func (ps *ParameterServer) finishUnreported() error {
+	client, err := mongo.NewClient(options.Client().ApplyURI(mongoURI()))
+	if err != nil {
+		return errors.Wrap(err, "could not create mongo client")
+	}
+	if err = client.Connect(context.TODO()); err != nil {
+		return errors.Wrap(err, "could not connect to mongo")
+	}
+	defer client.Disconnect(context.TODO())
+
+	collection := client.Database(util.MongoDatabase()).Collection(api.UnreportedFinishesCollection)
+	cursor, err := collection.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return errors.Wrap(err, "could not find unreported finishes")
+	}
+	var markers []api.UnreportedFinish
+	if err = cursor.All(context.TODO(), &markers); err != nil {
+		return errors.Wrap(err, "could not decode unreported finishes")
+	}
+
+	for _, marker := range markers {
+		err = ps.finishJob(marker.JobId, marker.Error)
+		if err == errJobNotFound {
+			ps.logger.Warn("Discarding unreported finish of untracked job",
+				zap.String("jobId", marker.JobId))
+		} else {
+			ps.logger.Info("Finished job that exited unreported",
+				zap.String("jobId", marker.JobId),
+				zap.Time("finished", marker.Finished))
+		}
+
+		if _, err = collection.DeleteOne(context.TODO(), bson.M{"_id": marker.JobId}); err != nil {
+			return errors.Wrapf(err, "could not delete unreported finish of job %s", marker.JobId)
+		}
+	}
+	return nil
+}
+
+// mongoURI returns the address of the database with the markers
+func mongoURI() string {
+	if util.IsDebugEnv() {
+		return api.MongoUrlDebug
+	}
+	return fmt.Sprintf("mongodb://%s:%d", api.MongoUrl, api.MongoPort)
+}
@@ -0,0 +1,182 @@
+package ps
+
+import (
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"strings"
+)
+
+const (
+	// FunctionNamespace is the namespace where the fission functions are deployed
+	FunctionNamespace = metav1.NamespaceDefault
+
+	scratchVolume = "scratch"
+)
+
+// scratchFunctionName returns the name of the per-job copy of a function
+func scratchFunctionName(task *api.TrainTask) string {
+	return fmt.Sprintf("%s-%s", task.Parameters.FunctionName, task.Job.JobId)
+}
+
+// scratchPodSpec returns the pod spec merged by fission with the pods of an environment,
+// it mounts an emptyDir volume of the requested size in the function container
+func scratchPodSpec(container string, sizeGB int) *corev1.PodSpec {
+	size := resource.NewQuantity(int64(sizeGB)<<30, resource.BinarySI)
+
+	return &corev1.PodSpec{
+		Volumes: []corev1.Volume{
+			{
+				Name: scratchVolume,
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{
+						SizeLimit: size,
+					},
+				},
+			},
+		},
+		Containers: []corev1.Container{
+			{
+				Name: container,
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      scratchVolume,
+						MountPath: api.ScratchMountPath,
+					},
+				},
+			},
+		},
+	}
+}
+
+// createScratchFunction creates a copy of the function used by the task whose pods mount
+// a scratch volume of the size requested. Since fission only allows customizing the pods at
+// the environment level, the environment of the function is also copied.
+//
+// The copy is exposed through an http trigger with its own name, which is
+// then used by the job to invoke the functions
+func (ps *ParameterServer) createScratchFunction(task *api.TrainTask) (string, error) {
+	name := scratchFunctionName(task)
+
+	fn, err := ps.fissionClient.CoreV1().Functions(FunctionNamespace).Get(task.Parameters.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrap(err, "could not get function")
+	}
+
+	env, err := ps.fissionClient.CoreV1().Environments(fn.Spec.Environment.Namespace).Get(fn.Spec.Environment.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrap(err, "could not get function environment")
+	}
+
+	// the runtime container of the environment pods is named after the environment
+	envCopy := &fv1.Environment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: FunctionNamespace,
+			Labels:    map[string]string{"job": task.Job.JobId},
+		},
+		Spec: *env.Spec.DeepCopy(),
+	}
+	envCopy.Spec.Runtime.PodSpec = scratchPodSpec(name, task.Parameters.ScratchGB)
+
+	_, err = ps.fissionClient.CoreV1().Environments(FunctionNamespace).Create(envCopy)
+	if err != nil {
+		return "", errors.Wrap(err, "could not create environment with scratch volume")
+	}
+
+	fnCopy := &fv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: FunctionNamespace,
+			Labels:    map[string]string{"job": task.Job.JobId},
+		},
+		Spec: *fn.Spec.DeepCopy(),
+	}
+	fnCopy.Spec.Environment = fv1.EnvironmentReference{
+		Namespace: FunctionNamespace,
+		Name:      name,
+	}
+
+	_, err = ps.fissionClient.CoreV1().Functions(FunctionNamespace).Create(fnCopy)
+	if err != nil {
+		return "", multierror.Append(errors.Wrap(err, "could not create function copy"),
+			ps.deleteScratchFunction(task)).ErrorOrNil()
+	}
+
+	trigger := &fv1.HTTPTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v-%v", name, strings.ToLower(http.MethodGet)),
+			Namespace: FunctionNamespace,
+			Labels:    map[string]string{"job": task.Job.JobId},
+		},
+		Spec: fv1.HTTPTriggerSpec{
+			RelativeURL: "/" + name,
+			Method:      http.MethodGet,
+			FunctionReference: fv1.FunctionReference{
+				Type: fv1.FunctionReferenceTypeFunctionName,
+				Name: name,
+			},
+		},
+	}
+
+	_, err = ps.fissionClient.CoreV1().HTTPTriggers(FunctionNamespace).Create(trigger)
+	if err != nil {
+		return "", multierror.Append(errors.Wrap(err, "could not create function trigger"),
+			ps.deleteScratchFunction(task)).ErrorOrNil()
+	}
+
+	ps.logger.Debug("Created function with scratch volume",
+		zap.String("function", name),
+		zap.Int("sizeGB", task.Parameters.ScratchGB))
+
+	return name, nil
+}
+
+// deleteScratchFunction deletes the function copy, its environment and trigger
+func (ps *ParameterServer) deleteScratchFunction(task *api.TrainTask) error {
+	name := scratchFunctionName(task)
+	var result *multierror.Error
+
+	triggerName := fmt.Sprintf("%v-%v", name, strings.ToLower(http.MethodGet))
+	err := ps.fissionClient.CoreV1().HTTPTriggers(FunctionNamespace).Delete(triggerName, &metav1.DeleteOptions{})
+	result = multierror.Append(result, ignoreNotFound(err))
+
+	err = ps.fissionClient.CoreV1().Functions(FunctionNamespace).Delete(name, &metav1.DeleteOptions{})
+	result = multierror.Append(result, ignoreNotFound(err))
+
+	err = ps.fissionClient.CoreV1().Environments(FunctionNamespace).Delete(name, &metav1.DeleteOptions{})
+	result = multierror.Append(result, ignoreNotFound(err))
+
+	return result.ErrorOrNil()
+}
+
+// ignoreNotFound returns nil if the resource to delete did not exist
+func ignoreNotFound(err error) error {
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// cleanScratchFunction deletes the function copy of a task if it was created,
+// errors are only logged since the job is already done
+func (ps *ParameterServer) cleanScratchFunction(task *api.TrainTask) {
+	if task.Parameters.ScratchGB <= 0 {
+		return
+	}
+
+	err := ps.deleteScratchFunction(task)
+	if err != nil {
+		ps.logger.Error("error deleting function with scratch volume",
+			zap.String("jobId", task.Job.JobId),
+			zap.Error(err))
+	}
+}
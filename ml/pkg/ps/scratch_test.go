@@ -0,0 +1,156 @@
+package ps
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/apis/genclient/clientset/versioned/fake"
+	"github.com/fission/fission/pkg/crd"
+	"go.uber.org/zap"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"testing"
+)
+
+// newScratchTestServer returns a parameter server whose fission client holds
+// the function of the scratch tasks, its environment and the objects given
+func newScratchTestServer(objects ...runtime.Object) *ParameterServer {
+	objects = append(objects,
+		&fv1.Function{
+			ObjectMeta: metav1.ObjectMeta{Name: "network", Namespace: FunctionNamespace},
+			Spec: fv1.FunctionSpec{
+				Environment: fv1.EnvironmentReference{Namespace: "fission", Name: "torch"},
+			},
+		},
+		&fv1.Environment{
+			ObjectMeta: metav1.ObjectMeta{Name: "torch", Namespace: "fission"},
+			Spec:       fv1.EnvironmentSpec{Runtime: fv1.Runtime{Image: "kubeml/torch"}},
+		},
+	)
+	return &ParameterServer{
+		logger:        zap.NewNop(),
+		fissionClient: &crd.FissionClient{Interface: fake.NewSimpleClientset(objects...)},
+	}
+}
+
+func scratchTask(sizeGB int) *api.TrainTask {
+	return &api.TrainTask{
+		Parameters: api.TrainRequest{FunctionName: "network", ScratchGB: sizeGB},
+		Job:        api.JobInfo{JobId: "job"},
+	}
+}
+
+// checkScratchDeleted fails if the copy of the function, its environment or its trigger exist
+func checkScratchDeleted(t *testing.T, ps *ParameterServer) {
+	t.Helper()
+	core := ps.fissionClient.CoreV1()
+	if _, err := core.Environments(FunctionNamespace).Get("network-job", metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("got error %v getting the environment copy, want it deleted", err)
+	}
+	if _, err := core.Functions(FunctionNamespace).Get("network-job", metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("got error %v getting the function copy, want it deleted", err)
+	}
+	if _, err := core.HTTPTriggers(FunctionNamespace).Get("network-job-get", metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("got error %v getting the trigger, want it deleted", err)
+	}
+}
+
+func TestCreateScratchFunction(t *testing.T) {
+	ps := newScratchTestServer()
+	task := scratchTask(2)
+
+	name, err := ps.createScratchFunction(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "network-job" {
+		t.Errorf("got function %s, want network-job", name)
+	}
+
+	// the pods of the environment copy mount the emptyDir in the function container
+	core := ps.fissionClient.CoreV1()
+	env, err := core.Environments(FunctionNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Spec.Runtime.Image != "kubeml/torch" {
+		t.Errorf("got image %s, want the one of the environment", env.Spec.Runtime.Image)
+	}
+	spec := env.Spec.Runtime.PodSpec
+	if spec == nil || len(spec.Volumes) != 1 || len(spec.Containers) != 1 {
+		t.Fatalf("got pod spec %+v, want one volume and one container", spec)
+	}
+	volume := spec.Volumes[0]
+	if volume.EmptyDir == nil || volume.EmptyDir.SizeLimit.Cmp(resource.MustParse("2Gi")) != 0 {
+		t.Errorf("got volume %+v, want an emptyDir of 2Gi", volume)
+	}
+	container := spec.Containers[0]
+	if container.Name != name || len(container.VolumeMounts) != 1 ||
+		container.VolumeMounts[0].Name != volume.Name || container.VolumeMounts[0].MountPath != api.ScratchMountPath {
+		t.Errorf("got container %+v, want %s mounting the volume in %s", container, name, api.ScratchMountPath)
+	}
+
+	// the function copy runs in the environment copy behind its own trigger
+	fn, err := core.Functions(FunctionNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fn.Spec.Environment.Namespace != FunctionNamespace || fn.Spec.Environment.Name != name {
+		t.Errorf("got environment %+v, want the copy", fn.Spec.Environment)
+	}
+	trigger, err := core.HTTPTriggers(FunctionNamespace).Get("network-job-get", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trigger.Spec.RelativeURL != "/network-job" || trigger.Spec.FunctionReference.Name != name {
+		t.Errorf("got trigger %+v, want it to route /network-job to the copy", trigger.Spec)
+	}
+	for _, labels := range []map[string]string{env.Labels, fn.Labels, trigger.Labels} {
+		if labels["job"] != "job" {
+			t.Errorf("got labels %v, want the job", labels)
+		}
+	}
+
+	// the copies are deleted when the job is done
+	ps.cleanScratchFunction(task)
+	checkScratchDeleted(t, ps)
+}
+
+func TestCreateScratchFunctionCleansUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing runtime.Object
+	}{
+		{
+			name:     "function copy fails",
+			existing: &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "network-job", Namespace: FunctionNamespace}},
+		},
+		{
+			name:     "trigger fails",
+			existing: &fv1.HTTPTrigger{ObjectMeta: metav1.ObjectMeta{Name: "network-job-get", Namespace: FunctionNamespace}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newScratchTestServer(tt.existing)
+			if _, err := ps.createScratchFunction(scratchTask(1)); err == nil {
+				t.Fatal("got no error, want the copy to fail")
+			}
+
+			// nothing created for the job is left behind
+			checkScratchDeleted(t, ps)
+		})
+	}
+}
+
+func TestCreateScratchFunctionMissingFunction(t *testing.T) {
+	ps := newScratchTestServer()
+	task := scratchTask(1)
+	task.Parameters.FunctionName = "missing"
+
+	if _, err := ps.createScratchFunction(task); err == nil {
+		t.Fatal("got no error, want the function not found")
+	}
+}
@@ -7,11 +7,14 @@ import (
 	"github.com/diegostock12/kubeml/ml/pkg/util"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/diegostock12/kubeml/ml/pkg/api"
 )
@@ -20,12 +23,7 @@ import (
 // TODO make this more elegant by not having to add all the parameters
 func buildFunctionURL(funcId, numFunc int, task, funcName, psId string) string {
 
-	var routerAddr string
-	if util.IsDebugEnv() {
-		routerAddr = api.FissionRouterUrlDebug
-	} else {
-		routerAddr = api.FissionRouterUrl
-	}
+	routerAddr := util.RouterUrl()
 
 	values := url.Values{}
 	values.Set("task", task)
@@ -64,6 +62,18 @@ func (s *Scheduler) newParallelism(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// a job over its rate limit has to send the update again after
+	// the time in Retry-After, the client keeps only its latest update
+	if allowed, wait := s.limiter.allow(task.Job.JobId, time.Now()); !allowed {
+		throttledUpdates.Inc()
+		s.logger.Debug("Throttled job update",
+			zap.String("jobId", task.Job.JobId),
+			zap.Duration("retryAfter", wait))
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Too many updates for the job", http.StatusTooManyRequests)
+		return
+	}
+
 	s.logger.Debug("Received request for new parallelism",
 		zap.Any("task", task))
 
@@ -94,7 +104,7 @@ func (s *Scheduler) train(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create the jobId and push to queue
-	id := createJobId()
+	id := requestJobId(&req)
 
 	// TODO now add it directly to the task queue
 	task := api.TrainTask{
@@ -127,7 +137,7 @@ func (s *Scheduler) infer(w http.ResponseWriter, r *http.Request) {
 
 	var req api.InferRequest
 	// read the train request
-	err = json.Unmarshal(body, &req)
+	err = util.Decode(r.Header.Get("Content-Type"), body, &req)
 	if err != nil {
 		s.logger.Error("Failed to parse the train request",
 			zap.Error(err),
@@ -140,7 +150,13 @@ func (s *Scheduler) infer(w http.ResponseWriter, r *http.Request) {
 	url := buildFunctionURL(0, 1, "infer", "network", req.ModelId)
 	s.logger.Debug("Build inference url", zap.String("url", url))
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+	resp, err := invokeInferFunction(url, &req, req.Serialization)
+	if err == nil && resp.StatusCode == http.StatusUnsupportedMediaType && req.Serialization == api.SerializationMsgpack {
+		// the function does not support msgpack, fall back to JSON
+		s.logger.Debug("Function does not support msgpack, falling back to json")
+		resp.Body.Close()
+		resp, err = invokeInferFunction(url, &req, api.SerializationJSON)
+	}
 	if err != nil {
 		s.logger.Error("Could not receive function response", zap.Error(err))
 		http.Error(w, "Failed to receive function response", http.StatusInternalServerError)
@@ -155,12 +171,34 @@ func (s *Scheduler) infer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.logger.Debug("got response", zap.String("predictions", string(preds)))
+	s.logger.Debug("got response", zap.Int("size", len(preds)))
+	contentType := resp.Header.Get("Content-Type")
+	if len(contentType) == 0 {
+		contentType = util.ContentTypeJSON
+	}
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
 	w.Write(preds)
 }
 
+// invokeInferFunction sends the inference request to the function
+// encoded in the given serialization format
+func invokeInferFunction(url string, req *api.InferRequest, serialization string) (*http.Response, error) {
+	body, contentType, err := util.Encode(serialization, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode request")
+	}
+
+	funcReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create request")
+	}
+	funcReq.Header.Set("Content-Type", contentType)
+	funcReq.Header.Set("Accept", contentType)
+
+	return http.DefaultClient.Do(funcReq)
+}
+
 // taskFinished simply deletes the entry from the scheduler index
 func (s *Scheduler) taskFinished(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -170,11 +208,36 @@ func (s *Scheduler) taskFinished(w http.ResponseWriter, r *http.Request) {
 		zap.String("task", taskId))
 
 	s.policy.taskFinished(taskId)
+	s.trace.finish(taskId)
+	s.limiter.forget(taskId)
 
 	w.WriteHeader(http.StatusOK)
 	return
 }
 
+// getTrace returns the trace of the scheduling decisions of a job
+func (s *Scheduler) getTrace(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	trace, exists := s.trace.get(jobId)
+	if !exists {
+		http.Error(w, "No scheduler trace found for the job", http.StatusNotFound)
+		return
+	}
+
+	resp, err := json.Marshal(trace)
+	if err != nil {
+		s.logger.Error("error marshalling trace", zap.Error(err))
+		http.Error(w, "error sending trace", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
 // Handle heartbeats from Kubernetes
 func (s *Scheduler) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
@@ -188,6 +251,8 @@ func (s *Scheduler) GetHandler() http.Handler {
 	r.HandleFunc("/infer", s.infer).Methods("POST")
 	r.HandleFunc("/health", s.handleHealth).Methods("GET")
 	r.HandleFunc("/finish/{taskId}", s.taskFinished).Methods("DELETE")
+	r.HandleFunc("/trace/{jobId}", s.getTrace).Methods("GET")
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
 	return r
 }
 
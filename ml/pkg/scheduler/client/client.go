@@ -4,13 +4,26 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// defaultRetryAfter is the wait before sending a throttled
+// update again if the scheduler does not set Retry-After
+const defaultRetryAfter = time.Second
+
+// ErrRedundantUpdate is returned by UpdateJob for the updates that do not change any
+// of the fields read by the scheduler policy since the last update of the job, which
+// are not sent and so get no answer from the scheduler
+var ErrRedundantUpdate = errors.New("update does not change the fields read by the scheduler")
+
 type (
 
 	// Client gives access
@@ -18,6 +31,33 @@ type (
 		logger       *zap.Logger
 		schedulerUrl string
 		httpClient   *http.Client
+
+		// updates keeps the updates sent by each job
+		mu      sync.Mutex
+		updates map[string]*jobUpdates
+	}
+
+	// jobUpdates holds the policy fields of the last update the scheduler
+	// accepted from a job, and the update waiting to be sent again after
+	// the scheduler throttled it, which is replaced by the later updates
+	jobUpdates struct {
+		last      *policyFields
+		pending   *pendingUpdate
+		throttled int
+		coalesced int
+	}
+
+	pendingUpdate struct {
+		body   []byte
+		fields policyFields
+	}
+
+	// policyFields are the fields of the task read by the scheduler policy
+	policyFields struct {
+		parallelism        int
+		windowTime         float64
+		static             bool
+		defaultParallelism int
 	}
 )
 
@@ -26,33 +66,158 @@ func MakeClient(logger *zap.Logger, schedulerUrl string) *Client {
 	return &Client{
 		logger:       logger.Named("scheduler-client"),
 		schedulerUrl: strings.TrimSuffix(schedulerUrl, "/"),
-		httpClient:   &http.Client{},
+		httpClient:   &http.Client{Timeout: api.RequestTimeout},
+		updates:      make(map[string]*jobUpdates),
 	}
 }
 
 // UpdateJob sends a request to the scheduler to determine the new level
 // of parallelism that should be given to a job based on metrics and
-// previous epochs
+// previous epochs.
+//
+// If the scheduler throttles the job, the update is sent again after the time
+// it asks for and nil is returned, so the job waits for the decision as usual.
+// The updates sent in the meantime replace the waiting one instead of being
+// sent. ErrRedundantUpdate is returned without sending the update if the fields
+// read by the policy did not change since the last update the scheduler accepted
 func (c *Client) UpdateJob(task *api.TrainTask) error {
-	url := c.schedulerUrl + "/job"
+	jobId := task.Job.JobId
+	fields := policyFields{
+		parallelism:        task.Job.State.Parallelism,
+		windowTime:         task.Job.State.WindowTime(),
+		static:             task.Parameters.Options.StaticParallelism,
+		defaultParallelism: task.Parameters.Options.DefaultParallelism,
+	}
 
 	body, err := json.Marshal(task)
 	if err != nil {
 		return errors.Wrap(err, "could not marshal request to update job")
 	}
 
-	_, err = c.httpClient.Post(url, "application/json", bytes.NewBuffer(body))
+	c.mu.Lock()
+	u := c.jobUpdates(jobId)
+	if u.pending != nil {
+		u.pending = &pendingUpdate{body: body, fields: fields}
+		u.coalesced++
+		c.mu.Unlock()
+		c.logger.Debug("Coalesced job update into the throttled one",
+			zap.String("jobId", jobId))
+		return nil
+	}
+	if u.last != nil && *u.last == fields {
+		c.mu.Unlock()
+		c.logger.Debug("Skipping redundant job update",
+			zap.String("jobId", jobId),
+			zap.Int("parallelism", fields.parallelism))
+		return ErrRedundantUpdate
+	}
+	c.mu.Unlock()
+
+	return c.sendUpdate(jobId, &pendingUpdate{body: body, fields: fields})
+}
+
+// sendUpdate posts the update to the scheduler, keeping it to be sent
+// again after the time in Retry-After if the scheduler throttles the job
+func (c *Client) sendUpdate(jobId string, update *pendingUpdate) error {
+	url := c.schedulerUrl + "/job"
+
+	resp, err := c.httpClient.Post(url, "application/json", bytes.NewBuffer(update.body))
 	if err != nil {
 		return errors.Wrap(err, "could not send request to scheduler")
 	}
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		resp.Body.Close()
+		wait := retryAfter(resp)
+
+		c.mu.Lock()
+		u := c.jobUpdates(jobId)
+		u.throttled++
+		if u.pending == nil {
+			u.pending = update
+		}
+		c.mu.Unlock()
+
+		c.logger.Debug("Job update throttled by the scheduler, sending it again later",
+			zap.String("jobId", jobId),
+			zap.Duration("retryAfter", wait))
+		time.AfterFunc(wait, func() { c.flushUpdate(jobId) })
+		return nil
+	}
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return errors.Wrap(err, "scheduler rejected the update")
+	}
+	resp.Body.Close()
+
+	c.mu.Lock()
+	c.jobUpdates(jobId).last = &update.fields
+	c.mu.Unlock()
 	return nil
+}
+
+// flushUpdate sends the latest update of a job that was waiting after
+// being throttled. If the job finished in the meantime nothing is sent
+func (c *Client) flushUpdate(jobId string) {
+	c.mu.Lock()
+	u, exists := c.updates[jobId]
+	if !exists || u.pending == nil {
+		c.mu.Unlock()
+		return
+	}
+	update := u.pending
+	u.pending = nil
+	c.mu.Unlock()
+
+	if err := c.sendUpdate(jobId, update); err != nil {
+		c.logger.Error("Could not send throttled job update",
+			zap.String("jobId", jobId),
+			zap.Error(err))
+	}
+}
+
+// jobUpdates returns the updates of the job, the client must be locked
+func (c *Client) jobUpdates(jobId string) *jobUpdates {
+	u, exists := c.updates[jobId]
+	if !exists {
+		u = &jobUpdates{}
+		c.updates[jobId] = u
+	}
+	return u
+}
+
+// UpdateStats returns the number of updates of the job throttled by
+// the scheduler and coalesced into a throttled one by the client
+func (c *Client) UpdateStats(jobId string) (throttled, coalesced int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if u, exists := c.updates[jobId]; exists {
+		return u.throttled, u.coalesced
+	}
+	return 0, 0
+}
+
+// CancelUpdates drops the update of the job waiting after being throttled,
+// so it is not sent once the job finished, and forgets its last update
+func (c *Client) CancelUpdates(jobId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.updates, jobId)
+}
 
+// retryAfter returns the wait set by the scheduler in Retry-After in seconds
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return defaultRetryAfter
+	}
+	return time.Duration(seconds) * time.Second
 }
 
 // FinishJob makes the scheduler delete the job entry from the cache
 func (c *Client) FinishJob(jobId string) error {
 	url := c.schedulerUrl + "/finish/" + jobId
+	c.CancelUpdates(jobId)
 
 	req, err := http.NewRequest(http.MethodDelete, url, nil)
 	if err != nil {
@@ -66,6 +231,29 @@ func (c *Client) FinishJob(jobId string) error {
 	return nil
 }
 
+// GetTrace returns the trace of the scheduling decisions of a job
+// as returned by the scheduler
+func (c *Client) GetTrace(jobId string) ([]byte, error) {
+	url := c.schedulerUrl + "/trace/" + jobId
+
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "error performing request")
+	}
+
+	if err = kerror.CheckHttpResponse(resp); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading response body")
+	}
+
+	return body, nil
+}
+
 // SubmitTrainTask submits a training task to the scheduler
 func (c *Client) SubmitTrainTask(req api.TrainRequest) (string, error) {
 	url := c.schedulerUrl + "/train"
@@ -81,24 +269,25 @@ func (c *Client) SubmitTrainTask(req api.TrainRequest) (string, error) {
 	return id, err
 }
 
-// SubmitInferenceTask submits an inference task to the scheduler
-// and returns the response from the inference task as a byte array
-func (c *Client) SubmitInferenceTask(req []byte) ([]byte, error) {
+// SubmitInferenceTask submits an inference task to the scheduler encoded with the
+// given content type and returns the response from the inference task as a byte
+// array along with its content type
+func (c *Client) SubmitInferenceTask(req []byte, contentType string) ([]byte, string, error) {
 	url := c.schedulerUrl + "/infer"
 
 	// Send the request and return the id
-	resp, err := c.httpClient.Post(url, "application/json", bytes.NewBuffer(req))
+	resp, err := c.httpClient.Post(url, contentType, bytes.NewBuffer(req))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read response body")
+		return nil, "", errors.Wrap(err, "could not read response body")
 	}
 
-	return body, nil
+	return body, resp.Header.Get("Content-Type"), nil
 }
 
 // sendTask submits the request to the scheduler
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeScheduler records the parallelism of the job updates it receives
+// and throttles the first ones
+type fakeScheduler struct {
+	mu       sync.Mutex
+	throttle int
+	status   int
+	received []int
+}
+
+func (s *fakeScheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var task api.TrainTask
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = append(s.received, task.Job.State.Parallelism)
+	if len(s.received) <= s.throttle {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many updates for the job", http.StatusTooManyRequests)
+		return
+	}
+	if s.status != 0 {
+		http.Error(w, "scheduler failed", s.status)
+	}
+}
+
+func (s *fakeScheduler) updates() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.received...)
+}
+
+// waitUpdates waits until the scheduler received n updates
+func (s *fakeScheduler) waitUpdates(t *testing.T, n int) []int {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if updates := s.updates(); len(updates) >= n {
+			return updates
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("got updates %v, want %d", s.updates(), n)
+	return nil
+}
+
+func newTestClient(t *testing.T, scheduler *fakeScheduler) *Client {
+	server := httptest.NewServer(scheduler)
+	t.Cleanup(server.Close)
+	return MakeClient(zap.NewNop(), server.URL)
+}
+
+func update(parallelism int) *api.TrainTask {
+	return &api.TrainTask{Job: api.JobInfo{JobId: "job", State: api.JobState{Parallelism: parallelism}}}
+}
+
+func TestUpdateJobCoalescesThrottledUpdates(t *testing.T) {
+	scheduler := &fakeScheduler{throttle: 1}
+	c := newTestClient(t, scheduler)
+
+	// the throttled update does not fail the epoch
+	if err := c.UpdateJob(update(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the job floods the client while its update waits
+	const updates = 50
+	var wg sync.WaitGroup
+	for i := 2; i < updates+2; i++ {
+		wg.Add(1)
+		go func(parallelism int) {
+			defer wg.Done()
+			if err := c.UpdateJob(update(parallelism)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := scheduler.updates(); len(got) != 1 {
+		t.Fatalf("got updates %v sent before Retry-After, want only the throttled one", got)
+	}
+
+	// only one of the coalesced updates is sent after Retry-After
+	got := scheduler.waitUpdates(t, 2)
+	time.Sleep(100 * time.Millisecond)
+	if got = scheduler.updates(); len(got) != 2 || got[1] < 2 {
+		t.Errorf("got updates %v, want the throttled one and one of the coalesced", got)
+	}
+	if throttled, coalesced := c.UpdateStats("job"); throttled != 1 || coalesced != updates {
+		t.Errorf("got %d throttled and %d coalesced updates, want 1 and %d", throttled, coalesced, updates)
+	}
+
+	// the update accepted is the last one, so sending it again is redundant
+	if err := c.UpdateJob(update(got[1])); err != ErrRedundantUpdate {
+		t.Errorf("got error %v, want %v", err, ErrRedundantUpdate)
+	}
+	if err := c.UpdateJob(update(got[1] + 1)); err != nil {
+		t.Fatal(err)
+	}
+	if got := scheduler.updates(); len(got) != 3 {
+		t.Errorf("got updates %v, want the changed update sent", got)
+	}
+}
+
+func TestUpdateJobCancelled(t *testing.T) {
+	scheduler := &fakeScheduler{throttle: 1}
+	c := newTestClient(t, scheduler)
+
+	if err := c.UpdateJob(update(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateJob(update(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the waiting update of a finished job is never sent
+	c.CancelUpdates("job")
+	time.Sleep(1500 * time.Millisecond)
+	if got := scheduler.updates(); len(got) != 1 {
+		t.Errorf("got updates %v, want only the throttled one", got)
+	}
+	if throttled, coalesced := c.UpdateStats("job"); throttled != 0 || coalesced != 0 {
+		t.Errorf("got %d throttled and %d coalesced updates after cancelling, want none", throttled, coalesced)
+	}
+}
+
+func TestUpdateJobRejected(t *testing.T) {
+	c := newTestClient(t, &fakeScheduler{status: http.StatusInternalServerError})
+
+	if err := c.UpdateJob(update(1)); err == nil {
+		t.Fatal("got no error, want the rejected update to fail")
+	}
+
+	// a rejected update is not taken as the last one of the job
+	if err := c.UpdateJob(update(1)); err == ErrRedundantUpdate {
+		t.Error("got the update after a rejected one skipped as redundant")
+	}
+}
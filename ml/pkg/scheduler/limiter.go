@@ -0,0 +1,108 @@
+package scheduler
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaults of the rate limit of the job updates, a job can send
+	// defaultUpdateBurst updates at once and then one every interval
+	defaultUpdateInterval = time.Second
+	defaultUpdateBurst    = 3
+)
+
+type (
+	// updateLimiter limits the updates each job sends to the scheduler with
+	// a token bucket per job, so a misbehaving job can not flood the queue.
+	// A bucket holds up to burst tokens and gets one every interval
+	updateLimiter struct {
+		mu       sync.Mutex
+		interval time.Duration
+		burst    int
+		buckets  map[string]*tokenBucket
+	}
+
+	// tokenBucket holds the tokens of a job as
+	// of the last time they were refilled
+	tokenBucket struct {
+		tokens float64
+		last   time.Time
+	}
+)
+
+// makeUpdateLimiter reads the rate limit of the job updates from
+// SCHEDULER_UPDATE_INTERVAL and SCHEDULER_UPDATE_BURST. An interval
+// of 0 disables the limit
+func makeUpdateLimiter() (*updateLimiter, error) {
+	interval := defaultUpdateInterval
+	if s := os.Getenv("SCHEDULER_UPDATE_INTERVAL"); len(s) > 0 {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid SCHEDULER_UPDATE_INTERVAL")
+		}
+		interval = d
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("SCHEDULER_UPDATE_INTERVAL should not be negative, got %v", interval)
+	}
+
+	burst := defaultUpdateBurst
+	if s := os.Getenv("SCHEDULER_UPDATE_BURST"); len(s) > 0 {
+		b, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid SCHEDULER_UPDATE_BURST")
+		}
+		burst = b
+	}
+	if burst <= 0 {
+		return nil, fmt.Errorf("SCHEDULER_UPDATE_BURST should be positive, got %v", burst)
+	}
+
+	return &updateLimiter{
+		interval: interval,
+		burst:    burst,
+		buckets:  make(map[string]*tokenBucket),
+	}, nil
+}
+
+// allow takes a token from the bucket of the job if it has one. Otherwise
+// it returns false and the time until the bucket gets the next token
+func (l *updateLimiter) allow(jobId string, now time.Time) (bool, time.Duration) {
+	if l.interval == 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, exists := l.buckets[jobId]
+	if !exists {
+		b = &tokenBucket{tokens: float64(l.burst), last: now}
+		l.buckets[jobId] = b
+	}
+
+	// refill the tokens gained since the last update
+	b.tokens += float64(now.Sub(b.last)) / float64(l.interval)
+	if b.tokens > float64(l.burst) {
+		b.tokens = float64(l.burst)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) * float64(l.interval))
+}
+
+// forget deletes the bucket of a finished job
+func (l *updateLimiter) forget(jobId string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, jobId)
+}
@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(interval time.Duration, burst int) *updateLimiter {
+	return &updateLimiter{interval: interval, burst: burst, buckets: make(map[string]*tokenBucket)}
+}
+
+func TestUpdateLimiter(t *testing.T) {
+	l := newTestLimiter(time.Second, 3)
+	start := time.Now()
+
+	tests := []struct {
+		name    string
+		jobId   string
+		at      time.Duration
+		allowed bool
+		wait    time.Duration
+	}{
+		{"burst", "job", 0, true, 0},
+		{"burst", "job", 0, true, 0},
+		{"burst", "job", 0, true, 0},
+		{"over the burst", "job", 0, false, time.Second},
+		{"half a token refilled", "job", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		{"token refilled", "job", time.Second, true, 0},
+		{"other job", "other", time.Second, true, 0},
+		{"refilled up to the burst", "job", time.Hour, true, 0},
+		{"refilled up to the burst", "job", time.Hour, true, 0},
+		{"refilled up to the burst", "job", time.Hour, true, 0},
+		{"over the refilled burst", "job", time.Hour, false, time.Second},
+	}
+
+	for _, tt := range tests {
+		allowed, wait := l.allow(tt.jobId, start.Add(tt.at))
+		if allowed != tt.allowed || wait != tt.wait {
+			t.Errorf("%s: got allowed %v waiting %v, want %v waiting %v", tt.name, allowed, wait, tt.allowed, tt.wait)
+		}
+	}
+
+	// a finished job starts again with a full bucket
+	l.forget("job")
+	if allowed, _ := l.allow("job", start.Add(time.Hour)); !allowed {
+		t.Error("got update throttled after forgetting the job")
+	}
+}
+
+func TestUpdateLimiterDisabled(t *testing.T) {
+	l := newTestLimiter(0, 1)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		if allowed, _ := l.allow("job", now); !allowed {
+			t.Fatalf("got update %d throttled, want no limit", i)
+		}
+	}
+}
+
+func TestMakeUpdateLimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		interval  string
+		burst     string
+		want      time.Duration
+		wantError bool
+	}{
+		{"defaults", "", "", defaultUpdateInterval, false},
+		{"disabled", "0s", "", 0, false},
+		{"configured", "250ms", "10", 250 * time.Millisecond, false},
+		{"invalid interval", "often", "", 0, true},
+		{"negative interval", "-1s", "", 0, true},
+		{"invalid burst", "", "many", 0, true},
+		{"zero burst", "", "0", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SCHEDULER_UPDATE_INTERVAL", tt.interval)
+			t.Setenv("SCHEDULER_UPDATE_BURST", tt.burst)
+
+			l, err := makeUpdateLimiter()
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if err == nil && l.interval != tt.want {
+				t.Errorf("got interval %v, want %v", l.interval, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewParallelismRateLimit(t *testing.T) {
+	s := &Scheduler{
+		logger:  zap.NewNop(),
+		queue:   NewQueue(),
+		limiter: newTestLimiter(time.Hour, 3),
+	}
+	server := httptest.NewServer(http.HandlerFunc(s.newParallelism))
+	defer server.Close()
+	throttled := testutil.ToFloat64(throttledUpdates)
+
+	// two jobs flood the scheduler with updates at once
+	const updates = 50
+	jobs := []string{"a", "b"}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	codes := make(map[string]map[int]int)
+	for _, jobId := range jobs {
+		codes[jobId] = make(map[int]int)
+		body, err := json.Marshal(api.TrainTask{Job: api.JobInfo{JobId: jobId}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < updates; i++ {
+			wg.Add(1)
+			go func(jobId string) {
+				defer wg.Done()
+				resp, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusTooManyRequests && resp.Header.Get("Retry-After") != "3600" {
+					t.Errorf("got Retry-After %q, want 3600", resp.Header.Get("Retry-After"))
+				}
+
+				mu.Lock()
+				codes[jobId][resp.StatusCode]++
+				mu.Unlock()
+			}(jobId)
+		}
+	}
+	wg.Wait()
+
+	// each job gets its burst queued and the rest throttled
+	for _, jobId := range jobs {
+		if codes[jobId][http.StatusOK] != 3 || codes[jobId][http.StatusTooManyRequests] != updates-3 {
+			t.Errorf("got responses %v for job %s, want 3 accepted and the rest throttled", codes[jobId], jobId)
+		}
+	}
+	if got := s.queue.trainQ.Len(); got != 2*3 {
+		t.Errorf("got %d updates queued, want %d", got, 2*3)
+	}
+	if got := testutil.ToFloat64(throttledUpdates) - throttled; got != 2*(updates-3) {
+		t.Errorf("got %v throttled updates counted, want %d", got, 2*(updates-3))
+	}
+}
@@ -0,0 +1,34 @@
+package scheduler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
+)
+
+var (
+	// decisionLatency is the time between a job asking for a new
+	// parallelism and the decision being delivered to it
+	decisionLatency = promauto.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name:       "kubeml_scheduler_decision_latency_seconds",
+			Help:       "Time taken by the scheduler to decide and deliver the parallelism of a job",
+			Objectives: map[float64]float64{0.5: 0.05, 0.95: 0.01, 0.99: 0.001},
+		},
+		[]string{"operation"},
+	)
+
+	// throttledUpdates is the number of job updates
+	// rejected for going over the rate limit of the job
+	throttledUpdates = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubeml_scheduler_throttled_updates_total",
+			Help: "Job updates rejected by the scheduler for exceeding the rate limit of the job",
+		},
+	)
+)
+
+// observeDecisionLatency records the latency of a decision of the given operation
+func observeDecisionLatency(op TaskOperation, latency time.Duration) {
+	decisionLatency.WithLabelValues(op.String()).Observe(latency.Seconds())
+}
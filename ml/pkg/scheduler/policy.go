@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
 	"go.uber.org/zap"
 	"sync"
@@ -17,10 +18,26 @@ const (
 type (
 	SchedulerPolicy interface {
 		// calculate paralellism returns the parallelism for the next epoch
-		calculateParallelism(task api.TrainTask) (parallelism int, op TaskOperation)
+		calculateParallelism(task api.TrainTask) decision
 		taskFinished(taskId string)
 	}
 
+	// decision holds the parallelism chosen by the policy for a task
+	// along with the reference time used and the reason for the choice.
+	// validFor is the number of epochs the decision holds, 0 if the job
+	// should ask again after the next epoch
+	decision struct {
+		parallelism   int
+		op            TaskOperation
+		referenceTime float64
+		reason        string
+		validFor      int
+
+		// cappedFrom is the parallelism chosen by the policy
+		// when it was over the max parallelism of the task
+		cappedFrom int
+	}
+
 	ThroughputBasedPolicy struct {
 		logger *zap.Logger
 
@@ -46,8 +63,39 @@ func makeThroughputPolicy(logger *zap.Logger) ThroughputBasedPolicy {
 // is better or slightly worse than in previous epochs (given by the scale-up threshold), and scales
 // down if the performance is much worse.
 //
-// In between those thresholds the parallelism is kept untouched
-func (tp ThroughputBasedPolicy) calculateParallelism(task api.TrainTask) (parallelism int, op TaskOperation) {
+// In between those thresholds the parallelism is kept untouched. The epoch time is
+// the mean of the recent epochs of the job at its current parallelism, see JobState.WindowTime.
+//
+// If fewer train functions ran at the same time than granted, since Fission queued some
+// of them, the policy scales from the concurrency the job measured, see PolicyParallelism.
+//
+// New tasks start from the parallelism recommended by the last job of the same function
+// and dataset, attached by the controller, or from their default parallelism
+func (tp ThroughputBasedPolicy) calculateParallelism(task api.TrainTask) decision {
+
+	// static jobs never ask for a new parallelism, so they
+	// are not tracked to avoid keeping their entries around
+	if task.Parameters.Options.StaticParallelism {
+		return decision{
+			parallelism: task.Parameters.Options.DefaultParallelism,
+			op:          CreateTask,
+			reason:      "static task, using default parallelism",
+		}
+	}
+
+	// the time compared is smoothed over the recent epochs
+	// of the job within the window of its options
+	elapsed := task.Job.State.WindowTime()
+
+	current := task.Job.State.PolicyParallelism()
+	var measured string
+	if task.Job.State.Diverged() {
+		tp.logger.Debug("Measured concurrency diverged from the parallelism, scaling from it",
+			zap.Int("measured", task.Job.State.MeasuredConcurrency),
+			zap.Int("parallelism", task.Job.State.Parallelism))
+		measured = fmt.Sprintf(" from the measured concurrency of %d (granted %d)",
+			task.Job.State.MeasuredConcurrency, task.Job.State.Parallelism)
+	}
 
 	tp.mu.RLock()
 	prevTime, exists := tp.timeCache[task.Job.JobId]
@@ -60,39 +108,86 @@ func (tp ThroughputBasedPolicy) calculateParallelism(task api.TrainTask) (parall
 		tp.timeCache[task.Job.JobId] = 0
 		tp.mu.Unlock()
 
-		return task.Parameters.Options.DefaultParallelism, CreateTask
+		// start from the parallelism recommended by the last
+		// job of the same function and dataset if there is one
+		if r := task.Parameters.Recommendation; r != nil && r.Parallelism > 0 {
+			return decision{
+				parallelism: r.Parallelism,
+				op:          CreateTask,
+				reason:      fmt.Sprintf("new task, using the parallelism recommended by job %s", r.JobId),
+			}
+		}
+
+		return decision{
+			parallelism: task.Parameters.Options.DefaultParallelism,
+			op:          CreateTask,
+			reason:      "new task, using default parallelism",
+		}
 
 	} else {
 
 		switch {
 		case prevTime == 0:
 			tp.logger.Debug("No previous time, increasing parallelism")
-			tp.timeCache[task.Job.JobId] = task.Job.State.ElapsedTime
-			return task.Job.State.Parallelism + 1, UpdateTask
+			tp.timeCache[task.Job.JobId] = elapsed
+			return decision{
+				parallelism: current + 1,
+				op:          UpdateTask,
+				reason:      "no previous time, scaling up" + measured,
+			}
 
 		// If the new time is better than the prevTime
 		// always scale up and set a new reference time
-		case task.Job.State.ElapsedTime <= prevTime*ThroughputScaleUpThreshold:
+		case elapsed <= prevTime*ThroughputScaleUpThreshold:
 			tp.logger.Debug("Time is better, scaling up")
-			tp.timeCache[task.Job.JobId] = task.Job.State.ElapsedTime
-			return task.Job.State.Parallelism + 1, UpdateTask
+			tp.timeCache[task.Job.JobId] = elapsed
+			return decision{
+				parallelism:   current + 1,
+				op:            UpdateTask,
+				referenceTime: prevTime,
+				reason:        fmt.Sprintf("time within %vx of the reference, scaling up", ThroughputScaleUpThreshold) + measured,
+			}
 
 		// If the performance is much worse (20%) than the reference
 		// time, downscale and set a new reference time
-		case task.Job.State.ElapsedTime >= prevTime*ThroughPutScaleDownThreshold:
+		case elapsed >= prevTime*ThroughPutScaleDownThreshold:
 			tp.logger.Debug("Time is worse, scaling down")
-			tp.timeCache[task.Job.JobId] = task.Job.State.ElapsedTime
-			return task.Job.State.Parallelism - 1, UpdateTask
+			tp.timeCache[task.Job.JobId] = elapsed
+			return decision{
+				parallelism:   current - 1,
+				op:            UpdateTask,
+				referenceTime: prevTime,
+				reason:        fmt.Sprintf("time over %vx the reference, scaling down", ThroughPutScaleDownThreshold) + measured,
+			}
 
 		default:
 			tp.logger.Debug("Time is worse within the limits, keeping parallelism")
-			return task.Job.State.Parallelism, UpdateTask
+			return decision{
+				parallelism:   current,
+				op:            UpdateTask,
+				referenceTime: prevTime,
+				reason:        "time worse within the limits, keeping parallelism" + measured,
+				validFor:      api.DecisionValidFor,
+			}
 		}
 
 	}
 
 }
 
+// capParallelism lowers the parallelism of the decision to the max
+// parallelism of the task, which the policies can never exceed
+func capParallelism(task api.TrainTask, d decision) decision {
+	parallelism, capped := task.Parameters.Options.CapParallelism(d.parallelism)
+	if !capped {
+		return d
+	}
+	d.cappedFrom = d.parallelism
+	d.parallelism = parallelism
+	d.reason += fmt.Sprintf(", capped at the max parallelism of %d", parallelism)
+	return d
+}
+
 // taskFinished handles the finish of the task, here simply deletes it from
 // the time cache
 func (tp ThroughputBasedPolicy) taskFinished(taskId string) {
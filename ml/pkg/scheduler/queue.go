@@ -5,12 +5,20 @@ import (
 	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
 	"sync"
+	"time"
 )
 
 // queue is the internal type used to queue
 // the elements in the scheduler
 type queue = list.List
 
+// queuedTask is a task in the queue along with the
+// time it was pushed, used to measure the decision latency
+type queuedTask struct {
+	task   *api.TrainTask
+	pushed time.Time
+}
+
 // SchedulerQueue is the queue that will be used for the scheduler
 type SchedulerQueue struct {
 
@@ -41,17 +49,18 @@ func (sq *SchedulerQueue) pushTask(task *api.TrainTask) {
 	defer sq.lock.Unlock()
 
 	// Insert a new TrainTask in the queue
-	sq.trainQ.PushBack(task)
+	sq.trainQ.PushBack(queuedTask{task: task, pushed: time.Now()})
 
 }
 
 // popTask returns the next element from the training queue
-func (sq *SchedulerQueue) popTask() (*api.TrainTask, error) {
+// along with the time it was pushed
+func (sq *SchedulerQueue) popTask() (*api.TrainTask, time.Time, error) {
 	sq.lock.Lock()
 	defer sq.lock.Unlock()
 
 	if sq.trainQ.Len() == 0 {
-		return nil, fmt.Errorf("queue is empty")
+		return nil, time.Time{}, fmt.Errorf("queue is empty")
 	}
 
 	// get the first element and remove it from the
@@ -60,7 +69,8 @@ func (sq *SchedulerQueue) popTask() (*api.TrainTask, error) {
 	sq.trainQ.Remove(e)
 
 	// Return the value as a train task
-	return e.Value.(*api.TrainTask), nil
+	qt := e.Value.(queuedTask)
+	return qt.task, qt.pushed, nil
 }
 
 // TODO how will the queues interact?
@@ -75,9 +85,9 @@ func (sq *SchedulerQueue) pushRequest(req *api.TrainRequest) {
 	t := &api.TrainTask{
 		Parameters: *req,
 		Job: api.JobInfo{
-			JobId: createJobId(),
+			JobId: requestJobId(req),
 		},
 	}
-	sq.trainQ.PushBack(t)
+	sq.trainQ.PushBack(queuedTask{task: t, pushed: time.Now()})
 
 }
@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"github.com/diegostock12/kubeml/ml/pkg/deployment"
 	psClient "github.com/diegostock12/kubeml/ml/pkg/ps/client"
 	"go.uber.org/zap"
 	"time"
@@ -26,6 +27,13 @@ type (
 
 		// SchedulerPolicy to determine the task parallelism
 		policy SchedulerPolicy
+
+		// trace keeps the decisions taken for the jobs that
+		// asked for a trace of the scheduler
+		trace *decisionTrace
+
+		// limiter rate limits the updates sent by each job
+		limiter *updateLimiter
 	}
 )
 
@@ -51,7 +59,7 @@ func (s *Scheduler) scheduleTasks() {
 	for {
 
 		// Wait until there is an element in the queue
-		task, err := s.queue.popTask()
+		task, pushed, err := s.queue.popTask()
 		if err != nil {
 			//s.logger.Warn("Schedule queue is empty, sleeping...")
 			// If there is no element sleep
@@ -63,10 +71,22 @@ func (s *Scheduler) scheduleTasks() {
 		s.logger.Debug("Serving task", zap.Any("task", task))
 
 		// calculate the parallelism of the next epoch using the scheduler policy
-		parallelism, operation := s.policy.calculateParallelism(*task)
+		d := capParallelism(*task, s.policy.calculateParallelism(*task))
+		if d.cappedFrom > 0 {
+			s.logger.Debug("Capped the parallelism of the task",
+				zap.String("jobId", task.Job.JobId),
+				zap.Int("requested", d.cappedFrom),
+				zap.Int("parallelism", d.parallelism))
+		}
+		if task.Parameters.Options.TraceScheduler {
+			s.trace.record(s.logger, task, d)
+		}
+		parallelism, operation := d.parallelism, d.op
 
 		// TODO if the scheduling fails, retry as K8s does by putting it in the queue
 		task.Job.State.Parallelism = parallelism
+		task.Job.State.ValidFor = d.validFor
+		task.Job.State.CappedFrom = d.cappedFrom
 		switch operation {
 		case CreateTask:
 			err = s.ps.StartTask(task)
@@ -74,6 +94,7 @@ func (s *Scheduler) scheduleTasks() {
 				s.logger.Error("Error sending task creation request to parameter server",
 					zap.Any("task", task),
 					zap.Error(err))
+				continue
 			}
 
 		case UpdateTask:
@@ -82,9 +103,14 @@ func (s *Scheduler) scheduleTasks() {
 				s.logger.Error("Error sending task update request to parameter server",
 					zap.Any("task", task),
 					zap.Error(err))
+				continue
 			}
 		}
 
+		// the latency covers the time in the queue, the policy
+		// and the delivery of the decision to the job
+		observeDecisionLatency(operation, time.Since(pushed))
+
 	}
 }
 
@@ -101,7 +127,17 @@ func Start(logger *zap.Logger, port int, psUrl string) {
 	s := &Scheduler{
 		logger: logger.Named("scheduler"),
 		queue:  NewQueue(),
+		trace:  newDecisionTrace(),
+	}
+
+	limiter, err := makeUpdateLimiter()
+	if err != nil {
+		s.logger.Fatal("Invalid job update rate limit", zap.Error(err))
 	}
+	s.limiter = limiter
+	s.logger.Debug("Set job update rate limit",
+		zap.Duration("interval", limiter.interval),
+		zap.Int("burst", limiter.burst))
 
 	// set the ps client
 	s.ps = psClient.MakeClient(s.logger, psUrl)
@@ -112,6 +148,7 @@ func Start(logger *zap.Logger, port int, psUrl string) {
 	go s.scheduleTasks()
 
 	// Finally start the API
+	deployment.Report(s.logger, "scheduler")
 	s.Serve(port)
 
 }
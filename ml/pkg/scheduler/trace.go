@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// maxFinishedTraces is the number of traces of finished
+// jobs kept so they can still be inspected
+const maxFinishedTraces = 10
+
+type (
+	// decisionTrace keeps the scheduling decisions taken for the
+	// jobs that asked for a trace of the scheduler
+	decisionTrace struct {
+		mu       sync.RWMutex
+		traces   map[string][]api.SchedulerDecision
+		finished []string
+	}
+)
+
+func newDecisionTrace() *decisionTrace {
+	return &decisionTrace{
+		traces: make(map[string][]api.SchedulerDecision),
+	}
+}
+
+// String returns the name of the operation used in the traces
+func (op TaskOperation) String() string {
+	switch op {
+	case CreateTask:
+		return "create"
+	case UpdateTask:
+		return "update"
+	default:
+		return "unknown"
+	}
+}
+
+// record adds the decision taken for the task to its trace and logs it
+func (t *decisionTrace) record(logger *zap.Logger, task *api.TrainTask, d decision) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry := api.SchedulerDecision{
+		Time:          time.Now(),
+		Request:       len(t.traces[task.Job.JobId]) + 1,
+		Operation:     d.op.String(),
+		State:         task.Job.State,
+		ReferenceTime: d.referenceTime,
+		Parallelism:   d.parallelism,
+		Reason:        d.reason,
+		ValidFor:      d.validFor,
+		CappedFrom:    d.cappedFrom,
+	}
+	t.traces[task.Job.JobId] = append(t.traces[task.Job.JobId], entry)
+
+	logger.Info("Scheduling decision",
+		zap.String("jobId", task.Job.JobId),
+		zap.Int("request", entry.Request),
+		zap.String("operation", entry.Operation),
+		zap.Int("currentParallelism", entry.State.Parallelism),
+		zap.Float64("elapsedTime", entry.State.ElapsedTime),
+		zap.Float64("referenceTime", entry.ReferenceTime),
+		zap.Int("parallelism", entry.Parallelism),
+		zap.String("reason", entry.Reason),
+		zap.Int("validFor", entry.ValidFor),
+		zap.Int("cappedFrom", entry.CappedFrom))
+}
+
+// get returns the trace of a job
+func (t *decisionTrace) get(jobId string) ([]api.SchedulerDecision, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	trace, exists := t.traces[jobId]
+	return trace, exists
+}
+
+// finish marks the trace of the job as finished, deleting the
+// oldest finished trace if there are too many of them
+func (t *decisionTrace) finish(jobId string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, exists := t.traces[jobId]; !exists {
+		return
+	}
+
+	t.finished = append(t.finished, jobId)
+	if len(t.finished) > maxFinishedTraces {
+		delete(t.traces, t.finished[0])
+		t.finished = t.finished[1:]
+	}
+}
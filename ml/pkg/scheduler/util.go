@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
 	"github.com/google/uuid"
 )
 
@@ -8,3 +9,12 @@ import (
 func createJobId() string {
 	return uuid.New().String()[:8]
 }
+
+// requestJobId returns the id of the job of a request, the id of
+// the finished job it continues or else a new one
+func requestJobId(req *api.TrainRequest) string {
+	if len(req.Options.ContinueFrom) > 0 {
+		return req.Options.ContinueFrom
+	}
+	return createJobId()
+}
@@ -0,0 +1,356 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Names of the splits of a dataset in the storage
+	SplitTrain = "train"
+	SplitTest  = "test"
+
+	defaultRetries = 3
+	defaultBackoff = 200 * time.Millisecond
+)
+
+var (
+	// fieldNames are the names of the multipart fields expected
+	// by the storage service when uploading a dataset
+	fieldNames = []string{"x-train", "y-train", "x-test", "y-test"}
+)
+
+type (
+
+	// Interface has the methods to work with the datasets kept by the
+	// storage service. It allows faking the storage in tests
+	Interface interface {
+		Get(ctx context.Context, name string) (*api.DatasetSummary, error)
+		List(ctx context.Context) ([]api.DatasetSummary, error)
+		Stats(ctx context.Context, name string) (*api.DatasetStats, error)
+		ListShards(ctx context.Context, name, split string) ([]int, error)
+		ReadShards(ctx context.Context, name, split string, start, end int) ([]api.DatasetShard, error)
+		Upload(ctx context.Context, name string, files DatasetFiles) error
+		Delete(ctx context.Context, name string) error
+		SetClassWeights(ctx context.Context, name string, weights []float64) error
+	}
+
+	// DatasetFiles holds the paths to the files that form a dataset
+	DatasetFiles struct {
+		TrainData   string
+		TrainLabels string
+		TestData    string
+		TestLabels  string
+	}
+
+	// Client talks to the storage service, either directly or through the
+	// controller proxy. Requests are retried with exponential backoff
+	// when the service cannot be reached or is temporarily unavailable
+	Client struct {
+		logger     *zap.Logger
+		storageUrl string
+		httpClient *http.Client
+		retries    int
+		backoff    time.Duration
+	}
+
+	// bodyFunc creates the body of a request, it is called once per attempt
+	// so the body can be rebuilt when retrying
+	bodyFunc func() (body io.Reader, contentType string, err error)
+)
+
+// MakeClient creates a client for the storage service
+func MakeClient(logger *zap.Logger, storageUrl string) *Client {
+	return &Client{
+		logger:     logger.Named("storage-client"),
+		storageUrl: strings.TrimSuffix(storageUrl, "/"),
+		httpClient: &http.Client{},
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+	}
+}
+
+// Get returns the summary of a dataset
+func (c *Client) Get(ctx context.Context, name string) (*api.DatasetSummary, error) {
+	var summary api.DatasetSummary
+	err := c.getJSON(ctx, "/dataset/"+name, &summary)
+	if err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// List returns the summaries of all the datasets
+func (c *Client) List(ctx context.Context) ([]api.DatasetSummary, error) {
+	var summaries []api.DatasetSummary
+	err := c.getJSON(ctx, "/dataset", &summaries)
+	if err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// Stats returns the summary of a dataset along with the shard information
+func (c *Client) Stats(ctx context.Context, name string) (*api.DatasetStats, error) {
+	var stats api.DatasetStats
+	err := c.getJSON(ctx, "/dataset/"+name+"/stats", &stats)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// ListShards returns the ids of the shards of a dataset split
+func (c *Client) ListShards(ctx context.Context, name, split string) ([]int, error) {
+	var ids []int
+	err := c.getJSON(ctx, fmt.Sprintf("/dataset/%s/%s/shards", name, split), &ids)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ReadShards returns the shards of a dataset split with ids in the range [start, end)
+func (c *Client) ReadShards(ctx context.Context, name, split string, start, end int) ([]api.DatasetShard, error) {
+	if start < 0 || end <= start {
+		return nil, kerror.New(http.StatusBadRequest, fmt.Sprintf("invalid shard range [%d, %d)", start, end))
+	}
+
+	values := url.Values{}
+	values.Set("start", strconv.Itoa(start))
+	values.Set("end", strconv.Itoa(end))
+
+	var shards []api.DatasetShard
+	path := fmt.Sprintf("/dataset/%s/%s/shards/range?%s", name, split, values.Encode())
+	err := c.getJSON(ctx, path, &shards)
+	if err != nil {
+		return nil, err
+	}
+	return shards, nil
+}
+
+// Upload uploads the dataset files to the storage service. The multipart
+// body is streamed from the files, so the request is sent in chunks instead of
+// being buffered in memory
+func (c *Client) Upload(ctx context.Context, name string, files DatasetFiles) error {
+	paths := []string{files.TrainData, files.TrainLabels, files.TestData, files.TestLabels}
+
+	// check the files before starting the upload
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return errors.Wrapf(err, "could not open file %s", path)
+		}
+	}
+
+	body := func() (io.Reader, string, error) {
+		pr, pw := io.Pipe()
+		writer := multipart.NewWriter(pw)
+		go func() {
+			pw.CloseWithError(writeDatasetFiles(writer, paths))
+		}()
+		return pr, writer.FormDataContentType(), nil
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/dataset/"+name, body)
+	if err != nil {
+		return errors.Wrap(err, "could not upload dataset")
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Delete deletes a dataset from the storage
+func (c *Client) Delete(ctx context.Context, name string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/dataset/"+name, nil)
+	if err != nil {
+		return errors.Wrap(err, "could not delete dataset")
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// SetClassWeights sets the weights of the classes of a dataset used by the jobs that
+// weight their loss, which must have one weight for each class. Without weights the
+// ones set are deleted, so they are computed again from the class distribution
+func (c *Client) SetClassWeights(ctx context.Context, name string, weights []float64) error {
+	path := "/dataset/" + name + "/weights"
+	if len(weights) == 0 {
+		resp, err := c.do(ctx, http.MethodDelete, path, nil)
+		if err != nil {
+			return errors.Wrap(err, "could not delete class weights")
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	payload, err := json.Marshal(map[string][]float64{"weights": weights})
+	if err != nil {
+		return errors.Wrap(err, "could not encode class weights")
+	}
+	body := func() (io.Reader, string, error) {
+		return bytes.NewReader(payload), "application/json", nil
+	}
+
+	resp, err := c.do(ctx, http.MethodPut, path, body)
+	if err != nil {
+		return errors.Wrap(err, "could not set class weights")
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// writeDatasetFiles copies each of the files to a multipart form field
+// with the name expected by the storage service
+func writeDatasetFiles(writer *multipart.Writer, paths []string) error {
+	for i, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return errors.Wrapf(err, "could not open file %s", path)
+		}
+
+		part, err := writer.CreateFormFile(fieldNames[i], file.Name())
+		if err != nil {
+			file.Close()
+			return errors.Wrapf(err, "could not write part from file %s", path)
+		}
+
+		_, err = io.Copy(part, file)
+		file.Close()
+		if err != nil {
+			return errors.Wrapf(err, "could not copy part from file %s", path)
+		}
+	}
+
+	return writer.Close()
+}
+
+// getJSON performs a GET request and decodes the json response in v
+func (c *Client) getJSON(ctx context.Context, path string, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "could not read response body")
+	}
+
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return errors.Wrap(err, "could not decode body")
+	}
+
+	return nil
+}
+
+// do performs the request, retrying with exponential backoff if the storage
+// service cannot be reached or answers with a temporary error. Any other non-OK
+// response is returned as a kubeml error
+func (c *Client) do(ctx context.Context, method, path string, body bodyFunc) (*http.Response, error) {
+	var lastErr error
+	wait := c.backoff
+
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			c.logger.Debug("retrying storage request",
+				zap.String("path", path),
+				zap.Int("attempt", attempt),
+				zap.Error(lastErr))
+
+			select {
+			case <-ctx.Done():
+				return nil, errors.Wrap(ctx.Err(), lastErr.Error())
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+
+		req, err := c.newRequest(ctx, method, path, body)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = errors.Wrap(err, "could not perform request")
+			continue
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		lastErr = responseError(resp)
+		if !isTemporary(resp.StatusCode) {
+			return nil, lastErr
+		}
+	}
+
+	return nil, lastErr
+}
+
+// newRequest builds the request for a single attempt
+func (c *Client) newRequest(ctx context.Context, method, path string, body bodyFunc) (*http.Request, error) {
+	var reader io.Reader
+	var contentType string
+	if body != nil {
+		var err error
+		reader, contentType, err = body()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create request body")
+		}
+	}
+
+	req, err := http.NewRequest(method, c.storageUrl+path, reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create request")
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	return req.WithContext(ctx), nil
+}
+
+// responseError reads the error returned by the storage service,
+// which answers with a json object holding the error message
+func responseError(resp *http.Response) error {
+	err := kerror.CheckFunctionError(resp)
+	if e, ok := err.(kerror.Error); ok && e.Code == 0 {
+		e.Code = resp.StatusCode
+		return e
+	}
+	return err
+}
+
+// isTemporary returns whether the request should be retried
+// given the status code of the response
+func isTemporary(code int) bool {
+	switch code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
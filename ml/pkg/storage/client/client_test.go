@@ -0,0 +1,193 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client of the server with a short backoff
+func newTestClient(server *httptest.Server) *Client {
+	c := MakeClient(zap.NewNop(), server.URL)
+	c.backoff = time.Millisecond
+	return c
+}
+
+// failing returns a handler that answers the first failures requests
+// with the status code and then calls next, counting the requests
+func failing(failures int32, code int, count *int32, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(count, 1) <= failures {
+			kerror.RespondWithError(w, kerror.New(code, "storage unavailable"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func TestGetRetriesTemporaryErrors(t *testing.T) {
+	want := api.DatasetSummary{Name: "mnist", TrainSetSize: 60000, TestSetSize: 10000}
+
+	var count int32
+	server := httptest.NewServer(failing(2, http.StatusServiceUnavailable, &count,
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/dataset/mnist" {
+				t.Errorf("unexpected path %s", r.URL.Path)
+			}
+			json.NewEncoder(w).Encode(want)
+		}))
+	defer server.Close()
+
+	got, err := newTestClient(server).Get(context.Background(), "mnist")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *got != want {
+		t.Errorf("got summary %+v, want %+v", *got, want)
+	}
+	if count != 3 {
+		t.Errorf("got %d requests, want 3", count)
+	}
+}
+
+func TestGetGivesUpAfterRetries(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(failing(100, http.StatusBadGateway, &count, nil))
+	defer server.Close()
+
+	_, err := newTestClient(server).Get(context.Background(), "mnist")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if e, ok := errors.Cause(err).(kerror.Error); !ok || e.Code != http.StatusBadGateway {
+		t.Errorf("got error %v, want a kubeml error with code %d", err, http.StatusBadGateway)
+	}
+	if count != defaultRetries+1 {
+		t.Errorf("got %d requests, want %d", count, defaultRetries+1)
+	}
+}
+
+func TestGetDoesNotRetryOtherErrors(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(failing(100, http.StatusNotFound, &count, nil))
+	defer server.Close()
+
+	_, err := newTestClient(server).Get(context.Background(), "mnist")
+	if e, ok := errors.Cause(err).(kerror.Error); !ok || e.Code != http.StatusNotFound {
+		t.Errorf("got error %v, want a kubeml error with code %d", err, http.StatusNotFound)
+	}
+	if count != 1 {
+		t.Errorf("got %d requests, want 1", count)
+	}
+}
+
+func TestGetStopsRetryingWhenCancelled(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(failing(100, http.StatusServiceUnavailable, &count, nil))
+	defer server.Close()
+
+	c := newTestClient(server)
+	c.backoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.Get(ctx, "mnist"); errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if count != 1 {
+		t.Errorf("got %d requests, want 1", count)
+	}
+}
+
+func TestUploadStreamsMultipartBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	contents := map[string]string{
+		"x-train": "train data",
+		"y-train": "train labels",
+		"x-test":  "test data",
+		"y-test":  "test labels",
+	}
+	paths := make(map[string]string)
+	for field, content := range contents {
+		paths[field] = filepath.Join(dir, field+".npy")
+		if err := ioutil.WriteFile(paths[field], []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first attempt fails, so the body has to be built again
+	var count int32
+	server := httptest.NewServer(failing(1, http.StatusServiceUnavailable, &count,
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost || r.URL.Path != "/dataset/mnist" {
+				t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			}
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Errorf("could not parse multipart body: %v", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for field, content := range contents {
+				file, header, err := r.FormFile(field)
+				if err != nil {
+					t.Errorf("missing field %s: %v", field, err)
+					continue
+				}
+				data, _ := ioutil.ReadAll(file)
+				file.Close()
+				if string(data) != content {
+					t.Errorf("field %s has %q, want %q", field, data, content)
+				}
+				if header.Filename != filepath.Base(paths[field]) {
+					t.Errorf("field %s has file name %s, want %s", field, header.Filename, filepath.Base(paths[field]))
+				}
+			}
+		}))
+	defer server.Close()
+
+	err = newTestClient(server).Upload(context.Background(), "mnist", DatasetFiles{
+		TrainData:   paths["x-train"],
+		TrainLabels: paths["y-train"],
+		TestData:    paths["x-test"],
+		TestLabels:  paths["y-test"],
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("got %d requests, want 2", count)
+	}
+}
+
+func TestUploadChecksFilesFirst(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(failing(0, 0, &count, func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	err := newTestClient(server).Upload(context.Background(), "mnist", DatasetFiles{
+		TrainData: filepath.Join(os.TempDir(), "does-not-exist.npy"),
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if count != 0 {
+		t.Errorf("got %d requests, want none", count)
+	}
+}
@@ -0,0 +1,231 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// storageResponse is a response of the storage service recorded in testdata
+type storageResponse struct {
+	code int
+	file string
+}
+
+// storageRoutes are the responses of the fake storage service,
+// keyed by the method and the path and query of the request
+var storageRoutes = map[string]storageResponse{
+	"GET /dataset":                                             {http.StatusOK, "datasets.json"},
+	"GET /dataset/mnist":                                       {http.StatusOK, "dataset.json"},
+	"GET /dataset/missing":                                     {http.StatusNotFound, "dataset_not_found.json"},
+	"GET /dataset/mnist/stats":                                 {http.StatusOK, "stats.json"},
+	"GET /dataset/texts/stats":                                 {http.StatusOK, "stats_unlabelled.json"},
+	"GET /dataset/missing/stats":                               {http.StatusNotFound, "dataset_not_found.json"},
+	"GET /dataset/mnist/train/shards":                          {http.StatusOK, "shards.json"},
+	"GET /dataset/mnist/validation/shards":                     {http.StatusNotFound, "split_not_found.json"},
+	"GET /dataset/mnist/train/shards/range?end=3&start=1":      {http.StatusOK, "shards_range.json"},
+	"GET /dataset/mnist/validation/shards/range?end=1&start=0": {http.StatusNotFound, "split_not_found.json"},
+	"PUT /dataset/mnist/weights":                               {http.StatusOK, "weights_set.json"},
+	"PUT /dataset/cifar10/weights":                             {http.StatusBadRequest, "weights_invalid.json"},
+	"DELETE /dataset/mnist/weights":                            {http.StatusOK, "weights_deleted.json"},
+}
+
+// fakeStorage serves the recorded responses of the storage service,
+// keeping the requests and their bodies
+type fakeStorage struct {
+	t        *testing.T
+	mu       sync.Mutex
+	requests []string
+	bodies   [][]byte
+}
+
+func (f *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Method + " " + r.URL.RequestURI()
+	body, _ := ioutil.ReadAll(r.Body)
+	f.mu.Lock()
+	f.requests = append(f.requests, key)
+	f.bodies = append(f.bodies, body)
+	f.mu.Unlock()
+
+	route, exists := storageRoutes[key]
+	if !exists {
+		f.t.Errorf("got request %s, which the storage service does not serve", key)
+		http.NotFound(w, r)
+		return
+	}
+	data, err := ioutil.ReadFile(filepath.Join("testdata", route.file))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(route.code)
+	w.Write(data)
+}
+
+func newContractClient(t *testing.T) (*Client, *fakeStorage) {
+	t.Helper()
+	storage := &fakeStorage{t: t}
+	server := httptest.NewServer(storage)
+	t.Cleanup(server.Close)
+	return newTestClient(server), storage
+}
+
+// checkStorageError fails if the error is not the one of the storage
+// service with the status code and the message of the response
+func checkStorageError(t *testing.T, err error, code int, message string) {
+	t.Helper()
+	e, ok := errors.Cause(err).(kerror.Error)
+	if !ok || e.Code != code || e.Message != message {
+		t.Errorf("got error %v, want %d %q", err, code, message)
+	}
+}
+
+func TestContractGet(t *testing.T) {
+	c, _ := newContractClient(t)
+
+	summary, err := c.Get(context.Background(), "mnist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := api.DatasetSummary{Name: "mnist", TrainSetSize: 60416, TestSetSize: 10240}
+	if *summary != want {
+		t.Errorf("got summary %+v, want %+v", *summary, want)
+	}
+
+	_, err = c.Get(context.Background(), "missing")
+	checkStorageError(t, err, http.StatusNotFound, "Dataset missing does not exist")
+}
+
+func TestContractList(t *testing.T) {
+	c, _ := newContractClient(t)
+
+	summaries, err := c.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []api.DatasetSummary{
+		{Name: "cifar10", TrainSetSize: 50176, TestSetSize: 10240},
+		{Name: "mnist", TrainSetSize: 60416, TestSetSize: 10240},
+	}
+	if !reflect.DeepEqual(summaries, want) {
+		t.Errorf("got summaries %+v, want %+v", summaries, want)
+	}
+}
+
+func TestContractStats(t *testing.T) {
+	c, _ := newContractClient(t)
+
+	stats, err := c.Stats(context.Background(), "mnist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Name != "mnist" || stats.ShardSize != 64 || stats.TrainShards != 944 || stats.TestShards != 160 {
+		t.Errorf("got stats %+v, want the shards of mnist", stats)
+	}
+	if len(stats.ClassCounts) != 10 || stats.ClassCounts[1] != 6742 {
+		t.Errorf("got class counts %v, want the 10 digits", stats.ClassCounts)
+	}
+	weights, source, err := stats.ResolveClassWeights()
+	if err != nil || source != api.ClassWeightsExplicit || !reflect.DeepEqual(weights, []float64{1, 1, 1, 1, 1, 1, 1, 1, 2, 2}) {
+		t.Errorf("got weights %v from %s and error %v, want the ones set", weights, source, err)
+	}
+	input, ok := stats.InputStats()
+	if !ok || input != (api.InputStats{Min: 0, Max: 255, Integer: true}) {
+		t.Errorf("got input stats %+v, want the pixels in [0, 255]", input)
+	}
+
+	// the fields the storage leaves out if the labels are not
+	// class indices and the features are not numeric
+	stats, err = c.Stats(context.Background(), "texts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TestSetSize != 0 || stats.ClassCounts != nil || stats.ClassWeights != nil {
+		t.Errorf("got stats %+v, want no test split nor classes", stats)
+	}
+	if _, ok := stats.InputStats(); ok {
+		t.Error("got input stats for features that are not numeric")
+	}
+
+	_, err = c.Stats(context.Background(), "missing")
+	checkStorageError(t, err, http.StatusNotFound, "Dataset missing does not exist")
+}
+
+func TestContractListShards(t *testing.T) {
+	c, _ := newContractClient(t)
+
+	ids, err := c.ListShards(context.Background(), "mnist", SplitTrain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int{0, 1, 2}) {
+		t.Errorf("got shards %v, want [0 1 2]", ids)
+	}
+
+	_, err = c.ListShards(context.Background(), "mnist", "validation")
+	checkStorageError(t, err, http.StatusNotFound, "Dataset split mnist/validation does not exist")
+}
+
+func TestContractReadShards(t *testing.T) {
+	c, storage := newContractClient(t)
+
+	// the pickled arrays are sent in base64
+	shards, err := c.ReadShards(context.Background(), "mnist", SplitTrain, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []api.DatasetShard{
+		{Id: 1, Data: []byte("data of shard 1"), Labels: []byte("labels of shard 1")},
+		{Id: 2, Data: []byte("data of shard 2"), Labels: []byte("labels of shard 2")},
+	}
+	if !reflect.DeepEqual(shards, want) {
+		t.Errorf("got shards %+v, want %+v", shards, want)
+	}
+
+	_, err = c.ReadShards(context.Background(), "mnist", "validation", 0, 1)
+	checkStorageError(t, err, http.StatusNotFound, "Dataset split mnist/validation does not exist")
+
+	// the ranges the storage would refuse are not sent
+	requests := len(storage.requests)
+	for _, r := range [][2]int{{-1, 2}, {2, 2}, {3, 1}} {
+		_, err = c.ReadShards(context.Background(), "mnist", SplitTrain, r[0], r[1])
+		if e, ok := err.(kerror.Error); !ok || e.Code != http.StatusBadRequest {
+			t.Errorf("got error %v for range %v, want a bad request", err, r)
+		}
+	}
+	if len(storage.requests) != requests {
+		t.Errorf("got %d requests for the invalid ranges, want none", len(storage.requests)-requests)
+	}
+}
+
+func TestContractSetClassWeights(t *testing.T) {
+	c, storage := newContractClient(t)
+
+	if err := c.SetClassWeights(context.Background(), "mnist", []float64{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	var body map[string][]float64
+	if err := json.Unmarshal(storage.bodies[0], &body); err != nil || !reflect.DeepEqual(body["weights"], []float64{1, 2}) {
+		t.Errorf("got body %s, want the weights", storage.bodies[0])
+	}
+
+	// no weights deletes the ones set
+	if err := c.SetClassWeights(context.Background(), "mnist", nil); err != nil {
+		t.Fatal(err)
+	}
+	if storage.requests[1] != "DELETE /dataset/mnist/weights" {
+		t.Errorf("got request %s, want the weights deleted", storage.requests[1])
+	}
+
+	err := c.SetClassWeights(context.Background(), "cifar10", []float64{1, 2})
+	checkStorageError(t, err, http.StatusBadRequest, "Got 2 class weights but the dataset has 10 classes")
+}
@@ -0,0 +1,260 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"github.com/diegostock12/kubeml/ml/pkg/storage/client"
+	"github.com/pkg/errors"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+)
+
+type (
+	// Storage keeps the datasets in memory and answers like the storage service,
+	// so the packages that read the datasets can be tested without it. The
+	// errors of the service are returned as kubeml errors with its status codes
+	Storage struct {
+		mu       sync.Mutex
+		datasets map[string]*Dataset
+
+		// Err, if set, is returned by every call as if the storage was unreachable
+		Err error
+
+		// Calls counts the calls of each method
+		Calls map[string]int
+	}
+
+	// Dataset is a dataset kept by the fake storage, its shards keyed by the split
+	Dataset struct {
+		Stats  api.DatasetStats
+		Shards map[string][]api.DatasetShard
+	}
+)
+
+var _ client.Interface = &Storage{}
+
+// NewStorage returns a storage holding the datasets with the stats
+// given and no shards, see AddDataset to add them with their shards
+func NewStorage(stats ...api.DatasetStats) *Storage {
+	s := &Storage{
+		datasets: make(map[string]*Dataset),
+		Calls:    make(map[string]int),
+	}
+	for _, st := range stats {
+		s.AddDataset(st, nil, nil)
+	}
+	return s
+}
+
+// AddDataset adds a dataset with its shards, replacing it if it exists
+func (s *Storage) AddDataset(stats api.DatasetStats, train, test []api.DatasetShard) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.datasets[stats.Name] = &Dataset{
+		Stats: stats,
+		Shards: map[string][]api.DatasetShard{
+			client.SplitTrain: train,
+			client.SplitTest:  test,
+		},
+	}
+}
+
+// Dataset returns a copy of a dataset, nil if it does not exist
+func (s *Storage) Dataset(name string) *Dataset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, exists := s.datasets[name]
+	if !exists {
+		return nil
+	}
+
+	copied := &Dataset{Stats: copyStats(d.Stats), Shards: make(map[string][]api.DatasetShard, len(d.Shards))}
+	for split, shards := range d.Shards {
+		copied.Shards[split] = append([]api.DatasetShard(nil), shards...)
+	}
+	return copied
+}
+
+// copyStats returns the stats without sharing the classes with the dataset
+func copyStats(stats api.DatasetStats) api.DatasetStats {
+	stats.ClassCounts = append([]int64(nil), stats.ClassCounts...)
+	stats.ClassWeights = append([]float64(nil), stats.ClassWeights...)
+	return stats
+}
+
+// call counts the call of a method and returns the dataset it is about, or the
+// error to return. The caller must hold the lock
+func (s *Storage) call(method, name string) (*Dataset, error) {
+	s.Calls[method]++
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	if name == "" {
+		return nil, nil
+	}
+	d, exists := s.datasets[name]
+	if !exists {
+		return nil, kerror.New(http.StatusNotFound, fmt.Sprintf("Dataset %s does not exist", name))
+	}
+	return d, nil
+}
+
+func (s *Storage) Get(_ context.Context, name string) (*api.DatasetSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.call("Get", name)
+	if err != nil {
+		return nil, err
+	}
+	summary := d.Stats.DatasetSummary
+	return &summary, nil
+}
+
+func (s *Storage) List(_ context.Context) ([]api.DatasetSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.call("List", ""); err != nil {
+		return nil, err
+	}
+
+	summaries := make([]api.DatasetSummary, 0, len(s.datasets))
+	for _, d := range s.datasets {
+		summaries = append(summaries, d.Stats.DatasetSummary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries, nil
+}
+
+func (s *Storage) Stats(_ context.Context, name string) (*api.DatasetStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.call("Stats", name)
+	if err != nil {
+		return nil, err
+	}
+	stats := copyStats(d.Stats)
+	return &stats, nil
+}
+
+// split returns the shards of a split of the dataset. The caller must hold the lock
+func (s *Storage) split(method, name, split string) ([]api.DatasetShard, error) {
+	d, err := s.call(method, name)
+	if err != nil {
+		if e, ok := err.(kerror.Error); ok && e.Code == http.StatusNotFound {
+			return nil, kerror.New(http.StatusNotFound, fmt.Sprintf("Dataset split %s/%s does not exist", name, split))
+		}
+		return nil, err
+	}
+	shards, exists := d.Shards[split]
+	if !exists {
+		return nil, kerror.New(http.StatusNotFound, fmt.Sprintf("Dataset split %s/%s does not exist", name, split))
+	}
+	return shards, nil
+}
+
+func (s *Storage) ListShards(_ context.Context, name, split string) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shards, err := s.split("ListShards", name, split)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(shards))
+	for _, shard := range shards {
+		ids = append(ids, shard.Id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (s *Storage) ReadShards(_ context.Context, name, split string, start, end int) ([]api.DatasetShard, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if start < 0 || end <= start {
+		s.Calls["ReadShards"]++
+		return nil, kerror.New(http.StatusBadRequest, fmt.Sprintf("invalid shard range [%d, %d)", start, end))
+	}
+	shards, err := s.split("ReadShards", name, split)
+	if err != nil {
+		return nil, err
+	}
+
+	read := []api.DatasetShard{}
+	for _, shard := range shards {
+		if shard.Id >= start && shard.Id < end {
+			read = append(read, shard)
+		}
+	}
+	sort.Slice(read, func(i, j int) bool {
+		return read[i].Id < read[j].Id
+	})
+	return read, nil
+}
+
+// Upload checks that the files exist and adds an empty dataset, the
+// files are not parsed so its stats are set with AddDataset if needed
+func (s *Storage) Upload(_ context.Context, name string, files client.DatasetFiles) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.call("Upload", ""); err != nil {
+		return errors.Wrap(err, "could not upload dataset")
+	}
+
+	for _, path := range []string{files.TrainData, files.TrainLabels, files.TestData, files.TestLabels} {
+		if _, err := os.Stat(path); err != nil {
+			return errors.Wrapf(err, "could not open file %s", path)
+		}
+	}
+	if _, exists := s.datasets[name]; exists {
+		return errors.Wrap(kerror.New(http.StatusBadRequest, fmt.Sprintf("Dataset %s already exists", name)),
+			"could not upload dataset")
+	}
+
+	s.datasets[name] = &Dataset{
+		Stats:  api.DatasetStats{DatasetSummary: api.DatasetSummary{Name: name}},
+		Shards: map[string][]api.DatasetShard{client.SplitTrain: nil, client.SplitTest: nil},
+	}
+	return nil
+}
+
+func (s *Storage) Delete(_ context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.call("Delete", name); err != nil {
+		return errors.Wrap(err, "could not delete dataset")
+	}
+	delete(s.datasets, name)
+	return nil
+}
+
+// SetClassWeights checks the weights against the classes of the dataset like the
+// storage service does, and deletes them if there are none
+func (s *Storage) SetClassWeights(_ context.Context, name string, weights []float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.call("SetClassWeights", name)
+	if err != nil {
+		return errors.Wrap(err, "could not set class weights")
+	}
+
+	if len(weights) == 0 {
+		d.Stats.ClassWeights = nil
+		return nil
+	}
+	if len(d.Stats.ClassCounts) == 0 {
+		return errors.Wrap(kerror.New(http.StatusBadRequest,
+			fmt.Sprintf("The labels of dataset %s are not class indices", name)), "could not set class weights")
+	}
+	if err := api.CheckClassWeights(weights, len(d.Stats.ClassCounts)); err != nil {
+		return errors.Wrap(kerror.New(http.StatusBadRequest, err.Error()), "could not set class weights")
+	}
+	d.Stats.ClassWeights = append([]float64(nil), weights...)
+	return nil
+}
@@ -0,0 +1,63 @@
+package fake
+
+import (
+	"context"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"github.com/diegostock12/kubeml/ml/pkg/storage/client"
+	"github.com/pkg/errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// the errors are the ones of the responses of the storage service in the testdata of the client
+func checkError(t *testing.T, err error, code int, message string) {
+	t.Helper()
+	e, ok := errors.Cause(err).(kerror.Error)
+	if !ok || e.Code != code || e.Message != message {
+		t.Errorf("got error %v, want %d %q", err, code, message)
+	}
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	s.AddDataset(api.DatasetStats{DatasetSummary: api.DatasetSummary{Name: "mnist"}, ClassCounts: []int64{5, 5}},
+		[]api.DatasetShard{{Id: 2}, {Id: 0}, {Id: 1}}, nil)
+
+	if ids, err := s.ListShards(ctx, "mnist", client.SplitTrain); err != nil || !reflect.DeepEqual(ids, []int{0, 1, 2}) {
+		t.Errorf("got shards %v and error %v, want [0 1 2]", ids, err)
+	}
+	shards, err := s.ReadShards(ctx, "mnist", client.SplitTrain, 1, 3)
+	if err != nil || len(shards) != 2 || shards[0].Id != 1 || shards[1].Id != 2 {
+		t.Errorf("got shards %+v and error %v, want 1 and 2", shards, err)
+	}
+	_, err = s.ReadShards(ctx, "mnist", client.SplitTrain, 2, 2)
+	checkError(t, err, http.StatusBadRequest, "invalid shard range [2, 2)")
+	_, err = s.ListShards(ctx, "mnist", "validation")
+	checkError(t, err, http.StatusNotFound, "Dataset split mnist/validation does not exist")
+	_, err = s.Stats(ctx, "missing")
+	checkError(t, err, http.StatusNotFound, "Dataset missing does not exist")
+
+	err = s.SetClassWeights(ctx, "mnist", []float64{1})
+	checkError(t, err, http.StatusBadRequest, "got 1 class weights but the dataset has 2 classes")
+	if err = s.SetClassWeights(ctx, "mnist", []float64{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ := s.Stats(ctx, "mnist"); !reflect.DeepEqual(stats.ClassWeights, []float64{1, 2}) {
+		t.Errorf("got weights %v, want [1 2]", stats.ClassWeights)
+	}
+
+	if err = s.Delete(ctx, "mnist"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Dataset("mnist") != nil || s.Calls["Stats"] != 2 {
+		t.Errorf("got dataset %+v after %d stats, want it deleted", s.Dataset("mnist"), s.Calls["Stats"])
+	}
+
+	s.Err = errors.New("storage unavailable")
+	if _, err = s.List(ctx); errors.Cause(err) != s.Err {
+		t.Errorf("got error %v, want %v", err, s.Err)
+	}
+}
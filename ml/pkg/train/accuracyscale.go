@@ -0,0 +1,66 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// errGoalScale is returned when the goal accuracy can't be in the scale of the
+// accuracy of the function, which stops the job whatever its validation policy
+var errGoalScale = errors.New("the goal accuracy does not match the scale of the accuracy")
+
+// errMixedScale is returned when the accuracies of the history the job continues are
+// not in the scale of the accuracy of the function, which also stops the job
+var errMixedScale = errors.New("the history does not match the scale of the accuracy")
+
+// setAccuracyScale sets the scale of the accuracy reported by the validation
+// functions, declared by the function or detected, and converts the goal of
+// the job to percent so it can be compared with the history. The accuracies
+// of a history continued from before the scale was recorded are converted too
+func (job *TrainJob) setAccuracyScale(scale, source string) error {
+	opts := &job.task.Parameters.Options
+	if err := opts.NormalizeGoal(scale); err != nil {
+		return errors.Wrap(errGoalScale, err.Error())
+	}
+	if err := job.history.ConvertAccuracies(scale, job.continues()); err != nil {
+		return errors.Wrap(errMixedScale, err.Error())
+	}
+
+	job.history.AccuracyScale = scale
+	job.goalAccuracy = opts.GoalAccuracy
+	job.logger.Info("Set the scale of the accuracy",
+		zap.String("scale", scale),
+		zap.String("source", source),
+		zap.Float64("goal", job.goalAccuracy))
+	return nil
+}
+
+// normalizeAccuracy converts an accuracy reported by the validation functions to
+// percent. If the function did not declare the scale, it is detected from the
+// first accuracy that tells it, and the accuracies until then are kept as reported
+// and converted once it is known. The accuracies of the first epoch only tell the
+// scale if they are over 1, since the model is barely trained
+func (job *TrainJob) normalizeAccuracy(accuracy float64) (float64, error) {
+	if len(job.history.AccuracyScale) == 0 {
+		trained := job.continues() || job.currentEpoch() > 1
+		scale, ok := api.DetectAccuracyScale(accuracy, trained)
+		if !ok {
+			job.logger.Debug("Could not detect the scale of the accuracy yet",
+				zap.Float64("accuracy", accuracy),
+				zap.Bool("trained", trained))
+			return accuracy, nil
+		}
+		if err := job.setAccuracyScale(scale, "detected"); err != nil {
+			return 0, err
+		}
+	}
+	return api.AccuracyToPercent(accuracy, job.history.AccuracyScale), nil
+}
+
+// accuracyScaleKnown returns whether the scale of the accuracy is known. Until then
+// the accuracies are kept as reported, so they are not compared with the goal and
+// the milestones, which are in percent
+func (job *TrainJob) accuracyScaleKnown() bool {
+	return len(job.history.AccuracyScale) > 0
+}
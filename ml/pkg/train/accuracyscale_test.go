@@ -0,0 +1,152 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	psClient "github.com/diegostock12/kubeml/ml/pkg/ps/client"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func newScaleTestJob(goal float64, history api.JobHistory) *TrainJob {
+	return &TrainJob{
+		logger:  zap.NewNop(),
+		history: history,
+		epoch:   1,
+		task: &api.TrainTask{
+			Parameters: api.TrainRequest{
+				Epochs:  10,
+				Options: api.TrainOptions{GoalAccuracy: goal},
+			},
+		},
+	}
+}
+
+func TestNormalizeAccuracyDetectsScale(t *testing.T) {
+	tests := []struct {
+		name       string
+		goal       float64
+		epoch      int
+		accuracies []float64
+		want       []float64
+		scale      string
+		wantGoal   float64
+	}{
+		{"fractions", 0.9, 2, []float64{0, 0.5, 1}, []float64{0, 50, 100}, api.AccuracyScaleFraction, 90},
+		{"percentages", 90, 1, []float64{0, 50, 100}, []float64{0, 50, 100}, api.AccuracyScalePercent, 90},
+		{"fraction of 1", api.NoGoalAccuracy, 2, []float64{1, 0.5}, []float64{100, 50}, api.AccuracyScaleFraction, api.NoGoalAccuracy},
+		{"only zeros", 0.9, 1, []float64{0, 0}, []float64{0, 0}, "", 0},
+		{"under 1% before training", 90, 1, []float64{0.8, 45, 0.8}, []float64{0.8, 45, 0.8}, api.AccuracyScalePercent, 90},
+		{"1% before training", 90, 1, []float64{1, 30}, []float64{1, 30}, api.AccuracyScalePercent, 90},
+		{"fractions after the first epoch", 0.9, 1, []float64{0.8, 0.85}, []float64{80, 85}, api.AccuracyScaleFraction, 90},
+		{"fraction of 1 before training", 0.9, 1, []float64{1, 0.9}, []float64{100, 90}, api.AccuracyScaleFraction, 90},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := newScaleTestJob(tt.goal, api.JobHistory{})
+			for i, accuracy := range tt.accuracies {
+				// one validation per epoch, kept in the history as the job does
+				job.epoch = tt.epoch + i
+				normalized, err := job.normalizeAccuracy(accuracy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				job.history.Accuracy = append(job.history.Accuracy, normalized)
+			}
+
+			if !reflect.DeepEqual(job.history.Accuracy, tt.want) {
+				t.Errorf("got accuracies %v, want %v", job.history.Accuracy, tt.want)
+			}
+			if job.history.AccuracyScale != tt.scale || job.goalAccuracy != tt.wantGoal {
+				t.Errorf("got scale %q and goal %v, want %q and %v",
+					job.history.AccuracyScale, job.goalAccuracy, tt.scale, tt.wantGoal)
+			}
+		})
+	}
+}
+
+func TestNormalizeAccuracyGoalOutOfScale(t *testing.T) {
+	job := newScaleTestJob(95, api.JobHistory{})
+	job.epoch = 2
+	if _, err := job.normalizeAccuracy(0.5); errors.Cause(err) != errGoalScale {
+		t.Errorf("got error %v, want %v", err, errGoalScale)
+	}
+}
+
+func TestRecordValidationHoldsGoalUntilScaleKnown(t *testing.T) {
+	server := httptest.NewServer(&fakePS{})
+	defer server.Close()
+
+	job := newScaleTestJob(0.9, api.JobHistory{Milestones: []api.AccuracyMilestone{{Accuracy: 0.5}}})
+	job.ps = psClient.MakeClient(zap.NewNop(), server.URL)
+	job.accuracyCh = make(chan struct{}, 1)
+	job.durations = newInvocationDurations()
+
+	// a model in percent that is right less than 1% of the time
+	// reaches neither the goal nor the milestone of the fractions
+	if err := job.recordValidation(&validationResults{accuracy: 0.95, loss: 4, samples: 100}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(job.accuracyCh) != 0 || job.history.Milestones[0].Reached {
+		t.Fatalf("got the goal or milestone reached with scale %q", job.history.AccuracyScale)
+	}
+	if !reflect.DeepEqual(job.history.Accuracy, []float64{0.95}) {
+		t.Errorf("got accuracies %v, want the one reported", job.history.Accuracy)
+	}
+}
+
+func TestSetAccuracyScaleConvertsContinuedHistory(t *testing.T) {
+	// the history of a run saved before the scale was recorded
+	// kept the accuracies as reported by the function
+	history := api.JobHistory{Accuracy: []float64{0.5, 0.8}, CanaryAccuracy: []float64{0.7}}
+	job := newScaleTestJob(0.9, history)
+	if err := job.setAccuracyScale(api.AccuracyScaleFraction, "declared"); err != nil {
+		t.Fatal(err)
+	}
+
+	accuracy, err := job.normalizeAccuracy(0.85)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.history.Accuracy = append(job.history.Accuracy, accuracy)
+	if !reflect.DeepEqual(job.history.Accuracy, []float64{50, 80, 85}) {
+		t.Errorf("got accuracies %v, want all in percent", job.history.Accuracy)
+	}
+	if !reflect.DeepEqual(job.history.CanaryAccuracy, []float64{70}) {
+		t.Errorf("got canary accuracies %v, want them in percent", job.history.CanaryAccuracy)
+	}
+
+	// a history that was already continued is not converted again
+	if err = job.setAccuracyScale(api.AccuracyScaleFraction, "continued"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(job.history.Accuracy, []float64{50, 80, 85}) {
+		t.Errorf("got accuracies %v after continuing again, want them unchanged", job.history.Accuracy)
+	}
+}
+
+func TestSetAccuracyScaleRefusesMixedHistory(t *testing.T) {
+	history := api.JobHistory{Accuracy: []float64{50, 80}}
+	job := newScaleTestJob(0.9, history)
+
+	err := job.setAccuracyScale(api.AccuracyScaleFraction, "detected")
+	if errors.Cause(err) != errMixedScale {
+		t.Fatalf("got error %v, want %v", err, errMixedScale)
+	}
+	if len(job.history.AccuracyScale) != 0 || !reflect.DeepEqual(job.history.Accuracy, []float64{50, 80}) {
+		t.Errorf("got scale %q and accuracies %v, want the history unchanged", job.history.AccuracyScale, job.history.Accuracy)
+	}
+
+	// the job is stopped whatever its validation policy
+	retried := false
+	stopErr := job.handleValidationError(err, func() error {
+		retried = true
+		return nil
+	})
+	if stopErr != err || retried {
+		t.Errorf("got error %v and retried %v, want the job stopped", stopErr, retried)
+	}
+}
@@ -0,0 +1,258 @@
+package train
+
+import (
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxTrackedInvocations bounds the invocations kept in the table of a job,
+// the ones started once it is full are only counted
+const maxTrackedInvocations = 1024
+
+// activeInvocations keeps the invocations of the functions of a job that are in
+// flight, added before the invoker is called and removed once it returns, either
+// with the response, an error or after timing out
+type activeInvocations struct {
+	mu        sync.Mutex
+	next      int64
+	table     map[int64]api.InvocationStatus
+	untracked int
+
+	// attempts counts the invocations of each function and
+	// task in the epoch, reset when the next one starts
+	epoch    int
+	attempts map[string]int
+
+	// seconds is the time the invocations of each epoch ran for
+	seconds map[int]float64
+
+	// intervals are the start and end of the train invocations
+	// that returned in each epoch, see concurrency
+	intervals map[int][]interval
+
+	// stragglerTimeout is the age after which the train invocations
+	// are stragglers, 0 unless the job sets a straggler timeout
+	stragglerTimeout time.Duration
+}
+
+// interval is the time an invocation was in flight
+type interval struct {
+	start, end time.Time
+}
+
+func newActiveInvocations() *activeInvocations {
+	return &activeInvocations{
+		table:     make(map[int64]api.InvocationStatus),
+		attempts:  make(map[string]int),
+		seconds:   make(map[int]float64),
+		intervals: make(map[int][]interval),
+	}
+}
+
+// add tracks a new invocation and returns the key to remove it with,
+// which is negative if the table is full and the invocation is not tracked
+func (a *activeInvocations) add(status api.InvocationStatus) int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if status.Epoch != a.epoch {
+		a.epoch = status.Epoch
+		a.attempts = make(map[string]int)
+	}
+	attempt := fmt.Sprintf("%s/%d", status.Task, status.FuncId)
+	a.attempts[attempt]++
+	status.Attempt = a.attempts[attempt]
+
+	if len(a.table) >= maxTrackedInvocations {
+		a.untracked++
+		return -1
+	}
+	a.next++
+	a.table[a.next] = status
+	return a.next
+}
+
+// remove stops tracking the invocation added with the key
+func (a *activeInvocations) remove(key int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if key < 0 {
+		a.untracked--
+		return
+	}
+	delete(a.table, key)
+}
+
+// charge adds the time an invocation ran for to the cost of the epoch
+func (a *activeInvocations) charge(epoch int, elapsed time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.seconds[epoch] += elapsed.Seconds()
+}
+
+// cost returns the function seconds of the epoch, forgetting the earlier epochs
+func (a *activeInvocations) cost(epoch int) float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for e := range a.seconds {
+		if e < epoch {
+			delete(a.seconds, e)
+		}
+	}
+	return a.seconds[epoch]
+}
+
+// record keeps the interval of a train invocation that returned
+func (a *activeInvocations) record(epoch int, start, end time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.intervals[epoch] = append(a.intervals[epoch], interval{start: start, end: end})
+}
+
+// concurrency returns the most train invocations of the epoch that were in
+// flight at the same time, forgetting the earlier epochs
+func (a *activeInvocations) concurrency(epoch int) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for e := range a.intervals {
+		if e < epoch {
+			delete(a.intervals, e)
+		}
+	}
+	return maxConcurrency(a.intervals[epoch])
+}
+
+// maxConcurrency counts the most intervals that overlap at any time, sweeping the
+// starts and ends in order. An invocation ending when another starts is counted
+// as over, so invocations run one after the other have a concurrency of 1.
+// An invocation that ended when it started is in flight for an instant, so
+// its end is not taken as the end of an invocation before its start
+func maxConcurrency(intervals []interval) int {
+	starts := make([]time.Time, len(intervals))
+	ends := make([]time.Time, len(intervals))
+	for i, in := range intervals {
+		starts[i], ends[i] = in.start, in.end
+		if !in.end.After(in.start) {
+			ends[i] = in.start.Add(time.Nanosecond)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	sort.Slice(ends, func(i, j int) bool { return ends[i].Before(ends[j]) })
+
+	var current, max, e int
+	for _, start := range starts {
+		for e < len(ends) && !ends[e].After(start) {
+			current--
+			e++
+		}
+		current++
+		if current > max {
+			max = current
+		}
+	}
+	return max
+}
+
+// setStragglerTimeout sets the age after which the train invocations are stragglers
+func (a *activeInvocations) setStragglerTimeout(timeout time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stragglerTimeout = timeout
+}
+
+// straggler returns true if a train invocation that ran for elapsed is a straggler
+func (a *activeInvocations) straggler(elapsed time.Duration) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stragglerTimeout > 0 && elapsed > a.stragglerTimeout
+}
+
+// list returns the invocations in flight sorted by age, the train
+// invocations taking the iteration the merger is currently in
+func (a *activeInvocations) list(jobId string, iteration int) *api.InvocationTable {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t := &api.InvocationTable{
+		JobId:            jobId,
+		Invocations:      make([]api.InvocationStatus, 0, len(a.table)),
+		Untracked:        a.untracked,
+		StragglerTimeout: a.stragglerTimeout.Seconds(),
+	}
+	for _, status := range a.table {
+		if status.Task == string(Train) {
+			status.Iteration = iteration
+		}
+		t.Invocations = append(t.Invocations, status)
+	}
+	t.SortByAge()
+	return t
+}
+
+// trackInvocation adds the invocation of the function to the table of the
+// job and returns the function that removes it once the invoker returns
+func (job *TrainJob) trackInvocation(funcId int, task FunctionTask) func() {
+	opts := job.task.Parameters.Options
+	status := api.InvocationStatus{
+		FuncId:    funcId,
+		Task:      string(task),
+		Epoch:     job.epoch,
+		Start:     time.Now(),
+		Transport: api.InvocationModeSync,
+	}
+	if opts.QueueInvocations() {
+		status.Transport = api.InvocationModeQueue
+	}
+
+	// the shards are known from the assignment recorded for the audit
+	if task == Train && job.audit != nil && len(job.audit.Epochs) > 0 {
+		functions := job.audit.Epochs[len(job.audit.Epochs)-1].Functions
+		if job.audit.Shards > 0 && funcId < len(functions) {
+			status.Shards = &[2]int64{functions[funcId].FirstShard, functions[funcId].EndShard}
+		}
+	}
+
+	// the validation after the training loop is charged to the last epoch
+	key := job.active.add(status)
+	epoch := job.currentEpoch()
+	return func() {
+		elapsed := time.Since(status.Start)
+		job.active.remove(key)
+		job.active.charge(epoch, elapsed)
+		job.durations.observe(epoch, task, elapsed)
+		if task == Train {
+			job.active.record(epoch, status.Start, time.Now())
+			if job.active.straggler(elapsed) {
+				job.logger.Warn("Train invocation ran over the straggler timeout",
+					zap.Int("funcId", funcId),
+					zap.Int("epoch", epoch),
+					zap.Duration("elapsed", elapsed))
+			}
+		}
+	}
+}
+
+// Invocations returns the invocations of the job in flight sorted by age
+func (job *TrainJob) Invocations() *api.InvocationTable {
+	return job.active.list(job.jobId, job.iterations.current())
+}
+
+// recordConcurrency measures the most train invocations of the epoch that ran at the
+// same time and saves it in the history and in the state sent to the scheduler, which
+// scales from it instead of the parallelism if the invocations were queued
+func (job *TrainJob) recordConcurrency() {
+	measured := job.active.concurrency(job.currentEpoch())
+	job.task.Job.State.MeasuredConcurrency = measured
+	job.setEpochMetrics(map[string]float64{api.MetricMeasuredConcurrency: float64(measured)})
+
+	if job.task.Job.State.Diverged() {
+		job.logger.Warn("Fewer train functions ran at the same time than granted",
+			zap.Int("measured", measured),
+			zap.Int("parallelism", job.task.Job.State.Parallelism))
+	}
+}
@@ -0,0 +1,200 @@
+package train
+
+import (
+	"context"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// intervals returns the intervals given as pairs of start and end in milliseconds
+func intervals(base time.Time, ms ...[2]int) []interval {
+	var result []interval
+	for _, m := range ms {
+		result = append(result, interval{
+			start: base.Add(time.Duration(m[0]) * time.Millisecond),
+			end:   base.Add(time.Duration(m[1]) * time.Millisecond),
+		})
+	}
+	return result
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	tests := []struct {
+		name      string
+		intervals [][2]int
+		want      int
+	}{
+		{"no invocations", nil, 0},
+		{"one invocation", [][2]int{{0, 10}}, 1},
+		{"fully serial", [][2]int{{0, 10}, {10, 20}, {20, 30}, {30, 40}}, 1},
+		{"serial with gaps", [][2]int{{0, 5}, {10, 15}, {20, 25}}, 1},
+		{"fully parallel", [][2]int{{0, 10}, {0, 10}, {0, 10}, {0, 10}}, 4},
+		{"same start different ends", [][2]int{{0, 10}, {0, 20}, {0, 30}}, 3},
+		{"staggered pairs", [][2]int{{0, 10}, {5, 15}, {10, 20}, {15, 25}}, 2},
+		{"nested", [][2]int{{0, 100}, {10, 90}, {20, 80}, {30, 40}, {50, 60}}, 4},
+		{"two peaks", [][2]int{{0, 10}, {1, 3}, {2, 4}, {5, 9}, {6, 8}}, 3},
+		{"unsorted", [][2]int{{20, 30}, {0, 25}, {10, 22}, {40, 50}}, 3},
+		// half of the functions are queued behind the other half
+		{"queued half", [][2]int{{0, 10}, {0, 10}, {10, 20}, {10, 20}}, 2},
+		{"instant invocation", [][2]int{{5, 5}}, 1},
+		{"instant invocations at once", [][2]int{{5, 5}, {5, 5}}, 2},
+		{"instant invocation before another", [][2]int{{0, 0}, {1, 2}}, 1},
+		{"instant invocation at the end of another", [][2]int{{0, 5}, {5, 5}}, 1},
+	}
+
+	base := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maxConcurrency(intervals(base, tt.intervals...)); got != tt.want {
+				t.Errorf("got concurrency %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConcurrencyForgetsEpochs(t *testing.T) {
+	a := newActiveInvocations()
+	base := time.Now()
+	for _, in := range intervals(base, [2]int{0, 10}, [2]int{0, 10}, [2]int{0, 10}) {
+		a.record(1, in.start, in.end)
+	}
+	for _, in := range intervals(base, [2]int{20, 30}, [2]int{30, 40}) {
+		a.record(2, in.start, in.end)
+	}
+
+	if got := a.concurrency(2); got != 1 {
+		t.Errorf("got concurrency %d in epoch 2, want 1", got)
+	}
+	if got := a.concurrency(1); got != 0 {
+		t.Errorf("got concurrency %d of a forgotten epoch, want 0", got)
+	}
+}
+
+// blockingInvoker holds the invocations until release is closed,
+// signalling started when each of them is in flight
+func blockingInvoker(started chan<- int, release <-chan struct{}) *fakeInvoker {
+	return &fakeInvoker{invoke: func(ctx context.Context, funcId int, task FunctionTask) (*http.Response, error) {
+		started <- funcId
+		<-release
+		return jsonResponse(map[string]float64{"loss": 1, "accuracy": 50, "length": 10}), nil
+	}}
+}
+
+func TestInvocationTableValidation(t *testing.T) {
+	validate := func(job *TrainJob, started chan int, release chan struct{}) *api.InvocationTable {
+		done := make(chan error, 1)
+		go func() {
+			_, err := job.invokeValFunctions(context.Background(), 3)
+			done <- err
+		}()
+		for i := 0; i < 3; i++ {
+			<-started
+		}
+		table := job.Invocations()
+		release <- struct{}{}
+		release <- struct{}{}
+		release <- struct{}{}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		return table
+	}
+
+	started, release := make(chan int, 3), make(chan struct{})
+	job := newInvokeTestJob(api.TrainOptions{}, blockingInvoker(started, release))
+	job.task.Parameters.Epochs = 5
+	job.epoch = 2
+
+	tests := []struct {
+		name    string
+		epoch   int
+		attempt int
+	}{
+		{"first validation", 2, 1},
+		{"validated again in the epoch", 2, 2},
+		{"next epoch", 3, 1},
+	}
+	for _, tt := range tests {
+		job.epoch = tt.epoch
+		table := validate(job, started, release)
+		if table.JobId != "job" || len(table.Invocations) != 3 {
+			t.Fatalf("%s: got table %+v, want the 3 validations in flight", tt.name, table)
+		}
+		funcs := make(map[int]bool)
+		for _, inv := range table.Invocations {
+			funcs[inv.FuncId] = true
+			if inv.Task != string(Validation) || inv.Epoch != tt.epoch || inv.Attempt != tt.attempt ||
+				inv.Transport != api.InvocationModeSync || inv.Iteration != 0 || inv.Shards != nil {
+				t.Errorf("%s: got invocation %+v, want attempt %d of a validation in epoch %d", tt.name, inv, tt.attempt, tt.epoch)
+			}
+		}
+		if len(funcs) != 3 {
+			t.Errorf("%s: got functions %v in flight, want 0, 1 and 2", tt.name, funcs)
+		}
+
+		// the invocations are removed once they return
+		if table := job.Invocations(); len(table.Invocations) != 0 {
+			t.Errorf("%s: got invocations %+v after they returned, want none", tt.name, table.Invocations)
+		}
+	}
+}
+
+func TestInvocationTableTrain(t *testing.T) {
+	job := newInvokeTestJob(api.TrainOptions{InvocationMode: api.InvocationModeQueue}, nil)
+	job.task.Parameters.Epochs = 5
+	job.epoch = 1
+	job.audit = &api.DataAudit{Shards: 8, Epochs: []api.EpochAssignment{{Epoch: 1, Functions: api.SplitShards(8, 2)}}}
+	job.iterations.startEpoch(1, 2)
+	job.iterations.advance(func(int) {})
+	job.iterations.advance(func(int) {})
+
+	untrack := job.trackInvocation(1, Train)
+	table := job.Invocations()
+	if len(table.Invocations) != 1 {
+		t.Fatalf("got invocations %+v, want the train invocation", table.Invocations)
+	}
+	inv := table.Invocations[0]
+	if inv.Task != string(Train) || inv.Iteration != 2 || inv.Shards == nil || *inv.Shards != [2]int64{4, 8} ||
+		inv.Transport != api.InvocationModeQueue || inv.Attempt != 1 {
+		t.Errorf("got invocation %+v, want function 1 on shards [4, 8) in iteration 2 through the queue", inv)
+	}
+
+	// the merger moves on while the invocation runs
+	job.iterations.advance(func(int) {})
+	if inv := job.Invocations().Invocations[0]; inv.Iteration != 3 {
+		t.Errorf("got iteration %d, want 3", inv.Iteration)
+	}
+
+	untrack()
+	if table := job.Invocations(); len(table.Invocations) != 0 {
+		t.Errorf("got invocations %+v after it returned, want none", table.Invocations)
+	}
+	if got := job.active.concurrency(1); got != 1 {
+		t.Errorf("got concurrency %d, want the train invocation recorded", got)
+	}
+}
+
+func TestInvocationTableFull(t *testing.T) {
+	a := newActiveInvocations()
+	var keys []int64
+	for i := 0; i < maxTrackedInvocations+2; i++ {
+		keys = append(keys, a.add(api.InvocationStatus{FuncId: i, Task: string(Train), Epoch: 1, Start: time.Now()}))
+	}
+	if keys[maxTrackedInvocations-1] < 0 || keys[maxTrackedInvocations] >= 0 || keys[maxTrackedInvocations+1] >= 0 {
+		t.Fatalf("got keys %v past the limit, want them untracked", keys[maxTrackedInvocations-1:])
+	}
+
+	table := a.list("job", 0)
+	if len(table.Invocations) != maxTrackedInvocations || table.Untracked != 2 {
+		t.Errorf("got %d invocations and %d untracked, want %d and 2", len(table.Invocations), table.Untracked, maxTrackedInvocations)
+	}
+
+	// an untracked invocation that returns is no longer counted
+	a.remove(keys[maxTrackedInvocations])
+	a.remove(keys[0])
+	if table := a.list("job", 0); len(table.Invocations) != maxTrackedInvocations-1 || table.Untracked != 1 {
+		t.Errorf("got %d invocations and %d untracked, want %d and 1", len(table.Invocations), table.Untracked, maxTrackedInvocations-1)
+	}
+}
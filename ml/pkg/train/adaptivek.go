@@ -0,0 +1,49 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+)
+
+// adaptK records the K of the epoch and the overhead of its merges, and with the
+// adaptive K sets the K of the next epoch from the overhead. The compute time is the
+// time the train functions of the epoch ran minus the time of the merges, so it also
+// counts the time the functions waited at the barrier for the slowest one
+func (job *TrainJob) adaptK() {
+	overhead := api.MergeOverhead(job.task.Job.State.ElapsedTime, job.mergeTime.Seconds())
+	job.setEpochMetrics(map[string]float64{
+		api.MetricK:             float64(job.K),
+		api.MetricMergeOverhead: overhead,
+	})
+
+	opts := job.task.Parameters.Options
+	if !opts.AdaptiveK {
+		return
+	}
+
+	next := api.NextK(job.K, overhead, opts.KTargetOverheadOrDefault(), opts.MaxKOrDefault())
+	if next == job.K {
+		job.logger.Debug("Keeping K",
+			zap.Int("K", job.K),
+			zap.Float64("overhead", overhead))
+		return
+	}
+	job.logger.Info("Adapting K to the merge overhead",
+		zap.Int("epoch", job.epoch),
+		zap.Int("from", job.K),
+		zap.Int("to", next),
+		zap.Float64("overhead", overhead),
+		zap.Float64("target", opts.KTargetOverheadOrDefault()))
+	job.K = next
+}
+
+// restoreK continues with the K of the last epoch of the history of the job, so
+// a continued job with the adaptive K does not start over from the requested one
+func (job *TrainJob) restoreK() {
+	if !job.task.Parameters.Options.AdaptiveK {
+		return
+	}
+	if n := len(job.history.K); n > 0 && job.history.K[n-1] >= 1 {
+		job.K = int(job.history.K[n-1])
+	}
+}
@@ -0,0 +1,209 @@
+package train
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// allReduceTimeout is the longest the merger waits for the functions
+// of an allreduce iteration to average the layers assigned to them
+var allReduceTimeout = 10 * time.Minute
+
+// allReduceRound holds the allreduce merge in progress, the layers assigned to each
+// function and the functions that did not average them yet. The merger starts and
+// ends the rounds, and the api handlers and the invocations report to them
+type allReduceRound struct {
+	mu          sync.Mutex
+	epoch       int
+	iteration   int
+	assignments map[int]api.AllReduceAssignment
+	pending     map[int]bool
+	reduced     chan *finishNotification
+}
+
+// start begins the round of the iteration and returns the channel the functions
+// report to once they averaged their layers. A nil response channel in the
+// notifications means the invocation of the function returned before
+func (r *allReduceRound) start(epoch, iteration int, assignments map[int]api.AllReduceAssignment) chan *finishNotification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.epoch, r.iteration = epoch, iteration
+	r.assignments = assignments
+	r.pending = make(map[int]bool, len(assignments))
+	for funcId := range assignments {
+		r.pending[funcId] = true
+	}
+	r.reduced = make(chan *finishNotification, len(assignments))
+	return r.reduced
+}
+
+// end forgets the round, so the late reports are rejected
+func (r *allReduceRound) end() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.assignments, r.pending, r.reduced = nil, nil, nil
+}
+
+// assignment returns the layers the function averages in the round
+func (r *allReduceRound) assignment(funcId int) (api.AllReduceAssignment, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, exists := r.assignments[funcId]
+	return a, exists
+}
+
+// reduce reports that the function averaged its layers in the iteration, and
+// returns the channel the merger answers on once all the functions did
+func (r *allReduceRound) reduce(funcId, epoch, iteration int) (chan MergeResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pending == nil || epoch != r.epoch || iteration != r.iteration {
+		return nil, errors.Wrapf(errStaleNotification, "no allreduce in progress for epoch %d iteration %d", epoch, iteration)
+	}
+	if !r.pending[funcId] {
+		return nil, errors.Wrapf(errStaleNotification, "function %d is not averaging layers in iteration %d", funcId, iteration)
+	}
+	delete(r.pending, funcId)
+
+	respChan := make(chan MergeResult, 1)
+	r.reduced <- &finishNotification{funcId: funcId, respChan: respChan, arrived: time.Now()}
+	return respChan, nil
+}
+
+// abandon reports that the invocation of the function returned, if it
+// still had to average its layers, so the merger does not wait for it
+func (r *allReduceRound) abandon(funcId int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending[funcId] {
+		delete(r.pending, funcId)
+		r.reduced <- &finishNotification{funcId: funcId}
+	}
+}
+
+// allReduceMerge runs the merge of an iteration of a job with the allreduce topology.
+// The functions that reported are answered with the layers each averages, balanced by
+// their size, and the merge is done once all of them saved their part of the reference
+// model. The iteration is then advanced and the functions load the reference model
+func (job *TrainJob) allReduceMerge(funcs []int, channels []chan MergeResult) error {
+	layers := api.AssignLayers(job.summary.Layers, len(funcs))
+	weights := make([]float64, len(funcs))
+	for i, funcId := range funcs {
+		weights[i] = job.mergeWeight(funcId)
+	}
+	assignments := make(map[int]api.AllReduceAssignment, len(funcs))
+	for i, funcId := range funcs {
+		assignments[funcId] = api.AllReduceAssignment{Layers: layers[i], Funcs: funcs, Weights: weights}
+	}
+
+	start := time.Now()
+	reduced := job.allReduce.start(job.epoch, job.iterations.current(), assignments)
+	defer job.allReduce.end()
+	answerFunctions(MergeReduce, channels)
+
+	timer := time.NewTimer(allReduceTimeout)
+	defer timer.Stop()
+
+	var done []chan MergeResult
+	for len(done) < len(funcs) {
+		select {
+		case msg := <-reduced:
+			if msg.respChan == nil {
+				answerFunctions(MergeFailed, done)
+				return fmt.Errorf("function %d exited before averaging its layers", msg.funcId)
+			}
+			done = append(done, msg.respChan)
+		case <-timer.C:
+			answerFunctions(MergeFailed, done)
+			return fmt.Errorf("only %d of the %d functions averaged their layers in %v",
+				len(done), len(funcs), allReduceTimeout)
+		}
+	}
+
+	job.merges++
+	job.mergeTime += time.Since(start)
+	job.logger.Debug("Allreduce took", zap.Float64("time", time.Since(start).Seconds()))
+
+	// no function finished its data, so all of them run the next iteration
+	job.iterations.advance(func(remaining int) {
+		if remaining > 0 {
+			job.wgIteration.Add(remaining)
+		}
+	})
+	answerFunctions(MergeSucceeded, done)
+	return nil
+}
+
+// loadAllReduceModels loads the layers of the functions of an allreduce job that
+// wait for the merge, which are not loaded when they report. It is called for the
+// merges that take functions that finished their data, which the job makes itself
+func (job *TrainJob) loadAllReduceModels(funcs []int, channels []chan MergeResult) {
+	for i, funcId := range funcs {
+		if channels[i] != nil {
+			job.model.Update(funcId, job.mergeWeight(funcId), nil)
+		}
+	}
+}
+
+// reducedIteration receives the functions of an allreduce job once they saved the
+// layers assigned to them, and answers them once all the functions of the iteration
+// did, after which they load the reference model
+func (job *TrainJob) reducedIteration(w http.ResponseWriter, r *http.Request) {
+	funcId, err := strconv.Atoi(mux.Vars(r)["funcId"])
+	if err != nil {
+		http.Error(w, "invalid function id", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	epoch, err := strconv.Atoi(query.Get("epoch"))
+	if err != nil {
+		http.Error(w, "invalid epoch", http.StatusBadRequest)
+		return
+	}
+	iteration, err := strconv.Atoi(query.Get("iteration"))
+	if err != nil {
+		http.Error(w, "invalid iteration", http.StatusBadRequest)
+		return
+	}
+
+	respChan, err := job.allReduce.reduce(funcId, epoch, iteration)
+	if err != nil {
+		job.logger.Warn("Discarding allreduce notification",
+			zap.Int("funcId", funcId),
+			zap.Int("epoch", epoch),
+			zap.Int("iteration", iteration),
+			zap.Error(err))
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if result := <-respChan; result != MergeSucceeded {
+		http.Error(w, "error merging model", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// respondAssignment answers a function of an allreduce job with the layers it averages
+func (job *TrainJob) respondAssignment(w http.ResponseWriter, funcId int) {
+	assignment, exists := job.allReduce.assignment(funcId)
+	if !exists {
+		http.Error(w, fmt.Sprintf("function %d has no layers assigned", funcId), http.StatusInternalServerError)
+		return
+	}
+	resp, _ := json.Marshal(assignment)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
@@ -0,0 +1,237 @@
+package train
+
+import (
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAllReduceRound(t *testing.T) {
+	r := &allReduceRound{}
+	if _, err := r.reduce(0, 1, 0); errors.Cause(err) != errStaleNotification {
+		t.Errorf("got error %v before the round started, want a stale notification", err)
+	}
+
+	reduced := r.start(1, 3, map[int]api.AllReduceAssignment{
+		0: {Layers: []string{"fc1.weight"}},
+		1: {Layers: []string{"conv1.weight"}},
+	})
+	if a, exists := r.assignment(1); !exists || len(a.Layers) != 1 || a.Layers[0] != "conv1.weight" {
+		t.Errorf("got assignment %+v, want conv1.weight", a)
+	}
+	if _, exists := r.assignment(2); exists {
+		t.Error("got an assignment for a function not in the round")
+	}
+
+	tests := []struct {
+		name      string
+		funcId    int
+		epoch     int
+		iteration int
+	}{
+		{"previous epoch", 0, 0, 3},
+		{"previous iteration", 0, 1, 2},
+		{"function not in the round", 2, 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := r.reduce(tt.funcId, tt.epoch, tt.iteration); errors.Cause(err) != errStaleNotification {
+				t.Errorf("got error %v, want a stale notification", err)
+			}
+		})
+	}
+
+	respChan, err := r.reduce(0, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-reduced; msg.funcId != 0 || msg.respChan != respChan {
+		t.Errorf("got notification of function %d, want function 0 with its response channel", msg.funcId)
+	}
+	if _, err := r.reduce(0, 1, 3); errors.Cause(err) != errStaleNotification {
+		t.Errorf("got error %v reducing twice, want a stale notification", err)
+	}
+
+	// the invocations that return after reducing do not report again
+	r.abandon(0)
+	r.abandon(1)
+	if msg := <-reduced; msg.funcId != 1 || msg.respChan != nil {
+		t.Errorf("got notification %+v, want function 1 exited", msg)
+	}
+	if len(reduced) != 0 {
+		t.Errorf("got %d notifications left, want none", len(reduced))
+	}
+	if _, err := r.reduce(1, 1, 3); errors.Cause(err) != errStaleNotification {
+		t.Errorf("got error %v after the function exited, want a stale notification", err)
+	}
+
+	r.end()
+	if _, exists := r.assignment(0); exists {
+		t.Error("got an assignment after the round ended")
+	}
+	if _, err := r.reduce(1, 1, 3); errors.Cause(err) != errStaleNotification {
+		t.Errorf("got error %v after the round ended, want a stale notification", err)
+	}
+	r.abandon(1)
+}
+
+// newAllReduceTestJob returns a job in the first iteration of its
+// epoch, with n functions and a model of four layers
+func newAllReduceTestJob(n int) *TrainJob {
+	job := &TrainJob{
+		logger:      zap.NewNop(),
+		task:        &api.TrainTask{},
+		epoch:       1,
+		summary:     &api.ModelSummary{},
+		devices:     newDeviceCounts(2),
+		allReduce:   &allReduceRound{},
+		iterations:  newIterationState(),
+		wgIteration: &sync.WaitGroup{},
+	}
+	for i, bytes := range []int64{400, 300, 200, 100} {
+		job.summary.Layers = append(job.summary.Layers, api.LayerSummary{Name: fmt.Sprintf("layer%d", i), Bytes: bytes})
+	}
+	job.iterations.startEpoch(1, n)
+	return job
+}
+
+// reduceLayers starts a merge of the functions as the merger does, and returns the
+// channels of the functions, answered once the merge asks them to reduce, and the
+// result of the merge
+func reduceLayers(job *TrainJob, funcs []int) ([]chan MergeResult, chan error) {
+	channels := make([]chan MergeResult, len(funcs))
+	for i := range channels {
+		channels[i] = make(chan MergeResult, 1)
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- job.allReduceMerge(funcs, channels) }()
+	return channels, errs
+}
+
+// postReduced reports that the function saved its layers in the iteration,
+// and returns the status it is answered with once the merge is done
+func postReduced(job *TrainJob, funcId, epoch, iteration int) int {
+	url := fmt.Sprintf("/reduced/%d?epoch=%d&iteration=%d", funcId, epoch, iteration)
+	w := httptest.NewRecorder()
+	job.GetHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, url, nil))
+	return w.Code
+}
+
+func TestAllReduceMerge(t *testing.T) {
+	job := newAllReduceTestJob(2)
+	// function 1 trains on one of the two devices requested
+	job.devices.report(1, 1)
+
+	channels, errs := reduceLayers(job, []int{0, 1})
+	for i, ch := range channels {
+		if result := <-ch; result != MergeReduce {
+			t.Fatalf("got result %v for function %d, want it to reduce", result, i)
+		}
+	}
+
+	// the layers are balanced by their size, and averaged over the weights of the functions
+	want := map[int][]string{0: {"layer0", "layer3"}, 1: {"layer1", "layer2"}}
+	for funcId, layers := range want {
+		a, exists := job.allReduce.assignment(funcId)
+		if !exists || fmt.Sprint(a.Layers) != fmt.Sprint(layers) {
+			t.Errorf("got layers %v for function %d, want %v", a.Layers, funcId, layers)
+		}
+		if fmt.Sprint(a.Funcs) != "[0 1]" || fmt.Sprint(a.Weights) != "[1 0.5]" {
+			t.Errorf("got functions %v and weights %v, want [0 1] and [1 0.5]", a.Funcs, a.Weights)
+		}
+	}
+
+	// the notifications of another iteration and the invalid ones are rejected
+	if code := postReduced(job, 0, 1, 1); code != http.StatusConflict {
+		t.Errorf("got status %d for the next iteration, want 409", code)
+	}
+	w := httptest.NewRecorder()
+	job.GetHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/reduced/0?epoch=1&iteration=last", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for an invalid iteration, want 400", w.Code)
+	}
+
+	codes := make(chan int, 2)
+	for _, funcId := range []int{0, 1} {
+		go func(funcId int) { codes <- postReduced(job, funcId, 1, 0) }(funcId)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("got status %d, want 200", code)
+		}
+	}
+
+	if job.iterations.current() != 1 || job.merges != 1 {
+		t.Errorf("got iteration %d after %d merges, want iteration 1 after 1", job.iterations.current(), job.merges)
+	}
+	// the iteration waits for both functions again
+	job.wgIteration.Done()
+	job.wgIteration.Done()
+	job.wgIteration.Wait()
+
+	// the round ended with the merge
+	if code := postReduced(job, 0, 1, 0); code != http.StatusConflict {
+		t.Errorf("got status %d after the merge, want 409", code)
+	}
+}
+
+func TestAllReduceMergeFailed(t *testing.T) {
+	defer func(timeout time.Duration) { allReduceTimeout = timeout }(allReduceTimeout)
+	allReduceTimeout = 100 * time.Millisecond
+
+	tests := []struct {
+		name string
+		// exit makes function 1 return before averaging its layers
+		exit bool
+	}{
+		{"function exited", true},
+		{"timeout", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := newAllReduceTestJob(2)
+			channels, errs := reduceLayers(job, []int{0, 1})
+			for _, ch := range channels {
+				<-ch
+			}
+
+			codes := make(chan int, 1)
+			go func() { codes <- postReduced(job, 0, 1, 0) }()
+			if tt.exit {
+				// function 1 exits once function 0 reduced
+				for pending := true; pending; {
+					job.allReduce.mu.Lock()
+					pending = job.allReduce.pending[0]
+					job.allReduce.mu.Unlock()
+					time.Sleep(time.Millisecond)
+				}
+				job.allReduce.abandon(1)
+			}
+
+			if err := <-errs; err == nil {
+				t.Fatal("got no error from the merge")
+			}
+			if code := <-codes; code != http.StatusInternalServerError {
+				t.Errorf("got status %d for the function that reduced, want 500", code)
+			}
+			if job.iterations.current() != 0 || job.merges != 0 {
+				t.Errorf("got iteration %d after %d merges, want the iteration kept", job.iterations.current(), job.merges)
+			}
+
+			// the function reporting late is rejected
+			if code := postReduced(job, 1, 1, 0); code != http.StatusConflict {
+				t.Errorf("got status %d after the merge failed, want 409", code)
+			}
+		})
+	}
+}
@@ -4,18 +4,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 // finishNotification is received by the merger
 // to know which functions to take into account
+// finishNotification is sent when a function finishes an iteration, arrived
+// is when the functions that wait for the merge started blocking for it
 type finishNotification struct {
 	funcId   int
 	respChan chan MergeResult
+	arrived  time.Time
 }
 
 type MergeResult int
@@ -23,6 +29,8 @@ type MergeResult int
 const (
 	MergeSucceeded MergeResult = iota
 	MergeFailed
+	// MergeReduce tells the functions of an allreduce job to average their layers
+	MergeReduce
 )
 
 // startTask receives the task description from the parameter server and starts
@@ -66,7 +74,7 @@ func (job *TrainJob) startTask(w http.ResponseWriter, r *http.Request) {
 
 // updateTask receives updates from the scheduler with new parameters such as
 // parallelism to be applied in the new epochs
-func (job TrainJob) updateTask(w http.ResponseWriter, r *http.Request) {
+func (job *TrainJob) updateTask(w http.ResponseWriter, r *http.Request) {
 
 	job.logger.Debug("Updating task")
 
@@ -88,35 +96,113 @@ func (job TrainJob) updateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	job.schedulerCh <- &state
-	w.WriteHeader(http.StatusOK)
-
-
-
+	// the job stops waiting for the update after the timeout, in that
+	// case the update is dropped instead of blocking the request
+	var opts api.TrainOptions
+	if task := job.state.Task(); task != nil {
+		opts = task.Parameters.Options
+	}
+	select {
+	case job.schedulerCh <- &state:
+		w.WriteHeader(http.StatusOK)
+	case <-time.After(opts.UpdateTimeout()):
+		job.logger.Error("Timed out sending the update, the job is not waiting for it")
+		http.Error(w, "job is not waiting for an update", http.StatusGatewayTimeout)
+	}
 }
 
 // nextIteration receives updates from the functions, and waits for all of the
-// functions to complete the current iteration,
+// functions to complete the current iteration.
+//
+// The functions send the epoch, the iteration and the token of their invocation,
+// and only the notifications of the current iteration are merged. Stale ones are
+// answered with a conflict so the function discards its work and re-syncs
 func (job *TrainJob) nextIteration(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	funcId, _ := strconv.Atoi(vars["funcId"])
+	funcId, err := strconv.Atoi(vars["funcId"])
+	if err != nil {
+		http.Error(w, "invalid function id", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	epoch, err := strconv.Atoi(query.Get("epoch"))
+	if err != nil {
+		http.Error(w, "invalid epoch", http.StatusBadRequest)
+		return
+	}
+	iteration, err := strconv.Atoi(query.Get("iteration"))
+	if err != nil {
+		http.Error(w, "invalid iteration", http.StatusBadRequest)
+		return
+	}
+
+	// the functions that train on several devices report how many they got
+	devices := 0
+	if d := query.Get("devices"); len(d) > 0 {
+		devices, err = strconv.Atoi(d)
+		if err != nil {
+			http.Error(w, "invalid devices", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = job.iterations.accept(funcId, epoch, iteration, query.Get("token"))
+	if errors.Cause(err) == errStaleNotification {
+		job.logger.Warn("Discarding stale finish notification",
+			zap.Int("funcId", funcId),
+			zap.Int("epoch", epoch),
+			zap.Int("iteration", iteration),
+			zap.Error(err))
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		job.logger.Error("Invalid finish notification",
+			zap.Int("funcId", funcId),
+			zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if devices > 0 {
+		job.devices.report(funcId, devices)
+	}
 
 	// communicate that this function has finished and wait for the
 	// merger to respond once finished
 	respChan := make(chan MergeResult, 1)
-	job.finishCh <- &finishNotification{funcId, respChan}
+	job.finishes.push(&finishNotification{funcId: funcId, respChan: respChan, arrived: time.Now()})
 
-	// trigger model update
-	job.model.Update(funcId)
+	// trigger model update, with allreduce the layers are only loaded
+	// if the job makes the merge, see loadAllReduceModels. The handler
+	// runs outside of the training loop so it reads the published task
+	if task := job.state.Task(); task == nil || !task.Parameters.Options.AllReduce() {
+		job.model.Update(funcId, job.mergeWeight(funcId), job.mergedLayers(iteration))
+	}
 	job.wgIteration.Done()
 	result := <-respChan
 
 	switch result {
 	case MergeSucceeded:
 		job.logger.Debug("Continuing with next iteration", zap.Int("funcId", funcId))
+
+		// the functions that sync layer groups reload the groups they pushed
+		if job.layerGroups != nil {
+			resp, _ := json.Marshal(api.MergeResponse{Groups: job.layerGroups.Due(iteration)})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(resp)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		return
 
+	case MergeReduce:
+		job.logger.Debug("Averaging layers in the function", zap.Int("funcId", funcId))
+		job.respondAssignment(w, funcId)
+		return
+
 	case MergeFailed:
 		job.logger.Debug("merge failed, critical failure")
 		http.Error(w, "error merging model", http.StatusInternalServerError)
@@ -133,6 +219,166 @@ func (job *TrainJob) stop(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// pauseTask pauses the training task after the epoch in progress
+func (job *TrainJob) pauseTask(w http.ResponseWriter, r *http.Request) {
+	var req api.PauseRequest
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		job.logger.Error("Could not read request body",
+			zap.Error(err))
+		http.Error(w, "could not read request body", http.StatusInternalServerError)
+		return
+	}
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "could not unmarshal pause request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err = job.Pause(req); err != nil {
+		respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// resumeTask resumes the paused training task
+func (job *TrainJob) resumeTask(w http.ResponseWriter, r *http.Request) {
+	if err := job.Resume(); err != nil {
+		respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// respondError answers with the error and its status code if it has one
+func respondError(w http.ResponseWriter, err error) {
+	e, ok := err.(kerror.Error)
+	if !ok {
+		e = kerror.New(http.StatusInternalServerError, err.Error())
+	}
+	kerror.RespondWithError(w, e)
+}
+
+// receiveResult receives the response that a function invoked through the queue
+// posts when it finishes, with the status code of the response in the query
+func (job *TrainJob) receiveResult(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["invocationId"]
+
+	queue, ok := job.invoker.(*queueInvoker)
+	if !ok {
+		http.Error(w, "job does not invoke functions through the queue", http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusOK
+	if s := r.URL.Query().Get("status"); len(s) > 0 {
+		code, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "invalid status", http.StatusBadRequest)
+			return
+		}
+		status = code
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		job.logger.Error("Could not read result body", zap.Error(err))
+		http.Error(w, "could not read request body", http.StatusInternalServerError)
+		return
+	}
+
+	if !queue.deliver(id, status, r.Header.Get("Content-Type"), body) {
+		job.logger.Warn("Dropping result of unknown or expired invocation",
+			zap.String("invocation", id))
+		http.Error(w, "invocation is not waiting for a result", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// modelSummary returns the layers of the model of the job, read once when
+// it was built, so the weights are not fetched from redis
+func (job *TrainJob) modelSummary(w http.ResponseWriter, r *http.Request) {
+	summary := job.state.Summary()
+	if summary == nil {
+		http.Error(w, "the model of the job is not built yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	resp, err := json.Marshal(summary)
+	if err != nil {
+		job.logger.Error("Could not marshal model summary", zap.Error(err))
+		http.Error(w, "error marshaling summary", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// status returns the task and the history of the job last published by
+// the training loop, so it can be polled while the job trains
+func (job *TrainJob) status(w http.ResponseWriter, r *http.Request) {
+	task, history := job.State()
+	if task == nil {
+		http.Error(w, "the job has not started its task yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	resp, err := json.Marshal(api.History{
+		Id:         job.jobId,
+		Task:       task.Redacted().Parameters,
+		Data:       *history,
+		InProgress: true,
+	})
+	if err != nil {
+		job.logger.Error("Could not marshal status", zap.Error(err))
+		http.Error(w, "error marshaling status", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// invocations returns the invocations of the functions of the job in flight
+func (job *TrainJob) invocations(w http.ResponseWriter, r *http.Request) {
+	resp, err := json.Marshal(job.Invocations())
+	if err != nil {
+		job.logger.Error("Could not marshal invocations", zap.Error(err))
+		http.Error(w, "error marshaling invocations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// snapshot returns the last snapshot of the model published by the job,
+// which the controller routes the inferences on the running job to
+func (job *TrainJob) snapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := job.LatestSnapshot()
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	resp, err := json.Marshal(snapshot)
+	if err != nil {
+		job.logger.Error("Could not marshal snapshot", zap.Error(err))
+		http.Error(w, "error marshaling snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
 
 func (job *TrainJob) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
@@ -143,7 +389,16 @@ func (job *TrainJob) GetHandler() http.Handler {
 	r.HandleFunc("/start", job.startTask).Methods("POST")
 	r.HandleFunc("/update", job.updateTask).Methods("POST")
 	r.HandleFunc("/next/{funcId}", job.nextIteration).Methods("POST")
+	r.HandleFunc("/reduced/{funcId}", job.reducedIteration).Methods("POST")
+	r.HandleFunc("/results/{invocationId}", job.receiveResult).Methods("POST")
 	r.HandleFunc("/stop", job.stop).Methods("DELETE")
+	r.HandleFunc("/pause", job.pauseTask).Methods("POST")
+	r.HandleFunc("/resume", job.resumeTask).Methods("POST")
+	r.Handle("/loglevel", job.level).Methods("GET", "PUT")
+	r.HandleFunc("/model/summary", job.modelSummary).Methods("GET")
+	r.HandleFunc("/status", job.status).Methods("GET")
+	r.HandleFunc("/invocations", job.invocations).Methods("GET")
+	r.HandleFunc("/snapshot", job.snapshot).Methods("GET")
 	r.HandleFunc("/health", job.handleHealth).Methods("GET")
 	return r
 }
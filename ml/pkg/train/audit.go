@@ -0,0 +1,122 @@
+package train
+
+import (
+	"context"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// newDataAudit creates the audit record of the data used by the job
+func newDataAudit(jobId string, req api.TrainRequest) *api.DataAudit {
+	scheme := api.AuditSchemeContiguous
+	if req.Options.ShuffleSeed != 0 {
+		scheme = api.AuditSchemeShuffled
+	}
+
+	return &api.DataAudit{
+		JobId:     jobId,
+		Dataset:   req.Dataset,
+		Shards:    req.DatasetShards,
+		ShardSize: api.DatasetShardSize,
+		Scheme:    scheme,
+		Seed:      req.Options.ShuffleSeed,
+	}
+}
+
+// recordDataAssignment adds the data assigned to the functions in the current
+// epoch to the audit of the job. The assignment is derived the same way the
+// functions split the dataset, so it is only known if the shards were counted
+func (job *TrainJob) recordDataAssignment() {
+	if job.audit == nil {
+		return
+	}
+
+	var functions []api.FunctionAssignment
+	switch {
+	case job.audit.Shards > 0 && len(job.shares) > 0:
+		functions = api.SplitShardsByShares(job.audit.Shards, job.shares)
+	case job.audit.Shards > 0:
+		functions = api.SplitShards(job.audit.Shards, job.parallelism)
+	default:
+		job.logger.Warn("Unknown number of shards, the audit only records the seeds of the functions")
+		functions = make([]api.FunctionAssignment, job.parallelism)
+		for i := range functions {
+			functions[i].FuncId = i
+		}
+	}
+	for i := range functions {
+		functions[i].Seed = api.FunctionSeed(job.audit.Seed, job.epoch, job.parallelism, i)
+		if len(job.batchSizes) > 0 {
+			functions[i].BatchSize = job.functionBatchSize(i)
+		}
+	}
+
+	job.audit.Epochs = append(job.audit.Epochs, api.EpochAssignment{
+		Epoch:       job.epoch,
+		Parallelism: job.parallelism,
+		K:           job.K,
+		BatchSize:   job.task.Parameters.BatchSize,
+		Functions:   functions,
+	})
+}
+
+// saveAudit saves the audit record of the job in the database
+func (job *TrainJob) saveAudit() {
+	if job.audit == nil || len(job.audit.Epochs) == 0 {
+		return
+	}
+
+	if err := writeAudit(job.audit); err != nil {
+		job.logger.Error("Could not save the data audit in the database", zap.Error(err))
+		return
+	}
+	job.logger.Info("Saved data audit", zap.Int("epochs", len(job.audit.Epochs)))
+}
+
+// writeAudit replaces the audit record of the job in the database
+func writeAudit(audit *api.DataAudit) error {
+	client, err := mongo.NewClient(options.Client().ApplyURI(createMongoURI()))
+	if err != nil {
+		return errors.Wrap(err, "could not create mongo client")
+	}
+	if err = client.Connect(context.TODO()); err != nil {
+		return errors.Wrap(err, "could not connect to mongo")
+	}
+	defer client.Disconnect(context.TODO())
+
+	collection := client.Database(util.MongoDatabase()).Collection("audit")
+	_, err = collection.ReplaceOne(context.TODO(),
+		bson.M{"_id": audit.JobId}, audit, options.Replace().SetUpsert(true))
+	if err != nil {
+		return errors.Wrap(err, "could not write audit")
+	}
+	return nil
+}
+
+// readAudit returns the audit record of the job saved in the database, nil if there is none
+func readAudit(jobId string) (*api.DataAudit, error) {
+	client, err := mongo.NewClient(options.Client().ApplyURI(createMongoURI()))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create mongo client")
+	}
+	if err = client.Connect(context.TODO()); err != nil {
+		return nil, errors.Wrap(err, "could not connect to mongo")
+	}
+	defer client.Disconnect(context.TODO())
+
+	var audit api.DataAudit
+	collection := client.Database(util.MongoDatabase()).Collection("audit")
+	err = collection.FindOne(context.TODO(), bson.M{"_id": jobId}).Decode(&audit)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read audit")
+	}
+	return &audit, nil
+}
@@ -0,0 +1,98 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+)
+
+// updateBatch sets the batch and learning rate of the functions for the current
+// parallelism. Without a global batch the functions keep the batch of the request,
+// so the effective global batch changes with the parallelism and a warning is
+// logged. The learning rate is scaled with the effective global batch if enabled.
+//
+// The functions that train on several devices take the batch on each of them,
+// so the global batch is split among the devices of all the functions
+func (job *TrainJob) updateBatch() {
+	req := job.task.Parameters
+	devices := req.Options.FunctionDevices()
+	batch := req.FunctionBatchSize(job.parallelism * devices)
+	global := batch * job.parallelism * devices
+
+	if job.globalBatch > 0 && global != job.globalBatch {
+		if req.Options.GlobalBatchSize > 0 {
+			job.logger.Info("Function batch changed with the parallelism",
+				zap.Int("parallelism", job.parallelism),
+				zap.Int("batch", batch),
+				zap.Int("globalBatch", global))
+		} else {
+			job.logger.Warn("Effective global batch changed with the parallelism, set a global batch size to keep it",
+				zap.Int("parallelism", job.parallelism),
+				zap.Int("previous", job.globalBatch),
+				zap.Int("globalBatch", global))
+		}
+	}
+	if job.referenceBatch == 0 {
+		job.referenceBatch = global
+		if req.Options.GlobalBatchSize > 0 {
+			job.referenceBatch = req.Options.GlobalBatchSize
+		}
+	}
+
+	job.batchSize = batch
+	job.globalBatch = global
+	job.updateShares()
+	job.learningRate = req.LearningRate
+	if req.Options.ScaleLRWithParallelism {
+		job.learningRate = api.ScaledLearningRate(req.LearningRate, global, job.referenceBatch)
+	}
+}
+
+// updateShares splits the data and the global batch among the functions by the capacity
+// they reported in the previous epoch if the job balances them, see api.CapacityShares.
+// Otherwise the shares are cleared and every function gets the same data and batch
+func (job *TrainJob) updateShares() {
+	req := job.task.Parameters
+	if !req.Options.BalanceByCapacity {
+		job.shares, job.batchSizes = nil, nil
+		return
+	}
+
+	job.shares = api.CapacityShares(job.capacities, job.parallelism)
+	job.batchSizes = req.FunctionBatchSizes(job.parallelism, req.Options.FunctionDevices(), job.shares)
+	job.logger.Debug("Balanced the functions by capacity",
+		zap.Any("capacities", job.capacities),
+		zap.Float64s("shares", job.shares),
+		zap.Ints("batchSizes", job.batchSizes))
+}
+
+// functionBatchSize returns the batch the function trains with in the current epoch
+func (job *TrainJob) functionBatchSize(funcId int) int {
+	if funcId < len(job.batchSizes) {
+		return job.batchSizes[funcId]
+	}
+	return job.batchSize
+}
+
+// mergeWeight returns the weight of the model of the function in the merge, its share
+// of the data relative to an even split, so the average is weighted by the datapoints
+// each function trained on. It is 1 for every function unless the job balances them.
+//
+// The functions that train on fewer devices than requested take fewer samples in each
+// step, so their weight is also scaled by the devices they reported
+func (job *TrainJob) mergeWeight(funcId int) float64 {
+	weight := job.devices.weight(funcId)
+	if funcId < len(job.shares) {
+		weight *= job.shares[funcId] * float64(len(job.shares))
+	}
+	return weight
+}
+
+// recordBatch saves the effective global batch of the epoch in the
+// history, and the learning rate if it is scaled with the parallelism
+func (job *TrainJob) recordBatch() {
+	metrics := map[string]float64{api.MetricGlobalBatch: float64(job.globalBatch)}
+	if job.task.Parameters.Options.ScaleLRWithParallelism {
+		metrics[api.MetricLearningRate] = float64(job.learningRate)
+	}
+	job.setEpochMetrics(metrics)
+}
@@ -0,0 +1,89 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"math"
+	"testing"
+)
+
+func TestUpdateBatch(t *testing.T) {
+	type epoch struct {
+		parallelism int
+		batch       int
+		global      int
+		lr          float32
+	}
+	tests := []struct {
+		name   string
+		opts   api.TrainOptions
+		epochs []epoch
+	}{
+		{
+			name: "function batch",
+			opts: api.TrainOptions{},
+			epochs: []epoch{
+				{4, 32, 128, 0.1},
+				{8, 32, 256, 0.1},
+			},
+		},
+		{
+			// the learning rate is tuned for the batch of the first epoch
+			name: "function batch scaling the learning rate",
+			opts: api.TrainOptions{ScaleLRWithParallelism: true},
+			epochs: []epoch{
+				{4, 32, 128, 0.1},
+				{8, 32, 256, 0.2},
+				{2, 32, 64, 0.05},
+			},
+		},
+		{
+			name: "global batch",
+			opts: api.TrainOptions{GlobalBatchSize: 100},
+			epochs: []epoch{
+				{4, 25, 100, 0.1},
+				{3, 33, 99, 0.1},
+				{16, 8, 128, 0.1},
+			},
+		},
+		{
+			// the learning rate is tuned for the global batch asked, and follows the
+			// rounding and the minimum batch of the functions
+			name: "global batch scaling the learning rate",
+			opts: api.TrainOptions{GlobalBatchSize: 100, ScaleLRWithParallelism: true},
+			epochs: []epoch{
+				{3, 33, 99, 0.099},
+				{8, 13, 104, 0.104},
+				{16, 8, 128, 0.128},
+			},
+		},
+		{
+			// the global batch is split among the devices of all the functions
+			name: "global batch on several devices",
+			opts: api.TrainOptions{GlobalBatchSize: 128, DevicesPerFunction: 2},
+			epochs: []epoch{
+				{4, 16, 128, 0.1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &TrainJob{
+				logger: zap.NewNop(),
+				task:   &api.TrainTask{Parameters: api.TrainRequest{BatchSize: 32, LearningRate: 0.1, Options: tt.opts}},
+			}
+			for _, e := range tt.epochs {
+				job.parallelism = e.parallelism
+				job.updateBatch()
+				if job.batchSize != e.batch || job.globalBatch != e.global {
+					t.Errorf("parallelism %d: got batch %d and global batch %d, want %d and %d",
+						e.parallelism, job.batchSize, job.globalBatch, e.batch, e.global)
+				}
+				if math.Abs(float64(job.learningRate-e.lr)) > 1e-6 {
+					t.Errorf("parallelism %d: got learning rate %v, want %v", e.parallelism, job.learningRate, e.lr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/model"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+// initCheckpoints creates the object store of the checkpoints if the job uses one,
+// so a job with a misconfigured bucket fails before training instead of losing them
+func (job *TrainJob) initCheckpoints() error {
+	backend := job.task.Parameters.Options.CheckpointBackend
+	if !api.IsObjectStorage(backend) {
+		return nil
+	}
+
+	store, err := model.NewObjectStore(backend)
+	if err != nil {
+		return errors.Wrap(err, "could not create checkpoint store")
+	}
+	job.checkpoints = store
+	return nil
+}
+
+// saveCheckpoint uploads the reference model to the object store after an epoch.
+// The upload is retried, skipping the layers already uploaded, and redis still holds
+// the model used by the functions, so if it fails the job keeps training and the
+// last checkpoint is kept
+func (job *TrainJob) saveCheckpoint() {
+	if job.checkpoints == nil {
+		return
+	}
+
+	var manifest *api.CheckpointManifest
+	var err error
+	for attempt := 0; attempt <= maxSaveRetries; attempt++ {
+		if attempt > 0 {
+			job.logger.Warn("error saving checkpoint, retrying...",
+				zap.Int("attempt", attempt),
+				zap.Error(err))
+			time.Sleep(saveRetryBackoff * time.Duration(1<<uint(attempt-1)))
+		}
+
+		manifest, err = job.model.SaveCheckpoint(job.checkpoints, job.epoch)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		job.logger.Error("Could not save checkpoint",
+			zap.Int("epoch", job.epoch),
+			zap.Error(err))
+		return
+	}
+
+	job.history.CheckpointEpoch = job.epoch
+	job.logger.Info("Saved checkpoint",
+		zap.Int("epoch", job.epoch),
+		zap.String("storage", manifest.Storage))
+
+	job.savedCheckpoints = append(job.savedCheckpoints, manifest)
+	job.pruneCheckpoints()
+}
+
+// pruneCheckpoints deletes the checkpoints left out by the retention of the job.
+// The checkpoints that could not be deleted are kept to retry after the next one
+func (job *TrainJob) pruneCheckpoints() {
+	opts := job.task.Parameters.Options
+	epochs := make([]int, len(job.savedCheckpoints))
+	for i, m := range job.savedCheckpoints {
+		epochs[i] = m.Epoch
+	}
+	keep := opts.RetainedCheckpoints(epochs, &job.history)
+
+	var saved []*api.CheckpointManifest
+	for _, m := range job.savedCheckpoints {
+		if keep[m.Epoch] {
+			saved = append(saved, m)
+			continue
+		}
+
+		if err := model.DeleteCheckpoint(job.checkpoints, m); err != nil {
+			job.logger.Warn("Could not delete checkpoint",
+				zap.Int("epoch", m.Epoch),
+				zap.Error(err))
+			saved = append(saved, m)
+			continue
+		}
+		job.logger.Debug("Deleted checkpoint", zap.Int("epoch", m.Epoch))
+	}
+	job.savedCheckpoints = saved
+}
+
+// resume replaces the model created by the init function with the last
+// checkpoint of the job it resumes from, read from the backend of that job
+func (job *TrainJob) resume(layers []string) error {
+	opts := job.task.Parameters.Options
+
+	var tensors map[string]*model.Tensor
+	if api.IsObjectStorage(opts.ResumeBackend) {
+		store, err := model.NewObjectStore(opts.ResumeBackend)
+		if err != nil {
+			return errors.Wrap(err, "could not create checkpoint store")
+		}
+
+		manifest, err := model.LatestCheckpoint(store, opts.ResumeFrom)
+		if err != nil {
+			return errors.Wrap(err, "could not read checkpoint manifest")
+		}
+		job.logger.Info("Resuming from checkpoint",
+			zap.String("jobId", opts.ResumeFrom),
+			zap.Int("epoch", manifest.Epoch),
+			zap.String("storage", manifest.Storage))
+
+		tensors, err = model.CheckpointTensors(store, manifest)
+		if err != nil {
+			return errors.Wrap(err, "could not download checkpoint")
+		}
+	} else {
+		job.logger.Info("Resuming from reference model in redis",
+			zap.String("jobId", opts.ResumeFrom))
+
+		var err error
+		tensors, err = model.ReferenceTensors(model.NewRedisStore(job.redisPool), opts.ResumeFrom, layers)
+		if err != nil {
+			return errors.Wrap(err, "could not read reference model")
+		}
+	}
+
+	return job.model.Restore(tensors)
+}
@@ -0,0 +1,53 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/model"
+	"github.com/pkg/errors"
+	"strings"
+	"testing"
+)
+
+func TestSaveCheckpointRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		saved    bool
+	}{
+		{"first attempt", 0, true},
+		{"after retries", maxSaveRetries, true},
+		{"retries exhausted", maxSaveRetries + 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, _ := newSnapshotTestJob(t)
+			store := model.NewMemoryObjectStore()
+			job.checkpoints = store
+
+			// each attempt fails at the upload of its first layer
+			attempts := 0
+			store.Fault = func(op, key string) error {
+				if op == model.ObjectPut && strings.HasSuffix(key, "/fc1.bias") {
+					if attempts++; attempts <= tt.failures {
+						return errors.New("bucket unavailable")
+					}
+				}
+				return nil
+			}
+
+			job.saveCheckpoint()
+			if saved := job.history.CheckpointEpoch == 1; saved != tt.saved {
+				t.Fatalf("got checkpoint epoch %d, want saved %v", job.history.CheckpointEpoch, tt.saved)
+			}
+			if tt.saved != (len(job.savedCheckpoints) == 1) {
+				t.Errorf("got checkpoints %v kept by the job, want one only if saved", job.savedCheckpoints)
+			}
+
+			// a failed save leaves no manifest for the epoch
+			_, err := model.LatestCheckpoint(store, "job")
+			if tt.saved != (err == nil) {
+				t.Errorf("got error %v reading the latest checkpoint, want one only if not saved", err)
+			}
+		})
+	}
+}
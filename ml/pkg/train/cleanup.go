@@ -0,0 +1,143 @@
+package train
+
+import (
+	"context"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// cleanupScanCount is the hint of keys returned by every SCAN call
+	cleanupScanCount = 1000
+
+	// cleanupBatchSize is the number of keys deleted by every UNLINK
+	// command, the commands of a scan page are sent in one pipeline
+	cleanupBatchSize = 500
+)
+
+// clearTensors drops the keys used during training by the functions. The keys
+// are found with SCAN on the job prefix so redis is not blocked like with KEYS,
+// and deleted with pipelined UNLINK commands, which free the memory in the
+// background. It runs after the finish was reported to the parameter server,
+// so it records its result in the history of the job and closes the pool
+func (job *TrainJob) clearTensors() {
+	defer job.redisPool.Close()
+
+	start := time.Now()
+	num, err := deletePrefix(job.redisPool, job.jobId)
+	cleanup := &api.TensorCleanup{
+		Keys:     num,
+		Elapsed:  time.Since(start).Seconds(),
+		Finished: time.Now(),
+	}
+	if err != nil {
+		job.logger.Error("Error deleting database tensors",
+			zap.Int("deleted", num),
+			zap.Error(err))
+		cleanup.Error = err.Error()
+	} else if num == 0 {
+		job.logger.Warn("No tensors found in storage")
+	}
+	job.logger.Debug("Delete from the database",
+		zap.Int("num tensors", num),
+		zap.Float64("elapsed", cleanup.Elapsed))
+
+	if err = recordCleanup(job.jobId, cleanup); err != nil {
+		job.logger.Warn("Could not record the cleanup in the history", zap.Error(err))
+	}
+}
+
+// deletePrefix deletes the keys starting with the prefix and returns the number deleted.
+// Every page of the scan is deleted before asking for the next one, which is safe since
+// SCAN returns all the keys present during the whole iteration
+func deletePrefix(pool *redis.Pool, prefix string) (int, error) {
+	conn := pool.Get()
+	defer conn.Close()
+
+	var deleted int
+	cursor := "0"
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", prefix+"*", "COUNT", cleanupScanCount))
+		if err != nil {
+			return deleted, errors.Wrap(err, "could not scan tensors")
+		}
+
+		var keys []string
+		if _, err = redis.Scan(reply, &cursor, &keys); err != nil {
+			return deleted, errors.Wrap(err, "could not parse scan reply")
+		}
+
+		num, err := unlinkKeys(conn, keys)
+		deleted += num
+		if err != nil {
+			return deleted, err
+		}
+
+		if cursor == "0" {
+			return deleted, nil
+		}
+	}
+}
+
+// unlinkKeys sends the UNLINK commands of the keys in batches
+// in a single pipeline, and returns the number of keys deleted
+func unlinkKeys(conn redis.Conn, keys []string) (int, error) {
+	var batches int
+	for i := 0; i < len(keys); i += cleanupBatchSize {
+		end := i + cleanupBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := conn.Send("UNLINK", redis.Args{}.AddFlat(keys[i:end])...); err != nil {
+			return 0, errors.Wrap(err, "could not send unlink")
+		}
+		batches++
+	}
+	if batches == 0 {
+		return 0, nil
+	}
+
+	if err := conn.Flush(); err != nil {
+		return 0, errors.Wrap(err, "could not flush unlink pipeline")
+	}
+
+	// read all the replies so the connection is left clean
+	var deleted int
+	var firstErr error
+	for i := 0; i < batches; i++ {
+		num, err := redis.Int(conn.Receive())
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrap(err, "could not unlink tensors")
+		}
+		deleted += num
+	}
+	return deleted, firstErr
+}
+
+// recordCleanup sets the result of the cleanup in the history of the
+// job, if the job saved one. The history is not created otherwise
+func recordCleanup(jobId string, cleanup *api.TensorCleanup) error {
+	client, err := mongo.NewClient(options.Client().ApplyURI(createMongoURI()))
+	if err != nil {
+		return errors.Wrap(err, "could not create mongo client")
+	}
+	if err = client.Connect(context.TODO()); err != nil {
+		return errors.Wrap(err, "could not connect to mongo")
+	}
+	defer client.Disconnect(context.TODO())
+
+	collection := client.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	_, err = collection.UpdateOne(context.TODO(),
+		bson.M{"_id": jobId}, bson.M{"$set": bson.M{"cleanup": cleanup}})
+	if err != nil {
+		return errors.Wrap(err, "could not update history")
+	}
+	return nil
+}
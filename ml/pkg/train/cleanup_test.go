@@ -0,0 +1,114 @@
+package train
+
+import (
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"strings"
+	"testing"
+)
+
+// populate stores the tensors of a job with the layers, the reference model
+// and the model of every function, and returns the number of keys stored
+func populate(s *miniredis.Miniredis, jobId string, layers, funcs int) int {
+	keys := 0
+	for l := 0; l < layers; l++ {
+		s.Set(fmt.Sprintf("%s:fc%d.weight", jobId, l), "tensor")
+		keys++
+		for f := 0; f < funcs; f++ {
+			s.Set(fmt.Sprintf("%s:fc%d.weight/%d", jobId, l, f), "tensor")
+			keys++
+		}
+	}
+	return keys
+}
+
+func TestDeletePrefix(t *testing.T) {
+	pool, s := newTestPool(t)
+
+	// more keys than returned by a scan page and deleted by an unlink
+	want := populate(s, "a1b2c3d4", 100, 24)
+	other := populate(s, "e5f6a7b8", 2, 2)
+
+	deleted, err := deletePrefix(pool, "a1b2c3d4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != want {
+		t.Errorf("got %d keys deleted, want %d", deleted, want)
+	}
+	for _, key := range s.Keys() {
+		if strings.HasPrefix(key, "a1b2c3d4") {
+			t.Errorf("got key %s left after the cleanup", key)
+		}
+	}
+	if len(s.Keys()) != other {
+		t.Errorf("got %d keys of the other job, want %d", len(s.Keys()), other)
+	}
+
+	// cleaning up again finds nothing to delete
+	if deleted, err = deletePrefix(pool, "a1b2c3d4"); err != nil || deleted != 0 {
+		t.Errorf("got %d keys deleted and error %v cleaning up twice, want none", deleted, err)
+	}
+}
+
+func TestDeletePrefixUnreachable(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := s.Addr()
+	s.Close()
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+	}
+	defer pool.Close()
+
+	if _, err = deletePrefix(pool, "a1b2c3d4"); err == nil {
+		t.Error("got no error, want the scan to fail")
+	}
+}
+
+func TestUnlinkKeys(t *testing.T) {
+	pool, s := newTestPool(t)
+	populate(s, "a1b2c3d4", 1, 2*cleanupBatchSize)
+	conn := pool.Get()
+	defer conn.Close()
+
+	// the keys that do not exist are not counted
+	keys := append(s.Keys(), "a1b2c3d4:missing")
+	deleted, err := unlinkKeys(conn, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != len(keys)-1 || len(s.Keys()) != 0 {
+		t.Errorf("got %d keys deleted and %d left, want %d deleted", deleted, len(s.Keys()), len(keys)-1)
+	}
+
+	// every reply of the pipeline was read, so the connection can still be used
+	if _, err = conn.Do("PING"); err != nil {
+		t.Errorf("got error %v using the connection after the pipeline", err)
+	}
+	if deleted, err = unlinkKeys(conn, nil); err != nil || deleted != 0 {
+		t.Errorf("got %d keys deleted and error %v with no keys, want none", deleted, err)
+	}
+}
+
+func BenchmarkDeletePrefix(b *testing.B) {
+	for _, layers := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("%d keys", layers*17), func(b *testing.B) {
+			pool, s := newTestPool(b)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				populate(s, "a1b2c3d4", layers, 16)
+				b.StartTimer()
+
+				if _, err := deletePrefix(pool, "a1b2c3d4"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"io/ioutil"
@@ -53,6 +54,39 @@ func (c *Client) Stop(task *api.TrainTask) error {
 	return nil
 }
 
+// Pause pauses the running task after the epoch in progress
+func (c *Client) Pause(task *api.TrainTask, pause api.PauseRequest) error {
+	svcName := task.Job.Svc.Name
+	url := fmt.Sprintf("http://%v/pause", svcName)
+
+	body, err := json.Marshal(pause)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal pause request")
+	}
+
+	resp, err := c.httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not pause task")
+	}
+
+	// keep the status code so the parameter server can forward it
+	return kerror.CheckFunctionError(resp)
+}
+
+// Resume resumes the paused task
+func (c *Client) Resume(task *api.TrainTask) error {
+	svcName := task.Job.Svc.Name
+	url := fmt.Sprintf("http://%v/resume", svcName)
+
+	resp, err := c.httpClient.Post(url, "application/json", nil)
+	if err != nil {
+		return errors.Wrap(err, "could not resume task")
+	}
+
+	// keep the status code so the parameter server can forward it
+	return kerror.CheckFunctionError(resp)
+}
+
 // UpdateTask sends the updated parameters to the TrainJob
 func (c *Client) UpdateTask(task *api.TrainTask, update api.JobState) error {
 	svcName := task.Job.Svc.Name
@@ -105,3 +139,77 @@ func (c *Client) StartTask(task *api.TrainTask) error {
 
 	return nil
 }
+
+// Invocations returns the invocations of the functions of the task in flight
+func (c *Client) Invocations(task *api.TrainTask) (*api.InvocationTable, error) {
+	svcName := task.Job.Svc.Name
+	url := fmt.Sprintf("http://%v/invocations", svcName)
+
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get invocations")
+	}
+	if err = kerror.CheckFunctionError(resp); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var table api.InvocationTable
+	if err = json.NewDecoder(resp.Body).Decode(&table); err != nil {
+		return nil, errors.Wrap(err, "could not decode invocations")
+	}
+	return &table, nil
+}
+
+// Snapshot returns the last snapshot of the model published by the running task
+func (c *Client) Snapshot(task *api.TrainTask) (*api.LiveSnapshot, error) {
+	svcName := task.Job.Svc.Name
+	url := fmt.Sprintf("http://%v/snapshot", svcName)
+
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get snapshot")
+	}
+	if err = kerror.CheckFunctionError(resp); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var snapshot api.LiveSnapshot
+	if err = json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return nil, errors.Wrap(err, "could not decode snapshot")
+	}
+	return &snapshot, nil
+}
+
+// SetLogLevel changes the log level of the running task
+func (c *Client) SetLogLevel(task *api.TrainTask, level string) error {
+	svcName := task.Job.Svc.Name
+	url := fmt.Sprintf("http://%v/loglevel", svcName)
+
+	body, err := json.Marshal(api.LogLevelRequest{Level: level})
+	if err != nil {
+		return errors.Wrap(err, "could not marshal log level")
+	}
+
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not create request body")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not set log level")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		res, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return errors.New(string(res))
+	}
+	return nil
+}
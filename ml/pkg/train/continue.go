@@ -0,0 +1,73 @@
+package train
+
+import (
+	"context"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// continues returns whether the job continues a completed run in place
+func (job *TrainJob) continues() bool {
+	return len(job.task.Parameters.Options.ContinueFrom) > 0
+}
+
+// loadContinuedHistory loads the history of the completed run the job continues,
+// so the new epochs are numbered after its last one and appended to its history.
+// The model was already restored from the last checkpoint of the run
+func (job *TrainJob) loadContinuedHistory() error {
+	client, err := mongo.NewClient(options.Client().ApplyURI(createMongoURI()))
+	if err != nil {
+		return errors.Wrap(err, "could not create mongo client")
+	}
+	if err = client.Connect(context.TODO()); err != nil {
+		return errors.Wrap(err, "could not connect to mongo")
+	}
+	defer client.Disconnect(context.TODO())
+
+	var history api.History
+	collection := client.Database(util.MongoDatabase()).Collection(util.HistoryCollection())
+	err = collection.FindOne(context.TODO(), bson.M{"_id": job.jobId}).Decode(&history)
+	if err != nil {
+		return errors.Wrap(err, "could not read the history of the job")
+	}
+	history.Migrate()
+
+	// the capabilities were just reported by the init function, and the
+	// stop rule and quiet epoch of the previous run do not apply anymore
+	capabilities := job.history.Capabilities
+	job.history = history.Data
+	job.history.Capabilities = capabilities
+	job.history.StoppedBy = ""
+	job.history.QuietEpoch = 0
+
+	trained := job.history.Epochs()
+	job.history.ContinuedEpochs = append(job.history.ContinuedEpochs, trained+1)
+	job.restoreK()
+
+	job.logger.Info("Continuing completed job",
+		zap.Int("trained", trained),
+		zap.Int("epochs", job.task.Parameters.Epochs))
+	return nil
+}
+
+// loadContinuedAudit adds the epochs audited in the run the job continues
+// to its audit, since the audit of the job is replaced when it is saved
+func (job *TrainJob) loadContinuedAudit() {
+	if job.audit == nil || !job.continues() {
+		return
+	}
+
+	audit, err := readAudit(job.jobId)
+	if err != nil {
+		job.logger.Warn("Could not read the audit of the continued run", zap.Error(err))
+		return
+	}
+	if audit != nil {
+		job.audit.Epochs = audit.Epochs
+	}
+}
@@ -0,0 +1,56 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"go.uber.org/zap"
+)
+
+// recordEpochCost saves the function seconds of the current epoch in the history.
+// If they go over the max epoch cost of the job the epoch is flagged and the
+// parallelism of the next epoch is lowered in proportion, on top of the decision of
+// the scheduler. It is called again for the last epoch after the final validation
+func (job *TrainJob) recordEpochCost() {
+	epoch := job.currentEpoch()
+	cost := job.active.cost(epoch)
+	job.setEpochMetrics(map[string]float64{api.MetricEpochCost: cost})
+
+	opts := job.task.Parameters.Options
+	if !opts.OverEpochCost(cost) {
+		return
+	}
+	if n := len(job.history.ExpensiveEpochs); n > 0 && job.history.ExpensiveEpochs[n-1] == epoch {
+		return
+	}
+	job.history.ExpensiveEpochs = append(job.history.ExpensiveEpochs, epoch)
+
+	// the epoch is lowered from the parallelism it trained with
+	parallelism := job.parallelism
+	if epoch <= len(job.history.Parallelism) {
+		parallelism = int(job.history.Parallelism[epoch-1])
+	}
+	next := job.parallelism
+	if epoch < job.task.Parameters.Epochs {
+		if reduced := opts.CostParallelism(parallelism, cost); reduced < next {
+			next = reduced
+		}
+	}
+
+	job.logger.Warn("The epoch went over the max epoch cost",
+		zap.Int("epoch", epoch),
+		zap.Float64("cost", cost),
+		zap.Float64("max", opts.MaxEpochCost),
+		zap.Int("parallelism", parallelism),
+		zap.Int("next parallelism", next))
+	job.notifyEvent(api.EventEpochOverCost, map[string]float64{
+		"cost":             cost,
+		"max_epoch_cost":   opts.MaxEpochCost,
+		"parallelism":      float64(parallelism),
+		"next_parallelism": float64(next),
+	})
+
+	if next < job.parallelism && !util.IsDebugEnv() && !util.LimitParallelism() {
+		job.parallelism = next
+		job.task.Job.State.Parallelism = next
+	}
+}
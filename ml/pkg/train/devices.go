@@ -0,0 +1,88 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"sync"
+)
+
+type (
+	// deviceCounts holds the devices each train function reported in the current
+	// epoch, which can be fewer than requested if its pod has fewer GPUs. They are
+	// reported in the finish notifications and the train responses, so they are
+	// set by the api handlers and the functions concurrently
+	deviceCounts struct {
+		mu        sync.Mutex
+		requested int
+		reported  map[int]int
+	}
+)
+
+func newDeviceCounts(requested int) *deviceCounts {
+	return &deviceCounts{
+		requested: requested,
+		reported:  make(map[int]int),
+	}
+}
+
+// reset clears the devices reported, called at the start of every epoch
+func (d *deviceCounts) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reported = make(map[int]int)
+}
+
+// report sets the devices the function trains on, ignoring invalid counts
+func (d *deviceCounts) report(funcId, devices int) {
+	if devices < 1 || devices > api.MaxFunctionDevices {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reported[funcId] = devices
+}
+
+// weight returns the devices of the function relative to the ones requested, the
+// share of the samples it trains on in every step compared with a function that
+// got all of them. Functions that did not report yet are taken as complete
+func (d *deviceCounts) weight(funcId int) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if n, exists := d.reported[funcId]; exists {
+		return float64(n) / float64(d.requested)
+	}
+	return 1
+}
+
+// total returns the devices of the given functions, counting the requested
+// ones for the functions that did not report, which is the effective parallelism
+func (d *deviceCounts) total(funcs []int) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var total int
+	for _, id := range funcs {
+		if n, exists := d.reported[id]; exists {
+			total += n
+		} else {
+			total += d.requested
+		}
+	}
+	return total
+}
+
+// recordDevices saves the effective parallelism of the epoch in the history, the
+// devices of the train functions that completed. It is only kept for the jobs
+// whose functions train on several devices
+func (job *TrainJob) recordDevices(funcs []int) {
+	if !job.task.Parameters.Options.MultiDevice() {
+		return
+	}
+
+	effective := job.devices.total(funcs)
+	if expected := len(funcs) * job.devices.requested; effective < expected {
+		job.logger.Warn("Some functions trained on fewer devices than requested",
+			zap.Int("devices", effective),
+			zap.Int("requested", expected))
+	}
+	job.setEpochMetrics(map[string]float64{api.MetricEffectiveParallelism: float64(effective)})
+}
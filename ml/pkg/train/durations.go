@@ -0,0 +1,166 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// invocationDurations keeps the histograms of the durations of the invocations of
+// a job by task, the ones of each epoch and the ones of the whole job, which are
+// exported by the parameter server
+type invocationDurations struct {
+	mu     sync.Mutex
+	bounds []float64
+	epochs map[int]map[string]*api.DurationHistogram
+	totals map[string]*api.DurationHistogram
+}
+
+func newInvocationDurations() *invocationDurations {
+	return &invocationDurations{
+		bounds: api.TrainOptions{}.DurationBucketsOrDefault().Bounds(),
+		epochs: make(map[int]map[string]*api.DurationHistogram),
+		totals: make(map[string]*api.DurationHistogram),
+	}
+}
+
+// setBounds sets the buckets of the histograms, before any invocation is observed
+func (d *invocationDurations) setBounds(bounds []float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.bounds = bounds
+}
+
+// observe counts the duration of an invocation of the task in the epoch
+func (d *invocationDurations) observe(epoch int, task FunctionTask, elapsed time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	histograms, exists := d.epochs[epoch]
+	if !exists {
+		histograms = make(map[string]*api.DurationHistogram)
+		d.epochs[epoch] = histograms
+	}
+	for _, m := range []map[string]*api.DurationHistogram{histograms, d.totals} {
+		h, exists := m[string(task)]
+		if !exists {
+			h = api.NewDurationHistogram(d.bounds)
+			m[string(task)] = h
+		}
+		h.Observe(elapsed.Seconds())
+	}
+}
+
+// epoch returns copies of the histograms of the epoch by task. The init function is
+// invoked before the first epoch, so it is merged into the first one returned. The
+// epoch before is kept for the straggler timeout relative to it, see previous, and
+// the earlier ones are forgotten
+func (d *invocationDurations) epoch(epoch int) map[string]*api.DurationHistogram {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current, exists := d.epochs[epoch]
+	if !exists {
+		current = make(map[string]*api.DurationHistogram)
+		d.epochs[epoch] = current
+	}
+	for e, histograms := range d.epochs {
+		switch {
+		case e == 0 && epoch > 0:
+			for task, h := range histograms {
+				if merged, exists := current[task]; exists {
+					merged.Merge(h)
+				} else {
+					current[task] = h
+				}
+			}
+			delete(d.epochs, e)
+		case e < epoch-1:
+			delete(d.epochs, e)
+		}
+	}
+
+	histograms := make(map[string]*api.DurationHistogram, len(current))
+	for task, h := range current {
+		histograms[task] = h.Clone()
+	}
+	return histograms
+}
+
+// previous returns a copy of the histogram of the task in the epoch before, nil if none
+func (d *invocationDurations) previous(epoch int, task FunctionTask) *api.DurationHistogram {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if h, exists := d.epochs[epoch-1][string(task)]; exists {
+		return h.Clone()
+	}
+	return nil
+}
+
+// total returns copies of the histograms of all the invocations of the job by task
+func (d *invocationDurations) total() map[string]*api.DurationHistogram {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	histograms := make(map[string]*api.DurationHistogram, len(d.totals))
+	for task, h := range d.totals {
+		histograms[task] = h.Clone()
+	}
+	return histograms
+}
+
+// recordDurations saves the percentiles of the durations of the invocations of the
+// current epoch in the history, replacing the ones saved before for the epoch. It is
+// called again for the last epoch after the final validation. A relative straggler
+// timeout is evaluated here against the train invocations of the epoch for the next
+func (job *TrainJob) recordDurations() {
+	epoch := job.currentEpoch()
+	entry := api.EpochDurations{Epoch: epoch, Tasks: make(map[string]api.DurationPercentiles)}
+	for task, h := range job.durations.epoch(epoch) {
+		entry.Tasks[task] = h.Percentiles()
+	}
+
+	if n := len(job.history.InvocationDurations); n > 0 && job.history.InvocationDurations[n-1].Epoch == epoch {
+		job.history.InvocationDurations[n-1] = entry
+	} else {
+		job.history.InvocationDurations = append(job.history.InvocationDurations, entry)
+	}
+
+	if train, exists := entry.Tasks[string(Train)]; exists {
+		job.logger.Debug("Train invocation durations",
+			zap.Int("epoch", epoch),
+			zap.Float64("p50", train.P50),
+			zap.Float64("p95", train.P95),
+			zap.Float64("p99", train.P99))
+	}
+
+	job.updateStragglerTimeout(epoch + 1)
+}
+
+// updateStragglerTimeout sets the straggler timeout of the train invocations
+// of the epoch, relative to the durations of the epoch before if the job
+// sets a percentile. It stays unset in the first epoch of a relative timeout
+func (job *TrainJob) updateStragglerTimeout(epoch int) {
+	opts := job.task.Parameters.Options
+	if len(opts.StragglerTimeout) == 0 {
+		return
+	}
+	// validated by the controller
+	timeout, err := api.ParseStragglerTimeout(opts.StragglerTimeout)
+	if err != nil {
+		job.logger.Warn("Invalid straggler timeout, ignoring it", zap.Error(err))
+		return
+	}
+
+	threshold, ok := timeout.Threshold(job.durations.previous(epoch, Train))
+	if !ok {
+		return
+	}
+	job.active.setStragglerTimeout(threshold)
+	if timeout.Relative() {
+		job.logger.Debug("Straggler timeout of the epoch",
+			zap.Int("epoch", epoch),
+			zap.String("timeout", opts.StragglerTimeout),
+			zap.Duration("threshold", threshold))
+	}
+}
@@ -0,0 +1,44 @@
+package train
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInvocationDurations(t *testing.T) {
+	d := newInvocationDurations()
+	d.setBounds([]float64{1, 2, 4})
+
+	// the init function runs before the first epoch
+	d.observe(0, Init, 3*time.Second)
+	d.observe(1, Train, 500*time.Millisecond)
+	d.observe(1, Train, 1500*time.Millisecond)
+
+	first := d.epoch(1)
+	if first[string(Init)] == nil || first[string(Init)].Count != 1 || first[string(Train)].Count != 2 {
+		t.Fatalf("got histograms %+v in the first epoch, want the init and train invocations", first)
+	}
+
+	// the histograms returned are copies
+	first[string(Train)].Observe(1)
+	if h := d.previous(2, Train); h == nil || h.Count != 2 {
+		t.Errorf("got histogram %+v of the previous epoch, want the 2 train invocations", h)
+	}
+
+	d.observe(2, Train, 3*time.Second)
+	d.epoch(2)
+	d.observe(3, Train, 3*time.Second)
+	d.epoch(3)
+	if h := d.previous(2, Train); h != nil {
+		t.Errorf("got histogram %+v of a forgotten epoch, want none", h)
+	}
+	if h := d.previous(3, Train); h == nil || h.Count != 1 {
+		t.Errorf("got histogram %+v of the previous epoch, want the train invocation", h)
+	}
+
+	// the totals keep every invocation of the job
+	totals := d.total()
+	if totals[string(Train)].Count != 4 || totals[string(Init)].Count != 1 {
+		t.Errorf("got totals %+v, want 4 train and 1 init invocations", totals)
+	}
+}
@@ -0,0 +1,72 @@
+package train
+
+import (
+	"context"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// recommendationsCollection holds the parallelism recommended for each
+// function and dataset, see api.ParallelismRecommendation
+const recommendationsCollection = "recommendations"
+
+// recordEfficiency computes the efficiency curve of the job once it finishes and
+// saves it in the history. The recommended parallelism is stored for the next jobs
+// of the function and the dataset only if the job succeeded and trained with more
+// than one parallelism, since otherwise there is nothing to compare
+func (job *TrainJob) recordEfficiency() {
+	curve := job.history.EfficiencyCurve()
+	if curve == nil {
+		return
+	}
+	job.history.Efficiency = curve
+	job.logger.Info("Efficiency of the parallelism", zap.String("summary", curve.Summary))
+
+	measured := 0
+	for _, p := range curve.Points {
+		if p.Epochs > 0 {
+			measured++
+		}
+	}
+	if job.exitErr != nil || measured < 2 {
+		return
+	}
+
+	recommendation := curve.Recommendation(job.jobId, job.task.Parameters)
+	if recommendation == nil {
+		return
+	}
+	if err := writeRecommendation(recommendation); err != nil {
+		job.logger.Warn("Could not save the recommended parallelism", zap.Error(err))
+		return
+	}
+	job.logger.Info("Saved recommended parallelism",
+		zap.String("function", recommendation.Function),
+		zap.String("dataset", recommendation.Dataset),
+		zap.Int("parallelism", recommendation.Parallelism))
+}
+
+// writeRecommendation replaces the recommendation of the function and the dataset
+func writeRecommendation(recommendation *api.ParallelismRecommendation) error {
+	client, err := mongo.NewClient(options.Client().ApplyURI(createMongoURI()))
+	if err != nil {
+		return errors.Wrap(err, "could not create mongo client")
+	}
+	if err = client.Connect(context.TODO()); err != nil {
+		return errors.Wrap(err, "could not connect to mongo")
+	}
+	defer client.Disconnect(context.TODO())
+
+	collection := client.Database(util.MongoDatabase()).Collection(recommendationsCollection)
+	_, err = collection.ReplaceOne(context.TODO(),
+		bson.M{"_id": recommendation.Id}, recommendation, options.Replace().SetUpsert(true))
+	if err != nil {
+		return errors.Wrap(err, "could not write recommendation")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"math"
+)
+
+const (
+	// etaWindow is the number of recent epochs used
+	// to estimate the duration of the next ones
+	etaWindow = 5
+
+	// minConfidentEpochs is the number of epochs needed
+	// for the estimation to have a high confidence
+	minConfidentEpochs = 3
+)
+
+// epochDurations returns the duration of the recent epochs used to estimate the
+// remaining time. The history keeps the elapsed time since the start of the training,
+// so the duration of each epoch is the difference with the previous one.
+//
+// The first epoch and the epochs right after a parallelism change or a pause are
+// excluded since they include the cold start of the functions
+func epochDurations(history *api.JobHistory) []float64 {
+	resumed := make(map[int]bool, len(history.ResumedEpochs))
+	for _, epoch := range history.ResumedEpochs {
+		resumed[epoch] = true
+	}
+
+	var durations []float64
+	for i := 1; i < len(history.EpochDuration) && i < len(history.Parallelism); i++ {
+		if history.Parallelism[i] != history.Parallelism[i-1] || resumed[i+1] {
+			continue
+		}
+		durations = append(durations, history.EpochDuration[i]-history.EpochDuration[i-1])
+	}
+
+	if len(durations) > etaWindow {
+		durations = durations[len(durations)-etaWindow:]
+	}
+	return durations
+}
+
+// epochsToGoal extrapolates the accuracy trend with a least squares fit
+// and returns the number of epochs needed to reach the goal accuracy, which
+// is approached from below if it is maximized or from above otherwise.
+// Returns false if the trend does not approach the goal
+func epochsToGoal(accuracy []float64, goal float64, validateEvery int, maximize bool) (float64, bool) {
+	n := float64(len(accuracy))
+	if len(accuracy) < 2 || validateEvery <= 0 {
+		return 0, false
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
+	for i, acc := range accuracy {
+		x := float64(i)
+		sumX += x
+		sumY += acc
+		sumXY += x * acc
+		sumXX += x * x
+	}
+
+	// a minimized metric is mirrored so it also approaches the goal from below
+	slope := (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	last := accuracy[len(accuracy)-1]
+	if !maximize {
+		slope, goal, last = -slope, -goal, -last
+	}
+	if slope <= 0 {
+		return 0, false
+	}
+
+	// each of the points in the accuracy history is a validation
+	// performed every validateEvery epochs
+	validations := math.Max(0, (goal-last)/slope)
+	return math.Ceil(validations * float64(validateEvery)), true
+}
+
+// estimateETA computes the estimated remaining training time of the job
+// based on the history of the job
+func (job *TrainJob) estimateETA() api.ETA {
+	durations := epochDurations(&job.history)
+
+	// if no epoch is valid yet, use the duration of the first one
+	if len(durations) == 0 {
+		if len(job.history.EpochDuration) == 0 {
+			return api.ETA{Confidence: api.ETAConfidenceLow}
+		}
+		durations = job.history.EpochDuration[:1]
+	}
+
+	var total float64
+	for _, d := range durations {
+		total += d
+	}
+	avg := total / float64(len(durations))
+
+	confidence := api.ETAConfidenceHigh
+	if len(durations) < minConfidentEpochs {
+		confidence = api.ETAConfidenceLow
+	}
+
+	// in convergence based runs the training might stop before
+	// finishing all the epochs if the accuracy trend reaches the goal
+	remaining := float64(job.task.Parameters.Epochs - len(job.history.EpochDuration))
+	if job.accuracyScaleKnown() && job.goalAccuracy > 0 && job.goalAccuracy < api.NoGoalAccuracy {
+		maximize := job.task.Parameters.Options.Direction(api.MetricAccuracy) == api.DirectionMaximize
+		if epochs, ok := epochsToGoal(job.history.Accuracy, job.goalAccuracy, job.validateEvery, maximize); ok {
+			remaining = math.Min(remaining, epochs)
+			confidence = api.ETAConfidenceLow
+		}
+	}
+
+	return api.ETA{
+		Seconds:    math.Max(0, remaining) * avg,
+		Confidence: confidence,
+	}
+}
@@ -0,0 +1,210 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"reflect"
+	"testing"
+)
+
+func TestEpochDurations(t *testing.T) {
+	tests := []struct {
+		name    string
+		history api.JobHistory
+		want    []float64
+	}{
+		{
+			name: "empty",
+			want: nil,
+		},
+		{
+			name: "first epoch is excluded",
+			history: api.JobHistory{
+				EpochDuration: []float64{30},
+				Parallelism:   []float64{2},
+			},
+			want: nil,
+		},
+		{
+			name: "differences of the elapsed time",
+			history: api.JobHistory{
+				EpochDuration: []float64{10, 20, 30, 45},
+				Parallelism:   []float64{2, 2, 2, 2},
+			},
+			want: []float64{10, 10, 15},
+		},
+		{
+			name: "parallelism change",
+			history: api.JobHistory{
+				EpochDuration: []float64{10, 20, 35, 45},
+				Parallelism:   []float64{2, 2, 4, 4},
+			},
+			want: []float64{10, 10},
+		},
+		{
+			name: "resumed epoch",
+			history: api.JobHistory{
+				EpochDuration: []float64{10, 20, 100, 110},
+				Parallelism:   []float64{2, 2, 2, 2},
+				ResumedEpochs: []int{3},
+			},
+			want: []float64{10, 10},
+		},
+		{
+			name: "only the recent epochs",
+			history: api.JobHistory{
+				EpochDuration: []float64{10, 20, 30, 40, 50, 62, 74, 86},
+				Parallelism:   []float64{2, 2, 2, 2, 2, 2, 2, 2},
+			},
+			want: []float64{10, 10, 12, 12, 12},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := epochDurations(&tt.history); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got durations %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEpochsToGoal(t *testing.T) {
+	tests := []struct {
+		name          string
+		accuracy      []float64
+		goal          float64
+		validateEvery int
+		maximize      bool
+		want          float64
+		ok            bool
+	}{
+		{"single validation", []float64{50}, 90, 1, true, 0, false},
+		{"no validations", nil, 90, 1, true, 0, false},
+		{"invalid validation period", []float64{50, 60}, 90, 0, true, 0, false},
+		{"linear trend", []float64{50, 60, 70}, 90, 1, true, 2, true},
+		{"validation every two epochs", []float64{50, 60, 70}, 90, 2, true, 4, true},
+		{"rounded up", []float64{50, 60, 70}, 85, 1, true, 2, true},
+		{"goal already passed", []float64{80, 90, 95}, 90, 1, true, 0, true},
+		{"flat trend", []float64{70, 70, 70}, 90, 1, true, 0, false},
+		{"trend away from the goal", []float64{70, 60, 50}, 90, 1, true, 0, false},
+		{"minimized metric", []float64{1, 0.75, 0.5}, 0.25, 1, false, 1, true},
+		{"minimized metric going up", []float64{0.5, 0.75, 1}, 0.25, 1, false, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := epochsToGoal(tt.accuracy, tt.goal, tt.validateEvery, tt.maximize)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("got (%v, %v), want (%v, %v)", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestEstimateETA(t *testing.T) {
+	tests := []struct {
+		name          string
+		epochs        int
+		goalAccuracy  float64
+		validateEvery int
+		history       api.JobHistory
+		want          api.ETA
+	}{
+		{
+			name:   "no epochs",
+			epochs: 10,
+			want:   api.ETA{Confidence: api.ETAConfidenceLow},
+		},
+		{
+			name:   "first epoch only",
+			epochs: 5,
+			history: api.JobHistory{
+				EpochDuration: []float64{12},
+				Parallelism:   []float64{2},
+			},
+			want: api.ETA{Seconds: 48, Confidence: api.ETAConfidenceLow},
+		},
+		{
+			name:   "steady epochs",
+			epochs: 10,
+			history: api.JobHistory{
+				EpochDuration: []float64{10, 20, 30, 40},
+				Parallelism:   []float64{2, 2, 2, 2},
+			},
+			want: api.ETA{Seconds: 60, Confidence: api.ETAConfidenceHigh},
+		},
+		{
+			name:   "finished",
+			epochs: 4,
+			history: api.JobHistory{
+				EpochDuration: []float64{10, 20, 30, 40},
+				Parallelism:   []float64{2, 2, 2, 2},
+			},
+			want: api.ETA{Seconds: 0, Confidence: api.ETAConfidenceHigh},
+		},
+		{
+			name:          "goal reached before the last epoch",
+			epochs:        10,
+			goalAccuracy:  90,
+			validateEvery: 1,
+			history: api.JobHistory{
+				EpochDuration: []float64{10, 20, 30, 40},
+				Parallelism:   []float64{2, 2, 2, 2},
+				Accuracy:      []float64{40, 50, 60, 70},
+				AccuracyScale: api.AccuracyScalePercent,
+			},
+			want: api.ETA{Seconds: 20, Confidence: api.ETAConfidenceLow},
+		},
+		{
+			name:          "scale of the accuracy not known yet",
+			epochs:        10,
+			goalAccuracy:  0.9,
+			validateEvery: 1,
+			history: api.JobHistory{
+				EpochDuration: []float64{10, 20, 30, 40},
+				Parallelism:   []float64{2, 2, 2, 2},
+				Accuracy:      []float64{0.4, 0.5, 0.6, 0.7},
+			},
+			want: api.ETA{Seconds: 60, Confidence: api.ETAConfidenceHigh},
+		},
+		{
+			name:          "goal not approached",
+			epochs:        10,
+			goalAccuracy:  90,
+			validateEvery: 1,
+			history: api.JobHistory{
+				EpochDuration: []float64{10, 20, 30, 40},
+				Parallelism:   []float64{2, 2, 2, 2},
+				Accuracy:      []float64{70, 60, 50, 40},
+				AccuracyScale: api.AccuracyScalePercent,
+			},
+			want: api.ETA{Seconds: 60, Confidence: api.ETAConfidenceHigh},
+		},
+		{
+			name:          "no goal",
+			epochs:        10,
+			goalAccuracy:  api.NoGoalAccuracy,
+			validateEvery: 1,
+			history: api.JobHistory{
+				EpochDuration: []float64{10, 20, 30, 40},
+				Parallelism:   []float64{2, 2, 2, 2},
+				Accuracy:      []float64{40, 50, 60, 70},
+			},
+			want: api.ETA{Seconds: 60, Confidence: api.ETAConfidenceHigh},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &TrainJob{
+				history:       tt.history,
+				task:          &api.TrainTask{Parameters: api.TrainRequest{Epochs: tt.epochs}},
+				goalAccuracy:  tt.goalAccuracy,
+				validateEvery: tt.validateEvery,
+			}
+			if got := job.estimateETA(); got != tt.want {
+				t.Errorf("got ETA %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package train
+
+import "sync"
+
+// finishQueue collects the finish notifications of the functions in an iteration
+// of the merger. Unlike a channel buffered to the parallelism of the job, adding
+// a notification never blocks, so more notifications than expected, e.g. from
+// retried functions, can not block the functions or the merger
+type finishQueue struct {
+	mu            sync.Mutex
+	notifications []*finishNotification
+}
+
+func newFinishQueue() *finishQueue {
+	return &finishQueue{}
+}
+
+// push adds the notification of a function to the current iteration
+func (q *finishQueue) push(n *finishNotification) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.notifications = append(q.notifications, n)
+}
+
+// drain returns the notifications received since the last drain
+// and empties the queue for the next iteration
+func (q *finishQueue) drain() []*finishNotification {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	notifications := q.notifications
+	q.notifications = nil
+	return notifications
+}
+
+// reset discards the notifications left from a previous epoch, answering
+// the functions still waiting on them so they do not hang, and returns
+// the number of notifications discarded
+func (q *finishQueue) reset() int {
+	notifications := q.drain()
+	for _, n := range notifications {
+		if n.respChan != nil {
+			n.respChan <- MergeFailed
+		}
+	}
+	return len(notifications)
+}
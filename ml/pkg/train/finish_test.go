@@ -0,0 +1,90 @@
+package train
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestFinishQueueDrain(t *testing.T) {
+	q := newFinishQueue()
+	if got := q.drain(); len(got) != 0 {
+		t.Fatalf("got %d notifications from an empty queue, want 0", len(got))
+	}
+
+	for funcId := 0; funcId < 3; funcId++ {
+		q.push(&finishNotification{funcId: funcId})
+	}
+
+	// the notifications are returned in the order they arrived
+	got := q.drain()
+	if len(got) != 3 {
+		t.Fatalf("got %d notifications, want 3", len(got))
+	}
+	for i, n := range got {
+		if n.funcId != i {
+			t.Errorf("got function %d in position %d, want %d", n.funcId, i, i)
+		}
+	}
+
+	// the next iteration starts with an empty queue
+	if got := q.drain(); len(got) != 0 {
+		t.Errorf("got %d notifications after draining, want 0", len(got))
+	}
+}
+
+func TestFinishQueuePushDoesNotBlock(t *testing.T) {
+	q := newFinishQueue()
+
+	// more notifications than the parallelism of any job,
+	// e.g. from retried functions, are all kept
+	const num = 100
+	var wg sync.WaitGroup
+	for funcId := 0; funcId < num; funcId++ {
+		wg.Add(1)
+		go func(funcId int) {
+			defer wg.Done()
+			q.push(&finishNotification{funcId: funcId})
+		}(funcId)
+	}
+	wg.Wait()
+
+	seen := make(map[int]bool)
+	for _, n := range q.drain() {
+		seen[n.funcId] = true
+	}
+	if len(seen) != num {
+		t.Errorf("got notifications of %d functions, want %d", len(seen), num)
+	}
+}
+
+func TestFinishQueueReset(t *testing.T) {
+	q := newFinishQueue()
+	waiting := []chan MergeResult{make(chan MergeResult, 1), make(chan MergeResult, 1)}
+
+	q.push(&finishNotification{funcId: 0, respChan: waiting[0]})
+	q.push(&finishNotification{funcId: 1})
+	q.push(&finishNotification{funcId: 2, respChan: waiting[1]})
+
+	if num := q.reset(); num != 3 {
+		t.Errorf("got %d discarded notifications, want 3", num)
+	}
+
+	// the functions still waiting are told the merge failed
+	for i, ch := range waiting {
+		select {
+		case result := <-ch:
+			if result != MergeFailed {
+				t.Errorf("got result %v for waiting function %d, want %v", result, i, MergeFailed)
+			}
+		default:
+			t.Errorf("waiting function %d was not answered", i)
+		}
+	}
+
+	if num := q.reset(); num != 0 {
+		t.Errorf("got %d discarded notifications after a reset, want 0", num)
+	}
+	if got := q.drain(); len(got) != 0 {
+		t.Errorf("got %d notifications after a reset, want 0", len(got))
+	}
+}
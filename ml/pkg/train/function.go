@@ -1,26 +1,31 @@
 package train
 
 import (
+	"context"
+	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
 	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
 	"github.com/diegostock12/kubeml/ml/pkg/util"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
-	"sync/atomic"
+	"time"
 )
 
 type (
 
 	// FunctionArgs holds the arguments needed to build
 	// the url of a function, such as the function id and
-	// parallelism level
+	// parallelism level, and the token of train invocations
 	FunctionArgs struct {
-		Id  int
-		Num int
+		Id    int
+		Num   int
+		Token string
 	}
 
 	// FunctionResults holds the function id and the execution
@@ -30,25 +35,48 @@ type (
 		results map[string]float64
 	}
 
+	// validationResults holds the metrics averaged over the validation
+	// functions that reported and the ids of the ones that failed
+	validationResults struct {
+		accuracy  float64
+		loss      float64
+		responses int
+		failed    []int
+		classes   classCounts
+
+		// samples is the number of datapoints evaluated by the functions
+		samples float64
+
+		// interrupted is set if the validation was cancelled
+		// before all the functions reported
+		interrupted bool
+	}
+
+	// classCounts are the correct predictions and the datapoints of
+	// each class summed over the validation functions, empty unless
+	// the job enables the per-class metrics
+	classCounts struct {
+		correct map[string]float64
+		total   map[string]float64
+	}
+
 	FunctionTask string
 )
 
 const (
 	Train      FunctionTask = "train"
 	Validation FunctionTask = "val"
+	Canary     FunctionTask = "canary"
 	Init       FunctionTask = "init"
+	Sanity     FunctionTask = "sanity"
 	Inference  FunctionTask = "infer"
+	WarmUp     FunctionTask = "warmup"
 )
 
 // buildFunctionURL returns the url that the PS will invoke to execute the function
 func (job *TrainJob) buildFunctionURL(args FunctionArgs, task FunctionTask) string {
 
-	var routerAddr string
-	if util.IsDebugEnv() {
-		routerAddr = api.FissionRouterUrlDebug
-	} else {
-		routerAddr = api.FissionRouterUrl
-	}
+	routerAddr := util.RouterUrl()
 
 	values := url.Values{}
 	values.Set("task", string(task))
@@ -56,27 +84,102 @@ func (job *TrainJob) buildFunctionURL(args FunctionArgs, task FunctionTask) stri
 	values.Set("N", strconv.Itoa(args.Num))
 	values.Set("K", strconv.Itoa(job.K))
 	values.Set("funcId", strconv.Itoa(args.Id))
-	values.Set("batchSize", strconv.Itoa(job.task.Parameters.BatchSize))
-	values.Set("lr", strconv.FormatFloat(float64(job.task.Parameters.LearningRate), 'f', -1, 32))
+	values.Set("batchSize", strconv.Itoa(job.batchSize))
+	if task == Train && len(job.shares) > 0 {
+		values.Set("batchSize", strconv.Itoa(job.functionBatchSize(args.Id)))
+		values.Set("shares", joinFloats(job.shares))
+	}
+	values.Set("lr", strconv.FormatFloat(float64(job.learningRate), 'f', -1, 32))
+	if devices := job.task.Parameters.Options.FunctionDevices(); devices > 1 && (task == Train || task == Validation) {
+		values.Set("devices", strconv.Itoa(devices))
+	}
 	values.Set("epoch", strconv.Itoa(job.epoch)) // add epoch to be able to train with step lr
+	if task == Train {
+		values.Set("token", args.Token)
+	}
+	if task == Train && job.task.Parameters.Options.AllReduce() {
+		values.Set("topology", api.SyncTopologyAllReduce)
+	}
+	if task == Train && job.layerGroups != nil {
+		values.Set("syncGroups", job.task.Parameters.Options.SyncGroupsArg())
+		values.Set("layerK", strconv.Itoa(job.task.Parameters.Options.K))
+	}
+	if task == Train && job.task.Parameters.Options.ShuffleSeed != 0 {
+		seed := api.FunctionSeed(job.task.Parameters.Options.ShuffleSeed, job.epoch, args.Num, args.Id)
+		values.Set("seed", strconv.FormatInt(seed, 10))
+	}
+	if task == Canary {
+		values.Set("canarySize", strconv.Itoa(job.canaryBatchSize))
+	}
+	if task == Sanity && job.task.Parameters.Options.StartupProbe {
+		values.Set("probe", "true")
+	}
+	if task == Validation && len(job.task.Parameters.Options.Metrics) > 0 {
+		values.Set("metrics", strings.Join(job.task.Parameters.Options.Metrics, ","))
+	}
+	if weights := job.task.Parameters.Options.ClassWeights; len(weights) > 0 &&
+		(task == Train || task == Validation || task == Canary || task == Sanity) {
+		values.Set("classWeights", joinFloats(weights))
+	}
+	if job.task.Parameters.ScratchGB > 0 {
+		values.Set("scratchDir", api.ScratchMountPath)
+	}
+	if len(job.task.Parameters.NormalizationMean) > 0 {
+		values.Set("mean", joinFloats(job.task.Parameters.NormalizationMean))
+		values.Set("std", joinFloats(job.task.Parameters.NormalizationStd))
+	}
 
-	dest := routerAddr + "/" + job.task.Parameters.FunctionName + "?" + values.Encode()
+	dest := routerAddr + "/" + job.functionName() + "?" + values.Encode()
 
 	job.logger.Debug("Built url", zap.String("url", dest))
 
 	return dest
 }
 
+// joinFloats returns the values separated by commas
+func joinFloats(values []float64) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strings.Join(s, ",")
+}
+
+// functionName returns the name of the function invoked by the job, which
+// is the per-job copy with the scratch volume if the job requested one
+func (job *TrainJob) functionName() string {
+	if len(job.task.Job.FunctionName) > 0 {
+		return job.task.Job.FunctionName
+	}
+	return job.task.Parameters.FunctionName
+}
+
+// invokeFunction sends the request to the function, asking for the response in
+// the serialization format of the job. Functions that do not support it answer
+// with JSON, which is handled when decoding the response
+func (job *TrainJob) invokeFunction(ctx context.Context, funcUrl string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, funcUrl, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create function request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", util.ContentType(job.task.Parameters.Options.Serialization))
+
+	return http.DefaultClient.Do(req)
+}
+
 // invokeInitFunction calls a single function which initializes the
 // model, saves it to the database and returns the layer names that the job will save
 func (job *TrainJob) invokeInitFunction() ([]string, error) {
 
 	job.logger.Info("Invoking init function")
 	funcUrl := job.buildFunctionURL(FunctionArgs{}, Init)
-	resp, err := http.Get(funcUrl)
+	start := time.Now()
+	resp, err := job.invokeFunction(context.Background(), funcUrl)
+	job.durations.observe(job.currentEpoch(), Init, time.Since(start))
 	if err != nil {
 		job.logger.Error("Could not call the init function",
-			zap.String("funcName", job.task.Parameters.FunctionName),
+			zap.String("funcName", job.functionName()),
 			zap.Any("request", job.task.Parameters),
 			zap.Error(err))
 
@@ -88,6 +191,25 @@ func (job *TrainJob) invokeInitFunction() ([]string, error) {
 		return nil, err
 	}
 
+	// the capabilities are sent in a header so the
+	// response is still the layer name array
+	if capabilities := api.ParseCapabilities(resp.Header.Get(api.HeaderCapabilities)); len(capabilities) > 0 {
+		job.logger.Debug("Function capabilities", zap.Strings("capabilities", capabilities))
+		job.history.Capabilities = capabilities
+	}
+
+	// the functions report the schema of their responses, the ones built
+	// with an older library are only accepted if the job allows it
+	schema := resp.Header.Get(api.HeaderResponseSchema)
+	if err = api.CheckResponseSchema(schema, job.task.Parameters.Options.LooseResponses); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	job.strictResponses = len(schema) > 0
+	if !job.strictResponses {
+		job.logger.Warn("The function does not report the schema of its responses, reading them in the loose format")
+	}
+
 	// read the layer name array from the response
 	layers, err := parseLayerNames(resp)
 	if err != nil {
@@ -98,6 +220,27 @@ func (job *TrainJob) invokeInitFunction() ([]string, error) {
 
 }
 
+// invokeSanityFunction invokes a single function that trains on the first
+// batch of the dataset without saving the model, and returns the shapes it saw
+func (job *TrainJob) invokeSanityFunction() (*api.SanityCheck, error) {
+	job.logger.Info("Invoking sanity check function")
+	funcUrl := job.buildFunctionURL(FunctionArgs{Id: 0, Num: 1}, Sanity)
+	resp, err := job.invokeFunction(context.Background(), funcUrl)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not call the function")
+	}
+
+	if err = kerror.CheckFunctionError(resp); err != nil {
+		return nil, err
+	}
+
+	var check api.SanityCheck
+	if err = util.DecodeResponse(resp, &check); err != nil {
+		return nil, errors.Wrap(err, "could not decode the sanity check")
+	}
+	return &check, nil
+}
+
 // invokeTrainFunctions Invokes N functions to start the next epoch
 // returns the function ids from which it got a response
 func (job *TrainJob) invokeTrainFunctions() (float64, []int, error) {
@@ -106,13 +249,18 @@ func (job *TrainJob) invokeTrainFunctions() (float64, []int, error) {
 	respChan := make(chan *FunctionResults, job.parallelism)
 	errChan := make(chan error, job.parallelism)
 
+	// the functions of the round are admitted together since they wait for each other
+	leases := job.acquireInvocations(job.parallelism)
 	for i := 0; i < job.parallelism; i++ {
 		wg.Add(1)
 
 		job.logger.Debug("Invoking function", zap.Int("id", i))
-		args := FunctionArgs{Id: i, Num: job.parallelism}
+		args := FunctionArgs{Id: i, Num: job.parallelism, Token: job.iterations.issue(i)}
 		funcUrl := job.buildFunctionURL(args, Train)
-		go job.launchFunction(i, funcUrl, Train, wg, respChan, errChan)
+		go func(i int) {
+			defer job.releaseInvocation(leases, i)
+			job.launchFunction(context.Background(), i, funcUrl, Train, wg, respChan, errChan)
+		}(i)
 	}
 	wg.Wait()
 
@@ -121,8 +269,11 @@ func (job *TrainJob) invokeTrainFunctions() (float64, []int, error) {
 		return 0, nil, err
 	}
 
-	// get the average loss
-	loss, funcs := getAverageLoss(respChan)
+	// get the loss of the epoch and the capacities used
+	// to balance the functions in the next epoch
+	loss, funcs, capacities := getTrainResults(respChan, job.task.Parameters.Options)
+	job.capacities = capacities
+	job.recordDevices(funcs)
 
 	return loss, funcs, nil
 }
@@ -131,42 +282,149 @@ func (job *TrainJob) invokeTrainFunctions() (float64, []int, error) {
 // the validations functions to get the performance of the system, these are returned as a dict
 // containing the accuracy, loss and number of datapoints processed by each of the functions.
 //
-// Returns the accuracy and loss of the functions
-func (job *TrainJob) invokeValFunctions() (float64, float64, error) {
+// The metrics are averaged over the functions that reported, failing only if fewer
+// functions than the validation quorum of the job reported. The parallelism is passed
+// since the validations against the latest merge run while the scheduler updates it.
+//
+// Cancelling the context abandons the functions still running, the metrics of the
+// ones that already reported are returned as an interrupted validation if they
+// reach the quorum
+func (job *TrainJob) invokeValFunctions(ctx context.Context, parallelism int) (*validationResults, error) {
 
 	wg := &sync.WaitGroup{}
-	respChan := make(chan *FunctionResults, job.parallelism)
-	errChan := make(chan error, job.parallelism)
+	respChan := make(chan *FunctionResults, parallelism)
+	errChan := make(chan error, parallelism)
 
-	for i := 0; i < job.parallelism; i++ {
+	leases := job.acquireInvocations(parallelism)
+	for i := 0; i < parallelism; i++ {
 		wg.Add(1)
 		job.logger.Debug("Invoking validation function", zap.Int("id", i))
-		args := FunctionArgs{Id: i, Num: job.parallelism}
+		args := FunctionArgs{Id: i, Num: parallelism}
 		funcUrl := job.buildFunctionURL(args, Validation)
-		go job.launchFunction(i, funcUrl, Validation, wg, respChan, errChan)
+		go func(i int) {
+			defer job.releaseInvocation(leases, i)
+			job.launchFunction(ctx, i, funcUrl, Validation, wg, respChan, errChan)
+		}(i)
 	}
 	wg.Wait()
 
-	// check that at least some functions returned without errors
-	if err := job.checkFunctionErrors(respChan, errChan); err != nil {
-		return 0, 0, err
+	accuracy, loss, total, funcs, classes := getValidationMetrics(respChan)
+	results := &validationResults{
+		accuracy:  accuracy,
+		loss:      loss,
+		responses: len(funcs),
+		failed:    missingFunctions(funcs, parallelism),
+		classes:   classes,
+		samples:   total,
+	}
+
+	// the metrics of an interrupted validation below the quorum
+	// are not recorded as those of the epoch
+	required := job.validationQuorum(parallelism)
+	if ctx.Err() != nil {
+		if results.responses < required {
+			return nil, fmt.Errorf("validation interrupted with %d of %d validation functions reported, at least %d needed",
+				results.responses, parallelism, required)
+		}
+		job.logger.Warn("Validation interrupted, keeping the partial results",
+			zap.Int("responses", results.responses),
+			zap.Int("parallelism", parallelism))
+		results.interrupted = true
+		return results, nil
+	}
+
+	if len(results.failed) > 0 {
+		job.logger.Warn("Some validation functions failed",
+			zap.Ints("failed", results.failed),
+			zap.Int("responses", results.responses),
+			zap.Int("parallelism", parallelism))
 	}
 
-	accuracy, loss, total := getValidationMetrics(respChan)
+	if results.responses < required {
+		err := fmt.Errorf("only %d of %d validation functions reported, at least %d needed",
+			results.responses, parallelism, required)
+		select {
+		case funcError := <-errChan:
+			return nil, errors.Wrap(funcError, err.Error())
+		default:
+			return nil, err
+		}
+	}
 
 	// Update the history with the new results
 	job.logger.Debug("Got validation results",
 		zap.Float64("accuracy", accuracy),
 		zap.Float64("loss", loss),
-		zap.Float64("total points", total))
+		zap.Float64("total points", total),
+		zap.Int("responses", results.responses))
 
-	return accuracy, loss, nil
+	return results, nil
+
+}
+
+// validationQuorum returns how many of the n validation functions must report,
+// which is a majority unless the job sets a different fraction
+func (job *TrainJob) validationQuorum(n int) int {
+	quorum := job.task.Parameters.Options.ValidationQuorum
+	if quorum <= 0 {
+		return n/2 + 1
+	}
+
+	required := int(math.Ceil(quorum * float64(n)))
+	switch {
+	case required < 1:
+		return 1
+	case required > n:
+		return n
+	default:
+		return required
+	}
+}
+
+// missingFunctions returns the ids of the n functions that did not report
+func missingFunctions(funcs []int, n int) []int {
+	reported := make(map[int]bool, len(funcs))
+	for _, id := range funcs {
+		reported[id] = true
+	}
+
+	var missing []int
+	for id := 0; id < n; id++ {
+		if !reported[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// invokeCanaryFunction invokes a single validation function on the fixed
+// canary batch of the validation set.
+//
+// Returns the accuracy and loss on the canary batch
+func (job *TrainJob) invokeCanaryFunction() (float64, float64, error) {
+
+	wg := &sync.WaitGroup{}
+	respChan := make(chan *FunctionResults, 1)
+	errChan := make(chan error, 1)
+
+	wg.Add(1)
+	funcUrl := job.buildFunctionURL(FunctionArgs{Id: 0, Num: 1}, Canary)
+	job.launchFunction(context.Background(), 0, funcUrl, Canary, wg, respChan, errChan)
+
+	select {
+	case err := <-errChan:
+		return 0, 0, err
+	default:
+	}
 
+	accuracy, loss, _, _, _ := getValidationMetrics(respChan)
+	return accuracy, loss, nil
 }
 
 // launchFunction launches a training function and sends the results to the
 // invokeTrainFunctions function. Which averages the results and adds them to the history
 func (job *TrainJob) launchFunction(
+	ctx context.Context,
 	funcId int,
 	funcUrl string,
 	task FunctionTask,
@@ -179,20 +437,33 @@ func (job *TrainJob) launchFunction(
 	// if we are validating we skip this
 	if task == Train {
 		defer func() {
-			// Send the finish notification and update the model
-			job.finishCh <- &finishNotification{funcId: funcId}
-			job.model.Update(funcId)
-
-			job.logger.Debug("adding 1 to the finished functions")
-			atomic.AddInt64(&job.finishedFuncs, 1)
+			job.allReduce.abandon(funcId)
+
+			// Send the finish notification and update the model, unless the
+			// function already reported in this iteration before returning
+			reported := job.iterations.finish(funcId, func() {
+				job.finishes.push(&finishNotification{funcId: funcId})
+			})
+			if !reported {
+				job.logger.Debug("function already reported in the iteration", zap.Int("funcId", funcId))
+				return
+			}
+			// the function saved all its layers after the last iteration
+			job.model.Update(funcId, job.mergeWeight(funcId), nil)
 			job.wgIteration.Done()
 		}()
 	}
 
 	defer wg.Done()
 
-	resp, err := http.Get(funcUrl)
-	if err != nil {
+	untrack := job.trackInvocation(funcId, task)
+	resp, err := job.invoker.Invoke(ctx, funcId, task, funcUrl)
+	untrack()
+	if err != nil && ctx.Err() != nil {
+		job.logger.Info("Function invocation cancelled", zap.Int("funcId", funcId))
+		errChan <- err
+		return
+	} else if err != nil {
 		job.logger.Error("Error when performing request",
 			zap.Int("funcId", funcId),
 			zap.Error(err))
@@ -209,12 +480,23 @@ func (job *TrainJob) launchFunction(
 		return
 	}
 
-	res, err := parseFunctionResults(resp)
+	res, err := job.parseFunctionResults(resp, task)
 	if err != nil {
-		errChan <- err
+		job.logger.Error("Invalid function response",
+			zap.Int("funcId", funcId),
+			zap.Error(err))
+		if _, invalid := err.(*api.ResponseError); invalid {
+			job.notifyEventError(api.EventInvalidResponse, map[string]float64{"func_id": float64(funcId)}, err)
+		}
+		errChan <- errors.Wrapf(err, "function %d", funcId)
 		return
 	}
 
+	// the devices are set before the deferred merge so they weight the last model
+	if devices, exists := res["devices"]; exists && task == Train {
+		job.devices.report(funcId, int(devices))
+	}
+
 	job.logger.Info("Sending result to channel and exiting",
 		zap.Int("funcId", funcId),
 		zap.Any("results", res))
@@ -0,0 +1,163 @@
+package train
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// fakeInvoker answers the invocations of the functions with invoke
+type fakeInvoker struct {
+	invoke func(ctx context.Context, funcId int, task FunctionTask) (*http.Response, error)
+}
+
+func (f *fakeInvoker) Invoke(ctx context.Context, funcId int, task FunctionTask, _ string) (*http.Response, error) {
+	return f.invoke(ctx, funcId, task)
+}
+
+func (f *fakeInvoker) Close() error {
+	return nil
+}
+
+// jsonResponse returns the response of a function with the body encoded as json
+func jsonResponse(body interface{}) *http.Response {
+	b, _ := json.Marshal(body)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(b)),
+	}
+}
+
+// newInvokeTestJob returns a job that invokes its functions with the invoker
+func newInvokeTestJob(opts api.TrainOptions, invoker Invoker) *TrainJob {
+	return &TrainJob{
+		logger:     zap.NewNop(),
+		jobId:      "job",
+		task:       &api.TrainTask{Parameters: api.TrainRequest{Options: opts}},
+		invoker:    invoker,
+		active:     newActiveInvocations(),
+		durations:  newInvocationDurations(),
+		iterations: newIterationState(),
+	}
+}
+
+var errFunctionCrashed = errors.New("function crashed")
+
+// validationInvoker answers the validations of the functions with the metrics,
+// and fails the functions without metrics
+func validationInvoker(metrics map[int]map[string]float64) *fakeInvoker {
+	return &fakeInvoker{invoke: func(ctx context.Context, funcId int, task FunctionTask) (*http.Response, error) {
+		m, exists := metrics[funcId]
+		if !exists {
+			return nil, errFunctionCrashed
+		}
+		return jsonResponse(m), nil
+	}}
+}
+
+func TestValidationQuorum(t *testing.T) {
+	tests := []struct {
+		name   string
+		quorum float64
+		n      int
+		want   int
+	}{
+		{"majority of 4", 0, 4, 3},
+		{"majority of 5", 0, 5, 3},
+		{"majority of 1", 0, 1, 1},
+		{"quarter of 4", 0.25, 4, 1},
+		{"three quarters of 4", 0.75, 4, 3},
+		{"rounded up", 0.6, 4, 3},
+		{"all", 1, 4, 4},
+		{"at least one", 0.01, 4, 1},
+		{"at most all", 2, 4, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := newInvokeTestJob(api.TrainOptions{ValidationQuorum: tt.quorum}, nil)
+			if got := job.validationQuorum(tt.n); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingFunctions(t *testing.T) {
+	tests := []struct {
+		name  string
+		funcs []int
+		n     int
+		want  []int
+	}{
+		{"all reported", []int{2, 0, 1, 3}, 4, nil},
+		{"one missing", []int{0, 1, 3}, 4, []int{2}},
+		{"three missing", []int{3}, 4, []int{0, 1, 2}},
+		{"none reported", nil, 2, []int{0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := missingFunctions(tt.funcs, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvokeValFunctionsOneOfFourFailing(t *testing.T) {
+	// function 2 fails and the rest are averaged by their datapoints
+	job := newInvokeTestJob(api.TrainOptions{}, validationInvoker(map[int]map[string]float64{
+		0: {"loss": 1, "accuracy": 90, "length": 100},
+		1: {"loss": 2, "accuracy": 80, "length": 300},
+		3: {"loss": 4, "accuracy": 50, "length": 100},
+	}))
+
+	results, err := job.invokeValFunctions(context.Background(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.responses != 3 || !reflect.DeepEqual(results.failed, []int{2}) {
+		t.Errorf("got %d responses and failed %v, want 3 and [2]", results.responses, results.failed)
+	}
+	if results.samples != 500 {
+		t.Errorf("got %v samples, want 500", results.samples)
+	}
+	if math.Abs(results.accuracy-76) > 1e-9 || math.Abs(results.loss-2.2) > 1e-9 {
+		t.Errorf("got accuracy %v and loss %v, want 76 and 2.2", results.accuracy, results.loss)
+	}
+}
+
+func TestInvokeValFunctionsThreeOfFourFailing(t *testing.T) {
+	job := newInvokeTestJob(api.TrainOptions{}, validationInvoker(map[int]map[string]float64{
+		1: {"loss": 2, "accuracy": 80, "length": 300},
+	}))
+
+	// the quorum error carries the error of a function
+	_, err := job.invokeValFunctions(context.Background(), 4)
+	if err == nil {
+		t.Fatal("got no error below the quorum")
+	}
+	if errors.Cause(err) != errFunctionCrashed {
+		t.Errorf("got error %v, want it to wrap %v", err, errFunctionCrashed)
+	}
+
+	// unless the job accepts a single function
+	job.task.Parameters.Options.ValidationQuorum = 0.25
+	results, err := job.invokeValFunctions(context.Background(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.accuracy != 80 || !reflect.DeepEqual(results.failed, []int{0, 2, 3}) {
+		t.Errorf("got accuracy %v and failed %v, want 80 and [0 2 3]", results.accuracy, results.failed)
+	}
+}
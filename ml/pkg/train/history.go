@@ -0,0 +1,141 @@
+package train
+
+import (
+	"context"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// historyWriter buffers the updates of the history of a job and writes the latest
+// one to the database every interval, so the writes stay bounded no matter how
+// often the metrics are updated. The history written while training is marked
+// as in progress, so a job that crashes leaves the history up to the last flush
+type historyWriter struct {
+	logger     *zap.Logger
+	client     *mongo.Client
+	collection *mongo.Collection
+	jobId      string
+
+	// pending is the encoded history not yet written, the
+	// history is encoded when buffered so the job can keep
+	// updating it while it is written
+	mu      sync.Mutex
+	pending bson.Raw
+	flushed bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newHistoryWriter connects to the database and starts flushing the buffered
+// history every interval. With an interval of 0 the history is only written
+// when the writer is closed
+func newHistoryWriter(logger *zap.Logger, jobId string, interval time.Duration) (*historyWriter, error) {
+	client, err := mongo.NewClient(options.Client().ApplyURI(createMongoURI()))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create mongo client")
+	}
+	if err = client.Connect(context.TODO()); err != nil {
+		return nil, errors.Wrap(err, "could not connect to mongo")
+	}
+
+	hw := &historyWriter{
+		logger:     logger.Named("history"),
+		client:     client,
+		collection: client.Database(util.MongoDatabase()).Collection(util.HistoryCollection()),
+		jobId:      jobId,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	go hw.run(interval)
+	return hw, nil
+}
+
+// run flushes the buffered history every interval until the writer is closed
+func (hw *historyWriter) run(interval time.Duration) {
+	defer close(hw.done)
+	if interval <= 0 {
+		<-hw.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := hw.flush(); err != nil {
+				hw.logger.Warn("Could not flush history", zap.Error(err))
+			}
+		case <-hw.stop:
+			return
+		}
+	}
+}
+
+// update buffers the history, replacing the one not yet written
+func (hw *historyWriter) update(h *api.History) error {
+	doc, err := bson.Marshal(h)
+	if err != nil {
+		return errors.Wrap(err, "could not encode history")
+	}
+
+	hw.mu.Lock()
+	hw.pending = doc
+	hw.mu.Unlock()
+	return nil
+}
+
+// flush writes the buffered history if there is one, replacing
+// the history previously written for the job
+func (hw *historyWriter) flush() error {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	if hw.pending == nil {
+		return nil
+	}
+
+	_, err := hw.collection.ReplaceOne(context.TODO(),
+		bson.M{"_id": hw.jobId}, hw.pending, options.Replace().SetUpsert(true))
+	if err != nil {
+		return errors.Wrap(err, "could not write history")
+	}
+
+	hw.pending = nil
+	hw.flushed = true
+	return nil
+}
+
+// hasFlushed returns whether the history was written at least once
+func (hw *historyWriter) hasFlushed() bool {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	return hw.flushed
+}
+
+// close stops the periodic flushes, writes the final history and disconnects
+func (hw *historyWriter) close(h *api.History) error {
+	close(hw.stop)
+	<-hw.done
+	defer hw.client.Disconnect(context.TODO())
+
+	if err := hw.update(h); err != nil {
+		return err
+	}
+	return hw.flush()
+}
+
+// discard stops the periodic flushes and disconnects without writing the buffered history
+func (hw *historyWriter) discard() {
+	close(hw.stop)
+	<-hw.done
+	hw.client.Disconnect(context.TODO())
+}
@@ -0,0 +1,249 @@
+package train
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// invocationLeaseTTL is the time a slot of a job is kept without being refreshed,
+	// so the slots of a job that dies are given back. The slots held are refreshed
+	// every invocationLeaseRefresh while their functions run
+	invocationLeaseTTL     = 2 * time.Minute
+	invocationLeaseRefresh = 30 * time.Second
+
+	// invocationTicketTTL is the time a job keeps its place in the queue
+	// without asking again, so the queue is not blocked by dead jobs
+	invocationTicketTTL = 10 * time.Second
+
+	// invocationPollInterval is the wait before asking again for slots,
+	// doubled after every attempt up to invocationMaxPollInterval
+	invocationPollInterval    = 100 * time.Millisecond
+	invocationMaxPollInterval = 2 * time.Second
+
+	// keys of the slots held by all the jobs, scored by their expiry, and
+	// of the jobs waiting for slots, scored by their arrival and expiry
+	invocationLeasesKey  = "kubeml:invocations:leases"
+	invocationQueueKey   = "kubeml:invocations:queue"
+	invocationTicketsKey = "kubeml:invocations:tickets"
+)
+
+// acquireScript takes the slots of a ticket if it is the first in the queue and
+// there are enough free slots, registering the ticket in the queue otherwise. A
+// ticket asking for more slots than the cap is admitted once all of them are free
+var acquireScript = redis.NewScript(3, `
+local now = tonumber(ARGV[1])
+local cap = tonumber(ARGV[2])
+local ticket = ARGV[3]
+local n = tonumber(ARGV[4])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+for _, t in ipairs(redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', now)) do
+	redis.call('ZREM', KEYS[2], t)
+	redis.call('ZREM', KEYS[3], t)
+end
+
+if not redis.call('ZSCORE', KEYS[2], ticket) then
+	redis.call('ZADD', KEYS[2], now, ticket)
+end
+redis.call('ZADD', KEYS[3], now + tonumber(ARGV[6]), ticket)
+
+local first = redis.call('ZRANGE', KEYS[2], 0, 0)[1]
+local free = cap - redis.call('ZCARD', KEYS[1])
+if first ~= ticket or free < math.min(n, cap) then
+	return 0
+end
+
+for i = 1, n do
+	redis.call('ZADD', KEYS[1], now + tonumber(ARGV[5]), ticket .. ':' .. i)
+end
+redis.call('ZREM', KEYS[2], ticket)
+redis.call('ZREM', KEYS[3], ticket)
+return 1
+`)
+
+// invocationLimiter keeps the function invocations in flight across all the jobs of
+// the cluster under a cap, coordinated through redis. The invocations of a round of a
+// job are admitted together, since the train functions of a round wait for each other
+// to merge, and the rounds of all the jobs are admitted in the order they asked, so
+// a job can not take the slots again while other jobs wait for them
+type invocationLimiter struct {
+	logger *zap.Logger
+	pool   *redis.Pool
+	cap    int
+
+	mu     sync.Mutex
+	leases map[string]bool
+	stop   chan struct{}
+}
+
+// newInvocationLimiter creates the limiter with the cap set in INVOCATION_CONCURRENCY,
+// returning nil if it is not set or 0, in which case the invocations are not limited
+func newInvocationLimiter(logger *zap.Logger, pool *redis.Pool) (*invocationLimiter, error) {
+	s := os.Getenv("INVOCATION_CONCURRENCY")
+	if len(s) == 0 {
+		return nil, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid INVOCATION_CONCURRENCY")
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("INVOCATION_CONCURRENCY should not be negative, got %d", limit)
+	}
+	if limit == 0 {
+		return nil, nil
+	}
+
+	l := &invocationLimiter{
+		logger: logger.Named("limiter"),
+		pool:   pool,
+		cap:    limit,
+		leases: make(map[string]bool),
+		stop:   make(chan struct{}),
+	}
+	go l.refreshLeases()
+	return l, nil
+}
+
+// acquire waits in the queue until n slots can be taken together and returns
+// their leases, which are released one by one as the invocations finish
+func (l *invocationLimiter) acquire(n int) ([]string, error) {
+	ticket := uuid.New().String()
+	wait := invocationPollInterval
+	for {
+		ok, err := l.tryAcquire(ticket, n)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+
+		time.Sleep(wait)
+		if wait *= 2; wait > invocationMaxPollInterval {
+			wait = invocationMaxPollInterval
+		}
+	}
+
+	leases := make([]string, n)
+	l.mu.Lock()
+	for i := range leases {
+		leases[i] = fmt.Sprintf("%s:%d", ticket, i+1)
+		l.leases[leases[i]] = true
+	}
+	l.mu.Unlock()
+	return leases, nil
+}
+
+// tryAcquire runs the acquire script once for the ticket
+func (l *invocationLimiter) tryAcquire(ticket string, n int) (bool, error) {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	ok, err := redis.Int(acquireScript.Do(conn, invocationLeasesKey, invocationQueueKey, invocationTicketsKey,
+		now, l.cap, ticket, n,
+		invocationLeaseTTL.Nanoseconds()/int64(time.Millisecond),
+		invocationTicketTTL.Nanoseconds()/int64(time.Millisecond)))
+	if err != nil {
+		return false, errors.Wrap(err, "could not acquire invocation slots")
+	}
+	return ok == 1, nil
+}
+
+// release gives back the slot of the lease
+func (l *invocationLimiter) release(lease string) {
+	l.mu.Lock()
+	delete(l.leases, lease)
+	l.mu.Unlock()
+
+	conn := l.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("ZREM", invocationLeasesKey, lease); err != nil {
+		// the slot is given back anyway once its lease expires
+		l.logger.Warn("Could not release invocation slot", zap.String("lease", lease), zap.Error(err))
+	}
+}
+
+// refreshLeases extends the leases held while their invocations run
+func (l *invocationLimiter) refreshLeases() {
+	ticker := time.NewTicker(invocationLeaseRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		l.mu.Lock()
+		args := redis.Args{invocationLeasesKey, "XX"}
+		expiry := time.Now().Add(invocationLeaseTTL).UnixNano() / int64(time.Millisecond)
+		for lease := range l.leases {
+			args = args.Add(expiry, lease)
+		}
+		l.mu.Unlock()
+		if len(args) == 2 {
+			continue
+		}
+
+		conn := l.pool.Get()
+		if _, err := conn.Do("ZADD", args...); err != nil {
+			l.logger.Warn("Could not refresh invocation slots", zap.Error(err))
+		}
+		conn.Close()
+	}
+}
+
+// close stops refreshing the leases and releases the ones still held
+func (l *invocationLimiter) close() {
+	close(l.stop)
+
+	l.mu.Lock()
+	var leases []string
+	for lease := range l.leases {
+		leases = append(leases, lease)
+	}
+	l.mu.Unlock()
+	for _, lease := range leases {
+		l.release(lease)
+	}
+}
+
+// acquireInvocations takes n invocation slots for a round of functions of the job,
+// waiting for them if the cluster is at its cap. If the limiter is disabled or redis
+// fails the invocations are not limited, so the limiter never fails the job
+func (job *TrainJob) acquireInvocations(n int) []string {
+	if job.limiter == nil {
+		return nil
+	}
+
+	start := time.Now()
+	leases, err := job.limiter.acquire(n)
+	if err != nil {
+		job.logger.Warn("Invoking the functions without limit", zap.Error(err))
+		return nil
+	}
+	if wait := time.Since(start); wait > time.Second {
+		job.logger.Info("Waited for invocation slots",
+			zap.Int("functions", n),
+			zap.Duration("wait", wait))
+	}
+	return leases
+}
+
+// releaseInvocation gives back the slot of the i-th invocation of a round
+func (job *TrainJob) releaseInvocation(leases []string, i int) {
+	if i < len(leases) {
+		job.limiter.release(leases[i])
+	}
+}
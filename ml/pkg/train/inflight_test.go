@@ -0,0 +1,186 @@
+package train
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+const (
+	testLeaseTTL  = 1000
+	testTicketTTL = 100
+)
+
+func newTestPool(t testing.TB) (*redis.Pool, *miniredis.Miniredis) {
+	t.Helper()
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", s.Addr())
+		},
+	}
+	t.Cleanup(func() { pool.Close() })
+	return pool, s
+}
+
+// admit runs the acquire script for the ticket at the given time in milliseconds
+func admit(t *testing.T, pool *redis.Pool, now int64, cap int, ticket string, n int) bool {
+	t.Helper()
+	conn := pool.Get()
+	defer conn.Close()
+
+	ok, err := redis.Int(acquireScript.Do(conn, invocationLeasesKey, invocationQueueKey, invocationTicketsKey,
+		now, cap, ticket, n, testLeaseTTL, testTicketTTL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok == 1
+}
+
+func checkAdmit(t *testing.T, pool *redis.Pool, now int64, cap int, ticket string, n int, want bool) {
+	t.Helper()
+	if got := admit(t, pool, now, cap, ticket, n); got != want {
+		t.Fatalf("got admitted %v for ticket %s at %d, want %v", got, ticket, now, want)
+	}
+}
+
+// releaseLeases gives back the slots of the leases
+func releaseLeases(t *testing.T, pool *redis.Pool, leases ...string) {
+	t.Helper()
+	conn := pool.Get()
+	defer conn.Close()
+	for _, lease := range leases {
+		if _, err := conn.Do("ZREM", invocationLeasesKey, lease); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAcquireScriptAdmitsInOrder(t *testing.T) {
+	pool, _ := newTestPool(t)
+
+	checkAdmit(t, pool, 0, 2, "a", 2, true)
+	checkAdmit(t, pool, 1, 2, "b", 2, false)
+	checkAdmit(t, pool, 2, 2, "c", 1, false)
+
+	// c fits in the free slots but b asked first
+	releaseLeases(t, pool, "a:1", "a:2")
+	checkAdmit(t, pool, 3, 2, "c", 1, false)
+	checkAdmit(t, pool, 4, 2, "b", 2, true)
+	checkAdmit(t, pool, 5, 2, "c", 1, false)
+
+	releaseLeases(t, pool, "b:1")
+	checkAdmit(t, pool, 6, 2, "c", 1, true)
+}
+
+func TestAcquireScriptAdmitsRoundOverCap(t *testing.T) {
+	pool, s := newTestPool(t)
+
+	checkAdmit(t, pool, 0, 2, "a", 1, true)
+
+	// a round bigger than the cap waits for all the slots
+	checkAdmit(t, pool, 1, 2, "b", 3, false)
+	releaseLeases(t, pool, "a:1")
+	checkAdmit(t, pool, 2, 2, "b", 3, true)
+
+	leases, err := s.ZMembers(invocationLeasesKey)
+	if err != nil || len(leases) != 3 {
+		t.Fatalf("got leases (%v, %v), want the 3 of b", leases, err)
+	}
+
+	// the cluster is over the cap until the round finishes
+	checkAdmit(t, pool, 3, 2, "c", 1, false)
+	releaseLeases(t, pool, "b:1")
+	checkAdmit(t, pool, 4, 2, "c", 1, false)
+	releaseLeases(t, pool, "b:2")
+	checkAdmit(t, pool, 5, 2, "c", 1, true)
+}
+
+func TestAcquireScriptExpiresLeases(t *testing.T) {
+	pool, _ := newTestPool(t)
+
+	// the job holding the slots dies without releasing them
+	checkAdmit(t, pool, 0, 2, "a", 2, true)
+	checkAdmit(t, pool, testLeaseTTL-1, 2, "b", 1, false)
+	checkAdmit(t, pool, testLeaseTTL, 2, "b", 1, true)
+}
+
+func TestAcquireScriptExpiresTickets(t *testing.T) {
+	pool, s := newTestPool(t)
+
+	checkAdmit(t, pool, 0, 2, "a", 2, true)
+
+	// b queues first and dies, c queues behind it
+	checkAdmit(t, pool, 10, 2, "b", 1, false)
+	checkAdmit(t, pool, 20, 2, "c", 1, false)
+	releaseLeases(t, pool, "a:1", "a:2")
+
+	// b still holds its place in the queue
+	checkAdmit(t, pool, 10+testTicketTTL-1, 2, "c", 1, false)
+
+	// asking again refreshes the ticket of c, which
+	// is admitted once the one of b expires
+	checkAdmit(t, pool, 10+testTicketTTL, 2, "c", 1, true)
+	if queued, _ := s.ZMembers(invocationQueueKey); len(queued) != 0 {
+		t.Errorf("got queued tickets %v, want none", queued)
+	}
+	if tickets, _ := s.ZMembers(invocationTicketsKey); len(tickets) != 0 {
+		t.Errorf("got tickets %v, want none", tickets)
+	}
+}
+
+func TestInvocationLimiter(t *testing.T) {
+	pool, s := newTestPool(t)
+	l := &invocationLimiter{
+		logger: zap.NewNop(),
+		pool:   pool,
+		cap:    2,
+		leases: make(map[string]bool),
+		stop:   make(chan struct{}),
+	}
+
+	leases, err := l.acquire(2)
+	if err != nil || len(leases) != 2 {
+		t.Fatalf("got leases (%v, %v), want 2", leases, err)
+	}
+
+	acquired := make(chan []string)
+	go func() {
+		leases, err := l.acquire(1)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- leases
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquired a slot over the cap")
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	// releasing a slot lets the waiting round in
+	l.release(leases[0])
+	var waited []string
+	select {
+	case waited = <-acquired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the released slot")
+	}
+	if len(waited) != 1 {
+		t.Errorf("got %d leases, want 1", len(waited))
+	}
+
+	// closing the limiter gives back the slots still held
+	l.close()
+	if held, _ := s.ZMembers(invocationLeasesKey); len(held) != 0 {
+		t.Errorf("got leases %v after closing, want none", held)
+	}
+}
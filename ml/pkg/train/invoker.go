@@ -0,0 +1,236 @@
+package train
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultQueuePrefix is the prefix of the subjects the invocations are published to,
+// followed by the name of the function
+const defaultQueuePrefix = "kubeml"
+
+type (
+	// Invoker sends the invocations of the train and validation functions and
+	// returns the response of the function. The checks of the responses, the
+	// quorum and the merges work on the responses, so they behave the same
+	// with every transport. Cancelling the context abandons the invocation
+	Invoker interface {
+		Invoke(ctx context.Context, funcId int, task FunctionTask, funcUrl string) (*http.Response, error)
+		Close() error
+	}
+
+	// httpInvoker calls the functions through the fission router and
+	// waits for the response, which is subject to the router timeout
+	httpInvoker struct {
+		job *TrainJob
+	}
+
+	// queueInvoker publishes the invocations to a NATS subject consumed by the message
+	// queue trigger of the function, and waits for the function to post its response
+	// to the results endpoint of the job. The router timeout does not apply, so it
+	// suits functions that run longer than the router allows
+	queueInvoker struct {
+		logger    *zap.Logger
+		publisher *natsPublisher
+		subject   string
+		callback  string
+		accept    string
+		timeout   time.Duration
+
+		mu      sync.Mutex
+		pending map[string]chan *http.Response
+
+		// heldSince is set while the merger waits for redis, and held is the
+		// time the invocations were held before. Invocations do not time out
+		// while held, and the time held is added to their timeout
+		heldSince time.Time
+		held      time.Duration
+	}
+)
+
+// initInvoker creates the invoker of the functions for the invocation mode of the job,
+// the queue is set with INVOCATION_QUEUE_URL and INVOCATION_QUEUE_PREFIX. It also
+// creates the limiter of the invocations in flight, see invocationLimiter
+func (job *TrainJob) initInvoker() error {
+	limiter, err := newInvocationLimiter(job.logger, job.redisPool)
+	if err != nil {
+		return err
+	}
+	job.limiter = limiter
+
+	opts := job.task.Parameters.Options
+	if !opts.QueueInvocations() {
+		job.invoker = &httpInvoker{job: job}
+		return nil
+	}
+
+	queueUrl := os.Getenv("INVOCATION_QUEUE_URL")
+	if len(queueUrl) == 0 {
+		return errors.New("INVOCATION_QUEUE_URL is not set")
+	}
+	prefix := os.Getenv("INVOCATION_QUEUE_PREFIX")
+	if len(prefix) == 0 {
+		prefix = defaultQueuePrefix
+	}
+
+	publisher, err := newNatsPublisher(job.logger, queueUrl, "job-"+job.jobId)
+	if err != nil {
+		return errors.Wrap(err, "could not connect to the invocation queue")
+	}
+
+	job.invoker = &queueInvoker{
+		logger:    job.logger.Named("queue"),
+		publisher: publisher,
+		subject:   prefix + "." + job.functionName(),
+		callback:  fmt.Sprintf("http://job-%s.kubeml/results/", job.jobId),
+		accept:    util.ContentType(opts.Serialization),
+		timeout:   opts.InvocationWait(),
+		pending:   make(map[string]chan *http.Response),
+	}
+	job.logger.Info("Invoking functions through the queue",
+		zap.String("subject", prefix+"."+job.functionName()))
+	return nil
+}
+
+// Invoke sends the request to the function
+func (i *httpInvoker) Invoke(ctx context.Context, _ int, _ FunctionTask, funcUrl string) (*http.Response, error) {
+	return i.job.invokeFunction(ctx, funcUrl)
+}
+
+func (i *httpInvoker) Close() error {
+	return nil
+}
+
+// Invoke publishes the arguments in the url of the function and
+// waits until the function posts its response, the timeout passes or the context
+// is cancelled. A response posted after that is dropped by the results endpoint
+func (i *queueInvoker) Invoke(ctx context.Context, funcId int, task FunctionTask, funcUrl string) (*http.Response, error) {
+	u, err := url.Parse(funcUrl)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse function url")
+	}
+
+	id := uuid.New().String()
+	msg := api.InvocationMessage{
+		Id:       id,
+		Function: strings.TrimPrefix(u.Path, "/"),
+		Args:     make(map[string]string),
+		Callback: i.callback + id,
+		Accept:   i.accept,
+	}
+	for key := range u.Query() {
+		msg.Args[key] = u.Query().Get(key)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode invocation")
+	}
+
+	respChan := make(chan *http.Response, 1)
+	i.mu.Lock()
+	i.pending[id] = respChan
+	i.mu.Unlock()
+	defer func() {
+		i.mu.Lock()
+		delete(i.pending, id)
+		i.mu.Unlock()
+	}()
+
+	if err = i.publisher.Publish(i.subject, data); err != nil {
+		return nil, err
+	}
+	i.logger.Debug("Published invocation",
+		zap.Int("funcId", funcId),
+		zap.String("task", string(task)),
+		zap.String("invocation", id))
+
+	start := i.heldFor()
+	var extended time.Duration
+	timer := time.NewTimer(i.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case resp := <-respChan:
+			return resp, nil
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "%s invocation of function %d abandoned", task, funcId)
+		case <-timer.C:
+			if held := i.heldFor() - start; held > extended {
+				timer.Reset(held - extended)
+				extended = held
+				continue
+			}
+			return nil, fmt.Errorf("function %d did not post the result of its %s invocation after %v",
+				funcId, task, i.timeout+extended)
+		}
+	}
+}
+
+// hold keeps the invocations from timing out until release is called
+func (i *queueInvoker) hold() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.heldSince.IsZero() {
+		i.heldSince = time.Now()
+	}
+}
+
+// release adds the time the invocations were held to their timeout
+func (i *queueInvoker) release() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.heldSince.IsZero() {
+		i.held += time.Since(i.heldSince)
+		i.heldSince = time.Time{}
+	}
+}
+
+// heldFor returns the total time the invocations have been held
+func (i *queueInvoker) heldFor() time.Duration {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.heldSince.IsZero() {
+		return i.held
+	}
+	return i.held + time.Since(i.heldSince)
+}
+
+// deliver passes the response posted by a function to its invocation, returning
+// false if no invocation is waiting for it because it timed out or is unknown
+func (i *queueInvoker) deliver(id string, status int, contentType string, body []byte) bool {
+	i.mu.Lock()
+	respChan, exists := i.pending[id]
+	delete(i.pending, id)
+	i.mu.Unlock()
+	if !exists {
+		return false
+	}
+
+	resp := &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+	}
+	resp.Header.Set("Content-Type", contentType)
+	respChan <- resp
+	return true
+}
+
+func (i *queueInvoker) Close() error {
+	return i.publisher.Close()
+}
@@ -0,0 +1,172 @@
+package train
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"sync"
+)
+
+// errStaleNotification is returned for the finish notifications of a previous
+// epoch or iteration, or repeated in the current one. The function has to
+// discard its work instead of continuing with the next iteration
+var errStaleNotification = errors.New("stale finish notification")
+
+type (
+	// iterationState keeps the invocations of the train functions and the
+	// functions that reported in the current iteration of the merger, so that
+	// the notifications of stragglers from a previous epoch or iteration are
+	// not taken as notifications of the current one
+	iterationState struct {
+		mu          sync.Mutex
+		epoch       int
+		iteration   int
+		parallelism int
+
+		// invocations holds the tokens given to the train functions
+		// of every epoch, so the ones of previous epochs are still known
+		invocations map[string]invocation
+
+		// reported are the functions that notified in the current
+		// iteration and finished the ones whose invocation returned
+		reported map[int]bool
+		finished map[int]bool
+
+		// stale is the number of stale notifications
+		// received since they were last taken
+		stale int
+	}
+
+	// invocation is the function and epoch a token was given to
+	invocation struct {
+		funcId int
+		epoch  int
+	}
+)
+
+func newIterationState() *iterationState {
+	return &iterationState{
+		invocations: make(map[string]invocation),
+		reported:    make(map[int]bool),
+		finished:    make(map[int]bool),
+	}
+}
+
+// startEpoch resets the iteration and the functions
+// tracked at the start of a new epoch
+func (s *iterationState) startEpoch(epoch, parallelism int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.epoch = epoch
+	s.iteration = 0
+	s.parallelism = parallelism
+	s.reported = make(map[int]bool)
+	s.finished = make(map[int]bool)
+}
+
+// issue returns the token sent to the function in
+// its invocation for the current epoch
+func (s *iterationState) issue(funcId int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := uuid.New().String()
+	s.invocations[token] = invocation{funcId: funcId, epoch: s.epoch}
+	return token
+}
+
+// accept checks a finish notification against the current epoch and iteration,
+// marking the function as reported if it belongs to them. Returns errStaleNotification
+// for the notifications of previous epochs or iterations, or repeated in the current
+// one, and other errors for the notifications that could not have been sent by an
+// invocation of the job
+func (s *iterationState) accept(funcId, epoch, iteration int, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inv, exists := s.invocations[token]
+	if !exists {
+		return fmt.Errorf("unknown invocation token %q", token)
+	}
+	if inv.funcId != funcId || inv.epoch != epoch {
+		return fmt.Errorf("token was given to function %d of epoch %d", inv.funcId, inv.epoch)
+	}
+
+	if epoch < s.epoch {
+		s.stale++
+		return errors.Wrapf(errStaleNotification, "epoch %d already finished", epoch)
+	}
+	if epoch > s.epoch {
+		return fmt.Errorf("epoch %d has not started", epoch)
+	}
+	if funcId < 0 || funcId >= s.parallelism {
+		return fmt.Errorf("function id %d out of range, parallelism is %d", funcId, s.parallelism)
+	}
+
+	switch {
+	case iteration > s.iteration:
+		return fmt.Errorf("iteration %d has not started", iteration)
+	case iteration < s.iteration:
+		s.stale++
+		return errors.Wrapf(errStaleNotification, "iteration %d already merged", iteration)
+	case s.finished[funcId]:
+		s.stale++
+		return errors.Wrapf(errStaleNotification, "invocation of function %d already returned", funcId)
+	case s.reported[funcId]:
+		s.stale++
+		return errors.Wrapf(errStaleNotification, "function %d already reported in iteration %d", funcId, iteration)
+	}
+
+	s.reported[funcId] = true
+	return nil
+}
+
+// finish marks that the invocation of the function returned and calls report
+// if the function still has to be counted in the current iteration, which is
+// not the case if it reported in it before the invocation returned. Returns
+// whether report was called
+func (s *iterationState) finish(funcId int, report func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.finished[funcId] = true
+	if s.reported[funcId] {
+		return false
+	}
+	s.reported[funcId] = true
+	report()
+	return true
+}
+
+// advance moves to the next iteration after a merge and returns the number
+// of functions that are still running. The merger resets its channels in next,
+// before the invocations that return in the new iteration can send to them
+func (s *iterationState) advance(next func(remaining int)) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.iteration++
+	s.reported = make(map[int]bool)
+	remaining := s.parallelism - len(s.finished)
+	next(remaining)
+	return remaining
+}
+
+// current returns the iteration the merger is in
+func (s *iterationState) current() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.iteration
+}
+
+// takeStale returns the stale notifications received since the last call,
+// so the ones that arrive between two epochs count in the next one
+func (s *iterationState) takeStale() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stale := s.stale
+	s.stale = 0
+	return stale
+}
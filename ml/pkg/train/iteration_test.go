@@ -0,0 +1,161 @@
+package train
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+const (
+	accepted = "accepted"
+	stale    = "stale"
+	invalid  = "invalid"
+)
+
+// outcome classifies the error of accept
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return accepted
+	case errors.Cause(err) == errStaleNotification:
+		return stale
+	default:
+		return invalid
+	}
+}
+
+func TestAcceptReplay(t *testing.T) {
+	s := newIterationState()
+	s.startEpoch(1, 2)
+	f0, f1 := s.issue(0), s.issue(1)
+
+	type notification struct {
+		name      string
+		funcId    int
+		epoch     int
+		iteration int
+		token     string
+		want      string
+	}
+	check := func(steps []notification) {
+		t.Helper()
+		for _, n := range steps {
+			if got := outcome(s.accept(n.funcId, n.epoch, n.iteration, n.token)); got != n.want {
+				t.Errorf("%s: got notification %s, want %s", n.name, got, n.want)
+			}
+		}
+	}
+
+	check([]notification{
+		{"first notification", 0, 1, 0, f0, accepted},
+		{"duplicate", 0, 1, 0, f0, stale},
+		{"other function", 1, 1, 0, f1, accepted},
+		{"future iteration", 0, 1, 1, f0, invalid},
+		{"token of another function", 0, 1, 0, f1, invalid},
+		{"unknown token", 0, 1, 0, "forged", invalid},
+	})
+
+	s.advance(func(int) {})
+	check([]notification{
+		{"replay of the merged iteration", 1, 1, 0, f1, stale},
+		{"next iteration", 0, 1, 1, f0, accepted},
+	})
+
+	// the invocation of function 1 returned and was counted in the iteration
+	if !s.finish(1, func() {}) {
+		t.Error("got the returned function not counted")
+	}
+	check([]notification{
+		{"after the invocation returned", 1, 1, 1, f1, stale},
+	})
+
+	s.startEpoch(2, 1)
+	next := s.issue(0)
+	check([]notification{
+		{"replay of the finished epoch", 0, 1, 1, f0, stale},
+		{"token of the finished epoch", 0, 2, 0, f0, invalid},
+		{"function out of the parallelism", 1, 2, 0, f1, invalid},
+		{"new epoch", 0, 2, 0, next, accepted},
+	})
+
+	// the stale notifications are counted once
+	if got := s.takeStale(); got != 4 {
+		t.Errorf("got %d stale notifications, want 4", got)
+	}
+	if got := s.takeStale(); got != 0 {
+		t.Errorf("got %d stale notifications after taking them, want 0", got)
+	}
+}
+
+func TestAcceptConcurrentDuplicates(t *testing.T) {
+	s := newIterationState()
+	s.startEpoch(1, 1)
+	token := s.issue(0)
+
+	// a notification retried many times at once is only accepted once
+	const replays = 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	outcomes := make(map[string]int)
+	for i := 0; i < replays; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o := outcome(s.accept(0, 1, 0, token))
+			mu.Lock()
+			outcomes[o]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if outcomes[accepted] != 1 || outcomes[stale] != replays-1 {
+		t.Errorf("got outcomes %v, want one accepted and the rest stale", outcomes)
+	}
+	if got := s.takeStale(); got != replays-1 {
+		t.Errorf("got %d stale notifications, want %d", got, replays-1)
+	}
+}
+
+func TestNextIterationRejectsReplays(t *testing.T) {
+	job := &TrainJob{
+		logger:     zap.NewNop(),
+		iterations: newIterationState(),
+		finishes:   newFinishQueue(),
+	}
+	job.iterations.startEpoch(2, 1)
+	old := job.iterations.issue(0)
+	job.iterations.startEpoch(3, 1)
+
+	tests := []struct {
+		name  string
+		epoch int
+		token string
+		code  int
+	}{
+		{"stale epoch", 2, old, http.StatusConflict},
+		{"forged token", 3, "forged", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := fmt.Sprintf("/finish/0?epoch=%d&iteration=0&token=%s", tt.epoch, tt.token)
+			r := mux.SetURLVars(httptest.NewRequest(http.MethodPost, url, nil), map[string]string{"funcId": "0"})
+			w := httptest.NewRecorder()
+
+			// the handler answers without waiting for a merge
+			job.nextIteration(w, r)
+			if w.Code != tt.code {
+				t.Errorf("got status %d, want %d", w.Code, tt.code)
+			}
+			if queued := job.finishes.drain(); len(queued) != 0 {
+				t.Errorf("got %d finish notifications queued, want none", len(queued))
+			}
+		})
+	}
+}
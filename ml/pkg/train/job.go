@@ -1,6 +1,7 @@
 package train
 
 import (
+	"context"
 	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
 	"github.com/diegostock12/kubeml/ml/pkg/model"
@@ -11,17 +12,31 @@ import (
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
+const (
+	// maxSaveRetries is the number of times the merger retries saving
+	// the reference model before failing the epoch
+	maxSaveRetries   = 3
+	saveRetryBackoff = 100 * time.Millisecond
+)
+
 // TrainJob is each of the workers launched by the parameter server.
 // The worker is responsible from managing the reference model, saving the
 // intermediate accuracy/validation results in the history, and requesting/receiving
-// new scheduling responses from the scheduler
+// new scheduling responses from the scheduler.
+//
+// The training loop is the only writer of the history, the task and the epoch.
+// The api handlers and the parameter server read them through state instead,
+// see jobState
 type TrainJob struct {
 	logger *zap.Logger
 
+	// level filters the logs of the job and all its child
+	// loggers, it can be changed while the job runs
+	level zap.AtomicLevel
+
 	// clients for other components
 	scheduler *schedulerClient.Client
 	ps        *psClient.Client
@@ -36,16 +51,130 @@ type TrainJob struct {
 	optimizer model.ParallelSGD
 
 	// options of the trainjob
-	parallelism   int
-	static        bool
-	validateEvery int
-	K             int
-	goalAccuracy  float64 // validation accuracy that marks the stop moment
+	parallelism     int
+	static          bool
+	validateEvery   int
+	K               int
+	goalAccuracy    float64 // validation accuracy that marks the stop moment
+	canaryBatchSize int
+
+	// batch and learning rate of the functions in the current epoch, see
+	// updateBatch. globalBatch is the batch of all the functions together
+	// and referenceBatch the one the learning rate of the request is for
+	batchSize      int
+	globalBatch    int
+	referenceBatch int
+	learningRate   float32
+
+	// capacities are the capacities reported by the train functions in the
+	// last epoch, keyed by the function id. If the job balances the functions
+	// by capacity, shares is the fraction of the data and batchSizes the batch
+	// of each function in the current epoch, both nil otherwise
+	capacities map[int]float64
+	shares     []float64
+	batchSizes []int
+
+	// devices are the devices the train functions reported in the
+	// current epoch, which weight their models in the merge
+	devices *deviceCounts
+
+	// sequence number of the last metric update sent to the PS
+	metricSeq int64
+
+	// notifier sends the events of the job to its notification
+	// url, nil if the job has none. notifiedEpoch is the last
+	// epoch whose event was queued
+	notifier      *notifier
+	notifiedEpoch int
+
+	// historyWriter saves the history of the job in the database
+	// periodically while training, nil if it could not be started
+	historyWriter *historyWriter
+
+	// audit records the data assigned to the functions every
+	// epoch, nil if the job does not audit its data
+	audit *api.DataAudit
+
+	// checkpoints is the object store where the model is checkpointed
+	// after every epoch, nil if the job keeps it only in redis
+	checkpoints model.ObjectStore
+
+	// savedCheckpoints are the manifests of the checkpoints of
+	// the job kept in object storage, in the order they were saved
+	savedCheckpoints []*api.CheckpointManifest
+
+	// invoker sends the invocations of the train and
+	// validation functions, see InvocationMode
+	invoker Invoker
+
+	// limiter keeps the invocations in flight across the cluster
+	// under a cap, nil if they are not limited
+	limiter *invocationLimiter
+
+	// strictResponses is set when the function reports the schema of its
+	// responses at init, so they are checked against it, see ResponseSchema
+	strictResponses bool
+
+	// memory in bytes used by the tensors of the job in redis
+	// the last time it was measured
+	redisMemory int64
+
+	// number of merges done in the current epoch, compared
+	// with the iterations planned by the controller
+	merges int
+
+	// sum of the L2 norms of the updates made by
+	// the merges of the current epoch
+	updateNorms float64
+
+	// layerGroups holds the layers of the model grouped by their sync
+	// cadence, nil if the job sets no layer sync overrides. groupMerges
+	// is the number of merges of each group in the current epoch
+	layerGroups *api.LayerGroups
+	groupMerges []int
+
+	// summary holds the layers of the model read once it is built, as they
+	// do not change while it trains, and is published in state for the api
+	// handlers. Its size decides if the merges take a merge slot, and mergeWait
+	// is the time the merges of the current epoch waited for one, see mergeSemaphore
+	summary   *api.ModelSummary
+	mergeWait time.Duration
+
+	// barrierWait is the time the functions of the current epoch were
+	// blocked at the merges until the last one of the iteration arrived
+	// and the merge got a merge slot
+	barrierWait time.Duration
+
+	// mergeTime is the time the merges of the current epoch took to
+	// average and save the model, which the adaptive K is tuned from
+	mergeTime time.Duration
+
+	// modelMu is held by the merger while it saves the reference model, by the
+	// validations against the latest merge while their functions run and by the
+	// snapshots while they copy it, so they never read a model that is half
+	// saved or replaced midway
+	modelMu *sync.Mutex
+
+	// snapshots are the copies of the reference model kept for the inferences
+	// run while the job trains, the latest last, see publishSnapshot. They are
+	// read by the api handlers, so they are guarded by snapshotMu
+	snapshotMu sync.Mutex
+	snapshots  []*api.LiveSnapshot
 
 	// channel to receive updates from the scheduler
 	// through the api
 	schedulerCh chan *api.JobState
 
+	// decisionEpochs is the number of epochs the last decision of the
+	// scheduler still holds, and decisionTime the epoch time it was
+	// taken with, used to ask the scheduler early if it changes
+	decisionEpochs int
+	decisionTime   float64
+
+	// parallelismCapped is set once a parallelism over the max
+	// parallelism of the job is capped, see capMaxParallelism
+	parallelismCapped bool
+
 	// this channel needs to be buffered to prevent deadlock, if the validation
 	// reaches the accuracy in the final validation outside of the loop,
 	// it will try to reach the loop by sending to the channel, but the main
@@ -54,17 +183,47 @@ type TrainJob struct {
 	accuracyCh      chan struct{}
 	accuracyReached bool
 
-	// function synchronization, waitgroup
-	// and index to track functions during an iteration
-	wgIteration   *sync.WaitGroup
-	finishedFuncs int64
-	startMerger   chan chan error
-	finishCh      chan *finishNotification
-	merged        chan struct{}
+	// function synchronization, waitgroup and the state
+	// of the iteration to track functions during an iteration
+	wgIteration *sync.WaitGroup
+	iterations  *iterationState
+	startMerger chan chan error
+	finishes    *finishQueue
+	merged      chan struct{}
+
+	// allReduce is the allreduce merge in progress with the allreduce topology
+	allReduce *allReduceRound
+
+	// finished is closed once the job reported its finish, and clearing
+	// tracks the deletion of its tensors, so a standalone job exits after
+	finished chan struct{}
+	clearing sync.WaitGroup
+
+	// markFinish saves the marker of a standalone job that could not report
+	// its finish, see reportFinish. It is nil for the jobs run in the
+	// parameter server, which only reconciles the standalone ones
+	markFinish func(jobId string, exitErr error) error
+
+	// active holds the invocations of the functions in flight
+	active *activeInvocations
+
+	// durations holds the histograms of the durations of the invocations
+	durations *invocationDurations
+
+	// state holds the copies of the task and the history published
+	// by the training loop for the goroutines outside of it
+	state *jobState
 
 	// keep track of the start time to compute stats
 	startTime time.Time
 
+	// pauses holds the pauses requested through the API, pause is set
+	// while the job is paused and pausedTime is the time spent paused,
+	// which is not counted in the elapsed time of the job
+	pauses     *pauseControl
+	pause      *api.JobPause
+	pausedTime time.Duration
+
 	stopChan chan struct{}
 	// exitErr holds the error that caused the job to quit
 	// it is sent to the Ps along the finish signal so it can be
@@ -81,8 +240,10 @@ func NewTrainJob(
 
 	logger.Info("Creating new train job")
 
+	level := zap.NewAtomicLevelAt(zap.DebugLevel)
 	job := &TrainJob{
-		logger:      logger.Named(fmt.Sprintf("trainJob-%s", task.Job.JobId)),
+		logger:      withLevel(logger.Named(fmt.Sprintf("trainJob-%s", task.Job.JobId)), level),
+		level:       level,
 		scheduler:   client,
 		jobId:       task.Job.JobId,
 		schedulerCh: schedulerCh,
@@ -91,7 +252,16 @@ func NewTrainJob(
 		startMerger: make(chan chan error),
 		accuracyCh:  make(chan struct{}, 1),
 		wgIteration: &sync.WaitGroup{},
+		iterations:  newIterationState(),
+		active:      newActiveInvocations(),
+		durations:   newInvocationDurations(),
+		state:       &jobState{},
+		finishes:    newFinishQueue(),
+		allReduce:   &allReduceRound{},
+		finished:    make(chan struct{}),
+		modelMu:     &sync.Mutex{},
 		merged:      make(chan struct{}),
+		pauses:      newPauseControl(),
 		stopChan:    make(chan struct{}, 1),
 	}
 
@@ -118,16 +288,28 @@ func NewTrainJob(
 func NewBasicJob(logger *zap.Logger, jobId string) *TrainJob {
 	logger.Info("Creating new basic train job")
 
+	level := zap.NewAtomicLevelAt(zap.DebugLevel)
 	job := &TrainJob{
-		logger:      logger.Named(fmt.Sprintf("trainJob-%s", jobId)),
+		logger:      withLevel(logger.Named(fmt.Sprintf("trainJob-%s", jobId)), level),
+		level:       level,
 		jobId:       jobId,
 		schedulerCh: make(chan *api.JobState),
+		markFinish:  writeUnreportedFinish,
 		redisPool:   util.GetRedisConnectionPool(),
 		history:     api.JobHistory{},
 		startMerger: make(chan chan error),
 		accuracyCh:  make(chan struct{}, 1),
 		wgIteration: &sync.WaitGroup{},
+		iterations:  newIterationState(),
+		active:      newActiveInvocations(),
+		durations:   newInvocationDurations(),
+		state:       &jobState{},
+		finishes:    newFinishQueue(),
+		allReduce:   &allReduceRound{},
+		finished:    make(chan struct{}),
+		modelMu:     &sync.Mutex{},
 		merged:      make(chan struct{}),
+		pauses:      newPauseControl(),
 		stopChan:    make(chan struct{}, 1),
 	}
 
@@ -142,10 +324,25 @@ func NewBasicJob(logger *zap.Logger, jobId string) *TrainJob {
 func (job *TrainJob) extractTaskSettings(task api.TrainTask) {
 	job.task = &task
 	job.parallelism = task.Job.State.Parallelism
+	if parallelism, capped := task.Parameters.Options.CapParallelism(job.parallelism); capped {
+		job.logger.Warn("The job was started over its max parallelism, capping it",
+			zap.Int("requested", job.parallelism),
+			zap.Int("max", parallelism))
+		job.parallelism = parallelism
+	}
 	job.static = task.Parameters.Options.StaticParallelism
 	job.validateEvery = task.Parameters.Options.ValidateEvery
-	job.K = task.Parameters.Options.K
+	// the functions sync every time a layer group is due
+	job.K = task.Parameters.Options.SyncPeriod()
 	job.goalAccuracy = task.Parameters.Options.GoalAccuracy
+	job.canaryBatchSize = task.Parameters.Options.CanaryBatchSize
+	job.devices = newDeviceCounts(task.Parameters.Options.FunctionDevices())
+	job.durations.setBounds(task.Parameters.Options.DurationBucketsOrDefault().Bounds())
+	// an absolute straggler timeout holds from the first epoch
+	job.updateStragglerTimeout(job.epoch)
+	job.setLogLevel(task.Parameters.Options.LogLevel)
+	job.updateBatch()
+	job.publishState()
 }
 
 // Train is the main
@@ -162,11 +359,29 @@ func (job *TrainJob) Train() {
 		// After the job is finished
 		// unregister the prometheus exposed metrics,
 		// clear connections and send the finish signal to the parameter
-		// server
-		job.clearTensors()
-		job.redisPool.Close()
+		// server. The tensors are deleted after the finish is sent, since
+		// it can take long for big models and nothing depends on it
+		if job.notifier != nil {
+			job.notifier.close()
+		}
+		if job.invoker != nil {
+			job.invoker.Close()
+		}
+		if job.limiter != nil {
+			job.limiter.close()
+		}
+		job.scheduler.CancelUpdates(job.jobId)
+		job.recordUpdateStats()
+		job.closeHistory()
+		job.saveAudit()
 		job.logger.Debug("closing job", zap.Error(job.exitErr))
-		job.ps.JobFinished(job.jobId, job.exitErr)
+		job.reportFinish()
+		job.clearing.Add(1)
+		go func() {
+			defer job.clearing.Done()
+			job.clearTensors()
+		}()
+		close(job.finished)
 	}()
 
 	// Call the init function and build the reference model,
@@ -179,11 +394,58 @@ func (job *TrainJob) Train() {
 		return
 	}
 
+	// fail fast if the model does not fit in the redis budget
+	if err = job.checkModelBudget(); err != nil {
+		job.logger.Error("Model exceeds the redis budget of the job",
+			zap.Error(err))
+		job.exitErr = err
+		return
+	}
+
+	if !job.task.Parameters.Options.SkipSanityCheck {
+		if err = job.sanityCheck(); err != nil {
+			job.logger.Error("Sanity check failed", zap.Error(err))
+			job.exitErr = err
+			job.saveTrainingHistory()
+			return
+		}
+	}
+
+	if err = job.initCheckpoints(); err != nil {
+		job.logger.Error("Could not initialize checkpoints",
+			zap.Error(err))
+		job.exitErr = err
+		return
+	}
+
+	if err = job.initInvoker(); err != nil {
+		job.logger.Error("Could not initialize function invoker",
+			zap.Error(err))
+		job.exitErr = err
+		return
+	}
+
+	// the functions are warmed up before the start time is taken, so
+	// the first epoch measured by the scheduler has no cold starts
+	if job.task.Parameters.Options.WarmUpFunctions {
+		job.warmUp()
+	}
+
 	// Main training loop
 	job.startTime = time.Now()
+	job.initMilestones()
+	job.startHistoryWriter()
+	if job.task.Parameters.Options.AuditData {
+		job.audit = newDataAudit(job.jobId, job.task.Parameters)
+		job.loadContinuedAudit()
+	}
+	if url := job.task.Parameters.Options.NotifyURL; len(url) > 0 {
+		job.notifier = newNotifier(job.logger, url, job.task.Parameters.NotifySecret)
+	}
 
+	// a continued job starts after the last epoch of the run it continues
 main:
-	for job.epoch = 1; job.epoch <= job.task.Parameters.Epochs; job.epoch++ {
+	for job.epoch = job.history.Epochs() + 1; job.epoch <= job.task.Parameters.Epochs; job.epoch++ {
 
 		err := job.train()
 		if err != nil {
@@ -192,50 +454,116 @@ main:
 			return
 		}
 
-		// If we need, ask the scheduler for updated settings
-		if !job.static && job.epoch < job.task.Parameters.Epochs {
-			err = job.scheduler.UpdateJob(job.task)
-			if err != nil {
-				job.logger.Error("Error updating parallelism",
-					zap.Error(err))
-				continue
-			}
-
-			update := <-job.schedulerCh
-			job.logger.Info("Received next config from the Scheduler",
-				zap.Int("new parallelism", update.Parallelism))
+		// the validation against the latest merge runs
+		// while the job waits for the scheduler
+		var pending *pendingValidation
+		if job.validationDue() && job.task.Parameters.Options.ValidatesLatest() {
+			pending = job.startLatestValidation()
+		}
 
-			// Get the new parallelism and update it in the history
-			job.task.Job.State = *update
-			if !util.IsDebugEnv() && !util.LimitParallelism() {
-				job.logger.Debug("updating parallelism...")
-				job.parallelism = update.Parallelism
+		// If we need, ask the scheduler for updated settings, static jobs
+		// never ask and dynamic ones keep a decision while it holds
+		needsUpdate := !job.static && job.epoch < job.task.Parameters.Epochs
+		if needsUpdate && !job.needsScheduler() {
+			job.skipScheduler()
+		} else if needsUpdate {
+			err = job.scheduler.UpdateJob(job.task)
+			if err == schedulerClient.ErrRedundantUpdate {
+				job.skipRedundantUpdate()
+			} else {
+				job.history.SchedulerContacts++
+				if err != nil {
+					job.logger.Error("Error updating parallelism",
+						zap.Error(err))
+					continue
+				}
+
+				// if the scheduler does not answer keep training with the current parallelism
+				update, err := job.waitSchedulerUpdate()
+				if err != nil {
+					job.logger.Error("Error updating parallelism",
+						zap.Int("parallelism", job.parallelism),
+						zap.Error(err))
+				} else {
+					job.logger.Info("Received next config from the Scheduler",
+						zap.Int("new parallelism", update.Parallelism))
+
+					// Get the new parallelism and update it in the history
+					job.capMaxParallelism(update)
+					job.capNearGoal(update)
+					job.recordDecision(update)
+					job.task.Job.State = *update
+					if !util.IsDebugEnv() && !util.LimitParallelism() {
+						job.logger.Debug("updating parallelism...")
+						job.parallelism = update.Parallelism
+					}
+				}
 			}
-
 		}
+		job.recordUpdateStats()
 
 		// receive signal that the models are merged
 		job.logger.Debug("Waiting for merge to complete...")
 		<-job.merged
 
-		// Trigger validation if configured
-		if job.validateEvery != 0 &&
-			job.epoch%job.validateEvery == 0 &&
-			job.epoch != job.task.Parameters.Epochs {
+		job.logger.Debug("Merges done in the epoch",
+			zap.Int("merges", job.merges),
+			zap.Int("planned", job.task.Parameters.PlannedIterations))
+		job.recordMerges()
+		job.adaptK()
+		job.saveCheckpoint()
+		job.publishSnapshot()
 
-			err = job.validate()
+		if err = job.checkBudget(); err != nil {
+			job.logger.Error("Job exceeds its redis budget",
+				zap.Error(err))
+			job.exitErr = err
+			return
+		}
+
+		// Evaluate the canary batch every epoch if configured
+		if job.canaryBatchSize > 0 {
+			err = job.validateCanary()
 			if err != nil {
-				job.logger.Error("error performing validation",
+				job.logger.Error("error performing canary validation",
+					zap.Error(err))
+			}
+		}
+
+		// Trigger validation if configured
+		if job.validationDue() {
+			if pending != nil {
+				err = job.finishValidation(pending)
+			} else {
+				err = job.validate()
+			}
+			if err = job.handleValidationError(err, job.validate); err != nil {
+				job.logger.Error("Stopping the job after a failed validation",
 					zap.Error(err))
+				job.exitErr = err
+				job.saveTrainingHistory()
+				return
 			}
 		}
 
+		job.recordEpochCost()
+		job.recordDurations()
+		job.checkStopRules()
+		job.bufferHistory()
+
+		// the last epoch is notified after its validation
+		if job.epoch != job.task.Parameters.Epochs {
+			job.notifyEpoch()
+		}
+
 		// check if the validation returned and we reached the goal average
 		select {
 		case <-job.stopChan:
 			job.logger.Debug("Job stopping...")
 			job.accuracyReached = true
-			job.exitErr = errors.New("job was force stopped")
+			if len(job.history.StoppedBy) == 0 {
+				job.exitErr = errors.New(api.ForceStoppedError)
+			}
 			break main
 		case <-job.accuracyCh:
 			job.logger.Debug("goal accuracy reached!, exiting")
@@ -243,22 +571,58 @@ main:
 			break main
 		default:
 		}
+
+		// the job pauses between epochs, once the model is merged and checkpointed,
+		// there is nothing left to pause for after the last one
+		if job.epoch < job.task.Parameters.Epochs {
+			if pause := job.pauses.take(); pause != nil && job.waitPaused(*pause) {
+				job.accuracyReached = true
+				job.exitErr = errors.New(api.ForceStoppedError)
+				break main
+			}
+		}
 	}
 
 	// if the accuracy is already reached, no need to
-	// validate again
+	// validate again. The model of the last epoch is already
+	// checkpointed, so a stop only interrupts the validation
 	if !job.accuracyReached {
-		err = job.validate()
-		if err != nil {
-			job.logger.Error("error performing validation",
+		err = job.validateFinal()
+		if err = job.handleValidationError(err, job.validateFinal); err != nil {
+			job.logger.Error("The final validation failed",
 				zap.Error(err))
+			if job.exitErr == nil {
+				job.exitErr = err
+			}
 		}
 	}
 
+	job.recordEpochCost()
+	job.recordDurations()
+	job.notifyEpoch()
+	job.recordEfficiency()
+
 	// Wait for the val functions to finish if there
 	// are still some running
 	job.saveTrainingHistory()
 
+	opts := job.task.Parameters.Options
+	for _, metric := range []string{api.MetricAccuracy, api.MetricValidationLoss} {
+		if value, epoch, ok := job.history.Best(metric, opts); ok {
+			job.logger.Info("Best validation result",
+				zap.String("metric", metric),
+				zap.String("direction", opts.Direction(metric)),
+				zap.Float64("value", value),
+				zap.Int("epoch", epoch))
+		}
+	}
+
+	for _, m := range job.history.Milestones {
+		if !m.Reached {
+			job.logger.Info("Accuracy milestone not reached", zap.Float64("accuracy", m.Accuracy))
+		}
+	}
+
 	job.logger.Info("Exiting...", zap.Any("history", job.history))
 	job.logger.Info(fmt.Sprintf("Training finished after %d epochs", job.epoch-1))
 
@@ -277,16 +641,62 @@ func (job *TrainJob) init() error {
 	}
 
 	job.logger.Debug("Received layers", zap.Any("layers", layers))
+
+	// the overrides can only be checked against the layers of the model
+	job.layerGroups, err = job.task.Parameters.Options.GroupLayers(layers)
+	if err != nil {
+		return errors.Wrap(err, "invalid layer sync overrides")
+	}
+	if job.layerGroups != nil {
+		job.logger.Info("Syncing layer groups with their own K",
+			zap.Ints("K", job.layerGroups.Ks),
+			zap.Any("layers", job.layerGroups.Layers))
+	}
+
+	// unlike the other capabilities, a function that does not report them
+	// can't average its layers, so it is not taken as capable
+	if job.task.Parameters.Options.AllReduce() &&
+		(job.history.Capabilities == nil || !job.history.HasCapability(api.CapabilityAllReduce)) {
+		return errors.Errorf("the function does not support the %s sync topology, update its kubeml library or use %s",
+			api.SyncTopologyAllReduce, api.SyncTopologyParameterServer)
+	}
+
 	job.logger.Debug("Creating model")
-	m := model.NewModel(job.logger, job.jobId, job.task.Parameters, layers, job.redisPool)
+	m := model.NewModel(job.logger, job.jobId, job.task.Parameters, layers, model.NewRedisStore(job.redisPool))
 	job.model = m
 
+	if len(job.task.Parameters.Options.ResumeFrom) > 0 {
+		if err = job.resume(layers); err != nil {
+			return errors.Wrap(err, "error resuming model")
+		}
+	}
+
+	if job.continues() {
+		if err = job.loadContinuedHistory(); err != nil {
+			return errors.Wrap(err, "error continuing job")
+		}
+	}
+
+	// the scale declared by the function takes precedence over
+	// the one detected in the run the job continues
+	scale, source := job.history.DeclaredAccuracyScale(), "declared"
+	if len(scale) == 0 {
+		scale, source = job.history.AccuracyScale, "continued"
+	}
+	if len(scale) > 0 {
+		if err = job.setAccuracyScale(scale, source); err != nil {
+			return err
+		}
+	}
+
 	err = m.Build()
 	if err != nil {
 		return errors.Wrap(err, "error building model")
 	}
 
 	m.Summary()
+	job.summary = m.Describe()
+	job.state.setSummary(job.summary)
 	return nil
 }
 
@@ -294,13 +704,28 @@ func (job *TrainJob) init() error {
 // returns the total time that the model spent training
 func (job *TrainJob) train() error {
 	job.logger.Info("Started new epoch", zap.Int("epoch", job.epoch))
+	job.publishState()
 
 	// set the channels and wait groups for the
 	// K-AVG model merger to receive models from the
 	// functions every K local forward passes
-	job.finishCh = make(chan *finishNotification, job.parallelism)
+	if discarded := job.finishes.reset(); discarded > 0 {
+		job.logger.Warn("Discarded finish notifications of the previous epoch", zap.Int("num", discarded))
+	}
 	job.wgIteration.Add(job.parallelism)
-	atomic.StoreInt64(&job.finishedFuncs, 0)
+	job.iterations.startEpoch(job.epoch, job.parallelism)
+	job.devices.reset()
+	job.merges = 0
+	job.updateNorms = 0
+	if job.layerGroups != nil {
+		job.groupMerges = make([]int, len(job.layerGroups.Ks))
+	}
+	job.mergeWait = 0
+	job.barrierWait = 0
+	job.mergeTime = 0
+	job.updateBatch()
+	job.recordBatch()
+	job.recordDataAssignment()
 	errChan := make(chan error, 1)
 	job.startMerger <- errChan
 
@@ -322,45 +747,121 @@ func (job *TrainJob) train() error {
 	job.task.Job.State.ElapsedTime = elapsed.Seconds()
 
 	job.logger.Info("Epoch finished")
+	job.recordConcurrency()
 
 	// update the training metrics
-	err = job.updateTrainMetrics(loss, time.Since(job.startTime))
+	err = job.updateTrainMetrics(loss, time.Since(job.startTime)-job.pausedTime)
 	if err != nil {
 		job.logger.Error("error updating metrics", zap.Error(err))
 	}
 
+	// keep the recent epochs the scheduler policy smooths the epoch time with
+	job.task.Job.State.AppendWindow(api.EpochPoint{
+		Epoch:               job.epoch,
+		Parallelism:         job.parallelism,
+		ElapsedTime:         elapsed.Seconds(),
+		TrainLoss:           loss,
+		MeasuredConcurrency: job.task.Job.State.MeasuredConcurrency,
+	}, job.task.Parameters.Options.SchedulerPolicyWindow())
+
 	job.logger.Debug("History updated", zap.Any("history", job.history))
 	return nil
 }
 
+// waitSchedulerUpdate waits for the scheduler to send the state for the next
+// epoch, returning an error if it does not arrive before the timeout of the job
+func (job *TrainJob) waitSchedulerUpdate() (*api.JobState, error) {
+	timeout := job.task.Parameters.Options.UpdateTimeout()
+	select {
+	case update := <-job.schedulerCh:
+		return update, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("timed out after %v waiting for the scheduler", timeout)
+	}
+}
+
 // validate invokes the validation functions
 // it uses the same degree of parallelism as the train functions and
 // averages the results from the functions later
 func (job *TrainJob) validate() error {
 	// invoke the validation function concurrently
-	accuracy, loss, err := job.invokeValFunctions()
+	results, err := job.invokeValFunctions(context.Background(), job.parallelism)
 	if err != nil {
 		return errors.Wrap(err, "error during validation")
 	}
+	return job.recordValidation(results, job.merges)
+}
 
-	err = job.updateValidationMetrics(loss, accuracy)
+// recordValidation saves the results of a validation run against the model
+// with the given merges of the epoch, and notifies if the goal was reached. A
+// validation that evaluated no datapoints is not recorded, since its metrics
+// are not defined, see recordNoValidationData
+func (job *TrainJob) recordValidation(results *validationResults, merges int) error {
+	if results.samples == 0 {
+		job.recordNoValidationData(results)
+		return nil
+	}
+
+	// the history, the goal and the milestones are in percent
+	accuracy, err := job.normalizeAccuracy(results.accuracy)
+	if err != nil {
+		return err
+	}
+	results.accuracy = accuracy
+
+	if job.task.Parameters.Options.ValidatesLatest() {
+		job.setEpochMetrics(map[string]float64{api.MetricValidationMerges: float64(merges)})
+	}
+
+	err = job.updateValidationMetrics(results.loss, results.accuracy, results.responses, results.classes)
 	if err != nil {
 		return errors.Wrap(err, "error sending val results")
 	}
 
+	if job.accuracyScaleKnown() {
+		job.recordMilestones(results.accuracy)
+	}
 	job.logger.Debug("History updated", zap.Any("history", job.history))
 
 	// if the accuracy reached the goal, send the notification
-	if accuracy >= job.goalAccuracy {
+	if job.accuracyScaleKnown() && job.task.Parameters.Options.GoalReached(api.MetricAccuracy, results.accuracy, job.goalAccuracy) {
+		if len(results.failed) > 0 {
+			job.logger.Warn("goal accuracy reached on a partial validation, confidence is reduced",
+				zap.Int("responses", results.responses),
+				zap.Ints("failed", results.failed))
+		}
 		job.logger.Debug("goal accuracy reached, sending message",
 			zap.Float64("goal", job.goalAccuracy),
-			zap.Float64("acc", accuracy))
+			zap.Float64("acc", results.accuracy))
 		job.accuracyCh <- struct{}{}
 	}
 
 	return nil
 }
 
+// validateCanary evaluates the model on the fixed canary batch, which is a
+// cheap high-frequency signal to catch divergence between full validations
+func (job *TrainJob) validateCanary() error {
+	accuracy, loss, err := job.invokeCanaryFunction()
+	if err != nil {
+		return errors.Wrap(err, "error during canary validation")
+	}
+	if accuracy, err = job.normalizeAccuracy(accuracy); err != nil {
+		return err
+	}
+
+	job.setEpochMetrics(map[string]float64{
+		api.MetricCanaryLoss:     loss,
+		api.MetricCanaryAccuracy: accuracy,
+	})
+
+	job.logger.Debug("Got canary results",
+		zap.Float64("accuracy", accuracy),
+		zap.Float64("loss", loss))
+
+	return nil
+}
+
 // mergeModel waits for a signal to start listening to functions requests
 //
 // After all running functions completing, it iterates through the function notifications
@@ -374,15 +875,24 @@ func (job *TrainJob) mergeModel() {
 			job.model.Clear()
 			job.logger.Debug("Waiting for functions to finish...")
 			job.wgIteration.Wait()
+			barrier := time.Now()
 
 			// get the function ids that will be taken into account
-			// when fetching and merging the model
+			// when fetching and merging the model, the functions
+			// that finished their data have no channel to answer
+			// and are not blocked at the barrier
 			var funcs []int
 			var channels []chan MergeResult
-			close(job.finishCh)
-			for msg := range job.finishCh {
+			finished := false
+			blocked := 0
+			for _, msg := range job.finishes.drain() {
 				funcs = append(funcs, msg.funcId)
 				channels = append(channels, msg.respChan)
+				finished = finished || msg.respChan == nil
+				if msg.respChan != nil {
+					job.barrierWait += barrier.Sub(msg.arrived)
+					blocked++
+				}
 			}
 
 			if len(funcs) == 0 {
@@ -390,19 +900,53 @@ func (job *TrainJob) mergeModel() {
 				break
 			}
 
+			// with allreduce the functions average the layers themselves, except
+			// in the last merge of the epoch, which the job makes with their layers
+			if job.task.Parameters.Options.AllReduce() {
+				if !finished {
+					if err := job.allReduceMerge(funcs, channels); err != nil {
+						job.logger.Error("error in allreduce", zap.Error(err))
+						errChan <- err
+						break
+					}
+					continue
+				}
+				job.loadAllReduceModels(funcs, channels)
+			}
+
 			// once all are done, merge the model and update
-			job.logger.Debug("Merging models after iteration", zap.Ints("finishCh", funcs))
+			job.logger.Debug("Merging models after iteration", zap.Ints("funcs", funcs))
+
+			// the slot is held from the average until the model is saved, so
+			// the merges of the jobs of the process do not compete for the cpu
+			release := job.acquireMergeSlot(blocked)
 
 			// time the merge time for tests
 			mergeStart := time.Now()
 			err := job.optimizer.Average(job.model, len(funcs))
 			if err != nil {
+				release()
 				answerFunctions(MergeFailed, channels)
 				errChan <- err
 				break
 			}
 
-			err = job.model.Save()
+			// the average is applied only once, if saving fails only the save is
+			// retried with the already averaged model, also once redis comes back
+			// if the connection was lost and the job has an outage grace
+			job.modelMu.Lock()
+			err = job.saveModel()
+			if err != nil && isConnectionError(err) && job.task.Parameters.Options.OutageGrace() > 0 {
+				err = job.saveAfterOutage(err)
+			}
+			if err == nil {
+				job.merges++
+				job.mergeTime += time.Since(mergeStart)
+				job.updateNorms += job.model.UpdateNorm()
+				job.countGroupMerges(finished)
+			}
+			job.modelMu.Unlock()
+			release()
 			if err != nil {
 				job.logger.Error("error saving model", zap.Error(err))
 				answerFunctions(MergeFailed, channels)
@@ -411,10 +955,14 @@ func (job *TrainJob) mergeModel() {
 			}
 			job.logger.Debug("Merge and save took", zap.Float64("time", time.Since(mergeStart).Seconds()))
 
-			finished := atomic.LoadInt64(&job.finishedFuncs)
-			job.logger.Debug("finished funcs are", zap.Int64("num", finished))
-			// initialize the wait group again by checking the number of finished functions
-			remaining := job.parallelism - int(finished)
+			// initialize the wait group again by checking the number of finished functions,
+			// before the functions that return in the next iteration use them
+			remaining := job.iterations.advance(func(remaining int) {
+				if remaining > 0 {
+					// reset the wait group for the functions still running
+					job.wgIteration.Add(remaining)
+				}
+			})
 			if remaining == 0 {
 				job.logger.Debug("all functions finished, quiting...")
 
@@ -425,10 +973,6 @@ func (job *TrainJob) mergeModel() {
 
 			} else {
 				job.logger.Debug("remaining functions is", zap.Int("num", remaining))
-				// reset the wait group and reopen the channel with a buffer
-				// size equal to the number of finishCh
-				job.wgIteration.Add(remaining)
-				job.finishCh = make(chan *finishNotification, remaining)
 
 				// answer to all the non-nil channels
 				// a channel is nil if the functions is completely finished
@@ -441,6 +985,82 @@ func (job *TrainJob) mergeModel() {
 
 }
 
+// saveModel saves the reference model retrying a bounded number of times,
+// so that a transient error in redis does not end the epoch.
+//
+// Retrying is safe since the model is saved in a single transaction, so a failed
+// save leaves the previous reference model untouched
+func (job *TrainJob) saveModel() error {
+	var err error
+	for attempt := 0; attempt <= maxSaveRetries; attempt++ {
+		if attempt > 0 {
+			job.logger.Warn("error saving model, retrying...",
+				zap.Int("attempt", attempt),
+				zap.Error(err))
+			time.Sleep(saveRetryBackoff * time.Duration(1<<uint(attempt-1)))
+		}
+
+		err = job.model.Save()
+		if err == nil {
+			return nil
+		}
+	}
+
+	return errors.Wrapf(err, "could not save model after %d retries", maxSaveRetries)
+}
+
+// recordMerges saves the number of merges of the epoch in the history along with
+// the average norm of the updates they made to the model, the number of stale
+// finish notifications that were discarded, the time spent waiting for merge slots
+// and the time the functions were blocked at the barrier of the merges
+func (job *TrainJob) recordMerges() {
+	metrics := map[string]float64{
+		api.MetricIterations:         float64(job.merges),
+		api.MetricStaleNotifications: float64(job.iterations.takeStale()),
+		api.MetricMergeWait:          job.mergeWait.Seconds(),
+		api.MetricBarrierWait:        job.barrierWait.Seconds(),
+	}
+	if job.merges > 0 {
+		metrics[api.MetricGradNorm] = job.updateNorms / float64(job.merges)
+		job.logger.Info("Norm of the model updates",
+			zap.Int("epoch", job.epoch),
+			zap.Float64("gradNorm", metrics[api.MetricGradNorm]))
+	}
+	job.setEpochMetrics(metrics)
+
+	if job.layerGroups != nil {
+		job.logger.Debug("Merges of the layer groups in the epoch", zap.Ints("merges", job.groupMerges))
+		job.history.SetLayerGroupMerges(job.currentEpoch(), job.groupMerges)
+	}
+}
+
+// mergedLayers returns the layers the functions push in the merge of the given
+// iteration, the ones of the layer groups due in it, or nil for all of them if
+// the job has no layer groups. The functions that finished their data push all
+func (job *TrainJob) mergedLayers(iteration int) []string {
+	if job.layerGroups == nil {
+		return nil
+	}
+	return job.layerGroups.Select(job.layerGroups.Due(iteration))
+}
+
+// countGroupMerges counts the merge of the current iteration for the layer
+// groups averaged in it, the due ones or all of them if some of the
+// functions merged finished their data and pushed their whole model
+func (job *TrainJob) countGroupMerges(finished bool) {
+	if job.layerGroups == nil {
+		return
+	}
+
+	groups := job.layerGroups.All()
+	if !finished {
+		groups = job.layerGroups.Due(job.iterations.current())
+	}
+	for _, group := range groups {
+		job.groupMerges[group]++
+	}
+}
+
 // answerFunctions responds to functions with the result of the merging process
 func answerFunctions(result MergeResult, channels []chan MergeResult) {
 	for _, ch := range channels {
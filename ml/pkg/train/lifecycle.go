@@ -0,0 +1,125 @@
+package train
+
+import (
+	"context"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"time"
+)
+
+// finishBackoff is the wait before the first retry of the finish
+// notification, doubled after every attempt
+var finishBackoff = time.Second
+
+// reportFinish sends the finish of the job to the parameter server, retrying with a
+// backoff. If the parameter server never acknowledges it, a standalone job leaves a
+// marker in the database so the parameter server finishes the job once it finds it.
+// A job run in the parameter server has no marker to leave, since it only reconciles
+// the standalone jobs, and just logs the error
+func (job *TrainJob) reportFinish() {
+	wait := finishBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = job.ps.JobFinished(job.jobId, job.exitErr); err == nil {
+			return
+		}
+		if attempt == api.JobFinishRetries {
+			break
+		}
+
+		job.logger.Warn("Could not report the finish, retrying",
+			zap.Int("attempt", attempt),
+			zap.Error(err))
+		time.Sleep(wait)
+		wait *= 2
+	}
+
+	if job.markFinish == nil {
+		job.logger.Error("Could not report the finish",
+			zap.Int("attempts", api.JobFinishRetries),
+			zap.Error(err))
+		return
+	}
+
+	job.logger.Error("Could not report the finish, leaving it for the parameter server",
+		zap.Int("attempts", api.JobFinishRetries),
+		zap.Error(err))
+	if err = job.markFinish(job.jobId, job.exitErr); err != nil {
+		job.logger.Error("Could not save the unreported finish", zap.Error(err))
+	}
+}
+
+// writeUnreportedFinish saves the marker of the job that could not report its finish
+func writeUnreportedFinish(jobId string, exitErr error) error {
+	client, err := mongo.NewClient(options.Client().ApplyURI(createMongoURI()))
+	if err != nil {
+		return errors.Wrap(err, "could not create mongo client")
+	}
+	if err = client.Connect(context.TODO()); err != nil {
+		return errors.Wrap(err, "could not connect to mongo")
+	}
+	defer client.Disconnect(context.TODO())
+
+	marker := api.UnreportedFinish{JobId: jobId, Finished: time.Now()}
+	if exitErr != nil {
+		marker.Error = exitErr.Error()
+	}
+
+	collection := client.Database(util.MongoDatabase()).Collection(api.UnreportedFinishesCollection)
+	_, err = collection.ReplaceOne(context.TODO(), bson.M{"_id": jobId}, marker, options.Replace().SetUpsert(true))
+	if err != nil {
+		return errors.Wrap(err, "could not write unreported finish")
+	}
+	return nil
+}
+
+// Run serves the api of a standalone job until the job finishes. Once the finish is
+// reported and the events and the history are flushed, the api is shut down after
+// answering the requests in flight, the tensors of the job are deleted and the exit
+// code of the pod is returned, so the pod ends with the status of the job
+func (job *TrainJob) Run(port int) int {
+	job.logger.Info("starting job API", zap.String("JobID", job.jobId))
+	l, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
+	if err != nil {
+		job.logger.Error("Could not listen for the job api", zap.Error(err))
+		return api.JobExitFailed
+	}
+	return job.serve(l)
+}
+
+// serve serves the api of the job on the listener until the job finishes, see Run
+func (job *TrainJob) serve(l net.Listener) int {
+	server := &http.Server{Handler: job.GetHandler()}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(l)
+	}()
+
+	select {
+	case err := <-serveErr:
+		job.logger.Error("Job api quit", zap.Error(err))
+		return api.JobExitFailed
+	case <-job.finished:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), api.JobShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		job.logger.Warn("Could not shut down the job api gracefully", zap.Error(err))
+	}
+	job.clearing.Wait()
+
+	code := api.JobExitCode(job.exitErr)
+	job.logger.Info("Job exiting", zap.Int("code", code), zap.Error(job.exitErr))
+	job.logger.Sync()
+	return code
+}
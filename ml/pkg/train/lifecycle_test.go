@@ -0,0 +1,142 @@
+package train
+
+import (
+	"errors"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	psClient "github.com/diegostock12/kubeml/ml/pkg/ps/client"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePS is a parameter server that refuses the first failures finish notifications
+type fakePS struct {
+	mu       sync.Mutex
+	failures int
+	finishes []string
+}
+
+func (ps *fakePS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.finishes = append(ps.finishes, r.URL.Path+" "+string(body))
+	if len(ps.finishes) <= ps.failures {
+		kerror.RespondWithError(w, kerror.New(http.StatusInternalServerError, "not ready"))
+	}
+}
+
+func TestReportFinish(t *testing.T) {
+	defer func(backoff time.Duration) { finishBackoff = backoff }(finishBackoff)
+	finishBackoff = time.Millisecond
+
+	tests := []struct {
+		name       string
+		failures   int
+		standalone bool
+		attempts   int
+		marker     bool
+	}{
+		{"acknowledged", 0, true, 1, false},
+		{"acknowledged after retries", api.JobFinishRetries - 1, true, api.JobFinishRetries, false},
+		{"standalone never acknowledged", api.JobFinishRetries, true, api.JobFinishRetries, true},
+		{"in the parameter server never acknowledged", api.JobFinishRetries, false, api.JobFinishRetries, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := &fakePS{failures: tt.failures}
+			server := httptest.NewServer(ps)
+			defer server.Close()
+
+			var markers []string
+			job := &TrainJob{
+				logger:  zap.NewNop(),
+				jobId:   "job",
+				ps:      psClient.MakeClient(zap.NewNop(), server.URL),
+				exitErr: errors.New("out of memory"),
+			}
+			if tt.standalone {
+				job.markFinish = func(jobId string, exitErr error) error {
+					markers = append(markers, jobId+" "+exitErr.Error())
+					return nil
+				}
+			}
+			job.reportFinish()
+
+			if len(ps.finishes) != tt.attempts {
+				t.Errorf("got %d attempts, want %d", len(ps.finishes), tt.attempts)
+			}
+			for _, finish := range ps.finishes {
+				if finish != "/finish/job out of memory" {
+					t.Errorf("got finish %q, want the job and its error", finish)
+				}
+			}
+
+			switch {
+			case tt.marker && (len(markers) != 1 || markers[0] != "job out of memory"):
+				t.Errorf("got markers %v, want the one of the job", markers)
+			case !tt.marker && len(markers) != 0:
+				t.Errorf("got markers %v, want none", markers)
+			}
+		})
+	}
+}
+
+func TestServeShutsDownAfterClearing(t *testing.T) {
+	job := &TrainJob{
+		logger:   zap.NewNop(),
+		jobId:    "job",
+		state:    &jobState{},
+		finished: make(chan struct{}),
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + l.Addr().String() + "/status"
+
+	code := make(chan int)
+	go func() {
+		code <- job.serve(l)
+	}()
+
+	// the api is served while the job trains
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// once the job finishes it waits for its tensors to be deleted
+	job.clearing.Add(1)
+	job.exitErr = errors.New(api.ForceStoppedError)
+	close(job.finished)
+	select {
+	case c := <-code:
+		t.Fatalf("got exit code %d before the tensors were deleted", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	job.clearing.Done()
+	select {
+	case c := <-code:
+		if c != api.JobExitStopped {
+			t.Errorf("got exit code %d, want %d", c, api.JobExitStopped)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the job to exit")
+	}
+
+	// the api was shut down before exiting
+	if resp, err := http.Get(url); err == nil {
+		resp.Body.Close()
+		t.Error("got the api served after the job exited")
+	}
+}
@@ -0,0 +1,62 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// levelCore filters the entries of a core with the level of the job. The loggers
+// named from the logger of the job share the core, so the merger, the clients and
+// the model all follow the level of the job when it is changed at runtime
+type levelCore struct {
+	zapcore.Core
+	level zap.AtomicLevel
+}
+
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	return c.level.Enabled(l) && c.Core.Enabled(l)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(e.Level) {
+		return ce
+	}
+	return c.Core.Check(e, ce)
+}
+
+// withLevel returns a logger whose entries are filtered by the level
+func withLevel(logger *zap.Logger, level zap.AtomicLevel) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return &levelCore{Core: c, level: level}
+	}))
+}
+
+// LogLevel returns the level of the job, which can be
+// changed to make the job more or less verbose
+func (job *TrainJob) LogLevel() zap.AtomicLevel {
+	return job.level
+}
+
+// setLogLevel sets the level of the job, an empty level keeps the current one
+func (job *TrainJob) setLogLevel(level string) {
+	if len(level) == 0 {
+		return
+	}
+
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil || api.ValidateLogLevel(level) != nil {
+		job.logger.Warn("Invalid log level, keeping the current one",
+			zap.String("level", level),
+			zap.String("current", job.level.String()))
+		job.task.Parameters.Options.LogLevel = job.level.String()
+		job.task.Parameters.RecordChange("options.log_level", level, job.task.Parameters.Options.LogLevel,
+			"invalid log level, the job kept the current one")
+		return
+	}
+	job.level.SetLevel(l)
+}
@@ -0,0 +1,106 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newLevelTestJob returns a job logging everything its level lets through to the observer
+func newLevelTestJob() (*TrainJob, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	level := zap.NewAtomicLevelAt(zap.DebugLevel)
+	job := &TrainJob{
+		logger: withLevel(zap.New(core).Named("trainJob-job"), level),
+		level:  level,
+		task:   &api.TrainTask{},
+	}
+	return job, logs
+}
+
+// logAll logs a message at every level with the logger and returns the levels observed
+func logAll(logger *zap.Logger, logs *observer.ObservedLogs) []zapcore.Level {
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+
+	var levels []zapcore.Level
+	for _, entry := range logs.TakeAll() {
+		levels = append(levels, entry.Level)
+	}
+	return levels
+}
+
+func TestLogLevelFlip(t *testing.T) {
+	job, logs := newLevelTestJob()
+	handler := job.GetHandler()
+
+	// the loggers named from the job and with fields follow its level
+	child := job.logger.Named("merger").With(zap.Int("epoch", 1))
+
+	tests := []struct {
+		level string
+		want  []zapcore.Level
+	}{
+		{"warn", []zapcore.Level{zap.WarnLevel, zap.ErrorLevel}},
+		{"error", []zapcore.Level{zap.ErrorLevel}},
+		{"debug", []zapcore.Level{zap.DebugLevel, zap.InfoLevel, zap.WarnLevel, zap.ErrorLevel}},
+		{"info", []zapcore.Level{zap.InfoLevel, zap.WarnLevel, zap.ErrorLevel}},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader(`{"level":"`+tt.level+`"}`))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d setting level %s, want 200", w.Code, tt.level)
+		}
+
+		if got := logAll(job.logger, logs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got levels %v logged by the job, want %v", tt.level, got, tt.want)
+		}
+		if got := logAll(child, logs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got levels %v logged by the child logger, want %v", tt.level, got, tt.want)
+		}
+	}
+
+	// the level is read back from the job
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/loglevel", nil))
+	if !strings.Contains(w.Body.String(), `"info"`) {
+		t.Errorf("got level %s, want info", w.Body.String())
+	}
+}
+
+func TestSetLogLevel(t *testing.T) {
+	job, logs := newLevelTestJob()
+
+	// an empty level keeps the current one
+	job.setLogLevel("")
+	if job.level.Level() != zap.DebugLevel {
+		t.Errorf("got level %v, want debug", job.level.Level())
+	}
+
+	job.setLogLevel("warn")
+	if got := logAll(job.logger, logs); !reflect.DeepEqual(got, []zapcore.Level{zap.WarnLevel, zap.ErrorLevel}) {
+		t.Errorf("got levels %v logged, want warn and error", got)
+	}
+
+	// levels zap knows but a job can't be set to are rejected
+	for _, level := range []string{"verbose", "dpanic"} {
+		job.setLogLevel(level)
+		if job.level.Level() != zap.WarnLevel || job.task.Parameters.Options.LogLevel != "warn" {
+			t.Errorf("%s: got level %v and option %s, want the level kept", level, job.level.Level(), job.task.Parameters.Options.LogLevel)
+		}
+		if entries := logs.TakeAll(); len(entries) != 1 || entries[0].Message != "Invalid log level, keeping the current one" {
+			t.Errorf("%s: got logs %+v, want the warning of the invalid level", level, entries)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package train
+
+import (
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"sort"
+	"strings"
+)
+
+// tensorMemory returns the memory used in redis by each of the tensors of the
+// job, which are found through the job id prefixing all of their keys
+func (job *TrainJob) tensorMemory() (map[string]int64, error) {
+	redisClient := util.GetRedisAIClient(job.redisPool, false)
+	defer redisClient.Close()
+
+	keys, err := redis.Strings(redisClient.DoOrSend("KEYS", redis.Args{job.jobId + ":*"}, nil))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list tensors")
+	}
+
+	usage := make(map[string]int64, len(keys))
+	for _, key := range keys {
+		size, err := redis.Int64(redisClient.DoOrSend("MEMORY", redis.Args{"USAGE", key}, nil))
+		if err == redis.ErrNil {
+			// the tensor was deleted after listing it
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get memory usage of %s", key)
+		}
+		usage[key] = size
+	}
+
+	return usage, nil
+}
+
+// measureRedisMemory updates the memory used in redis by the job,
+// which is reported to the parameter server with the metrics
+func (job *TrainJob) measureRedisMemory() (map[string]int64, error) {
+	usage, err := job.tensorMemory()
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, size := range usage {
+		total += size
+	}
+	job.redisMemory = total
+
+	return usage, nil
+}
+
+// checkModelBudget fails the job right after the init if the
+// reference model alone does not fit in the redis budget of the job
+func (job *TrainJob) checkModelBudget() error {
+	budget := job.task.Job.RedisBudget
+	if budget > 0 && job.summary.Bytes > budget {
+		return fmt.Errorf("the weights of the model take %d bytes, over the redis budget of %d bytes of the job",
+			job.summary.Bytes, budget)
+	}
+	if footprint := job.summary.RedisFootprint(job.parallelism); budget > 0 && footprint > budget {
+		job.logger.Info("The tensors of the functions are expected to go over the redis budget, they will be trimmed after the merges",
+			zap.Int64("footprint", footprint),
+			zap.Int64("budget", budget))
+	}
+
+	if _, err := job.measureRedisMemory(); err != nil {
+		job.logger.Warn("Could not measure the redis memory of the model", zap.Error(err))
+		return nil
+	}
+
+	job.logger.Info("Measured redis memory of the model",
+		zap.Int64("bytes", job.redisMemory),
+		zap.Int64("budget", budget))
+
+	if budget > 0 && job.redisMemory > budget {
+		return fmt.Errorf("model uses %d bytes in redis, over the budget of %d bytes of the job",
+			job.redisMemory, budget)
+	}
+	return nil
+}
+
+// checkBudget measures the memory used by the job after an epoch is merged. If the
+// job is over its budget the tensors saved by the functions, which are no longer needed
+// once the model is merged, are trimmed in the order of the functions that saved them
+// until the job fits. If trimming is not enough an error is returned
+func (job *TrainJob) checkBudget() error {
+	usage, err := job.measureRedisMemory()
+	if err != nil {
+		job.logger.Warn("Could not measure the redis memory of the job", zap.Error(err))
+		return nil
+	}
+
+	budget := job.task.Job.RedisBudget
+	if budget <= 0 || job.redisMemory <= budget {
+		return nil
+	}
+
+	job.logger.Warn("Job over its redis budget, trimming function tensors",
+		zap.Int64("bytes", job.redisMemory),
+		zap.Int64("budget", budget))
+
+	var trimmable []string
+	for key := range usage {
+		if strings.Contains(key, "/") {
+			trimmable = append(trimmable, key)
+		}
+	}
+	sort.Strings(trimmable)
+
+	redisClient := util.GetRedisAIClient(job.redisPool, false)
+	defer redisClient.Close()
+
+	var trimmed []string
+	var freed int64
+	for _, key := range trimmable {
+		if job.redisMemory-freed <= budget {
+			break
+		}
+		if _, err := redisClient.DoOrSend("DEL", redis.Args{key}, nil); err != nil {
+			return errors.Wrapf(err, "could not trim tensor %s", key)
+		}
+		trimmed = append(trimmed, key)
+		freed += usage[key]
+	}
+	job.redisMemory -= freed
+
+	job.logger.Info("Trimmed function tensors",
+		zap.Int("tensors", len(trimmed)),
+		zap.Int64("freed", freed),
+		zap.Int64("bytes", job.redisMemory),
+		zap.Int64("budget", budget))
+
+	if job.redisMemory > budget {
+		return fmt.Errorf("job uses %d bytes in redis after trimming, over its budget of %d bytes",
+			job.redisMemory, budget)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package train
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultMergeSlotMinParameters is the size in parameters under which a model
+// merges without taking a merge slot, since waiting for one would only add latency
+const defaultMergeSlotMinParameters = 1 << 20
+
+var (
+	// mergeSlots is shared by all the jobs running in the process, so the jobs
+	// deployed in the parameter server do not all merge at the same time
+	mergeSlots     *mergeSemaphore
+	mergeSlotsOnce sync.Once
+)
+
+type (
+	// mergeSemaphore limits the number of merges running at the same time. The
+	// slots are handed out in the order they were asked for so no job starves
+	mergeSemaphore struct {
+		mu      sync.Mutex
+		slots   int
+		busy    int
+		waiting *list.List
+
+		// minParameters is the size of the models that need a slot to merge
+		minParameters int64
+	}
+)
+
+func newMergeSemaphore(slots int, minParameters int64) *mergeSemaphore {
+	return &mergeSemaphore{
+		slots:         slots,
+		waiting:       list.New(),
+		minParameters: minParameters,
+	}
+}
+
+// getMergeSlots returns the merge semaphore of the process, created the first time
+// from MERGE_CONCURRENCY, the number of merges that can run at the same time (the
+// number of CPUs by default, 0 disables the limit), and MERGE_SLOT_MIN_PARAMETERS.
+// Invalid values are logged and the defaults used instead
+func getMergeSlots(logger *zap.Logger) *mergeSemaphore {
+	mergeSlotsOnce.Do(func() {
+		slots, err := mergeSlotsFromEnv("MERGE_CONCURRENCY", runtime.NumCPU())
+		if err != nil {
+			logger.Warn("Using the default merge concurrency", zap.Error(err))
+		}
+		minParameters, err := mergeSlotsFromEnv("MERGE_SLOT_MIN_PARAMETERS", defaultMergeSlotMinParameters)
+		if err != nil {
+			logger.Warn("Using the default minimum parameters of the merge slots", zap.Error(err))
+		}
+		mergeSlots = newMergeSemaphore(slots, int64(minParameters))
+		logger.Info("Set merge concurrency",
+			zap.Int("slots", slots),
+			zap.Int("minParameters", minParameters))
+	})
+	return mergeSlots
+}
+
+// mergeSlotsFromEnv reads a non negative integer from the environment,
+// returning the default value if it is not set or not valid
+func mergeSlotsFromEnv(name string, defaultValue int) (int, error) {
+	s := os.Getenv(name)
+	if len(s) == 0 {
+		return defaultValue, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return defaultValue, errors.Wrapf(err, "invalid %s", name)
+	}
+	if v < 0 {
+		return defaultValue, fmt.Errorf("%s should not be negative, got %d", name, v)
+	}
+	return v, nil
+}
+
+// needed returns whether a model with the given parameters has to take a slot
+func (s *mergeSemaphore) needed(parameters int64) bool {
+	return s.slots > 0 && parameters >= s.minParameters
+}
+
+// acquire takes a merge slot, waiting behind the merges that asked before if all
+// the slots are busy, and returns the time spent waiting for it
+func (s *mergeSemaphore) acquire() time.Duration {
+	s.mu.Lock()
+	if s.busy < s.slots && s.waiting.Len() == 0 {
+		s.busy++
+		s.mu.Unlock()
+		return 0
+	}
+
+	start := time.Now()
+	ready := make(chan struct{})
+	s.waiting.PushBack(ready)
+	s.mu.Unlock()
+
+	<-ready
+	return time.Since(start)
+}
+
+// release frees a merge slot, handing it to the
+// merge that has been waiting the longest
+func (s *mergeSemaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if front := s.waiting.Front(); front != nil {
+		s.waiting.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	s.busy--
+}
+
+// acquireMergeSlot takes a merge slot for the merge of the job unless its model is
+// too small to need one, adding the time spent waiting to the epoch. The blocked
+// functions wait for the slot as well, so the wait is added to their barrier wait.
+// It returns the function releasing the slot once the merged model is saved
+func (job *TrainJob) acquireMergeSlot(blocked int) func() {
+	slots := getMergeSlots(job.logger)
+	if !slots.needed(job.summary.Parameters) {
+		return func() {}
+	}
+
+	wait := slots.acquire()
+	job.mergeWait += wait
+	job.barrierWait += time.Duration(blocked) * wait
+	if wait > 0 {
+		job.logger.Debug("Waited for a merge slot", zap.Duration("wait", wait))
+	}
+	return slots.release
+}
@@ -0,0 +1,189 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until the semaphore has the given number of merges waiting
+func waitQueued(t *testing.T, s *mergeSemaphore, num int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		queued := s.waiting.Len()
+		s.mu.Unlock()
+		if queued == num {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d queued merges", num)
+}
+
+func TestMergeSemaphoreNeeded(t *testing.T) {
+	tests := []struct {
+		name       string
+		slots      int
+		parameters int64
+		want       bool
+	}{
+		{"below the minimum", 2, 99, false},
+		{"at the minimum", 2, 100, true},
+		{"above the minimum", 2, 1000, true},
+		{"limit disabled", 0, 1000, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMergeSemaphore(tt.slots, 100)
+			if got := s.needed(tt.parameters); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeSemaphoreFIFO(t *testing.T) {
+	s := newMergeSemaphore(1, 0)
+	if wait := s.acquire(); wait != 0 {
+		t.Errorf("got wait %v with a free slot, want 0", wait)
+	}
+
+	// the merges queue one after the other
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.acquire()
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			s.release()
+		}(i)
+		waitQueued(t, s, i+1)
+	}
+
+	s.release()
+	wg.Wait()
+	if !reflect.DeepEqual(order, []int{0, 1, 2, 3}) {
+		t.Errorf("got order %v, want [0 1 2 3]", order)
+	}
+	if s.busy != 0 || s.waiting.Len() != 0 {
+		t.Errorf("got %d busy and %d waiting slots, want 0 and 0", s.busy, s.waiting.Len())
+	}
+}
+
+func TestMergeSemaphoreReleaseHandsSlotToWaiter(t *testing.T) {
+	s := newMergeSemaphore(1, 0)
+	s.acquire()
+
+	acquired := make(chan time.Duration)
+	go func() {
+		acquired <- s.acquire()
+	}()
+	waitQueued(t, s, 1)
+
+	// the released slot goes straight to the waiting merge, so
+	// it stays busy and a new merge can not take it first
+	time.Sleep(10 * time.Millisecond)
+	s.release()
+	s.mu.Lock()
+	busy := s.busy
+	s.mu.Unlock()
+	if busy != 1 {
+		t.Errorf("got %d busy slots after handing the slot, want 1", busy)
+	}
+
+	if wait := <-acquired; wait < 10*time.Millisecond {
+		t.Errorf("got wait %v, want at least 10ms", wait)
+	}
+	s.release()
+	if s.busy != 0 {
+		t.Errorf("got %d busy slots, want 0", s.busy)
+	}
+}
+
+func TestMergeSemaphoreConcurrentJobs(t *testing.T) {
+	const (
+		slots  = 2
+		jobs   = 4
+		merges = 20
+	)
+	s := newMergeSemaphore(slots, 0)
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	var wg sync.WaitGroup
+	for j := 0; j < jobs; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := 0; m < merges; m++ {
+				s.acquire()
+				mu.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mu.Unlock()
+
+				time.Sleep(time.Millisecond)
+
+				mu.Lock()
+				running--
+				mu.Unlock()
+				s.release()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning > slots {
+		t.Errorf("got %d merges running at the same time, want at most %d", maxRunning, slots)
+	}
+	if s.busy != 0 || s.waiting.Len() != 0 {
+		t.Errorf("got %d busy and %d waiting slots, want 0 and 0", s.busy, s.waiting.Len())
+	}
+}
+
+func TestAcquireMergeSlotAddsBarrierWait(t *testing.T) {
+	mergeSlotsOnce.Do(func() {
+		mergeSlots = newMergeSemaphore(1, 100)
+	})
+	slots := getMergeSlots(zap.NewNop())
+
+	// a small model merges without a slot
+	small := &TrainJob{logger: zap.NewNop(), summary: &api.ModelSummary{Parameters: 10}}
+	small.acquireMergeSlot(3)()
+	if small.mergeWait != 0 || small.barrierWait != 0 {
+		t.Errorf("got waits (%v, %v) without a slot, want 0", small.mergeWait, small.barrierWait)
+	}
+
+	slots.acquire()
+	job := &TrainJob{logger: zap.NewNop(), summary: &api.ModelSummary{Parameters: 1000}}
+	done := make(chan struct{})
+	go func() {
+		job.acquireMergeSlot(3)()
+		close(done)
+	}()
+	waitQueued(t, slots, 1)
+	time.Sleep(10 * time.Millisecond)
+	slots.release()
+	<-done
+
+	// the three blocked functions waited for the slot as long as the merge
+	if job.mergeWait < 10*time.Millisecond {
+		t.Errorf("got merge wait %v, want at least 10ms", job.mergeWait)
+	}
+	if job.barrierWait != 3*job.mergeWait {
+		t.Errorf("got barrier wait %v, want %v", job.barrierWait, 3*job.mergeWait)
+	}
+}
@@ -0,0 +1,43 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"time"
+)
+
+// initMilestones adds the accuracy milestones of the job to the history as not
+// reached. A continued job keeps the milestones of the run it continues
+func (job *TrainJob) initMilestones() {
+	for _, accuracy := range job.task.Parameters.Options.Milestones() {
+		found := false
+		for _, m := range job.history.Milestones {
+			found = found || m.Accuracy == accuracy
+		}
+		if !found {
+			job.history.Milestones = append(job.history.Milestones, api.AccuracyMilestone{Accuracy: accuracy})
+		}
+	}
+}
+
+// recordMilestones marks the milestones reached by the accuracy of a validation
+// with the epoch, the training time and the function seconds until now
+func (job *TrainJob) recordMilestones(accuracy float64) {
+	opts := job.task.Parameters.Options
+	for i := range job.history.Milestones {
+		m := &job.history.Milestones[i]
+		if m.Reached || !opts.GoalReached(api.MetricAccuracy, accuracy, m.Accuracy) {
+			continue
+		}
+
+		m.Reached = true
+		m.Epoch = job.currentEpoch()
+		m.Elapsed = (time.Since(job.startTime) - job.pausedTime).Seconds()
+		m.FunctionSeconds = job.history.FunctionSeconds(m.Epoch)
+		job.logger.Info("Reached accuracy milestone",
+			zap.Float64("accuracy", m.Accuracy),
+			zap.Int("epoch", m.Epoch),
+			zap.Float64("elapsed", m.Elapsed),
+			zap.Float64("function seconds", m.FunctionSeconds))
+	}
+}
@@ -0,0 +1,190 @@
+package train
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	natsDefaultPort = "4222"
+	natsDialTimeout = 10 * time.Second
+)
+
+// natsPublisher publishes messages to a NATS server with the text protocol of
+// core NATS. The jobs only publish, so it keeps a single connection, answers the
+// pings of the server and reconnects on the next publish if the connection drops
+type natsPublisher struct {
+	logger *zap.Logger
+	url    *url.URL
+	name   string
+
+	mu   sync.Mutex
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+// newNatsPublisher connects to the server at the url, given as
+// nats://[user:password@]host[:port]
+func newNatsPublisher(logger *zap.Logger, rawUrl, name string) (*natsPublisher, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Scheme != "nats" || len(u.Hostname()) == 0 {
+		return nil, fmt.Errorf("invalid NATS url \"%s\", expected nats://host:port", rawUrl)
+	}
+
+	p := &natsPublisher{
+		logger: logger.Named("nats"),
+		url:    u,
+		name:   name,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// connect opens the connection and waits for the server to accept it,
+// it must be called with the lock held
+func (p *natsPublisher) connect() error {
+	port := p.url.Port()
+	if len(port) == 0 {
+		port = natsDefaultPort
+	}
+	addr := net.JoinHostPort(p.url.Hostname(), port)
+
+	conn, err := net.DialTimeout("tcp", addr, natsDialTimeout)
+	if err != nil {
+		return errors.Wrapf(err, "could not connect to NATS server %s", addr)
+	}
+	conn.SetDeadline(time.Now().Add(natsDialTimeout))
+
+	// the server greets with its info, then it answers the ping
+	// sent after the connect once it accepted the connection
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return fmt.Errorf("unexpected greeting from NATS server %s", addr)
+	}
+
+	opts := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     p.name,
+		"lang":     "go",
+	}
+	if user := p.url.User; user != nil {
+		opts["user"] = user.Username()
+		if pass, ok := user.Password(); ok {
+			opts["pass"] = pass
+		}
+	}
+	connect, _ := json.Marshal(opts)
+	if _, err = fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		conn.Close()
+		return errors.Wrap(err, "could not send connect to NATS server")
+	}
+
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return errors.Wrap(err, "could not read from NATS server")
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return fmt.Errorf("NATS server refused the connection: %s", strings.TrimSpace(line))
+		}
+		if strings.HasPrefix(line, "PONG") {
+			break
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	p.conn = conn
+	p.w = bufio.NewWriter(conn)
+	go p.read(conn, r)
+
+	p.logger.Debug("Connected to NATS server", zap.String("addr", addr))
+	return nil
+}
+
+// read answers the pings of the server until the connection is closed
+func (p *natsPublisher) read(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			p.drop(conn)
+			return
+		}
+
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			p.mu.Lock()
+			if p.conn == conn {
+				p.w.WriteString("PONG\r\n")
+				p.w.Flush()
+			}
+			p.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			p.logger.Warn("Error from NATS server", zap.String("error", strings.TrimSpace(line)))
+		}
+	}
+}
+
+// drop closes the connection if it is still the current one
+func (p *natsPublisher) drop(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == conn {
+		p.logger.Warn("Lost connection to NATS server")
+		conn.Close()
+		p.conn = nil
+	}
+}
+
+// Publish sends the message to the subject, reconnecting first if the connection
+// dropped. As in core NATS there is no acknowledgement, a message lost after
+// leaving the job is noticed when its result does not arrive
+func (p *natsPublisher) Publish(subject string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(p.w, "PUB %s %d\r\n", subject, len(data))
+	p.w.Write(data)
+	p.w.WriteString("\r\n")
+	if err := p.w.Flush(); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return errors.Wrap(err, "could not publish message")
+	}
+	return nil
+}
+
+// Close closes the connection to the server
+func (p *natsPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
@@ -0,0 +1,370 @@
+package train
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// natsServer is a NATS server embedded in the tests that speaks the core protocol
+	// the publisher uses: it greets with its info, answers the pings and keeps the
+	// messages published. If refuse is set it rejects the connections with that error
+	natsServer struct {
+		ln     net.Listener
+		refuse string
+
+		mu       sync.Mutex
+		conns    []net.Conn
+		connects []map[string]interface{}
+
+		messages chan natsMessage
+		pongs    chan struct{}
+	}
+
+	natsMessage struct {
+		subject string
+		data    []byte
+	}
+)
+
+func newNatsServer(t *testing.T, refuse string) *natsServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &natsServer{
+		ln:       ln,
+		refuse:   refuse,
+		messages: make(chan natsMessage, 100),
+		pongs:    make(chan struct{}, 10),
+	}
+	t.Cleanup(s.close)
+	go s.serve()
+	return s
+}
+
+func (s *natsServer) url() string {
+	return "nats://" + s.ln.Addr().String()
+}
+
+func (s *natsServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *natsServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			var opts map[string]interface{}
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &opts)
+			s.mu.Lock()
+			s.connects = append(s.connects, opts)
+			s.mu.Unlock()
+			if len(s.refuse) > 0 {
+				fmt.Fprintf(conn, "-ERR '%s'\r\n", s.refuse)
+				return
+			}
+		case line == "PING":
+			fmt.Fprintf(conn, "PONG\r\n")
+		case line == "PONG":
+			s.pongs <- struct{}{}
+		case strings.HasPrefix(line, "PUB "):
+			fields := strings.Fields(line)
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			s.messages <- natsMessage{subject: fields[1], data: data[:size]}
+		}
+	}
+}
+
+// ping sends a ping to every client connected
+func (s *natsServer) ping() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		fmt.Fprintf(conn, "PING\r\n")
+	}
+}
+
+// drop closes the connections of the clients, which reconnect on their next publish
+func (s *natsServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *natsServer) close() {
+	s.ln.Close()
+	s.drop()
+}
+
+// message waits for the next message published to the server
+func (s *natsServer) message(t *testing.T) natsMessage {
+	t.Helper()
+	select {
+	case msg := <-s.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("got no message published")
+		return natsMessage{}
+	}
+}
+
+func TestNatsPublisher(t *testing.T) {
+	s := newNatsServer(t, "")
+	u := strings.Replace(s.url(), "nats://", "nats://job:secret@", 1)
+	p, err := newNatsPublisher(zap.NewNop(), u, "job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	s.mu.Lock()
+	connect := s.connects[0]
+	s.mu.Unlock()
+	if connect["name"] != "job-1" || connect["user"] != "job" || connect["pass"] != "secret" || connect["verbose"] != false {
+		t.Errorf("got connect %v, want the name and the credentials of the job", connect)
+	}
+
+	// the message can hold line breaks, its size delimits it
+	if err := p.Publish("kubeml.lenet", []byte("{\"a\":\r\n1}")); err != nil {
+		t.Fatal(err)
+	}
+	if msg := s.message(t); msg.subject != "kubeml.lenet" || string(msg.data) != "{\"a\":\r\n1}" {
+		t.Errorf("got message %q on %s, want it on kubeml.lenet", msg.data, msg.subject)
+	}
+
+	// the publisher answers the pings of the server
+	s.ping()
+	select {
+	case <-s.pongs:
+	case <-time.After(5 * time.Second):
+		t.Error("got no answer to the ping")
+	}
+}
+
+func TestNatsPublisherReconnects(t *testing.T) {
+	s := newNatsServer(t, "")
+	p, err := newNatsPublisher(zap.NewNop(), s.url(), "job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	s.drop()
+	// the publisher notices that the connection dropped while reading
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.mu.Lock()
+		dropped := p.conn == nil
+		p.mu.Unlock()
+		if dropped || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := p.Publish("kubeml.lenet", []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	if msg := s.message(t); string(msg.data) != "after" {
+		t.Errorf("got message %q, want the one published after reconnecting", msg.data)
+	}
+	s.mu.Lock()
+	connects := len(s.connects)
+	s.mu.Unlock()
+	if connects != 2 {
+		t.Errorf("got %d connections, want 2", connects)
+	}
+}
+
+func TestNatsPublisherErrors(t *testing.T) {
+	s := newNatsServer(t, "Authorization Violation")
+	if _, err := newNatsPublisher(zap.NewNop(), s.url(), "job-1"); err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("got error %v, want the connection refused", err)
+	}
+
+	for _, u := range []string{"http://localhost:4222", "nats://", "::"} {
+		if _, err := newNatsPublisher(zap.NewNop(), u, "job-1"); err == nil {
+			t.Errorf("%s: got no error for an invalid url", u)
+		}
+	}
+
+	// nothing listens on the address once the server is closed
+	closed := newNatsServer(t, "")
+	closed.close()
+	if _, err := newNatsPublisher(zap.NewNop(), closed.url(), "job-1"); err == nil {
+		t.Error("got no error connecting to a closed server")
+	}
+}
+
+// newQueueTestJob returns a job invoking its functions through the
+// queue of the server, waiting for the results up to the timeout
+func newQueueTestJob(t *testing.T, s *natsServer, timeout time.Duration) (*TrainJob, *queueInvoker) {
+	t.Helper()
+	publisher, err := newNatsPublisher(zap.NewNop(), s.url(), "job-job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoker := &queueInvoker{
+		logger:    zap.NewNop(),
+		publisher: publisher,
+		subject:   "kubeml.lenet",
+		callback:  "http://job-job.kubeml/results/",
+		accept:    "application/json",
+		timeout:   timeout,
+		pending:   make(map[string]chan *http.Response),
+	}
+	t.Cleanup(func() { invoker.Close() })
+	return newInvokeTestJob(api.TrainOptions{InvocationMode: api.InvocationModeQueue}, invoker), invoker
+}
+
+// postResult posts the result of the invocation to the results endpoint of the job
+func postResult(job *TrainJob, msg api.InvocationMessage, status int, body string) int {
+	req := httptest.NewRequest(http.MethodPost, "/results/"+msg.Id+"?status="+strconv.Itoa(status), strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	job.GetHandler().ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestQueueInvoker(t *testing.T) {
+	s := newNatsServer(t, "")
+	job, invoker := newQueueTestJob(t, s, time.Minute)
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := invoker.Invoke(context.Background(), 2, Train, "http://router/lenet?task=train&funcId=2&N=4")
+		results <- result{resp, err}
+	}()
+
+	var msg api.InvocationMessage
+	published := s.message(t)
+	if err := json.Unmarshal(published.data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if published.subject != "kubeml.lenet" || msg.Function != "lenet" || msg.Args["funcId"] != "2" || msg.Args["N"] != "4" ||
+		msg.Callback != "http://job-job.kubeml/results/"+msg.Id || msg.Accept != "application/json" {
+		t.Errorf("got message %+v on %s, want the invocation of function 2 of lenet", msg, published.subject)
+	}
+
+	// the function fails, and its error is the response of the invocation
+	if code := postResult(job, msg, http.StatusInternalServerError, `{"error":"out of memory"}`); code != http.StatusOK {
+		t.Errorf("got status %d posting the result, want 200", code)
+	}
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	body, _ := ioutil.ReadAll(r.resp.Body)
+	if r.resp.StatusCode != http.StatusInternalServerError || string(body) != `{"error":"out of memory"}` ||
+		r.resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("got response %d %q, want the error posted by the function", r.resp.StatusCode, body)
+	}
+
+	// the result was delivered once
+	if code := postResult(job, msg, http.StatusOK, "{}"); code != http.StatusNotFound {
+		t.Errorf("got status %d posting the result again, want 404", code)
+	}
+}
+
+func TestQueueInvokerTimeout(t *testing.T) {
+	s := newNatsServer(t, "")
+	job, invoker := newQueueTestJob(t, s, 50*time.Millisecond)
+
+	if _, err := invoker.Invoke(context.Background(), 0, Validation, "http://router/lenet?task=val"); err == nil {
+		t.Fatal("got no error without a result")
+	}
+
+	// the result arriving after the timeout is dropped
+	var msg api.InvocationMessage
+	if err := json.Unmarshal(s.message(t).data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if code := postResult(job, msg, http.StatusOK, "{}"); code != http.StatusNotFound {
+		t.Errorf("got status %d posting a late result, want 404", code)
+	}
+
+	// the invocation abandoned by the job does not wait for the timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := invoker.Invoke(ctx, 0, Train, "http://router/lenet?task=train"); err == nil {
+		t.Error("got no error from an abandoned invocation")
+	}
+}
+
+func TestQueueInvokerHeld(t *testing.T) {
+	s := newNatsServer(t, "")
+	job, invoker := newQueueTestJob(t, s, 50*time.Millisecond)
+
+	// the invocations do not time out while the merger waits for redis
+	invoker.hold()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := invoker.Invoke(context.Background(), 1, Train, "http://router/lenet?task=train")
+		errs <- err
+	}()
+
+	var msg api.InvocationMessage
+	if err := json.Unmarshal(s.message(t).data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if code := postResult(job, msg, http.StatusOK, "{}"); code != http.StatusOK {
+		t.Errorf("got status %d posting the result of a held invocation, want 200", code)
+	}
+	invoker.release()
+	if err := <-errs; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReceiveResultSync(t *testing.T) {
+	job := newInvokeTestJob(api.TrainOptions{}, &httpInvoker{})
+	if code := postResult(job, api.InvocationMessage{Id: "id"}, http.StatusOK, "{}"); code != http.StatusBadRequest {
+		t.Errorf("got status %d posting a result to a sync job, want 400", code)
+	}
+}
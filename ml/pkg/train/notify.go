@@ -0,0 +1,216 @@
+package train
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+const (
+	// notifyQueueSize is the number of events waiting to be delivered,
+	// events are dropped if the endpoint is too slow to keep up
+	notifyQueueSize = 16
+
+	// notifyAttempts is the number of times the delivery of an
+	// event is attempted, waiting notifyBackoff after the first
+	// failure and doubling it after every other one
+	notifyAttempts = 4
+	notifyBackoff  = time.Second
+
+	notifyTimeout = 10 * time.Second
+)
+
+type (
+	// notifier delivers the events of a job to its notification url in the
+	// background, so the training never waits for the endpoint
+	notifier struct {
+		logger     *zap.Logger
+		url        string
+		secret     string
+		httpClient *http.Client
+
+		// backoff is the wait after the first failed
+		// attempt of a delivery
+		backoff time.Duration
+
+		events chan *api.EpochEvent
+		done   chan struct{}
+	}
+
+	// deliveryError is returned when the endpoint
+	// answers a notification with an error code
+	deliveryError struct {
+		code int
+	}
+)
+
+func (e deliveryError) Error() string {
+	return fmt.Sprintf("endpoint answered with status %d", e.code)
+}
+
+// newNotifier creates a notifier and starts delivering its events
+func newNotifier(logger *zap.Logger, url, secret string) *notifier {
+	n := &notifier{
+		logger:     logger.Named("notifier"),
+		url:        url,
+		secret:     secret,
+		httpClient: &http.Client{Timeout: notifyTimeout},
+		backoff:    notifyBackoff,
+		events:     make(chan *api.EpochEvent, notifyQueueSize),
+		done:       make(chan struct{}),
+	}
+
+	go n.run()
+	return n
+}
+
+// notify queues an event without blocking, dropping it if the queue is full
+func (n *notifier) notify(event *api.EpochEvent) {
+	select {
+	case n.events <- event:
+	default:
+		n.logger.Warn("Notification queue full, dropping event",
+			zap.String("type", event.Type),
+			zap.Int("epoch", event.Epoch))
+	}
+}
+
+// close waits for the queued events to be delivered
+func (n *notifier) close() {
+	close(n.events)
+	<-n.done
+}
+
+func (n *notifier) run() {
+	defer close(n.done)
+	for event := range n.events {
+		code, err := n.deliver(event)
+		if err != nil {
+			n.logger.Error("Could not deliver event",
+				zap.String("type", event.Type),
+				zap.Int("epoch", event.Epoch),
+				zap.Int("code", code),
+				zap.Error(err))
+		}
+	}
+}
+
+// deliver sends the event retrying with an exponential backoff, returning
+// the status code of the last attempt, or 0 if there was no response
+func (n *notifier) deliver(event *api.EpochEvent) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not encode event")
+	}
+
+	var code int
+	wait := n.backoff
+	for attempt := 1; ; attempt++ {
+		code, err = n.send(event.Type, body)
+		if err == nil {
+			return code, nil
+		}
+		if attempt == notifyAttempts {
+			return code, errors.Wrapf(err, "giving up after %d attempts", attempt)
+		}
+
+		n.logger.Debug("Event delivery failed, retrying",
+			zap.Int("attempt", attempt),
+			zap.Duration("wait", wait),
+			zap.Error(err))
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// send posts the signed event to the notification url once
+func (n *notifier) send(eventType string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(api.HeaderEvent, eventType)
+	req.Header.Set(api.HeaderSignature, api.SignPayload(n.secret, body))
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, deliveryError{code: resp.StatusCode}
+	}
+	return resp.StatusCode, nil
+}
+
+// epochEvent builds the event of the given epoch from the history of the job
+func (job *TrainJob) epochEvent(epoch int) *api.EpochEvent {
+	metrics := make(map[string]float64)
+	for _, metric := range []string{
+		api.MetricTrainLoss, api.MetricParallelism, api.MetricIterations,
+		api.MetricGlobalBatch, api.MetricLearningRate, api.MetricGradNorm,
+		api.MetricStaleNotifications, api.MetricMergeWait, api.MetricBarrierWait, api.MetricEffectiveParallelism, api.MetricEpochCost,
+		api.MetricMeasuredConcurrency, api.MetricK, api.MetricMergeOverhead,
+		api.MetricCanaryLoss, api.MetricCanaryAccuracy,
+		api.MetricValidationLoss, api.MetricAccuracy, api.MetricValidationFunctions,
+		api.MetricValidationMerges,
+	} {
+		values, epochs := job.history.Series(metric)
+		for i := range values {
+			if epochs[i] == epoch {
+				metrics[metric] = values[i]
+			}
+		}
+	}
+
+	// the history keeps the elapsed time at the end of each epoch
+	cumulative := make(map[string]float64)
+	if elapsed := job.history.EpochDuration; epoch <= len(elapsed) {
+		cumulative["elapsed_time"] = elapsed[epoch-1]
+		metrics[api.MetricEpochDuration] = elapsed[epoch-1]
+		if epoch > 1 {
+			metrics[api.MetricEpochDuration] -= elapsed[epoch-2]
+		}
+	}
+
+	opts := job.task.Parameters.Options
+	for _, metric := range []string{api.MetricAccuracy, api.MetricValidationLoss} {
+		if value, _, ok := job.history.Best(metric, opts); ok {
+			cumulative["best_"+metric] = value
+		}
+	}
+
+	// the time to reach each of the accuracy milestones, once reached
+	for _, m := range job.history.Milestones {
+		if m.Reached && m.Epoch <= epoch {
+			cumulative[fmt.Sprintf("time_to_accuracy_%v", m.Accuracy)] = m.Elapsed
+		}
+	}
+
+	return &api.EpochEvent{
+		Type:       api.EventEpochCompleted,
+		JobId:      job.jobId,
+		Epoch:      epoch,
+		Epochs:     job.task.Parameters.Epochs,
+		Time:       time.Now(),
+		Metrics:    metrics,
+		Cumulative: cumulative,
+	}
+}
+
+// notifyEpoch queues the event of the current epoch if the job has a notification url
+func (job *TrainJob) notifyEpoch() {
+	epoch := job.currentEpoch()
+	if job.notifier == nil || epoch <= job.notifiedEpoch {
+		return
+	}
+	job.notifier.notify(job.epochEvent(epoch))
+	job.notifiedEpoch = epoch
+}
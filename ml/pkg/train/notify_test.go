@@ -0,0 +1,164 @@
+package train
+
+import (
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEndpoint fails the first deliveries with the status and
+// keeps the events it receives once their signature is checked
+type fakeEndpoint struct {
+	t      *testing.T
+	secret string
+	status int
+	fail   int
+
+	mu       sync.Mutex
+	attempts int
+	events   []api.EpochEvent
+}
+
+func (e *fakeEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		e.t.Error(err)
+		return
+	}
+	if !api.VerifySignature(e.secret, body, r.Header.Get(api.HeaderSignature)) {
+		e.t.Errorf("got invalid signature %s", r.Header.Get(api.HeaderSignature))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.attempts++
+	if e.attempts <= e.fail {
+		w.WriteHeader(e.status)
+		return
+	}
+
+	var event api.EpochEvent
+	if err = json.Unmarshal(body, &event); err != nil {
+		e.t.Error(err)
+	}
+	if r.Header.Get(api.HeaderEvent) != event.Type {
+		e.t.Errorf("got event header %s, want %s", r.Header.Get(api.HeaderEvent), event.Type)
+	}
+	e.events = append(e.events, event)
+}
+
+// newTestNotifier returns a notifier that does not deliver the queued events
+func newTestNotifier(url string) *notifier {
+	return &notifier{
+		logger:     zap.NewNop(),
+		url:        url,
+		secret:     "secret",
+		httpClient: &http.Client{Timeout: time.Second},
+		backoff:    10 * time.Millisecond,
+		events:     make(chan *api.EpochEvent, 1),
+	}
+}
+
+func TestNotifierDeliver(t *testing.T) {
+	tests := []struct {
+		name      string
+		fail      int
+		wantCode  int
+		wantError bool
+	}{
+		{"first attempt", 0, http.StatusOK, false},
+		{"after retries", notifyAttempts - 1, http.StatusOK, false},
+		{"retries exhausted", notifyAttempts, http.StatusServiceUnavailable, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := &fakeEndpoint{t: t, secret: "secret", status: http.StatusServiceUnavailable, fail: tt.fail}
+			server := httptest.NewServer(endpoint)
+			defer server.Close()
+			n := newTestNotifier(server.URL)
+
+			start := time.Now()
+			code, err := n.deliver(&api.EpochEvent{Type: api.EventEpochCompleted, JobId: "job", Epoch: 3})
+			if (err != nil) != tt.wantError || code != tt.wantCode {
+				t.Fatalf("got code %d and error %v, want %d and error %v", code, err, tt.wantCode, tt.wantError)
+			}
+
+			// the attempts stop at the limit, doubling the wait after every failure
+			attempts := tt.fail + 1
+			if attempts > notifyAttempts {
+				attempts = notifyAttempts
+			}
+			if endpoint.attempts != attempts {
+				t.Errorf("got %d attempts, want %d", endpoint.attempts, attempts)
+			}
+			if wait := n.backoff * (1<<uint(attempts-1) - 1); time.Since(start) < wait {
+				t.Errorf("got delivery in %v, want at least %v of backoff", time.Since(start), wait)
+			}
+			if !tt.wantError && (len(endpoint.events) != 1 || endpoint.events[0].Epoch != 3) {
+				t.Errorf("got events %+v, want the event of epoch 3", endpoint.events)
+			}
+		})
+	}
+}
+
+func TestNotifierDeliverUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	n := newTestNotifier(server.URL)
+	server.Close()
+
+	// there is no code if the endpoint never answered
+	code, err := n.deliver(&api.EpochEvent{Type: api.EventEpochCompleted})
+	if err == nil || code != 0 {
+		t.Errorf("got code %d and error %v, want 0 and an error", code, err)
+	}
+}
+
+func TestNotifierDropsEvents(t *testing.T) {
+	n := newTestNotifier("http://localhost")
+
+	// the events that do not fit in the queue are dropped without blocking the job
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for epoch := 1; epoch <= 3; epoch++ {
+			n.notify(&api.EpochEvent{Type: api.EventEpochCompleted, Epoch: epoch})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("got notify blocked on a full queue")
+	}
+	if event := <-n.events; event.Epoch != 1 || len(n.events) != 0 {
+		t.Errorf("got event of epoch %d queued, want only the first", event.Epoch)
+	}
+}
+
+func TestNotifierClose(t *testing.T) {
+	endpoint := &fakeEndpoint{t: t, secret: "secret"}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	// close waits for the queued events to be delivered
+	n := newNotifier(zap.NewNop(), server.URL, "secret")
+	for epoch := 1; epoch <= 3; epoch++ {
+		n.notify(&api.EpochEvent{Type: api.EventEpochCompleted, Epoch: epoch})
+	}
+	n.close()
+
+	if len(endpoint.events) != 3 {
+		t.Fatalf("got %d events delivered, want 3", len(endpoint.events))
+	}
+	for i, event := range endpoint.events {
+		if event.Epoch != i+1 {
+			t.Errorf("got event of epoch %d delivered in position %d", event.Epoch, i)
+		}
+	}
+}
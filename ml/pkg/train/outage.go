@@ -0,0 +1,154 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// outageProbeBackoff is the wait before probing redis after the connection
+	// is lost, doubled after every failed probe up to outageProbeMaxBackoff
+	outageProbeBackoff    = 500 * time.Millisecond
+	outageProbeMaxBackoff = 5 * time.Second
+)
+
+// isConnectionError returns true if the error comes from losing the connection
+// to redis, or from a redis that is not ready to take writes during a failover
+func isConnectionError(err error) bool {
+	switch cause := errors.Cause(err).(type) {
+	case net.Error:
+		return true
+	case redis.Error:
+		for _, prefix := range []string{"LOADING", "READONLY", "MASTERDOWN"} {
+			if strings.HasPrefix(string(cause), prefix) {
+				return true
+			}
+		}
+		return false
+	default:
+		return cause == io.EOF || cause == io.ErrUnexpectedEOF
+	}
+}
+
+// saveAfterOutage pauses the merge after saving the model failed because redis is
+// unreachable. The functions are held at the merge while redis is probed with a
+// backoff, and the model is saved once it answers. Returns an error if redis does
+// not come back within the outage grace of the job
+func (job *TrainJob) saveAfterOutage(cause error) error {
+	grace := job.task.Parameters.Options.OutageGrace()
+	start := time.Now()
+	job.pauseMerge(start, cause)
+
+	wait := outageProbeBackoff
+	for {
+		remaining := grace - time.Since(start)
+		if remaining <= 0 {
+			job.clearMergePause()
+			return errors.Wrapf(cause, "redis did not come back after %v", grace)
+		}
+		if wait > remaining {
+			wait = remaining
+		}
+		time.Sleep(wait)
+		if wait *= 2; wait > outageProbeMaxBackoff {
+			wait = outageProbeMaxBackoff
+		}
+
+		if err := job.pingRedis(); err != nil {
+			job.logger.Debug("Redis still unreachable", zap.Error(err))
+			continue
+		}
+
+		err := job.model.Save()
+		if err == nil {
+			job.resumeMerge(start)
+			return nil
+		}
+		if !isConnectionError(err) {
+			job.clearMergePause()
+			return err
+		}
+		cause = err
+	}
+}
+
+// pingRedis checks that redis answers with a connection from the pool
+func (job *TrainJob) pingRedis() error {
+	conn := job.redisPool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return err
+}
+
+// pauseMerge reports that the merger is waiting for redis and
+// keeps the queued invocations from timing out meanwhile
+func (job *TrainJob) pauseMerge(since time.Time, cause error) {
+	job.logger.Warn("Lost the connection to redis, pausing the merge",
+		zap.Duration("grace", job.task.Parameters.Options.OutageGrace()),
+		zap.Error(cause))
+
+	if queue, ok := job.invoker.(*queueInvoker); ok {
+		queue.hold()
+	}
+
+	pause := &api.MergePause{Epoch: job.epoch, Since: since, Reason: cause.Error()}
+	if err := job.ps.SetMergePause(job.jobId, pause); err != nil {
+		job.logger.Warn("Could not report the merge pause", zap.Error(err))
+	}
+	job.notifyEvent(api.EventMergePaused, nil)
+}
+
+// resumeMerge reports that redis came back and the merge continues
+func (job *TrainJob) resumeMerge(since time.Time) {
+	outage := time.Since(since)
+	job.logger.Info("Redis is back, resuming the merge", zap.Duration("outage", outage))
+
+	job.clearMergePause()
+	job.notifyEvent(api.EventMergeResumed, map[string]float64{"outage_seconds": outage.Seconds()})
+}
+
+// clearMergePause releases the queued invocations and
+// clears the pause from the state of the job
+func (job *TrainJob) clearMergePause() {
+	if queue, ok := job.invoker.(*queueInvoker); ok {
+		queue.release()
+	}
+	if err := job.ps.SetMergePause(job.jobId, nil); err != nil {
+		job.logger.Warn("Could not clear the merge pause", zap.Error(err))
+	}
+}
+
+// notifyEvent queues an event sent during the training, such as the
+// merge and pause events, if the job has a notification url
+func (job *TrainJob) notifyEvent(eventType string, metrics map[string]float64) {
+	job.notifyEventError(eventType, metrics, nil)
+}
+
+// notifyEventError queues an event caused by an error, which is sent in the event
+func (job *TrainJob) notifyEventError(eventType string, metrics map[string]float64, err error) {
+	if job.notifier == nil {
+		return
+	}
+	if metrics == nil {
+		metrics = make(map[string]float64)
+	}
+	event := &api.EpochEvent{
+		Type:       eventType,
+		JobId:      job.jobId,
+		Epoch:      job.epoch,
+		Epochs:     job.task.Parameters.Epochs,
+		Time:       time.Now(),
+		Metrics:    metrics,
+		Cumulative: make(map[string]float64),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	job.notifier.notify(event)
+}
@@ -0,0 +1,171 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// pauseControl keeps the pause requested through the API until the job takes
+// it at the end of the epoch, and wakes up the job once it is resumed
+type pauseControl struct {
+	mu        sync.Mutex
+	requested *api.PauseRequest
+	paused    bool
+	resumeCh  chan struct{}
+}
+
+func newPauseControl() *pauseControl {
+	return &pauseControl{resumeCh: make(chan struct{}, 1)}
+}
+
+// request records a pause, which fails if the job is already paused or pausing
+func (p *pauseControl) request(req api.PauseRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.requested != nil || p.paused {
+		return kerror.New(http.StatusConflict, "job is already paused or pausing")
+	}
+	p.requested = &req
+	return nil
+}
+
+// take returns the pause requested during the epoch, nil
+// if there is none, and marks the job as paused
+func (p *pauseControl) take() *api.PauseRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	req := p.requested
+	p.requested = nil
+	p.paused = req != nil
+	return req
+}
+
+// resume cancels a pause the job did not take yet or wakes up the paused job
+func (p *pauseControl) resume() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.requested != nil:
+		p.requested = nil
+	case p.paused:
+		p.paused = false
+		select {
+		case p.resumeCh <- struct{}{}:
+		default:
+		}
+	default:
+		return kerror.New(http.StatusConflict, "job is not paused")
+	}
+	return nil
+}
+
+// finish marks the job as running again after it resumed by itself or was
+// stopped, dropping a resume that arrived meanwhile
+func (p *pauseControl) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = false
+	select {
+	case <-p.resumeCh:
+	default:
+	}
+}
+
+// Pause asks the job to pause after the epoch in progress. The job stops invoking
+// functions once the epoch is merged, checkpointed and validated, and waits until
+// it is resumed or the time in the request is reached
+func (job *TrainJob) Pause(req api.PauseRequest) error {
+	if err := req.Validate(time.Now()); err != nil {
+		return kerror.New(http.StatusBadRequest, err.Error())
+	}
+	if err := job.pauses.request(req); err != nil {
+		return err
+	}
+	job.logger.Info("Pause requested, pausing after the current epoch",
+		zap.Int("epoch", job.state.Epoch()),
+		zap.Time("until", req.Until))
+	return nil
+}
+
+// Resume resumes a paused job, or cancels the pause if
+// the job is still finishing the epoch in progress
+func (job *TrainJob) Resume() error {
+	if err := job.pauses.resume(); err != nil {
+		return err
+	}
+	job.logger.Info("Resume requested")
+	return nil
+}
+
+// waitPaused keeps the job paused until it is resumed, the time of the request
+// is reached or it is stopped, which is returned. While paused the job invokes
+// no functions and refreshes its history every PauseHeartbeat. The time paused is
+// excluded from the elapsed time of the job, and the next epoch plans the shards
+// for the stored parallelism and asks the scheduler again after it
+func (job *TrainJob) waitPaused(req api.PauseRequest) (stopped bool) {
+	pause := &api.JobPause{Epoch: job.epoch, Since: time.Now(), Until: req.Until}
+	job.logger.Info("Pausing the job",
+		zap.Int("epoch", job.epoch),
+		zap.Time("until", req.Until))
+	job.setPause(pause)
+	job.notifyEvent(api.EventJobPaused, nil)
+
+	var autoResume <-chan time.Time
+	if !req.Until.IsZero() {
+		timer := time.NewTimer(time.Until(req.Until))
+		defer timer.Stop()
+		autoResume = timer.C
+	}
+	heartbeat := time.NewTicker(api.PauseHeartbeat)
+	defer heartbeat.Stop()
+
+wait:
+	for {
+		select {
+		case <-job.pauses.resumeCh:
+			break wait
+		case <-autoResume:
+			job.logger.Info("Reached the resume time of the pause")
+			break wait
+		case <-job.stopChan:
+			stopped = true
+			break wait
+		case <-heartbeat.C:
+			job.logger.Debug("Job paused", zap.Duration("for", time.Since(pause.Since)))
+			job.bufferHistory()
+		}
+	}
+	job.pauses.finish()
+
+	paused := time.Since(pause.Since)
+	job.pausedTime += paused
+	job.history.PausedTime += paused.Seconds()
+	job.setPause(nil)
+	if stopped {
+		job.logger.Info("Job stopped while paused", zap.Duration("paused", paused))
+		return true
+	}
+
+	job.logger.Info("Resuming the job",
+		zap.Int("epoch", job.epoch+1),
+		zap.Int("parallelism", job.parallelism),
+		zap.Duration("paused", paused))
+	job.history.ResumedEpochs = append(job.history.ResumedEpochs, job.epoch+1)
+	job.decisionEpochs = 0
+	job.notifyEvent(api.EventJobResumed, map[string]float64{"paused_seconds": paused.Seconds()})
+	return false
+}
+
+// setPause keeps the pause of the job, nil once it resumes, and reports
+// it to the parameter server and the history so it is shown in the status
+func (job *TrainJob) setPause(pause *api.JobPause) {
+	job.pause = pause
+	if err := job.ps.SetJobPause(job.jobId, pause); err != nil {
+		job.logger.Warn("Could not report the pause of the job", zap.Error(err))
+	}
+	job.bufferHistory()
+}
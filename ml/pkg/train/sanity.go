@@ -0,0 +1,70 @@
+package train
+
+import (
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sanityCheck trains a single function on one batch before the first epoch and
+// fails the job if the function errors or reports shapes that do not match,
+// instead of finding out after a whole epoch. With the startup probe the losses
+// on the batch before and after one step must also be finite and under the limit
+// of the job. The result is kept in the history
+func (job *TrainJob) sanityCheck() error {
+	opts := job.task.Parameters.Options
+	start := time.Now()
+	check, err := job.invokeSanityFunction()
+	if check == nil {
+		check = &api.SanityCheck{}
+	}
+	check.Elapsed = time.Since(start).Seconds()
+	check.ModelParameters, check.ModelBytes = job.summary.Parameters, job.summary.Bytes
+	job.history.SanityCheck = check
+
+	// functions built with an older version of the library do not know
+	// the task, so the check is skipped unless the job asked for the probe
+	if isUnknownTask(err) && opts.StartupProbe {
+		check.Error = "the function does not support the startup probe"
+		return fmt.Errorf("startup probe of function %s failed: %v", job.task.Parameters.FunctionName, err)
+	}
+	if isUnknownTask(err) {
+		job.logger.Warn("The function does not support the sanity check, skipping it",
+			zap.Error(err))
+		check.Skipped = true
+		return nil
+	}
+
+	if err == nil {
+		err = check.Verify(job.batchSize)
+	}
+	if err == nil && opts.StartupProbe {
+		err = check.VerifyLoss(opts.InitialLossLimit(), job.learningRate)
+	}
+	if err != nil {
+		check.Error = err.Error()
+		return fmt.Errorf("sanity check of function %s on dataset %s failed: %v",
+			job.task.Parameters.FunctionName, job.task.Parameters.Dataset, err)
+	}
+
+	check.Passed = true
+	job.logger.Info("Sanity check passed",
+		zap.Int64s("input", check.InputShape),
+		zap.Int64s("labels", check.LabelShape),
+		zap.Int64s("output", check.OutputShape),
+		zap.Float64("loss", check.Loss),
+		zap.Int64("parameters", check.ModelParameters),
+		zap.Bool("probed", opts.StartupProbe),
+		zap.Float64("elapsed", check.Elapsed))
+	return nil
+}
+
+// isUnknownTask returns whether the function answered that it does not know the task
+func isUnknownTask(err error) bool {
+	e, ok := err.(kerror.Error)
+	return ok && e.Code == http.StatusBadRequest && strings.Contains(e.Message, "not recognized")
+}
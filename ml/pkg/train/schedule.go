@@ -0,0 +1,133 @@
+package train
+
+import (
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"math"
+)
+
+// needsScheduler returns whether the job should ask the scheduler for the parallelism
+// of the next epoch. The job keeps the last decision until it expires unless the
+// epoch time or the train loss changed enough to trigger a new decision early
+func (job *TrainJob) needsScheduler() bool {
+	if job.decisionEpochs <= 0 {
+		return true
+	}
+
+	opts := job.task.Parameters.Options
+	elapsed := job.task.Job.State.ElapsedTime
+	if job.decisionTime > 0 {
+		change := math.Abs(elapsed-job.decisionTime) / job.decisionTime
+		if change > opts.SchedulerThroughputTrigger() {
+			job.logger.Debug("Epoch time changed since the last decision, asking the scheduler",
+				zap.Float64("change", change))
+			return true
+		}
+	}
+
+	if opts.PlateauTrigger > 0 && len(job.history.TrainLoss) > 1 {
+		prev := job.history.TrainLoss[len(job.history.TrainLoss)-2]
+		curr := lastValue(job.history.TrainLoss)
+		if prev != 0 && (prev-curr)/math.Abs(prev) < opts.PlateauTrigger {
+			job.logger.Debug("Train loss plateaued, asking the scheduler",
+				zap.Float64("previous", prev),
+				zap.Float64("loss", curr))
+			return true
+		}
+	}
+
+	return false
+}
+
+// skipScheduler keeps the current parallelism for the next epoch
+// without contacting the scheduler
+func (job *TrainJob) skipScheduler() {
+	job.decisionEpochs--
+	job.history.SchedulerSkips++
+	job.logger.Debug("Scheduler decision still holds, keeping parallelism",
+		zap.Int("parallelism", job.parallelism),
+		zap.Int("epochsLeft", job.decisionEpochs))
+}
+
+// skipRedundantUpdate keeps the parallelism when the update was not sent because
+// nothing the scheduler policy reads changed, so there is no decision to wait for
+func (job *TrainJob) skipRedundantUpdate() {
+	job.history.SchedulerSkips++
+	job.logger.Debug("Update unchanged since the last one, keeping parallelism",
+		zap.Int("parallelism", job.parallelism))
+}
+
+// recordUpdateStats saves in the history the updates the scheduler throttled
+// and the ones the client coalesced into a throttled update
+func (job *TrainJob) recordUpdateStats() {
+	job.history.SchedulerThrottled, job.history.SchedulerCoalesced = job.scheduler.UpdateStats(job.jobId)
+}
+
+// recordDecision keeps the epoch time the scheduler decided on and the
+// number of epochs the decision holds after the next one
+func (job *TrainJob) recordDecision(state *api.JobState) {
+	job.decisionTime = job.task.Job.State.ElapsedTime
+	job.decisionEpochs = state.ValidFor - 1
+}
+
+// capNearGoal keeps the job from scaling up once its last validation accuracy
+// is within the quiet margin of the goal, since more functions give little
+// speedup that close to the end. The scheduler can still lower the parallelism
+func (job *TrainJob) capNearGoal(state *api.JobState) {
+	if len(job.history.Accuracy) == 0 || !job.accuracyScaleKnown() {
+		return
+	}
+
+	accuracy := lastValue(job.history.Accuracy)
+	if !job.task.Parameters.Options.NearGoal(accuracy) {
+		return
+	}
+
+	if job.history.QuietEpoch == 0 {
+		job.history.QuietEpoch = job.epoch
+		job.logger.Info("Accuracy is close to the goal, the job will not scale up anymore",
+			zap.Float64("accuracy", accuracy),
+			zap.Float64("goal", job.goalAccuracy),
+			zap.Float64("margin", job.task.Parameters.Options.QuietMargin),
+			zap.Int("parallelism", job.parallelism))
+	}
+
+	if state.Parallelism > job.parallelism {
+		job.logger.Debug("Capping the parallelism of the scheduler near the goal",
+			zap.Int("requested", state.Parallelism),
+			zap.Int("parallelism", job.parallelism))
+		state.Parallelism = job.parallelism
+	}
+}
+
+// capMaxParallelism keeps the parallelism sent by the scheduler under the max
+// parallelism of the job. The scheduler already caps its decisions, so going over
+// it means the scheduler ignored the cap. Every capped decision is sent as an event,
+// and the first one is also recorded in the effective options of the job
+func (job *TrainJob) capMaxParallelism(state *api.JobState) {
+	if parallelism, capped := job.task.Parameters.Options.CapParallelism(state.Parallelism); capped {
+		job.logger.Warn("The scheduler ignored the max parallelism of the job, capping it",
+			zap.Int("requested", state.Parallelism),
+			zap.Int("max", parallelism))
+		state.CappedFrom = state.Parallelism
+		state.Parallelism = parallelism
+	}
+	if state.CappedFrom == 0 {
+		return
+	}
+
+	job.logger.Debug("Parallelism capped at the max parallelism of the job",
+		zap.Int("requested", state.CappedFrom),
+		zap.Int("parallelism", state.Parallelism))
+	job.notifyEvent(api.EventParallelismCapped, map[string]float64{
+		"requested":       float64(state.CappedFrom),
+		"max_parallelism": float64(state.Parallelism),
+	})
+
+	if !job.parallelismCapped {
+		job.parallelismCapped = true
+		job.task.Parameters.RecordChange("parallelism", state.CappedFrom, state.Parallelism,
+			fmt.Sprintf("capped at the max parallelism from epoch %d", job.epoch))
+	}
+}
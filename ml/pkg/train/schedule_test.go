@@ -0,0 +1,61 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestEffectiveOptionsClamps(t *testing.T) {
+	// the request is filled from the suggestions when it is admitted
+	k := 4
+	req := api.TrainRequest{Epochs: 3, BatchSize: 32, Options: api.TrainOptions{
+		DefaultParallelism: 2,
+		K:                  8,
+		MaxParallelism:     4,
+		UseSuggestions:     []string{api.SuggestParallelism, api.SuggestK},
+	}}
+	req.ApplySuggestions(&api.Suggestions{Parallelism: 3, ParallelismJob: "previous", K: &k, Runs: 5})
+
+	job := &TrainJob{
+		logger: zap.NewNop(),
+		level:  zap.NewAtomicLevel(),
+		task:   &api.TrainTask{Parameters: req},
+		epoch:  2,
+	}
+
+	// the scheduler ignores the max parallelism twice, only the first cap is recorded
+	for _, requested := range []int{6, 8} {
+		state := &api.JobState{Parallelism: requested}
+		job.capMaxParallelism(state)
+		if state.Parallelism != 4 || state.CappedFrom != requested {
+			t.Errorf("got parallelism %d capped from %d, want 4 capped from %d", state.Parallelism, state.CappedFrom, requested)
+		}
+	}
+
+	// an invalid log level is replaced by the current one
+	job.setLogLevel("verbose")
+
+	effective := job.task.Parameters.Effective
+	want := []api.OptionChange{
+		{Field: "options.default_parallelism", Original: "2", Effective: "3"},
+		{Field: "options.k", Original: "8", Effective: "4"},
+		{Field: "parallelism", Original: "6", Effective: "4"},
+		{Field: "options.log_level", Original: "verbose", Effective: "info"},
+	}
+	if len(effective.Changes) != len(want) {
+		t.Fatalf("got changes %+v, want %d", effective.Changes, len(want))
+	}
+	for i, change := range effective.Changes {
+		if change.Field != want[i].Field || change.Original != want[i].Original ||
+			change.Effective != want[i].Effective || len(change.Reason) == 0 {
+			t.Errorf("got change %+v, want %+v with a reason", change, want[i])
+		}
+	}
+
+	// the snapshot holds the options after every change
+	opts := effective.Options
+	if opts.DefaultParallelism != 3 || opts.K != 4 || opts.LogLevel != "info" || effective.Epochs != 3 {
+		t.Errorf("got effective options %+v, want the options after the changes", effective)
+	}
+}
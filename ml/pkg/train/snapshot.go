@@ -0,0 +1,83 @@
+package train
+
+import (
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// publishSnapshot copies the reference model after the merge of the epoch so the
+// inferences run while the job trains read a model no function is updating. The
+// snapshot is only published once all its layers are saved, and the oldest one is
+// deleted once there are more than api.SnapshotsKept, so the inferences routed to
+// the previous snapshot can still finish. A snapshot that can't be saved is skipped
+func (job *TrainJob) publishSnapshot() {
+	if !job.task.Parameters.Options.LiveInferenceAllowed() {
+		return
+	}
+
+	job.snapshotMu.Lock()
+	generation := 1
+	if n := len(job.snapshots); n > 0 {
+		generation = job.snapshots[n-1].Generation + 1
+	}
+	job.snapshotMu.Unlock()
+
+	snapshot := &api.LiveSnapshot{
+		JobId:      job.jobId,
+		ModelId:    api.SnapshotModelId(job.jobId, generation),
+		Generation: generation,
+		Epoch:      job.epoch,
+		Created:    time.Now(),
+	}
+	// the copy is taken between merges, so all its layers come from the same one
+	job.modelMu.Lock()
+	err := job.model.SaveSnapshot(snapshot.ModelId)
+	job.modelMu.Unlock()
+	if err != nil {
+		job.logger.Warn("Could not save the snapshot of the model",
+			zap.Int("epoch", job.epoch),
+			zap.Error(err))
+		return
+	}
+
+	job.snapshotMu.Lock()
+	job.snapshots = append(job.snapshots, snapshot)
+	var expired []*api.LiveSnapshot
+	if n := len(job.snapshots); n > api.SnapshotsKept {
+		expired = job.snapshots[:n-api.SnapshotsKept]
+		job.snapshots = append([]*api.LiveSnapshot(nil), job.snapshots[n-api.SnapshotsKept:]...)
+	}
+	job.snapshotMu.Unlock()
+
+	job.logger.Debug("Published snapshot of the model",
+		zap.Int("generation", generation),
+		zap.Int("epoch", job.epoch))
+
+	for _, s := range expired {
+		if err := job.model.DeleteSnapshot(s.ModelId); err != nil {
+			job.logger.Warn("Could not delete old snapshot of the model",
+				zap.String("modelId", s.ModelId),
+				zap.Error(err))
+		}
+	}
+}
+
+// LatestSnapshot returns the last snapshot of the model published by the job. The
+// error has status 409 if the job does not allow live inference and 503 if there
+// is no snapshot yet, which happens until the first epoch is merged
+func (job *TrainJob) LatestSnapshot() (*api.LiveSnapshot, error) {
+	if task := job.state.Task(); task != nil && !task.Parameters.Options.LiveInferenceAllowed() {
+		return nil, kerror.New(http.StatusConflict, "the job does not allow inference while it trains")
+	}
+
+	job.snapshotMu.Lock()
+	defer job.snapshotMu.Unlock()
+	if len(job.snapshots) == 0 {
+		return nil, kerror.New(http.StatusServiceUnavailable, "the job has no snapshot of the model yet")
+	}
+	snapshot := *job.snapshots[len(job.snapshots)-1]
+	return &snapshot, nil
+}
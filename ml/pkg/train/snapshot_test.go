@@ -0,0 +1,192 @@
+package train
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/RedisAI/redisai-go/redisai"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/model"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gorgonia.org/tensor"
+	"sync"
+	"testing"
+)
+
+// snapshotLayers are the layers of the model of the snapshot tests
+var snapshotLayers = []string{"fc1.weight", "fc1.bias", "fc2.weight", "fc2.bias"}
+
+// newSnapshotTestJob returns a job whose model, saved in a memory store,
+// has all its layers set to 0
+func newSnapshotTestJob(t *testing.T) (*TrainJob, *model.MemoryStore) {
+	t.Helper()
+	store := model.NewMemoryStore()
+	for _, name := range snapshotLayers {
+		if err := store.SetTensor("job:"+name, snapshotTensor(0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := model.NewModel(zap.NewNop(), "job", api.TrainRequest{}, snapshotLayers, store)
+	if err := m.Build(); err != nil {
+		t.Fatal(err)
+	}
+	job := &TrainJob{
+		logger:  zap.NewNop(),
+		jobId:   "job",
+		epoch:   1,
+		model:   m,
+		modelMu: &sync.Mutex{},
+		state:   &jobState{},
+		task:    &api.TrainTask{},
+	}
+	return job, store
+}
+
+func snapshotTensor(value float32) *model.Tensor {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, []float32{value, value})
+	return &model.Tensor{Dtype: redisai.TypeFloat32, Shape: []int64{2}, Blob: buf.Bytes()}
+}
+
+// mergeSnapshotModel replaces the layers of the model with the value
+// and saves it, holding the model lock as the merger does
+func mergeSnapshotModel(t *testing.T, job *TrainJob, value float32) {
+	job.modelMu.Lock()
+	defer job.modelMu.Unlock()
+	for _, name := range snapshotLayers {
+		layer := job.model.StateDict[name]
+		layer.Weights = tensor.New(tensor.WithShape(2), tensor.WithBacking([]float32{value, value}))
+	}
+	if err := job.model.Save(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLiveInferenceRacingMerge(t *testing.T) {
+	job, store := newSnapshotTestJob(t)
+	const readers, readsEach = 4, 100
+
+	var training sync.WaitGroup
+	stop := make(chan struct{})
+
+	// the merger saves a new model with all the layers set to its number
+	training.Add(1)
+	go func() {
+		defer training.Done()
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				mergeSnapshotModel(t, job, float32(i))
+			}
+		}
+	}()
+
+	// and the snapshots are taken while the merges run
+	training.Add(1)
+	go func() {
+		defer training.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				job.publishSnapshot()
+			}
+		}
+	}()
+
+	// the inferences read the layers of the latest snapshot
+	var mu sync.Mutex
+	reads, expired := 0, 0
+	var inferences sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		inferences.Add(1)
+		go func() {
+			defer inferences.Done()
+			for n := 0; n < readsEach; {
+				snapshot, err := job.LatestSnapshot()
+				if err != nil {
+					// no snapshot published yet
+					continue
+				}
+				n++
+				if snapshot.ModelId != api.SnapshotModelId("job", snapshot.Generation) {
+					t.Errorf("got model %s for generation %d", snapshot.ModelId, snapshot.Generation)
+					return
+				}
+
+				values, err := readSnapshot(store, snapshot.ModelId)
+				if errors.Cause(err) == model.ErrTensorNotFound {
+					// only the snapshots older than those kept are deleted
+					latest, _ := job.LatestSnapshot()
+					if latest.Generation-snapshot.Generation < api.SnapshotsKept {
+						t.Errorf("got generation %d deleted while %d is the latest", snapshot.Generation, latest.Generation)
+						return
+					}
+					mu.Lock()
+					expired++
+					mu.Unlock()
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				// all the layers come from the same merge
+				first := values[snapshotLayers[0]]
+				for name, v := range values {
+					if v != first {
+						t.Errorf("got layer %s of generation %d from merge %v, want all from merge %v",
+							name, snapshot.Generation, v, first)
+						return
+					}
+				}
+				mu.Lock()
+				reads++
+				mu.Unlock()
+			}
+		}()
+	}
+	inferences.Wait()
+	close(stop)
+	training.Wait()
+
+	if reads == 0 {
+		t.Fatal("got no inference read a snapshot")
+	}
+	t.Logf("%d snapshot reads, %d found expired", reads, expired)
+
+	// the snapshots kept are the last published
+	job.snapshotMu.Lock()
+	kept := len(job.snapshots)
+	job.snapshotMu.Unlock()
+	if kept > api.SnapshotsKept {
+		t.Errorf("got %d snapshots kept, want at most %d", kept, api.SnapshotsKept)
+	}
+}
+
+// readSnapshot returns the first value of each layer of the snapshot
+func readSnapshot(store model.ModelStore, modelId string) (map[string]float32, error) {
+	keys := make([]string, len(snapshotLayers))
+	for i, name := range snapshotLayers {
+		keys[i] = modelId + ":" + name
+	}
+	tensors, err := store.GetTensors(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]float32, len(tensors))
+	for i, t := range tensors {
+		var v [2]float32
+		if err := binary.Read(bytes.NewReader(t.Blob), binary.LittleEndian, &v); err != nil {
+			return nil, err
+		}
+		values[snapshotLayers[i]] = v[0]
+	}
+	return values, nil
+}
@@ -0,0 +1,132 @@
+package train
+
+import (
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"sync"
+)
+
+// jobState is the view of the task and the history of a job read outside of the
+// training loop, by the api handlers, the parameter server running the job in its
+// process, the merger and the reporters.
+//
+// The ownership rules are:
+//   - the training loop is the only writer of job.task, job.history and job.epoch,
+//     and reads them without locking
+//   - the goroutines the loop starts for the epoch in progress, the function
+//     invocations and the merger, may read them since the loop waits for them
+//     before writing them again
+//   - every other goroutine reads the copies published here, which the loop
+//     replaces with publishState whenever it hands the history to the writer
+//
+// The copies are never modified once published, so readers can keep them. The
+// summary of the model is published once it is built, and never changes after
+type jobState struct {
+	mu      sync.RWMutex
+	epoch   int
+	task    *api.TrainTask
+	history *api.JobHistory
+	summary *api.ModelSummary
+}
+
+// set replaces the published copies
+func (s *jobState) set(epoch int, task *api.TrainTask, history *api.JobHistory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.epoch, s.task, s.history = epoch, task, history
+}
+
+// setSummary publishes the summary of the model once it is built
+func (s *jobState) setSummary(summary *api.ModelSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary = summary
+}
+
+// Summary returns the summary of the model, nil until it is built
+func (s *jobState) Summary() *api.ModelSummary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.summary
+}
+
+// Epoch returns the epoch of the job when the state was last published
+func (s *jobState) Epoch() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.epoch
+}
+
+// Task returns the task of the job, nil until the job gets it
+func (s *jobState) Task() *api.TrainTask {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.task
+}
+
+// History returns the history of the job, empty until the first epoch is published
+func (s *jobState) History() *api.JobHistory {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.history == nil {
+		return &api.JobHistory{}
+	}
+	return s.history
+}
+
+// publishState publishes copies of the task and the history for the readers
+// outside of the training loop. Only called from the training loop, or before
+// it starts, as the copies are taken without locking
+func (job *TrainJob) publishState() {
+	var task *api.TrainTask
+	if job.task != nil {
+		t, err := copyTask(job.task)
+		if err != nil {
+			job.logger.Warn("Could not copy the task to publish it", zap.Error(err))
+			return
+		}
+		task = t
+	}
+
+	history, err := copyHistory(&job.history)
+	if err != nil {
+		job.logger.Warn("Could not copy the history to publish it", zap.Error(err))
+		return
+	}
+	job.state.set(job.epoch, task, history)
+}
+
+// State returns the task and the history of the job last published by its
+// training loop, which are safe to read from any goroutine
+func (job *TrainJob) State() (*api.TrainTask, *api.JobHistory) {
+	return job.state.Task(), job.state.History()
+}
+
+// copyTask returns a deep copy of the task, the fields that are not
+// encoded, like the pod and service of the job, are shared
+func copyTask(task *api.TrainTask) (*api.TrainTask, error) {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return nil, err
+	}
+	var t api.TrainTask
+	if err = json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	t.Job.Pod, t.Job.Svc, t.Job.Channel = task.Job.Pod, task.Job.Svc, task.Job.Channel
+	return &t, nil
+}
+
+// copyHistory returns a deep copy of the history
+func copyHistory(history *api.JobHistory) (*api.JobHistory, error) {
+	data, err := json.Marshal(history)
+	if err != nil {
+		return nil, err
+	}
+	var h api.JobHistory
+	if err = json.Unmarshal(data, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
@@ -0,0 +1,161 @@
+package train
+
+import (
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// newStateTestJob returns a job with the fields the training loop
+// publishes, as it is left once it takes its task
+func newStateTestJob() *TrainJob {
+	job := &TrainJob{
+		logger: zap.NewNop(),
+		level:  zap.NewAtomicLevel(),
+		jobId:  "job",
+		state:  &jobState{},
+		task: &api.TrainTask{
+			Parameters: api.TrainRequest{Epochs: 100, NotifySecret: "secret"},
+			Job:        api.JobInfo{JobId: "job"},
+		},
+	}
+	job.publishState()
+	return job
+}
+
+// trainEpoch makes the changes of an epoch of the training loop to the job
+func trainEpoch(job *TrainJob) {
+	job.epoch++
+	job.task.Job.State.Parallelism = job.epoch
+	job.history.TrainLoss = append(job.history.TrainLoss, 1/float64(job.epoch))
+	job.history.Parallelism = append(job.history.Parallelism, float64(job.epoch))
+	job.publishState()
+}
+
+func TestPublishStateCopies(t *testing.T) {
+	job := newStateTestJob()
+	trainEpoch(job)
+
+	task, history := job.State()
+	if len(history.TrainLoss) != 1 || task.Job.State.Parallelism != 1 {
+		t.Fatalf("got history %v and parallelism %d, want one epoch", history.TrainLoss, task.Job.State.Parallelism)
+	}
+
+	// the loop keeps writing its own task and history, which
+	// does not change the copies the readers already have
+	job.task.Job.State.Parallelism = 5
+	job.history.TrainLoss[0] = 10
+	if task.Job.State.Parallelism != 1 || history.TrainLoss[0] != 1 {
+		t.Errorf("got parallelism %d and loss %v, want the published ones", task.Job.State.Parallelism, history.TrainLoss[0])
+	}
+	if epoch := job.state.Epoch(); epoch != 1 {
+		t.Errorf("got epoch %d, want 1", epoch)
+	}
+}
+
+func TestStatusBeforeTask(t *testing.T) {
+	job := &TrainJob{logger: zap.NewNop(), jobId: "job", state: &jobState{}}
+	w := httptest.NewRecorder()
+	job.GetHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status code %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	w = httptest.NewRecorder()
+	job.GetHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/model/summary", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status code %d for the summary, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+// TestStatusDuringTraining polls the status and the summary of the model while
+// a fake training loop runs its epochs, and is meant to be run with -race
+func TestStatusDuringTraining(t *testing.T) {
+	const (
+		epochs  = 200
+		readers = 8
+	)
+	job := newStateTestJob()
+	server := httptest.NewServer(job.GetHandler())
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := 0; e < epochs; e++ {
+			if e == epochs/2 {
+				job.summary = &api.ModelSummary{Parameters: 10}
+				job.state.setSummary(job.summary)
+			}
+			trainEpoch(job)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last := 0
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				resp, err := http.Get(server.URL + "/status")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var status api.History
+				err = json.NewDecoder(resp.Body).Decode(&status)
+				resp.Body.Close()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				// every status is a whole epoch and they never go back
+				epoch := len(status.Data.TrainLoss)
+				if len(status.Data.Parallelism) != epoch {
+					t.Errorf("got %d losses and %d parallelisms in the same status",
+						epoch, len(status.Data.Parallelism))
+					return
+				}
+				if epoch < last {
+					t.Errorf("got epoch %d after %d", epoch, last)
+					return
+				}
+				last = epoch
+				if len(status.Task.NotifySecret) > 0 {
+					t.Error("got the notify secret in the status")
+					return
+				}
+
+				resp, err = http.Get(server.URL + "/model/summary")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+			}
+		}()
+	}
+
+	<-done
+	wg.Wait()
+
+	_, history := job.State()
+	if len(history.TrainLoss) != epochs {
+		t.Errorf("got %d epochs published, want %d", len(history.TrainLoss), epochs)
+	}
+	if summary := job.state.Summary(); summary == nil || summary.Parameters != 10 {
+		t.Errorf("got summary %+v, want the published one", summary)
+	}
+}
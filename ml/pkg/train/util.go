@@ -1,28 +1,39 @@
 package train
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/diegostock12/kubeml/ml/pkg/api"
 	"github.com/diegostock12/kubeml/ml/pkg/util"
-	"github.com/gomodule/redigo/redis"
 	"github.com/pkg/errors"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"time"
 )
 
-// updateValidationMetrics updates the validation statistics in the PS
-func (job *TrainJob) updateValidationMetrics(valLoss, accuracy float64) error {
-	job.history.ValidationLoss = append(job.history.ValidationLoss, valLoss)
-	job.history.Accuracy = append(job.history.Accuracy, accuracy)
+// updateValidationMetrics updates the validation statistics in the PS, along
+// with the number of functions the statistics were averaged over. The accuracy
+// of each class is only kept in the history
+func (job *TrainJob) updateValidationMetrics(valLoss, accuracy float64, functions int, classes classCounts) error {
+	job.setEpochMetrics(map[string]float64{
+		api.MetricValidationLoss:      valLoss,
+		api.MetricAccuracy:            accuracy,
+		api.MetricValidationFunctions: float64(functions),
+	})
+
+	if job.task.Parameters.Options.WantsMetric(api.MetricsPerClass) {
+		if len(classes.total) == 0 {
+			job.logger.Warn("Per-class metrics enabled but the validation functions did not report them")
+		} else {
+			job.history.SetClassAccuracy(job.currentEpoch(), classes.correct, classes.total,
+				job.task.Parameters.Options.MetricDecimals(api.MetricAccuracy))
+		}
+	}
 
 	// send the update to the PS
-	err := job.ps.UpdateMetrics(job.jobId, getLatestMetrics(&job.history))
+	err := job.ps.UpdateMetrics(job.jobId, job.latestMetrics())
 	if err != nil {
 		return errors.Wrap(err, "error sending validation update to parameter server")
 	}
@@ -31,17 +42,50 @@ func (job *TrainJob) updateValidationMetrics(valLoss, accuracy float64) error {
 
 }
 
+// setEpochMetrics sets the metrics of the current epoch in the history,
+// overwriting them if they were already set. The values are rounded to
+// the precision configured for the job before saving them
+func (job *TrainJob) setEpochMetrics(metrics map[string]float64) {
+	names := make([]string, 0, len(metrics))
+	for metric := range metrics {
+		names = append(names, metric)
+	}
+	sort.Strings(names)
+
+	epoch := job.currentEpoch()
+	for _, metric := range names {
+		value := api.RoundMetric(metrics[metric], job.task.Parameters.Options.MetricDecimals(metric))
+		if err := job.history.SetEpochMetric(metric, epoch, value); err != nil {
+			job.logger.Error("could not set metric in history",
+				zap.String("metric", metric),
+				zap.Int("epoch", epoch),
+				zap.Error(err))
+		}
+	}
+}
+
+// currentEpoch returns the epoch being trained, after the
+// training loop ends this is the last trained epoch
+func (job *TrainJob) currentEpoch() int {
+	if job.epoch > job.task.Parameters.Epochs {
+		return job.task.Parameters.Epochs
+	}
+	return job.epoch
+}
+
 // updateTrainMetrics updates the metrics in the job history and sends an update to the
 // parameter server to publish the new metrics to prometheus
 func (job *TrainJob) updateTrainMetrics(loss float64, elapsed time.Duration) error {
 
 	// add the new metrics to the history
-	job.history.Parallelism = append(job.history.Parallelism, float64(job.parallelism))
-	job.history.EpochDuration = append(job.history.EpochDuration, elapsed.Seconds())
-	job.history.TrainLoss = append(job.history.TrainLoss, loss)
+	job.setEpochMetrics(map[string]float64{
+		api.MetricParallelism:   float64(job.parallelism),
+		api.MetricEpochDuration: elapsed.Seconds(),
+		api.MetricTrainLoss:     loss,
+	})
 
 	// send the update to the PS
-	err := job.ps.UpdateMetrics(job.jobId, getLatestMetrics(&job.history))
+	err := job.ps.UpdateMetrics(job.jobId, job.latestMetrics())
 	if err != nil {
 		return errors.Wrap(err, "error sending train update to parameter server")
 	}
@@ -62,45 +106,47 @@ func createMongoURI() string {
 func parseLayerNames(resp *http.Response) ([]string, error) {
 	var names []string
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	err := util.DecodeResponse(resp, &names)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read body")
-	}
-
-	err = json.Unmarshal(body, &names)
-	if err != nil {
-		return nil, errors.Wrap(err, "error unmarshaling json")
+		return nil, errors.Wrap(err, "error decoding layer names")
 	}
 
 	return names, nil
 
 }
 
-// getAverageLoss iterates through the function results gotten from several
-// training functions and returns the average loss and the ids of the functions that completed
-func getAverageLoss(respChan chan *FunctionResults) (float64, []int) {
+// getTrainResults iterates through the function results gotten from several training
+// functions and returns the loss combined with the reduction of the options, the ids of
+// the functions that completed and the capacities they reported, keyed by the function id
+func getTrainResults(respChan chan *FunctionResults, options api.TrainOptions) (float64, []int, map[int]float64) {
 	var funcs []int
-	var loss float64
+	var losses, lengths []float64
+	capacities := make(map[int]float64)
 
 	// close the channel so it can be iterated over
 	close(respChan)
 	for response := range respChan {
-		loss += response.results["loss"]
+		losses = append(losses, response.results["loss"])
+		lengths = append(lengths, response.results["length"])
 		funcs = append(funcs, response.funcId)
+		if c, exists := response.results["capacity"]; exists && c > 0 {
+			capacities[response.funcId] = c
+		}
 	}
 
-	avgLoss := loss / float64(len(funcs))
-	return avgLoss, funcs
+	return options.ReduceLoss(losses, lengths), funcs, capacities
 }
 
 // getValidationMetrics analyzes the results of validation functions containing
 // the accuracy, the loss and the number of datapoints used in each, and performs
-// the weighted averaging of both according to the number of points
-func getValidationMetrics(respChan chan *FunctionResults) (float64, float64, float64) {
+// the weighted averaging of both according to the number of points. It also
+// returns the ids of the functions that reported and the per-class counts
+func getValidationMetrics(respChan chan *FunctionResults) (float64, float64, float64, []int, classCounts) {
 	var accuracy float64
 	var loss float64
 	var total float64
+	var funcs []int
+	classes := classCounts{correct: map[string]float64{}, total: map[string]float64{}}
 
 	// close the channel
 	close(respChan)
@@ -111,32 +157,46 @@ func getValidationMetrics(respChan chan *FunctionResults) (float64, float64, flo
 		loss += response.results["loss"] * length
 		accuracy += response.results["accuracy"] * length
 		total += length
+		funcs = append(funcs, response.funcId)
+		api.ClassCounts(response.results, classes.correct, classes.total)
 	}
 
 	// divide by the total number of points to get the accuracy
 	accuracy /= total
 	loss /= total
 
-	return accuracy, loss, total
+	return accuracy, loss, total, funcs, classes
 
 }
 
-// parseFunctionResults takes care of extracting the results from the response body
-func parseFunctionResults(resp *http.Response) (map[string]float64, error) {
-
+// parseFunctionResults takes care of extracting the results from the response body,
+// which are checked against the schema of the task. The response is decoded as a
+// generic map first so the same checks apply to JSON and msgpack
+func (job *TrainJob) parseFunctionResults(resp *http.Response, task FunctionTask) (map[string]float64, error) {
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to read response body")
+		return nil, errors.Wrap(err, "could not read response body")
 	}
 
-	var results map[string]float64
-	err = json.Unmarshal(body, &results)
-	if err != nil {
-		return nil, err
+	var fields map[string]interface{}
+	if err = util.Decode(resp.Header.Get("Content-Type"), body, &fields); err != nil {
+		return nil, api.NewResponseError(string(task), "", err.Error(), body)
 	}
 
-	return results, nil
+	return responseSchema(task).Metrics(fields, body, job.strictResponses)
+}
+
+// responseSchema returns the schema of the response of a function task
+func responseSchema(task FunctionTask) api.ResponseSchema {
+	switch task {
+	case Validation:
+		return api.ValidationResponseSchema
+	case Canary:
+		return api.CanaryResponseSchema
+	default:
+		return api.TrainResponseSchema
+	}
 }
 
 // checkFunctionErrors checks that all of the functions or some of them returned without
@@ -202,79 +262,127 @@ func getLatestMetrics(history *api.JobHistory) *api.MetricUpdate {
 		TrainLoss:      lastValue(history.TrainLoss),
 		Parallelism:    lastValue(history.Parallelism),
 		EpochDuration:  lastValue(history.EpochDuration),
+
+		MeasuredConcurrency: lastValue(history.MeasuredConcurrency),
 	}
 }
 
-// clearTensors simply drops the keys and values used during training by the
-// different functions and keeps only the reference model in the database
-// to save space
-func (job *TrainJob) clearTensors() {
-
-	// disable the pipeline in the client
-	redisClient := util.GetRedisAIClient(job.redisPool, false)
-	defer redisClient.Close()
+// latestMetrics returns the latest metrics of the job along with
+// the updated estimation of the remaining time
+func (job *TrainJob) latestMetrics() *api.MetricUpdate {
+	job.metricSeq++
+
+	metrics := getLatestMetrics(&job.history)
+	metrics.Epoch = job.currentEpoch()
+	metrics.Seq = job.metricSeq
+	metrics.ETA = job.estimateETA()
+	metrics.RedisMemory = job.redisMemory
+	metrics.Durations = job.durations.total()
+	return metrics
+}
 
-	// delete all of the tensors for that model in the database
-	filterStr := fmt.Sprintf("%s*", job.jobId)
-	tensorListArgs := redis.Args{filterStr}
-	tensorNames, err := redis.Strings(redisClient.DoOrSend("KEYS", tensorListArgs, nil))
-	if err != nil {
-		job.logger.Error("Error accessing tensors to be deleted", zap.Error(err))
+// checkStopRules stops the job if any of its stop rules fired with the metrics
+// of the epoch or its train loss stalled, recording the reason in the history
+func (job *TrainJob) checkStopRules() {
+	opts := job.task.Parameters.Options
+	if rule := job.history.FiredStopRule(opts.StopRules); rule != nil {
+		job.logger.Info("Stop rule fired, stopping the job",
+			zap.String("rule", rule.String()),
+			zap.Int("epoch", job.epoch))
+		job.history.StoppedBy = rule.String()
+	} else if stalled, improvement := job.history.Stalled(opts); stalled {
+		job.logger.Info("Train loss stalled, stopping the job",
+			zap.Float64("improvement", improvement),
+			zap.Int("window", opts.TrainImprovementWindow()),
+			zap.Int("epoch", job.epoch))
+		job.history.StoppedBy = fmt.Sprintf("training stalled: train loss improved %.2f%% in %d epochs, minimum %v%%",
+			improvement*100, opts.TrainImprovementWindow(), opts.MinTrainImprovement*100)
+	} else {
 		return
 	}
 
-	if len(tensorNames) == 0 {
-		job.logger.Error("No tensors found in storage")
-		return
+	// the channel is buffered, if a stop is already
+	// pending the job will stop anyway
+	select {
+	case job.stopChan <- struct{}{}:
+	default:
 	}
+}
 
-	job.logger.Debug("Deleting tensors...", zap.Strings("names", tensorNames))
+// jobHistory returns the history of the job as saved in the database
+func (job *TrainJob) jobHistory(inProgress bool) *api.History {
+	h := &api.History{
+		Id:         job.jobId,
+		Task:       job.task.Redacted().Parameters,
+		Data:       job.history,
+		InProgress: inProgress,
+		Started:    job.startTime,
+		Paused:     job.pause,
+	}
+	if !inProgress {
+		h.Finished = time.Now()
+		if job.exitErr != nil {
+			h.Error = job.exitErr.Error()
+		}
+	}
+	return h
+}
 
-	// delete the temporary tensors in one call
-	deleteArgs := redis.Args{}.AddFlat(tensorNames)
-	num, err := redis.Int(redisClient.DoOrSend("DEL", deleteArgs, nil))
+// startHistoryWriter starts writing the history of the job to the database while
+// it trains. If the database is not reachable the history is only saved at the end
+func (job *TrainJob) startHistoryWriter() {
+	interval := time.Duration(job.task.Job.HistoryFlushInterval) * time.Second
+	hw, err := newHistoryWriter(job.logger, job.jobId, interval)
 	if err != nil {
-		job.logger.Error("Error deleting database tensors", zap.Error(err))
+		job.logger.Warn("Could not start the history writer", zap.Error(err))
 		return
 	}
-	if num == 0 {
-		job.logger.Warn("No tensors with this name found in the database")
-	}
-	job.logger.Debug("Delete from the database", zap.Int("num tensors", num))
+	job.historyWriter = hw
 }
 
-// saveTrainingHistory saves the history in the mongo database
-func (job *TrainJob) saveTrainingHistory() {
-	// get the mongo connection
-	client, err := mongo.NewClient(options.Client().ApplyURI(createMongoURI()))
-	if err != nil {
-		job.logger.Error("Could not create mongo client", zap.Error(err))
+// bufferHistory hands the latest history of the job to the
+// writer, which saves it in the next periodic flush
+func (job *TrainJob) bufferHistory() {
+	job.publishState()
+	if job.historyWriter == nil {
 		return
 	}
+	if err := job.historyWriter.update(job.jobHistory(true)); err != nil {
+		job.logger.Warn("Could not buffer history", zap.Error(err))
+	}
+}
 
-	// Save the history in the kubeml database in the history collections
-	err = client.Connect(context.TODO())
-	if err != nil {
-		job.logger.Error("Could not connect to mongo", zap.Error(err))
+// closeHistory is called when the job exits. If the job failed after its history
+// was written while training, the history is updated so it is not left in progress
+func (job *TrainJob) closeHistory() {
+	if job.historyWriter == nil {
 		return
 	}
-	defer client.Disconnect(context.TODO())
-
-	// Create the history and index by id
-	collection := client.Database("kubeml").Collection("history")
-	h := api.History{
-		Id:   job.jobId,
-		Task: job.task.Parameters,
-		Data: job.history,
+	if job.historyWriter.hasFlushed() {
+		job.saveTrainingHistory()
+		return
 	}
+	job.historyWriter.discard()
+	job.historyWriter = nil
+}
 
-	// insert it in the DB
-	resp, err := collection.InsertOne(context.TODO(), h)
-	if err != nil {
-		job.logger.Error("Could not insert the history in the database",
-			zap.Error(err))
+// saveTrainingHistory saves the final history in the mongo database,
+// replacing the one written while the job was training
+func (job *TrainJob) saveTrainingHistory() {
+	hw := job.historyWriter
+	job.historyWriter = nil
+	if hw == nil {
+		var err error
+		if hw, err = newHistoryWriter(job.logger, job.jobId, 0); err != nil {
+			job.logger.Error("Could not save the history in the database", zap.Error(err))
+			return
+		}
 	}
 
-	job.logger.Info("Inserted history", zap.Any("id", resp.InsertedID))
+	if err := hw.close(job.jobHistory(false)); err != nil {
+		job.logger.Error("Could not save the history in the database", zap.Error(err))
+		return
+	}
 
+	job.logger.Info("Saved history", zap.String("id", job.jobId))
 }
@@ -0,0 +1,128 @@
+package train
+
+import (
+	"bytes"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/diegostock12/kubeml/ml/pkg/util"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// rawResponse returns the response of a function with the body as is
+func rawResponse(contentType string, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{contentType}},
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+	}
+}
+
+func TestParseFunctionResults(t *testing.T) {
+	const (
+		loose  = false
+		strict = true
+	)
+	tests := []struct {
+		name   string
+		task   FunctionTask
+		body   string
+		strict bool
+		want   map[string]float64
+		// the field of the error, empty if the body can not be decoded
+		wantField string
+		wantError bool
+	}{
+		{name: "train", task: Train, body: `{"loss": 0.5, "capacity": 2}`, strict: strict,
+			want: map[string]float64{"loss": 0.5, "capacity": 2}},
+		{name: "train missing loss", task: Train, body: `{"capacity": 2}`, strict: strict,
+			wantField: "loss", wantError: true},
+		{name: "train missing loss loose", task: Train, body: `{"capacity": 2}`, strict: loose,
+			want: map[string]float64{"capacity": 2}},
+		{name: "train extra field", task: Train, body: `{"loss": 1, "lr": 0.1}`, strict: strict,
+			wantField: "lr", wantError: true},
+		{name: "train extra field loose", task: Train, body: `{"loss": 1, "lr": 0.1}`, strict: loose,
+			want: map[string]float64{"loss": 1}},
+		{name: "train string loss", task: Train, body: `{"loss": "0.5"}`, strict: loose,
+			wantField: "loss", wantError: true},
+		{name: "train truncated", task: Train, body: `{"loss": 0.`, strict: loose,
+			wantError: true},
+		{name: "train malformed", task: Train, body: `loss=0.5`, strict: loose,
+			wantError: true},
+		{name: "train list", task: Train, body: `[0.5]`, strict: loose,
+			wantError: true},
+
+		{name: "validation", task: Validation, strict: strict,
+			body: `{"loss": 1, "accuracy": 0.5, "length": 10, "class_correct_0": 3, "class_total_0": 4}`,
+			want: map[string]float64{"loss": 1, "accuracy": 0.5, "length": 10, "class_correct_0": 3, "class_total_0": 4}},
+		{name: "validation missing length", task: Validation, body: `{"loss": 1, "accuracy": 0.5}`, strict: strict,
+			wantField: "length", wantError: true},
+		{name: "validation train field", task: Validation, body: `{"loss": 1, "accuracy": 0.5, "length": 10, "devices": 2}`,
+			strict: strict, wantField: "devices", wantError: true},
+		{name: "validation null accuracy", task: Validation, body: `{"loss": 1, "accuracy": null, "length": 10}`,
+			strict: loose, wantField: "accuracy", wantError: true},
+		{name: "validation nested class", task: Validation, body: `{"loss": 1, "accuracy": 0.5, "length": 10, "class_total_0": [4]}`,
+			strict: loose, wantField: "class_total_0", wantError: true},
+		{name: "validation truncated", task: Validation, body: `{"loss": 1, "accuracy": 0.5, "len`, strict: strict,
+			wantError: true},
+
+		{name: "canary", task: Canary, body: `{"loss": 1, "accuracy": 0.5, "length": 10}`, strict: strict,
+			want: map[string]float64{"loss": 1, "accuracy": 0.5, "length": 10}},
+		{name: "canary class field", task: Canary, body: `{"loss": 1, "accuracy": 0.5, "length": 10, "class_total_0": 4}`,
+			strict: strict, wantField: "class_total_0", wantError: true},
+		{name: "canary empty", task: Canary, body: ``, strict: loose,
+			wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &TrainJob{strictResponses: tt.strict}
+			metrics, err := job.parseFunctionResults(rawResponse(util.ContentTypeJSON, []byte(tt.body)), tt.task)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if err != nil {
+				// the error names the task, the field and the body of the function
+				e, ok := errors.Cause(err).(*api.ResponseError)
+				if !ok {
+					t.Fatalf("got error %v, want a response error", err)
+				}
+				if e.Task != string(tt.task) || e.Field != tt.wantField || e.Body != tt.body {
+					t.Errorf("got error of task %q field %q body %q, want %q %q %q",
+						e.Task, e.Field, e.Body, tt.task, tt.wantField, tt.body)
+				}
+				return
+			}
+			if !reflect.DeepEqual(metrics, tt.want) {
+				t.Errorf("got metrics %v, want %v", metrics, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFunctionResultsMsgpack(t *testing.T) {
+	// msgpack keeps the integers as such
+	body, contentType, err := util.Encode(api.SerializationMsgpack, map[string]interface{}{
+		"loss": float32(0.5), "accuracy": 1, "length": uint8(10),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := &TrainJob{strictResponses: true}
+	metrics, err := job.parseFunctionResults(rawResponse(contentType, body), Validation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]float64{"loss": 0.5, "accuracy": 1, "length": 10}; !reflect.DeepEqual(metrics, want) {
+		t.Errorf("got metrics %v, want %v", metrics, want)
+	}
+
+	// a truncated msgpack body fails to decode
+	_, err = job.parseFunctionResults(rawResponse(contentType, body[:len(body)-2]), Validation)
+	if e, ok := errors.Cause(err).(*api.ResponseError); !ok || len(e.Field) > 0 {
+		t.Errorf("got error %v, want a response error of the body", err)
+	}
+}
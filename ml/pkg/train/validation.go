@@ -0,0 +1,160 @@
+package train
+
+import (
+	"context"
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// pendingValidation is a validation started against the last intermediate
+// merge of an epoch, done is closed once its functions finished
+type pendingValidation struct {
+	merges  int
+	done    chan struct{}
+	results *validationResults
+	err     error
+}
+
+// validationDue returns whether the current epoch is validated inside
+// the training loop, the last epoch is validated after the loop
+func (job *TrainJob) validationDue() bool {
+	return job.validateEvery != 0 &&
+		job.epoch%job.validateEvery == 0 &&
+		job.epoch != job.task.Parameters.Epochs
+}
+
+// startLatestValidation starts validating the model of the last merge of the epoch
+// while the final merge is pending. The model lock is taken here and released once
+// the validation functions finish, so the final merge waits instead of replacing
+// the model they read. Returns nil if the epoch had no intermediate merges, in
+// which case the epoch is validated once its final merge is done
+func (job *TrainJob) startLatestValidation() *pendingValidation {
+	job.modelMu.Lock()
+	if job.merges == 0 {
+		job.modelMu.Unlock()
+		job.logger.Debug("No intermediate merges in the epoch, validating the final model")
+		return nil
+	}
+
+	pv := &pendingValidation{merges: job.merges, done: make(chan struct{})}
+	job.logger.Debug("Validating the latest merge of the epoch",
+		zap.Int("merges", pv.merges))
+
+	parallelism := job.parallelism
+	go func() {
+		defer close(pv.done)
+		defer job.modelMu.Unlock()
+		pv.results, pv.err = job.invokeValFunctions(context.Background(), parallelism)
+	}()
+	return pv
+}
+
+// finishValidation waits for a validation against the latest
+// merge and saves its results in the history
+func (job *TrainJob) finishValidation(pv *pendingValidation) error {
+	<-pv.done
+	if pv.err != nil {
+		return errors.Wrap(pv.err, "error during validation")
+	}
+	return job.recordValidation(pv.results, pv.merges)
+}
+
+// validateFinal validates the model after the training loop, where the stops are no
+// longer checked. A stop requested meanwhile cancels the validation functions still
+// running, so the job records the metrics of the ones that reported, if they reach
+// the quorum, and finishes instead of waiting for the rest
+func (job *TrainJob) validateFinal() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	watched := make(chan bool, 1)
+	go func() {
+		select {
+		case <-job.stopChan:
+			job.logger.Info("Stop requested during the final validation, interrupting it")
+			cancel()
+			watched <- true
+		case <-done:
+			watched <- false
+		}
+	}()
+
+	results, err := job.invokeValFunctions(ctx, job.parallelism)
+	close(done)
+	if <-watched {
+		job.exitErr = errors.New(api.ForceStoppedError)
+		if len(job.history.StoppedBy) == 0 {
+			job.history.StoppedBy = "stop requested"
+			switch {
+			case err != nil:
+				job.history.StoppedBy += ", the final validation was interrupted before enough functions reported"
+			case results.interrupted:
+				job.history.StoppedBy += fmt.Sprintf(", the final validation was interrupted with %d of %d functions reported",
+					results.responses, job.parallelism)
+			}
+		}
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "error during validation")
+	}
+	return job.recordValidation(results, job.merges)
+}
+
+// handleValidationError applies the validation failure policy of the job to a failed
+// validation. The retry policy runs the validation again with retry, against the final
+// model of the epoch, and the failures left are counted in the history. Returns the
+// error that stops the job, nil if it keeps training.
+//
+// A job that is already stopping is not retried, since its validation was interrupted,
+// and a goal or a history that does not match the scale of the accuracy always stops
+// the job
+func (job *TrainJob) handleValidationError(err error, retry func() error) error {
+	if cause := errors.Cause(err); cause == errGoalScale || cause == errMixedScale {
+		return err
+	}
+
+	opts := job.task.Parameters.Options
+	limit := opts.ValidationRetryLimit()
+	for attempt := 1; err != nil && attempt <= limit && job.exitErr == nil; attempt++ {
+		job.logger.Warn("Validation failed, retrying",
+			zap.Int("epoch", job.epoch),
+			zap.Int("attempt", attempt),
+			zap.Int("retries", limit),
+			zap.Error(err))
+		err = retry()
+	}
+	if err == nil {
+		return nil
+	}
+
+	job.history.ValidationFailures++
+	if opts.ValidationFailurePolicyOrDefault() == api.ValidationFailureContinue {
+		job.logger.Error("error performing validation, continuing without its metrics",
+			zap.Int("epoch", job.epoch),
+			zap.Error(err))
+		return nil
+	}
+	if limit > 0 {
+		return errors.Wrapf(err, "validation of epoch %d failed after %d retries", job.epoch, limit)
+	}
+	return errors.Wrapf(err, "validation of epoch %d failed", job.epoch)
+}
+
+// recordNoValidationData records a validation whose functions reported no evaluated
+// datapoints, as happens when the test split of the dataset is empty. The epoch is
+// kept in the history and sent as an event instead of an accuracy of 0, which would
+// skew the history and could even reach the goal of a job minimizing the accuracy
+func (job *TrainJob) recordNoValidationData(results *validationResults) {
+	job.logger.Warn("Validation functions evaluated no datapoints, skipping the validation metrics",
+		zap.Int("epoch", job.epoch),
+		zap.Int("responses", results.responses))
+
+	job.history.NoValidationData = append(job.history.NoValidationData, job.epoch)
+	job.notifyEvent(api.EventNoValidationData, map[string]float64{
+		"responses": float64(results.responses),
+	})
+}
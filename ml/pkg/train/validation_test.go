@@ -0,0 +1,99 @@
+package train
+
+import (
+	"context"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	psClient "github.com/diegostock12/kubeml/ml/pkg/ps/client"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowValidationInvoker answers the validations of the fast functions right away,
+// the rest run until they are cancelled or the slow duration passes
+func slowValidationInvoker(fast map[int]map[string]float64, slow time.Duration, reported *sync.WaitGroup) *fakeInvoker {
+	return &fakeInvoker{invoke: func(ctx context.Context, funcId int, task FunctionTask) (*http.Response, error) {
+		if m, exists := fast[funcId]; exists {
+			defer reported.Done()
+			return jsonResponse(m), nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(slow):
+			return jsonResponse(map[string]float64{"loss": 1, "accuracy": 10, "length": 100}), nil
+		}
+	}}
+}
+
+func TestValidateFinalStopped(t *testing.T) {
+	server := httptest.NewServer(&fakePS{})
+	defer server.Close()
+
+	fast := map[int]map[string]float64{
+		0: {"loss": 1, "accuracy": 90, "length": 100},
+		1: {"loss": 3, "accuracy": 70, "length": 100},
+	}
+	tests := []struct {
+		name      string
+		quorum    float64
+		accuracy  []float64
+		stoppedBy string
+	}{
+		{"partial results over the quorum", 0.5, []float64{80}, "interrupted with 2 of 4 functions reported"},
+		{"partial results under the quorum", 0, nil, "interrupted before enough functions reported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reported := &sync.WaitGroup{}
+			reported.Add(len(fast))
+			job := newInvokeTestJob(api.TrainOptions{ValidationQuorum: tt.quorum, ValidationFailurePolicy: api.ValidationFailureRetry},
+				slowValidationInvoker(fast, time.Minute, reported))
+			job.task.Parameters.Epochs = 1
+			job.epoch = 1
+			job.parallelism = 4
+			job.stopChan = make(chan struct{}, 1)
+			job.accuracyCh = make(chan struct{}, 1)
+			job.ps = psClient.MakeClient(zap.NewNop(), server.URL)
+
+			// the stop arrives once the fast functions reported
+			go func() {
+				reported.Wait()
+				job.stopChan <- struct{}{}
+			}()
+
+			// the job does not wait for the slow functions nor retries them
+			start := time.Now()
+			err := job.validateFinal()
+			retried := false
+			job.handleValidationError(err, func() error {
+				retried = true
+				return nil
+			})
+			if elapsed := time.Since(start); elapsed > 5*time.Second || retried {
+				t.Fatalf("got the validation finished after %v and retried %v, want it interrupted", elapsed, retried)
+			}
+
+			if job.exitErr == nil || job.exitErr.Error() != api.ForceStoppedError {
+				t.Errorf("got exit error %v, want the job stopped", job.exitErr)
+			}
+			if !strings.Contains(job.history.StoppedBy, tt.stoppedBy) {
+				t.Errorf("got stopped by %q, want it to contain %q", job.history.StoppedBy, tt.stoppedBy)
+			}
+			if (err != nil) != (tt.accuracy == nil) {
+				t.Errorf("got error %v, want one only if the results are not recorded", err)
+			}
+
+			// only the results that reach the quorum are the accuracy of the epoch
+			if !reflect.DeepEqual(job.history.Accuracy, tt.accuracy) {
+				t.Errorf("got accuracies %v, want %v", job.history.Accuracy, tt.accuracy)
+			}
+		})
+	}
+}
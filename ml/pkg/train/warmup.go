@@ -0,0 +1,79 @@
+package train
+
+import (
+	"context"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	kerror "github.com/diegostock12/kubeml/ml/pkg/error"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// warmUpTimeout is the longest the job waits for the warm-up invocations,
+// the ones still running after it are abandoned and training starts
+const warmUpTimeout = 5 * time.Minute
+
+// warmUp invokes as many functions as the first epoch trains with, at the same time so
+// fission starts a pod for each, before the start time of the job is taken. The
+// functions answer without training, and the warm-up never fails the job, it only
+// leaves the cold starts in the first epoch. Functions built with an older version
+// of the library do not know the task, which also starts their pods
+func (job *TrainJob) warmUp() {
+	n := job.parallelism
+	job.logger.Info("Warming up the functions", zap.Int("functions", n))
+
+	ctx, cancel := context.WithTimeout(context.Background(), warmUpTimeout)
+	defer cancel()
+
+	start := time.Now()
+	report := &api.WarmUp{Functions: n}
+	var mu sync.Mutex
+	wg := &sync.WaitGroup{}
+
+	leases := job.acquireInvocations(n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		funcUrl := job.buildFunctionURL(FunctionArgs{Id: i, Num: n}, WarmUp)
+		go func(i int) {
+			defer wg.Done()
+			defer job.releaseInvocation(leases, i)
+
+			err := job.invokeWarmUp(ctx, i, funcUrl)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				report.Ready++
+			case isUnknownTask(err):
+				report.Ready++
+				report.Skipped = true
+			default:
+				report.Error = err.Error()
+				job.logger.Warn("Warm-up invocation failed",
+					zap.Int("funcId", i),
+					zap.Error(err))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	report.Elapsed = time.Since(start).Seconds()
+	job.history.WarmUp = report
+	job.logger.Info("Functions warmed up",
+		zap.Int("ready", report.Ready),
+		zap.Int("functions", n),
+		zap.Float64("elapsed", report.Elapsed))
+}
+
+// invokeWarmUp sends a warm-up invocation and checks that the function answered
+func (job *TrainJob) invokeWarmUp(ctx context.Context, funcId int, funcUrl string) error {
+	resp, err := job.invoker.Invoke(ctx, funcId, WarmUp, funcUrl)
+	if err != nil {
+		return err
+	}
+	if err = kerror.CheckFunctionError(resp); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,110 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v4"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+// Content types of the payloads exchanged with the functions
+const (
+	ContentTypeJSON    = "application/json"
+	ContentTypeMsgpack = "application/msgpack"
+
+	// ContentTypeNDJSON is used for the streamed
+	// predictions with one prediction per line
+	ContentTypeNDJSON = "application/x-ndjson"
+)
+
+// IsValidSerialization returns whether the serialization format is supported,
+// an empty format defaults to JSON
+func IsValidSerialization(serialization string) bool {
+	switch serialization {
+	case "", api.SerializationJSON, api.SerializationMsgpack:
+		return true
+	}
+	return false
+}
+
+// ContentType returns the content type used to send payloads in
+// the given serialization format
+func ContentType(serialization string) string {
+	if serialization == api.SerializationMsgpack {
+		return ContentTypeMsgpack
+	}
+	return ContentTypeJSON
+}
+
+// Encode serializes v in the given format and returns the
+// encoded payload along with its content type.
+//
+// The json tags of the structs are also used for msgpack so both
+// formats produce the same field names
+func Encode(serialization string, v interface{}) ([]byte, string, error) {
+	if serialization != api.SerializationMsgpack {
+		body, err := json.Marshal(v)
+		return body, ContentTypeJSON, err
+	}
+
+	var buf bytes.Buffer
+	err := msgpack.NewEncoder(&buf).UseJSONTag(true).Encode(v)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "could not encode msgpack payload")
+	}
+	return buf.Bytes(), ContentTypeMsgpack, nil
+}
+
+// Decode deserializes the payload according to its content type,
+// anything that is not msgpack is decoded as JSON
+func Decode(contentType string, body []byte, v interface{}) error {
+	if !IsMsgpack(contentType) {
+		return json.Unmarshal(body, v)
+	}
+
+	err := msgpack.NewDecoder(bytes.NewReader(body)).UseJSONTag(true).Decode(v)
+	if err != nil {
+		return errors.Wrap(err, "could not decode msgpack payload")
+	}
+	return nil
+}
+
+// DecodeStrict decodes the payload like Decode, but fails if it has fields
+// that are not part of v, which allows checking versioned responses
+func DecodeStrict(contentType string, body []byte, v interface{}) error {
+	if !IsMsgpack(contentType) {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		return dec.Decode(v)
+	}
+
+	dec := msgpack.NewDecoder(bytes.NewReader(body)).UseJSONTag(true)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errors.Wrap(err, "could not decode msgpack payload")
+	}
+	return nil
+}
+
+// DecodeResponse reads and closes the body of the response and decodes it
+// according to the content type returned. This allows functions that do not
+// support msgpack to keep answering with JSON
+func DecodeResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "could not read response body")
+	}
+
+	return Decode(resp.Header.Get("Content-Type"), body, v)
+}
+
+// IsMsgpack returns whether the content type is msgpack
+func IsMsgpack(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == ContentTypeMsgpack
+}
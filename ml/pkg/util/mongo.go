@@ -0,0 +1,36 @@
+package util
+
+import (
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"os"
+	"strings"
+)
+
+// Environment variables with the names used in MongoDB, so several
+// deployments of kubeml can share the same MongoDB without colliding
+const (
+	MongoDatabaseEnv          = "MONGO_DATABASE"
+	MongoHistoryCollectionEnv = "MONGO_HISTORY_COLLECTION"
+)
+
+// MongoDatabase returns the database of the kubeml collections
+func MongoDatabase() string {
+	return envOrDefault(MongoDatabaseEnv, api.DefaultMongoDatabase)
+}
+
+// HistoryCollection returns the collection of the histories of the jobs
+func HistoryCollection() string {
+	return envOrDefault(MongoHistoryCollectionEnv, api.DefaultHistoryCollection)
+}
+
+// ValidateMongoNames checks that the names set in the environment are not empty,
+// so a misconfigured component fails when it starts instead of writing elsewhere
+func ValidateMongoNames() error {
+	for _, name := range []string{MongoDatabaseEnv, MongoHistoryCollectionEnv} {
+		if value, set := os.LookupEnv(name); set && len(strings.TrimSpace(value)) == 0 {
+			return fmt.Errorf("%s is set but empty", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"fmt"
+	"github.com/diegostock12/kubeml/ml/pkg/api"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultRouterService and defaultFissionNamespace are the name and
+	// namespace of the service of the fission router in a default install
+	defaultRouterService    = "router"
+	defaultFissionNamespace = "fission"
+)
+
+var (
+	routerOnce sync.Once
+	routerUrl  string
+)
+
+// RouterUrl returns the address of the fission router the functions are invoked
+// through, discovered once per process, see discoverRouter
+func RouterUrl() string {
+	routerOnce.Do(func() {
+		routerUrl = discoverRouter()
+	})
+	return routerUrl
+}
+
+// discoverRouter finds the address of the fission router. FISSION_ROUTER_URL is used
+// if set, otherwise the router service is looked up in the cluster, named by
+// FISSION_ROUTER_SERVICE and FISSION_NAMESPACE (router and fission by default).
+// If the service can not be resolved, like when running out of the cluster in
+// the debug environment, the debug address is used
+func discoverRouter() string {
+	if u := strings.TrimSpace(os.Getenv("FISSION_ROUTER_URL")); len(u) > 0 {
+		return strings.TrimSuffix(u, "/")
+	}
+	if IsDebugEnv() {
+		return api.FissionRouterUrlDebug
+	}
+
+	service := envOrDefault("FISSION_ROUTER_SERVICE", defaultRouterService)
+	namespace := envOrDefault("FISSION_NAMESPACE", defaultFissionNamespace)
+	host := fmt.Sprintf("%s.%s", service, namespace)
+	if _, err := net.LookupHost(host); err == nil {
+		return "http://" + host
+	}
+	return api.FissionRouterUrlDebug
+}
+
+// envOrDefault returns the value of the environment variable, or the default if it is not set
+func envOrDefault(env, defaultName string) string {
+	if value := strings.TrimSpace(os.Getenv(env)); len(value) > 0 {
+		return value
+	}
+	return defaultName
+}
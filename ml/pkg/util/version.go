@@ -0,0 +1,24 @@
+package util
+
+import "os"
+
+// Build information of the binary, set when building the image with
+//
+//	-ldflags "-X github.com/diegostock12/kubeml/ml/pkg/util.Commit=..."
+var (
+	Version   = ""
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// GetVersion returns the version the binary was built with,
+// or the version of the image set in the environment
+func GetVersion() string {
+	if len(Version) > 0 {
+		return Version
+	}
+	if v := os.Getenv("KUBEML_VERSION"); len(v) > 0 {
+		return v
+	}
+	return "latest"
+}
@@ -1,9 +1,12 @@
 import pickle
 from abc import ABC, abstractmethod
+from typing import List, Tuple
 
 import numpy as np
+import torch
 import torch.utils.data as data
 from flask import request
+from werkzeug.datastructures import MultiDict
 from pymongo import MongoClient
 from pymongo.errors import PyMongoError
 
@@ -34,6 +37,23 @@ class _KubeArgs:
                  epoch: int,
                  lr: float = 0,
                  batch_size: int = 0,
+                 canary_size: int = 0,
+                 scratch_dir: str = None,
+                 mean: List[float] = None,
+                 std: List[float] = None,
+                 seed: int = None,
+                 metrics: List[str] = None,
+                 callback: str = None,
+                 accept: str = None,
+                 token: str = None,
+                 export_format: str = None,
+                 shares: List[float] = None,
+                 probe: bool = False,
+                 class_weights: List[float] = None,
+                 sync_groups: List[Tuple[str, int]] = None,
+                 layer_k: int = None,
+                 devices: int = 1,
+                 topology: str = None,
                  ):
         """
         :arg job_id: id of the job\n
@@ -43,6 +63,27 @@ class _KubeArgs:
         :arg func_id: id of the function
         :arg lr: learning rate
         :arg batch_size: size of the batch
+        :arg canary_size: number of datapoints of the canary validation batch
+        :arg scratch_dir: path of the scratch volume of the job, None if not requested
+        :arg mean: per-channel mean of the dataset, None if not set in the request
+        :arg std: per-channel standard deviation of the dataset, None if not set in the request
+        :arg seed: seed used to shuffle the training data of the function, None to train in order
+        :arg metrics: optional metrics computed during validation, like per_class
+        :arg callback: url the response is posted to if the function was invoked through the queue
+        :arg accept: content type of the response posted to the callback
+        :arg token: token of the train invocation sent back in the finish notifications
+        :arg export_format: format the model is exported to in the export task
+        :arg shares: fraction of the train data of each function when the job balances it by their capacity,
+        None to split it evenly
+        :arg probe: whether the sanity check takes a second step to probe the loss after an update
+        :arg class_weights: weight of each class in the loss, None if the job does not use class weights
+        :arg sync_groups: pattern and K of the layer groups synced with their own cadence, in order, None if
+        the whole model is synced every K
+        :arg layer_k: K of the layers that match none of the sync groups, K being the smallest K of all the
+        groups when the job sets them
+        :arg devices: number of GPUs the function trains on, the batch size being the batch of each of them
+        :arg topology: sync topology of the job, allreduce if the functions average the layers themselves,
+        None if the job merges them
         """
 
         self._job_id = job_id
@@ -53,6 +94,23 @@ class _KubeArgs:
         self.lr = lr
         self.batch_size = batch_size
         self.epoch = epoch
+        self.canary_size = canary_size
+        self.scratch_dir = scratch_dir
+        self.mean = mean
+        self.std = std
+        self.seed = seed
+        self.metrics = metrics or []
+        self.callback = callback
+        self.accept = accept
+        self.token = token
+        self.export_format = export_format
+        self.shares = shares
+        self.probe = probe
+        self.class_weights = class_weights
+        self.sync_groups = sync_groups
+        self.layer_k = layer_k
+        self.devices = devices
+        self.topology = topology
 
     @classmethod
     def parse(cls):
@@ -60,23 +118,66 @@ class _KubeArgs:
         Parses the arguments from the request context
         :return: returns a KubeArgs object used by other methods
         """
+        # invocations sent through the queue carry the arguments in the
+        # message delivered as the body instead of the query of the request
+        args, callback, accept = request.args, None, None
+        message = request.get_json(silent=True) if request.method == "POST" else None
+        if isinstance(message, dict) and "callback" in message:
+            args = MultiDict(message.get("args", {}))
+            callback, accept = message["callback"], message.get("accept")
+
         try:
-            job_id = request.args.get("jobId")
-            N = request.args.get("N", type=int)
-            K = request.args.get("K", type=int)
-            task = request.args.get("task")
-            func_id = request.args.get("funcId", type=int)
-            lr = request.args.get("lr", type=float)
-            batch_size = request.args.get("batchSize", type=int)
-            epoch = request.args.get("epoch", type=int)
+            job_id = args.get("jobId")
+            N = args.get("N", type=int)
+            K = args.get("K", type=int)
+            task = args.get("task")
+            func_id = args.get("funcId", type=int)
+            lr = args.get("lr", type=float)
+            batch_size = args.get("batchSize", type=int)
+            epoch = args.get("epoch", type=int)
+            canary_size = args.get("canarySize", default=0, type=int)
+            scratch_dir = args.get("scratchDir")
+            mean = args.get("mean", type=cls._parse_floats)
+            std = args.get("std", type=cls._parse_floats)
+            seed = args.get("seed", type=int)
+            metrics = args.get("metrics", type=lambda s: s.split(','))
+            token = args.get("token")
+            export_format = args.get("format")
+            shares = args.get("shares", type=cls._parse_floats)
+            probe = args.get("probe", default=False, type=lambda s: s.lower() == "true")
+            class_weights = args.get("classWeights", type=cls._parse_floats)
+            sync_groups = args.get("syncGroups", type=cls._parse_sync_groups)
+            layer_k = args.get("layerK", type=int)
+            devices = args.get("devices", default=1, type=int)
+            topology = args.get("topology")
 
         except ValueError as ve:
-            logging.error(f"Error parsing request arguments: {ve}, args:{request.args}")
+            logging.error(f"Error parsing request arguments: {ve}, args:{args}")
             raise InvalidArgsError(ve)
 
-        args = cls(job_id, N, K, task, func_id, epoch, lr, batch_size)
+        args = cls(job_id, N, K, task, func_id, epoch, lr, batch_size, canary_size, scratch_dir, mean, std, seed,
+                   metrics, callback, accept, token, export_format, shares, probe,
+                   class_weights, sync_groups, layer_k, devices, topology)
         return args
 
+    @staticmethod
+    def _parse_floats(s: str) -> List[float]:
+        """
+        Parses a list of comma separated floats
+        """
+        return [float(v) for v in s.split(',')]
+
+    @staticmethod
+    def _parse_sync_groups(s: str) -> List[Tuple[str, int]]:
+        """
+        Parses a list of comma separated pattern:K layer groups
+        """
+        groups = []
+        for group in s.split(','):
+            pattern, k = group.rsplit(':', 1)
+            groups.append((pattern, int(k)))
+        return groups
+
 
 class KubeDataset(data.Dataset, ABC):
     """
@@ -104,6 +205,10 @@ class KubeDataset(data.Dataset, ABC):
         # data and labels of the dataset
         self.data, self.labels = None, None
 
+        # per-channel normalization stats sent in the train request,
+        # set by the KubeModel before loading the data
+        self.mean, self.std = None, None
+
         # Check first if the dataset that the user gave as input
         # is available in the configured storage service
         try:
@@ -147,6 +252,29 @@ class KubeDataset(data.Dataset, ABC):
         """
         return self._mode == 'train'
 
+    def normalize(self, x: torch.Tensor) -> torch.Tensor:
+        """
+        Normalizes a datapoint with the per-channel mean and std given in the train request,
+        so the same function can be used with datasets with different stats. The channels must
+        be the first dimension of the datapoint, like in the tensors returned by ToTensor.
+
+        If the request did not set the stats the datapoint is returned unchanged
+
+        :param x: tensor of the datapoint
+        :return: the normalized tensor
+        """
+        if self.mean is None:
+            return x
+
+        if x.shape[0] != len(self.mean):
+            raise InvalidArgsError(ValueError(f"normalization stats have {len(self.mean)} "
+                                              f"channels but the data has {x.shape[0]}"))
+
+        shape = (-1,) + (1,) * (x.dim() - 1)
+        mean = torch.as_tensor(self.mean, dtype=x.dtype, device=x.device).view(shape)
+        std = torch.as_tensor(self.std, dtype=x.dtype, device=x.device).view(shape)
+        return (x - mean) / std
+
     def _load_train_data(self, start: int, end: int):
         """
         For K averaging the data needs to be refreshed with the next K batches
@@ -18,6 +18,12 @@ class MergeError(KubeMLException):
             .__init__(f"Error merging model: {e}", 500)
 
 
+class StaleIterationError(KubeMLException):
+    def __init__(self, message: str):
+        super(StaleIterationError, self) \
+            .__init__(f"The job discarded the update of the function: {message}", 409)
+
+
 class DataError(KubeMLException):
     def __init__(self):
         super(DataError, self) \
@@ -30,6 +36,30 @@ class InvalidFormatError(KubeMLException):
             .__init__("The data provided is not in an appropriate format", 400)
 
 
+class InvalidOutputTypeError(KubeMLException):
+    def __init__(self, output_type: str):
+        super(InvalidOutputTypeError, self) \
+            .__init__(f"Unknown output type {output_type}", 400)
+
+
+class UnsupportedOutputError(KubeMLException):
+    def __init__(self, output_type: str):
+        super(UnsupportedOutputError, self) \
+            .__init__(f"The function does not implement the {output_type} output", 501)
+
+
+class UnsupportedOperationError(KubeMLException):
+    def __init__(self, operation: str):
+        super(UnsupportedOperationError, self) \
+            .__init__(f"The function does not implement {operation}", 501)
+
+
+class UnsupportedMediaTypeError(KubeMLException):
+    def __init__(self, mimetype: str):
+        super(UnsupportedMediaTypeError, self) \
+            .__init__(f"Content type {mimetype} is not supported by the function", 415)
+
+
 class StorageError(KubeMLException):
     def __init__(self, e: Exception):
         super(StorageError, self) \
@@ -46,3 +76,9 @@ class InvalidArgsError(KubeMLException):
     def __init__(self, e: Exception):
         super(InvalidArgsError, self) \
             .__init__(f"Error parsing function arguments: {str(e)}", 500)
+
+
+class SanityCheckError(KubeMLException):
+    def __init__(self, message: str):
+        super(SanityCheckError, self) \
+            .__init__(f"Sanity check failed: {message}", 422)
@@ -1,6 +1,10 @@
+import hashlib
+import io
+import time
 from abc import ABC
 from collections import defaultdict
-from typing import Dict, Tuple, Any, Union, Callable, Iterable, Sequence
+from fnmatch import fnmatchcase
+from typing import Dict, Tuple, Any, Union, Callable, Iterable, Sequence, Optional
 
 import flask
 import numpy as np
@@ -25,9 +29,63 @@ except KeyError:
     REDIS_URL = "redisai.kubeml"
     REDIS_PORT = 6379
 
+# the exported models are uploaded to the storage service
+STORAGE_URL = os.environ.get('STORAGE_URL', "http://storage.kubeml")
+
+# msgpack is optional, if it is not installed the function
+# exchanges the payloads with the job in JSON
+try:
+    import msgpack
+except ImportError:
+    msgpack = None
+
+MSGPACK_MIMETYPE = "application/msgpack"
+
+# optional metric that counts the predictions of each class during validation
+PER_CLASS_METRICS = "per_class"
+
+# representations an inference can return for each datapoint
+OUTPUT_PREDICTION = "prediction"
+OUTPUT_LOGITS = "logits"
+OUTPUT_EMBEDDING = "embedding"
+
+# capabilities reported to the job in the init response, and
+# the formats the model can be exported to
+HEADER_CAPABILITIES = "X-Kubeml-Capabilities"
+CAPABILITY_TRAIN = "train"
+CAPABILITY_EXPORT = "export"
+CAPABILITY_TORCHSCRIPT = "torchscript"
+CAPABILITY_ALLREDUCE = "allreduce"
+
+# scales of the accuracy returned by validate, declared to the job in the
+# capabilities. The job detects it from the first validation if not declared
+ACCURACY_FRACTION = "fraction"
+ACCURACY_PERCENT = "percent"
+EXPORT_ONNX = "onnx"
+EXPORT_TORCHSCRIPT = "torchscript"
+
+# version of the schema of the responses, reported to the job in the init
+# response. The job checks the responses against it and rejects unknown fields
+HEADER_RESPONSE_SCHEMA = "X-Kubeml-Response-Schema"
+RESPONSE_SCHEMA_VERSION = 1
+
+# capacity reported to the job after training, used to balance the data and
+# batch of the functions if the job enables it. It is the datapoints trained
+# per second unless set in the environment of the function, which allows
+# giving a fixed relative capacity to the pods with more resources
+try:
+    CAPACITY = float(os.environ['KUBEML_CAPACITY'])
+except (KeyError, ValueError):
+    CAPACITY = None
+
 
 class KubeModel(ABC):
 
+    # scale of the accuracy returned by validate, ACCURACY_FRACTION (0-1)
+    # or ACCURACY_PERCENT (0-100). Subclasses set it so the job does not
+    # have to detect it from the first validation
+    accuracy_scale: Optional[str] = None
+
     def __init__(self, network: nn.Module, dataset: KubeDataset, gpu=False):
         """Init the KubeModel, device can be either gpu or cpu"""
 
@@ -37,6 +95,12 @@ class KubeModel(ABC):
         self._dataset = dataset
         self.platform = 'gpu' if gpu else 'cpu'
         self.device = None
+
+        # the network replicated on the gpus of the function when the job asks
+        # to train on several devices, None if it runs on a single one. The
+        # state dict is always the one of the network itself
+        self._parallel = None
+        self.devices = 1
         self.args = None
         self.logger = None
 
@@ -48,6 +112,16 @@ class KubeModel(ABC):
         self.optimizer = None
         self.epoch = None
 
+        # path to the scratch volume of the job, None if the
+        # train request did not ask for one
+        self.scratch_dir = None
+
+        # layers loaded at the start and saved at the end of the
+        # iteration, None for all of them. The jobs that sync some
+        # layer groups with their own K only exchange the due groups
+        self._load_layers = None
+        self._save_layers = None
+
         # initialize redis connection
         self._redis_client = rai.Client(host=REDIS_URL, port=REDIS_PORT)
 
@@ -65,6 +139,8 @@ class KubeModel(ABC):
         :param kwargs:
         :return: the output of the network
         """
+        if self._parallel is not None:
+            return self._parallel(*args, **kwargs)
         return self._network(*args, **kwargs)
 
     # Functions that act like a proxy to the same
@@ -88,6 +164,20 @@ class KubeModel(ABC):
         self._network.apply(fn)
         return self
 
+    @property
+    def class_weights(self) -> Optional[torch.Tensor]:
+        """
+        Weight of each class of the dataset to use in the loss, for example
+        F.cross_entropy(output, y, weight=self.class_weights). The weights are
+        either set for the dataset or computed from its class distribution
+
+        :return: tensor with the weights on the device of the model, None if
+        the job does not use class weights
+        """
+        if self.args is None or not self.args.class_weights:
+            return None
+        return torch.tensor(self.args.class_weights, dtype=torch.float32, device=self.device)
+
     def _read_args(self):
         """Parse the args and update the parameters"""
         self.args = _KubeArgs.parse()
@@ -95,6 +185,9 @@ class KubeModel(ABC):
         self.batch_size = self.args.batch_size
         self.task = self.args._task
         self.epoch = self.args.epoch
+        self.scratch_dir = self.args.scratch_dir
+        self._dataset.mean = self.args.mean
+        self._dataset.std = self.args.std
 
     def _config_optimizer(self):
         """
@@ -145,32 +238,88 @@ class KubeModel(ABC):
 
     def start(self) -> Tuple[flask.Response, int]:
         """
-        Start executes the function invoked by the user
+        Start executes the function invoked by the user. If the function was invoked
+        through the queue, the response is also posted to the job, including the errors
         """
         # parse arguments and
         self._read_args()
         self._get_logger()
 
+        if self.args.callback is None:
+            return self.__run()
+
+        try:
+            response, code = self.__run()
+        except KubeMLException as e:
+            response, code = jsonify(e.to_dict()), e.status_code
+        except Exception as e:
+            response, code = jsonify(error=f"{type(e).__name__}: {e}", code=500), 500
+
+        self.__post_result(response, code)
+        return response, code
+
+    def __run(self) -> Tuple[flask.Response, int]:
+        """
+        Runs the task of the invocation
+        """
         if self.task == "init":
             layers = self.__initialize()
-            return jsonify(layers), 200
+            response = self._respond(layers)
+            response.headers[HEADER_CAPABILITIES] = ",".join(self.__capabilities())
+            response.headers[HEADER_RESPONSE_SCHEMA] = str(RESPONSE_SCHEMA_VERSION)
+            return response, 200
 
         elif self.task == "train":
-            loss = self.__train()
-            return jsonify(loss=loss), 200
+            loss, capacity, length = self.__train()
+            # the devices are only reported to the jobs that asked for several
+            if self.args.devices > 1:
+                return self._respond(loss=loss, capacity=capacity, length=length, devices=self.devices), 200
+            return self._respond(loss=loss, capacity=capacity, length=length), 200
+
+        elif self.task == "sanity":
+            report = self.__sanity()
+            return self._respond(**report), 200
+
+        elif self.task == "warmup":
+            # the job only invokes it to start the pod before training
+            self._redis_client.close()
+            return self._respond(ready=True), 200
 
         elif self.task == "val":
-            acc, loss, length = self.__validate()
-            return jsonify(loss=loss, accuracy=acc, length=length), 200
+            acc, loss, length, classes = self.__validate()
+            return self._respond(loss=loss, accuracy=acc, length=length, **classes), 200
+
+        elif self.task == "canary":
+            acc, loss, length, _ = self.__validate(canary=True)
+            return self._respond(loss=loss, accuracy=acc, length=length), 200
+
+        elif self.task == "export":
+            artifact = self.__export()
+            return self._respond(**artifact), 200
 
         elif self.task == "infer":
-            preds = self.__infer()
-            return jsonify(predictions=preds), 200
+            preds, output_type, failed = self.__infer()
+            if failed is None:
+                return self._respond(predictions=preds, output_type=output_type), 200
+            return self._respond(predictions=preds, output_type=output_type, failed=failed), 200
 
         else:
             self._redis_client.close()
             raise KubeMLException(f"Task {self.task} not recognized", 400)
 
+    def _respond(self, *args, **kwargs) -> flask.Response:
+        """
+        Builds the response of the function, it is serialized with msgpack if the
+        caller accepts it and the library is installed, and with JSON otherwise
+        """
+        accept = self.args.accept or request.headers.get("Accept", "")
+        if msgpack is None or MSGPACK_MIMETYPE not in accept:
+            return jsonify(*args, **kwargs)
+
+        payload = args[0] if args else kwargs
+        return current_app.response_class(msgpack.packb(payload, use_bin_type=True),
+                                          mimetype=MSGPACK_MIMETYPE)
+
     def __initialize(self) -> List[str]:
         """
         Initializes the network
@@ -188,6 +337,105 @@ class KubeModel(ABC):
 
         return [name for name in self._network.state_dict()]
 
+    def __capabilities(self) -> List[str]:
+        """
+        Returns the operations the function implements besides training, which
+        are the optional methods overridden by the subclass, and the scale of the
+        accuracy if the subclass declares it
+        """
+        capabilities = [CAPABILITY_TRAIN, CAPABILITY_TORCHSCRIPT, CAPABILITY_ALLREDUCE]
+        if type(self).export_sample is not KubeModel.export_sample:
+            capabilities.append(CAPABILITY_EXPORT)
+        if self.accuracy_scale in (ACCURACY_FRACTION, ACCURACY_PERCENT):
+            capabilities.append(f"accuracy_{self.accuracy_scale}")
+        return capabilities
+
+    def __export(self) -> Dict[str, Any]:
+        """
+        Exports the reference model to ONNX, tracing the network with the sample input returned
+        by export_sample, or to TorchScript, and uploads the artifact to the storage service
+
+        :return: the size and sha256 of the artifact
+        """
+        export_format = self.args.export_format or EXPORT_ONNX
+        if export_format not in (EXPORT_ONNX, EXPORT_TORCHSCRIPT):
+            raise KubeMLException(f"Export format {export_format} not supported", 400)
+
+        # the sample is only needed to script the networks that have to be traced
+        if export_format == EXPORT_ONNX:
+            sample = self.export_sample()
+        else:
+            try:
+                sample = self.export_sample()
+            except UnsupportedOperationError:
+                sample = None
+
+        try:
+            self.__load_model()
+        except RedisError as re:
+            raise StorageError(re)
+        finally:
+            self._redis_client.close()
+
+        self._network.eval()
+        if export_format == EXPORT_TORCHSCRIPT:
+            artifact = self.__script(sample)
+        else:
+            buffer = io.BytesIO()
+            with torch.no_grad():
+                torch.onnx.export(self._network, sample, buffer)
+            artifact = buffer.getvalue()
+        checksum = hashlib.sha256(artifact).hexdigest()
+        self.logger.debug(f"Exported model to {export_format}, {len(artifact)} bytes")
+
+        url = f"{STORAGE_URL}/artifacts/{self.args._job_id}/{export_format}"
+        try:
+            resp = requests.put(url, data=artifact, headers={"Content-Type": "application/octet-stream",
+                                                             "X-Kubeml-Checksum": checksum})
+        except requests.ConnectionError as e:
+            raise StorageError(e)
+        if not resp.ok:
+            raise KubeMLException(f"Storage service did not accept the artifact: {resp.content.decode()}", 500)
+
+        return dict(size=len(artifact), sha256=checksum)
+
+    def __script(self, sample: Optional[Union[torch.Tensor, Tuple[torch.Tensor, ...]]]) -> bytes:
+        """
+        Converts the network to TorchScript, scripting it or tracing it with the sample if
+        it can not be scripted. The artifact is loaded back before it is returned and, given
+        a sample, it must give the same output as the network
+
+        :return: the serialized TorchScript module
+        """
+        try:
+            module = torch.jit.script(self._network)
+        except Exception as e:
+            if sample is None:
+                raise KubeMLException(f"The network can not be scripted and the function does not "
+                                      f"implement export_sample to trace it: {type(e).__name__}: {e}", 501)
+            self.logger.debug(f"Could not script the network, tracing it: {e}")
+            with torch.no_grad():
+                module = torch.jit.trace(self._network, sample)
+
+        buffer = io.BytesIO()
+        torch.jit.save(module, buffer)
+        artifact = buffer.getvalue()
+
+        try:
+            loaded = torch.jit.load(io.BytesIO(artifact), map_location="cpu")
+        except Exception as e:
+            raise KubeMLException(f"The TorchScript artifact does not load: {type(e).__name__}: {e}", 500)
+
+        if sample is not None:
+            inputs = sample if isinstance(sample, tuple) else (sample,)
+            with torch.no_grad():
+                expected = self._network.cpu()(*inputs)
+                got = loaded(*inputs)
+            if isinstance(expected, torch.Tensor) and not torch.allclose(expected, got, rtol=1e-4, atol=1e-5):
+                raise KubeMLException("The TorchScript artifact does not match the output of the network", 500)
+
+        return artifact
+
     def _on_train_start(self):
         """
         Prepares the network for training
@@ -213,7 +461,7 @@ class KubeModel(ABC):
         reset optimizer state
         :return:
         """
-        self.__load_model()
+        self.__load_model(self._load_layers)
         self._reset_optimizer_state()
 
     def _on_iteration_end(self):
@@ -221,7 +469,7 @@ class KubeModel(ABC):
         Called at the end of each iteration
         :return:
         """
-        self.__save_model()
+        self.__save_model(self._save_layers)
 
     def _batch_to_device(self, batch: Union[torch.Tensor, Iterable[torch.Tensor]]):
         """
@@ -249,24 +497,31 @@ class KubeModel(ABC):
         else:
             return batch
 
-    def __train(self) -> float:
+    def __train(self) -> Tuple[float, float, int]:
         """
         Function called to train the network. Loads the reference model from the database,
         trains with the method provided by the user and saves the model after training to the database
 
-        :return: The loss of the epoch, as returned by the user function
+        :return: The loss of the epoch, as returned by the user function, the capacity of the function
+            and the number of datapoints it trained on, used by the job to weight its loss
         """
 
         self._on_train_start()
 
-        # Determine the batches that we need to train on and the first subset id
-        assigned_subsets = split_minibatches(range(self._dataset.num_docs),
-                                             self.args._N)[self.args._func_id]
+        # Determine the batches that we need to train on and the first subset id,
+        # if the job balances the data by capacity it sends the share of each function
+        if self.args.shares is not None and len(self.args.shares) == self.args._N:
+            assigned_subsets = split_by_shares(range(self._dataset.num_docs),
+                                               self.args.shares)[self.args._func_id]
+        else:
+            assigned_subsets = split_minibatches(range(self._dataset.num_docs),
+                                                 self.args._N)[self.args._func_id]
 
         # calculate the number of subsets that we need to train on
-        # per epoch
+        # per epoch, each step taking the batch on every device
+        step_batch = self.batch_size * self.devices
         subsets_per_iter = get_subset_period(self.args._K,
-                                             self.args.batch_size,
+                                             step_batch,
                                              assigned_subsets)
         self.logger.debug(f"Subsets per iteration: {subsets_per_iter}")
         intervals = range(assigned_subsets.start, assigned_subsets.stop, subsets_per_iter)
@@ -275,17 +530,44 @@ class KubeModel(ABC):
         # will determine the number of losses added.
         loss = 0
         num_iterations = 0
+
+        # datapoints trained and the time spent training them,
+        # excluding the waits for the merges of the job
+        datapoints, elapsed = 0, 0.0
+
+        # number of merges the function took part in, sent
+        # to the job along with the epoch in each notification
+        merges = 0
+
+        # if the job sets a seed the data of each interval is shuffled with it,
+        # so the order can be derived again from the seed for auditing
+        generator = None
+        if self.args.seed is not None:
+            generator = torch.Generator()
+            generator.manual_seed(self.args.seed)
+
+        # the layers grouped by their sync cadence, None if the job syncs the whole model
+        groups = self.__layer_groups()
+        self._load_layers, self._save_layers = None, None
+
         for i in intervals:
 
             self.logger.debug(f"Starting iteration {i}")
             self._dataset._load_train_data(start=i, end=min(assigned_subsets.stop, i + subsets_per_iter))
 
             # create the loader that will be used
-            loader = DataLoader(self._dataset, batch_size=self.batch_size)
+            loader = DataLoader(self._dataset, batch_size=step_batch,
+                                shuffle=generator is not None, generator=generator)
             num_iterations += len(loader)
 
+            # the last iteration saves all the layers, since the
+            # job merges all of them once the functions finish
+            if groups is not None:
+                self._save_layers = None if i == intervals[-1] else self.__due_layers(groups, merges)
+
             # load the reference model, train and save
             try:
+                start = time.monotonic()
                 self._on_iteration_start()
 
                 for idx, batch in enumerate(loader):
@@ -295,6 +577,8 @@ class KubeModel(ABC):
                     self.logger.debug(f'loss is {loss}, iterations are {num_iterations}')
 
                 self._on_iteration_end()
+                datapoints += len(loader.dataset)
+                elapsed += time.monotonic() - start
             except RedisError as re:
                 raise StorageError(re)
             finally:
@@ -303,11 +587,87 @@ class KubeModel(ABC):
             # send notification to the train job to refresh the model if not
             # the last interval
             if i != intervals[-1]:
-                self.__send_finish_signal()
+                merged = self.__send_finish_signal(merges)
+                merges += 1
+                if groups is not None:
+                    self._load_layers = [name for g in merged for name in groups[g][1]]
 
         self._on_train_end()
 
-        return loss / num_iterations
+        capacity = CAPACITY
+        if capacity is None:
+            capacity = datapoints / elapsed if elapsed > 0 else 0
+        return loss / num_iterations, capacity, datapoints
+
+    def __sanity(self) -> Dict[str, Any]:
+        """
+        Runs a single forward and backward pass over the first batch of the train set
+        without saving the model, so the job can check that the function matches the
+        dataset before training. If the job enables the startup probe a second step is
+        taken on the same batch. Errors raised by the user code are returned as a
+        SanityCheckError with the original message
+
+        :return: The loss and the shapes of the inputs, labels and output of the network,
+        along with the number of classes and the largest label for classification networks,
+        and the loss after one step if probed
+        """
+
+        self._on_train_start()
+        self._dataset._load_train_data(start=0, end=1)
+        loader = DataLoader(self._dataset, batch_size=self.batch_size)
+        if len(loader) == 0:
+            raise SanityCheckError("the dataset has no training data")
+
+        # keep the output of the network to report its shape
+        outputs = []
+        hook = self._network.register_forward_hook(lambda module, inputs, output: outputs.append(output))
+
+        try:
+            self.__load_model()
+            batch = self._batch_to_device(next(iter(loader)))
+            loss = self.train(batch, 0)
+
+            # the probe takes a second step on the same batch, so the
+            # loss reflects one update with the learning rate of the job
+            probe_loss = self.train(batch, 1) if self.args.probe else None
+        except RedisError as re:
+            raise StorageError(re)
+        except KubeMLException:
+            raise
+        except Exception as e:
+            raise SanityCheckError(f"{type(e).__name__}: {e}")
+        finally:
+            hook.remove()
+            self._redis_client.close()
+
+        # losses that are not finite can not be encoded in JSON,
+        # so they are reported by name along with their value
+        report = {"loss": None}
+        losses = {"loss": loss}
+        if probe_loss is not None:
+            losses["probe_loss"] = probe_loss
+        for name, value in losses.items():
+            value = float(value)
+            if math.isfinite(value):
+                report[name] = value
+            else:
+                report.setdefault("non_finite", {})[name] = str(value)
+
+        tensors = [batch] if isinstance(batch, torch.Tensor) else list(batch)
+        report["input_shape"] = list(tensors[0].shape)
+        if len(tensors) > 1:
+            labels = tensors[-1]
+            report["label_shape"] = list(labels.shape)
+            if not labels.is_floating_point() and labels.numel() > 0:
+                report["max_label"] = int(labels.max())
+
+        if outputs and isinstance(outputs[-1], torch.Tensor):
+            output = outputs[-1]
+            report["output_shape"] = list(output.shape)
+            if output.dim() == 2:
+                report["classes"] = output.shape[1]
+
+        return report
 
     def _on_validation_start(self):
         """
@@ -317,7 +677,7 @@ class KubeModel(ABC):
         self._set_device()
         self._network.eval()
 
-    def __validate(self):
+    def __validate(self, canary=False):
         """
         Validate sets the device to be used and sets the network in eval mode.
         Then it:
@@ -325,21 +685,43 @@ class KubeModel(ABC):
         - Creates a data loader
         - Feeds the validate function defined by the user with datapoints already sent to the correct device
 
-        :return: A tuple containing the mean accuracy and loss on the val dataset and the number or datapoints
+        If canary is set, only the first canary_size datapoints of the validation set are used,
+        so the same fixed batch is evaluated every epoch
+
+        If the per_class metrics are enabled, the output of the network is compared with the
+        labels of each batch to count the correct predictions and the datapoints of each class
+
+        :return: A tuple containing the mean accuracy and loss on the val dataset, the number or datapoints
+        and the per-class counts keyed as class_correct_<label> and class_total_<label>
         """
 
         self._on_validation_start()
 
         # Determine the batches that we need to validate on and the first
         # subset id that we need to get each iteration
-        assigned_subsets = split_minibatches(range(self._dataset.num_val_docs), self.args._N)[self.args._func_id]
+        if canary:
+            num_subsets = int(math.ceil(self.args.canary_size / STORAGE_SUBSET_SIZE))
+            assigned_subsets = range(min(num_subsets, self._dataset.num_val_docs))
+        else:
+            assigned_subsets = split_minibatches(range(self._dataset.num_val_docs), self.args._N)[self.args._func_id]
 
         # load the validation data
         self._dataset._load_validation_data(start=assigned_subsets.start,
                                             end=assigned_subsets.stop)
+        if canary:
+            self._dataset.data = self._dataset.data[:self.args.canary_size]
+            self._dataset.labels = self._dataset.labels[:self.args.canary_size]
 
         # create the loader that will be used
-        loader = DataLoader(self._dataset, batch_size=self.batch_size)
+        loader = DataLoader(self._dataset, batch_size=self.batch_size * self.devices)
+
+        # keep the output of the network to count the predictions of each class
+        per_class = not canary and PER_CLASS_METRICS in self.args.metrics
+        correct, total = defaultdict(int), defaultdict(int)
+        outputs = []
+        hook = None
+        if per_class:
+            hook = self._network.register_forward_hook(lambda module, inputs, output: outputs.append(output))
 
         acc, loss = 0, 0
         try:
@@ -352,23 +734,112 @@ class KubeModel(ABC):
                     # accumulate statistics
                     acc += _acc
                     loss += _loss
+
+                    if per_class:
+                        self.__count_classes(batch, outputs, correct, total)
+                        outputs.clear()
         except RedisError as re:
             raise StorageError(re)
         finally:
+            if hook is not None:
+                hook.remove()
             self._redis_client.close()
 
-        return acc / len(loader), loss / len(loader), len(self._dataset)
+        classes = {}
+        for label, n in total.items():
+            classes[f"class_correct_{label}"] = float(correct[label])
+            classes[f"class_total_{label}"] = float(n)
+
+        return acc / len(loader), loss / len(loader), len(self._dataset), classes
+
+    @staticmethod
+    def __count_classes(batch, outputs: List[Any], correct: Dict[int, int], total: Dict[int, int]):
+        """
+        Counts the correct predictions and the datapoints of each class in the batch, taking the
+        last output of the network as the prediction. Batches without integer labels or whose
+        output is not one score per class are skipped
+        """
+        if isinstance(batch, torch.Tensor) or len(batch) < 2 or not outputs:
+            return
+
+        labels, output = batch[-1], outputs[-1]
+        if not isinstance(output, torch.Tensor) or output.dim() != 2 or labels.is_floating_point():
+            return
+
+        labels = labels.view(-1)
+        if output.shape[0] != labels.shape[0]:
+            return
+
+        hits = output.argmax(dim=1).eq(labels)
+        for label, hit in zip(labels.tolist(), hits.tolist()):
+            total[label] += 1
+            correct[label] += int(hit)
+
+    def __infer(self) -> Tuple[List[Any], str, Optional[List[Dict[str, Any]]]]:
+        """
+        Runs the inference with the method of the output type requested, which
+        is infer for the predictions, logits for the raw scores of the network
+        and embed for the output of its penultimate layer.
+
+        If the request allows partial results and the batch fails, each datapoint
+        is inferred on its own and the ones that fail are returned with their
+        index and the reason instead of failing the whole request
+
+        :return: the output of each datapoint that succeeded, the output type and
+        the datapoints that failed, None if the request does not allow partial results
+        """
+        if request.mimetype == MSGPACK_MIMETYPE:
+            if msgpack is None:
+                raise UnsupportedMediaTypeError(request.mimetype)
+            data = msgpack.unpackb(request.get_data(), raw=False)
+        else:
+            data = request.json
 
-    def __infer(self) -> Union[torch.Tensor, np.ndarray, List[float]]:
-        data_json = request.json
-        if not data_json:
-            self.logger.error("JSON not found in request")
+        if not data:
+            self.logger.error("Data not found in request")
             raise DataError
 
-        preds = self.infer(self._network, data_json)
+        output_type = data.get("output_type") or OUTPUT_PREDICTION
+        if output_type not in (OUTPUT_PREDICTION, OUTPUT_LOGITS, OUTPUT_EMBEDDING):
+            raise InvalidOutputTypeError(output_type)
 
+        if not data.get("allow_partial"):
+            return self.__infer_output(output_type, data), output_type, None
+
+        try:
+            return self.__infer_output(output_type, data), output_type, []
+        except KubeMLException:
+            raise
+        except Exception as e:
+            self.logger.warning(f"Inference of the batch failed, inferring each datapoint: {e}")
+
+        preds, failed = [], []
+        for i, point in enumerate(data.get("data") or []):
+            try:
+                preds.extend(self.__infer_output(output_type, {**data, "data": [point]}))
+            except KubeMLException:
+                raise
+            except Exception as e:
+                failed.append({"index": i, "reason": f"{type(e).__name__}: {e}"})
+
+        return preds, output_type, failed
+
+    def __infer_output(self, output_type: str, data: Dict[str, Any]) -> List[Any]:
+        """Returns the output of the given type for the datapoints of the request"""
+        if output_type == OUTPUT_PREDICTION:
+            preds = self.infer(self._network, data)
+        elif output_type == OUTPUT_LOGITS:
+            preds = self.logits(data)
+        else:
+            preds = self.embed(data)
+
+        return self.__to_list(preds)
+
+    @staticmethod
+    def __to_list(preds: Union[torch.Tensor, np.ndarray, List[Any]]) -> List[Any]:
+        """Converts the output of an inference to a list, so embeddings are returned as arrays"""
         if isinstance(preds, torch.Tensor):
-            return preds.cpu().numpy().tolist()
+            return preds.detach().cpu().numpy().tolist()
         elif isinstance(preds, np.ndarray):
             return preds.tolist()
         elif isinstance(preds, list):
@@ -378,7 +849,10 @@ class KubeModel(ABC):
 
     def _set_device(self):
         """Set device updates the used gpu or cpu based on the function id and the previously
-        used devices"""
+        used devices. If the job asks for several devices, the network is replicated on as many
+        gpus of the pod as available, and the batches are split among them"""
+
+        self._parallel, self.devices = None, 1
 
         # if the indicated platform is cpu,
         # set that as the torch device
@@ -387,50 +861,170 @@ class KubeModel(ABC):
 
         # if it's gpu, get the appropriate one and put the model there
         else:
-            gpu_id = get_gpu(self.args._func_id)
-            self.device = torch.device(f'cuda:{gpu_id}')
+            gpu_ids = get_gpus(self.args._func_id, max(self.args.devices, 1))
+            self.device = torch.device(f'cuda:{gpu_ids[0]}')
             self._network = self._network.to(self.device)
-            self.logger.debug(f'Set device to {self.device}')
+            if len(gpu_ids) > 1:
+                self._parallel = nn.DataParallel(self._network, device_ids=gpu_ids)
+                self.devices = len(gpu_ids)
+            if self.devices < self.args.devices:
+                self.logger.warning(f'The job asked for {self.args.devices} devices, training on {self.devices}')
+            self.logger.debug(f'Set device to {self.device}, training on gpus {gpu_ids}')
+
+    def __post_result(self, response: flask.Response, code: int):
+        """
+        Posts the response of a function invoked through the queue to the job, which
+        handles it as the response of a synchronous invocation. If the job no longer
+        waits for it, because it timed out, the result is discarded
+        """
+        try:
+            resp = requests.post(self.args.callback, params={"status": code}, data=response.get_data(),
+                                 headers={"Content-Type": response.content_type})
+        except requests.ConnectionError as e:
+            self.logger.error(f"error posting the result to the train job: {e}")
+            raise
+
+        if not resp.ok:
+            self.logger.warning(f"The job did not accept the result. Code:{resp.status_code}. "
+                                f"Msg: {resp.content.decode()}")
+
+    def __layer_groups(self) -> Optional[List[Tuple[int, List[str]]]]:
+        """
+        Groups the layers of the network by their sync cadence, a layer taking the first
+        sync group whose pattern matches its name. The default group comes first, followed
+        by the sync groups in their order
+
+        :return: The K and the layers of each group, None if the job does not set sync groups
+        """
+        if not self.args.sync_groups:
+            return None
 
-    def __send_finish_signal(self):
+        groups = [(self.args.layer_k, [])] + [(k, []) for _, k in self.args.sync_groups]
+        for name in self._network.state_dict():
+            group = 0
+            for i, (pattern, _) in enumerate(self.args.sync_groups):
+                if fnmatchcase(name, pattern):
+                    group = i + 1
+                    break
+            groups[group][1].append(name)
+        return groups
+
+    def __due_layers(self, groups: List[Tuple[int, List[str]]], iteration: int) -> List[str]:
+        """
+        Returns the layers of the groups due in the merge of the iteration, which
+        takes place after (iteration+1)*K local steps, K being the smallest K of the groups
+        """
+        steps = (iteration + 1) * self.args._K
+        return [name for k, layers in groups if k > 0 and steps % k == 0 for name in layers]
+
+    def __send_finish_signal(self, iteration: int) -> List[int]:
         """Sends a request to the train job communicating that the iteration is over
         and the model is published in the database.
 
-        The PS will not respond until all the functions have finished the step
+        The PS will not respond until all the functions have finished the step. If the
+        job already moved past the iteration, the update is discarded and the function
+        stops so that it does not train on a model that is no longer the reference.
+        With the allreduce topology the job may answer with layers for the function
+        to average, in which case it does and reports back before loading the model
+
+        :return: The layer groups merged by the job, empty if it does not sync layer groups
         """
 
         # create the url for the job service
         url = f"http://job-{self.args._job_id}.kubeml/next/{self.args._func_id}"
+        params = {"epoch": self.args.epoch, "iteration": iteration, "token": self.args.token}
+        if self.args.devices > 1:
+            params["devices"] = self.devices
 
         try:
             self.logger.debug(f"Sending request to {url}")
-            resp = requests.post(url)
+            resp = requests.post(url, params=params)
         except requests.ConnectionError as e:
             self.logger.error("error connecting to the train job")
             raise MergeError(e)
 
+        if resp.status_code == 409:
+            self.logger.warning(f"The job discarded the update of iteration {iteration}: {resp.content.decode()}")
+            raise StaleIterationError(resp.content.decode().strip())
+
         if not resp.ok:
             self.logger.error(f"Received non OK message. Code:{resp.status_code}. Msg: {resp.content.decode()}")
             raise MergeError()
 
-    def __load_model(self):
+        if not resp.content:
+            return []
+        body = resp.json()
+        if "layers" in body:
+            self.__all_reduce(iteration, body["layers"], body["funcs"], body["weights"])
+            return []
+        return body.get("groups") or []
+
+    def __all_reduce(self, iteration: int, layers: List[str], funcs: List[int], weights: List[float]):
+        """Averages the layers assigned to the function over the models saved by all the
+        functions of the iteration, weighted like the job would, and saves them as the
+        reference model. The job answers once every function averaged its layers
+
+        :param iteration: The iteration being merged
+        :param layers: The layers the function averages
+        :param funcs: The functions whose models are averaged
+        :param weights: The weight of the model of each of the functions
+        """
+        job_id = self.args._job_id
+        total = sum(weights)
+
+        try:
+            for name in layers:
+                tensors = [self._redis_client.tensorget(f'{job_id}:{name}/{f}') for f in funcs]
+                dtype = tensors[0].dtype
+
+                # int layers, like the batches tracked by the norm layers, are divided
+                # by the number of functions as the job does, the rest are weighted
+                if np.issubdtype(dtype, np.integer):
+                    mean = sum(t.astype(np.int64) for t in tensors) // len(tensors)
+                else:
+                    mean = sum(w * t.astype(np.float64) for t, w in zip(tensors, weights)) / total
+                self._redis_client.tensorset(f'{job_id}:{name}', mean.astype(dtype))
+        except RedisError as re:
+            raise StorageError(re)
+        self.logger.debug(f"Averaged {len(layers)} layers over functions {funcs}")
+
+        url = f"http://job-{job_id}.kubeml/reduced/{self.args._func_id}"
+        params = {"epoch": self.args.epoch, "iteration": iteration}
+        try:
+            resp = requests.post(url, params=params)
+        except requests.ConnectionError as e:
+            self.logger.error("error connecting to the train job")
+            raise MergeError(e)
+
+        if resp.status_code == 409:
+            self.logger.warning(f"The job discarded the average of iteration {iteration}: {resp.content.decode()}")
+            raise StaleIterationError(resp.content.decode().strip())
+
+        if not resp.ok:
+            self.logger.error(f"Received non OK message. Code:{resp.status_code}. Msg: {resp.content.decode()}")
+            raise MergeError()
+
+    def __load_model(self, layers: Optional[List[str]] = None):
         """
         Loads the model from redis ai and applies it to the network
+
+        :param layers: The layers to load, None for the whole model
         """
-        state_dict = self.__get_model_dict()
-        self._network.load_state_dict(state_dict)
+        state_dict = self.__get_model_dict(layers)
+        self._network.load_state_dict(state_dict, strict=layers is None)
         self.logger.debug("Loaded state dict from redis")
 
-    def __get_model_dict(self) -> Dict[str, torch.Tensor]:
+    def __get_model_dict(self, layers: Optional[List[str]] = None) -> Dict[str, torch.Tensor]:
         """
         Fetches the model weights from the tensor storage
 
+        :param layers: The layers to fetch, None for the whole model
         :return: The state dict of the reference model
         """
         job_id = self.args._job_id
 
         state = dict()
-        for name in self._network.state_dict():
+        for name in layers if layers is not None else self._network.state_dict():
             # load each of the layers in the statedict
             weight_key = f'{job_id}:{name}'
             w = self._redis_client.tensorget(weight_key)
@@ -441,9 +1035,11 @@ class KubeModel(ABC):
 
         return state
 
-    def __save_model(self):
+    def __save_model(self, layers: Optional[List[str]] = None):
         """
         Saves the model to the tensor storage
+
+        :param layers: The layers to save, None for the whole model
         """
         job_id = self.args._job_id
         task = self.args._task
@@ -451,7 +1047,9 @@ class KubeModel(ABC):
 
         self.logger.debug("Saving model to the database")
         with torch.no_grad():
-            for name, layer in self._network.state_dict().items():
+            state = self._network.state_dict()
+            for name in layers if layers is not None else state:
+                layer = state[name]
                 # Save the weights
                 weight_key = f'{job_id}:{name}' \
                     if task == 'init' \
@@ -474,3 +1072,15 @@ class KubeModel(ABC):
 
     def infer(self, data: List[Any]) -> Union[torch.Tensor, np.ndarray, List[float]]:
         pass
+
+    def logits(self, data: List[Any]) -> Union[torch.Tensor, np.ndarray, List[List[float]]]:
+        """Returns the raw scores of the network for each datapoint, before any activation"""
+        raise UnsupportedOutputError(OUTPUT_LOGITS)
+
+    def embed(self, data: List[Any]) -> Union[torch.Tensor, np.ndarray, List[List[float]]]:
+        """Returns the output of the penultimate layer of the network for each datapoint"""
+        raise UnsupportedOutputError(OUTPUT_EMBEDDING)
+
+    def export_sample(self) -> Union[torch.Tensor, Tuple[torch.Tensor, ...]]:
+        """Returns a sample input of the network used to trace it when exporting the model"""
+        raise UnsupportedOperationError("export")
@@ -34,6 +34,14 @@ def get_gpu(func_id: int) -> int:
     return gpu_id
 
 
+def get_gpus(func_id: int, n: int) -> List[int]:
+    """Returns the gpus a function training on n devices uses, starting from the one given
+    by get_gpu and taking the following ones. If the pod has fewer than n gpus, all of them are used"""
+    gpu_count = torch.cuda.device_count()
+    first = get_gpu(func_id)
+    return [(first + i) % gpu_count for i in range(min(n, gpu_count))]
+
+
 def is_optimizable(layer: nn.Module) -> bool:
     """Should save layer returns just whether the layer is optimizable or not
     and thus if it should be sent to the parameter server"""
@@ -56,6 +64,23 @@ def split_minibatches(a: range, n: int) -> List[range]:
     return [a[i * k + min(i, m):(i + 1) * k + min(i + 1, m)] for i in range(n)]
 
 
+def split_by_shares(a: range, shares: List[float]) -> List[range]:
+    """
+    Divides the minibatches across the functions proportionally to their
+    shares, so the functions with more capacity get more minibatches
+
+    :arg a range with the list of minibatches
+    :arg shares fraction of the minibatches of each function, indexed by the funcId
+    :return: list with all the ranges, indexed by the funcId
+    """
+    total = sum(shares)
+    bounds, acc = [0], 0.0
+    for share in shares:
+        acc += share
+        bounds.append(int(round(len(a) * acc / total)))
+    return [a[bounds[i]:bounds[i + 1]] for i in range(len(shares))]
+
+
 def get_subset_period(K: int, batch_size: int, assigned_subsets: range) -> int:
     """
     Calculates the number of subsets that will be evaluated per iteration
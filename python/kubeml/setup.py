@@ -20,7 +20,8 @@ setup(
         'torch>=1.7',
         'redisai>=1.0.1',
         'pymongo>=3.11.1',
-        'flask>=1.1.2'
+        'flask>=1.1.2',
+        'msgpack>=1.0.0'
     ],
     license="MIT",
 )
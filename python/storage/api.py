@@ -1,11 +1,13 @@
+import hashlib
 import logging
 import os
 import pickle
 import uuid
 
+import gridfs
 import numpy as np
 import pymongo
-from flask import Flask, request, jsonify
+from flask import Flask, Response, request, jsonify
 from utils import *
 
 app = Flask(__name__)
@@ -26,12 +28,35 @@ else:
 
 app.config['UPLOAD_FOLDER'] = 'uploads'
 
+# size of the documents the datasets are split into and
+# the admin databases that are not datasets
+SHARD_SIZE = 64
+SPLITS = ['train', 'test']
+KUBEML_DATABASE = os.environ.get('MONGO_DATABASE') or 'kubeml'
+DEFAULT_DATABASES = {'admin', 'config', KUBEML_DATABASE, 'local'}
+
+# the models exported by the functions are kept in a GridFS
+# bucket of the kubeml database, read in chunks of this size
+ARTIFACTS_BUCKET = 'artifacts'
+ARTIFACT_CHUNK_SIZE = 1 << 20
+CHECKSUM_HEADER = 'X-Kubeml-Checksum'
+
+# the class distribution of the train set and the class weights
+# set for a dataset are kept in this document of its metadata collection
+METADATA_COLLECTION = 'metadata'
+CLASSES_DOCUMENT = 'classes'
+
+# the range and dtype of the train features, which the inference
+# inputs are checked against, are kept in this document
+FEATURES_DOCUMENT = 'features'
+
 # set some basic logging params
 FORMAT = '[%(asctime)s] %(levelname)-8s %(message)s'
 logging.basicConfig(level=logging.DEBUG, format=FORMAT)
 
 # mongo connection
 client = pymongo.MongoClient(app.config['MONGO_ADDRESS'], app.config['MONGO_PORT'])
+artifacts = gridfs.GridFS(client[KUBEML_DATABASE], collection=ARTIFACTS_BUCKET)
 
 
 @app.route('/health')
@@ -51,6 +76,161 @@ def handle_dataset(name: str):
         return delete_dataset(name)
 
 
+@app.route('/dataset', methods=['GET'])
+def list_datasets():
+    summaries = [_dataset_summary(name) for name in _dataset_names()]
+    return jsonify(summaries), 200
+
+
+@app.route('/dataset/<string:name>', methods=['GET'])
+def get_dataset(name: str):
+    if name not in _dataset_names():
+        return jsonify(error=f'Dataset {name} does not exist'), 404
+    return jsonify(_dataset_summary(name)), 200
+
+
+@app.route('/dataset/<string:name>/stats', methods=['GET'])
+def get_dataset_stats(name: str):
+    if name not in _dataset_names():
+        return jsonify(error=f'Dataset {name} does not exist'), 404
+
+    db = client[name]
+    stats = _dataset_summary(name)
+    stats['shard_size'] = SHARD_SIZE
+    stats['train_shards'] = db['train'].estimated_document_count()
+    stats['test_shards'] = db['test'].estimated_document_count()
+
+    classes = _dataset_classes(name)
+    if classes.get('counts'):
+        stats['class_counts'] = classes['counts']
+    if classes.get('weights'):
+        stats['class_weights'] = classes['weights']
+
+    features = _dataset_features(name)
+    if features:
+        stats['feature_min'] = features['min']
+        stats['feature_max'] = features['max']
+        stats['feature_dtype'] = features['dtype']
+    return jsonify(stats), 200
+
+
+# Sets the weights of the classes of a dataset, one finite non-negative weight for
+# each class. Deleting them makes the jobs compute them from the class distribution
+@app.route('/dataset/<string:name>/weights', methods=['PUT', 'DELETE'])
+def handle_class_weights(name: str):
+    if name not in _dataset_names():
+        return jsonify(error=f'Dataset {name} does not exist'), 404
+
+    classes = _dataset_classes(name)
+    col = client[name][METADATA_COLLECTION]
+    if request.method == 'DELETE':
+        col.update_one({'_id': CLASSES_DOCUMENT}, {'$unset': {'weights': ''}})
+        return jsonify(result='Class weights deleted'), 200
+
+    body = request.get_json(silent=True) or {}
+    try:
+        weights = [float(w) for w in body['weights']]
+    except (KeyError, TypeError, ValueError):
+        return jsonify(error='weights must be a list of numbers'), 400
+
+    counts = classes.get('counts')
+    if not counts:
+        return jsonify(error=f'The labels of dataset {name} are not class indices'), 400
+    if len(weights) != len(counts):
+        return jsonify(error=f'Got {len(weights)} class weights but the dataset has {len(counts)} classes'), 400
+    if not all(np.isfinite(w) and w >= 0 for w in weights) or sum(weights) == 0:
+        return jsonify(error='Class weights must be finite and not negative, with at least one positive'), 400
+
+    col.update_one({'_id': CLASSES_DOCUMENT}, {'$set': {'weights': weights}}, upsert=True)
+    return jsonify(result='Class weights set'), 200
+
+
+# Lists the ids of the shards (documents) that form a split of the dataset
+@app.route('/dataset/<string:name>/<string:split>/shards', methods=['GET'])
+def list_shards(name: str, split: str):
+    if name not in _dataset_names() or split not in SPLITS:
+        return jsonify(error=f'Dataset split {name}/{split} does not exist'), 404
+
+    ids = [doc['_id'] for doc in client[name][split].find({}, {'_id': 1}).sort('_id')]
+    return jsonify(ids), 200
+
+
+# Returns the shards in the range [start, end) of a split of the dataset,
+# with the pickled data and labels encoded in base64
+@app.route('/dataset/<string:name>/<string:split>/shards/range', methods=['GET'])
+def read_shards(name: str, split: str):
+    if name not in _dataset_names() or split not in SPLITS:
+        return jsonify(error=f'Dataset split {name}/{split} does not exist'), 404
+
+    try:
+        start = int(request.args.get('start', 0))
+        end = int(request.args['end'])
+    except (KeyError, ValueError):
+        return jsonify(error='start and end must be valid integers'), 400
+
+    if start < 0 or end <= start:
+        return jsonify(error=f'Invalid shard range [{start}, {end})'), 400
+
+    docs = client[name][split].find({'_id': {'$gte': start, '$lt': end}}).sort('_id')
+    return jsonify([encode_shard(doc) for doc in docs]), 200
+
+
+# Saves the model of a job exported by its function. The artifact is checked against
+# the checksum sent by the function before the previous versions are replaced
+@app.route('/artifacts/<string:job_id>/<string:fmt>', methods=['PUT'])
+def put_artifact(job_id: str, fmt: str):
+    data = request.get_data()
+    checksum = hashlib.sha256(data).hexdigest()
+    expected = request.headers.get(CHECKSUM_HEADER)
+    if expected and expected != checksum:
+        return jsonify(error=f'Artifact checksum {checksum} does not match {expected}'), 400
+
+    filename = _artifact_name(job_id, fmt)
+    file_id = artifacts.put(data, filename=filename, metadata={'sha256': checksum})
+    for old in artifacts.find({'filename': filename, '_id': {'$ne': file_id}}):
+        artifacts.delete(old._id)
+
+    logging.debug(f'Saved artifact {filename}, {len(data)} bytes')
+    return jsonify(size=len(data), sha256=checksum), 200
+
+
+# Streams the model of a job exported by its function, with its checksum in the headers
+@app.route('/artifacts/<string:job_id>/<string:fmt>', methods=['GET'])
+def get_artifact(job_id: str, fmt: str):
+    filename = _artifact_name(job_id, fmt)
+    try:
+        artifact = artifacts.get_last_version(filename)
+    except gridfs.NoFile:
+        return jsonify(error=f'No {fmt} artifact for job {job_id}'), 404
+
+    def chunks():
+        while True:
+            chunk = artifact.read(ARTIFACT_CHUNK_SIZE)
+            if not chunk:
+                break
+            yield chunk
+
+    headers = {
+        'Content-Length': str(artifact.length),
+        'Content-Disposition': f'attachment; filename={filename}',
+        CHECKSUM_HEADER: artifact.metadata['sha256'],
+    }
+    return Response(chunks(), mimetype='application/octet-stream', headers=headers)
+
+
+# Deletes every version of the model of a job exported to a format
+@app.route('/artifacts/<string:job_id>/<string:fmt>', methods=['DELETE'])
+def delete_artifact(job_id: str, fmt: str):
+    filename = _artifact_name(job_id, fmt)
+    deleted = 0
+    for artifact in artifacts.find({'filename': filename}):
+        artifacts.delete(artifact._id)
+        deleted += 1
+    if deleted == 0:
+        return jsonify(error=f'No {fmt} artifact for job {job_id}'), 404
+    return jsonify(result=f'Deleted {deleted} versions of {filename}'), 200
+
+
 # Handles the upload of a dataset
 # Sees if the file has an npy or pkl extension
 # and according to that it divides the dataset in batches
@@ -132,9 +312,14 @@ def _process_datasets(dataset_name: str, extension: str, upload_id: str):
         db = client[dataset_name]
         db.create_collection(datatype)
 
-        splits = dataset_splits(data, targets, 64)
+        splits = dataset_splits(data, targets, SHARD_SIZE)
         save_batches(db[datatype], splits)
 
+        # keep the class distribution of the train set for the class weights
+        if datatype == 'train':
+            db[METADATA_COLLECTION].insert_one({'_id': CLASSES_DOCUMENT, 'counts': class_counts(targets)})
+            db[METADATA_COLLECTION].insert_one({'_id': FEATURES_DOCUMENT, 'stats': feature_stats([data])})
+
         # delete the documents from the server
         os.remove(x_path)
         os.remove(y_path)
@@ -142,6 +327,54 @@ def _process_datasets(dataset_name: str, extension: str, upload_id: str):
     return jsonify(result='Dataset created'), 200
 
 
+def _artifact_name(job_id: str, fmt: str):
+    return f'{job_id}.{fmt}'
+
+
+def _dataset_names():
+    return set(client.list_database_names()) - DEFAULT_DATABASES
+
+
+def _dataset_summary(name: str):
+    db = client[name]
+    return {
+        'name': name,
+        'train_set_size': db['train'].estimated_document_count() * SHARD_SIZE,
+        'test_set_size': db['test'].estimated_document_count() * SHARD_SIZE,
+    }
+
+
+def _dataset_classes(name: str):
+    """Returns the classes document of a dataset. The class distribution of the
+    datasets uploaded before it was kept is computed from the train shards"""
+    col = client[name][METADATA_COLLECTION]
+    doc = col.find_one({'_id': CLASSES_DOCUMENT})
+    if doc is not None and 'counts' in doc:
+        return doc
+
+    logging.debug(f'Computing the class distribution of dataset {name}')
+    labels = [pickle.loads(shard['labels']) for shard in client[name]['train'].find({}, {'labels': 1}).sort('_id')]
+    counts = class_counts(np.concatenate(labels)) if labels else None
+    col.update_one({'_id': CLASSES_DOCUMENT}, {'$set': {'counts': counts}}, upsert=True)
+    return col.find_one({'_id': CLASSES_DOCUMENT})
+
+
+def _dataset_features(name: str):
+    """Returns the range and dtype of the train features of a dataset, None if they
+    are not numeric. The ones of the datasets uploaded before they were kept are
+    computed from the train shards"""
+    col = client[name][METADATA_COLLECTION]
+    doc = col.find_one({'_id': FEATURES_DOCUMENT})
+    if doc is not None and 'stats' in doc:
+        return doc['stats']
+
+    logging.debug(f'Computing the feature stats of dataset {name}')
+    shards = client[name]['train'].find({}, {'data': 1})
+    stats = feature_stats(pickle.loads(shard['data']) for shard in shards)
+    col.update_one({'_id': FEATURES_DOCUMENT}, {'$set': {'stats': stats}}, upsert=True)
+    return stats
+
+
 def delete_dataset(dataset_name: str):
     # Simply check that the dataset exists, and if so, delete it
     db_names = set(client.list_database_names())
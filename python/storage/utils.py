@@ -1,5 +1,8 @@
+import base64
 import pickle
 import logging
+
+import numpy as np
 from pymongo import collection
 
 
@@ -23,3 +26,42 @@ def save_batches(col: collection.Collection, batches):
         for i, (data, labels) in enumerate(batches)
     ]).inserted_ids
     logging.debug(f'Inserted {len(ids)} documents')
+
+
+def encode_shard(doc):
+    """Encodes a shard document so it can be returned
+    as json, the pickled data and labels are base64 encoded"""
+    return {
+        'id': doc['_id'],
+        'data': base64.b64encode(doc['data']).decode(),
+        'labels': base64.b64encode(doc['labels']).decode(),
+    }
+
+
+def class_counts(labels):
+    """Returns the number of datapoints of each class if the
+    labels are class indices, None otherwise"""
+    labels = np.asarray(labels)
+    if labels.ndim != 1 or labels.size == 0 or not np.issubdtype(labels.dtype, np.integer):
+        return None
+    if labels.min() < 0:
+        return None
+    return np.bincount(labels).tolist()
+
+
+def feature_stats(arrays):
+    """Returns the range and the dtype of the values of the features
+    split in the arrays if they are numeric, None otherwise"""
+    low, high, dtype = None, None, None
+    for data in arrays:
+        data = np.asarray(data)
+        if data.size == 0:
+            continue
+        if not np.issubdtype(data.dtype, np.number) or np.issubdtype(data.dtype, np.complexfloating):
+            return None
+        low = float(np.nanmin(data)) if low is None else min(low, float(np.nanmin(data)))
+        high = float(np.nanmax(data)) if high is None else max(high, float(np.nanmax(data)))
+        dtype = data.dtype if dtype is None else np.promote_types(dtype, data.dtype)
+    if dtype is None:
+        return None
+    return {'min': low, 'max': high, 'dtype': str(dtype)}